@@ -9,6 +9,7 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
+	_ "time/tzdata" // timezone database for hosts without one (TIMEZONE)
 
 	"go-acs/internal/config"
 	"go-acs/internal/database"
@@ -19,6 +20,8 @@ import (
 	"go-acs/internal/notification/fcm"
 	"go-acs/internal/notification/telegram"
 	"go-acs/internal/notification/whatsapp"
+	"go-acs/internal/payment"
+	"go-acs/internal/payment/midtrans"
 	"go-acs/internal/payment/tripay"
 	"go-acs/internal/scheduler"
 	"go-acs/internal/tr069"
@@ -36,7 +39,13 @@ func main() {
 	cfg := config.Load()
 
 	// Initialize database
-	db, err := database.InitDB(cfg.DatabaseURL)
+	db, err := database.InitDB(cfg.DatabaseURL, database.Options{
+		Driver:          cfg.DatabaseDriver,
+		MaxOpenConns:    cfg.DBMaxOpenConns,
+		MaxIdleConns:    cfg.DBMaxIdleConns,
+		BusyTimeoutMs:   cfg.DBBusyTimeoutMs,
+		MaxPendingTasks: cfg.MaxPendingTasks,
+	})
 	if err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
@@ -44,14 +53,27 @@ func main() {
 
 	log.Println("✓ Database initialized successfully")
 
+	// Timezone goes first, before any goroutine computes dates
+	if v, err := db.GetSetting("timezone"); err == nil && v != "" {
+		cfg.Timezone = v
+	}
+	if err := cfg.LoadTimezone(); err != nil {
+		log.Printf("Warning: %v, using the server's local time", err)
+	} else if cfg.Timezone != "" {
+		log.Printf("✓ Timezone set to %s", cfg.Timezone)
+	}
+
 	// Initialize WebSocket hub
 	wsHub := websocket.NewHub()
+	wsHub.ResolveAlias = func(deviceID int64) string {
+		return db.DeviceAlias(deviceID, cfg.DeviceAliasFormat)
+	}
 	go wsHub.Run()
 
 	log.Println("✓ WebSocket hub started")
 
 	// Initialize TR-069 server
-	tr069Server := tr069.NewServer(cfg.TR069Port, db, wsHub)
+	tr069Server := tr069.NewServer(cfg.TR069Port, db, wsHub, cfg)
 	go tr069Server.Start()
 
 	log.Printf("✓ TR-069 server started on port %d", cfg.TR069Port)
@@ -65,6 +87,7 @@ func main() {
 		From:     "noreply@go-acs.local",
 	}
 	mailService := mailer.New(mailConfig)
+	mailService.TestMode = func() bool { return cfg.TestMode }
 
 	// Load settings from database
 	settings, err := db.GetSettings()
@@ -86,13 +109,166 @@ func main() {
 		if v, ok := settings["tripay_api_key"]; ok && v != "" {
 			cfg.TripayAPIKey = v
 		}
+		if v, ok := settings["payment_gateway"]; ok && v != "" {
+			cfg.PaymentGateway = v
+		}
+		if v, ok := settings["midtrans_server_key"]; ok && v != "" {
+			cfg.MidtransServerKey = v
+		}
+		if v, ok := settings["midtrans_mode"]; ok && v != "" {
+			cfg.MidtransMode = v
+		}
+		if v, ok := settings["tr069_username"]; ok && v != "" {
+			cfg.TR069Username = v
+		}
+		if v, ok := settings["tr069_password"]; ok && v != "" {
+			cfg.TR069Password = v
+		}
+		if v, ok := settings["device_registration"]; ok && v != "" {
+			cfg.DeviceRegistration = v
+		}
+		if v, ok := settings["device_alias_format"]; ok && v != "" {
+			cfg.DeviceAliasFormat = v
+		}
+		if v, ok := settings["auto_assign_pppoe"]; ok && v != "" {
+			cfg.AutoAssignPPPoE = v == "true" || v == "1"
+		}
+		if v, ok := settings["optical_alert_enabled"]; ok && v != "" {
+			cfg.OpticalAlertEnabled = v == "true" || v == "1"
+		}
+		if v, ok := settings["device_label_template"]; ok {
+			cfg.DeviceLabelTemplate = v
+		}
+		if v, ok := settings["conn_req_scheme"]; ok {
+			cfg.ConnReqScheme = v
+		}
+		if v, ok := settings["conn_req_port"]; ok && v != "" {
+			if port, err := strconv.Atoi(v); err == nil {
+				cfg.ConnReqPort = port
+			}
+		}
+		if v, ok := settings["conn_req_tls_verify"]; ok && v != "" {
+			cfg.ConnReqTLSVerify = v == "true" || v == "1"
+		}
+		if v, ok := settings["public_url"]; ok {
+			cfg.PublicURL = v
+		}
+		if v, ok := settings["trusted_proxies"]; ok {
+			cfg.TrustedProxies = v
+		}
+		if v, ok := settings["max_pending_tasks"]; ok && v != "" {
+			if n, err := strconv.Atoi(v); err == nil {
+				cfg.MaxPendingTasks = n
+				db.SetMaxPendingTasks(n)
+			}
+		}
+		if v, ok := settings["test_mode"]; ok && v != "" {
+			cfg.TestMode = v == "true" || v == "1"
+		}
+		if v, ok := settings["send_receipt"]; ok && v != "" {
+			cfg.SendReceipt = v == "true" || v == "1"
+		}
+		if v, ok := settings["proration_enabled"]; ok && v != "" {
+			cfg.ProrationEnabled = v == "true" || v == "1"
+		}
+		if v, ok := settings["max_task_retries"]; ok && v != "" {
+			if n, err := strconv.Atoi(v); err == nil {
+				cfg.MaxTaskRetries = n
+			}
+		}
+		for key, field := range map[string]*float64{
+			"rx_excellent_dbm":    &cfg.RXExcellentDBm,
+			"rx_good_dbm":         &cfg.RXGoodDBm,
+			"rx_warning_dbm":      &cfg.RXWarningDBm,
+			"rx_overload_dbm":     &cfg.RXOverloadDBm,
+			"rx_alert_hysteresis": &cfg.RXAlertHysteresisDB,
+			"wifi_client_alert":   &cfg.WiFiClientAlertPercent,
+		} {
+			if v, ok := settings[key]; ok && v != "" {
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					*field = f
+				}
+			}
+		}
+		if v, ok := settings["optical_alert_customer_template"]; ok {
+			cfg.OpticalAlertCustomerMsg = v
+		}
+		if v, ok := settings["optical_alert_operator_template"]; ok {
+			cfg.OpticalAlertOperatorMsg = v
+		}
+		if v, ok := settings["default_package_id"]; ok && v != "" {
+			if id, err := strconv.ParseInt(v, 10, 64); err == nil {
+				cfg.DefaultPackageID = id
+			}
+		}
+		if v, ok := settings["currency_symbol"]; ok {
+			cfg.CurrencySymbol = v
+		}
+		if v, ok := settings["currency_decimals"]; ok && v != "" {
+			if n, err := strconv.Atoi(v); err == nil {
+				cfg.CurrencyDecimals = n
+			}
+		}
+		if v, ok := settings["amount_rounding"]; ok && v != "" {
+			if step, err := strconv.ParseFloat(v, 64); err == nil {
+				cfg.AmountRounding = step
+			}
+		}
+		if v, ok := settings["tax_percent"]; ok && v != "" {
+			if pct, err := strconv.ParseFloat(v, 64); err == nil {
+				cfg.TaxPercent = pct
+			}
+		}
+		if v, ok := settings["online_payment_min_amount"]; ok && v != "" {
+			if amount, err := strconv.ParseFloat(v, 64); err == nil {
+				cfg.OnlinePaymentMinAmount = amount
+			}
+		}
+		if v, ok := settings["ticket_auto_assign"]; ok && v != "" {
+			cfg.TicketAutoAssign = v
+		}
+		if v, ok := settings["ticket_auto_close_days"]; ok && v != "" {
+			if n, err := strconv.Atoi(v); err == nil {
+				cfg.TicketAutoCloseDays = n
+			}
+		}
+		if v, ok := settings["hold_max_days"]; ok && v != "" {
+			if n, err := strconv.Atoi(v); err == nil {
+				cfg.HoldMaxDays = n
+			}
+		}
+		if v, ok := settings["invoice_reminder_days"]; ok {
+			cfg.InvoiceReminderDays = v
+		}
+		if v, ok := settings["carry_forward_max_invoices"]; ok && v != "" {
+			if n, err := strconv.Atoi(v); err == nil {
+				cfg.CarryForwardMaxInvoices = n
+			}
+		}
+		if v, ok := settings["carry_forward_max_amount"]; ok && v != "" {
+			if amount, err := strconv.ParseFloat(v, 64); err == nil {
+				cfg.CarryForwardMaxAmount = amount
+			}
+		}
 	}
 
 	// Initialize MikroTik Client
 	mikrotikClient := mikrotik.New(cfg)
 
-	// Initialize Payment Gateway (Tripay)
-	tripayGateway := tripay.New(cfg)
+	// Initialize Payment Gateway (Tripay or Midtrans)
+	var paymentGateway payment.Gateway
+	switch cfg.PaymentGateway {
+	case "midtrans":
+		// Notification signatures are keyed on the server key; without it
+		// anyone could forge a settlement
+		if cfg.MidtransServerKey == "" {
+			log.Fatal("PAYMENT_GATEWAY is midtrans but MIDTRANS_SERVER_KEY is not set")
+		}
+		paymentGateway = midtrans.New(cfg)
+	default:
+		paymentGateway = tripay.New(cfg)
+	}
+	log.Printf("Payment gateway: %s", cfg.PaymentGateway)
 
 	// Initialize WhatsApp Client
 	waClient := whatsapp.New(cfg)
@@ -102,9 +278,18 @@ func main() {
 
 	// Initialize Telegram Client
 	telegramClient := telegram.New(cfg.TelegramToken, cfg.TelegramChatID)
+	telegramClient.TestMode = func() bool { return cfg.TestMode }
+
+	if cfg.TestMode {
+		log.Println("⚠️  TEST MODE: MikroTik, payment and notification calls are logged, not performed")
+	}
 
 	// Initialize HTTP handlers
-	h := handlers.NewHandler(db, wsHub, mailService, mikrotikClient, tripayGateway, waClient, fcmClient, telegramClient, cfg)
+	h := handlers.NewHandler(db, wsHub, mailService, mikrotikClient, paymentGateway, waClient, fcmClient, telegramClient, cfg, tr069Server)
+	db.OnStatusChange = h.NotifyDeviceStatus
+	tr069Server.OnOpticalAlertChange = h.NotifyOpticalAlert
+	tr069Server.OnClientLimitChange = h.NotifyClientLimit
+	tr069Server.OnWatchedParameterChange = h.NotifyParameterWatch
 
 	// Initialize Scheduler
 	sched := scheduler.New(h)
@@ -201,172 +386,293 @@ func setupRouter(h *handlers.Handler, wsHub *websocket.Hub) *mux.Router {
 	// Customer Portal Authentication
 	api.HandleFunc("/portal/auth/login", h.CustomerLogin).Methods("POST")
 	api.HandleFunc("/portal/auth/logout", h.CustomerLogout).Methods("POST")
-
-	// Customer Portal API
-	api.HandleFunc("/portal/dashboard", h.GetPortalDashboard).Methods("GET")
-	api.HandleFunc("/portal/invoices", h.GetPortalInvoices).Methods("GET")
-	api.HandleFunc("/portal/wifi/ssid", h.UpdatePortalWiFiSSID).Methods("PUT")
-	api.HandleFunc("/portal/wifi/password", h.UpdatePortalWiFiPassword).Methods("PUT")
-	api.HandleFunc("/portal/tickets", h.CreatePortalTicket).Methods("POST")
+	api.HandleFunc("/portal/forgot-password", h.ForgotPortalPassword).Methods("POST")
+	api.HandleFunc("/portal/reset-password", h.ResetPortalPasswordWithCode).Methods("POST")
+
+	// Customer Portal API. Customers sign in with their own token and only
+	// ever see or change their own account; staff tokens are not accepted.
+	portal := api.PathPrefix("/portal").Subrouter()
+	portal.Use(middleware.CustomerAuthMiddleware(h.Config.JWTSecret))
+	portal.HandleFunc("/dashboard", h.GetPortalDashboard).Methods("GET")
+	portal.HandleFunc("/invoices", h.GetPortalInvoices).Methods("GET")
+	portal.HandleFunc("/wifi", h.GetCustomerWiFi).Methods("GET")
+	portal.HandleFunc("/wifi", h.UpdateCustomerWiFi).Methods("PUT")
+	portal.HandleFunc("/wifi/ssid", h.UpdatePortalWiFiSSID).Methods("PUT")
+	portal.HandleFunc("/wifi/password", h.UpdatePortalWiFiPassword).Methods("PUT")
+	portal.HandleFunc("/tickets", h.GetPortalTickets).Methods("GET")
+	portal.HandleFunc("/tickets", h.CreatePortalTicket).Methods("POST")
+	portal.HandleFunc("/tickets/{id}/rate", h.RatePortalTicket).Methods("POST")
+
+	// Admin API. Viewers are read-only; operators may also change devices,
+	// customers and billing; system settings, updates and the audit trail
+	// are admin only. Every state-changing request goes to the audit log.
+	manage := api.NewRoute().Subrouter()
+	manage.Use(h.AuditMiddleware)
+	manage.Use(middleware.RequireRoleForWrites(middleware.RoleAdmin, middleware.RoleOperator))
+	manage.Use(h.RequireApprovedDevice)
+	system := manage.NewRoute().Subrouter()
+	system.Use(middleware.RequireRole(middleware.RoleAdmin))
 
 	// Dashboard
-	api.HandleFunc("/dashboard/stats", h.GetDashboardStats).Methods("GET")
+	manage.HandleFunc("/dashboard/stats", h.GetDashboardStats).Methods("GET")
+	manage.HandleFunc("/search", h.Search).Methods("GET")
 
 	// Device/ONU management
-	api.HandleFunc("/devices", h.GetDevices).Methods("GET")
-	api.HandleFunc("/devices", h.CreateDevice).Methods("POST")
-	api.HandleFunc("/devices/{id}", h.GetDevice).Methods("GET")
-	api.HandleFunc("/devices/{id}", h.UpdateDevice).Methods("PUT")
-	api.HandleFunc("/devices/{id}", h.DeleteDevice).Methods("DELETE")
-	api.HandleFunc("/devices/{id}/status", h.GetDeviceStatus).Methods("GET")
-	api.HandleFunc("/devices/{id}/logs", h.GetDeviceLogs).Methods("GET")
-	api.HandleFunc("/devices/{id}/status-logs", h.GetDeviceStatusLogs).Methods("GET")
-	api.HandleFunc("/devices/{id}/pon", h.GetDevicePON).Methods("GET")
-	api.HandleFunc("/devices/{id}/clients", h.GetDeviceClients).Methods("GET")
-	api.HandleFunc("/devices/{id}/reboot", h.RebootDevice).Methods("POST")
-	api.HandleFunc("/devices/{id}/factory-reset", h.FactoryResetDevice).Methods("POST")
-	api.HandleFunc("/devices/{id}/refresh", h.RefreshDevice).Methods("POST")
-	api.HandleFunc("/devices/{id}/parameters", h.GetDeviceParameters).Methods("GET")
+	manage.HandleFunc("/devices", h.GetDevices).Methods("GET")
+	manage.HandleFunc("/devices", h.CreateDevice).Methods("POST")
+	manage.HandleFunc("/devices/{id}", h.GetDevice).Methods("GET")
+	manage.HandleFunc("/devices/{id}", h.UpdateDevice).Methods("PUT")
+	manage.HandleFunc("/devices/{id}", h.DeleteDevice).Methods("DELETE")
+	manage.HandleFunc("/devices/{id}/status", h.GetDeviceStatus).Methods("GET")
+	manage.HandleFunc("/devices/{id}/logs", h.GetDeviceLogs).Methods("GET")
+	manage.HandleFunc("/devices/{id}/status-logs", h.GetDeviceStatusLogs).Methods("GET")
+	manage.HandleFunc("/devices/{id}/reboots", h.GetDeviceReboots).Methods("GET")
+	manage.HandleFunc("/devices/{id}/informs", h.GetDeviceInformEvents).Methods("GET")
+	manage.HandleFunc("/devices/{id}/commands", h.GetDeviceCommands).Methods("GET")
+	manage.HandleFunc("/devices/{id}/management", h.SetDeviceManagement).Methods("PUT")
+	manage.HandleFunc("/devices/{id}/pon", h.GetDevicePON).Methods("GET")
+	manage.HandleFunc("/devices/{id}/optical-history", h.GetDeviceOpticalHistory).Methods("GET")
+	manage.HandleFunc("/devices/{id}/clients", h.GetDeviceClients).Methods("GET")
+	manage.HandleFunc("/devices/{id}/clients/history", h.GetDeviceClientHistory).Methods("GET")
+	manage.HandleFunc("/devices/{id}/reboot", h.RebootDevice).Methods("POST")
+	manage.HandleFunc("/devices/{id}/reboot-schedule", h.GetRebootSchedules).Methods("GET")
+	manage.HandleFunc("/devices/{id}/reboot-schedule", h.CreateRebootSchedule).Methods("POST")
+	manage.HandleFunc("/devices/{id}/identify", h.IdentifyDevice).Methods("POST")
+	manage.HandleFunc("/devices/{id}/factory-reset", h.FactoryResetDevice).Methods("POST")
+	manage.HandleFunc("/devices/{id}/refresh", h.RefreshDevice).Methods("POST")
+	manage.HandleFunc("/devices/{targetId}/clone-from/{sourceId}", h.CloneDeviceConfig).Methods("POST")
+	manage.HandleFunc("/devices/{oldId}/replace", h.ReplaceDevice).Methods("POST")
+	manage.HandleFunc("/devices/{id}/quick-fix", h.QuickFixDevice).Methods("POST")
+	manage.HandleFunc("/devices/{id}/parameters", h.GetDeviceParameters).Methods("GET")
+	manage.HandleFunc("/devices/{id}/inform-auth", h.SetDeviceInformAuth).Methods("PUT")
+	manage.HandleFunc("/devices/{id}/approve", h.ApproveDevice).Methods("POST")
+	manage.HandleFunc("/devices/{id}/lifecycle", h.SetDeviceLifecycle).Methods("PUT")
+	manage.HandleFunc("/devices/{id}/poll-profile", h.GetDevicePollProfile).Methods("GET")
 
 	// WiFi configuration
-	api.HandleFunc("/devices/{id}/wifi", h.GetWiFiConfig).Methods("GET")
-	api.HandleFunc("/devices/{id}/wifi", h.UpdateWiFiConfig).Methods("PUT")
-	api.HandleFunc("/devices/{id}/wifi/ssid", h.UpdateSSID).Methods("PUT")
-	api.HandleFunc("/devices/{id}/wifi/password", h.UpdateWiFiPassword).Methods("PUT")
+	manage.HandleFunc("/devices/{id}/wifi", h.GetWiFiConfig).Methods("GET")
+	manage.HandleFunc("/devices/{id}/wifi", h.UpdateWiFiConfig).Methods("PUT")
+	manage.HandleFunc("/devices/{id}/wifi/ssid", h.UpdateSSID).Methods("PUT")
+	manage.HandleFunc("/devices/{id}/wifi/password", h.UpdateWiFiPassword).Methods("PUT")
 
 	// WAN configuration
-	api.HandleFunc("/devices/{id}/wan", h.GetWANConfigs).Methods("GET")
-	api.HandleFunc("/devices/{id}/wan", h.CreateWANConfig).Methods("POST")
-	api.HandleFunc("/devices/{id}/wan/{wanId}", h.GetWANConfig).Methods("GET")
-	api.HandleFunc("/devices/{id}/wan/{wanId}", h.UpdateWANConfig).Methods("PUT")
-	api.HandleFunc("/devices/{id}/wan/{wanId}", h.DeleteWANConfig).Methods("DELETE")
+	manage.HandleFunc("/devices/{id}/wan", h.GetWANConfigs).Methods("GET")
+	manage.HandleFunc("/devices/{id}/wan", h.CreateWANConfig).Methods("POST")
+	manage.HandleFunc("/devices/{id}/wan/{wanId}", h.GetWANConfig).Methods("GET")
+	manage.HandleFunc("/devices/{id}/wan/{wanId}", h.UpdateWANConfig).Methods("PUT")
+	manage.HandleFunc("/devices/{id}/wan/{wanId}", h.DeleteWANConfig).Methods("DELETE")
+	manage.HandleFunc("/devices/{id}/wan/{wanId}/apply", h.ApplyWANConfig).Methods("POST")
 	// WAN/PPPoE details
-	api.HandleFunc("/devices/{id}/wan-details", h.GetDeviceWAN).Methods("GET")
+	manage.HandleFunc("/devices/{id}/wan-details", h.GetDeviceWAN).Methods("GET")
+
+	// Multi-WAN templates
+	manage.HandleFunc("/wan-templates", h.GetWANTemplates).Methods("GET")
+	manage.HandleFunc("/wan-templates", h.CreateWANTemplate).Methods("POST")
+	manage.HandleFunc("/wan-templates/{id}", h.GetWANTemplate).Methods("GET")
+	manage.HandleFunc("/wan-templates/{id}", h.UpdateWANTemplate).Methods("PUT")
+	manage.HandleFunc("/wan-templates/{id}", h.DeleteWANTemplate).Methods("DELETE")
+	manage.HandleFunc("/devices/{id}/wan-template", h.ApplyWANTemplate).Methods("POST")
 
 	// LAN configuration
-	api.HandleFunc("/devices/{id}/lan", h.GetLANConfig).Methods("GET")
-	api.HandleFunc("/devices/{id}/lan", h.UpdateLANConfig).Methods("PUT")
+	manage.HandleFunc("/devices/{id}/lan", h.GetLANConfig).Methods("GET")
+	manage.HandleFunc("/devices/{id}/lan", h.UpdateLANConfig).Methods("PUT")
 
 	// Device parameters
-	api.HandleFunc("/devices/{id}/parameters", h.GetDeviceParameters).Methods("GET")
-	api.HandleFunc("/devices/{id}/parameters", h.SetDeviceParameters).Methods("POST")
-	api.HandleFunc("/devices/{id}/parameters/{path}", h.GetDeviceParameter).Methods("GET")
-	api.HandleFunc("/devices/template/{template}", h.GetDeviceByTemplate).Methods("GET")
-	api.HandleFunc("/customers/pppoe/{pppoeUsername}", h.GetCustomerByPPPoE).Methods("GET")
+	manage.HandleFunc("/devices/{id}/parameters", h.GetDeviceParameters).Methods("GET")
+	manage.HandleFunc("/devices/{id}/parameters", h.SetDeviceParameters).Methods("POST")
+	manage.HandleFunc("/devices/{id}/parameters/pinned", h.GetDevicePinnedParameters).Methods("GET")
+	manage.HandleFunc("/devices/{id}/parameter-watches", h.GetParameterWatches).Methods("GET")
+	manage.HandleFunc("/devices/{id}/parameter-watches", h.CreateParameterWatch).Methods("POST")
+	manage.HandleFunc("/devices/{id}/parameters/{path}", h.GetDeviceParameter).Methods("GET")
+	manage.HandleFunc("/devices/{id}/parameters/{path}/history", h.GetParameterHistory).Methods("GET")
+	manage.HandleFunc("/pinned-parameters", h.GetPinnedParameters).Methods("GET")
+	manage.HandleFunc("/pinned-parameters", h.CreatePinnedParameter).Methods("POST")
+	manage.HandleFunc("/pinned-parameters/{id}", h.DeletePinnedParameter).Methods("DELETE")
+	manage.HandleFunc("/parameter-watches", h.GetParameterWatches).Methods("GET")
+	manage.HandleFunc("/parameter-watches/{id}", h.DeleteParameterWatch).Methods("DELETE")
+	manage.HandleFunc("/reboot-schedules", h.GetRebootSchedules).Methods("GET")
+	manage.HandleFunc("/reboot-schedules", h.CreateRebootSchedule).Methods("POST")
+	manage.HandleFunc("/reboot-schedules/{id}", h.DeleteRebootSchedule).Methods("DELETE")
+	manage.HandleFunc("/devices/template/{template}", h.GetDeviceByTemplate).Methods("GET")
+	manage.HandleFunc("/customers/pppoe/{pppoeUsername}", h.GetCustomerByPPPoE).Methods("GET")
 
 	// Firmware management
-	api.HandleFunc("/devices/{id}/firmware", h.GetFirmwareInfo).Methods("GET")
-	api.HandleFunc("/devices/{id}/firmware/upgrade", h.UpgradeFirmware).Methods("POST")
+	manage.HandleFunc("/devices/{id}/firmware", h.GetFirmwareInfo).Methods("GET")
+	manage.HandleFunc("/devices/{id}/firmware/upgrade", h.UpgradeFirmware).Methods("POST")
+	manage.HandleFunc("/firmware", h.GetFirmwares).Methods("GET")
+	manage.HandleFunc("/firmware/bulk-upgrade", h.BulkUpgradeFirmware).Methods("POST")
+	manage.HandleFunc("/firmware/audit", h.GetFirmwareAudit).Methods("GET")
+	manage.HandleFunc("/firmware", h.CreateFirmware).Methods("POST")
+	manage.HandleFunc("/firmware/{id}", h.DeleteFirmware).Methods("DELETE")
 
 	// Tasks/Commands
-	api.HandleFunc("/devices/{id}/tasks", h.GetDeviceTasks).Methods("GET")
-	api.HandleFunc("/devices/{id}/tasks", h.CreateDeviceTask).Methods("POST")
-	api.HandleFunc("/tasks/{taskId}", h.GetTask).Methods("GET")
-	api.HandleFunc("/tasks/{taskId}", h.DeleteTask).Methods("DELETE")
+	manage.HandleFunc("/devices/{id}/tasks", h.GetDeviceTasks).Methods("GET")
+	manage.HandleFunc("/devices/{id}/tasks", h.CreateDeviceTask).Methods("POST")
+	manage.HandleFunc("/devices/{id}/pending", h.GetDevicePendingSummary).Methods("GET")
+	manage.HandleFunc("/tasks/{taskId}", h.GetTask).Methods("GET")
+	manage.HandleFunc("/tasks/{taskId}", h.DeleteTask).Methods("DELETE")
+	manage.HandleFunc("/tasks/{taskId}/revert", h.RevertTask).Methods("POST")
+	manage.HandleFunc("/tasks/{taskId}/retry", h.RetryTask).Methods("POST")
 
 	// Presets/Provisions
-	api.HandleFunc("/presets", h.GetPresets).Methods("GET")
-	api.HandleFunc("/presets", h.CreatePreset).Methods("POST")
-	api.HandleFunc("/presets/{id}", h.GetPreset).Methods("GET")
-	api.HandleFunc("/presets/{id}", h.UpdatePreset).Methods("PUT")
-	api.HandleFunc("/presets/{id}", h.DeletePreset).Methods("DELETE")
+	manage.HandleFunc("/presets", h.GetPresets).Methods("GET")
+	manage.HandleFunc("/presets", h.CreatePreset).Methods("POST")
+	manage.HandleFunc("/presets/{id}", h.GetPreset).Methods("GET")
+	manage.HandleFunc("/presets/{id}", h.UpdatePreset).Methods("PUT")
+	manage.HandleFunc("/presets/{id}", h.DeletePreset).Methods("DELETE")
+	manage.HandleFunc("/rollouts", h.GetRollouts).Methods("GET")
+	manage.HandleFunc("/rollouts", h.CreateRollout).Methods("POST")
+	manage.HandleFunc("/rollouts/{id}", h.GetRollout).Methods("GET")
+	manage.HandleFunc("/rollouts/{id}/abort", h.AbortRollout).Methods("POST")
+
+	// Poll profiles (per-model parameter sets read on bootstrap)
+	manage.HandleFunc("/poll-profiles", h.GetPollProfiles).Methods("GET")
+	manage.HandleFunc("/poll-profiles", h.CreatePollProfile).Methods("POST")
+	manage.HandleFunc("/poll-profiles/{id}", h.UpdatePollProfile).Methods("PUT")
+	manage.HandleFunc("/poll-profiles/{id}", h.DeletePollProfile).Methods("DELETE")
+
+	// Tags
+	manage.HandleFunc("/tags", h.GetTags).Methods("GET")
+	manage.HandleFunc("/tags", h.CreateTag).Methods("POST")
+	manage.HandleFunc("/tags/{name}", h.DeleteTag).Methods("DELETE")
+	manage.HandleFunc("/devices/bulk/tags", h.BulkTagDevices).Methods("POST")
+	manage.HandleFunc("/devices/bulk-action", h.BulkDeviceAction).Methods("POST")
+	manage.HandleFunc("/devices/{id}/tags", h.AddDeviceTags).Methods("POST")
+	manage.HandleFunc("/devices/{id}/tags/{tag}", h.RemoveDeviceTag).Methods("DELETE")
 
 	// Logs
-	api.HandleFunc("/logs", h.GetLogs).Methods("GET")
-	api.HandleFunc("/devices/{id}/logs", h.GetDeviceLogs).Methods("GET")
+	manage.HandleFunc("/logs", h.GetLogs).Methods("GET")
+	manage.HandleFunc("/logs/export", h.ExportLogs).Methods("GET")
+	system.HandleFunc("/audit", h.GetAuditLog).Methods("GET")
+	system.HandleFunc("/audit/export", h.ExportAudit).Methods("GET")
+	manage.HandleFunc("/devices/{id}/logs", h.GetDeviceLogs).Methods("GET")
 
 	// ============== Billing API Routes ==============
 
 	// Packages
-	api.HandleFunc("/packages", h.GetPackages).Methods("GET")
-	api.HandleFunc("/packages", h.CreatePackage).Methods("POST")
-	api.HandleFunc("/packages/{id}", h.GetPackageByID).Methods("GET")
-	api.HandleFunc("/packages/{id}", h.UpdatePackage).Methods("PUT")
-	api.HandleFunc("/packages/{id}", h.DeletePackage).Methods("DELETE")
+	manage.HandleFunc("/packages", h.GetPackages).Methods("GET")
+	manage.HandleFunc("/packages", h.CreatePackage).Methods("POST")
+	manage.HandleFunc("/packages/{id}", h.GetPackageByID).Methods("GET")
+	manage.HandleFunc("/packages/{id}", h.UpdatePackage).Methods("PUT")
+	manage.HandleFunc("/packages/{id}", h.DeletePackage).Methods("DELETE")
 
 	//Customers
-	api.HandleFunc("/customers", h.GetCustomers).Methods("GET")
-	api.HandleFunc("/customers", h.CreateCustomer).Methods("POST")
-	api.HandleFunc("/customers/{id}", h.GetCustomer).Methods("GET")
-	api.HandleFunc("/customers/{id}", h.UpdateCustomer).Methods("PUT")
-	api.HandleFunc("/customers/{id}", h.DeleteCustomer).Methods("DELETE")
-	api.HandleFunc("/customers/{id}/isolir", h.IsolirCustomer).Methods("POST")
-	api.HandleFunc("/customers/{id}/unsuspend", h.UnsuspendCustomer).Methods("POST")
-	api.HandleFunc("/customers/{id}/unsuspend-without-payment", h.UnsuspendCustomerWithoutPayment).Methods("POST")
-	api.HandleFunc("/customers/{id}/location", h.UpdateCustomerLocation).Methods("PUT")
-	api.HandleFunc("/customers/{id}/fcm", h.UpdateCustomerFCM).Methods("POST")
-	api.HandleFunc("/customers/{id}/sync-device", h.SyncCustomerToDeviceByPPPoE).Methods("POST")
-	api.HandleFunc("/locations", h.GetLocations).Methods("GET")
+	manage.HandleFunc("/customers", h.GetCustomers).Methods("GET")
+	manage.HandleFunc("/customers", h.CreateCustomer).Methods("POST")
+	manage.HandleFunc("/customers/onboard", h.OnboardCustomer).Methods("POST")
+	manage.HandleFunc("/customers/export", h.ExportCustomers).Methods("GET")
+	manage.HandleFunc("/customers/import", h.ImportCustomers).Methods("POST")
+	manage.HandleFunc("/customers/{id}", h.GetCustomer).Methods("GET")
+	manage.HandleFunc("/customers/{id}", h.UpdateCustomer).Methods("PUT")
+	manage.HandleFunc("/customers/{id}", h.DeleteCustomer).Methods("DELETE")
+	manage.HandleFunc("/customers/{id}/anonymize", h.AnonymizeCustomer).Methods("POST")
+	manage.HandleFunc("/customers/{id}/reset-portal-password", h.ResetPortalPassword).Methods("POST")
+	manage.HandleFunc("/customers/{id}/statement", h.GetCustomerStatement).Methods("GET")
+	manage.HandleFunc("/customers/{id}/isolir", h.IsolirCustomer).Methods("POST")
+	manage.HandleFunc("/customers/{id}/unsuspend", h.UnsuspendCustomer).Methods("POST")
+	manage.HandleFunc("/customers/{id}/unsuspend-without-payment", h.UnsuspendCustomerWithoutPayment).Methods("POST")
+	manage.HandleFunc("/customers/{id}/hold", h.GetCustomerHold).Methods("GET")
+	manage.HandleFunc("/customers/{id}/hold", h.HoldCustomer).Methods("POST")
+	manage.HandleFunc("/customers/{id}/hold", h.ResumeCustomerHold).Methods("DELETE")
+	manage.HandleFunc("/customer-holds", h.GetCustomerHolds).Methods("GET")
+	manage.HandleFunc("/customers/{id}/location", h.UpdateCustomerLocation).Methods("PUT")
+	manage.HandleFunc("/customers/{id}/fcm", h.UpdateCustomerFCM).Methods("POST")
+	manage.HandleFunc("/customers/{id}/notification-preferences", h.GetCustomerNotificationPreferences).Methods("GET")
+	manage.HandleFunc("/customers/{id}/notification-preferences", h.UpdateCustomerNotificationPreferences).Methods("PUT")
+	manage.HandleFunc("/customers/{id}/discount", h.GetCustomerDiscount).Methods("GET")
+	manage.HandleFunc("/customers/{id}/discount", h.UpdateCustomerDiscount).Methods("PUT")
+	manage.HandleFunc("/customers/{id}/sync-device", h.SyncCustomerToDeviceByPPPoE).Methods("POST")
+	manage.HandleFunc("/locations", h.GetLocations).Methods("GET")
 
 	// Invoices
-	api.HandleFunc("/invoices", h.GetInvoices).Methods("GET")
-	api.HandleFunc("/invoices", h.CreateInvoice).Methods("POST")
-	api.HandleFunc("/invoices/generate", h.GenerateMonthlyInvoices).Methods("POST")
-	api.HandleFunc("/invoices/{id}", h.GetInvoice).Methods("GET")
-	api.HandleFunc("/invoices/{id}/pay", h.MarkInvoicePaid).Methods("POST")
+	manage.HandleFunc("/invoices", h.GetInvoices).Methods("GET")
+	manage.HandleFunc("/invoices", h.CreateInvoice).Methods("POST")
+	manage.HandleFunc("/invoices/generate", h.GenerateMonthlyInvoices).Methods("POST")
+	manage.HandleFunc("/invoices/resend", h.ResendPendingInvoices).Methods("POST")
+	manage.HandleFunc("/invoices/{id}", h.GetInvoice).Methods("GET")
+	manage.HandleFunc("/invoices/{id}/pay", h.MarkInvoicePaid).Methods("POST")
+	manage.HandleFunc("/invoices/{id}/resend", h.ResendInvoice).Methods("POST")
 
 	// Payments
-	api.HandleFunc("/payments", h.GetPayments).Methods("GET")
-	api.HandleFunc("/payments", h.CreatePayment).Methods("POST")
-	api.HandleFunc("/payment/channels", h.GetPaymentChannels).Methods("GET")
-	api.HandleFunc("/invoices/{id}/pay/online", h.CreatePaymentTransaction).Methods("POST")
+	manage.HandleFunc("/payments", h.GetPayments).Methods("GET")
+	manage.HandleFunc("/payments", h.CreatePayment).Methods("POST")
+	manage.HandleFunc("/payment/channels", h.GetPaymentChannels).Methods("GET")
+	manage.HandleFunc("/invoices/{id}/pay/online", h.CreatePaymentTransaction).Methods("POST")
 
-	// Callbacks (Public)
+	// Callbacks (Public, verified by the gateway signature)
 	api.HandleFunc("/callbacks/tripay", h.HandleTripayCallback).Methods("POST")
+	api.HandleFunc("/callbacks/midtrans", h.HandleMidtransCallback).Methods("POST")
+	manage.HandleFunc("/callbacks", h.GetCallbackEvents).Methods("GET")
+	manage.HandleFunc("/callbacks/{id}/reprocess", h.ReprocessCallback).Methods("POST")
 
-	// Billing Stats & Actions
-	api.HandleFunc("/billing/stats", h.GetBillingStats).Methods("GET")
-	api.HandleFunc("/network/stats", h.GetNetworkOverview).Methods("GET")
-	api.HandleFunc("/billing/batch-isolir", h.BatchIsolirOverdue).Methods("POST")
+	// Notification outbox and provider health
+	manage.HandleFunc("/notifications/health", h.GetNotificationHealth).Methods("GET")
+	system.HandleFunc("/notifications/outbox", h.GetNotificationOutbox).Methods("GET")
+	manage.HandleFunc("/notifications/outbox/{id}/retry", h.RetryNotification).Methods("POST")
 
-	// Customer Portal API
-	api.HandleFunc("/portal/auth/login", h.CustomerLogin).Methods("POST")
-	api.HandleFunc("/portal/dashboard", h.GetCustomerDashboard).Methods("GET")
-	api.HandleFunc("/portal/invoices", h.GetCustomerInvoices).Methods("GET")
-	api.HandleFunc("/portal/wifi", h.GetCustomerWiFi).Methods("GET")
-	api.HandleFunc("/portal/wifi", h.UpdateCustomerWiFi).Methods("PUT")
+	// Billing Stats & Actions
+	manage.HandleFunc("/billing/stats", h.GetBillingStats).Methods("GET")
+	manage.HandleFunc("/network/stats", h.GetNetworkOverview).Methods("GET")
+	manage.HandleFunc("/billing/batch-isolir", h.BatchIsolirOverdue).Methods("POST")
 
 	// Mobile API
-	api.HandleFunc("/mobile/usage", h.GetMobileUsage).Methods("GET")
+	manage.HandleFunc("/mobile/usage", h.GetMobileUsage).Methods("GET")
+	manage.HandleFunc("/field/install", h.FieldInstall).Methods("POST")
 
 	// Support Tickets
-	api.HandleFunc("/tickets", h.GetSupportTickets).Methods("GET")
-	api.HandleFunc("/tickets", h.CreateSupportTicket).Methods("POST")
-	api.HandleFunc("/tickets/{id}", h.GetSupportTicket).Methods("GET")
-	api.HandleFunc("/tickets/{id}", h.UpdateSupportTicket).Methods("PUT")
-	api.HandleFunc("/tickets/{id}", h.DeleteSupportTicket).Methods("DELETE")
+	manage.HandleFunc("/tickets", h.GetSupportTickets).Methods("GET")
+	manage.HandleFunc("/tickets", h.CreateSupportTicket).Methods("POST")
+	manage.HandleFunc("/tickets/stats", h.GetTicketStats).Methods("GET")
+	manage.HandleFunc("/tickets/{id}", h.GetSupportTicket).Methods("GET")
+	manage.HandleFunc("/tickets/{id}", h.UpdateSupportTicket).Methods("PUT")
+	manage.HandleFunc("/tickets/{id}", h.DeleteSupportTicket).Methods("DELETE")
+	manage.HandleFunc("/technicians", h.GetTechnicians).Methods("GET")
+	manage.HandleFunc("/technicians", h.CreateTechnician).Methods("POST")
+	manage.HandleFunc("/technicians/{id}", h.UpdateTechnician).Methods("PUT")
+	manage.HandleFunc("/technicians/{id}", h.DeleteTechnician).Methods("DELETE")
+
+	// Customer portal announcements
+	manage.HandleFunc("/announcements", h.GetAnnouncements).Methods("GET")
+	manage.HandleFunc("/announcements", h.CreateAnnouncement).Methods("POST")
+	manage.HandleFunc("/announcements/{id}", h.UpdateAnnouncement).Methods("PUT")
+	manage.HandleFunc("/announcements/{id}", h.DeleteAnnouncement).Methods("DELETE")
 
 	// Device Location (for map)
-	api.HandleFunc("/devices/{id}/location", h.UpdateDeviceLocation).Methods("PUT")
-
-	// System Settings
-	api.HandleFunc("/settings", h.GetSettings).Methods("GET")
-	api.HandleFunc("/settings", h.SaveSettings).Methods("POST")
-	api.HandleFunc("/settings/password", h.ChangeAdminPassword).Methods("POST")
-	api.HandleFunc("/mikrotik/test", h.TestMikrotik).Methods("GET")
-	api.HandleFunc("/mikrotik/profiles", h.GetMikrotikProfiles).Methods("GET")
-	api.HandleFunc("/mikrotik/profiles", h.CreateMikrotikProfile).Methods("POST")
-
-	// Update API
-	api.HandleFunc("/update/check", h.CheckForUpdates).Methods("GET")
-	api.HandleFunc("/update/perform", h.PerformUpdate).Methods("POST")
-	api.HandleFunc("/update/rebuild", h.RebuildApplication).Methods("POST")
-	api.HandleFunc("/update/restart", h.RestartService).Methods("POST")
+	manage.HandleFunc("/devices/{id}/location", h.UpdateDeviceLocation).Methods("PUT")
+
+	// System Settings (admin only)
+	system.HandleFunc("/settings", h.GetSettings).Methods("GET")
+	system.HandleFunc("/settings", h.SaveSettings).Methods("POST")
+	system.HandleFunc("/settings/export", h.ExportSettings).Methods("GET")
+	system.HandleFunc("/settings/import", h.ImportSettings).Methods("POST")
+	system.HandleFunc("/settings/password", h.ChangeAdminPassword).Methods("POST")
+
+	// MikroTik
+	manage.HandleFunc("/mikrotik/test", h.TestMikrotik).Methods("GET")
+	manage.HandleFunc("/mikrotik/resource", h.GetMikrotikResource).Methods("GET")
+	manage.HandleFunc("/mikrotik/profiles", h.GetMikrotikProfiles).Methods("GET")
+	manage.HandleFunc("/mikrotik/profiles", h.CreateMikrotikProfile).Methods("POST")
+
+	// Update API (admin only)
+	system.HandleFunc("/update/check", h.CheckForUpdates).Methods("GET")
+	system.HandleFunc("/update/perform", h.PerformUpdate).Methods("POST")
+	system.HandleFunc("/update/rebuild", h.RebuildApplication).Methods("POST")
+	system.HandleFunc("/update/restart", h.RestartService).Methods("POST")
 
 	// LAN Configuration
-	api.HandleFunc("/devices/{id}/lan", h.GetLANConfig).Methods("GET")
-	api.HandleFunc("/devices/{id}/lan", h.UpdateLANConfig).Methods("PUT")
+	manage.HandleFunc("/devices/{id}/lan", h.GetLANConfig).Methods("GET")
+	manage.HandleFunc("/devices/{id}/lan", h.UpdateLANConfig).Methods("PUT")
 
 	// Port Forwarding / NAT
-	api.HandleFunc("/devices/{id}/port-forwarding", h.GetPortForwardingRules).Methods("GET")
-	api.HandleFunc("/devices/{id}/port-forwarding", h.CreatePortForwardingRule).Methods("POST")
+	manage.HandleFunc("/devices/{id}/port-forwarding", h.GetPortForwardingRules).Methods("GET")
+	manage.HandleFunc("/devices/{id}/port-forwarding", h.CreatePortForwardingRule).Methods("POST")
 
 	// Bridge Mode
-	api.HandleFunc("/devices/{id}/bridge-mode", h.SetBridgeMode).Methods("PUT")
+	manage.HandleFunc("/devices/{id}/bridge-mode", h.SetBridgeMode).Methods("PUT")
 
 	// QoS
-	api.HandleFunc("/devices/{id}/qos", h.GetQoSConfig).Methods("GET")
-	api.HandleFunc("/devices/{id}/qos", h.UpdateQoSConfig).Methods("PUT")
+	manage.HandleFunc("/devices/{id}/qos", h.GetQoSConfig).Methods("GET")
+	manage.HandleFunc("/devices/{id}/qos", h.UpdateQoSConfig).Methods("PUT")
 
 	// WebSocket
 	router.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go-acs/internal/config"
+	"go-acs/internal/database"
+	"go-acs/internal/handlers"
+	"go-acs/internal/middleware"
+	"go-acs/internal/models"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const testJWTSecret = "test-secret"
+
+func TestMain(m *testing.M) {
+	// Templates are parsed relative to the repository root
+	if err := os.Chdir("../.."); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+func newTestServer(t *testing.T) http.Handler {
+	t.Helper()
+	db, err := database.InitDB(filepath.Join(t.TempDir(), "acs.db"), database.Options{})
+	if err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	cfg := config.Load()
+	cfg.TestMode = true
+	h := handlers.NewHandler(db, nil, nil, nil, nil, nil, nil, nil, cfg, nil)
+	return middleware.AuthMiddleware(testJWTSecret)(setupRouter(h, nil))
+}
+
+func tokenFor(t *testing.T, role string) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &middleware.Claims{UserID: 1, Username: role, Role: role}).
+		SignedString([]byte(testJWTSecret))
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+	return token
+}
+
+func do(server http.Handler, method, path, token, body string) int {
+	return serve(server, method, path, token, body).Code
+}
+
+func serve(server http.Handler, method, path, token, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, r)
+	return rec
+}
+
+func TestPortalRoutesRequireACustomerToken(t *testing.T) {
+	db, err := database.InitDB(filepath.Join(t.TempDir(), "acs.db"), database.Options{})
+	if err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	cfg := config.Load()
+	cfg.TestMode = true
+	cfg.JWTSecret = testJWTSecret
+	server := middleware.AuthMiddleware(testJWTSecret)(setupRouter(handlers.NewHandler(db, nil, nil, nil, nil, nil, nil, nil, cfg, nil), nil))
+
+	pkg, err := db.CreatePackage(&models.Package{Name: "Home 20", Price: 150000, IsActive: true})
+	if err != nil {
+		t.Fatalf("CreatePackage: %v", err)
+	}
+	hash, _ := db.HashPassword("secret123")
+	customers := map[string]*models.Customer{}
+	devices := map[string]*models.Device{}
+	for i, name := range []string{"alice", "bob"} {
+		c, err := db.CreateCustomer(&models.Customer{Name: name, Username: name, Password: hash, PackageID: pkg.ID, Status: "active"})
+		if err != nil {
+			t.Fatalf("CreateCustomer: %v", err)
+		}
+		d, err := db.CreateDevice(&models.Device{SerialNumber: fmt.Sprintf("SN00%d", i+1), Manufacturer: "Huawei", Status: "online"})
+		if err != nil {
+			t.Fatalf("CreateDevice: %v", err)
+		}
+		if err := db.AssignDeviceToCustomer(d.ID, c.ID); err != nil {
+			t.Fatalf("AssignDeviceToCustomer: %v", err)
+		}
+		customers[name], devices[name] = c, d
+	}
+
+	rec := serve(server, "POST", "/api/portal/auth/login", "", `{"username":"alice","password":"secret123"}`)
+	var login struct {
+		Token string `json:"token"`
+	}
+	if rec.Code != http.StatusOK || json.NewDecoder(rec.Body).Decode(&login) != nil || login.Token == "" {
+		t.Fatalf("portal login = %d %s", rec.Code, rec.Body)
+	}
+	alice := login.Token
+
+	portalRoutes := []struct{ method, path string }{
+		{"GET", "/api/portal/dashboard"},
+		{"GET", "/api/portal/invoices"},
+		{"GET", "/api/portal/wifi"},
+		{"PUT", "/api/portal/wifi"},
+		{"PUT", "/api/portal/wifi/ssid"},
+		{"PUT", "/api/portal/wifi/password"},
+		{"POST", "/api/portal/tickets"},
+		{"POST", "/api/portal/tickets/1/rate"},
+	}
+	staff := map[string]string{
+		"none":   "",
+		"admin":  tokenFor(t, middleware.RoleAdmin),
+		"viewer": tokenFor(t, middleware.RoleViewer),
+		"legacy": fmt.Sprintf("customer-%d-1700000000", customers["alice"].ID),
+	}
+	for who, token := range staff {
+		for _, route := range portalRoutes {
+			if code := do(server, route.method, route.path, token, `{}`); code != http.StatusUnauthorized {
+				t.Errorf("%s token %s %s = %d, want 401", who, route.method, route.path, code)
+			}
+		}
+	}
+
+	// A customer token is not a staff session
+	for _, path := range []string{"/api/customers", "/api/devices", "/api/dashboard/stats"} {
+		if code := do(server, "GET", path, alice, ""); code != http.StatusUnauthorized {
+			t.Errorf("customer token GET %s = %d, want 401", path, code)
+		}
+	}
+
+	if code := do(server, "GET", "/api/portal/dashboard", alice, ""); code != http.StatusOK {
+		t.Errorf("dashboard = %d, want 200", code)
+	}
+	ssid := func(deviceID int64) int {
+		return do(server, "PUT", "/api/portal/wifi/ssid", alice, fmt.Sprintf(`{"deviceId":%d,"ssid":"Home"}`, deviceID))
+	}
+	if code := ssid(devices["alice"].ID); code != http.StatusOK {
+		t.Errorf("SSID change on own device = %d, want 200", code)
+	}
+	if code := ssid(devices["bob"].ID); code != http.StatusNotFound {
+		t.Errorf("SSID change on another customer's device = %d, want 404", code)
+	}
+
+	// The ticket belongs to the session's customer; it can't be named in the body
+	if code := do(server, "POST", "/api/portal/tickets", alice, fmt.Sprintf(`{"customerId":%d,"subject":"Slow"}`, customers["bob"].ID)); code != http.StatusBadRequest {
+		t.Errorf("ticket naming another customer = %d, want 400", code)
+	}
+	rec = serve(server, "POST", "/api/portal/tickets", alice, `{"subject":"Slow","description":"Slow at night"}`)
+	var created struct {
+		Ticket models.SupportTicket `json:"ticket"`
+	}
+	if rec.Code != http.StatusCreated || json.NewDecoder(rec.Body).Decode(&created) != nil {
+		t.Fatalf("create ticket = %d %s", rec.Code, rec.Body)
+	}
+	if created.Ticket.CustomerID != customers["alice"].ID {
+		t.Errorf("ticket customer = %d, want %d", created.Ticket.CustomerID, customers["alice"].ID)
+	}
+}
+
+func TestPaymentCallbacksArePublic(t *testing.T) {
+	server := newTestServer(t)
+	for _, path := range []string{"/api/callbacks/tripay", "/api/callbacks/midtrans"} {
+		code := do(server, "POST", path, "", `{}`)
+		if code == http.StatusUnauthorized || code == http.StatusForbidden || code == http.StatusNotFound || code == http.StatusMethodNotAllowed {
+			t.Errorf("POST %s without a token = %d, want it to reach the handler", path, code)
+		}
+	}
+	if code := do(server, "POST", "/api/callbacks/1/reprocess", "", ""); code != http.StatusUnauthorized {
+		t.Errorf("reprocess without a token = %d, want 401", code)
+	}
+}
+
+func TestPendingDeviceIsNotManageableUntilApproved(t *testing.T) {
+	db, err := database.InitDB(filepath.Join(t.TempDir(), "acs.db"), database.Options{})
+	if err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	cfg := config.Load()
+	cfg.TestMode = true
+	server := middleware.AuthMiddleware(testJWTSecret)(setupRouter(handlers.NewHandler(db, nil, nil, nil, nil, nil, nil, nil, cfg, nil), nil))
+	operator := tokenFor(t, middleware.RoleOperator)
+
+	device, err := db.CreateDevice(&models.Device{SerialNumber: "SN001", Status: models.StatusPending})
+	if err != nil {
+		t.Fatalf("CreateDevice: %v", err)
+	}
+
+	for _, route := range []struct{ method, path, body string }{
+		{"POST", "/api/devices/1/reboot", ""},
+		{"PUT", "/api/devices/1/wifi/ssid", `{"ssid":"Home"}`},
+		{"POST", "/api/devices/1/parameters", `{"parameters":{"A.B":"1"}}`},
+		{"POST", "/api/devices/1/tasks", `{"type":"reboot"}`},
+	} {
+		if code := do(server, route.method, route.path, operator, route.body); code != http.StatusConflict {
+			t.Errorf("%s %s on a pending device = %d, want 409", route.method, route.path, code)
+		}
+	}
+	if code := do(server, "GET", "/api/devices/1", operator, ""); code != http.StatusOK {
+		t.Errorf("GET a pending device = %d, want 200", code)
+	}
+
+	if code := do(server, "POST", "/api/devices/1/approve", operator, ""); code != http.StatusOK {
+		t.Fatalf("approve = %d, want 200", code)
+	}
+	if got, _ := db.GetDevice(device.ID); got.Status == models.StatusPending {
+		t.Fatal("device still pending after approval")
+	}
+	if code := do(server, "POST", "/api/devices/1/reboot", operator, ""); code == http.StatusConflict {
+		t.Error("reboot still refused after approval")
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"os"
 	"strconv"
 	"time"
+
+	"go-acs/internal/models"
 )
 
 // Config holds the application configuration
@@ -13,9 +15,27 @@ type Config struct {
 	ServerPort              int
 	TR069Port               int
 	TR069Secure             bool
+	TR069Username           string // Global inform credentials; empty disables inform auth
+	TR069Password           string
+	DeviceRegistration      string // auto or approval
+	DeviceAliasFormat       string // Friendly device name in live events/logs: customer, pppoe or serial
+	AutoAssignPPPoE         bool   // Link devices to the customer whose username matches the PPPoE username on inform
+	DeviceLabelTemplate     string // Label set on customer assignment, e.g. "{name} - {address}"; empty disables
+	ConnReqScheme           string // Override the connection-request URL scheme (http/https); empty keeps the device's
+	ConnReqPort             int    // Override the connection-request URL port; 0 keeps the device's
+	ConnReqTLSVerify        bool   // Verify CPE certificates on https connection requests
+	PublicURL               string // External base URL (e.g. https://acs.example.com) for payment return/callback links; empty = derive from the request
+	TrustedProxies          string // Comma-separated IPs/CIDRs whose X-Forwarded-* headers are honoured; empty = none
+	DatabaseDriver          string // Database engine: sqlite (default); postgres and mysql are not supported yet
 	DatabaseURL             string
+	DBMaxOpenConns          int
+	DBMaxIdleConns          int
+	DBBusyTimeoutMs         int
+	MaxPendingTasks         int // Per-device pending task cap; 0 = unlimited
+	MaxTaskRetries          int // Failed tasks are requeued automatically up to this many times; 0 = off
 	JWTSecret               string
 	LogLevel                string
+	TestMode                bool // Log MikroTik, payment and notification side effects instead of performing them
 	AuthEnabled             bool
 	AdminUser               string
 	AdminPass               string
@@ -23,15 +43,57 @@ type Config struct {
 	MikrotikUser            string
 	MikrotikPass            string
 	MikrotikPort            int
+	MikrotikPollSeconds     int // Dashboard router stats are refreshed at most this often
 	TripayAPIKey            string
 	TripayPrivateKey        string
 	TripayMerchantCode      string
-	TripayMode              string // sandbox or production
+	TripayMode              string  // sandbox or production
+	PaymentGateway          string  // tripay or midtrans
+	MidtransServerKey       string  // Also verifies notification signatures
+	MidtransMode            string  // sandbox or production
+	PortalPhoneLogin        bool    // Allow portal login with the customer's phone number
+	PhoneCountryCode        string  // Used to normalize local numbers (0812... -> 62812...)
+	RXExcellentDBm          float64 // RX power at or above this is excellent
+	RXGoodDBm               float64 // ... good; below it the optical alert is a warning
+	RXWarningDBm            float64 // ... warning; below is critical and so is the alert
+	RXOverloadDBm           float64 // RX power above this overloads the receiver (warning)
+	RXAlertHysteresisDB     float64 // RX must recover this far above a band to clear its alert
+	OpticalAlertEnabled     bool    // Notify (log, WebSocket, Telegram, WhatsApp) on optical alert level changes
+	OpticalAlertCustomerMsg string  // WhatsApp template sent to the customer; empty = don't notify
+	OpticalAlertOperatorMsg string  // Telegram template sent to operators; empty = don't notify
+	WiFiClientAlertPercent  float64 // Alert when WiFi clients reach this % of the MaxClients limit (WLAN 1) and again at 100%; 0 = off
+	Timezone                string  // IANA name, e.g. "Asia/Jakarta"; empty = the server's local time
+	CurrencySymbol          string  // Shown in notifications, e.g. "Rp"
+	CurrencyDecimals        int     // 0 for Rupiah
+	AmountRounding          float64 // Round computed invoice totals to a multiple of this (1, 100, 1000)
+	TaxPercent              float64 // VAT added to generated invoices, e.g. 11; 0 = none
+	OnlinePaymentMinAmount  float64 // Invoices below this total can't be paid online; 0 = no minimum
+	CallbackMaxAgeHours     int     // Reject PAID callbacks whose paid_at is older than this; 0 disables
+	CallbackRetryMinutes    int     // Interval between retries of failed payment callbacks; 0 = manual reprocess only
+	CallbackMaxAttempts     int     // Attempts before a failing callback is moved to the dead-letter
+	DefaultPackageID        int64   // Package billed for active customers without one; 0 = skip them
+	CarryForwardMaxInvoices int     // Max unpaid invoices carried forward before termination; 0 = unlimited
+	CarryForwardMaxAmount   float64 // Max unpaid amount carried forward before termination; 0 = unlimited
+	TicketAutoAssign        string  // off, round_robin or area
+	TicketAutoCloseDays     int     // Close resolved tickets after this many days without activity; 0 = off
+	HoldMaxDays             int     // Longest vacation hold a customer may be put on; 0 = unlimited
+	InvoiceReminderDays     string  // Days before the due date to remind customers, e.g. "3,0"; empty = off
+	NotifyEmailConcurrency  int     // Max concurrent email sends for bulk notifications
+	NotifyWAConcurrency     int     // Max concurrent WhatsApp sends
+	NotifyPushConcurrency   int     // Max concurrent FCM sends
+	NotifyRetryHours        int     // Keep retrying an undelivered notification this long before giving up
+	NotifyBreakerThreshold  int     // Consecutive failures that mark a notification provider down
+	NotifyBreakerCooldown   int     // Seconds a down provider is left alone before a probe send
+	SendReceipt             bool    // Send payment receipts when an invoice is paid
+	ProrationEnabled        bool    // Bill customers who join mid-month only for the remaining days
 	WAProviderURL           string
 	WAApiKey                string
 	FirebaseCredentialsFile string
 	TelegramToken           string
 	TelegramChatID          string
+
+	// Location is Timezone loaded by LoadTimezone; nil = the server's local time
+	Location *time.Location
 }
 
 // Load loads configuration from environment variables with defaults
@@ -48,9 +110,27 @@ func Load() *Config {
 		ServerPort:              getEnvAsInt("SERVER_PORT", 8080),
 		TR069Port:               getEnvAsInt("TR069_PORT", 7547),
 		TR069Secure:             getEnvAsBool("TR069_SECURE", false),
+		TR069Username:           getEnv("TR069_USERNAME", ""),
+		TR069Password:           getEnv("TR069_PASSWORD", ""),
+		DeviceRegistration:      getEnv("DEVICE_REGISTRATION", "auto"),
+		DeviceAliasFormat:       getEnv("DEVICE_ALIAS_FORMAT", "customer"),
+		AutoAssignPPPoE:         getEnvAsBool("AUTO_ASSIGN_PPPOE", false),
+		DeviceLabelTemplate:     getEnv("DEVICE_LABEL_TEMPLATE", ""),
+		ConnReqScheme:           getEnv("CONN_REQ_SCHEME", ""),
+		ConnReqPort:             getEnvAsInt("CONN_REQ_PORT", 0),
+		ConnReqTLSVerify:        getEnvAsBool("CONN_REQ_TLS_VERIFY", false),
+		PublicURL:               getEnv("PUBLIC_URL", ""),
+		TrustedProxies:          getEnv("TRUSTED_PROXIES", ""),
+		DatabaseDriver:          getEnv("DB_DRIVER", "sqlite"),
 		DatabaseURL:             getEnv("DATABASE_URL", "./data/goacs.db"),
+		DBMaxOpenConns:          getEnvAsInt("DB_MAX_OPEN_CONNS", 4),
+		DBMaxIdleConns:          getEnvAsInt("DB_MAX_IDLE_CONNS", 4),
+		DBBusyTimeoutMs:         getEnvAsInt("DB_BUSY_TIMEOUT_MS", 5000),
+		MaxPendingTasks:         getEnvAsInt("MAX_PENDING_TASKS", 0),
+		MaxTaskRetries:          getEnvAsInt("MAX_TASK_RETRIES", 0),
 		JWTSecret:               jwtSecret,
 		LogLevel:                getEnv("LOG_LEVEL", "info"),
+		TestMode:                getEnvAsBool("TEST_MODE", false),
 		AuthEnabled:             getEnvAsBool("AUTH_ENABLED", true),
 		AdminUser:               getEnv("ADMIN_USER", "admin"),
 		AdminPass:               getEnv("ADMIN_PASS", "admin123"),
@@ -58,10 +138,49 @@ func Load() *Config {
 		MikrotikUser:            getEnv("MIKROTIK_USER", "admin"),
 		MikrotikPass:            getEnv("MIKROTIK_PASS", ""),
 		MikrotikPort:            getEnvAsInt("MIKROTIK_PORT", 8728),
+		MikrotikPollSeconds:     getEnvAsInt("MIKROTIK_POLL_SECONDS", 30),
 		TripayAPIKey:            getEnv("TRIPAY_API_KEY", "DEV-YOUR-API-KEY"),
-		TripayPrivateKey:        getEnv("TRIPAY_PRIVATE_KEY", "DEV-YOUR-PRIVATE-KEY"),
+		TripayPrivateKey:        getEnv("TRIPAY_PRIVATE_KEY", ""),
 		TripayMerchantCode:      getEnv("TRIPAY_MERCHANT_CODE", "T12345"),
 		TripayMode:              getEnv("TRIPAY_MODE", "sandbox"),
+		PaymentGateway:          getEnv("PAYMENT_GATEWAY", "tripay"),
+		MidtransServerKey:       getEnv("MIDTRANS_SERVER_KEY", ""),
+		MidtransMode:            getEnv("MIDTRANS_MODE", "sandbox"),
+		PortalPhoneLogin:        getEnvAsBool("PORTAL_PHONE_LOGIN", true),
+		PhoneCountryCode:        getEnv("PHONE_COUNTRY_CODE", "62"),
+		RXExcellentDBm:          getEnvAsFloat("RX_EXCELLENT_DBM", -20),
+		RXGoodDBm:               getEnvAsFloat("RX_GOOD_DBM", getEnvAsFloat("RX_POWER_WARN_DBM", getEnvAsFloat("OPTICAL_ALERT_DBM", -25))),
+		RXWarningDBm:            getEnvAsFloat("RX_WARNING_DBM", getEnvAsFloat("RX_POWER_CRITICAL_DBM", -27)),
+		RXOverloadDBm:           getEnvAsFloat("RX_OVERLOAD_DBM", -8),
+		RXAlertHysteresisDB:     getEnvAsFloat("RX_ALERT_HYSTERESIS_DB", 1),
+		OpticalAlertEnabled:     getEnvAsBool("OPTICAL_ALERT_ENABLED", false),
+		OpticalAlertCustomerMsg: getEnv("OPTICAL_ALERT_CUSTOMER_TEMPLATE", DefaultOpticalAlertCustomerMsg),
+		OpticalAlertOperatorMsg: getEnv("OPTICAL_ALERT_OPERATOR_TEMPLATE", DefaultOpticalAlertOperatorMsg),
+		WiFiClientAlertPercent:  getEnvAsFloat("WIFI_CLIENT_ALERT_PERCENT", 80),
+		Timezone:                getEnv("TIMEZONE", ""),
+		CurrencySymbol:          getEnv("CURRENCY_SYMBOL", "Rp"),
+		CurrencyDecimals:        getEnvAsInt("CURRENCY_DECIMALS", 0),
+		AmountRounding:          getEnvAsFloat("AMOUNT_ROUNDING", 1),
+		TaxPercent:              getEnvAsFloat("TAX_PERCENT", 0),
+		OnlinePaymentMinAmount:  getEnvAsFloat("ONLINE_PAYMENT_MIN_AMOUNT", 0),
+		CallbackMaxAgeHours:     getEnvAsInt("CALLBACK_MAX_AGE_HOURS", 48),
+		CallbackRetryMinutes:    getEnvAsInt("CALLBACK_RETRY_MINUTES", 5),
+		CallbackMaxAttempts:     getEnvAsInt("CALLBACK_MAX_ATTEMPTS", 5),
+		DefaultPackageID:        int64(getEnvAsInt("DEFAULT_PACKAGE_ID", 0)),
+		CarryForwardMaxInvoices: getEnvAsInt("CARRY_FORWARD_MAX_INVOICES", 0),
+		CarryForwardMaxAmount:   getEnvAsFloat("CARRY_FORWARD_MAX_AMOUNT", 0),
+		TicketAutoAssign:        getEnv("TICKET_AUTO_ASSIGN", "off"),
+		TicketAutoCloseDays:     getEnvAsInt("TICKET_AUTO_CLOSE_DAYS", 0),
+		HoldMaxDays:             getEnvAsInt("HOLD_MAX_DAYS", 90),
+		InvoiceReminderDays:     getEnv("INVOICE_REMINDER_DAYS", "3,0"),
+		NotifyEmailConcurrency:  getEnvAsInt("NOTIFY_EMAIL_CONCURRENCY", 5),
+		NotifyWAConcurrency:     getEnvAsInt("NOTIFY_WA_CONCURRENCY", 2),
+		NotifyPushConcurrency:   getEnvAsInt("NOTIFY_PUSH_CONCURRENCY", 10),
+		NotifyRetryHours:        getEnvAsInt("NOTIFY_RETRY_HOURS", 24),
+		NotifyBreakerThreshold:  getEnvAsInt("NOTIFY_BREAKER_THRESHOLD", 5),
+		NotifyBreakerCooldown:   getEnvAsInt("NOTIFY_BREAKER_COOLDOWN", 60),
+		SendReceipt:             getEnvAsBool("SEND_RECEIPT", true),
+		ProrationEnabled:        getEnvAsBool("PRORATION_ENABLED", false),
 		WAProviderURL:           getEnv("WA_PROVIDER_URL", "https://api.fonnte.com/send"),
 		WAApiKey:                getEnv("WA_API_KEY", ""),
 		FirebaseCredentialsFile: getEnv("FIREBASE_CREDENTIALS_FILE", "firebase-service-account.json"),
@@ -70,6 +189,62 @@ func Load() *Config {
 	}
 }
 
+// Default low optical signal templates. Placeholders: {name} (customer),
+// {serial}, {device} (alias), {level} (warning or critical), {rx}, {previous}
+// and {threshold} (dBm).
+const (
+	DefaultOpticalAlertCustomerMsg = "*Gangguan Sinyal - GO-ACS*\n\nHalo {name},\nKoneksi internet Anda mungkin tidak stabil karena sinyal optik melemah. Teknisi kami sudah diberitahu dan akan segera menangani.\nTerima kasih."
+	DefaultOpticalAlertOperatorMsg = "⚠️ <b>Sinyal optik rendah ({level})</b>\nPerangkat: {device} ({serial})\nPelanggan: {name}\nRX: {rx} dBm (sebelumnya {previous} dBm, batas {threshold} dBm)"
+)
+
+// RXThresholds returns the configured optical RX power quality bands
+func (c *Config) RXThresholds() models.RXThresholds {
+	return models.RXThresholds{
+		Excellent: c.RXExcellentDBm,
+		Good:      c.RXGoodDBm,
+		Warning:   c.RXWarningDBm,
+		Overload:  c.RXOverloadDBm,
+	}
+}
+
+// LoadTimezone resolves Timezone into Location, which invoice periods, due
+// dates and scheduler boundaries are computed in. An empty Timezone leaves the
+// server's local time in use.
+func (c *Config) LoadTimezone() error {
+	if c.Timezone == "" {
+		c.Location = nil
+		return nil
+	}
+	loc, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return fmt.Errorf("invalid timezone %q: %v", c.Timezone, err)
+	}
+	c.Location = loc
+	return nil
+}
+
+// Loc returns the ISP's timezone, or the server's local time when none is set
+func (c *Config) Loc() *time.Location {
+	if c == nil || c.Location == nil {
+		return time.Local
+	}
+	return c.Location
+}
+
+// Now returns the current time in the ISP's timezone
+func (c *Config) Now() time.Time {
+	return time.Now().In(c.Loc())
+}
+
+// Currency returns the configured currency rounding and display settings
+func (c *Config) Currency() models.Currency {
+	return models.Currency{
+		Symbol:   c.CurrencySymbol,
+		Decimals: c.CurrencyDecimals,
+		Rounding: c.AmountRounding,
+	}
+}
+
 // Helper functions for environment variables
 func getEnv(key, defaultValue string) string {
 	if value, exists := os.LookupEnv(key); exists {
@@ -101,6 +276,15 @@ func getEnvAsInt(key string, defaultValue int) int {
 	return defaultValue
 }
 
+func getEnvAsFloat(key string, defaultValue float64) float64 {
+	if value, exists := os.LookupEnv(key); exists {
+		if floatValue, err := strconv.ParseFloat(value, 64); err == nil {
+			return floatValue
+		}
+	}
+	return defaultValue
+}
+
 func getEnvAsBool(key string, defaultValue bool) bool {
 	if value, exists := os.LookupEnv(key); exists {
 		switch value {
@@ -0,0 +1,52 @@
+package database
+
+import (
+	"testing"
+
+	"go-acs/internal/models"
+)
+
+func TestDeviceAliasFormats(t *testing.T) {
+	db := newTestDB(t)
+	device, err := db.CreateDevice(&models.Device{SerialNumber: "ZTEG1234", Manufacturer: "ZTE", Template: "budi@isp"})
+	if err != nil {
+		t.Fatalf("CreateDevice: %v", err)
+	}
+	bare, err := db.CreateDevice(&models.Device{SerialNumber: "HWTC5678", Manufacturer: "Huawei"})
+	if err != nil {
+		t.Fatalf("CreateDevice: %v", err)
+	}
+
+	// Before the device is assigned the customer format falls back to the serial
+	if got := db.DeviceAlias(device.ID, AliasCustomer); got != "ZTEG1234" {
+		t.Errorf("unassigned alias = %q, want the serial", got)
+	}
+
+	res, err := db.Exec(`INSERT INTO customers (customer_code, name, status) VALUES ('C001', 'Budi Santoso', 'active')`)
+	if err != nil {
+		t.Fatalf("insert customer: %v", err)
+	}
+	customerID, _ := res.LastInsertId()
+	if err := db.LinkDeviceToCustomer(device.ID, customerID); err != nil {
+		t.Fatalf("LinkDeviceToCustomer: %v", err)
+	}
+
+	for _, tc := range []struct {
+		id     int64
+		format string
+		want   string
+	}{
+		{device.ID, AliasCustomer, "Budi Santoso (ZTEG1234)"},
+		{device.ID, "", "Budi Santoso (ZTEG1234)"},
+		{device.ID, AliasPPPoE, "budi@isp"},
+		{device.ID, AliasSerial, "ZTEG1234"},
+		{bare.ID, AliasCustomer, "HWTC5678"},
+		{bare.ID, AliasPPPoE, "HWTC5678"},
+		{9999, AliasCustomer, ""},
+		{0, AliasSerial, ""},
+	} {
+		if got := db.DeviceAlias(tc.id, tc.format); got != tc.want {
+			t.Errorf("DeviceAlias(%d, %q) = %q, want %q", tc.id, tc.format, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package database
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go-acs/internal/models"
+)
+
+// createTestInvoice inserts a pending 100000 invoice for a new customer
+func createTestInvoice(t *testing.T, db *DB, no string) *models.Invoice {
+	t.Helper()
+	res, err := db.Exec(`INSERT INTO customers (customer_code, name, status) VALUES (?, 'Customer', 'active')`, "C-"+no)
+	if err != nil {
+		t.Fatalf("insert customer: %v", err)
+	}
+	customerID, _ := res.LastInsertId()
+	due := time.Now().AddDate(0, 0, 7)
+	res, err = db.Exec(`INSERT INTO invoices (invoice_no, customer_id, period_start, period_end, due_date, subtotal, total, status, paid_amount)
+		VALUES (?, ?, ?, ?, ?, 100000, 100000, 'pending', 0)`, no, customerID, due.AddDate(0, -1, 0), due, due)
+	if err != nil {
+		t.Fatalf("insert invoice: %v", err)
+	}
+	id, _ := res.LastInsertId()
+	invoice, err := db.GetInvoice(id)
+	if err != nil {
+		t.Fatalf("GetInvoice: %v", err)
+	}
+	return invoice
+}
+
+// failPaymentInserts makes every payment insert fail, as a crash or full disk
+// would after the invoice row was already updated
+func failPaymentInserts(t *testing.T, db *DB) {
+	t.Helper()
+	if _, err := db.Exec(`CREATE TRIGGER fail_payments BEFORE INSERT ON payments BEGIN SELECT RAISE(ABORT, 'disk I/O error'); END`); err != nil {
+		t.Fatalf("create trigger: %v", err)
+	}
+}
+
+func assertUnpaid(t *testing.T, db *DB, invoice *models.Invoice) {
+	t.Helper()
+	got, err := db.GetInvoice(invoice.ID)
+	if err != nil {
+		t.Fatalf("GetInvoice: %v", err)
+	}
+	if got.Status != models.InvoicePending || got.PaidAmount != 0 || got.PaidAt != nil {
+		t.Fatalf("invoice after failed payment: status %s, paid %v at %v", got.Status, got.PaidAmount, got.PaidAt)
+	}
+	var payments int
+	db.QueryRow(`SELECT COUNT(*) FROM payments WHERE invoice_id = ?`, invoice.ID).Scan(&payments)
+	if payments != 0 {
+		t.Fatalf("%d payments recorded", payments)
+	}
+}
+
+func TestAddInvoicePaymentRollsBackWhenThePaymentFails(t *testing.T) {
+	db := newTestDB(t)
+	invoice := createTestInvoice(t, db, "INV-1")
+	failPaymentInserts(t, db)
+
+	_, err := db.AddInvoicePayment(invoice.ID, &models.Payment{CustomerID: invoice.CustomerID, Amount: 100000, Status: "completed", PaymentDate: time.Now()})
+	if err == nil {
+		t.Fatal("expected the payment insert to fail")
+	}
+	assertUnpaid(t, db, invoice)
+}
+
+func TestParallelPaymentsGetDistinctNumbers(t *testing.T) {
+	db, err := InitDB(filepath.Join(t.TempDir(), "acs.db"), Options{MaxOpenConns: 8, BusyTimeoutMs: 5000})
+	if err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	invoice := createTestInvoice(t, db, "INV-1")
+
+	// A number issued before the sequence existed is not handed out again
+	prefix := "PAY-" + time.Now().Format("200601") + "-"
+	if _, err := db.CreatePayment(&models.Payment{PaymentNo: prefix + "0003", CustomerID: invoice.CustomerID, Amount: 1}); err != nil {
+		t.Fatalf("CreatePayment: %v", err)
+	}
+
+	const workers, perWorker = 10, 5
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*perWorker)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < perWorker; j++ {
+				if _, err := db.CreatePayment(&models.Payment{CustomerID: invoice.CustomerID, Amount: 1000, Status: "completed"}); err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("parallel CreatePayment: %v", err)
+	}
+
+	rows, err := db.Query(`SELECT payment_no FROM payments`)
+	if err != nil {
+		t.Fatalf("list payments: %v", err)
+	}
+	defer rows.Close()
+	seen := map[string]bool{}
+	for rows.Next() {
+		var no string
+		rows.Scan(&no)
+		if seen[no] {
+			t.Errorf("duplicate payment number %s", no)
+		}
+		seen[no] = true
+		if !strings.HasPrefix(no, prefix) {
+			t.Errorf("payment number %s, want the %s prefix", no, prefix)
+		}
+	}
+	if len(seen) != workers*perWorker+1 {
+		t.Fatalf("%d distinct payment numbers, want %d", len(seen), workers*perWorker+1)
+	}
+	for n := 4; n < 4+workers*perWorker; n++ {
+		if no := fmt.Sprintf("%s%04d", prefix, n); !seen[no] {
+			t.Errorf("%s was skipped", no)
+		}
+	}
+}
@@ -3,48 +3,134 @@ package database
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"go-acs/internal/models"
 
 	"golang.org/x/crypto/bcrypt"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 )
 
-// DB wraps the database connection
+// DB wraps the database connection. Exec and transactions go through the
+// embedded single writer connection; Query and QueryRow outside a transaction
+// use a separate read-only pool, so reads never wait on the write lock.
 type DB struct {
 	*sql.DB
+	reader *sql.DB
+	maxPendingTasks atomic.Int64 // Per-device cap on pending tasks; 0 = unlimited
+
+	// OnStatusChange, when set, is called after UpdateDeviceStatus commits
+	// a status transition
+	OnStatusChange func(deviceID int64, previous, current models.DeviceStatus)
+
+	aliasCache sync.Map // device ID -> deviceAliasEntry
 }
 
-// InitDB initializes the database connection and creates tables
-func InitDB(dbPath string) (*DB, error) {
+// Options selects the database engine and tunes its connection pools
+type Options struct {
+	Driver          string // database engine, see DialectFor; empty = sqlite
+	MaxOpenConns    int    // size of the read pool; writes always use one connection
+	MaxIdleConns    int    // idle connections kept in the read pool
+	BusyTimeoutMs   int    // how long SQLite waits on a locked database before returning SQLITE_BUSY
+	MaxPendingTasks int    // per-device pending task cap, see DB.MaxPendingTasks
+}
+
+// ErrUnsupportedDriver is returned by InitDB for a database engine without
+// a dialect
+var ErrUnsupportedDriver = errors.New("unsupported database driver")
+
+// Dialect opens the connections for one database engine. The queries in this
+// package are written for SQLite, so it is the only dialect; another engine
+// needs a dialect of its own and a port of those queries and migrations.
+type Dialect interface {
+	// Open returns the writer connection and the read pool for url
+	Open(url string, opts Options) (writer, reader *sql.DB, err error)
+}
+
+// DialectFor returns the dialect selected by DB_DRIVER. PostgreSQL and MySQL
+// are recognised but not supported yet.
+func DialectFor(driver string) (Dialect, error) {
+	switch strings.ToLower(strings.TrimSpace(driver)) {
+	case "", "sqlite", "sqlite3":
+		return sqliteDialect{}, nil
+	case "postgres", "postgresql", "mysql":
+		return nil, fmt.Errorf("%w: %s is not supported yet, use sqlite", ErrUnsupportedDriver, driver)
+	default:
+		return nil, fmt.Errorf("%w %q", ErrUnsupportedDriver, driver)
+	}
+}
+
+// sqliteDialect opens a SQLite file
+type sqliteDialect struct{}
+
+func (sqliteDialect) Open(dbPath string, opts Options) (*sql.DB, *sql.DB, error) {
+	if strings.Contains(dbPath, "://") && !strings.HasPrefix(dbPath, "file:") {
+		return nil, nil, fmt.Errorf("DATABASE_URL %q is not a path to a SQLite file", dbPath)
+	}
+
 	// Ensure directory exists
 	dir := filepath.Dir(dbPath)
 	if err := os.MkdirAll(dir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create data directory: %v", err)
+		return nil, nil, fmt.Errorf("failed to create data directory: %v", err)
 	}
 
-	db, err := sql.Open("sqlite3", dbPath+"?_foreign_keys=on&_journal_mode=WAL")
+	// SQLite allows one writer at a time, so writes share a single connection
+	// and queue in the pool instead of contending for the lock. _txlock=immediate
+	// takes the write lock at BEGIN, so a transaction never fails upgrading a
+	// read lock.
+	dsn := fmt.Sprintf("%s?_foreign_keys=on&_journal_mode=WAL&_busy_timeout=%d&_txlock=immediate", dbPath, opts.BusyTimeoutMs)
+	db, err := sql.Open("sqlite3", dsn)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open database: %v", err)
+		return nil, nil, fmt.Errorf("failed to open database: %v", err)
 	}
 
 	// Test connection
 	if err := db.Ping(); err != nil {
-		return nil, fmt.Errorf("failed to ping database: %v", err)
+		return nil, nil, fmt.Errorf("failed to ping database: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+
+	// Reads use their own pool with deferred locking; with WAL they run
+	// alongside the writer
+	readerDSN := fmt.Sprintf("%s?_foreign_keys=on&_busy_timeout=%d&_txlock=deferred&_query_only=true", dbPath, opts.BusyTimeoutMs)
+	reader, err := sql.Open("sqlite3", readerDSN)
+	if err != nil {
+		db.Close()
+		return nil, nil, fmt.Errorf("failed to open database: %v", err)
+	}
+	if opts.MaxOpenConns > 0 {
+		reader.SetMaxOpenConns(opts.MaxOpenConns)
 	}
+	if opts.MaxIdleConns > 0 {
+		reader.SetMaxIdleConns(opts.MaxIdleConns)
+	}
+	reader.SetConnMaxLifetime(5 * time.Minute)
+	return db, reader, nil
+}
 
-	// Set connection pool settings
-	db.SetMaxOpenConns(25)
-	db.SetMaxIdleConns(5)
-	db.SetConnMaxLifetime(5 * time.Minute)
+// InitDB initializes the database connection and creates tables
+func InitDB(dbPath string, opts Options) (*DB, error) {
+	dialect, err := DialectFor(opts.Driver)
+	if err != nil {
+		return nil, err
+	}
+	db, reader, err := dialect.Open(dbPath, opts)
+	if err != nil {
+		return nil, err
+	}
 
-	wrapper := &DB{db}
+	wrapper := &DB{DB: db, reader: reader}
+	wrapper.SetMaxPendingTasks(opts.MaxPendingTasks)
 
 	// Create tables
 	if err := wrapper.createTables(); err != nil {
@@ -54,6 +140,10 @@ func InitDB(dbPath string) (*DB, error) {
 	// Auto-migrations
 	wrapper.checkAndMigrateDevicesTable()
 	wrapper.checkAndMigrateCustomersTable()
+	wrapper.checkAndMigratePackagesTable()
+	wrapper.checkAndMigrateTasksTable()
+	wrapper.checkAndMigrateTicketsTable()
+	wrapper.checkAndMigrateSettings()
 
 	// Migrate customer passwords to bcrypt
 	if err := wrapper.MigrateCustomerPasswords(); err != nil {
@@ -66,6 +156,84 @@ func InitDB(dbPath string) (*DB, error) {
 	return wrapper, nil
 }
 
+// Query runs a read-only query on the read pool
+func (db *DB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return db.reader.Query(query, args...)
+}
+
+// QueryRow runs a read-only single-row query on the read pool
+func (db *DB) QueryRow(query string, args ...interface{}) *sql.Row {
+	return db.reader.QueryRow(query, args...)
+}
+
+// Close closes the read pool and the writer connection
+func (db *DB) Close() error {
+	readErr := db.reader.Close()
+	if err := db.DB.Close(); err != nil {
+		return err
+	}
+	return readErr
+}
+
+// WithTx runs fn inside a transaction, committing on success. If SQLite reports
+// the database as busy or locked the whole transaction is retried with backoff.
+func (db *DB) WithTx(fn func(tx *sql.Tx) error) error {
+	const maxAttempts = 5
+	var err error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		err = db.runTx(fn)
+		if err == nil || !isBusyError(err) {
+			return err
+		}
+		time.Sleep(time.Duration(attempt*50) * time.Millisecond)
+	}
+	return err
+}
+
+func (db *DB) runTx(fn func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// isBusyError reports whether err is SQLITE_BUSY or SQLITE_LOCKED
+func isBusyError(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
+	}
+	return false
+}
+
+// isUniqueError reports whether err is a UNIQUE constraint violation
+func isUniqueError(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+	}
+	return false
+}
+
+// nextSequence atomically increments the named counter and returns its new
+// value. A new counter starts after seed, so numbers issued before the
+// counter existed are not handed out again.
+func nextSequence(q querier, name string, seed int64) (int64, error) {
+	var n int64
+	err := q.QueryRow(`
+		INSERT INTO number_sequences (name, value) VALUES (?, ? + 1)
+		ON CONFLICT(name) DO UPDATE SET value = value + 1
+		RETURNING value
+	`, name, seed).Scan(&n)
+	return n, err
+}
+
 func (db *DB) checkAndMigrateDevicesTable() {
 	var count int
 
@@ -125,6 +293,42 @@ func (db *DB) checkAndMigrateDevicesTable() {
 		fmt.Println("[DB] Migrating: adding temperature")
 		db.Exec("ALTER TABLE devices ADD COLUMN temperature REAL DEFAULT 0")
 	}
+
+	// Columns: cwmp_username, cwmp_password (per-device inform authentication)
+	db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('devices') WHERE name='cwmp_username'").Scan(&count)
+	if count == 0 {
+		fmt.Println("[DB] Migrating: adding cwmp_username, cwmp_password")
+		db.Exec("ALTER TABLE devices ADD COLUMN cwmp_username TEXT")
+		db.Exec("ALTER TABLE devices ADD COLUMN cwmp_password TEXT")
+	}
+
+	// Column: lifecycle_state (stock, deployed, retired)
+	db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('devices') WHERE name='lifecycle_state'").Scan(&count)
+	if count == 0 {
+		fmt.Println("[DB] Migrating: adding lifecycle_state")
+		db.Exec("ALTER TABLE devices ADD COLUMN lifecycle_state TEXT DEFAULT 'deployed'")
+	}
+
+	// Column: optical_alert (current RX power alert level, see models.OpticalAlertLevel)
+	db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('devices') WHERE name='optical_alert'").Scan(&count)
+	if count == 0 {
+		fmt.Println("[DB] Migrating: adding optical_alert")
+		db.Exec("ALTER TABLE devices ADD COLUMN optical_alert TEXT DEFAULT ''")
+	}
+
+	// Column: client_alert (current WiFi client limit alert level, see models.ClientLimitLevel)
+	db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('devices') WHERE name='client_alert'").Scan(&count)
+	if count == 0 {
+		fmt.Println("[DB] Migrating: adding client_alert")
+		db.Exec("ALTER TABLE devices ADD COLUMN client_alert TEXT DEFAULT ''")
+	}
+
+	// Column: label (display name, see models.DeviceLabel)
+	db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('devices') WHERE name='label'").Scan(&count)
+	if count == 0 {
+		fmt.Println("[DB] Migrating: adding label")
+		db.Exec("ALTER TABLE devices ADD COLUMN label TEXT DEFAULT ''")
+	}
 }
 
 func (db *DB) checkAndMigrateCustomersTable() {
@@ -136,6 +340,119 @@ func (db *DB) checkAndMigrateCustomersTable() {
 			fmt.Printf("[DB] Error adding fcm_token column: %v\n", err)
 		}
 	}
+
+	db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('customers') WHERE name='notify_receipts'").Scan(&count)
+	if count == 0 {
+		fmt.Println("[DB] Migrating customers table: adding notify_receipts column")
+		if _, err := db.Exec("ALTER TABLE customers ADD COLUMN notify_receipts BOOLEAN DEFAULT 1"); err != nil {
+			fmt.Printf("[DB] Error adding notify_receipts column: %v\n", err)
+		}
+	}
+
+	db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('customers') WHERE name='discount_percent'").Scan(&count)
+	if count == 0 {
+		fmt.Println("[DB] Migrating customers table: adding discount_percent column")
+		if _, err := db.Exec("ALTER TABLE customers ADD COLUMN discount_percent REAL DEFAULT 0"); err != nil {
+			fmt.Printf("[DB] Error adding discount_percent column: %v\n", err)
+		}
+	}
+}
+
+func (db *DB) checkAndMigratePackagesTable() {
+	columns := []struct{ name, def string }{
+		{"vlan", "INTEGER DEFAULT 0"},
+		{"discount_percent", "REAL DEFAULT 0"},
+	}
+	for _, col := range columns {
+		var count int
+		db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('packages') WHERE name=?", col.name).Scan(&count)
+		if count == 0 {
+			fmt.Printf("[DB] Migrating packages table: adding %s column\n", col.name)
+			if _, err := db.Exec("ALTER TABLE packages ADD COLUMN " + col.name + " " + col.def); err != nil {
+				fmt.Printf("[DB] Error adding %s column: %v\n", col.name, err)
+			}
+		}
+	}
+}
+
+func (db *DB) checkAndMigrateTicketsTable() {
+	columns := []struct{ name, def string }{
+		{"rating", "INTEGER"},
+		{"rating_comment", "TEXT"},
+		{"rated_at", "DATETIME"},
+		{"close_warned_at", "DATETIME"},
+	}
+	for _, col := range columns {
+		var count int
+		db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('support_tickets') WHERE name=?", col.name).Scan(&count)
+		if count == 0 {
+			fmt.Printf("[DB] Migrating support_tickets table: adding %s column\n", col.name)
+			if _, err := db.Exec("ALTER TABLE support_tickets ADD COLUMN " + col.name + " " + col.def); err != nil {
+				fmt.Printf("[DB] Error adding %s column: %v\n", col.name, err)
+			}
+		}
+	}
+}
+
+// checkAndMigrateSettings moves retired setting keys to their replacements.
+// The optical alert thresholds are the RX quality bands now: alerts fire
+// below rx_good_dbm (warning) and rx_warning_dbm (critical). An existing
+// value of the new key is kept.
+func (db *DB) checkAndMigrateSettings() {
+	for _, m := range []struct{ from, to string }{
+		{"rx_power_warn", "rx_good_dbm"},
+		{"optical_alert_dbm", "rx_good_dbm"},
+		{"rx_power_critical", "rx_warning_dbm"},
+	} {
+		var value string
+		if err := db.QueryRow("SELECT COALESCE(value, '') FROM settings WHERE key = ?", m.from).Scan(&value); err != nil {
+			continue
+		}
+		fmt.Printf("[DB] Migrating setting %s to %s\n", m.from, m.to)
+		if value != "" && value != "0" {
+			db.Exec("INSERT OR IGNORE INTO settings (key, value) VALUES (?, ?)", m.to, value)
+		}
+		db.Exec("DELETE FROM settings WHERE key = ?", m.from)
+	}
+}
+
+func (db *DB) checkAndMigrateTasksTable() {
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('tasks') WHERE name='priority'").Scan(&count)
+	if count == 0 {
+		fmt.Println("[DB] Migrating tasks table: adding priority column")
+		if _, err := db.Exec("ALTER TABLE tasks ADD COLUMN priority INTEGER DEFAULT 5"); err != nil {
+			fmt.Printf("[DB] Error adding priority column: %v\n", err)
+		}
+	}
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_tasks_pending ON tasks(device_id, status, priority)")
+
+	db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('tasks') WHERE name='previous_values'").Scan(&count)
+	if count == 0 {
+		fmt.Println("[DB] Migrating tasks table: adding previous_values column")
+		if _, err := db.Exec("ALTER TABLE tasks ADD COLUMN previous_values TEXT"); err != nil {
+			fmt.Printf("[DB] Error adding previous_values column: %v\n", err)
+		}
+	}
+
+	db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('tasks') WHERE name='retry_count'").Scan(&count)
+	if count == 0 {
+		fmt.Println("[DB] Migrating tasks table: adding retry_count column")
+		if _, err := db.Exec("ALTER TABLE tasks ADD COLUMN retry_count INTEGER DEFAULT 0"); err != nil {
+			fmt.Printf("[DB] Error adding retry_count column: %v\n", err)
+		}
+	}
+
+	db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('tasks') WHERE name='verification'").Scan(&count)
+	if count == 0 {
+		fmt.Println("[DB] Migrating tasks table: adding verification, verify_task_id columns")
+		if _, err := db.Exec("ALTER TABLE tasks ADD COLUMN verification TEXT DEFAULT ''"); err != nil {
+			fmt.Printf("[DB] Error adding verification column: %v\n", err)
+		}
+		if _, err := db.Exec("ALTER TABLE tasks ADD COLUMN verify_task_id INTEGER"); err != nil {
+			fmt.Printf("[DB] Error adding verify_task_id column: %v\n", err)
+		}
+	}
 }
 
 func (db *DB) createTables() error {
@@ -168,6 +485,9 @@ func (db *DB) createTables() error {
 			address TEXT,
 			customer_id INTEGER,
 			temperature REAL DEFAULT 0,
+			cwmp_username TEXT,
+			cwmp_password TEXT,
+			lifecycle_state TEXT DEFAULT 'deployed',
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
 		)`,
@@ -185,6 +505,96 @@ func (db *DB) createTables() error {
 			UNIQUE(device_id, path)
 		)`,
 
+		// Changes of stored parameter values (first-seen values are not recorded)
+		`CREATE TABLE IF NOT EXISTS parameter_history (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			device_id INTEGER NOT NULL,
+			path TEXT NOT NULL,
+			old_value TEXT,
+			new_value TEXT,
+			changed_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (device_id) REFERENCES devices(id) ON DELETE CASCADE
+		)`,
+
+		// Parameters whose changes are notified
+		`CREATE TABLE IF NOT EXISTS parameter_watches (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			device_id INTEGER NOT NULL,
+			path TEXT NOT NULL,
+			label TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (device_id) REFERENCES devices(id) ON DELETE CASCADE,
+			UNIQUE(device_id, path)
+		)`,
+
+		// Recurring reboots of one device or of every device with a tag
+		`CREATE TABLE IF NOT EXISTS reboot_schedules (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			device_id INTEGER,
+			tag TEXT,
+			spec TEXT NOT NULL,
+			enabled INTEGER DEFAULT 1,
+			last_run_at DATETIME,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (device_id) REFERENCES devices(id) ON DELETE CASCADE
+		)`,
+
+		// Multi-WAN provisioning templates
+		`CREATE TABLE IF NOT EXISTS wan_templates (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT UNIQUE NOT NULL,
+			description TEXT,
+			connections TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+
+		// Firmware repository used for update-availability checks
+		`CREATE TABLE IF NOT EXISTS firmware (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			manufacturer TEXT NOT NULL,
+			product_class TEXT NOT NULL DEFAULT '',
+			version TEXT NOT NULL,
+			url TEXT NOT NULL,
+			release_notes TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(manufacturer, product_class, version)
+		)`,
+
+		// Bulk firmware upgrades and the batch each device belongs to
+		`CREATE TABLE IF NOT EXISTS firmware_upgrades (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			batch_id TEXT NOT NULL UNIQUE,
+			url TEXT NOT NULL,
+			parameters TEXT NOT NULL,
+			delay_seconds INTEGER NOT NULL,
+			batches INTEGER NOT NULL,
+			next_batch INTEGER NOT NULL DEFAULT 1,
+			next_run_at DATETIME,
+			user_id INTEGER,
+			created_by TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS firmware_upgrade_devices (
+			upgrade_id INTEGER NOT NULL,
+			device_id INTEGER NOT NULL,
+			batch INTEGER NOT NULL,
+			task_id INTEGER,
+			PRIMARY KEY (upgrade_id, device_id),
+			FOREIGN KEY (upgrade_id) REFERENCES firmware_upgrades(id) ON DELETE CASCADE
+		)`,
+
+		// Pinned (favorite) parameters per device model
+		`CREATE TABLE IF NOT EXISTS pinned_parameters (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			model_name TEXT NOT NULL DEFAULT '',
+			path TEXT NOT NULL,
+			label TEXT,
+			position INTEGER DEFAULT 0,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(model_name, path)
+		)`,
+
 		// WAN configurations table
 		`CREATE TABLE IF NOT EXISTS wan_configs (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -218,6 +628,7 @@ func (db *DB) createTables() error {
 			type TEXT NOT NULL,
 			status TEXT DEFAULT 'pending',
 			parameters TEXT,
+			priority INTEGER DEFAULT 5,
 			result TEXT,
 			error TEXT,
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
@@ -240,6 +651,15 @@ func (db *DB) createTables() error {
 			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
 		)`,
 
+		// Tags table
+		`CREATE TABLE IF NOT EXISTS tags (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT UNIQUE NOT NULL,
+			color TEXT,
+			description TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+
 		// Logs table
 		`CREATE TABLE IF NOT EXISTS logs (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -263,6 +683,34 @@ func (db *DB) createTables() error {
 		)`,
 		`CREATE INDEX IF NOT EXISTS idx_bandwidth_device_time ON bandwidth_usage(device_id, timestamp)`,
 
+		// LAN clients seen per device, one row per MAC
+		`CREATE TABLE IF NOT EXISTS client_history (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			device_id INTEGER NOT NULL,
+			mac TEXT NOT NULL,
+			hostname TEXT,
+			ip_address TEXT,
+			interface TEXT,
+			first_seen DATETIME NOT NULL,
+			last_seen DATETIME NOT NULL,
+			FOREIGN KEY (device_id) REFERENCES devices(id) ON DELETE CASCADE,
+			UNIQUE(device_id, mac)
+		)`,
+
+		// Optical signal samples for trend graphs
+		`CREATE TABLE IF NOT EXISTS optical_history (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			device_id INTEGER NOT NULL,
+			rx_power REAL,
+			tx_power REAL,
+			temperature REAL,
+			voltage REAL,
+			bias_current REAL,
+			recorded_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (device_id) REFERENCES devices(id) ON DELETE CASCADE
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_optical_history_device_time ON optical_history(device_id, recorded_at)`,
+
 		// Device Logs table (Uptime Tracking)
 		`CREATE TABLE IF NOT EXISTS device_logs (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -307,6 +755,8 @@ func (db *DB) createTables() error {
 			quota INTEGER DEFAULT 0,
 			price REAL DEFAULT 0,
 			setup_fee REAL DEFAULT 0,
+			vlan INTEGER DEFAULT 0,
+			discount_percent REAL DEFAULT 0,
 			is_active BOOLEAN DEFAULT 1,
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
@@ -422,6 +872,7 @@ func (db *DB) createTables() error {
 		`CREATE INDEX IF NOT EXISTS idx_devices_status_last_contact ON devices(status, last_contact)`,
 		`CREATE INDEX IF NOT EXISTS idx_device_parameters_device ON device_parameters(device_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_device_parameters_path ON device_parameters(path)`,
+		`CREATE INDEX IF NOT EXISTS idx_parameter_history_device_path ON parameter_history(device_id, path, changed_at)`,
 		`CREATE INDEX IF NOT EXISTS idx_tasks_device ON tasks(device_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_tasks_status ON tasks(status)`,
 		`CREATE INDEX IF NOT EXISTS idx_logs_device ON logs(device_id)`,
@@ -435,6 +886,184 @@ func (db *DB) createTables() error {
 		`CREATE INDEX IF NOT EXISTS idx_payments_customer ON payments(customer_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_payments_date ON payments(payment_date)`,
 
+		// Inform event codes (why a device contacted the ACS)
+		`CREATE TABLE IF NOT EXISTS inform_events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			device_id INTEGER NOT NULL,
+			event_codes TEXT,
+			command_keys TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (device_id) REFERENCES devices(id) ON DELETE CASCADE
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_inform_events_device ON inform_events(device_id, id)`,
+
+		// Manual device commands with the admin who issued them
+		`CREATE TABLE IF NOT EXISTS device_commands (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			device_id INTEGER NOT NULL,
+			command TEXT NOT NULL,
+			task_id INTEGER,
+			user_id INTEGER,
+			username TEXT,
+			details TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (device_id) REFERENCES devices(id) ON DELETE CASCADE
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_device_commands_device ON device_commands(device_id, id)`,
+
+		// Admin audit trail: every state-changing API request
+		`CREATE TABLE IF NOT EXISTS audit_log (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER,
+			username TEXT NOT NULL,
+			action TEXT NOT NULL,
+			target_type TEXT,
+			target_id TEXT,
+			ip TEXT,
+			status INTEGER,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_log_created ON audit_log(created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_log_username ON audit_log(username)`,
+
+		// Payment gateway callback events (retry / dead-letter)
+		`CREATE TABLE IF NOT EXISTS callback_events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			gateway TEXT NOT NULL,
+			reference TEXT,
+			invoice_no TEXT,
+			status TEXT,
+			payload TEXT,
+			process_status TEXT DEFAULT 'received',
+			attempts INTEGER DEFAULT 0,
+			last_error TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_callback_events_status ON callback_events(process_status)`,
+		`CREATE INDEX IF NOT EXISTS idx_callback_events_reference ON callback_events(gateway, reference)`,
+
+		// Customer notifications queued for delivery and retried while a
+		// provider (SMTP, WhatsApp, FCM) is down
+		`CREATE TABLE IF NOT EXISTS notification_outbox (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			channel TEXT NOT NULL,
+			recipient TEXT NOT NULL,
+			subject TEXT,
+			body TEXT NOT NULL,
+			status TEXT DEFAULT 'pending',
+			attempts INTEGER DEFAULT 0,
+			last_error TEXT,
+			next_attempt_at DATETIME,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			sent_at DATETIME
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_notification_outbox_due ON notification_outbox(status, next_attempt_at)`,
+		// Sends cut short by a restart are retried
+		`UPDATE notification_outbox SET status = 'pending' WHERE status = 'sending'`,
+
+		// Per-model parameter poll profiles
+		`CREATE TABLE IF NOT EXISTS poll_profiles (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			manufacturer TEXT NOT NULL,
+			model_name TEXT,
+			paths TEXT,
+			enabled BOOLEAN DEFAULT 1,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+
+		// Suspension events (one per suspend/reactivate cycle; dedupes notices)
+		`CREATE TABLE IF NOT EXISTS suspension_events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			customer_id INTEGER NOT NULL,
+			reason TEXT,
+			suspended_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			notified_at DATETIME,
+			reactivated_at DATETIME,
+			FOREIGN KEY (customer_id) REFERENCES customers(id) ON DELETE CASCADE
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_suspension_events_open ON suspension_events(customer_id, reactivated_at)`,
+
+		// Vacation holds: service and billing paused until resume_on
+		`CREATE TABLE IF NOT EXISTS customer_holds (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			customer_id INTEGER NOT NULL,
+			resume_on TEXT NOT NULL,
+			suspend_connection BOOLEAN DEFAULT 0,
+			reason TEXT,
+			created_by TEXT,
+			started_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			resumed_at DATETIME,
+			FOREIGN KEY (customer_id) REFERENCES customers(id) ON DELETE CASCADE
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_customer_holds_open ON customer_holds(resumed_at, resume_on)`,
+
+		// Invoice due-date reminders already sent (one per invoice and day offset)
+		`CREATE TABLE IF NOT EXISTS invoice_reminders (
+			invoice_id INTEGER NOT NULL,
+			days_before INTEGER NOT NULL,
+			sent_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (invoice_id, days_before),
+			FOREIGN KEY (invoice_id) REFERENCES invoices(id) ON DELETE CASCADE
+		)`,
+
+		// Technicians eligible for ticket auto-assignment
+		`CREATE TABLE IF NOT EXISTS technicians (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER UNIQUE NOT NULL,
+			areas TEXT,
+			skills TEXT,
+			available BOOLEAN DEFAULT 1,
+			last_assigned_at DATETIME,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`,
+
+		// Customer portal announcements (maintenance notices)
+		`CREATE TABLE IF NOT EXISTS announcements (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			title TEXT NOT NULL,
+			message TEXT,
+			area TEXT,
+			starts_at DATETIME NOT NULL,
+			ends_at DATETIME,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+
+		// Canary provisioning rollouts and the devices in each phase
+		`CREATE TABLE IF NOT EXISTS rollouts (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			parameters TEXT NOT NULL,
+			filter TEXT,
+			canary_percent INTEGER NOT NULL,
+			wait_minutes INTEGER NOT NULL,
+			max_failure_percent REAL NOT NULL,
+			status TEXT NOT NULL DEFAULT 'canary',
+			reason TEXT,
+			created_by TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			canary_until DATETIME NOT NULL,
+			decided_at DATETIME
+		)`,
+		`CREATE TABLE IF NOT EXISTS rollout_devices (
+			rollout_id INTEGER NOT NULL,
+			device_id INTEGER NOT NULL,
+			phase TEXT NOT NULL,
+			task_id INTEGER,
+			was_online INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (rollout_id, device_id),
+			FOREIGN KEY (rollout_id) REFERENCES rollouts(id) ON DELETE CASCADE
+		)`,
+
+		// Atomic counters for document numbers (e.g. PAY-YYYYMM-NNNN)
+		`CREATE TABLE IF NOT EXISTS number_sequences (
+			name TEXT PRIMARY KEY,
+			value INTEGER NOT NULL
+		)`,
+
 		// Settings table for application config
 		`CREATE TABLE IF NOT EXISTS settings (
 			key TEXT PRIMARY KEY,
@@ -454,8 +1083,9 @@ func (db *DB) createTables() error {
 
 // ============== Device Operations ==============
 
-// GetDevices retrieves all devices with optional filtering
-func (db *DB) GetDevices(status string, search string, limit, offset int) ([]*models.Device, int64, error) {
+// GetDevices retrieves all devices with optional filtering. tag keeps only
+// devices carrying that tag, compared case-insensitively like preset filters.
+func (db *DB) GetDevices(status string, search string, lifecycle string, tag string, limit, offset int) ([]*models.Device, int64, error) {
 	var conditions []string
 	var args []interface{}
 
@@ -464,10 +1094,21 @@ func (db *DB) GetDevices(status string, search string, limit, offset int) ([]*mo
 		args = append(args, status)
 	}
 
+	if lifecycle != "" && lifecycle != "all" {
+		conditions = append(conditions, "COALESCE(lifecycle_state, 'deployed') = ?")
+		args = append(args, lifecycle)
+	}
+
 	if search != "" {
-		conditions = append(conditions, "(serial_number LIKE ? OR manufacturer LIKE ? OR model_name LIKE ?)")
+		conditions = append(conditions, "(serial_number LIKE ? OR manufacturer LIKE ? OR model_name LIKE ? OR label LIKE ?)")
 		searchPattern := "%" + search + "%"
-		args = append(args, searchPattern, searchPattern, searchPattern)
+		args = append(args, searchPattern, searchPattern, searchPattern, searchPattern)
+	}
+
+	if tag != "" {
+		conditions = append(conditions, `EXISTS (SELECT 1 FROM json_each(CASE WHEN json_valid(devices.tags) THEN devices.tags ELSE '[]' END)
+			WHERE json_each.value = ? COLLATE NOCASE)`)
+		args = append(args, tag)
 	}
 
 	whereClause := ""
@@ -489,7 +1130,8 @@ func (db *DB) GetDevices(status string, search string, limit, offset int) ([]*mo
 			   hardware_version, software_version, connection_request, status,
 			   last_inform, last_contact, ip_address, mac_address, uptime,
 			   rx_power, client_count, template,
-			   parameters, tags, notes, created_at, updated_at, latitude, longitude, address, temperature, customer_id
+			   parameters, tags, notes, created_at, updated_at, latitude, longitude, address, temperature, customer_id,
+			   COALESCE(lifecycle_state, 'deployed'), COALESCE(label, '')
 		FROM devices %s
 		ORDER BY last_contact DESC
 		LIMIT ? OFFSET ?
@@ -521,7 +1163,8 @@ func (db *DB) GetDevicesByCustomer(customerID int64) ([]*models.Device, error) {
 			   hardware_version, software_version, connection_request, status,
 			   last_inform, last_contact, ip_address, mac_address, uptime,
 			   rx_power, client_count, template,
-			   parameters, tags, notes, created_at, updated_at, latitude, longitude, address, temperature, customer_id
+			   parameters, tags, notes, created_at, updated_at, latitude, longitude, address, temperature, customer_id,
+			   COALESCE(lifecycle_state, 'deployed'), COALESCE(label, '')
 		FROM devices WHERE customer_id = ?
 		ORDER BY last_contact DESC
 	`
@@ -542,17 +1185,49 @@ func (db *DB) GetDevicesByCustomer(customerID int64) ([]*models.Device, error) {
 	return devices, nil
 }
 
-// GetDevice retrieves a device by ID
-func (db *DB) GetDevice(id int64) (*models.Device, error) {
+// GetDevicesByModel returns the devices of a manufacturer and/or product
+// class (case-insensitive); an empty argument matches any value
+func (db *DB) GetDevicesByModel(manufacturer, productClass string) ([]*models.Device, error) {
 	query := `
 		SELECT id, serial_number, oui, product_class, manufacturer, model_name,
 			   hardware_version, software_version, connection_request, status,
 			   last_inform, last_contact, ip_address, mac_address, uptime,
 			   rx_power, client_count, template,
-			   parameters, tags, notes, created_at, updated_at, latitude, longitude, address, temperature, customer_id
-		FROM devices WHERE id = ?
+			   parameters, tags, notes, created_at, updated_at, latitude, longitude, address, temperature, customer_id,
+			   COALESCE(lifecycle_state, 'deployed'), COALESCE(label, '')
+		FROM devices
+		WHERE (? = '' OR UPPER(manufacturer) = UPPER(?)) AND (? = '' OR UPPER(product_class) = UPPER(?))
+		ORDER BY id
 	`
-	row := db.QueryRow(query, id)
+	rows, err := db.Query(query, manufacturer, manufacturer, productClass, productClass)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var devices []*models.Device
+	for rows.Next() {
+		device, err := scanDevice(rows)
+		if err != nil {
+			return nil, err
+		}
+		devices = append(devices, device)
+	}
+	return devices, rows.Err()
+}
+
+// GetDevice retrieves a device by ID
+func (db *DB) GetDevice(id int64) (*models.Device, error) {
+	query := `
+		SELECT id, serial_number, oui, product_class, manufacturer, model_name,
+			   hardware_version, software_version, connection_request, status,
+			   last_inform, last_contact, ip_address, mac_address, uptime,
+			   rx_power, client_count, template,
+			   parameters, tags, notes, created_at, updated_at, latitude, longitude, address, temperature, customer_id,
+			   COALESCE(lifecycle_state, 'deployed'), COALESCE(label, '')
+		FROM devices WHERE id = ?
+	`
+	row := db.QueryRow(query, id)
 	return scanDeviceRow(row)
 }
 
@@ -563,7 +1238,8 @@ func (db *DB) GetDeviceBySerial(serialNumber string) (*models.Device, error) {
 			   hardware_version, software_version, connection_request, status,
 			   last_inform, last_contact, ip_address, mac_address, uptime,
 			   rx_power, client_count, template,
-			   parameters, tags, notes, created_at, updated_at, latitude, longitude, address, temperature, customer_id
+			   parameters, tags, notes, created_at, updated_at, latitude, longitude, address, temperature, customer_id,
+			   COALESCE(lifecycle_state, 'deployed'), COALESCE(label, '')
 		FROM devices WHERE serial_number = ?
 	`
 	row := db.QueryRow(query, serialNumber)
@@ -575,18 +1251,23 @@ func (db *DB) CreateDevice(device *models.Device) (*models.Device, error) {
 	paramsJSON, _ := json.Marshal(device.Parameters)
 	tagsJSON, _ := json.Marshal(device.Tags)
 
+	lifecycle := device.LifecycleState
+	if lifecycle == "" {
+		lifecycle = models.LifecycleDeployed
+	}
+
 	result, err := db.Exec(`
 		INSERT INTO devices (serial_number, oui, product_class, manufacturer, model_name,
 							 hardware_version, software_version, connection_request, status,
 							 ip_address, mac_address, uptime, rx_power, client_count, template,
-							 parameters, tags, notes, temperature)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+							 parameters, tags, notes, temperature, lifecycle_state)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`,
 		device.SerialNumber, device.OUI, device.ProductClass, device.Manufacturer,
 		device.ModelName, device.HardwareVersion, device.SoftwareVersion,
 		device.ConnectionRequest, device.Status, device.IPAddress, device.MACAddress,
 		device.Uptime, device.RXPower, device.ClientCount, device.Template,
-		string(paramsJSON), string(tagsJSON), device.Notes, device.Temperature,
+		string(paramsJSON), string(tagsJSON), device.Notes, device.Temperature, lifecycle,
 	)
 	if err != nil {
 		return nil, err
@@ -630,31 +1311,268 @@ func (db *DB) DeleteDevice(id int64) error {
 	return err
 }
 
+// GetDeviceInformCredentials returns the per-device inform username and password hash for a serial
+func (db *DB) GetDeviceInformCredentials(serial string) (string, string, error) {
+	var username, password sql.NullString
+	err := db.QueryRow("SELECT cwmp_username, cwmp_password FROM devices WHERE serial_number = ?", serial).Scan(&username, &password)
+	return username.String, password.String, err
+}
+
+// SetDeviceInformCredentials stores the per-device inform username and password hash
+func (db *DB) SetDeviceInformCredentials(id int64, username, passwordHash string) error {
+	_, err := db.Exec(`
+		UPDATE devices SET cwmp_username = ?, cwmp_password = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?
+	`, username, passwordHash, id)
+	return err
+}
+
+// SetDeviceLifecycle moves a device to another inventory state
+func (db *DB) SetDeviceLifecycle(id int64, state models.LifecycleState) error {
+	_, err := db.Exec(`UPDATE devices SET lifecycle_state = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, state, id)
+	return err
+}
+
 // UpdateDeviceStatus updates the status and last contact time
 func (db *DB) UpdateDeviceStatus(id int64, newStatus models.DeviceStatus) error {
-	// 1. Get current status
 	var oldStatus string
-	err := db.QueryRow("SELECT COALESCE(status, 'offline') FROM devices WHERE id = ?", id).Scan(&oldStatus)
+	err := db.WithTx(func(tx *sql.Tx) error {
+		// 1. Get current status
+		err := tx.QueryRow("SELECT COALESCE(status, 'offline') FROM devices WHERE id = ?", id).Scan(&oldStatus)
+		if err != nil {
+			return err
+		}
+
+		// 2. If changed, insert log
+		if oldStatus != string(newStatus) {
+			_, err = tx.Exec("INSERT INTO device_logs (device_id, status, changed_at) VALUES (?, ?, CURRENT_TIMESTAMP)", id, newStatus)
+			if err != nil {
+				fmt.Printf("Failed to log status change for device %d: %v\n", id, err)
+			}
+		}
+
+		// 3. Update device
+		_, err = tx.Exec(`
+			UPDATE devices SET status = ?, last_contact = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+			WHERE id = ?
+		`, newStatus, id)
+		return err
+	})
+	if err == nil && oldStatus != string(newStatus) && db.OnStatusChange != nil {
+		db.OnStatusChange(id, models.DeviceStatus(oldStatus), newStatus)
+	}
+	return err
+}
+
+// informEventsKept is how many inform events are retained per device
+const informEventsKept = 200
+
+// RecordInformEvents stores the event codes of an Inform and trims the
+// device history to the most recent informEventsKept entries
+func (db *DB) RecordInformEvents(deviceID int64, eventCodes, commandKeys []string) error {
+	codesJSON, _ := json.Marshal(eventCodes)
+	keysJSON, _ := json.Marshal(commandKeys)
+	if _, err := db.Exec(`INSERT INTO inform_events (device_id, event_codes, command_keys) VALUES (?, ?, ?)`,
+		deviceID, string(codesJSON), string(keysJSON)); err != nil {
+		return err
+	}
+	_, err := db.Exec(`
+		DELETE FROM inform_events WHERE device_id = ? AND id <= (
+			SELECT id FROM inform_events WHERE device_id = ? ORDER BY id DESC LIMIT 1 OFFSET ?
+		)
+	`, deviceID, deviceID, informEventsKept)
+	return err
+}
+
+// GetInformEvents returns the most recent inform events of a device, newest first
+func (db *DB) GetInformEvents(deviceID int64, limit int) ([]*models.InformEvent, error) {
+	rows, err := db.Query(`
+		SELECT id, device_id, event_codes, command_keys, created_at
+		FROM inform_events WHERE device_id = ? ORDER BY id DESC LIMIT ?
+	`, deviceID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*models.InformEvent{}
+	for rows.Next() {
+		var e models.InformEvent
+		var codes, keys sql.NullString
+		if err := rows.Scan(&e.ID, &e.DeviceID, &codes, &keys, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		json.Unmarshal([]byte(codes.String), &e.EventCodes)
+		json.Unmarshal([]byte(keys.String), &e.CommandKeys)
+		events = append(events, &e)
+	}
+	return events, nil
+}
+
+// RecordDeviceCommand adds an entry to a device's command history
+func (db *DB) RecordDeviceCommand(cmd *models.DeviceCommand) error {
+	result, err := db.Exec(`
+		INSERT INTO device_commands (device_id, command, task_id, user_id, username, details)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, cmd.DeviceID, cmd.Command, cmd.TaskID, cmd.UserID, cmd.Username, cmd.Details)
 	if err != nil {
 		return err
 	}
+	cmd.ID, _ = result.LastInsertId()
+	return nil
+}
 
-	// 2. If changed, insert log
-	if oldStatus != string(newStatus) {
-		_, err = db.Exec("INSERT INTO device_logs (device_id, status, changed_at) VALUES (?, ?, CURRENT_TIMESTAMP)", id, newStatus)
-		if err != nil {
-			fmt.Printf("Failed to log status change for device %d: %v\n", id, err)
+// GetDeviceCommands returns the most recent manual commands of a device, newest first
+func (db *DB) GetDeviceCommands(deviceID int64, limit int) ([]*models.DeviceCommand, error) {
+	rows, err := db.Query(`
+		SELECT id, device_id, command, task_id, user_id, username, details, created_at
+		FROM device_commands WHERE device_id = ? ORDER BY id DESC LIMIT ?
+	`, deviceID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	commands := []*models.DeviceCommand{}
+	for rows.Next() {
+		var c models.DeviceCommand
+		var taskID, userID sql.NullInt64
+		var username, details sql.NullString
+		if err := rows.Scan(&c.ID, &c.DeviceID, &c.Command, &taskID, &userID, &username, &details, &c.CreatedAt); err != nil {
+			return nil, err
 		}
+		if taskID.Valid {
+			c.TaskID = &taskID.Int64
+		}
+		if userID.Valid {
+			c.UserID = &userID.Int64
+		}
+		c.Username = username.String
+		c.Details = details.String
+		commands = append(commands, &c)
 	}
+	return commands, rows.Err()
+}
 
-	// 3. Update device
-	_, err = db.Exec(`
-		UPDATE devices SET status = ?, last_contact = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
-		WHERE id = ?
-	`, newStatus, id)
+// GetOpticalAlertLevel returns the device's current optical alert level, "" when none
+func (db *DB) GetOpticalAlertLevel(deviceID int64) string {
+	var level sql.NullString
+	db.QueryRow("SELECT optical_alert FROM devices WHERE id = ?", deviceID).Scan(&level)
+	return level.String
+}
+
+// SetOpticalAlertLevel stores the device's optical alert level
+func (db *DB) SetOpticalAlertLevel(deviceID int64, level string) error {
+	_, err := db.Exec("UPDATE devices SET optical_alert = ? WHERE id = ?", level, deviceID)
+	return err
+}
+
+// GetClientAlertLevel returns the device's current WiFi client limit alert level, "" when none
+func (db *DB) GetClientAlertLevel(deviceID int64) string {
+	var level sql.NullString
+	db.QueryRow("SELECT client_alert FROM devices WHERE id = ?", deviceID).Scan(&level)
+	return level.String
+}
+
+// SetClientAlertLevel stores the device's WiFi client limit alert level
+func (db *DB) SetClientAlertLevel(deviceID int64, level string) error {
+	_, err := db.Exec("UPDATE devices SET client_alert = ? WHERE id = ?", level, deviceID)
+	return err
+}
+
+// RecordDeviceReboot adds a reboot entry to the device uptime log
+func (db *DB) RecordDeviceReboot(deviceID int64) error {
+	_, err := db.Exec("INSERT INTO device_logs (device_id, status, changed_at) VALUES (?, 'reboot', CURRENT_TIMESTAMP)", deviceID)
 	return err
 }
 
+// GetDeviceRebootCounts returns the number of reboots per day over the last given days
+func (db *DB) GetDeviceRebootCounts(deviceID int64, days int) ([]models.RebootCount, error) {
+	rows, err := db.Query(`
+		SELECT date(changed_at) AS day, COUNT(*) FROM device_logs
+		WHERE device_id = ? AND status = 'reboot' AND changed_at >= datetime('now', ?)
+		GROUP BY day ORDER BY day DESC
+	`, deviceID, fmt.Sprintf("-%d days", days))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []models.RebootCount{}
+	for rows.Next() {
+		var c models.RebootCount
+		if err := rows.Scan(&c.Date, &c.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, nil
+}
+
+// Device alias formats (DEVICE_ALIAS_FORMAT)
+const (
+	AliasCustomer = "customer" // "Customer Name (SERIAL)", falls back to serial
+	AliasPPPoE    = "pppoe"    // PPPoE username, falls back to serial
+	AliasSerial   = "serial"
+)
+
+// deviceAliasTTL bounds how stale a cached alias may be after a device is
+// reassigned
+const deviceAliasTTL = 5 * time.Minute
+
+type deviceAliasEntry struct {
+	serial   string
+	customer string
+	pppoe    string
+	label    string
+	expires  time.Time
+}
+
+// DeviceAlias returns a friendly identifier for a device for live events and
+// logs. Lookups are cached for deviceAliasTTL; unknown devices yield "".
+func (db *DB) DeviceAlias(id int64, format string) string {
+	if id == 0 {
+		return ""
+	}
+
+	var entry deviceAliasEntry
+	if cached, ok := db.aliasCache.Load(id); ok && time.Now().Before(cached.(deviceAliasEntry).expires) {
+		entry = cached.(deviceAliasEntry)
+	} else {
+		var customer, pppoe, label sql.NullString
+		err := db.QueryRow(`
+			SELECT d.serial_number, c.name, d.template, d.label
+			FROM devices d LEFT JOIN customers c ON c.id = d.customer_id
+			WHERE d.id = ?
+		`, id).Scan(&entry.serial, &customer, &pppoe, &label)
+		if err != nil {
+			return ""
+		}
+		entry.customer = customer.String
+		entry.pppoe = pppoe.String
+		entry.label = label.String
+		entry.expires = time.Now().Add(deviceAliasTTL)
+		db.aliasCache.Store(id, entry)
+	}
+
+	switch format {
+	case AliasSerial:
+		return entry.serial
+	case AliasPPPoE:
+		if entry.pppoe != "" {
+			return entry.pppoe
+		}
+		return entry.serial
+	default:
+		if entry.label != "" {
+			return fmt.Sprintf("%s (%s)", entry.label, entry.serial)
+		}
+		if entry.customer != "" {
+			return fmt.Sprintf("%s (%s)", entry.customer, entry.serial)
+		}
+		return entry.serial
+	}
+}
+
 // GetDeviceLogs retrieves uptime logs for a device
 func (db *DB) GetDeviceLogs(deviceID int64, limit int) ([]models.DeviceLog, error) {
 	rows, err := db.Query("SELECT id, device_id, status, changed_at FROM device_logs WHERE device_id = ? ORDER BY changed_at DESC LIMIT ?", deviceID, limit)
@@ -715,569 +1633,3075 @@ func (db *DB) GetDeviceParameters(deviceID int64, pathPrefix string) ([]*models.
 	return params, nil
 }
 
-// SetDeviceParameter sets or updates a device parameter
-func (db *DB) SetDeviceParameter(deviceID int64, path, value, paramType string, writable bool) error {
-	_, err := db.Exec(`
-		INSERT INTO device_parameters (device_id, path, value, type, writable, updated_at)
-		VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
-		ON CONFLICT(device_id, path) DO UPDATE SET
-			value = excluded.value,
-			type = excluded.type,
-			writable = excluded.writable,
-			updated_at = CURRENT_TIMESTAMP
-	`, deviceID, path, value, paramType, writable)
-	return err
+// SetDeviceParameter sets or updates a device parameter. It returns the
+// recorded change when an existing value changed, nil otherwise.
+func (db *DB) SetDeviceParameter(deviceID int64, path, value, paramType string, writable bool) (*models.ParameterChange, error) {
+	var change *models.ParameterChange
+	err := db.WithTx(func(tx *sql.Tx) error {
+		change = nil
+
+		// Record the change before the upsert overwrites the old value
+		var old sql.NullString
+		err := tx.QueryRow("SELECT value FROM device_parameters WHERE device_id = ? AND path = ?", deviceID, path).Scan(&old)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		if err == nil && old.String != value {
+			result, err := tx.Exec(`
+				INSERT INTO parameter_history (device_id, path, old_value, new_value) VALUES (?, ?, ?, ?)
+			`, deviceID, path, old, value)
+			if err != nil {
+				return err
+			}
+			id, _ := result.LastInsertId()
+			change = &models.ParameterChange{
+				ID: id, DeviceID: deviceID, Path: path, OldValue: old.String, NewValue: value, ChangedAt: time.Now(),
+			}
+		}
+
+		_, err = tx.Exec(`
+			INSERT INTO device_parameters (device_id, path, value, type, writable, updated_at)
+			VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+			ON CONFLICT(device_id, path) DO UPDATE SET
+				value = excluded.value,
+				type = excluded.type,
+				writable = excluded.writable,
+				updated_at = CURRENT_TIMESTAMP
+		`, deviceID, path, value, paramType, writable)
+		return err
+	})
+	return change, err
 }
 
-// ============== WAN Config Operations ==============
+// GetParameterHistory returns the most recent value changes of one device
+// parameter, newest first
+func (db *DB) GetParameterHistory(deviceID int64, path string, limit int) ([]*models.ParameterChange, error) {
+	rows, err := db.Query(`
+		SELECT id, device_id, path, old_value, new_value, changed_at
+		FROM parameter_history
+		WHERE device_id = ? AND path = ?
+		ORDER BY changed_at DESC, id DESC
+		LIMIT ?
+	`, deviceID, path, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-// GetWANConfigs retrieves all WAN configurations for a device
-func (db *DB) GetWANConfigs(deviceID int64) ([]*models.WANConfig, error) {
+	changes := []*models.ParameterChange{}
+	for rows.Next() {
+		var c models.ParameterChange
+		var oldValue, newValue sql.NullString
+		if err := rows.Scan(&c.ID, &c.DeviceID, &c.Path, &oldValue, &newValue, &c.ChangedAt); err != nil {
+			return nil, err
+		}
+		c.OldValue = oldValue.String
+		c.NewValue = newValue.String
+		changes = append(changes, &c)
+	}
+	return changes, rows.Err()
+}
+
+// ============== Pinned Parameter Operations ==============
+
+func queryPinnedParameters(db *DB, where string, args ...interface{}) ([]*models.PinnedParameter, error) {
 	rows, err := db.Query(`
-		SELECT id, device_id, name, connection_type, vlan, username, password,
-			   ip_address, subnet_mask, gateway, dns1, dns2, mtu, enabled,
-			   nat_enabled, status, uptime, bytes_sent, bytes_received,
-			   created_at, updated_at
-		FROM wan_configs
-		WHERE device_id = ?
-		ORDER BY id
-	`, deviceID)
+		SELECT id, model_name, path, label, position, created_at
+		FROM pinned_parameters `+where+` ORDER BY position, id
+	`, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var configs []*models.WANConfig
+	pins := []*models.PinnedParameter{}
 	for rows.Next() {
-		var c models.WANConfig
-		err := rows.Scan(
-			&c.ID, &c.DeviceID, &c.Name, &c.ConnectionType, &c.VLAN,
-			&c.Username, &c.Password, &c.IPAddress, &c.SubnetMask, &c.Gateway,
-			&c.DNS1, &c.DNS2, &c.MTU, &c.Enabled, &c.NATEnabled, &c.Status,
-			&c.Uptime, &c.BytesSent, &c.BytesReceived, &c.CreatedAt, &c.UpdatedAt,
-		)
-		if err != nil {
+		var p models.PinnedParameter
+		var label sql.NullString
+		if err := rows.Scan(&p.ID, &p.ModelName, &p.Path, &label, &p.Position, &p.CreatedAt); err != nil {
 			return nil, err
 		}
-		configs = append(configs, &c)
+		p.Label = label.String
+		pins = append(pins, &p)
 	}
+	return pins, rows.Err()
+}
 
-	return configs, nil
+// GetPinnedParameters retrieves every pinned parameter entry
+func (db *DB) GetPinnedParameters() ([]*models.PinnedParameter, error) {
+	return queryPinnedParameters(db, "")
 }
 
-// CreateWANConfig creates a new WAN configuration
-func (db *DB) CreateWANConfig(config *models.WANConfig) (*models.WANConfig, error) {
-	result, err := db.Exec(`
-		INSERT INTO wan_configs (device_id, name, connection_type, vlan, username, password,
-								 ip_address, subnet_mask, gateway, dns1, dns2, mtu, enabled, nat_enabled)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`,
-		config.DeviceID, config.Name, config.ConnectionType, config.VLAN,
-		config.Username, config.Password, config.IPAddress, config.SubnetMask,
-		config.Gateway, config.DNS1, config.DNS2, config.MTU, config.Enabled, config.NATEnabled,
-	)
+// GetPinnedParametersForModel returns the pinned set for a model: entries for
+// the model itself plus those pinned for all models, one per path (the
+// model-specific entry wins)
+func (db *DB) GetPinnedParametersForModel(model string) ([]*models.PinnedParameter, error) {
+	pins, err := queryPinnedParameters(db, "WHERE model_name = '' OR UPPER(model_name) = UPPER(?)", model)
 	if err != nil {
 		return nil, err
 	}
 
-	id, _ := result.LastInsertId()
-	config.ID = id
-	return config, nil
+	specific := make(map[string]bool)
+	for _, p := range pins {
+		if p.ModelName != "" {
+			specific[p.Path] = true
+		}
+	}
+	result := []*models.PinnedParameter{}
+	for _, p := range pins {
+		if p.ModelName == "" && specific[p.Path] {
+			continue
+		}
+		result = append(result, p)
+	}
+	return result, nil
 }
 
-// UpdateWANConfig updates a WAN configuration
-func (db *DB) UpdateWANConfig(config *models.WANConfig) error {
-	_, err := db.Exec(`
-		UPDATE wan_configs SET
-			name = ?, connection_type = ?, vlan = ?, username = ?, password = ?,
-			ip_address = ?, subnet_mask = ?, gateway = ?, dns1 = ?, dns2 = ?,
-			mtu = ?, enabled = ?, nat_enabled = ?, updated_at = CURRENT_TIMESTAMP
-		WHERE id = ?
-	`,
-		config.Name, config.ConnectionType, config.VLAN, config.Username, config.Password,
-		config.IPAddress, config.SubnetMask, config.Gateway, config.DNS1, config.DNS2,
-		config.MTU, config.Enabled, config.NATEnabled, config.ID,
-	)
-	return err
+// CreatePinnedParameter pins a parameter path for a model
+func (db *DB) CreatePinnedParameter(p *models.PinnedParameter) (*models.PinnedParameter, error) {
+	result, err := db.Exec(`
+		INSERT INTO pinned_parameters (model_name, path, label, position) VALUES (?, ?, ?, ?)
+	`, p.ModelName, p.Path, p.Label, p.Position)
+	if err != nil {
+		return nil, err
+	}
+	p.ID, _ = result.LastInsertId()
+	p.CreatedAt = time.Now()
+	return p, nil
 }
 
-// DeleteWANConfig deletes a WAN configuration
-func (db *DB) DeleteWANConfig(id int64) error {
-	_, err := db.Exec("DELETE FROM wan_configs WHERE id = ?", id)
+// DeletePinnedParameter unpins a parameter
+func (db *DB) DeletePinnedParameter(id int64) error {
+	_, err := db.Exec("DELETE FROM pinned_parameters WHERE id = ?", id)
 	return err
 }
 
-// ============== Task Operations ==============
+// ============== Parameter Watch Operations ==============
 
-// GetPendingTasks retrieves pending tasks for a device
-func (db *DB) GetPendingTasks(deviceID int64) ([]*models.DeviceTask, error) {
+func queryParameterWatches(db *DB, where string, args ...interface{}) ([]*models.ParameterWatch, error) {
 	rows, err := db.Query(`
-		SELECT id, device_id, type, status, parameters, result, error,
-			   created_at, started_at, completed_at
-		FROM tasks
-		WHERE device_id = ? AND status = 'pending'
-		ORDER BY created_at ASC
-	`, deviceID)
+		SELECT id, device_id, path, label, created_at
+		FROM parameter_watches `+where+` ORDER BY device_id, path
+	`, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var tasks []*models.DeviceTask
+	watches := []*models.ParameterWatch{}
 	for rows.Next() {
-		task, err := scanTask(rows)
-		if err != nil {
+		var w models.ParameterWatch
+		var label sql.NullString
+		if err := rows.Scan(&w.ID, &w.DeviceID, &w.Path, &label, &w.CreatedAt); err != nil {
 			return nil, err
 		}
-		tasks = append(tasks, task)
+		w.Label = label.String
+		watches = append(watches, &w)
 	}
+	return watches, rows.Err()
+}
 
-	return tasks, nil
+// GetParameterWatches retrieves every parameter watch
+func (db *DB) GetParameterWatches() ([]*models.ParameterWatch, error) {
+	return queryParameterWatches(db, "")
 }
 
-// CreateTask creates a new task
-func (db *DB) CreateTask(task *models.DeviceTask) (*models.DeviceTask, error) {
+// GetDeviceParameterWatches retrieves the watches of one device
+func (db *DB) GetDeviceParameterWatches(deviceID int64) ([]*models.ParameterWatch, error) {
+	return queryParameterWatches(db, "WHERE device_id = ?", deviceID)
+}
+
+// CreateParameterWatch watches a device parameter for changes
+func (db *DB) CreateParameterWatch(w *models.ParameterWatch) (*models.ParameterWatch, error) {
 	result, err := db.Exec(`
-		INSERT INTO tasks (device_id, type, status, parameters)
-		VALUES (?, ?, ?, ?)
-	`, task.DeviceID, task.Type, models.TaskPending, string(task.Parameters))
+		INSERT INTO parameter_watches (device_id, path, label) VALUES (?, ?, ?)
+	`, w.DeviceID, w.Path, w.Label)
 	if err != nil {
 		return nil, err
 	}
-
-	id, _ := result.LastInsertId()
-	task.ID = id
-	task.Status = models.TaskPending
-	return task, nil
+	w.ID, _ = result.LastInsertId()
+	w.CreatedAt = time.Now()
+	return w, nil
 }
 
-// UpdateTask updates a task in the database
-func (db *DB) UpdateTask(task *models.DeviceTask) error {
-	paramsJSON, _ := json.Marshal(task.Parameters)
-	resultJSON, _ := json.Marshal(task.Result)
-
-	_, err := db.Exec(`
-		UPDATE tasks SET
-			status = ?,
-			parameters = ?,
-			result = ?,
-			error = ?,
-			started_at = ?,
-			completed_at = ?
-		WHERE id = ?
-	`, task.Status, string(paramsJSON), string(resultJSON), task.Error, task.StartedAt, task.CompletedAt, task.ID)
+// DeleteParameterWatch removes a parameter watch
+func (db *DB) DeleteParameterWatch(id int64) error {
+	_, err := db.Exec("DELETE FROM parameter_watches WHERE id = ?", id)
 	return err
 }
 
-// UpdateTaskStatus updates a task's status
-func (db *DB) UpdateTaskStatus(id int64, status models.TaskStatus, result json.RawMessage, errMsg string) error {
-	_, err := db.Exec(`
-		UPDATE tasks SET
-			status = ?,
-			result = ?,
-			error = ?,
-			started_at = CASE WHEN ? = 'running' AND started_at IS NULL THEN CURRENT_TIMESTAMP ELSE started_at END,
-			completed_at = CASE WHEN ? IN ('completed', 'failed') THEN CURRENT_TIMESTAMP ELSE completed_at END
-		WHERE id = ?
-	`, status, string(result), errMsg, status, status, id)
+// ============== Reboot Schedule Operations ==============
+
+func queryRebootSchedules(db *DB, where string, args ...interface{}) ([]*models.RebootSchedule, error) {
+	rows, err := db.Query(`
+		SELECT id, device_id, COALESCE(tag, ''), spec, enabled, last_run_at, created_at
+		FROM reboot_schedules `+where+` ORDER BY id
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schedules := []*models.RebootSchedule{}
+	for rows.Next() {
+		var rs models.RebootSchedule
+		var deviceID sql.NullInt64
+		var lastRun sql.NullTime
+		if err := rows.Scan(&rs.ID, &deviceID, &rs.Tag, &rs.Spec, &rs.Enabled, &lastRun, &rs.CreatedAt); err != nil {
+			return nil, err
+		}
+		if deviceID.Valid {
+			rs.DeviceID = &deviceID.Int64
+		}
+		if lastRun.Valid {
+			rs.LastRunAt = &lastRun.Time
+		}
+		schedules = append(schedules, &rs)
+	}
+	return schedules, rows.Err()
+}
+
+// GetRebootSchedules retrieves every reboot schedule
+func (db *DB) GetRebootSchedules() ([]*models.RebootSchedule, error) {
+	return queryRebootSchedules(db, "")
+}
+
+// GetDeviceRebootSchedules retrieves the schedules that target one device
+// directly (tag schedules are not included)
+func (db *DB) GetDeviceRebootSchedules(deviceID int64) ([]*models.RebootSchedule, error) {
+	return queryRebootSchedules(db, "WHERE device_id = ?", deviceID)
+}
+
+// GetEnabledRebootSchedules retrieves the schedules the scheduler evaluates
+func (db *DB) GetEnabledRebootSchedules() ([]*models.RebootSchedule, error) {
+	return queryRebootSchedules(db, "WHERE enabled = 1")
+}
+
+// CreateRebootSchedule stores a reboot schedule
+func (db *DB) CreateRebootSchedule(rs *models.RebootSchedule) (*models.RebootSchedule, error) {
+	var tag interface{}
+	if rs.Tag != "" {
+		tag = rs.Tag
+	}
+	result, err := db.Exec(`
+		INSERT INTO reboot_schedules (device_id, tag, spec, enabled) VALUES (?, ?, ?, ?)
+	`, rs.DeviceID, tag, rs.Spec, rs.Enabled)
+	if err != nil {
+		return nil, err
+	}
+	rs.ID, _ = result.LastInsertId()
+	rs.CreatedAt = time.Now()
+	return rs, nil
+}
+
+// DeleteRebootSchedule removes a reboot schedule
+func (db *DB) DeleteRebootSchedule(id int64) error {
+	_, err := db.Exec("DELETE FROM reboot_schedules WHERE id = ?", id)
+	return err
+}
+
+// ClaimRebootScheduleRun records that a schedule fired in the minute
+// starting at minute. It returns false when that minute was already
+// claimed, so a schedule fires once even if it is evaluated twice.
+func (db *DB) ClaimRebootScheduleRun(id int64, minute time.Time) (bool, error) {
+	result, err := db.Exec(`
+		UPDATE reboot_schedules SET last_run_at = ?
+		WHERE id = ? AND (last_run_at IS NULL OR last_run_at < ?)
+	`, minute, id, minute)
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// ============== Rollout Operations ==============
+
+// Rollout phases in rollout_devices
+const (
+	RolloutPhaseCanary = "canary"
+	RolloutPhaseRest   = "rest"
+)
+
+const rolloutColumns = `id, name, parameters, COALESCE(filter, ''), canary_percent, wait_minutes, max_failure_percent,
+	status, COALESCE(reason, ''), COALESCE(created_by, ''), created_at, canary_until, decided_at`
+
+func scanRollout(row rowScanner) (*models.Rollout, error) {
+	var ro models.Rollout
+	var params, filter string
+	var decidedAt sql.NullTime
+	if err := row.Scan(&ro.ID, &ro.Name, &params, &filter, &ro.CanaryPercent, &ro.WaitMinutes, &ro.MaxFailurePercent,
+		&ro.Status, &ro.Reason, &ro.CreatedBy, &ro.CreatedAt, &ro.CanaryUntil, &decidedAt); err != nil {
+		return nil, err
+	}
+	json.Unmarshal([]byte(params), &ro.Parameters)
+	if filter != "" {
+		ro.Filter = json.RawMessage(filter)
+	}
+	if decidedAt.Valid {
+		ro.DecidedAt = &decidedAt.Time
+	}
+	return &ro, nil
+}
+
+// CreateRollout stores a rollout with its canary and remaining devices.
+// online holds the devices that were online at the start, so devices that
+// drop off during the canary wait can be told apart from ones already offline.
+func (db *DB) CreateRollout(ro *models.Rollout, canary, rest []int64, online map[int64]bool) (*models.Rollout, error) {
+	params, _ := json.Marshal(ro.Parameters)
+	var filter interface{}
+	if len(ro.Filter) > 0 {
+		filter = string(ro.Filter)
+	}
+	err := db.WithTx(func(tx *sql.Tx) error {
+		result, err := tx.Exec(`
+			INSERT INTO rollouts (name, parameters, filter, canary_percent, wait_minutes, max_failure_percent, status, created_by, canary_until)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, ro.Name, string(params), filter, ro.CanaryPercent, ro.WaitMinutes, ro.MaxFailurePercent,
+			models.RolloutCanary, ro.CreatedBy, ro.CanaryUntil.UTC())
+		if err != nil {
+			return err
+		}
+		ro.ID, _ = result.LastInsertId()
+
+		for phase, ids := range map[string][]int64{RolloutPhaseCanary: canary, RolloutPhaseRest: rest} {
+			for _, id := range ids {
+				if _, err := tx.Exec(`INSERT INTO rollout_devices (rollout_id, device_id, phase, was_online) VALUES (?, ?, ?, ?)`,
+					ro.ID, id, phase, online[id]); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return db.GetRollout(ro.ID)
+}
+
+// GetRollouts returns the most recent rollouts, newest first
+func (db *DB) GetRollouts(limit int) ([]*models.Rollout, error) {
+	rows, err := db.Query(`SELECT `+rolloutColumns+` FROM rollouts ORDER BY id DESC LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rollouts := []*models.Rollout{}
+	for rows.Next() {
+		ro, err := scanRollout(rows)
+		if err != nil {
+			return nil, err
+		}
+		rollouts = append(rollouts, ro)
+	}
+	return rollouts, rows.Err()
+}
+
+// GetRollout returns a rollout by ID
+func (db *DB) GetRollout(id int64) (*models.Rollout, error) {
+	return scanRollout(db.QueryRow(`SELECT `+rolloutColumns+` FROM rollouts WHERE id = ?`, id))
+}
+
+// GetDueRollouts returns the rollouts whose canary wait has ended without a decision
+func (db *DB) GetDueRollouts(now time.Time) ([]*models.Rollout, error) {
+	rows, err := db.Query(`SELECT `+rolloutColumns+` FROM rollouts WHERE status = ? ORDER BY id`, models.RolloutCanary)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var due []*models.Rollout
+	for rows.Next() {
+		ro, err := scanRollout(rows)
+		if err != nil {
+			return nil, err
+		}
+		if !ro.CanaryUntil.After(now) {
+			due = append(due, ro)
+		}
+	}
+	return due, rows.Err()
+}
+
+// GetRolloutDeviceIDs returns the devices of one rollout phase
+func (db *DB) GetRolloutDeviceIDs(rolloutID int64, phase string) ([]int64, error) {
+	rows, err := db.Query(`SELECT device_id FROM rollout_devices WHERE rollout_id = ? AND phase = ? ORDER BY device_id`, rolloutID, phase)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// SetRolloutDeviceTask links the task queued for a device to its rollout
+func (db *DB) SetRolloutDeviceTask(rolloutID, deviceID, taskID int64) error {
+	_, err := db.Exec(`UPDATE rollout_devices SET task_id = ? WHERE rollout_id = ? AND device_id = ?`, taskID, rolloutID, deviceID)
+	return err
+}
+
+// GetRolloutPendingTaskIDs returns the rollout tasks that have not been sent to their device yet
+func (db *DB) GetRolloutPendingTaskIDs(rolloutID int64) ([]int64, error) {
+	rows, err := db.Query(`
+		SELECT t.id FROM rollout_devices rd JOIN tasks t ON t.id = rd.task_id
+		WHERE rd.rollout_id = ? AND t.status = ?
+	`, rolloutID, models.TaskPending)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// GetRolloutHealth counts the task outcomes and offline devices of the
+// canary and of the rest (the control group while the canary runs)
+func (db *DB) GetRolloutHealth(rolloutID int64) (canary, control models.RolloutHealth, err error) {
+	rows, err := db.Query(`
+		SELECT rd.phase, rd.was_online, COALESCE(d.status, 'offline'), COALESCE(t.status, ''), COALESCE(t.retry_count, 0)
+		FROM rollout_devices rd
+		LEFT JOIN devices d ON d.id = rd.device_id
+		LEFT JOIN tasks t ON t.id = rd.task_id
+		WHERE rd.rollout_id = ?
+	`, rolloutID)
+	if err != nil {
+		return canary, control, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var phase, deviceStatus, taskStatus string
+		var wasOnline bool
+		var retries int
+		if err := rows.Scan(&phase, &wasOnline, &deviceStatus, &taskStatus, &retries); err != nil {
+			return canary, control, err
+		}
+		h := &control
+		if phase == RolloutPhaseCanary {
+			h = &canary
+		}
+		h.Devices++
+		switch {
+		case wasOnline && deviceStatus != string(models.StatusOnline):
+			h.Offline++
+		case taskStatus == "":
+			// Control devices have no task while the canary runs
+		case taskStatus == string(models.TaskFailed) || retries > 0:
+			h.Failed++
+		case taskStatus == string(models.TaskCompleted):
+			h.Applied++
+		default:
+			h.Pending++
+		}
+	}
+	return canary, control, rows.Err()
+}
+
+// FinishRollout records the outcome of a rollout still in its canary phase.
+// It reports false when the rollout was already decided (e.g. aborted by hand
+// while the scheduler evaluated it).
+func (db *DB) FinishRollout(id int64, status, reason string) (bool, error) {
+	result, err := db.Exec(`
+		UPDATE rollouts SET status = ?, reason = ?, decided_at = CURRENT_TIMESTAMP WHERE id = ? AND status = ?
+	`, status, reason, id, models.RolloutCanary)
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// ============== WAN Template Operations ==============
+
+const wanTemplateColumns = `id, name, description, connections, created_at, updated_at`
+
+func scanWANTemplate(row rowScanner) (*models.WANTemplate, error) {
+	var t models.WANTemplate
+	var description sql.NullString
+	var connections string
+	if err := row.Scan(&t.ID, &t.Name, &description, &connections, &t.CreatedAt, &t.UpdatedAt); err != nil {
+		return nil, err
+	}
+	t.Description = description.String
+	json.Unmarshal([]byte(connections), &t.Connections)
+	return &t, nil
+}
+
+// GetWANTemplates retrieves all multi-WAN templates
+func (db *DB) GetWANTemplates() ([]*models.WANTemplate, error) {
+	rows, err := db.Query(`SELECT ` + wanTemplateColumns + ` FROM wan_templates ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	templates := []*models.WANTemplate{}
+	for rows.Next() {
+		t, err := scanWANTemplate(rows)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, t)
+	}
+	return templates, rows.Err()
+}
+
+// GetWANTemplate retrieves a multi-WAN template by ID
+func (db *DB) GetWANTemplate(id int64) (*models.WANTemplate, error) {
+	return scanWANTemplate(db.QueryRow(`SELECT `+wanTemplateColumns+` FROM wan_templates WHERE id = ?`, id))
+}
+
+// CreateWANTemplate creates a multi-WAN template
+func (db *DB) CreateWANTemplate(t *models.WANTemplate) (*models.WANTemplate, error) {
+	connectionsJSON, _ := json.Marshal(t.Connections)
+	result, err := db.Exec(`
+		INSERT INTO wan_templates (name, description, connections) VALUES (?, ?, ?)
+	`, t.Name, t.Description, string(connectionsJSON))
+	if err != nil {
+		return nil, err
+	}
+	id, _ := result.LastInsertId()
+	return db.GetWANTemplate(id)
+}
+
+// UpdateWANTemplate updates a multi-WAN template
+func (db *DB) UpdateWANTemplate(t *models.WANTemplate) error {
+	connectionsJSON, _ := json.Marshal(t.Connections)
+	_, err := db.Exec(`
+		UPDATE wan_templates SET name = ?, description = ?, connections = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?
+	`, t.Name, t.Description, string(connectionsJSON), t.ID)
+	return err
+}
+
+// DeleteWANTemplate deletes a multi-WAN template
+func (db *DB) DeleteWANTemplate(id int64) error {
+	_, err := db.Exec("DELETE FROM wan_templates WHERE id = ?", id)
+	return err
+}
+
+// ============== Firmware Repository Operations ==============
+
+func queryFirmware(db *DB, where string, args ...interface{}) ([]*models.Firmware, error) {
+	rows, err := db.Query(`
+		SELECT id, manufacturer, product_class, version, url, release_notes, created_at
+		FROM firmware `+where+` ORDER BY manufacturer, product_class, id
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	images := []*models.Firmware{}
+	for rows.Next() {
+		var f models.Firmware
+		var notes sql.NullString
+		if err := rows.Scan(&f.ID, &f.Manufacturer, &f.ProductClass, &f.Version, &f.URL, &notes, &f.CreatedAt); err != nil {
+			return nil, err
+		}
+		f.ReleaseNotes = notes.String
+		images = append(images, &f)
+	}
+	return images, rows.Err()
+}
+
+// GetFirmwares lists the firmware repository
+func (db *DB) GetFirmwares() ([]*models.Firmware, error) {
+	return queryFirmware(db, "")
+}
+
+// GetFirmwareVersionCounts counts non-retired devices per manufacturer,
+// product class and software version
+func (db *DB) GetFirmwareVersionCounts() ([]models.FirmwareVersionCount, error) {
+	rows, err := db.Query(`
+		SELECT COALESCE(manufacturer, ''), COALESCE(product_class, ''), COALESCE(software_version, ''), COUNT(*)
+		FROM devices
+		WHERE COALESCE(lifecycle_state, 'deployed') != 'retired'
+		GROUP BY 1, 2, 3
+		ORDER BY 1, 2, 3
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []models.FirmwareVersionCount
+	for rows.Next() {
+		var c models.FirmwareVersionCount
+		if err := rows.Scan(&c.Manufacturer, &c.ProductClass, &c.SoftwareVersion, &c.Devices); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
+
+// GetFirmware retrieves a firmware repository entry by ID
+func (db *DB) GetFirmware(id int64) (*models.Firmware, error) {
+	images, err := queryFirmware(db, "WHERE id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	if len(images) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return images[0], nil
+}
+
+// GetLatestFirmware returns the newest firmware for a manufacturer and
+// product class (case-insensitive); entries without a product class apply to
+// every class. Returns sql.ErrNoRows when the repository has nothing for it.
+func (db *DB) GetLatestFirmware(manufacturer, productClass string) (*models.Firmware, error) {
+	images, err := queryFirmware(db, "WHERE UPPER(manufacturer) = UPPER(?) AND (product_class = '' OR UPPER(product_class) = UPPER(?))",
+		manufacturer, productClass)
+	if err != nil {
+		return nil, err
+	}
+	latest := models.LatestFirmware(images)
+	if latest == nil {
+		return nil, sql.ErrNoRows
+	}
+	return latest, nil
+}
+
+// ============== Bulk Firmware Upgrade Operations ==============
+
+const firmwareUpgradeColumns = `id, batch_id, url, parameters, delay_seconds, batches, next_batch, next_run_at,
+	user_id, COALESCE(created_by, ''), created_at`
+
+func scanFirmwareUpgrade(row rowScanner) (*models.FirmwareUpgrade, error) {
+	var u models.FirmwareUpgrade
+	var params string
+	var nextRunAt sql.NullTime
+	var userID sql.NullInt64
+	if err := row.Scan(&u.ID, &u.BatchID, &u.URL, &params, &u.DelaySeconds, &u.Batches, &u.NextBatch, &nextRunAt,
+		&userID, &u.CreatedBy, &u.CreatedAt); err != nil {
+		return nil, err
+	}
+	u.Parameters = json.RawMessage(params)
+	if nextRunAt.Valid {
+		u.NextRunAt = &nextRunAt.Time
+	}
+	if userID.Valid {
+		u.UserID = &userID.Int64
+	}
+	return &u, nil
+}
+
+// CreateFirmwareUpgrade stores a bulk upgrade with the devices of each batch
+// (batches[0] is batch 1). The first batch is due immediately.
+func (db *DB) CreateFirmwareUpgrade(u *models.FirmwareUpgrade, batches [][]int64) (*models.FirmwareUpgrade, error) {
+	err := db.WithTx(func(tx *sql.Tx) error {
+		result, err := tx.Exec(`
+			INSERT INTO firmware_upgrades (batch_id, url, parameters, delay_seconds, batches, next_batch, next_run_at, user_id, created_by)
+			VALUES (?, ?, ?, ?, ?, 1, ?, ?, ?)
+		`, u.BatchID, u.URL, string(u.Parameters), u.DelaySeconds, len(batches), time.Now().UTC(), u.UserID, u.CreatedBy)
+		if err != nil {
+			return err
+		}
+		u.ID, _ = result.LastInsertId()
+
+		for i, ids := range batches {
+			for _, id := range ids {
+				if _, err := tx.Exec(`INSERT INTO firmware_upgrade_devices (upgrade_id, device_id, batch) VALUES (?, ?, ?)`,
+					u.ID, id, i+1); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return db.GetFirmwareUpgrade(u.ID)
+}
+
+// GetFirmwareUpgrade retrieves a bulk firmware upgrade by ID
+func (db *DB) GetFirmwareUpgrade(id int64) (*models.FirmwareUpgrade, error) {
+	return scanFirmwareUpgrade(db.QueryRow(`SELECT `+firmwareUpgradeColumns+` FROM firmware_upgrades WHERE id = ?`, id))
+}
+
+// GetDueFirmwareUpgrades returns the bulk upgrades whose next batch is due
+func (db *DB) GetDueFirmwareUpgrades(now time.Time) ([]*models.FirmwareUpgrade, error) {
+	rows, err := db.Query(`SELECT ` + firmwareUpgradeColumns + ` FROM firmware_upgrades WHERE next_run_at IS NOT NULL ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var due []*models.FirmwareUpgrade
+	for rows.Next() {
+		u, err := scanFirmwareUpgrade(rows)
+		if err != nil {
+			return nil, err
+		}
+		if !u.NextRunAt.After(now) {
+			due = append(due, u)
+		}
+	}
+	return due, rows.Err()
+}
+
+// ClaimFirmwareUpgradeBatch marks batch as queued and schedules the next one
+// at nextRunAt (nil after the last batch). It returns false when the batch
+// was already claimed.
+func (db *DB) ClaimFirmwareUpgradeBatch(id int64, batch int, nextRunAt *time.Time) (bool, error) {
+	var next interface{}
+	if nextRunAt != nil {
+		next = nextRunAt.UTC()
+	}
+	result, err := db.Exec(`
+		UPDATE firmware_upgrades SET next_batch = next_batch + 1, next_run_at = ? WHERE id = ? AND next_batch = ?
+	`, next, id, batch)
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// GetFirmwareUpgradeDeviceIDs returns the devices of one batch of a bulk upgrade
+func (db *DB) GetFirmwareUpgradeDeviceIDs(id int64, batch int) ([]int64, error) {
+	rows, err := db.Query(`SELECT device_id FROM firmware_upgrade_devices WHERE upgrade_id = ? AND batch = ? ORDER BY device_id`, id, batch)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var deviceID int64
+		if err := rows.Scan(&deviceID); err != nil {
+			return nil, err
+		}
+		ids = append(ids, deviceID)
+	}
+	return ids, rows.Err()
+}
+
+// SetFirmwareUpgradeDeviceTask records the download task queued for a device
+func (db *DB) SetFirmwareUpgradeDeviceTask(id, deviceID, taskID int64) error {
+	_, err := db.Exec(`UPDATE firmware_upgrade_devices SET task_id = ? WHERE upgrade_id = ? AND device_id = ?`, taskID, id, deviceID)
+	return err
+}
+
+// CreateFirmware adds an image to the firmware repository
+func (db *DB) CreateFirmware(f *models.Firmware) (*models.Firmware, error) {
+	result, err := db.Exec(`
+		INSERT INTO firmware (manufacturer, product_class, version, url, release_notes) VALUES (?, ?, ?, ?, ?)
+	`, f.Manufacturer, f.ProductClass, f.Version, f.URL, f.ReleaseNotes)
+	if err != nil {
+		return nil, err
+	}
+	f.ID, _ = result.LastInsertId()
+	f.CreatedAt = time.Now()
+	return f, nil
+}
+
+// DeleteFirmware removes an image from the firmware repository
+func (db *DB) DeleteFirmware(id int64) error {
+	_, err := db.Exec("DELETE FROM firmware WHERE id = ?", id)
+	return err
+}
+
+// ============== WAN Config Operations ==============
+
+// GetWANConfigs retrieves all WAN configurations for a device
+func (db *DB) GetWANConfigs(deviceID int64) ([]*models.WANConfig, error) {
+	rows, err := db.Query(`
+		SELECT id, device_id, name, connection_type, vlan, username, password,
+			   ip_address, subnet_mask, gateway, dns1, dns2, mtu, enabled,
+			   nat_enabled, status, uptime, bytes_sent, bytes_received,
+			   created_at, updated_at
+		FROM wan_configs
+		WHERE device_id = ?
+		ORDER BY id
+	`, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var configs []*models.WANConfig
+	for rows.Next() {
+		var c models.WANConfig
+		err := rows.Scan(
+			&c.ID, &c.DeviceID, &c.Name, &c.ConnectionType, &c.VLAN,
+			&c.Username, &c.Password, &c.IPAddress, &c.SubnetMask, &c.Gateway,
+			&c.DNS1, &c.DNS2, &c.MTU, &c.Enabled, &c.NATEnabled, &c.Status,
+			&c.Uptime, &c.BytesSent, &c.BytesReceived, &c.CreatedAt, &c.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		configs = append(configs, &c)
+	}
+
+	return configs, nil
+}
+
+// GetWANConfig retrieves a single WAN configuration by ID
+func (db *DB) GetWANConfig(id int64) (*models.WANConfig, error) {
+	var c models.WANConfig
+	err := db.QueryRow(`
+		SELECT id, device_id, name, connection_type, vlan, username, password,
+			   ip_address, subnet_mask, gateway, dns1, dns2, mtu, enabled,
+			   nat_enabled, status, uptime, bytes_sent, bytes_received,
+			   created_at, updated_at
+		FROM wan_configs
+		WHERE id = ?
+	`, id).Scan(
+		&c.ID, &c.DeviceID, &c.Name, &c.ConnectionType, &c.VLAN,
+		&c.Username, &c.Password, &c.IPAddress, &c.SubnetMask, &c.Gateway,
+		&c.DNS1, &c.DNS2, &c.MTU, &c.Enabled, &c.NATEnabled, &c.Status,
+		&c.Uptime, &c.BytesSent, &c.BytesReceived, &c.CreatedAt, &c.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// CreateWANConfig creates a new WAN configuration
+func (db *DB) CreateWANConfig(config *models.WANConfig) (*models.WANConfig, error) {
+	result, err := db.Exec(`
+		INSERT INTO wan_configs (device_id, name, connection_type, vlan, username, password,
+								 ip_address, subnet_mask, gateway, dns1, dns2, mtu, enabled, nat_enabled)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`,
+		config.DeviceID, config.Name, config.ConnectionType, config.VLAN,
+		config.Username, config.Password, config.IPAddress, config.SubnetMask,
+		config.Gateway, config.DNS1, config.DNS2, config.MTU, config.Enabled, config.NATEnabled,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	id, _ := result.LastInsertId()
+	config.ID = id
+	return config, nil
+}
+
+// UpdateWANConfig updates a WAN configuration
+func (db *DB) UpdateWANConfig(config *models.WANConfig) error {
+	_, err := db.Exec(`
+		UPDATE wan_configs SET
+			name = ?, connection_type = ?, vlan = ?, username = ?, password = ?,
+			ip_address = ?, subnet_mask = ?, gateway = ?, dns1 = ?, dns2 = ?,
+			mtu = ?, enabled = ?, nat_enabled = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`,
+		config.Name, config.ConnectionType, config.VLAN, config.Username, config.Password,
+		config.IPAddress, config.SubnetMask, config.Gateway, config.DNS1, config.DNS2,
+		config.MTU, config.Enabled, config.NATEnabled, config.ID,
+	)
+	return err
+}
+
+// DeleteWANConfig deletes a WAN configuration
+func (db *DB) DeleteWANConfig(id int64) error {
+	_, err := db.Exec("DELETE FROM wan_configs WHERE id = ?", id)
+	return err
+}
+
+// ============== Task Operations ==============
+
+// GetPendingTasks retrieves pending tasks for a device, highest priority first
+func (db *DB) GetPendingTasks(deviceID int64) ([]*models.DeviceTask, error) {
+	rows, err := db.Query(`
+		SELECT id, device_id, type, status, parameters, priority, result, error,
+			   created_at, started_at, completed_at, previous_values, retry_count, COALESCE(verification, '')
+		FROM tasks
+		WHERE device_id = ? AND status = 'pending'
+		ORDER BY priority DESC, created_at ASC, id ASC
+	`, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tasks []*models.DeviceTask
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, task)
+	}
+
+	return tasks, nil
+}
+
+// GetTask retrieves a task by ID
+func (db *DB) GetTask(id int64) (*models.DeviceTask, error) {
+	rows, err := db.Query(`
+		SELECT id, device_id, type, status, parameters, priority, result, error,
+			   created_at, started_at, completed_at, previous_values, retry_count, COALESCE(verification, '')
+		FROM tasks WHERE id = ?
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, sql.ErrNoRows
+	}
+	return scanTask(rows)
+}
+
+// StartTaskVerification marks a completed SetParameterValues task as waiting
+// for the read-back task readBackID
+func (db *DB) StartTaskVerification(taskID, readBackID int64) error {
+	_, err := db.Exec(`UPDATE tasks SET verification = ?, verify_task_id = ? WHERE id = ?`,
+		models.VerificationPending, readBackID, taskID)
+	return err
+}
+
+// GetTaskVerifiedBy returns the task whose writes the read-back task
+// readBackID checks; sql.ErrNoRows when it is not a read-back
+func (db *DB) GetTaskVerifiedBy(readBackID int64) (*models.DeviceTask, error) {
+	rows, err := db.Query(`
+		SELECT id, device_id, type, status, parameters, priority, result, error,
+			   created_at, started_at, completed_at, previous_values, retry_count, COALESCE(verification, '')
+		FROM tasks WHERE verify_task_id = ?
+	`, readBackID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, sql.ErrNoRows
+	}
+	return scanTask(rows)
+}
+
+// FinishTaskVerification stores the read-back outcome of a task, with the
+// values the device did not apply in its result
+func (db *DB) FinishTaskVerification(taskID int64, verification string, mismatches []models.ParameterMismatch) error {
+	if mismatches == nil {
+		mismatches = []models.ParameterMismatch{}
+	}
+	result, _ := json.Marshal(map[string]interface{}{
+		"verification": verification,
+		"mismatches":   mismatches,
+	})
+	_, err := db.Exec(`UPDATE tasks SET verification = ?, result = ? WHERE id = ?`, verification, string(result), taskID)
+	return err
+}
+
+// requeueTaskSQL resets failed tasks to pending so the ACS sends them again on
+// the next session, counting the attempt
+const requeueTaskSQL = `
+	UPDATE tasks SET status = 'pending', error = NULL, result = NULL, started_at = NULL, completed_at = NULL,
+		retry_count = COALESCE(retry_count, 0) + 1
+	WHERE status = 'failed' AND `
+
+// ErrTaskNotFailed is returned when retrying a task that has not failed
+var ErrTaskNotFailed = errors.New("task has not failed")
+
+// RetryTask requeues a failed task. Unknown IDs give sql.ErrNoRows, tasks in
+// any other state ErrTaskNotFailed.
+func (db *DB) RetryTask(id int64) error {
+	result, err := db.Exec(requeueTaskSQL+"id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		return nil
+	}
+
+	var exists int
+	if err := db.QueryRow("SELECT 1 FROM tasks WHERE id = ?", id).Scan(&exists); err != nil {
+		return err
+	}
+	return ErrTaskNotFailed
+}
+
+// Errors recorded when prunePendingTasks discards a queued task. These tasks
+// were replaced on purpose and are never requeued automatically.
+const (
+	taskErrSuperseded = "superseded by a newer task"
+	taskErrDropped    = "dropped: pending task limit reached"
+)
+
+// autoRequeueFilter limits automatic requeues to real failures since the
+// retry setting was switched on, with retries left
+const autoRequeueFilter = `COALESCE(retry_count, 0) < ? AND COALESCE(error, '') NOT IN (?, ?)
+	AND datetime(completed_at) >= datetime(?)`
+
+// RequeueFailedTasks requeues every task that failed after since and was
+// retried fewer than maxRetries times, and returns the affected device IDs
+func (db *DB) RequeueFailedTasks(maxRetries int, since time.Time) ([]int64, error) {
+	if maxRetries <= 0 {
+		return nil, nil
+	}
+	args := []interface{}{maxRetries, taskErrSuperseded, taskErrDropped, since.UTC().Format("2006-01-02 15:04:05")}
+
+	var deviceIDs []int64
+	err := db.WithTx(func(tx *sql.Tx) error {
+		deviceIDs = nil
+		rows, err := tx.Query(`
+			SELECT DISTINCT device_id FROM tasks WHERE status = 'failed' AND `+autoRequeueFilter, args...)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			deviceIDs = append(deviceIDs, id)
+		}
+		rows.Close()
+
+		_, err = tx.Exec(requeueTaskSQL+autoRequeueFilter, args...)
+		return err
+	})
+	return deviceIDs, err
+}
+
+// ErrTaskNotPending is returned when deleting a task the ACS has already picked up
+var ErrTaskNotPending = errors.New("task is not pending")
+
+// DeleteTask removes a pending task. Tasks that are running or finished are
+// left in place and ErrTaskNotPending is returned; unknown IDs give sql.ErrNoRows.
+func (db *DB) DeleteTask(id int64) error {
+	result, err := db.Exec("DELETE FROM tasks WHERE id = ? AND status = ?", id, models.TaskPending)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		return nil
+	}
+
+	var exists int
+	if err := db.QueryRow("SELECT 1 FROM tasks WHERE id = ?", id).Scan(&exists); err != nil {
+		return err
+	}
+	return ErrTaskNotPending
+}
+
+// CreateTask creates a new task
+func (db *DB) CreateTask(task *models.DeviceTask) (*models.DeviceTask, error) {
+	if err := db.WithTx(func(tx *sql.Tx) error { return db.insertTask(tx, task) }); err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+// ErrDevicePending is returned when a task is queued for a device that is
+// still awaiting operator approval
+var ErrDevicePending = errors.New("device is awaiting approval")
+
+// insertTask queues a task within tx. Every task insert goes through here so
+// SetParameterValues tasks get their previous-value snapshot, the per-device
+// pending cap is enforced and devices awaiting approval get no tasks.
+func (db *DB) insertTask(tx *sql.Tx, task *models.DeviceTask) error {
+	var status string
+	if err := tx.QueryRow(`SELECT status FROM devices WHERE id = ?`, task.DeviceID).Scan(&status); err == nil &&
+		models.DeviceStatus(status) == models.StatusPending {
+		return ErrDevicePending
+	}
+
+	if task.Priority == 0 {
+		task.Priority = models.DefaultTaskPriority(task.Type)
+	}
+
+	var previous interface{}
+	if task.Type == models.TaskSetParameterValues {
+		snapshot, err := snapshotParameters(tx, task.DeviceID, task.Parameters)
+		if err != nil {
+			return err
+		}
+		task.PreviousValues = snapshot
+		if snapshot != nil {
+			previous = string(snapshot)
+		}
+	}
+
+	result, err := tx.Exec(`
+		INSERT INTO tasks (device_id, type, status, parameters, priority, previous_values)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, task.DeviceID, task.Type, models.TaskPending, string(task.Parameters), task.Priority, previous)
+	if err != nil {
+		return err
+	}
+	task.ID, _ = result.LastInsertId()
+
+	max := db.MaxPendingTasks()
+	dropped, err := prunePendingTasks(tx, task.DeviceID, task.ID, max)
+	if err != nil {
+		return err
+	}
+	if dropped > 0 {
+		fmt.Printf("[TASK] Device %d exceeded %d pending tasks, dropped %d stale task(s)\n", task.DeviceID, max, dropped)
+	}
+	task.Status = models.TaskPending
+	return nil
+}
+
+// snapshotParameters records the last known values of the parameters a
+// SetParameterValues task is about to write (the "last known good" config).
+// Parameters the ACS has never read are left out and cannot be reverted.
+func snapshotParameters(tx *sql.Tx, deviceID int64, parameters json.RawMessage) (json.RawMessage, error) {
+	var params map[string]interface{}
+	if err := json.Unmarshal(parameters, &params); err != nil || len(params) == 0 {
+		return nil, nil
+	}
+
+	previous := make(map[string]string)
+	for path := range params {
+		var value sql.NullString
+		err := tx.QueryRow("SELECT value FROM device_parameters WHERE device_id = ? AND path = ?", deviceID, path).Scan(&value)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		previous[path] = value.String
+	}
+	if len(previous) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(previous)
+}
+
+// MaxPendingTasks returns the per-device pending task cap; 0 = unlimited
+func (db *DB) MaxPendingTasks() int {
+	return int(db.maxPendingTasks.Load())
+}
+
+// SetMaxPendingTasks changes the per-device pending task cap. It is safe to
+// call while tasks are being created.
+func (db *DB) SetMaxPendingTasks(n int) {
+	db.maxPendingTasks.Store(int64(n))
+}
+
+// prunePendingTasks enforces the per-device pending task cap after keepID
+// was queued. Superseded tasks go first: duplicates of the same type and
+// parameters (any refresh counts as a duplicate of another) keep only the
+// newest. If the device is still over the cap, the oldest lowest-priority
+// tasks are dropped. keepID itself is never pruned, so the caller's task
+// always stays queued. Dropped tasks are marked failed rather than deleted so
+// they stay visible.
+func prunePendingTasks(tx *sql.Tx, deviceID, keepID int64, max int) (int64, error) {
+	if max <= 0 {
+		return 0, nil
+	}
+
+	var pending int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM tasks WHERE device_id = ? AND status = 'pending'", deviceID).Scan(&pending); err != nil {
+		return 0, err
+	}
+	if pending <= max {
+		return 0, nil
+	}
+
+	res, err := tx.Exec(`
+		UPDATE tasks SET status = 'failed', error = ?, completed_at = CURRENT_TIMESTAMP
+		WHERE device_id = ? AND status = 'pending' AND id != ? AND id NOT IN (
+			SELECT MAX(id) FROM tasks WHERE device_id = ? AND status = 'pending'
+			GROUP BY type, CASE WHEN type = 'refresh' THEN '' ELSE COALESCE(parameters, '') END
+		)
+	`, taskErrSuperseded, deviceID, keepID, deviceID)
+	if err != nil {
+		return 0, err
+	}
+	dropped, _ := res.RowsAffected()
+
+	excess := pending - int(dropped) - max
+	if excess <= 0 {
+		return dropped, nil
+	}
+
+	res, err = tx.Exec(`
+		UPDATE tasks SET status = 'failed', error = ?, completed_at = CURRENT_TIMESTAMP
+		WHERE id IN (
+			SELECT id FROM tasks WHERE device_id = ? AND status = 'pending' AND id != ?
+			ORDER BY priority ASC, created_at ASC, id ASC LIMIT ?
+		)
+	`, taskErrDropped, deviceID, keepID, excess)
+	if err != nil {
+		return dropped, err
+	}
+	n, _ := res.RowsAffected()
+	return dropped + n, nil
+}
+
+// UpdateTask updates a task in the database
+func (db *DB) UpdateTask(task *models.DeviceTask) error {
+	paramsJSON, _ := json.Marshal(task.Parameters)
+	resultJSON, _ := json.Marshal(task.Result)
+
+	_, err := db.Exec(`
+		UPDATE tasks SET
+			status = ?,
+			parameters = ?,
+			result = ?,
+			error = ?,
+			started_at = ?,
+			completed_at = ?
+		WHERE id = ?
+	`, task.Status, string(paramsJSON), string(resultJSON), task.Error, task.StartedAt, task.CompletedAt, task.ID)
+	return err
+}
+
+// UpdateTaskStatus updates a task's status
+func (db *DB) UpdateTaskStatus(id int64, status models.TaskStatus, result json.RawMessage, errMsg string) error {
+	_, err := db.Exec(`
+		UPDATE tasks SET
+			status = ?,
+			result = ?,
+			error = ?,
+			started_at = CASE WHEN ? = 'running' AND started_at IS NULL THEN CURRENT_TIMESTAMP ELSE started_at END,
+			completed_at = CASE WHEN ? IN ('completed', 'failed') THEN CURRENT_TIMESTAMP ELSE completed_at END
+		WHERE id = ?
+	`, status, string(result), errMsg, status, status, id)
+	return err
+}
+
+// GetWrittenParameterValues returns the last value written to each parameter
+// of a device by its completed SetParameterValues tasks, oldest first so the
+// latest write wins. Writes the read-back showed were not applied are
+// ignored. Devices often report secrets such as WiFi keys and PPPoE
+// passwords empty, so this is the only place their values are known.
+func (db *DB) GetWrittenParameterValues(deviceID int64) (map[string]string, error) {
+	rows, err := db.Query(`
+		SELECT parameters FROM tasks
+		WHERE device_id = ? AND type = ? AND status = ? AND COALESCE(verification, '') != ?
+		ORDER BY id ASC
+	`, deviceID, models.TaskSetParameterValues, models.TaskCompleted, models.VerificationNotApplied)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := make(map[string]string)
+	for rows.Next() {
+		var raw sql.NullString
+		if err := rows.Scan(&raw); err != nil {
+			return nil, err
+		}
+		var params map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(raw.String), &params); err != nil {
+			continue
+		}
+		for path, value := range params {
+			if string(value) == "null" {
+				continue
+			}
+			var s string
+			if err := json.Unmarshal(value, &s); err != nil {
+				s = string(value) // Number or boolean
+			}
+			values[path] = s
+		}
+	}
+	return values, rows.Err()
+}
+
+// ============== Preset Operations ==============
+
+const presetColumns = `id, name, description, filter, provisions, weight, enabled, events, created_at, updated_at`
+
+func scanPreset(row rowScanner) (*models.Preset, error) {
+	var p models.Preset
+	var description, filter, provisions, events sql.NullString
+	if err := row.Scan(&p.ID, &p.Name, &description, &filter, &provisions, &p.Weight, &p.Enabled, &events, &p.CreatedAt, &p.UpdatedAt); err != nil {
+		return nil, err
+	}
+	p.Description = description.String
+	if filter.String != "" {
+		p.Filter = json.RawMessage(filter.String)
+	}
+	if provisions.String != "" {
+		p.Provisions = json.RawMessage(provisions.String)
+	}
+	json.Unmarshal([]byte(events.String), &p.Events)
+	return &p, nil
+}
+
+// rawJSONOrNil stores an empty JSON document as NULL
+func rawJSONOrNil(raw json.RawMessage) interface{} {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+	return string(raw)
+}
+
+// GetPresets retrieves all presets ordered by weight
+func (db *DB) GetPresets() ([]*models.Preset, error) {
+	rows, err := db.Query(`SELECT ` + presetColumns + ` FROM presets ORDER BY weight, name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	presets := []*models.Preset{}
+	for rows.Next() {
+		p, err := scanPreset(rows)
+		if err != nil {
+			return nil, err
+		}
+		presets = append(presets, p)
+	}
+	return presets, rows.Err()
+}
+
+// GetPreset retrieves a preset by ID
+func (db *DB) GetPreset(id int64) (*models.Preset, error) {
+	return scanPreset(db.QueryRow(`SELECT `+presetColumns+` FROM presets WHERE id = ?`, id))
+}
+
+// CreatePreset creates a new preset
+func (db *DB) CreatePreset(p *models.Preset) (*models.Preset, error) {
+	eventsJSON, _ := json.Marshal(p.Events)
+	result, err := db.Exec(`
+		INSERT INTO presets (name, description, filter, provisions, weight, enabled, events)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, p.Name, p.Description, rawJSONOrNil(p.Filter), rawJSONOrNil(p.Provisions), p.Weight, p.Enabled, string(eventsJSON))
+	if err != nil {
+		return nil, err
+	}
+	id, _ := result.LastInsertId()
+	return db.GetPreset(id)
+}
+
+// UpdatePreset updates a preset
+func (db *DB) UpdatePreset(p *models.Preset) error {
+	eventsJSON, _ := json.Marshal(p.Events)
+	_, err := db.Exec(`
+		UPDATE presets SET name = ?, description = ?, filter = ?, provisions = ?, weight = ?, enabled = ?, events = ?,
+		updated_at = CURRENT_TIMESTAMP WHERE id = ?
+	`, p.Name, p.Description, rawJSONOrNil(p.Filter), rawJSONOrNil(p.Provisions), p.Weight, p.Enabled, string(eventsJSON), p.ID)
+	return err
+}
+
+// DeletePreset deletes a preset
+func (db *DB) DeletePreset(id int64) error {
+	_, err := db.Exec("DELETE FROM presets WHERE id = ?", id)
+	return err
+}
+
+// GetMatchingPresets returns the enabled presets whose filter selects the
+// device, ordered by weight
+func (db *DB) GetMatchingPresets(device *models.Device) ([]*models.Preset, error) {
+	presets, err := db.GetPresets()
+	if err != nil {
+		return nil, err
+	}
+	matching := []*models.Preset{}
+	for _, p := range presets {
+		if p.Matches(device) {
+			matching = append(matching, p)
+		}
+	}
+	return matching, nil
+}
+
+// HasPendingTask reports whether an identical task is already queued for the device
+func (db *DB) HasPendingTask(deviceID int64, taskType models.TaskType, parameters json.RawMessage) bool {
+	var count int
+	db.QueryRow(`
+		SELECT COUNT(*) FROM tasks WHERE device_id = ? AND type = ? AND status = ? AND parameters = ?
+	`, deviceID, taskType, models.TaskPending, string(parameters)).Scan(&count)
+	return count > 0
+}
+
+// ============== Poll Profile Operations ==============
+
+// GetPollProfiles retrieves all poll profiles
+func (db *DB) GetPollProfiles() ([]*models.PollProfile, error) {
+	rows, err := db.Query(`
+		SELECT id, name, manufacturer, model_name, paths, enabled, created_at, updated_at
+		FROM poll_profiles ORDER BY manufacturer, model_name
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	profiles := []*models.PollProfile{}
+	for rows.Next() {
+		var p models.PollProfile
+		var model, paths sql.NullString
+		if err := rows.Scan(&p.ID, &p.Name, &p.Manufacturer, &model, &paths, &p.Enabled, &p.CreatedAt, &p.UpdatedAt); err != nil {
+			return nil, err
+		}
+		p.ModelName = model.String
+		json.Unmarshal([]byte(paths.String), &p.Paths)
+		profiles = append(profiles, &p)
+	}
+	return profiles, nil
+}
+
+// GetPollProfile retrieves a poll profile by ID
+func (db *DB) GetPollProfile(id int64) (*models.PollProfile, error) {
+	var p models.PollProfile
+	var model, paths sql.NullString
+	err := db.QueryRow(`
+		SELECT id, name, manufacturer, model_name, paths, enabled, created_at, updated_at
+		FROM poll_profiles WHERE id = ?
+	`, id).Scan(&p.ID, &p.Name, &p.Manufacturer, &model, &paths, &p.Enabled, &p.CreatedAt, &p.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	p.ModelName = model.String
+	json.Unmarshal([]byte(paths.String), &p.Paths)
+	return &p, nil
+}
+
+// CreatePollProfile creates a new poll profile
+func (db *DB) CreatePollProfile(p *models.PollProfile) (*models.PollProfile, error) {
+	pathsJSON, _ := json.Marshal(p.Paths)
+	result, err := db.Exec(`
+		INSERT INTO poll_profiles (name, manufacturer, model_name, paths, enabled)
+		VALUES (?, ?, ?, ?, ?)
+	`, p.Name, p.Manufacturer, p.ModelName, string(pathsJSON), p.Enabled)
+	if err != nil {
+		return nil, err
+	}
+	id, _ := result.LastInsertId()
+	return db.GetPollProfile(id)
+}
+
+// UpdatePollProfile updates a poll profile
+func (db *DB) UpdatePollProfile(p *models.PollProfile) error {
+	pathsJSON, _ := json.Marshal(p.Paths)
+	_, err := db.Exec(`
+		UPDATE poll_profiles SET name = ?, manufacturer = ?, model_name = ?, paths = ?, enabled = ?,
+		updated_at = CURRENT_TIMESTAMP WHERE id = ?
+	`, p.Name, p.Manufacturer, p.ModelName, string(pathsJSON), p.Enabled, p.ID)
+	return err
+}
+
+// DeletePollProfile deletes a poll profile
+func (db *DB) DeletePollProfile(id int64) error {
+	_, err := db.Exec("DELETE FROM poll_profiles WHERE id = ?", id)
+	return err
+}
+
+// ============== Tag Operations ==============
+
+// GetTags returns all known tags (managed and ad-hoc) with device counts
+func (db *DB) GetTags() ([]*models.Tag, error) {
+	tags := make(map[string]*models.Tag)
+
+	rows, err := db.Query("SELECT id, name, color, description, created_at FROM tags")
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var t models.Tag
+		var color, description sql.NullString
+		if err := rows.Scan(&t.ID, &t.Name, &color, &description, &t.CreatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		t.Color = color.String
+		t.Description = description.String
+		tags[t.Name] = &t
+	}
+	rows.Close()
+
+	rows, err = db.Query("SELECT tags FROM devices WHERE tags IS NOT NULL AND tags != '' AND tags != 'null'")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var tagsStr string
+		if err := rows.Scan(&tagsStr); err != nil {
+			return nil, err
+		}
+		var deviceTags []string
+		json.Unmarshal([]byte(tagsStr), &deviceTags)
+		for _, name := range deviceTags {
+			t, ok := tags[name]
+			if !ok {
+				t = &models.Tag{Name: name}
+				tags[name] = t
+			}
+			t.DeviceCount++
+		}
+	}
+
+	result := make([]*models.Tag, 0, len(tags))
+	for _, t := range tags {
+		result = append(result, t)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result, nil
+}
+
+// CreateTag creates a managed tag
+func (db *DB) CreateTag(tag *models.Tag) (*models.Tag, error) {
+	result, err := db.Exec("INSERT INTO tags (name, color, description) VALUES (?, ?, ?)",
+		tag.Name, tag.Color, tag.Description)
+	if err != nil {
+		return nil, err
+	}
+	tag.ID, _ = result.LastInsertId()
+	tag.CreatedAt = time.Now()
+	return tag, nil
+}
+
+// DeleteTag removes a tag definition and strips it from every device
+func (db *DB) DeleteTag(name string) (int, error) {
+	if _, err := db.Exec("DELETE FROM tags WHERE name = ?", name); err != nil {
+		return 0, err
+	}
+	ids, err := db.getDeviceIDsWithTags()
+	if err != nil {
+		return 0, err
+	}
+	return db.BulkUpdateDeviceTags(ids, nil, []string{name})
+}
+
+// BulkUpdateDeviceTags adds and removes tags on a set of devices in one transaction.
+// It returns the number of devices whose tags changed.
+func (db *DB) BulkUpdateDeviceTags(deviceIDs []int64, add, remove []string) (int, error) {
+	changed := 0
+	err := db.WithTx(func(tx *sql.Tx) error {
+		changed = 0
+		for _, id := range deviceIDs {
+			var tagsStr sql.NullString
+			if err := tx.QueryRow("SELECT tags FROM devices WHERE id = ?", id).Scan(&tagsStr); err != nil {
+				if err == sql.ErrNoRows {
+					continue
+				}
+				return err
+			}
+
+			var current []string
+			if tagsStr.Valid && tagsStr.String != "" {
+				json.Unmarshal([]byte(tagsStr.String), &current)
+			}
+
+			updated := mergeTags(current, add, remove)
+			if len(updated) == len(current) && strings.Join(updated, ",") == strings.Join(current, ",") {
+				continue
+			}
+
+			tagsJSON, _ := json.Marshal(updated)
+			if _, err := tx.Exec("UPDATE devices SET tags = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", string(tagsJSON), id); err != nil {
+				return err
+			}
+			changed++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return changed, nil
+}
+
+// getDeviceIDsWithTags returns the IDs of devices that carry at least one tag
+func (db *DB) getDeviceIDsWithTags() ([]int64, error) {
+	rows, err := db.Query("SELECT id FROM devices WHERE tags IS NOT NULL AND tags != '' AND tags != 'null' AND tags != '[]'")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// mergeTags applies additions and removals to a tag list, keeping order and dropping duplicates
+func mergeTags(current, add, remove []string) []string {
+	removeSet := make(map[string]bool, len(remove))
+	for _, t := range remove {
+		removeSet[t] = true
+	}
+
+	seen := make(map[string]bool)
+	result := make([]string, 0, len(current)+len(add))
+	for _, t := range append(append([]string{}, current...), add...) {
+		t = strings.TrimSpace(t)
+		if t == "" || seen[t] || removeSet[t] {
+			continue
+		}
+		seen[t] = true
+		result = append(result, t)
+	}
+	return result
+}
+
+// ============== Dashboard Operations ==============
+
+// CountDeployedDevices returns how many deployed devices there are and how
+// many of them are online
+func (db *DB) CountDeployedDevices() (total, online int64, err error) {
+	err = db.QueryRow(`
+		SELECT COUNT(*), COALESCE(SUM(CASE WHEN status = 'online' THEN 1 ELSE 0 END), 0)
+		FROM devices WHERE COALESCE(lifecycle_state, 'deployed') = 'deployed'
+	`).Scan(&total, &online)
+	return total, online, err
+}
+
+// GetDashboardStats retrieves dashboard statistics
+func (db *DB) GetDashboardStats() (*models.DashboardStats, error) {
+	stats := &models.DashboardStats{
+		DevicesByModel: make(map[string]int64),
+	}
+
+	// Total and online devices (stock and retired units are not part of network health)
+	stats.TotalDevices, stats.OnlineDevices, _ = db.CountDeployedDevices()
+
+	// Offline devices
+	stats.OfflineDevices = stats.TotalDevices - stats.OnlineDevices
+
+	// Inventory
+	db.QueryRow("SELECT COUNT(*) FROM devices WHERE lifecycle_state = 'stock'").Scan(&stats.StockDevices)
+	db.QueryRow("SELECT COUNT(*) FROM devices WHERE lifecycle_state = 'retired'").Scan(&stats.RetiredDevices)
+
+	// Pending tasks
+	db.QueryRow("SELECT COUNT(*) FROM tasks WHERE status = 'pending'").Scan(&stats.PendingTasks)
+
+	// Devices by model
+	rows, err := db.Query(`
+		SELECT COALESCE(model_name, 'Unknown'), COUNT(*)
+		FROM devices
+		GROUP BY model_name
+		ORDER BY COUNT(*) DESC
+		LIMIT 10
+	`)
+	if err == nil {
+		defer rows.Close()
+		for rows.Next() {
+			var model string
+			var count int64
+			if rows.Scan(&model, &count) == nil {
+				stats.DevicesByModel[model] = count
+			}
+		}
+	}
+
+	// Recent activity
+	activityRows, err := db.Query(`
+		SELECT l.category, l.message, l.created_at, d.id, d.serial_number
+		FROM logs l
+		LEFT JOIN devices d ON l.device_id = d.id
+		ORDER BY l.created_at DESC
+		LIMIT 10
+	`)
+	if err == nil {
+		defer activityRows.Close()
+		for activityRows.Next() {
+			var activity models.ActivityItem
+			var deviceID sql.NullInt64
+			var deviceSN sql.NullString
+			if activityRows.Scan(&activity.Type, &activity.Message, &activity.Timestamp, &deviceID, &deviceSN) == nil {
+				if deviceID.Valid {
+					activity.DeviceID = deviceID.Int64
+				}
+				if deviceSN.Valid {
+					activity.DeviceSN = deviceSN.String
+				}
+				stats.RecentActivity = append(stats.RecentActivity, activity)
+			}
+		}
+	}
+
+	return stats, nil
+}
+
+// ============== Log Operations ==============
+
+// CreateLog creates a new log entry
+func (db *DB) CreateLog(deviceID *int64, level, category, message, details string) error {
+	_, err := db.Exec(`
+		INSERT INTO logs (device_id, level, category, message, details)
+		VALUES (?, ?, ?, ?, ?)
+	`, deviceID, level, category, message, details)
+	return err
+}
+
+// GetLogs retrieves logs with filtering
+func (db *DB) GetLogs(filter models.LogFilter, limit, offset int) ([]*models.Log, int64, error) {
+	whereClause, args := logFilterClause(filter)
+
+	var total int64
+	if err := db.QueryRow("SELECT COUNT(*) FROM logs "+whereClause, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := fmt.Sprintf(`
+		SELECT id, device_id, level, category, message, details, created_at
+		FROM logs %s
+		ORDER BY created_at DESC
+		LIMIT ? OFFSET ?
+	`, whereClause)
+
+	args = append(args, limit, offset)
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var logs []*models.Log
+	for rows.Next() {
+		var l models.Log
+		var deviceID sql.NullInt64
+		err := rows.Scan(&l.ID, &deviceID, &l.Level, &l.Category, &l.Message, &l.Details, &l.CreatedAt)
+		if err != nil {
+			return nil, 0, err
+		}
+		if deviceID.Valid {
+			l.DeviceID = &deviceID.Int64
+		}
+		logs = append(logs, &l)
+	}
+
+	return logs, total, nil
+}
+
+// StreamLogs calls fn for every log matching the filter, oldest first,
+// without loading the result set into memory. It stops at the first error fn returns.
+func (db *DB) StreamLogs(filter models.LogFilter, fn func(*models.Log) error) error {
+	whereClause, args := logFilterClause(filter)
+	rows, err := db.Query(`
+		SELECT id, device_id, level, category, message, details, created_at
+		FROM logs `+whereClause+`
+		ORDER BY created_at, id
+	`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var l models.Log
+		var deviceID sql.NullInt64
+		var details sql.NullString
+		if err := rows.Scan(&l.ID, &deviceID, &l.Level, &l.Category, &l.Message, &details, &l.CreatedAt); err != nil {
+			return err
+		}
+		if deviceID.Valid {
+			l.DeviceID = &deviceID.Int64
+		}
+		l.Details = details.String
+		if err := fn(&l); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// RecordAudit stores an admin audit log entry
+func (db *DB) RecordAudit(e *models.AuditEntry) error {
+	_, err := db.Exec(`
+		INSERT INTO audit_log (user_id, username, action, target_type, target_id, ip, status)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, e.UserID, e.Username, e.Action, e.TargetType, e.TargetID, e.IP, e.Status)
+	return err
+}
+
+// auditFilterClause builds the WHERE clause for an audit log filter; action
+// matches partially (e.g. "isolir")
+func auditFilterClause(filter models.AuditFilter) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	if filter.Action != "" {
+		conditions = append(conditions, "action LIKE ?")
+		args = append(args, "%"+filter.Action+"%")
+	}
+	if filter.User != "" {
+		conditions = append(conditions, "username = ? COLLATE NOCASE")
+		args = append(args, filter.User)
+	}
+	if filter.From != nil {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, filter.From.UTC().Format("2006-01-02 15:04:05"))
+	}
+	if filter.To != nil {
+		conditions = append(conditions, "created_at <= ?")
+		args = append(args, filter.To.UTC().Format("2006-01-02 15:04:05"))
+	}
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return "WHERE " + strings.Join(conditions, " AND "), args
+}
+
+func scanAuditEntry(row rowScanner) (*models.AuditEntry, error) {
+	var e models.AuditEntry
+	var userID, status sql.NullInt64
+	var targetType, targetID, ip sql.NullString
+	if err := row.Scan(&e.ID, &userID, &e.Username, &e.Action, &targetType, &targetID, &ip, &status, &e.CreatedAt); err != nil {
+		return nil, err
+	}
+	e.UserID = userID.Int64
+	e.TargetType = targetType.String
+	e.TargetID = targetID.String
+	e.IP = ip.String
+	e.Status = int(status.Int64)
+	return &e, nil
+}
+
+// GetAuditLog returns the newest audit log entries matching filter
+func (db *DB) GetAuditLog(filter models.AuditFilter, limit int) ([]*models.AuditEntry, error) {
+	whereClause, args := auditFilterClause(filter)
+	rows, err := db.Query(`
+		SELECT id, user_id, username, action, target_type, target_id, ip, status, created_at
+		FROM audit_log `+whereClause+`
+		ORDER BY created_at DESC, id DESC
+		LIMIT ?
+	`, append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*models.AuditEntry{}
+	for rows.Next() {
+		e, err := scanAuditEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// StreamAuditLog calls fn for every audit log entry matching filter, oldest
+// first, without loading them all into memory
+func (db *DB) StreamAuditLog(filter models.AuditFilter, fn func(*models.AuditEntry) error) error {
+	whereClause, args := auditFilterClause(filter)
+	rows, err := db.Query(`
+		SELECT id, user_id, username, action, target_type, target_id, ip, status, created_at
+		FROM audit_log `+whereClause+`
+		ORDER BY created_at, id
+	`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e, err := scanAuditEntry(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// logFilterClause builds the WHERE clause shared by log listing and export
+func logFilterClause(filter models.LogFilter) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+
+	if filter.DeviceID != nil {
+		conditions = append(conditions, "device_id = ?")
+		args = append(args, *filter.DeviceID)
+	}
+
+	if filter.Level != "" && filter.Level != "all" {
+		conditions = append(conditions, "level = ?")
+		args = append(args, filter.Level)
+	}
+
+	if filter.Category != "" && filter.Category != "all" {
+		conditions = append(conditions, "category = ?")
+		args = append(args, filter.Category)
+	}
+
+	// created_at is stored by SQLite as UTC "YYYY-MM-DD HH:MM:SS"
+	if filter.From != nil {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, filter.From.UTC().Format("2006-01-02 15:04:05"))
+	}
+	if filter.To != nil {
+		conditions = append(conditions, "created_at <= ?")
+		args = append(args, filter.To.UTC().Format("2006-01-02 15:04:05"))
+	}
+
+	if len(conditions) == 0 {
+		return "", args
+	}
+	return "WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// ============== Helper Functions ==============
+
+func scanDevice(rows *sql.Rows) (*models.Device, error) {
+	var d models.Device
+	var lastInform, lastContact sql.NullTime
+	var paramsStr, tagsStr, notes, address, templateStr sql.NullString
+	var lat, long, temp sql.NullFloat64
+	var rxPower sql.NullFloat64
+	var clientCount sql.NullInt64
+	var customerID sql.NullInt64
+
+	err := rows.Scan(
+		&d.ID, &d.SerialNumber, &d.OUI, &d.ProductClass, &d.Manufacturer,
+		&d.ModelName, &d.HardwareVersion, &d.SoftwareVersion, &d.ConnectionRequest,
+		&d.Status, &lastInform, &lastContact, &d.IPAddress, &d.MACAddress,
+		&d.Uptime, &rxPower, &clientCount, &templateStr,
+		&paramsStr, &tagsStr, &notes, &d.CreatedAt, &d.UpdatedAt,
+		&lat, &long, &address, &temp, &customerID, &d.LifecycleState, &d.Label,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	d.RXPower = rxPower.Float64
+	d.ClientCount = int(clientCount.Int64)
+	d.Template = templateStr.String
+	d.Latitude = lat.Float64
+	d.Longitude = long.Float64
+	d.Temperature = temp.Float64
+	d.Address = address.String
+	if customerID.Valid {
+		d.CustomerID = &customerID.Int64
+	}
+
+	if lastInform.Valid {
+		d.LastInform = &lastInform.Time
+	}
+	if lastContact.Valid {
+		d.LastContact = &lastContact.Time
+	}
+	if notes.Valid {
+		d.Notes = notes.String
+	}
+
+	// Parse parameters JSON
+	d.Parameters = make(map[string]string)
+	if paramsStr.Valid && paramsStr.String != "" {
+		json.Unmarshal([]byte(paramsStr.String), &d.Parameters)
+	}
+
+	// Parse tags JSON
+	if tagsStr.Valid && tagsStr.String != "" {
+		json.Unmarshal([]byte(tagsStr.String), &d.Tags)
+	}
+
+	return &d, nil
+}
+
+func scanDeviceRow(row *sql.Row) (*models.Device, error) {
+	var d models.Device
+	var lastInform, lastContact sql.NullTime
+	var paramsStr, tagsStr, notes, address, templateStr sql.NullString
+	var lat, long, temp sql.NullFloat64
+	var rxPower sql.NullFloat64
+	var clientCount sql.NullInt64
+	var customerID sql.NullInt64
+
+	err := row.Scan(
+		&d.ID, &d.SerialNumber, &d.OUI, &d.ProductClass, &d.Manufacturer,
+		&d.ModelName, &d.HardwareVersion, &d.SoftwareVersion, &d.ConnectionRequest,
+		&d.Status, &lastInform, &lastContact, &d.IPAddress, &d.MACAddress,
+		&d.Uptime, &rxPower, &clientCount, &templateStr,
+		&paramsStr, &tagsStr, &notes, &d.CreatedAt, &d.UpdatedAt,
+		&lat, &long, &address, &temp, &customerID, &d.LifecycleState, &d.Label,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	d.RXPower = rxPower.Float64
+	d.ClientCount = int(clientCount.Int64)
+	d.Template = templateStr.String
+	d.Latitude = lat.Float64
+	d.Longitude = long.Float64
+	d.Temperature = temp.Float64
+	d.Address = address.String
+	if customerID.Valid {
+		d.CustomerID = &customerID.Int64
+	}
+
+	if lastInform.Valid {
+		d.LastInform = &lastInform.Time
+	}
+	if lastContact.Valid {
+		d.LastContact = &lastContact.Time
+	}
+	if notes.Valid {
+		d.Notes = notes.String
+	}
+
+	d.Parameters = make(map[string]string)
+	if paramsStr.Valid && paramsStr.String != "" {
+		json.Unmarshal([]byte(paramsStr.String), &d.Parameters)
+	}
+
+	if tagsStr.Valid && tagsStr.String != "" {
+		json.Unmarshal([]byte(tagsStr.String), &d.Tags)
+	}
+
+	return &d, nil
+}
+
+func scanTask(rows *sql.Rows) (*models.DeviceTask, error) {
+	var t models.DeviceTask
+	var params, result, previous sql.NullString
+	var errMsg sql.NullString
+	var priority, retryCount sql.NullInt64
+	var startedAt, completedAt sql.NullTime
+
+	err := rows.Scan(
+		&t.ID, &t.DeviceID, &t.Type, &t.Status, &params, &priority, &result,
+		&errMsg, &t.CreatedAt, &startedAt, &completedAt, &previous, &retryCount, &t.Verification,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if params.Valid {
+		t.Parameters = json.RawMessage(params.String)
+	}
+	if priority.Valid {
+		t.Priority = int(priority.Int64)
+	}
+	if result.Valid {
+		t.Result = json.RawMessage(result.String)
+	}
+	if errMsg.Valid {
+		t.Error = errMsg.String
+	}
+	if startedAt.Valid {
+		t.StartedAt = &startedAt.Time
+	}
+	if completedAt.Valid {
+		t.CompletedAt = &completedAt.Time
+	}
+	if previous.Valid && previous.String != "" {
+		t.PreviousValues = json.RawMessage(previous.String)
+	}
+	t.RetryCount = int(retryCount.Int64)
+
+	return &t, nil
+}
+
+// ============== Package Operations ==============
+
+// GetPackages retrieves all packages
+func (db *DB) GetPackages(activeOnly bool) ([]*models.Package, error) {
+	query := `
+		SELECT p.id, p.name, p.description, p.download_speed, p.upload_speed, p.quota, p.price, p.setup_fee, COALESCE(p.vlan, 0), COALESCE(p.discount_percent, 0), p.is_active, p.created_at, p.updated_at,
+		       (SELECT COUNT(*) FROM customers WHERE package_id = p.id) as subscribers
+		FROM packages p
+	`
+	if activeOnly {
+		query += " WHERE p.is_active = 1"
+	}
+	query += " ORDER BY p.price ASC"
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var packages []*models.Package
+	for rows.Next() {
+		var p models.Package
+		var desc sql.NullString
+		err := rows.Scan(&p.ID, &p.Name, &desc, &p.DownloadSpeed, &p.UploadSpeed, &p.Quota, &p.Price, &p.SetupFee, &p.VLAN, &p.DiscountPercent, &p.IsActive, &p.CreatedAt, &p.UpdatedAt, &p.Subscribers)
+		if err != nil {
+			return nil, err
+		}
+		if desc.Valid {
+			p.Description = desc.String
+		}
+		packages = append(packages, &p)
+	}
+	return packages, nil
+}
+
+// GetPackage retrieves a package by ID
+func (db *DB) GetPackage(id int64) (*models.Package, error) {
+	var p models.Package
+	var desc sql.NullString
+	err := db.QueryRow(`
+		SELECT id, name, description, download_speed, upload_speed, quota, price, setup_fee, COALESCE(vlan, 0), COALESCE(discount_percent, 0), is_active, created_at, updated_at,
+		       (SELECT COUNT(*) FROM customers WHERE package_id = id) as subscribers
+		FROM packages WHERE id = ?
+	`, id).Scan(&p.ID, &p.Name, &desc, &p.DownloadSpeed, &p.UploadSpeed, &p.Quota, &p.Price, &p.SetupFee, &p.VLAN, &p.DiscountPercent, &p.IsActive, &p.CreatedAt, &p.UpdatedAt, &p.Subscribers)
+	if err != nil {
+		return nil, err
+	}
+	if desc.Valid {
+		p.Description = desc.String
+	}
+	return &p, nil
+}
+
+// CreatePackage creates a new package
+func (db *DB) CreatePackage(pkg *models.Package) (*models.Package, error) {
+	result, err := db.Exec(`
+		INSERT INTO packages (name, description, download_speed, upload_speed, quota, price, setup_fee, vlan, discount_percent, is_active)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, pkg.Name, pkg.Description, pkg.DownloadSpeed, pkg.UploadSpeed, pkg.Quota, pkg.Price, pkg.SetupFee, pkg.VLAN, pkg.DiscountPercent, pkg.IsActive)
+	if err != nil {
+		return nil, err
+	}
+	id, _ := result.LastInsertId()
+	return db.GetPackage(id)
+}
+
+// UpdatePackage updates a package
+func (db *DB) UpdatePackage(pkg *models.Package) error {
+	_, err := db.Exec(`
+		UPDATE packages SET name = ?, description = ?, download_speed = ?, upload_speed = ?, quota = ?, 
+		price = ?, setup_fee = ?, vlan = ?, discount_percent = ?, is_active = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?
+	`, pkg.Name, pkg.Description, pkg.DownloadSpeed, pkg.UploadSpeed, pkg.Quota, pkg.Price, pkg.SetupFee, pkg.VLAN, pkg.DiscountPercent, pkg.IsActive, pkg.ID)
+	return err
+}
+
+// DeletePackage deletes a package
+func (db *DB) DeletePackage(id int64) error {
+	_, err := db.Exec("DELETE FROM packages WHERE id = ?", id)
+	return err
+}
+
+// ============== Customer Operations ==============
+
+// GetCustomers retrieves all customers with optional filtering
+func (db *DB) GetCustomers(status string, search string, limit, offset int) ([]*models.Customer, int64, error) {
+	whereClause, args := customerFilterClause(status, search)
+
+	// Get total count
+	var total int64
+	countQuery := "SELECT COUNT(*) FROM customers c " + whereClause
+	db.QueryRow(countQuery, args...).Scan(&total)
+
+	// Get customers
+	query := fmt.Sprintf(`%s %s ORDER BY c.created_at DESC LIMIT ? OFFSET ?`, customerListQuery, whereClause)
+
+	args = append(args, limit, offset)
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var customers []*models.Customer
+	for rows.Next() {
+		c, err := scanCustomerListRow(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		customers = append(customers, c)
+	}
+	return customers, total, nil
+}
+
+// StreamCustomers calls fn for every customer matching the GetCustomers
+// filters, oldest first, without loading the whole list into memory
+func (db *DB) StreamCustomers(status, search string, fn func(*models.Customer) error) error {
+	whereClause, args := customerFilterClause(status, search)
+	rows, err := db.Query(customerListQuery+" "+whereClause+" ORDER BY c.id", args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		c, err := scanCustomerListRow(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(c); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// customerFilterClause builds the WHERE clause shared by customer listing and
+// export. Columns are qualified because the listing joins packages, which
+// also has a name column.
+func customerFilterClause(status, search string) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+
+	if status != "" && status != "all" {
+		conditions = append(conditions, "c.status = ?")
+		args = append(args, status)
+	}
+
+	if search != "" {
+		conditions = append(conditions, "(c.customer_code LIKE ? OR c.name LIKE ? OR c.phone LIKE ?)")
+		searchPattern := "%" + search + "%"
+		args = append(args, searchPattern, searchPattern, searchPattern)
+	}
+
+	if len(conditions) == 0 {
+		return "", args
+	}
+	return "WHERE " + strings.Join(conditions, " AND "), args
+}
+
+const customerListQuery = `
+		SELECT c.id, c.customer_code, c.name, c.email, c.phone, c.address, c.latitude, c.longitude,
+		       c.package_id, c.username, c.status, c.join_date, c.balance, c.created_at, c.updated_at, c.fcm_token,
+		       p.name, p.price, p.download_speed, p.upload_speed
+		FROM customers c
+		LEFT JOIN packages p ON c.package_id = p.id`
+
+func scanCustomerListRow(row rowScanner) (*models.Customer, error) {
+	var c models.Customer
+	var email, phone, address, username, fcmToken sql.NullString
+	var packageID sql.NullInt64
+	var pkgName sql.NullString
+	var pkgPrice sql.NullFloat64
+	var pkgDown, pkgUp sql.NullInt64
+
+	err := row.Scan(&c.ID, &c.CustomerCode, &c.Name, &email, &phone, &address, &c.Latitude, &c.Longitude,
+		&packageID, &username, &c.Status, &c.JoinDate, &c.Balance, &c.CreatedAt, &c.UpdatedAt, &fcmToken,
+		&pkgName, &pkgPrice, &pkgDown, &pkgUp)
+	if err != nil {
+		return nil, err
+	}
+	c.Email = email.String
+	c.Phone = phone.String
+	c.Address = address.String
+	c.PackageID = packageID.Int64
+	c.Username = username.String
+	c.FCMToken = fcmToken.String
+
+	if pkgName.Valid {
+		c.Package = &models.Package{
+			ID:            packageID.Int64,
+			Name:          pkgName.String,
+			Price:         pkgPrice.Float64,
+			DownloadSpeed: int(pkgDown.Int64),
+			UploadSpeed:   int(pkgUp.Int64),
+		}
+	}
+	return &c, nil
+}
+
+// GetCustomerLocations retrieves customer locations for mapping
+func (db *DB) GetCustomerLocations() ([]models.CustomerLocation, error) {
+	query := `
+        SELECT c.id, c.name, COALESCE(c.latitude, 0), COALESCE(c.longitude, 0), c.status, c.address,
+               COALESCE(d.status, 'offline') as device_status
+        FROM customers c
+        LEFT JOIN devices d ON d.customer_id = c.id
+        GROUP BY c.id
+    `
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var locs []models.CustomerLocation
+	for rows.Next() {
+		var l models.CustomerLocation
+		var addr sql.NullString
+		if err := rows.Scan(&l.ID, &l.Name, &l.Latitude, &l.Longitude, &l.Status, &addr, &l.DeviceStatus); err != nil {
+			continue
+		}
+		l.Address = addr.String
+		locs = append(locs, l)
+	}
+	return locs, nil
+}
+
+// UpdateCustomerLocation updates the geolocation of a customer
+func (db *DB) UpdateCustomerLocation(id int64, lat, long float64, address string) error {
+	_, err := db.Exec("UPDATE customers SET latitude=?, longitude=?, address=?, updated_at=CURRENT_TIMESTAMP WHERE id=?", lat, long, address, id)
+	return err
+}
+
+// UpdateCustomerFCM updates the FCM token for a customer
+func (db *DB) UpdateCustomerFCM(id int64, token string) error {
+	_, err := db.Exec("UPDATE customers SET fcm_token=?, updated_at=CURRENT_TIMESTAMP WHERE id=?", token, id)
 	return err
 }
 
-// ============== Dashboard Operations ==============
+// CustomerWantsReceipts reports whether a customer accepts payment receipts;
+// customers default to yes
+func (db *DB) CustomerWantsReceipts(id int64) bool {
+	var notify sql.NullBool
+	if err := db.QueryRow("SELECT notify_receipts FROM customers WHERE id = ?", id).Scan(&notify); err != nil {
+		return true
+	}
+	return !notify.Valid || notify.Bool
+}
 
-// GetDashboardStats retrieves dashboard statistics
-func (db *DB) GetDashboardStats() (*models.DashboardStats, error) {
-	stats := &models.DashboardStats{
-		DevicesByModel: make(map[string]int64),
+// SetCustomerWantsReceipts stores a customer's payment receipt preference
+func (db *DB) SetCustomerWantsReceipts(id int64, enabled bool) error {
+	res, err := db.Exec("UPDATE customers SET notify_receipts = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", enabled, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
 	}
+	return nil
+}
 
-	// Total devices
-	db.QueryRow("SELECT COUNT(*) FROM devices").Scan(&stats.TotalDevices)
+// CustomerDiscountPercent returns a customer's own invoice discount, 0 if none
+func (db *DB) CustomerDiscountPercent(id int64) float64 {
+	var pct sql.NullFloat64
+	db.QueryRow("SELECT discount_percent FROM customers WHERE id = ?", id).Scan(&pct)
+	return pct.Float64
+}
 
-	// Online devices
-	db.QueryRow("SELECT COUNT(*) FROM devices WHERE status = 'online'").Scan(&stats.OnlineDevices)
+// SetCustomerDiscountPercent stores a customer's invoice discount
+func (db *DB) SetCustomerDiscountPercent(id int64, pct float64) error {
+	res, err := db.Exec("UPDATE customers SET discount_percent = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", pct, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
 
-	// Offline devices
-	stats.OfflineDevices = stats.TotalDevices - stats.OnlineDevices
+// GetCustomer retrieves a customer by ID
+func (db *DB) GetCustomer(id int64) (*models.Customer, error) {
+	var c models.Customer
+	var email, phone, address, username, fcmToken sql.NullString
+	var packageID sql.NullInt64
+	var pkgName sql.NullString
+	var pkgPrice sql.NullFloat64
+	var pkgDown, pkgUp sql.NullInt64
 
-	// Pending tasks
-	db.QueryRow("SELECT COUNT(*) FROM tasks WHERE status = 'pending'").Scan(&stats.PendingTasks)
+	err := db.QueryRow(`
+		SELECT c.id, c.customer_code, c.name, c.email, c.phone, c.address, c.latitude, c.longitude,
+		       c.package_id, c.username, c.status, c.join_date, c.balance, c.created_at, c.updated_at, c.fcm_token,
+		       p.name, p.price, p.download_speed, p.upload_speed
+		FROM customers c
+		LEFT JOIN packages p ON c.package_id = p.id
+		WHERE c.id = ?
+	`, id).Scan(&c.ID, &c.CustomerCode, &c.Name, &email, &phone, &address, &c.Latitude, &c.Longitude,
+		&packageID, &username, &c.Status, &c.JoinDate, &c.Balance, &c.CreatedAt, &c.UpdatedAt, &fcmToken,
+		&pkgName, &pkgPrice, &pkgDown, &pkgUp)
+	if err != nil {
+		return nil, err
+	}
+	if email.Valid {
+		c.Email = email.String
+	}
+	if phone.Valid {
+		c.Phone = phone.String
+	}
+	if address.Valid {
+		c.Address = address.String
+	}
+	if packageID.Valid {
+		c.PackageID = packageID.Int64
+	}
+	if username.Valid {
+		c.Username = username.String
+	}
 
-	// Devices by model
-	rows, err := db.Query(`
-		SELECT COALESCE(model_name, 'Unknown'), COUNT(*)
-		FROM devices
-		GROUP BY model_name
-		ORDER BY COUNT(*) DESC
-		LIMIT 10
-	`)
-	if err == nil {
-		defer rows.Close()
-		for rows.Next() {
-			var model string
-			var count int64
-			if rows.Scan(&model, &count) == nil {
-				stats.DevicesByModel[model] = count
-			}
+	if fcmToken.Valid {
+		c.FCMToken = fcmToken.String
+	}
+
+	if pkgName.Valid {
+		c.Package = &models.Package{
+			ID:            packageID.Int64,
+			Name:          pkgName.String,
+			Price:         pkgPrice.Float64,
+			DownloadSpeed: int(pkgDown.Int64),
+			UploadSpeed:   int(pkgUp.Int64),
 		}
 	}
 
-	// Recent activity
-	activityRows, err := db.Query(`
-		SELECT l.category, l.message, l.created_at, d.id, d.serial_number
-		FROM logs l
-		LEFT JOIN devices d ON l.device_id = d.id
-		ORDER BY l.created_at DESC
-		LIMIT 10
-	`)
-	if err == nil {
-		defer activityRows.Close()
-		for activityRows.Next() {
-			var activity models.ActivityItem
-			var deviceID sql.NullInt64
-			var deviceSN sql.NullString
-			if activityRows.Scan(&activity.Type, &activity.Message, &activity.Timestamp, &deviceID, &deviceSN) == nil {
-				if deviceID.Valid {
-					activity.DeviceID = deviceID.Int64
-				}
-				if deviceSN.Valid {
-					activity.DeviceSN = deviceSN.String
+	return &c, nil
+}
+
+// CreateCustomer creates a new customer
+func (db *DB) CreateCustomer(customer *models.Customer) (*models.Customer, error) {
+	// Generate customer code if not provided
+	if customer.CustomerCode == "" {
+		var count int64
+		db.QueryRow("SELECT COUNT(*) FROM customers").Scan(&count)
+		customer.CustomerCode = fmt.Sprintf("CUST-%04d", count+1)
+	}
+
+	result, err := db.Exec(`
+		INSERT INTO customers (customer_code, name, email, phone, address, latitude, longitude, package_id, username, password, status, balance)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, customer.CustomerCode, customer.Name, customer.Email, customer.Phone, customer.Address,
+		customer.Latitude, customer.Longitude, customer.PackageID, customer.Username, customer.Password, customer.Status, customer.Balance)
+	if err != nil {
+		return nil, err
+	}
+	id, _ := result.LastInsertId()
+	return db.GetCustomer(id)
+}
+
+// OnboardCustomer writes a new customer together with its device link, initial
+// WiFi task and first invoice in one transaction; if any write fails nothing is
+// kept. deviceID 0, empty wifiParams or a nil invoice skip that step. On success
+// customer.ID and invoice.ID/InvoiceNo are set and the queued task ID is returned.
+func (db *DB) OnboardCustomer(customer *models.Customer, deviceID int64, pppoeUsername string, wifiParams map[string]string, invoice *models.Invoice) (int64, error) {
+	var taskID int64
+	err := db.WithTx(func(tx *sql.Tx) error {
+		taskID = 0
+		code := customer.CustomerCode
+		if code == "" {
+			var count int64
+			tx.QueryRow("SELECT COUNT(*) FROM customers").Scan(&count)
+			code = fmt.Sprintf("CUST-%04d", count+1)
+		}
+		var packageID interface{}
+		if customer.PackageID > 0 {
+			packageID = customer.PackageID
+		}
+		result, err := tx.Exec(`
+			INSERT INTO customers (customer_code, name, email, phone, address, latitude, longitude, package_id, username, password, status, balance)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, code, customer.Name, customer.Email, customer.Phone, customer.Address,
+			customer.Latitude, customer.Longitude, packageID, customer.Username, customer.Password, customer.Status, customer.Balance)
+		if err != nil {
+			return fmt.Errorf("customer: %v", err)
+		}
+		customerID, _ := result.LastInsertId()
+
+		if deviceID > 0 {
+			if _, err := tx.Exec(`
+				UPDATE devices SET customer_id = ?,
+					template = CASE WHEN ? != '' THEN ? ELSE template END,
+					lifecycle_state = CASE WHEN lifecycle_state = 'stock' THEN 'deployed' ELSE lifecycle_state END,
+					updated_at = CURRENT_TIMESTAMP
+				WHERE id = ?
+			`, customerID, pppoeUsername, pppoeUsername, deviceID); err != nil {
+				return fmt.Errorf("device: %v", err)
+			}
+			if _, err := tx.Exec(`INSERT OR REPLACE INTO device_customer_map (device_id, customer_id) VALUES (?, ?)`, deviceID, customerID); err != nil {
+				return fmt.Errorf("device: %v", err)
+			}
+
+			if len(wifiParams) > 0 {
+				paramsJSON, _ := json.Marshal(wifiParams)
+				task := &models.DeviceTask{DeviceID: deviceID, Type: models.TaskSetParameterValues, Parameters: paramsJSON}
+				if err := db.insertTask(tx, task); err != nil {
+					return fmt.Errorf("wifi: %v", err)
 				}
-				stats.RecentActivity = append(stats.RecentActivity, activity)
+				taskID = task.ID
+			}
+		}
+
+		var invoiceID int64
+		invoiceNo := ""
+		if invoice != nil {
+			invoiceNo = invoice.InvoiceNo
+			if invoiceNo == "" {
+				invoiceNo = fmt.Sprintf("INV-%s-%04d", time.Now().Format("200601"), customerID)
+			}
+			result, err := tx.Exec(`
+				INSERT INTO invoices (invoice_no, customer_id, period_start, period_end, due_date, subtotal, tax, discount, total, status, notes)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			`, invoiceNo, customerID, invoice.PeriodStart, invoice.PeriodEnd, invoice.DueDate, invoice.Subtotal, invoice.Tax, invoice.Discount, invoice.Total, invoice.Status, invoice.Notes)
+			if err != nil {
+				return fmt.Errorf("invoice: %v", err)
+			}
+			invoiceID, _ = result.LastInsertId()
+			if err := insertInvoiceItems(tx, invoiceID, invoice.Items); err != nil {
+				return fmt.Errorf("invoice items: %v", err)
 			}
 		}
+
+		// Only publish IDs once every write has succeeded
+		customer.ID = customerID
+		customer.CustomerCode = code
+		if invoice != nil {
+			invoice.ID = invoiceID
+			invoice.InvoiceNo = invoiceNo
+			invoice.CustomerID = customerID
+		}
+		return nil
+	})
+	if err == nil && deviceID > 0 {
+		db.aliasCache.Delete(deviceID)
 	}
+	return taskID, err
+}
 
-	return stats, nil
+// UpdateCustomer updates a customer. An empty Password keeps the stored one
+// (GetCustomer does not load it).
+func (db *DB) UpdateCustomer(customer *models.Customer) error {
+	return db.WithTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			UPDATE customers SET name = ?, email = ?, phone = ?, address = ?, latitude = ?, longitude = ?,
+			package_id = ?, username = ?, password = COALESCE(NULLIF(?, ''), password), status = ?, balance = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?
+		`, customer.Name, customer.Email, customer.Phone, customer.Address, customer.Latitude, customer.Longitude,
+			customer.PackageID, customer.Username, customer.Password, customer.Status, customer.Balance, customer.ID)
+		if err != nil {
+			return err
+		}
+		return closeSuspensionUnlessSuspended(tx, customer)
+	})
 }
 
-// ============== Log Operations ==============
+// closeSuspensionUnlessSuspended ends the open suspension event when a status
+// change moves the customer out of suspended, so the next suspension starts a
+// new event and is notified again
+func closeSuspensionUnlessSuspended(tx *sql.Tx, customer *models.Customer) error {
+	if customer.Status == "suspended" {
+		return nil
+	}
+	_, err := tx.Exec(`
+		UPDATE suspension_events SET reactivated_at = CURRENT_TIMESTAMP
+		WHERE customer_id = ? AND reactivated_at IS NULL
+	`, customer.ID)
+	return err
+}
 
-// CreateLog creates a new log entry
-func (db *DB) CreateLog(deviceID *int64, level, category, message, details string) error {
+// GetCustomerIDByCode returns the ID of the customer with the given code, or
+// sql.ErrNoRows
+func (db *DB) GetCustomerIDByCode(code string) (int64, error) {
+	var id int64
+	err := db.QueryRow("SELECT id FROM customers WHERE customer_code = ?", code).Scan(&id)
+	return id, err
+}
+
+// UpdateCustomerProfile updates a customer's contact details, package and
+// status, leaving the portal credentials and balance untouched
+func (db *DB) UpdateCustomerProfile(customer *models.Customer) error {
+	return db.WithTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			UPDATE customers SET name = ?, email = ?, phone = ?, address = ?, package_id = ?, status = ?,
+			updated_at = CURRENT_TIMESTAMP WHERE id = ?
+		`, customer.Name, customer.Email, customer.Phone, customer.Address, customer.PackageID, customer.Status, customer.ID)
+		if err != nil {
+			return err
+		}
+		return closeSuspensionUnlessSuspended(tx, customer)
+	})
+}
+
+// DeleteCustomer deletes a customer
+func (db *DB) DeleteCustomer(id int64) error {
+	_, err := db.Exec("DELETE FROM customers WHERE id = ?", id)
+	return err
+}
+
+// AnonymizeCustomer scrubs personal data from a customer record while keeping
+// the row (and therefore its invoices, payments and balance) in place. The
+// customer's status is left as it is; terminating the service is a separate step.
+func (db *DB) AnonymizeCustomer(id int64) error {
+	res, err := db.Exec(`
+		UPDATE customers SET name = 'Anonymized ' || customer_code, email = '', phone = '', address = '',
+		latitude = 0, longitude = 0, username = NULL, password = NULL, fcm_token = NULL,
+		updated_at = CURRENT_TIMESTAMP WHERE id = ?
+	`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ============== Invoice Operations ==============
+
+// OpenSuspensionEvent records that a customer was suspended. If the customer
+// already has an open (not yet reactivated) event it is reused, so repeated
+// batch runs never start a second event.
+func (db *DB) OpenSuspensionEvent(customerID int64, reason string) (int64, error) {
+	var id int64
+	err := db.WithTx(func(tx *sql.Tx) error {
+		err := tx.QueryRow(`
+			SELECT id FROM suspension_events WHERE customer_id = ? AND reactivated_at IS NULL
+			ORDER BY id DESC LIMIT 1
+		`, customerID).Scan(&id)
+		if err != sql.ErrNoRows {
+			return err
+		}
+		result, err := tx.Exec(`INSERT INTO suspension_events (customer_id, reason) VALUES (?, ?)`, customerID, reason)
+		if err != nil {
+			return err
+		}
+		id, err = result.LastInsertId()
+		return err
+	})
+	return id, err
+}
+
+// ClaimSuspensionNotice marks the open suspension event of a customer as
+// notified. It returns true only for the first caller, so the suspension
+// message is sent exactly once per event.
+func (db *DB) ClaimSuspensionNotice(customerID int64) (bool, error) {
+	result, err := db.Exec(`
+		UPDATE suspension_events SET notified_at = CURRENT_TIMESTAMP
+		WHERE customer_id = ? AND reactivated_at IS NULL AND notified_at IS NULL
+	`, customerID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// CloseSuspensionEvent ends the open suspension event of a customer on reactivation
+func (db *DB) CloseSuspensionEvent(customerID int64) error {
 	_, err := db.Exec(`
-		INSERT INTO logs (device_id, level, category, message, details)
-		VALUES (?, ?, ?, ?, ?)
-	`, deviceID, level, category, message, details)
+		UPDATE suspension_events SET reactivated_at = CURRENT_TIMESTAMP
+		WHERE customer_id = ? AND reactivated_at IS NULL
+	`, customerID)
 	return err
 }
 
-// GetLogs retrieves logs with filtering
-func (db *DB) GetLogs(deviceID *int64, level string, limit, offset int) ([]*models.Log, error) {
-	var conditions []string
-	var args []interface{}
+// ErrCustomerNotActive is returned when a hold is requested for a customer
+// that is not active
+var ErrCustomerNotActive = errors.New("customer is not active")
+
+// StartCustomerHold puts an active customer on hold: the hold is recorded and
+// the customer status becomes hold, which keeps them out of invoice runs
+func (db *DB) StartCustomerHold(hold *models.CustomerHold) (*models.CustomerHold, error) {
+	err := db.WithTx(func(tx *sql.Tx) error {
+		result, err := tx.Exec(`
+			UPDATE customers SET status = 'hold', updated_at = CURRENT_TIMESTAMP WHERE id = ? AND status = 'active'
+		`, hold.CustomerID)
+		if err != nil {
+			return err
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return ErrCustomerNotActive
+		}
+		result, err = tx.Exec(`
+			INSERT INTO customer_holds (customer_id, resume_on, suspend_connection, reason, created_by)
+			VALUES (?, ?, ?, ?, ?)
+		`, hold.CustomerID, hold.ResumeOn, hold.SuspendConnection, hold.Reason, hold.CreatedBy)
+		if err != nil {
+			return err
+		}
+		hold.ID, err = result.LastInsertId()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	hold.StartedAt = time.Now()
+	return hold, nil
+}
 
-	if deviceID != nil {
-		conditions = append(conditions, "device_id = ?")
-		args = append(args, *deviceID)
+func queryCustomerHolds(db *DB, where string, args ...interface{}) ([]*models.CustomerHold, error) {
+	rows, err := db.Query(`
+		SELECT h.id, h.customer_id, c.customer_code, c.name, h.resume_on, h.suspend_connection,
+			COALESCE(h.reason, ''), COALESCE(h.created_by, ''), h.started_at, h.resumed_at
+		FROM customer_holds h JOIN customers c ON c.id = h.customer_id
+		`+where+` ORDER BY h.resume_on, h.id
+	`, args...)
+	if err != nil {
+		return nil, err
 	}
+	defer rows.Close()
 
-	if level != "" && level != "all" {
-		conditions = append(conditions, "level = ?")
-		args = append(args, level)
+	holds := []*models.CustomerHold{}
+	for rows.Next() {
+		var h models.CustomerHold
+		var resumedAt sql.NullTime
+		if err := rows.Scan(&h.ID, &h.CustomerID, &h.CustomerCode, &h.CustomerName, &h.ResumeOn, &h.SuspendConnection,
+			&h.Reason, &h.CreatedBy, &h.StartedAt, &resumedAt); err != nil {
+			return nil, err
+		}
+		if resumedAt.Valid {
+			h.ResumedAt = &resumedAt.Time
+		}
+		holds = append(holds, &h)
+	}
+	return holds, rows.Err()
+}
+
+// GetOpenCustomerHolds lists the holds that have not ended yet, soonest
+// resume date first
+func (db *DB) GetOpenCustomerHolds() ([]*models.CustomerHold, error) {
+	return queryCustomerHolds(db, "WHERE h.resumed_at IS NULL")
+}
+
+// GetCustomerHold returns the open hold of a customer, or sql.ErrNoRows
+func (db *DB) GetCustomerHold(customerID int64) (*models.CustomerHold, error) {
+	holds, err := queryCustomerHolds(db, "WHERE h.resumed_at IS NULL AND h.customer_id = ?", customerID)
+	if err != nil {
+		return nil, err
 	}
+	if len(holds) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return holds[0], nil
+}
+
+// GetDueCustomerHolds returns open holds whose resume date (YYYY-MM-DD) is
+// on or before today
+func (db *DB) GetDueCustomerHolds(today string) ([]*models.CustomerHold, error) {
+	return queryCustomerHolds(db, "WHERE h.resumed_at IS NULL AND h.resume_on <= ?", today)
+}
+
+// EndCustomerHold marks a hold as resumed. It returns false when the hold had
+// already ended, so a hold is resumed only once.
+func (db *DB) EndCustomerHold(id int64) (bool, error) {
+	result, err := db.Exec(`UPDATE customer_holds SET resumed_at = ? WHERE id = ? AND resumed_at IS NULL`, time.Now(), id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// GetCarriedForwardTotals returns how many invoices of a customer have been
+// carried forward (status combined) and their outstanding total
+func (db *DB) GetCarriedForwardTotals(customerID int64) (int, float64, error) {
+	var count int
+	var total float64
+	err := db.QueryRow(`
+		SELECT COUNT(*), COALESCE(SUM(total - paid_amount), 0) FROM invoices WHERE customer_id = ? AND status = 'combined'
+	`, customerID).Scan(&count, &total)
+	return count, total, err
+}
+
+// CombineInvoices marks the given invoices as carried forward in one transaction
+func (db *DB) CombineInvoices(ids []int64) error {
+	return db.WithTx(func(tx *sql.Tx) error {
+		for _, id := range ids {
+			if _, err := tx.Exec(`
+				UPDATE invoices SET status = 'combined', updated_at = CURRENT_TIMESTAMP
+				WHERE id = ? AND status IN ('pending', 'overdue')
+			`, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// MarkOverdueInvoices flips unpaid invoices due before today (YYYY-MM-DD, local
+// time) to overdue and returns the invoices it changed
+func (db *DB) MarkOverdueInvoices(today string) ([]*models.Invoice, error) {
+	var marked []*models.Invoice
+	err := db.WithTx(func(tx *sql.Tx) error {
+		rows, err := tx.Query(`SELECT `+invoiceColumns+` FROM invoices
+			WHERE status IN ('pending', 'partial') AND due_date < ? ORDER BY id`, today)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			inv, err := scanInvoice(rows)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			marked = append(marked, inv)
+		}
+		rows.Close()
 
-	whereClause := ""
-	if len(conditions) > 0 {
-		whereClause = "WHERE " + strings.Join(conditions, " AND ")
+		for _, inv := range marked {
+			if _, err := tx.Exec(`UPDATE invoices SET status = 'overdue', updated_at = CURRENT_TIMESTAMP
+				WHERE id = ? AND status IN ('pending', 'partial')`, inv.ID); err != nil {
+				return err
+			}
+			inv.Status = models.InvoiceOverdue
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
+	return marked, nil
+}
 
-	query := fmt.Sprintf(`
-		SELECT id, device_id, level, category, message, details, created_at
-		FROM logs %s
-		ORDER BY created_at DESC
-		LIMIT ? OFFSET ?
-	`, whereClause)
-
-	args = append(args, limit, offset)
-	rows, err := db.Query(query, args...)
+// GetUnpaidInvoices returns a customer's open invoices: pending, partially
+// paid or marked overdue, oldest due first
+func (db *DB) GetUnpaidInvoices(customerID int64) ([]*models.Invoice, error) {
+	rows, err := db.Query(`SELECT `+invoiceColumns+` FROM invoices
+		WHERE customer_id = ? AND status IN ('pending', 'partial', 'overdue') ORDER BY due_date, id`, customerID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var logs []*models.Log
+	var invoices []*models.Invoice
 	for rows.Next() {
-		var l models.Log
-		var deviceID sql.NullInt64
-		err := rows.Scan(&l.ID, &deviceID, &l.Level, &l.Category, &l.Message, &l.Details, &l.CreatedAt)
+		inv, err := scanInvoice(rows)
 		if err != nil {
 			return nil, err
 		}
-		if deviceID.Valid {
-			l.DeviceID = &deviceID.Int64
-		}
-		logs = append(logs, &l)
+		invoices = append(invoices, inv)
 	}
-
-	return logs, nil
+	return invoices, rows.Err()
 }
 
-// ============== Helper Functions ==============
-
-func scanDevice(rows *sql.Rows) (*models.Device, error) {
-	var d models.Device
-	var lastInform, lastContact sql.NullTime
-	var paramsStr, tagsStr, notes, address, templateStr sql.NullString
-	var lat, long, temp sql.NullFloat64
-	var rxPower sql.NullFloat64
-	var clientCount sql.NullInt64
-	var customerID sql.NullInt64
+// CountOverdueInvoices counts a customer's unpaid invoices due before today
+// (YYYY-MM-DD, local time), whether or not they are marked overdue yet
+func (db *DB) CountOverdueInvoices(customerID int64, today string) (int, error) {
+	var count int
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM invoices
+		WHERE customer_id = ? AND (status = 'overdue' OR (status IN ('pending', 'partial') AND due_date < ?))
+	`, customerID, today).Scan(&count)
+	return count, err
+}
 
-	err := rows.Scan(
-		&d.ID, &d.SerialNumber, &d.OUI, &d.ProductClass, &d.Manufacturer,
-		&d.ModelName, &d.HardwareVersion, &d.SoftwareVersion, &d.ConnectionRequest,
-		&d.Status, &lastInform, &lastContact, &d.IPAddress, &d.MACAddress,
-		&d.Uptime, &rxPower, &clientCount, &templateStr,
-		&paramsStr, &tagsStr, &notes, &d.CreatedAt, &d.UpdatedAt,
-		&lat, &long, &address, &temp, &customerID,
-	)
+// GetInvoicesDueOn returns unpaid invoices due on the given day (YYYY-MM-DD)
+func (db *DB) GetInvoicesDueOn(day string) ([]*models.Invoice, error) {
+	rows, err := db.Query(`SELECT `+invoiceColumns+` FROM invoices
+		WHERE status IN ('pending', 'partial') AND substr(due_date, 1, 10) = ? ORDER BY id`, day)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	d.RXPower = rxPower.Float64
-	d.ClientCount = int(clientCount.Int64)
-	d.Template = templateStr.String
-	d.Latitude = lat.Float64
-	d.Longitude = long.Float64
-	d.Temperature = temp.Float64
-	d.Address = address.String
-	if customerID.Valid {
-		d.CustomerID = &customerID.Int64
-	}
-
-	if lastInform.Valid {
-		d.LastInform = &lastInform.Time
-	}
-	if lastContact.Valid {
-		d.LastContact = &lastContact.Time
-	}
-	if notes.Valid {
-		d.Notes = notes.String
-	}
-
-	// Parse parameters JSON
-	d.Parameters = make(map[string]string)
-	if paramsStr.Valid && paramsStr.String != "" {
-		json.Unmarshal([]byte(paramsStr.String), &d.Parameters)
-	}
-
-	// Parse tags JSON
-	if tagsStr.Valid && tagsStr.String != "" {
-		json.Unmarshal([]byte(tagsStr.String), &d.Tags)
+	var invoices []*models.Invoice
+	for rows.Next() {
+		inv, err := scanInvoice(rows)
+		if err != nil {
+			return nil, err
+		}
+		invoices = append(invoices, inv)
 	}
-
-	return &d, nil
+	return invoices, rows.Err()
 }
 
-func scanDeviceRow(row *sql.Row) (*models.Device, error) {
-	var d models.Device
-	var lastInform, lastContact sql.NullTime
-	var paramsStr, tagsStr, notes, address, templateStr sql.NullString
-	var lat, long, temp sql.NullFloat64
-	var rxPower sql.NullFloat64
-	var clientCount sql.NullInt64
-	var customerID sql.NullInt64
-
-	err := row.Scan(
-		&d.ID, &d.SerialNumber, &d.OUI, &d.ProductClass, &d.Manufacturer,
-		&d.ModelName, &d.HardwareVersion, &d.SoftwareVersion, &d.ConnectionRequest,
-		&d.Status, &lastInform, &lastContact, &d.IPAddress, &d.MACAddress,
-		&d.Uptime, &rxPower, &clientCount, &templateStr,
-		&paramsStr, &tagsStr, &notes, &d.CreatedAt, &d.UpdatedAt,
-		&lat, &long, &address, &temp, &customerID,
-	)
+// ClaimInvoiceReminder records the reminder sent daysBefore an invoice's due
+// date. It returns false if that reminder already went out, so each one is
+// sent once however often the scheduler runs.
+func (db *DB) ClaimInvoiceReminder(invoiceID int64, daysBefore int) (bool, error) {
+	result, err := db.Exec(`INSERT OR IGNORE INTO invoice_reminders (invoice_id, days_before) VALUES (?, ?)`, invoiceID, daysBefore)
 	if err != nil {
-		return nil, err
+		return false, err
 	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
 
-	d.RXPower = rxPower.Float64
-	d.ClientCount = int(clientCount.Int64)
-	d.Template = templateStr.String
-	d.Latitude = lat.Float64
-	d.Longitude = long.Float64
-	d.Temperature = temp.Float64
-	d.Address = address.String
-	if customerID.Valid {
-		d.CustomerID = &customerID.Int64
-	}
+// GetInvoices retrieves invoices with optional filtering
+func (db *DB) GetInvoices(customerID *int64, status string, limit, offset int) ([]*models.Invoice, int64, error) {
+	var conditions []string
+	var args []interface{}
 
-	if lastInform.Valid {
-		d.LastInform = &lastInform.Time
-	}
-	if lastContact.Valid {
-		d.LastContact = &lastContact.Time
+	if customerID != nil {
+		conditions = append(conditions, "customer_id = ?")
+		args = append(args, *customerID)
 	}
-	if notes.Valid {
-		d.Notes = notes.String
+	if status != "" && status != "all" {
+		conditions = append(conditions, "status = ?")
+		args = append(args, status)
 	}
 
-	d.Parameters = make(map[string]string)
-	if paramsStr.Valid && paramsStr.String != "" {
-		json.Unmarshal([]byte(paramsStr.String), &d.Parameters)
+	whereClause := ""
+	if len(conditions) > 0 {
+		whereClause = "WHERE " + strings.Join(conditions, " AND ")
 	}
 
-	if tagsStr.Valid && tagsStr.String != "" {
-		json.Unmarshal([]byte(tagsStr.String), &d.Tags)
+	var total int64
+	db.QueryRow("SELECT COUNT(*) FROM invoices "+whereClause, args...).Scan(&total)
+
+	query := fmt.Sprintf(`SELECT `+invoiceColumns+` FROM invoices %s ORDER BY created_at DESC LIMIT ? OFFSET ?`, whereClause)
+
+	args = append(args, limit, offset)
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, 0, err
 	}
+	defer rows.Close()
 
-	return &d, nil
+	var invoices []*models.Invoice
+	for rows.Next() {
+		inv, err := scanInvoice(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		invoices = append(invoices, inv)
+	}
+	return invoices, total, nil
 }
 
-func scanTask(rows *sql.Rows) (*models.DeviceTask, error) {
-	var t models.DeviceTask
-	var params, result sql.NullString
-	var errMsg sql.NullString
-	var startedAt, completedAt sql.NullTime
+const invoiceColumns = `id, invoice_no, customer_id, period_start, period_end, due_date,
+	subtotal, tax, discount, total, status, paid_amount, paid_at, notes, created_at, updated_at`
 
-	err := rows.Scan(
-		&t.ID, &t.DeviceID, &t.Type, &t.Status, &params, &result,
-		&errMsg, &t.CreatedAt, &startedAt, &completedAt,
-	)
+func scanInvoice(row rowScanner) (*models.Invoice, error) {
+	var inv models.Invoice
+	var periodStart, periodEnd, dueDate, paidAt sql.NullTime
+	var notes sql.NullString
+	err := row.Scan(&inv.ID, &inv.InvoiceNo, &inv.CustomerID, &periodStart, &periodEnd, &dueDate,
+		&inv.Subtotal, &inv.Tax, &inv.Discount, &inv.Total, &inv.Status, &inv.PaidAmount, &paidAt, &notes, &inv.CreatedAt, &inv.UpdatedAt)
 	if err != nil {
 		return nil, err
 	}
-
-	if params.Valid {
-		t.Parameters = json.RawMessage(params.String)
+	if periodStart.Valid {
+		inv.PeriodStart = periodStart.Time
 	}
-	if result.Valid {
-		t.Result = json.RawMessage(result.String)
+	if periodEnd.Valid {
+		inv.PeriodEnd = periodEnd.Time
 	}
-	if errMsg.Valid {
-		t.Error = errMsg.String
+	if dueDate.Valid {
+		inv.DueDate = dueDate.Time
 	}
-	if startedAt.Valid {
-		t.StartedAt = &startedAt.Time
+	if paidAt.Valid {
+		inv.PaidAt = &paidAt.Time
 	}
-	if completedAt.Valid {
-		t.CompletedAt = &completedAt.Time
+	if notes.Valid {
+		inv.Notes = notes.String
 	}
-
-	return &t, nil
+	return &inv, nil
 }
 
-// ============== Package Operations ==============
-
-// GetPackages retrieves all packages
-func (db *DB) GetPackages(activeOnly bool) ([]*models.Package, error) {
-	query := `
-		SELECT p.id, p.name, p.description, p.download_speed, p.upload_speed, p.quota, p.price, p.setup_fee, p.is_active, p.created_at, p.updated_at,
-		       (SELECT COUNT(*) FROM customers WHERE package_id = p.id) as subscribers
-		FROM packages p
-	`
-	if activeOnly {
-		query += " WHERE p.is_active = 1"
+// CreateInvoice creates a new invoice
+func (db *DB) CreateInvoice(inv *models.Invoice) (*models.Invoice, error) {
+	// Generate invoice number
+	if inv.InvoiceNo == "" {
+		var count int64
+		db.QueryRow("SELECT COUNT(*) FROM invoices WHERE strftime('%Y%m', created_at) = strftime('%Y%m', 'now')").Scan(&count)
+		inv.InvoiceNo = fmt.Sprintf("INV-%s-%04d", time.Now().Format("200601"), count+1)
 	}
-	query += " ORDER BY p.price ASC"
 
-	rows, err := db.Query(query)
+	err := db.WithTx(func(tx *sql.Tx) error {
+		result, err := tx.Exec(`
+			INSERT INTO invoices (invoice_no, customer_id, period_start, period_end, due_date, subtotal, tax, discount, total, status, notes)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, inv.InvoiceNo, inv.CustomerID, inv.PeriodStart, inv.PeriodEnd, inv.DueDate, inv.Subtotal, inv.Tax, inv.Discount, inv.Total, inv.Status, inv.Notes)
+		if err != nil {
+			return err
+		}
+		id, _ := result.LastInsertId()
+		if err := insertInvoiceItems(tx, id, inv.Items); err != nil {
+			return err
+		}
+		inv.ID = id
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
+	return inv, nil
+}
 
-	var packages []*models.Package
-	for rows.Next() {
-		var p models.Package
-		var desc sql.NullString
-		err := rows.Scan(&p.ID, &p.Name, &desc, &p.DownloadSpeed, &p.UploadSpeed, &p.Quota, &p.Price, &p.SetupFee, &p.IsActive, &p.CreatedAt, &p.UpdatedAt, &p.Subscribers)
+// insertInvoiceItems stores the line items of a new invoice
+func insertInvoiceItems(tx *sql.Tx, invoiceID int64, items []models.InvoiceItem) error {
+	for i := range items {
+		result, err := tx.Exec(`
+			INSERT INTO invoice_items (invoice_id, description, quantity, unit_price, amount) VALUES (?, ?, ?, ?, ?)
+		`, invoiceID, items[i].Description, items[i].Quantity, items[i].UnitPrice, items[i].Amount)
 		if err != nil {
-			return nil, err
-		}
-		if desc.Valid {
-			p.Description = desc.String
+			return err
 		}
-		packages = append(packages, &p)
+		items[i].ID, _ = result.LastInsertId()
+		items[i].InvoiceID = invoiceID
 	}
-	return packages, nil
+	return nil
 }
 
-// GetPackage retrieves a package by ID
-func (db *DB) GetPackage(id int64) (*models.Package, error) {
-	var p models.Package
-	var desc sql.NullString
-	err := db.QueryRow(`
-		SELECT id, name, description, download_speed, upload_speed, quota, price, setup_fee, is_active, created_at, updated_at,
-		       (SELECT COUNT(*) FROM customers WHERE package_id = id) as subscribers
-		FROM packages WHERE id = ?
-	`, id).Scan(&p.ID, &p.Name, &desc, &p.DownloadSpeed, &p.UploadSpeed, &p.Quota, &p.Price, &p.SetupFee, &p.IsActive, &p.CreatedAt, &p.UpdatedAt, &p.Subscribers)
+// GetInvoiceItems returns the line items of an invoice
+func (db *DB) GetInvoiceItems(invoiceID int64) ([]models.InvoiceItem, error) {
+	rows, err := db.Query(`
+		SELECT id, invoice_id, COALESCE(description, ''), quantity, unit_price, amount
+		FROM invoice_items WHERE invoice_id = ? ORDER BY id
+	`, invoiceID)
 	if err != nil {
 		return nil, err
 	}
-	if desc.Valid {
-		p.Description = desc.String
+	defer rows.Close()
+
+	items := []models.InvoiceItem{}
+	for rows.Next() {
+		var it models.InvoiceItem
+		if err := rows.Scan(&it.ID, &it.InvoiceID, &it.Description, &it.Quantity, &it.UnitPrice, &it.Amount); err != nil {
+			return nil, err
+		}
+		items = append(items, it)
 	}
-	return &p, nil
+	return items, rows.Err()
 }
 
-// CreatePackage creates a new package
-func (db *DB) CreatePackage(pkg *models.Package) (*models.Package, error) {
-	result, err := db.Exec(`
-		INSERT INTO packages (name, description, download_speed, upload_speed, quota, price, setup_fee, is_active)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
-	`, pkg.Name, pkg.Description, pkg.DownloadSpeed, pkg.UploadSpeed, pkg.Quota, pkg.Price, pkg.SetupFee, pkg.IsActive)
+// GetInvoice retrieves a single invoice by ID
+func (db *DB) GetInvoice(id int64) (*models.Invoice, error) {
+	inv, err := scanInvoice(db.QueryRow(`SELECT `+invoiceColumns+` FROM invoices WHERE id = ?`, id))
 	if err != nil {
 		return nil, err
 	}
-	id, _ := result.LastInsertId()
-	return db.GetPackage(id)
+	inv.Items, _ = db.GetInvoiceItems(inv.ID)
+	return inv, nil
 }
 
-// UpdatePackage updates a package
-func (db *DB) UpdatePackage(pkg *models.Package) error {
-	_, err := db.Exec(`
-		UPDATE packages SET name = ?, description = ?, download_speed = ?, upload_speed = ?, quota = ?, 
-		price = ?, setup_fee = ?, is_active = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?
-	`, pkg.Name, pkg.Description, pkg.DownloadSpeed, pkg.UploadSpeed, pkg.Quota, pkg.Price, pkg.SetupFee, pkg.IsActive, pkg.ID)
-	return err
+// GetInvoiceByNumber retrieves a single invoice by invoice number
+func (db *DB) GetInvoiceByNumber(invoiceNo string) (*models.Invoice, error) {
+	return scanInvoice(db.QueryRow(`SELECT `+invoiceColumns+` FROM invoices WHERE invoice_no = ?`, invoiceNo))
 }
 
-// DeletePackage deletes a package
-func (db *DB) DeletePackage(id int64) error {
-	_, err := db.Exec("DELETE FROM packages WHERE id = ?", id)
+// UpdateInvoice updates an invoice
+func (db *DB) UpdateInvoice(inv *models.Invoice) error {
+	_, err := db.Exec(`
+		UPDATE invoices SET status = ?, paid_amount = ?, paid_at = ?, notes = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?
+	`, inv.Status, inv.PaidAmount, inv.PaidAt, inv.Notes, inv.ID)
 	return err
 }
 
-// ============== Customer Operations ==============
-
-// GetCustomers retrieves all customers with optional filtering
-func (db *DB) GetCustomers(status string, search string, limit, offset int) ([]*models.Customer, int64, error) {
-	var conditions []string
-	var args []interface{}
-
-	if status != "" && status != "all" {
-		conditions = append(conditions, "status = ?")
-		args = append(args, status)
+// UpdateInvoiceStatus updates invoice status and paid amount
+func (db *DB) UpdateInvoiceStatus(id int64, status models.InvoiceStatus, paidAmount float64) error {
+	var paidAt interface{}
+	if status == models.InvoicePaid {
+		paidAt = time.Now()
 	}
+	_, err := db.Exec(`
+		UPDATE invoices SET status = ?, paid_amount = ?, paid_at = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?
+	`, status, paidAmount, paidAt, id)
+	return err
+}
 
-	if search != "" {
-		conditions = append(conditions, "(customer_code LIKE ? OR name LIKE ? OR phone LIKE ?)")
-		searchPattern := "%" + search + "%"
-		args = append(args, searchPattern, searchPattern, searchPattern)
-	}
+// ============== Payment Operations ==============
 
+// GetPayments retrieves payments
+func (db *DB) GetPayments(customerID *int64, limit, offset int) ([]*models.Payment, int64, error) {
 	whereClause := ""
-	if len(conditions) > 0 {
-		whereClause = "WHERE " + strings.Join(conditions, " AND ")
+	var args []interface{}
+	if customerID != nil {
+		whereClause = "WHERE customer_id = ?"
+		args = append(args, *customerID)
 	}
 
-	// Get total count
 	var total int64
-	countQuery := "SELECT COUNT(*) FROM customers " + whereClause
-	db.QueryRow(countQuery, args...).Scan(&total)
+	db.QueryRow("SELECT COUNT(*) FROM payments "+whereClause, args...).Scan(&total)
 
-	// Get customers
 	query := fmt.Sprintf(`
-		SELECT c.id, c.customer_code, c.name, c.email, c.phone, c.address, c.latitude, c.longitude,
-		       c.package_id, c.username, c.status, c.join_date, c.balance, c.created_at, c.updated_at, c.fcm_token,
-		       p.name, p.price, p.download_speed, p.upload_speed
-		FROM customers c 
-		LEFT JOIN packages p ON c.package_id = p.id
-		%s
-		ORDER BY c.created_at DESC
-		LIMIT ? OFFSET ?
+		SELECT id, payment_no, customer_id, invoice_id, amount, payment_method, reference, status, notes, received_by, payment_date, created_at, updated_at
+		FROM payments %s ORDER BY payment_date DESC LIMIT ? OFFSET ?
 	`, whereClause)
 
 	args = append(args, limit, offset)
@@ -1287,437 +4711,466 @@ func (db *DB) GetCustomers(status string, search string, limit, offset int) ([]*
 	}
 	defer rows.Close()
 
-	var customers []*models.Customer
+	var payments []*models.Payment
 	for rows.Next() {
-		var c models.Customer
-		var email, phone, address, username, fcmToken sql.NullString
-		var packageID sql.NullInt64
-		var pkgName sql.NullString
-		var pkgPrice sql.NullFloat64
-		var pkgDown, pkgUp sql.NullInt64
-
-		err := rows.Scan(&c.ID, &c.CustomerCode, &c.Name, &email, &phone, &address, &c.Latitude, &c.Longitude,
-			&packageID, &username, &c.Status, &c.JoinDate, &c.Balance, &c.CreatedAt, &c.UpdatedAt, &fcmToken,
-			&pkgName, &pkgPrice, &pkgDown, &pkgUp)
+		var p models.Payment
+		var invoiceID sql.NullInt64
+		var reference, notes, receivedBy sql.NullString
+		err := rows.Scan(&p.ID, &p.PaymentNo, &p.CustomerID, &invoiceID, &p.Amount, &p.PaymentMethod, &reference, &p.Status, &notes, &receivedBy, &p.PaymentDate, &p.CreatedAt, &p.UpdatedAt)
 		if err != nil {
 			return nil, 0, err
 		}
-		if email.Valid {
-			c.Email = email.String
+		if invoiceID.Valid {
+			p.InvoiceID = &invoiceID.Int64
+		}
+		if reference.Valid {
+			p.Reference = reference.String
+		}
+		if notes.Valid {
+			p.Notes = notes.String
 		}
-		if phone.Valid {
-			c.Phone = phone.String
+		if receivedBy.Valid {
+			p.ReceivedBy = receivedBy.String
 		}
-		if address.Valid {
-			c.Address = address.String
+		payments = append(payments, &p)
+	}
+	return payments, total, nil
+}
+
+// CreatePayment creates a new payment
+func (db *DB) CreatePayment(payment *models.Payment) (*models.Payment, error) {
+	err := db.WithTx(func(tx *sql.Tx) error {
+		_, err := insertPayment(tx, payment)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return payment, nil
+}
+
+// ErrInvoiceAlreadyPaid is returned when paying an invoice that is fully paid
+var ErrInvoiceAlreadyPaid = errors.New("invoice is already paid")
+
+// ErrPaymentExceedsBalance is returned when a payment is larger than what is
+// still owed on the invoice
+var ErrPaymentExceedsBalance = errors.New("payment exceeds the outstanding amount")
+
+// AddInvoicePayment records a payment against an invoice and adds it to the
+// paid amount; a zero payment amount pays the outstanding balance. The invoice
+// becomes paid once the paid amount reaches the total, otherwise it is partial
+// (an overdue invoice stays overdue). Returns the updated invoice.
+func (db *DB) AddInvoicePayment(invoiceID int64, payment *models.Payment) (*models.Invoice, error) {
+	var inv *models.Invoice
+	err := db.WithTx(func(tx *sql.Tx) error {
+		var err error
+		inv, err = scanInvoice(tx.QueryRow(`SELECT `+invoiceColumns+` FROM invoices WHERE id = ?`, invoiceID))
+		if err != nil {
+			return err
 		}
-		if packageID.Valid {
-			c.PackageID = packageID.Int64
+		if inv.Status == models.InvoicePaid {
+			return ErrInvoiceAlreadyPaid
 		}
-		if username.Valid {
-			c.Username = username.String
+		outstanding := inv.Total - inv.PaidAmount
+		if payment.Amount <= 0 {
+			payment.Amount = outstanding
 		}
-		if fcmToken.Valid {
-			c.FCMToken = fcmToken.String
+		if payment.Amount > outstanding+0.005 {
+			return ErrPaymentExceedsBalance
 		}
 
-		if pkgName.Valid {
-			c.Package = &models.Package{
-				ID:            packageID.Int64,
-				Name:          pkgName.String,
-				Price:         pkgPrice.Float64,
-				DownloadSpeed: int(pkgDown.Int64),
-				UploadSpeed:   int(pkgUp.Int64),
-			}
+		inv.PaidAmount += payment.Amount
+		if inv.PaidAmount >= inv.Total-0.005 {
+			inv.Status = models.InvoicePaid
+			inv.PaidAt = &payment.PaymentDate
+		} else if inv.Status != models.InvoiceOverdue {
+			inv.Status = models.InvoicePartial
+		}
+		if _, err := tx.Exec(`
+			UPDATE invoices SET status = ?, paid_amount = ?, paid_at = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?
+		`, inv.Status, inv.PaidAmount, inv.PaidAt, inv.ID); err != nil {
+			return fmt.Errorf("update invoice: %v", err)
 		}
 
-		customers = append(customers, &c)
+		payment.CustomerID = inv.CustomerID
+		payment.InvoiceID = &inv.ID
+		if _, err := insertPayment(tx, payment); err != nil {
+			return fmt.Errorf("record payment: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-	return customers, total, nil
+	return inv, nil
 }
 
-// GetCustomerLocations retrieves customer locations for mapping
-func (db *DB) GetCustomerLocations() ([]models.CustomerLocation, error) {
-	query := `
-        SELECT c.id, c.name, COALESCE(c.latitude, 0), COALESCE(c.longitude, 0), c.status, c.address,
-               COALESCE(d.status, 'offline') as device_status
-        FROM customers c
-        LEFT JOIN devices d ON d.customer_id = c.id
-        GROUP BY c.id
-    `
-	rows, err := db.Query(query)
+// querier is satisfied by both *DB and *sql.Tx
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// nextPaymentNo returns the next PAY-YYYYMM-NNNN number of the current month
+// from its sequence, seeded with the highest number already issued
+func nextPaymentNo(q querier) (string, error) {
+	prefix := fmt.Sprintf("PAY-%s-", time.Now().Format("200601"))
+	var seed int64
+	if err := q.QueryRow(`
+		SELECT COALESCE(MAX(CAST(substr(payment_no, ?) AS INTEGER)), 0) FROM payments WHERE payment_no LIKE ?
+	`, len(prefix)+1, prefix+"%").Scan(&seed); err != nil {
+		return "", err
+	}
+	n, err := nextSequence(q, prefix, seed)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	defer rows.Close()
+	return fmt.Sprintf("%s%04d", prefix, n), nil
+}
 
-	var locs []models.CustomerLocation
-	for rows.Next() {
-		var l models.CustomerLocation
-		var addr sql.NullString
-		if err := rows.Scan(&l.ID, &l.Name, &l.Latitude, &l.Longitude, &l.Status, &addr, &l.DeviceStatus); err != nil {
-			continue
+func insertPayment(q querier, payment *models.Payment) (*models.Payment, error) {
+	// Generate payment number; a number taken by a manually numbered payment
+	// is skipped
+	generated := payment.PaymentNo == ""
+	const maxAttempts = 5
+	for attempt := 1; ; attempt++ {
+		if generated {
+			no, err := nextPaymentNo(q)
+			if err != nil {
+				return nil, fmt.Errorf("payment number: %v", err)
+			}
+			payment.PaymentNo = no
 		}
-		l.Address = addr.String
-		locs = append(locs, l)
+
+		result, err := q.Exec(`
+			INSERT INTO payments (payment_no, customer_id, invoice_id, amount, payment_method, reference, status, notes, received_by, payment_date)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, payment.PaymentNo, payment.CustomerID, payment.InvoiceID, payment.Amount, payment.PaymentMethod, payment.Reference, payment.Status, payment.Notes, payment.ReceivedBy, payment.PaymentDate)
+		if err != nil {
+			if generated && isUniqueError(err) && attempt < maxAttempts {
+				continue
+			}
+			return nil, err
+		}
+		id, _ := result.LastInsertId()
+		payment.ID = id
+		return payment, nil
 	}
-	return locs, nil
 }
 
-// UpdateCustomerLocation updates the geolocation of a customer
-func (db *DB) UpdateCustomerLocation(id int64, lat, long float64, address string) error {
-	_, err := db.Exec("UPDATE customers SET latitude=?, longitude=?, address=?, updated_at=CURRENT_TIMESTAMP WHERE id=?", lat, long, address, id)
-	return err
+// ============== Callback Event Operations ==============
+
+// CreateCallbackEvent persists an incoming payment callback before it is processed
+func (db *DB) CreateCallbackEvent(event *models.CallbackEvent) (*models.CallbackEvent, error) {
+	result, err := db.Exec(`
+		INSERT INTO callback_events (gateway, reference, invoice_no, status, payload, process_status)
+		VALUES (?, ?, ?, ?, ?, 'received')
+	`, event.Gateway, event.Reference, event.InvoiceNo, event.Status, string(event.Payload))
+	if err != nil {
+		return nil, err
+	}
+	event.ID, _ = result.LastInsertId()
+	event.ProcessStatus = "received"
+	return event, nil
 }
 
-// UpdateCustomerFCM updates the FCM token for a customer
-func (db *DB) UpdateCustomerFCM(id int64, token string) error {
-	_, err := db.Exec("UPDATE customers SET fcm_token=?, updated_at=CURRENT_TIMESTAMP WHERE id=?", token, id)
-	return err
+// GetCallbackEvent retrieves a callback event by ID
+func (db *DB) GetCallbackEvent(id int64) (*models.CallbackEvent, error) {
+	row := db.QueryRow(`
+		SELECT id, gateway, reference, invoice_no, status, payload, process_status, attempts, last_error, created_at, updated_at
+		FROM callback_events WHERE id = ?
+	`, id)
+	return scanCallbackEvent(row)
 }
 
-// GetCustomer retrieves a customer by ID
-func (db *DB) GetCustomer(id int64) (*models.Customer, error) {
-	var c models.Customer
-	var email, phone, address, username, fcmToken sql.NullString
-	var packageID sql.NullInt64
-	var pkgName sql.NullString
-	var pkgPrice sql.NullFloat64
-	var pkgDown, pkgUp sql.NullInt64
+// GetCallbackEvents lists callback events, optionally filtered by processing status
+func (db *DB) GetCallbackEvents(processStatus string, limit, offset int) ([]*models.CallbackEvent, error) {
+	whereClause := ""
+	var args []interface{}
+	if processStatus != "" && processStatus != "all" {
+		whereClause = "WHERE process_status = ?"
+		args = append(args, processStatus)
+	}
+	args = append(args, limit, offset)
 
-	err := db.QueryRow(`
-		SELECT c.id, c.customer_code, c.name, c.email, c.phone, c.address, c.latitude, c.longitude,
-		       c.package_id, c.username, c.status, c.join_date, c.balance, c.created_at, c.updated_at, c.fcm_token,
-		       p.name, p.price, p.download_speed, p.upload_speed
-		FROM customers c
-		LEFT JOIN packages p ON c.package_id = p.id
-		WHERE c.id = ?
-	`, id).Scan(&c.ID, &c.CustomerCode, &c.Name, &email, &phone, &address, &c.Latitude, &c.Longitude,
-		&packageID, &username, &c.Status, &c.JoinDate, &c.Balance, &c.CreatedAt, &c.UpdatedAt, &fcmToken,
-		&pkgName, &pkgPrice, &pkgDown, &pkgUp)
+	rows, err := db.Query(fmt.Sprintf(`
+		SELECT id, gateway, reference, invoice_no, status, payload, process_status, attempts, last_error, created_at, updated_at
+		FROM callback_events %s
+		ORDER BY created_at DESC
+		LIMIT ? OFFSET ?
+	`, whereClause), args...)
 	if err != nil {
 		return nil, err
 	}
-	if email.Valid {
-		c.Email = email.String
-	}
-	if phone.Valid {
-		c.Phone = phone.String
-	}
-	if address.Valid {
-		c.Address = address.String
-	}
-	if packageID.Valid {
-		c.PackageID = packageID.Int64
-	}
-	if username.Valid {
-		c.Username = username.String
-	}
-
-	if fcmToken.Valid {
-		c.FCMToken = fcmToken.String
-	}
+	defer rows.Close()
 
-	if pkgName.Valid {
-		c.Package = &models.Package{
-			ID:            packageID.Int64,
-			Name:          pkgName.String,
-			Price:         pkgPrice.Float64,
-			DownloadSpeed: int(pkgDown.Int64),
-			UploadSpeed:   int(pkgUp.Int64),
+	var events []*models.CallbackEvent
+	for rows.Next() {
+		e, err := scanCallbackEvent(rows)
+		if err != nil {
+			return nil, err
 		}
+		events = append(events, e)
 	}
-
-	return &c, nil
+	return events, nil
 }
 
-// CreateCustomer creates a new customer
-func (db *DB) CreateCustomer(customer *models.Customer) (*models.Customer, error) {
-	// Generate customer code if not provided
-	if customer.CustomerCode == "" {
-		var count int64
-		db.QueryRow("SELECT COUNT(*) FROM customers").Scan(&count)
-		customer.CustomerCode = fmt.Sprintf("CUST-%04d", count+1)
-	}
+// IsCallbackReferenceProcessed reports whether a gateway reference was already applied
+// with the given status. Gateways such as Midtrans notify the same reference once
+// per status change (pending, then settlement), so only a repeated status is a replay.
+func (db *DB) IsCallbackReferenceProcessed(gateway, reference, status string) (bool, error) {
+	var count int
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM callback_events
+		WHERE gateway = ? AND reference = ? AND status = ? AND process_status = 'processed'
+	`, gateway, reference, status).Scan(&count)
+	return count > 0, err
+}
 
-	result, err := db.Exec(`
-		INSERT INTO customers (customer_code, name, email, phone, address, latitude, longitude, package_id, username, password, status, balance)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`, customer.CustomerCode, customer.Name, customer.Email, customer.Phone, customer.Address,
-		customer.Latitude, customer.Longitude, customer.PackageID, customer.Username, customer.Password, customer.Status, customer.Balance)
+// GetRetryableCallbackEvents returns failed events that have not exhausted their attempts
+func (db *DB) GetRetryableCallbackEvents(maxAttempts int) ([]*models.CallbackEvent, error) {
+	rows, err := db.Query(`
+		SELECT id, gateway, reference, invoice_no, status, payload, process_status, attempts, last_error, created_at, updated_at
+		FROM callback_events WHERE process_status = 'failed' AND attempts < ?
+		ORDER BY created_at ASC
+	`, maxAttempts)
 	if err != nil {
 		return nil, err
 	}
-	id, _ := result.LastInsertId()
-	return db.GetCustomer(id)
+	defer rows.Close()
+
+	var events []*models.CallbackEvent
+	for rows.Next() {
+		e, err := scanCallbackEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, nil
 }
 
-// UpdateCustomer updates a customer
-func (db *DB) UpdateCustomer(customer *models.Customer) error {
+// UpdateCallbackEventResult records the outcome of a processing attempt
+func (db *DB) UpdateCallbackEventResult(id int64, processStatus, lastError string) error {
 	_, err := db.Exec(`
-		UPDATE customers SET name = ?, email = ?, phone = ?, address = ?, latitude = ?, longitude = ?,
-		package_id = ?, username = ?, password = ?, status = ?, balance = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?
-	`, customer.Name, customer.Email, customer.Phone, customer.Address, customer.Latitude, customer.Longitude,
-		customer.PackageID, customer.Username, customer.Password, customer.Status, customer.Balance, customer.ID)
+		UPDATE callback_events SET process_status = ?, last_error = ?, attempts = attempts + 1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, processStatus, lastError, id)
 	return err
 }
 
-// DeleteCustomer deletes a customer
-func (db *DB) DeleteCustomer(id int64) error {
-	_, err := db.Exec("DELETE FROM customers WHERE id = ?", id)
-	return err
+type rowScanner interface {
+	Scan(dest ...interface{}) error
 }
 
-// ============== Invoice Operations ==============
-
-// GetInvoices retrieves invoices with optional filtering
-func (db *DB) GetInvoices(customerID *int64, status string, limit, offset int) ([]*models.Invoice, int64, error) {
-	var conditions []string
-	var args []interface{}
-
-	if customerID != nil {
-		conditions = append(conditions, "customer_id = ?")
-		args = append(args, *customerID)
-	}
-	if status != "" && status != "all" {
-		conditions = append(conditions, "status = ?")
-		args = append(args, status)
-	}
-
-	whereClause := ""
-	if len(conditions) > 0 {
-		whereClause = "WHERE " + strings.Join(conditions, " AND ")
+func scanCallbackEvent(row rowScanner) (*models.CallbackEvent, error) {
+	var e models.CallbackEvent
+	var reference, invoiceNo, status, payload, lastError sql.NullString
+	err := row.Scan(&e.ID, &e.Gateway, &reference, &invoiceNo, &status, &payload, &e.ProcessStatus,
+		&e.Attempts, &lastError, &e.CreatedAt, &e.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	e.Reference = reference.String
+	e.InvoiceNo = invoiceNo.String
+	e.Status = status.String
+	e.LastError = lastError.String
+	if payload.Valid && payload.String != "" {
+		e.Payload = json.RawMessage(payload.String)
 	}
+	return &e, nil
+}
 
-	var total int64
-	db.QueryRow("SELECT COUNT(*) FROM invoices "+whereClause, args...).Scan(&total)
+// ============== Notification Outbox Operations ==============
 
-	query := fmt.Sprintf(`
-		SELECT id, invoice_no, customer_id, period_start, period_end, due_date, 
-		       subtotal, tax, discount, total, status, paid_amount, paid_at, notes, created_at, updated_at
-		FROM invoices %s ORDER BY created_at DESC LIMIT ? OFFSET ?
-	`, whereClause)
+const outboxColumns = `id, channel, recipient, COALESCE(subject, ''), body, status, attempts,
+	COALESCE(last_error, ''), next_attempt_at, created_at, sent_at`
 
-	args = append(args, limit, offset)
-	rows, err := db.Query(query, args...)
+func scanOutboxMessage(row rowScanner) (*models.OutboxMessage, error) {
+	var m models.OutboxMessage
+	var sentAt sql.NullTime
+	if err := row.Scan(&m.ID, &m.Channel, &m.Recipient, &m.Subject, &m.Body, &m.Status, &m.Attempts,
+		&m.LastError, &m.NextAttemptAt, &m.CreatedAt, &sentAt); err != nil {
+		return nil, err
+	}
+	if sentAt.Valid {
+		m.SentAt = &sentAt.Time
+	}
+	return &m, nil
+}
+
+func queryOutboxMessages(db *DB, query string, args ...interface{}) ([]*models.OutboxMessage, error) {
+	rows, err := db.Query(`SELECT `+outboxColumns+` FROM notification_outbox `+query, args...)
 	if err != nil {
-		return nil, 0, err
+		return nil, err
 	}
 	defer rows.Close()
 
-	var invoices []*models.Invoice
+	messages := []*models.OutboxMessage{}
 	for rows.Next() {
-		var inv models.Invoice
-		var periodStart, periodEnd, dueDate, paidAt sql.NullTime
-		var notes sql.NullString
-		err := rows.Scan(&inv.ID, &inv.InvoiceNo, &inv.CustomerID, &periodStart, &periodEnd, &dueDate,
-			&inv.Subtotal, &inv.Tax, &inv.Discount, &inv.Total, &inv.Status, &inv.PaidAmount, &paidAt, &notes, &inv.CreatedAt, &inv.UpdatedAt)
+		m, err := scanOutboxMessage(rows)
 		if err != nil {
-			return nil, 0, err
-		}
-		if periodStart.Valid {
-			inv.PeriodStart = periodStart.Time
-		}
-		if periodEnd.Valid {
-			inv.PeriodEnd = periodEnd.Time
-		}
-		if dueDate.Valid {
-			inv.DueDate = dueDate.Time
-		}
-		if paidAt.Valid {
-			inv.PaidAt = &paidAt.Time
-		}
-		if notes.Valid {
-			inv.Notes = notes.String
+			return nil, err
 		}
-		invoices = append(invoices, &inv)
+		messages = append(messages, m)
 	}
-	return invoices, total, nil
+	return messages, rows.Err()
 }
 
-// CreateInvoice creates a new invoice
-func (db *DB) CreateInvoice(inv *models.Invoice) (*models.Invoice, error) {
-	// Generate invoice number
-	if inv.InvoiceNo == "" {
-		var count int64
-		db.QueryRow("SELECT COUNT(*) FROM invoices WHERE strftime('%Y%m', created_at) = strftime('%Y%m', 'now')").Scan(&count)
-		inv.InvoiceNo = fmt.Sprintf("INV-%s-%04d", time.Now().Format("200601"), count+1)
-	}
-
+// EnqueueNotification stores a notification for delivery, due immediately
+func (db *DB) EnqueueNotification(m *models.OutboxMessage) (*models.OutboxMessage, error) {
+	now := time.Now()
 	result, err := db.Exec(`
-		INSERT INTO invoices (invoice_no, customer_id, period_start, period_end, due_date, subtotal, tax, discount, total, status, notes)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`, inv.InvoiceNo, inv.CustomerID, inv.PeriodStart, inv.PeriodEnd, inv.DueDate, inv.Subtotal, inv.Tax, inv.Discount, inv.Total, inv.Status, inv.Notes)
+		INSERT INTO notification_outbox (channel, recipient, subject, body, status, next_attempt_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, m.Channel, m.Recipient, m.Subject, m.Body, models.OutboxPending, now, now)
 	if err != nil {
 		return nil, err
 	}
-	id, _ := result.LastInsertId()
-	inv.ID = id
-	return inv, nil
+	m.ID, _ = result.LastInsertId()
+	m.Status = models.OutboxPending
+	m.NextAttemptAt = now
+	m.CreatedAt = now
+	return m, nil
 }
 
-// GetInvoice retrieves a single invoice by ID
-func (db *DB) GetInvoice(id int64) (*models.Invoice, error) {
-	var inv models.Invoice
-	var periodStart, periodEnd, dueDate, paidAt sql.NullTime
-	var notes sql.NullString
-	err := db.QueryRow(`
-		SELECT id, invoice_no, customer_id, period_start, period_end, due_date, 
-		       subtotal, tax, discount, total, status, paid_amount, paid_at, notes, created_at, updated_at
-		FROM invoices WHERE id = ?
-	`, id).Scan(&inv.ID, &inv.InvoiceNo, &inv.CustomerID, &periodStart, &periodEnd, &dueDate,
-		&inv.Subtotal, &inv.Tax, &inv.Discount, &inv.Total, &inv.Status, &inv.PaidAmount, &paidAt, &notes, &inv.CreatedAt, &inv.UpdatedAt)
-	if err != nil {
-		return nil, err
-	}
-	if periodStart.Valid {
-		inv.PeriodStart = periodStart.Time
-	}
-	if periodEnd.Valid {
-		inv.PeriodEnd = periodEnd.Time
-	}
-	if dueDate.Valid {
-		inv.DueDate = dueDate.Time
-	}
-	if paidAt.Valid {
-		inv.PaidAt = &paidAt.Time
+// GetOutboxMessage retrieves an outbox message by ID
+func (db *DB) GetOutboxMessage(id int64) (*models.OutboxMessage, error) {
+	return scanOutboxMessage(db.QueryRow(`SELECT `+outboxColumns+` FROM notification_outbox WHERE id = ?`, id))
+}
+
+// GetOutboxMessages lists outbox messages, newest first, optionally filtered
+// by status and channel
+func (db *DB) GetOutboxMessages(status, channel string, limit, offset int) ([]*models.OutboxMessage, error) {
+	conditions := []string{"1=1"}
+	args := []interface{}{}
+	if status != "" {
+		conditions = append(conditions, "status = ?")
+		args = append(args, status)
 	}
-	if notes.Valid {
-		inv.Notes = notes.String
+	if channel != "" {
+		conditions = append(conditions, "channel = ?")
+		args = append(args, channel)
 	}
-	return &inv, nil
+	args = append(args, limit, offset)
+	return queryOutboxMessages(db, "WHERE "+strings.Join(conditions, " AND ")+" ORDER BY id DESC LIMIT ? OFFSET ?", args...)
 }
 
-// GetInvoiceByNumber retrieves a single invoice by invoice number
-func (db *DB) GetInvoiceByNumber(invoiceNo string) (*models.Invoice, error) {
-	var inv models.Invoice
-	var periodStart, periodEnd, dueDate, paidAt sql.NullTime
-	var notes sql.NullString
-	err := db.QueryRow(`
-		SELECT id, invoice_no, customer_id, period_start, period_end, due_date, 
-		       subtotal, tax, discount, total, status, paid_amount, paid_at, notes, created_at, updated_at
-		FROM invoices WHERE invoice_no = ?
-	`, invoiceNo).Scan(&inv.ID, &inv.InvoiceNo, &inv.CustomerID, &periodStart, &periodEnd, &dueDate,
-		&inv.Subtotal, &inv.Tax, &inv.Discount, &inv.Total, &inv.Status, &inv.PaidAmount, &paidAt, &notes, &inv.CreatedAt, &inv.UpdatedAt)
+// GetDueNotifications returns pending messages whose next attempt is due,
+// oldest first
+func (db *DB) GetDueNotifications(now time.Time, limit int) ([]*models.OutboxMessage, error) {
+	return queryOutboxMessages(db, "WHERE status = ? AND next_attempt_at <= ? ORDER BY id LIMIT ?",
+		models.OutboxPending, now, limit)
+}
+
+// ClaimNotification marks a pending message as being sent. It returns false
+// when another worker already claimed it.
+func (db *DB) ClaimNotification(id int64) (bool, error) {
+	result, err := db.Exec(`UPDATE notification_outbox SET status = ? WHERE id = ? AND status = ?`,
+		models.OutboxSending, id, models.OutboxPending)
 	if err != nil {
-		return nil, err
-	}
-	if periodStart.Valid {
-		inv.PeriodStart = periodStart.Time
-	}
-	if periodEnd.Valid {
-		inv.PeriodEnd = periodEnd.Time
-	}
-	if dueDate.Valid {
-		inv.DueDate = dueDate.Time
+		return false, err
 	}
-	if paidAt.Valid {
-		inv.PaidAt = &paidAt.Time
-	}
-	if notes.Valid {
-		inv.Notes = notes.String
-	}
-	return &inv, nil
+	n, _ := result.RowsAffected()
+	return n > 0, nil
 }
 
-// UpdateInvoice updates an invoice
-func (db *DB) UpdateInvoice(inv *models.Invoice) error {
+// MarkNotificationSent records a delivered message
+func (db *DB) MarkNotificationSent(id int64) error {
 	_, err := db.Exec(`
-		UPDATE invoices SET status = ?, paid_amount = ?, paid_at = ?, notes = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?
-	`, inv.Status, inv.PaidAmount, inv.PaidAt, inv.Notes, inv.ID)
+		UPDATE notification_outbox SET status = ?, attempts = attempts + 1, last_error = NULL, sent_at = ?
+		WHERE id = ?
+	`, models.OutboxSent, time.Now(), id)
 	return err
 }
 
-// UpdateInvoiceStatus updates invoice status and paid amount
-func (db *DB) UpdateInvoiceStatus(id int64, status models.InvoiceStatus, paidAmount float64) error {
-	var paidAt interface{}
-	if status == models.InvoicePaid {
-		paidAt = time.Now()
+// RescheduleNotification puts a message back in the queue for another
+// attempt at next. A failed attempt (lastError set) is counted; a message
+// held back by an open circuit breaker is not.
+func (db *DB) RescheduleNotification(id int64, next time.Time, lastError string) error {
+	counted := 0
+	if lastError != "" {
+		counted = 1
 	}
 	_, err := db.Exec(`
-		UPDATE invoices SET status = ?, paid_amount = ?, paid_at = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?
-	`, status, paidAmount, paidAt, id)
+		UPDATE notification_outbox SET status = ?, attempts = attempts + ?,
+			last_error = COALESCE(NULLIF(?, ''), last_error), next_attempt_at = ?
+		WHERE id = ?
+	`, models.OutboxPending, counted, lastError, next, id)
 	return err
 }
 
-// ============== Payment Operations ==============
+// FailNotification gives up on a message after a failed attempt
+func (db *DB) FailNotification(id int64, lastError string) error {
+	_, err := db.Exec(`
+		UPDATE notification_outbox SET status = ?, attempts = attempts + 1, last_error = ? WHERE id = ?
+	`, models.OutboxFailed, lastError, id)
+	return err
+}
 
-// GetPayments retrieves payments
-func (db *DB) GetPayments(customerID *int64, limit, offset int) ([]*models.Payment, int64, error) {
-	whereClause := ""
-	var args []interface{}
-	if customerID != nil {
-		whereClause = "WHERE customer_id = ?"
-		args = append(args, *customerID)
+// RetryNotification re-queues a failed message for immediate delivery
+func (db *DB) RetryNotification(id int64) (bool, error) {
+	result, err := db.Exec(`
+		UPDATE notification_outbox SET status = ?, next_attempt_at = ?, created_at = ? WHERE id = ? AND status = ?
+	`, models.OutboxPending, time.Now(), time.Now(), id, models.OutboxFailed)
+	if err != nil {
+		return false, err
 	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
 
-	var total int64
-	db.QueryRow("SELECT COUNT(*) FROM payments "+whereClause, args...).Scan(&total)
-
-	query := fmt.Sprintf(`
-		SELECT id, payment_no, customer_id, invoice_id, amount, payment_method, reference, status, notes, received_by, payment_date, created_at, updated_at
-		FROM payments %s ORDER BY payment_date DESC LIMIT ? OFFSET ?
-	`, whereClause)
-
-	args = append(args, limit, offset)
-	rows, err := db.Query(query, args...)
+// GetOutboxCounts counts outbox messages per channel and status
+func (db *DB) GetOutboxCounts() (map[string]map[string]int64, error) {
+	rows, err := db.Query(`SELECT channel, status, COUNT(*) FROM notification_outbox GROUP BY channel, status`)
 	if err != nil {
-		return nil, 0, err
+		return nil, err
 	}
 	defer rows.Close()
 
-	var payments []*models.Payment
+	counts := make(map[string]map[string]int64)
 	for rows.Next() {
-		var p models.Payment
-		var invoiceID sql.NullInt64
-		var reference, notes, receivedBy sql.NullString
-		err := rows.Scan(&p.ID, &p.PaymentNo, &p.CustomerID, &invoiceID, &p.Amount, &p.PaymentMethod, &reference, &p.Status, &notes, &receivedBy, &p.PaymentDate, &p.CreatedAt, &p.UpdatedAt)
-		if err != nil {
-			return nil, 0, err
-		}
-		if invoiceID.Valid {
-			p.InvoiceID = &invoiceID.Int64
-		}
-		if reference.Valid {
-			p.Reference = reference.String
-		}
-		if notes.Valid {
-			p.Notes = notes.String
+		var channel, status string
+		var n int64
+		if err := rows.Scan(&channel, &status, &n); err != nil {
+			return nil, err
 		}
-		if receivedBy.Valid {
-			p.ReceivedBy = receivedBy.String
+		if counts[channel] == nil {
+			counts[channel] = make(map[string]int64)
 		}
-		payments = append(payments, &p)
+		counts[channel][status] = n
 	}
-	return payments, total, nil
+	return counts, rows.Err()
 }
 
-// CreatePayment creates a new payment
-func (db *DB) CreatePayment(payment *models.Payment) (*models.Payment, error) {
-	// Generate payment number
-	if payment.PaymentNo == "" {
-		var count int64
-		db.QueryRow("SELECT COUNT(*) FROM payments WHERE strftime('%Y%m', created_at) = strftime('%Y%m', 'now')").Scan(&count)
-		payment.PaymentNo = fmt.Sprintf("PAY-%s-%04d", time.Now().Format("200601"), count+1)
-	}
-
+// PruneNotificationOutbox deletes delivered and given-up messages older than
+// 30 days
+func (db *DB) PruneNotificationOutbox() (int64, error) {
+	cutoff := time.Now().AddDate(0, 0, -30)
 	result, err := db.Exec(`
-		INSERT INTO payments (payment_no, customer_id, invoice_id, amount, payment_method, reference, status, notes, received_by, payment_date)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`, payment.PaymentNo, payment.CustomerID, payment.InvoiceID, payment.Amount, payment.PaymentMethod, payment.Reference, payment.Status, payment.Notes, payment.ReceivedBy, payment.PaymentDate)
+		DELETE FROM notification_outbox
+		WHERE (status = ? AND sent_at < ?) OR (status = ? AND created_at < ?)
+	`, models.OutboxSent, cutoff, models.OutboxFailed, cutoff)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
-	id, _ := result.LastInsertId()
-	payment.ID = id
-	return payment, nil
+	return result.RowsAffected()
 }
 
 // ============== Billing Stats ==============
 
-// GetBillingStats retrieves billing dashboard statistics
-func (db *DB) GetBillingStats() (*models.BillingStats, error) {
+// GetBillingStats retrieves billing dashboard statistics. Today and this month
+// are those of now's location.
+func (db *DB) GetBillingStats(now time.Time) (*models.BillingStats, error) {
 	stats := &models.BillingStats{}
+	// Timestamps are stored either with their offset (set from Go) or in UTC
+	// (CURRENT_TIMESTAMP), so datetime() brings both to UTC and they are
+	// compared against the local day and month boundaries converted to UTC
+	utc := func(t time.Time) string { return t.UTC().Format("2006-01-02 15:04:05") }
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	dayFrom, dayTo := utc(dayStart), utc(dayStart.AddDate(0, 0, 1))
+	monthFrom, monthTo := utc(monthStart), utc(monthStart.AddDate(0, 1, 0))
 
 	// Total customers
 	db.QueryRow("SELECT COUNT(*) FROM customers").Scan(&stats.TotalCustomers)
@@ -1731,27 +5184,68 @@ func (db *DB) GetBillingStats() (*models.BillingStats, error) {
 	// Monthly revenue (this month's paid invoices)
 	db.QueryRow(`
 		SELECT COALESCE(SUM(paid_amount), 0) FROM invoices 
-		WHERE status = 'paid' AND strftime('%Y%m', paid_at) = strftime('%Y%m', 'now')
-	`).Scan(&stats.MonthlyRevenue)
+		WHERE status = 'paid' AND datetime(paid_at) >= ? AND datetime(paid_at) < ?
+	`, monthFrom, monthTo).Scan(&stats.MonthlyRevenue)
 
 	// Pending invoices
 	db.QueryRow("SELECT COUNT(*) FROM invoices WHERE status = 'pending'").Scan(&stats.PendingInvoices)
 
-	// Overdue amount
+	// Overdue amount (due dates are local dates)
 	db.QueryRow(`
 		SELECT COALESCE(SUM(total - paid_amount), 0) FROM invoices 
-		WHERE status IN ('pending', 'overdue') AND due_date < date('now')
-	`).Scan(&stats.OverdueAmount)
+		WHERE status IN ('pending', 'partial', 'overdue') AND due_date < ?
+	`, now.Format("2006-01-02")).Scan(&stats.OverdueAmount)
 
 	// Today's payments
 	db.QueryRow(`
 		SELECT COALESCE(SUM(amount), 0) FROM payments 
-		WHERE date(payment_date) = date('now') AND status = 'completed'
-	`).Scan(&stats.TodayPayments)
+		WHERE datetime(payment_date) >= ? AND datetime(payment_date) < ? AND status = 'completed'
+	`, dayFrom, dayTo).Scan(&stats.TodayPayments)
+
+	// ARPU from this month's completed payments
+	var monthPayments float64
+	db.QueryRow(`
+		SELECT COALESCE(SUM(amount), 0) FROM payments
+		WHERE datetime(payment_date) >= ? AND datetime(payment_date) < ? AND status = 'completed'
+	`, monthFrom, monthTo).Scan(&monthPayments)
+	stats.ARPU = computeARPU(monthPayments, stats.ActiveCustomers)
+
+	// Churn: customers terminated this month against the base at the start of the month
+	var startBase int64
+	db.QueryRow(`
+		SELECT COUNT(*) FROM customers
+		WHERE status = 'terminated' AND datetime(updated_at) >= ? AND datetime(updated_at) < ?
+	`, monthFrom, monthTo).Scan(&stats.ChurnedCustomers)
+	db.QueryRow(`
+		SELECT COUNT(*) FROM customers
+		WHERE datetime(join_date) >= ? AND datetime(join_date) < ?
+	`, monthFrom, monthTo).Scan(&stats.NewCustomers)
+	db.QueryRow(`
+		SELECT COUNT(*) FROM customers
+		WHERE datetime(join_date) < ?
+		AND NOT (status = 'terminated' AND datetime(updated_at) < ?)
+	`, monthFrom, monthFrom).Scan(&startBase)
+	stats.ChurnRate = computeChurnRate(stats.ChurnedCustomers, startBase)
 
 	return stats, nil
 }
 
+// computeARPU returns revenue per customer, or 0 when there are no customers
+func computeARPU(revenue float64, customers int64) float64 {
+	if customers <= 0 {
+		return 0
+	}
+	return math.Round(revenue/float64(customers)*100) / 100
+}
+
+// computeChurnRate returns churned customers as a percentage of the base
+func computeChurnRate(churned, base int64) float64 {
+	if base <= 0 {
+		return 0
+	}
+	return math.Round(float64(churned)/float64(base)*10000) / 100
+}
+
 // ============== Customer Portal Operations ==============
 
 // GetCustomerByUsername retrieves a customer by username
@@ -1786,6 +5280,26 @@ func (db *DB) GetCustomerByUsername(username string) (*models.Customer, error) {
 	return &c, nil
 }
 
+// GetCustomerIDsByPhone returns the IDs of the customers with exactly this
+// phone number
+func (db *DB) GetCustomerIDsByPhone(phone string) ([]int64, error) {
+	rows, err := db.Query("SELECT id FROM customers WHERE phone = ? ORDER BY id", phone)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
 // GetCustomerByCode retrieves a customer by customer code
 func (db *DB) GetCustomerByCode(code string) (*models.Customer, error) {
 	var c models.Customer
@@ -1821,6 +5335,62 @@ func (db *DB) GetCustomerByCode(code string) (*models.Customer, error) {
 	return &c, nil
 }
 
+// GetCustomerByEmail retrieves a customer by email address (case-insensitive)
+func (db *DB) GetCustomerByEmail(email string) (*models.Customer, error) {
+	var c models.Customer
+	var phone sql.NullString
+	err := db.QueryRow(`
+		SELECT id, customer_code, name, email, phone, status
+		FROM customers WHERE LOWER(email) = LOWER(?) LIMIT 1
+	`, email).Scan(&c.ID, &c.CustomerCode, &c.Name, &c.Email, &phone, &c.Status)
+	if err != nil {
+		return nil, err
+	}
+	c.Phone = phone.String
+	return &c, nil
+}
+
+// SetCustomerPassword stores a new (already hashed) portal password
+func (db *DB) SetCustomerPassword(id int64, passwordHash string) error {
+	res, err := db.Exec("UPDATE customers SET password = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", passwordHash, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetCustomersByPhoneSuffix returns customers whose phone, ignoring spaces,
+// dashes, dots and '+', ends with the given digits. Callers compare the
+// normalized numbers themselves since phones are stored in free format.
+func (db *DB) GetCustomersByPhoneSuffix(digits string) ([]*models.Customer, error) {
+	rows, err := db.Query(`
+		SELECT id, customer_code, name, phone, username, password, status
+		FROM customers
+		WHERE REPLACE(REPLACE(REPLACE(REPLACE(phone, ' ', ''), '-', ''), '.', ''), '+', '') LIKE ?
+	`, "%"+digits)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var customers []*models.Customer
+	for rows.Next() {
+		var c models.Customer
+		var phone, username, pwd sql.NullString
+		if err := rows.Scan(&c.ID, &c.CustomerCode, &c.Name, &phone, &username, &pwd, &c.Status); err != nil {
+			return nil, err
+		}
+		c.Phone = phone.String
+		c.Username = username.String
+		c.Password = pwd.String
+		customers = append(customers, &c)
+	}
+	return customers, nil
+}
+
 // GetDeviceByTemplate retrieves a device by its template field which contains the PPPoE username
 func (db *DB) GetDeviceByTemplate(template string) (*models.Device, error) {
 	query := `
@@ -1828,7 +5398,8 @@ func (db *DB) GetDeviceByTemplate(template string) (*models.Device, error) {
 		       hardware_version, software_version, connection_request, status,
 		       last_inform, last_contact, ip_address, mac_address, uptime,
 		       rx_power, client_count, template,
-		       parameters, tags, notes, created_at, updated_at, latitude, longitude, address, temperature, customer_id
+		       parameters, tags, notes, created_at, updated_at, latitude, longitude, address, temperature, customer_id,
+			   COALESCE(lifecycle_state, 'deployed'), COALESCE(label, '')
 		FROM devices WHERE template = ?
 	`
 	row := db.QueryRow(query, template)
@@ -1841,7 +5412,9 @@ func (db *DB) GetCustomerDevices(customerID int64) ([]*models.Device, error) {
 		SELECT d.id, d.serial_number, d.oui, d.product_class, d.manufacturer, d.model_name,
 		       d.hardware_version, d.software_version, d.connection_request, d.status,
 		       d.last_inform, d.last_contact, d.ip_address, d.mac_address, d.uptime,
-		       d.parameters, d.tags, d.notes, d.created_at, d.updated_at
+		       d.rx_power, d.client_count, d.template,
+		       d.parameters, d.tags, d.notes, d.created_at, d.updated_at, d.latitude, d.longitude, d.address, d.temperature, d.customer_id,
+		       COALESCE(d.lifecycle_state, 'deployed'), COALESCE(d.label, '')
 		FROM devices d
 		INNER JOIN device_customer_map dcm ON d.id = dcm.device_id
 		WHERE dcm.customer_id = ?
@@ -1858,9 +5431,92 @@ func (db *DB) GetCustomerDevices(customerID int64) ([]*models.Device, error) {
 		if err != nil {
 			return nil, err
 		}
-		devices = append(devices, device)
+		devices = append(devices, device)
+	}
+	return devices, nil
+}
+
+// Search looks up devices (serial, model, IP, MAC), customers (code, name,
+// phone, PPPoE username) and invoices (number) containing q, returning at
+// most limit matches per category
+func (db *DB) Search(q string, limit int) (*models.SearchResults, error) {
+	pattern := "%" + q + "%"
+	results := &models.SearchResults{
+		Query:     q,
+		Devices:   []models.SearchDevice{},
+		Customers: []models.SearchCustomer{},
+		Invoices:  []models.SearchInvoice{},
+	}
+
+	rows, err := db.Query(`
+		SELECT id, serial_number, COALESCE(manufacturer, ''), COALESCE(model_name, ''), COALESCE(ip_address, ''),
+		       COALESCE(mac_address, ''), COALESCE(status, ''), customer_id, COALESCE(template, '')
+		FROM devices
+		WHERE serial_number LIKE ? OR model_name LIKE ? OR product_class LIKE ? OR ip_address LIKE ? OR mac_address LIKE ?
+		ORDER BY serial_number LIMIT ?
+	`, pattern, pattern, pattern, pattern, pattern, limit)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var d models.SearchDevice
+		var customerID sql.NullInt64
+		if err := rows.Scan(&d.ID, &d.SerialNumber, &d.Manufacturer, &d.ModelName, &d.IPAddress,
+			&d.MACAddress, &d.Status, &customerID, &d.PPPoEUsername); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if customerID.Valid && customerID.Int64 > 0 {
+			d.CustomerID = &customerID.Int64
+		}
+		results.Devices = append(results.Devices, d)
+	}
+	rows.Close()
+
+	rows, err = db.Query(`
+		SELECT c.id, c.customer_code, c.name, COALESCE(c.phone, ''), c.status
+		FROM customers c
+		WHERE c.customer_code LIKE ? OR c.name LIKE ? OR c.phone LIKE ?
+		   OR EXISTS (SELECT 1 FROM device_customer_map dcm JOIN devices d ON d.id = dcm.device_id
+		              WHERE dcm.customer_id = c.id AND d.template LIKE ?)
+		ORDER BY c.name LIMIT ?
+	`, pattern, pattern, pattern, pattern, limit)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var c models.SearchCustomer
+		if err := rows.Scan(&c.ID, &c.CustomerCode, &c.Name, &c.Phone, &c.Status); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		results.Customers = append(results.Customers, c)
+	}
+	rows.Close()
+
+	rows, err = db.Query(`
+		SELECT i.id, i.invoice_no, i.customer_id, COALESCE(c.name, ''), i.total, i.status, i.due_date
+		FROM invoices i
+		LEFT JOIN customers c ON c.id = i.customer_id
+		WHERE i.invoice_no LIKE ?
+		ORDER BY i.created_at DESC LIMIT ?
+	`, pattern, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var inv models.SearchInvoice
+		var dueDate sql.NullTime
+		if err := rows.Scan(&inv.ID, &inv.InvoiceNo, &inv.CustomerID, &inv.CustomerName, &inv.Total, &inv.Status, &dueDate); err != nil {
+			return nil, err
+		}
+		if dueDate.Valid {
+			inv.DueDate = dueDate.Time
+		}
+		results.Invoices = append(results.Invoices, inv)
 	}
-	return devices, nil
+	return results, rows.Err()
 }
 
 // GetCustomerByPPPoE retrieves a customer by PPPoE username (searching through device template)
@@ -1947,6 +5603,83 @@ func (db *DB) SyncCustomerToDevice(customerID int64, pppoeUsername string) error
 	return nil
 }
 
+// LinkDeviceToCustomer sets the device owner and the device-customer mapping together
+func (db *DB) LinkDeviceToCustomer(deviceID, customerID int64) error {
+	db.aliasCache.Delete(deviceID)
+	return db.WithTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`UPDATE devices SET customer_id = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, customerID, deviceID); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`INSERT OR REPLACE INTO device_customer_map (device_id, customer_id) VALUES (?, ?)`, deviceID, customerID)
+		return err
+	})
+}
+
+// ReplaceDevice hands everything that belongs to the site of a broken device
+// over to its replacement in one transaction: the customer assignment, the
+// PPPoE username (template), location and label. The replacement is marked
+// deployed; the old device is unassigned and retired.
+func (db *DB) ReplaceDevice(oldID, newID int64) error {
+	db.aliasCache.Delete(oldID)
+	db.aliasCache.Delete(newID)
+	return db.WithTx(func(tx *sql.Tx) error {
+		var customerID sql.NullInt64
+		var template, label, address sql.NullString
+		var latitude, longitude sql.NullFloat64
+		if err := tx.QueryRow(`
+			SELECT customer_id, template, label, latitude, longitude, address FROM devices WHERE id = ?
+		`, oldID).Scan(&customerID, &template, &label, &latitude, &longitude, &address); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`
+			UPDATE devices SET customer_id = ?, template = ?, label = ?, latitude = ?, longitude = ?, address = ?,
+				lifecycle_state = 'deployed', updated_at = CURRENT_TIMESTAMP
+			WHERE id = ?
+		`, customerID, template.String, label.String, latitude.Float64, longitude.Float64, address.String, newID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM device_customer_map WHERE device_id = ?`, newID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`UPDATE device_customer_map SET device_id = ? WHERE device_id = ?`, newID, oldID); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`
+			UPDATE devices SET customer_id = NULL, template = '', label = '',
+				lifecycle_state = 'retired', updated_at = CURRENT_TIMESTAMP
+			WHERE id = ?
+		`, oldID)
+		return err
+	})
+}
+
+// ApplyDeviceLabel sets a device's label from tpl (see models.DeviceLabel)
+// using its assigned customer. An empty template or an unassigned device
+// leaves the label untouched. It returns the label written, if any.
+func (db *DB) ApplyDeviceLabel(deviceID int64, tpl string) (string, error) {
+	if tpl == "" {
+		return "", nil
+	}
+	device, err := db.GetDevice(deviceID)
+	if err != nil {
+		return "", err
+	}
+	if device.CustomerID == nil {
+		return "", nil
+	}
+	customer, err := db.GetCustomer(*device.CustomerID)
+	if err != nil {
+		return "", err
+	}
+	label := models.DeviceLabel(tpl, customer, device)
+	if label == "" {
+		return "", nil
+	}
+	db.aliasCache.Delete(deviceID)
+	_, err = db.Exec(`UPDATE devices SET label = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, label, deviceID)
+	return label, err
+}
+
 // UpdateDeviceLocation updates device location coordinates and address
 func (db *DB) UpdateDeviceLocation(deviceID int64, latitude, longitude float64, address string) error {
 	_, err := db.Exec(`
@@ -1955,6 +5688,49 @@ func (db *DB) UpdateDeviceLocation(deviceID int64, latitude, longitude float64,
 	return err
 }
 
+// InstallDevice performs the write side of a field install in one transaction:
+// customer assignment, GPS location and the initial WiFi configuration task.
+// Zero/empty arguments skip the corresponding step. It returns the queued task ID (0 if none).
+func (db *DB) InstallDevice(deviceID, customerID int64, latitude, longitude float64, address string, wifiParams map[string]string) (int64, error) {
+	var taskID int64
+	err := db.WithTx(func(tx *sql.Tx) error {
+		if customerID > 0 {
+			// Installing a spare unit takes it out of stock
+			if _, err := tx.Exec(`
+				UPDATE devices SET customer_id = ?,
+					lifecycle_state = CASE WHEN lifecycle_state = 'stock' THEN 'deployed' ELSE lifecycle_state END,
+					updated_at = CURRENT_TIMESTAMP
+				WHERE id = ?
+			`, customerID, deviceID); err != nil {
+				return err
+			}
+			if _, err := tx.Exec(`INSERT OR REPLACE INTO device_customer_map (device_id, customer_id) VALUES (?, ?)`, deviceID, customerID); err != nil {
+				return err
+			}
+		}
+
+		if latitude != 0 || longitude != 0 {
+			if _, err := tx.Exec(`
+				UPDATE devices SET latitude = ?, longitude = ?, address = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?
+			`, latitude, longitude, address, deviceID); err != nil {
+				return err
+			}
+		}
+
+		taskID = 0
+		if len(wifiParams) > 0 {
+			paramsJSON, _ := json.Marshal(wifiParams)
+			task := &models.DeviceTask{DeviceID: deviceID, Type: models.TaskSetParameterValues, Parameters: paramsJSON}
+			if err := db.insertTask(tx, task); err != nil {
+				return err
+			}
+			taskID = task.ID
+		}
+		return nil
+	})
+	return taskID, err
+}
+
 // CreateSupportTicket creates a new support ticket
 func (db *DB) CreateSupportTicket(ticket *models.SupportTicket) (*models.SupportTicket, error) {
 	// Generate ticket number
@@ -1998,10 +5774,7 @@ func (db *DB) GetSupportTickets(customerID *int64, status string, limit, offset
 	var total int64
 	db.QueryRow("SELECT COUNT(*) FROM support_tickets "+whereClause, args...).Scan(&total)
 
-	query := fmt.Sprintf(`
-		SELECT id, ticket_no, customer_id, subject, description, category, priority, status, assigned_to, resolution, created_at, updated_at, closed_at
-		FROM support_tickets %s ORDER BY created_at DESC LIMIT ? OFFSET ?
-	`, whereClause)
+	query := fmt.Sprintf(`SELECT %s FROM support_tickets %s ORDER BY created_at DESC LIMIT ? OFFSET ?`, supportTicketColumns, whereClause)
 
 	args = append(args, limit, offset)
 	rows, err := db.Query(query, args...)
@@ -2012,38 +5785,25 @@ func (db *DB) GetSupportTickets(customerID *int64, status string, limit, offset
 
 	var tickets []*models.SupportTicket
 	for rows.Next() {
-		var t models.SupportTicket
-		var assignedTo sql.NullInt64
-		var resolution sql.NullString
-		var closedAt sql.NullTime
-		err := rows.Scan(&t.ID, &t.TicketNo, &t.CustomerID, &t.Subject, &t.Description, &t.Category, &t.Priority, &t.Status, &assignedTo, &resolution, &t.CreatedAt, &t.UpdatedAt, &closedAt)
+		t, err := scanSupportTicket(rows)
 		if err != nil {
 			return nil, 0, err
 		}
-		if assignedTo.Valid {
-			t.AssignedTo = &assignedTo.Int64
-		}
-		if resolution.Valid {
-			t.Resolution = resolution.String
-		}
-		if closedAt.Valid {
-			t.ClosedAt = &closedAt.Time
-		}
-		tickets = append(tickets, &t)
+		tickets = append(tickets, t)
 	}
 	return tickets, total, nil
 }
 
-// GetSupportTicket retrieves a support ticket by ID
-func (db *DB) GetSupportTicket(id int64) (*models.SupportTicket, error) {
+const supportTicketColumns = `id, ticket_no, customer_id, subject, description, category, priority, status, assigned_to, resolution,
+	created_at, updated_at, closed_at, rating, rating_comment, rated_at`
+
+func scanSupportTicket(row rowScanner) (*models.SupportTicket, error) {
 	var t models.SupportTicket
-	var assignedTo sql.NullInt64
-	var resolution sql.NullString
-	var closedAt sql.NullTime
-	err := db.QueryRow(`
-		SELECT id, ticket_no, customer_id, subject, description, category, priority, status, assigned_to, resolution, created_at, updated_at, closed_at
-		FROM support_tickets WHERE id = ?
-	`, id).Scan(&t.ID, &t.TicketNo, &t.CustomerID, &t.Subject, &t.Description, &t.Category, &t.Priority, &t.Status, &assignedTo, &resolution, &t.CreatedAt, &t.UpdatedAt, &closedAt)
+	var assignedTo, rating sql.NullInt64
+	var resolution, ratingComment sql.NullString
+	var closedAt, ratedAt sql.NullTime
+	err := row.Scan(&t.ID, &t.TicketNo, &t.CustomerID, &t.Subject, &t.Description, &t.Category, &t.Priority, &t.Status, &assignedTo, &resolution,
+		&t.CreatedAt, &t.UpdatedAt, &closedAt, &rating, &ratingComment, &ratedAt)
 	if err != nil {
 		return nil, err
 	}
@@ -2056,9 +5816,166 @@ func (db *DB) GetSupportTicket(id int64) (*models.SupportTicket, error) {
 	if closedAt.Valid {
 		t.ClosedAt = &closedAt.Time
 	}
+	if rating.Valid {
+		r := int(rating.Int64)
+		t.Rating = &r
+		t.RatingComment = ratingComment.String
+	}
+	if ratedAt.Valid {
+		t.RatedAt = &ratedAt.Time
+	}
 	return &t, nil
 }
 
+// GetSupportTicket retrieves a support ticket by ID
+func (db *DB) GetSupportTicket(id int64) (*models.SupportTicket, error) {
+	return scanSupportTicket(db.QueryRow(`SELECT `+supportTicketColumns+` FROM support_tickets WHERE id = ?`, id))
+}
+
+// GetTicketsDueForCloseWarning returns resolved tickets that will be
+// auto-closed within a day (no activity for days-1 days) and whose customer
+// has not been warned yet
+func (db *DB) GetTicketsDueForCloseWarning(days int) ([]*models.SupportTicket, error) {
+	rows, err := db.Query(`SELECT `+supportTicketColumns+` FROM support_tickets
+		WHERE status = 'resolved' AND close_warned_at IS NULL AND updated_at <= datetime('now', ?)
+		ORDER BY id`, fmt.Sprintf("-%d days", days-1))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tickets []*models.SupportTicket
+	for rows.Next() {
+		t, err := scanSupportTicket(rows)
+		if err != nil {
+			return nil, err
+		}
+		tickets = append(tickets, t)
+	}
+	return tickets, rows.Err()
+}
+
+// MarkTicketCloseWarned records that the customer was told the ticket is
+// about to close. It doesn't count as ticket activity.
+func (db *DB) MarkTicketCloseWarned(id int64) error {
+	_, err := db.Exec("UPDATE support_tickets SET close_warned_at = CURRENT_TIMESTAMP WHERE id = ?", id)
+	return err
+}
+
+// AutoCloseResolvedTickets closes resolved tickets without activity for the
+// given number of days whose close warning went out at least a day ago, and
+// returns the tickets it closed
+func (db *DB) AutoCloseResolvedTickets(days int) ([]*models.SupportTicket, error) {
+	var closed []*models.SupportTicket
+	err := db.WithTx(func(tx *sql.Tx) error {
+		rows, err := tx.Query(`SELECT `+supportTicketColumns+` FROM support_tickets
+			WHERE status = 'resolved' AND updated_at <= datetime('now', ?)
+			AND close_warned_at IS NOT NULL AND close_warned_at <= datetime('now', '-1 day')
+			ORDER BY id`, fmt.Sprintf("-%d days", days))
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			t, err := scanSupportTicket(rows)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			closed = append(closed, t)
+		}
+		rows.Close()
+
+		for _, t := range closed {
+			if _, err := tx.Exec(`UPDATE support_tickets SET status = 'closed', closed_at = CURRENT_TIMESTAMP,
+				updated_at = CURRENT_TIMESTAMP WHERE id = ?`, t.ID); err != nil {
+				return err
+			}
+			t.Status = "closed"
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return closed, nil
+}
+
+// ErrTicketNotClosed is returned when rating a ticket that isn't resolved or closed yet
+var ErrTicketNotClosed = errors.New("ticket is not resolved or closed")
+
+// ErrTicketAlreadyRated is returned when a ticket already has a rating
+var ErrTicketAlreadyRated = errors.New("ticket has already been rated")
+
+// RateSupportTicket stores the customer's 1-5 satisfaction rating on a
+// resolved or closed ticket. A ticket can be rated once.
+func (db *DB) RateSupportTicket(id int64, rating int, comment string) error {
+	result, err := db.Exec(`
+		UPDATE support_tickets SET rating = ?, rating_comment = ?, rated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND status IN ('resolved', 'closed') AND rating IS NULL
+	`, rating, comment, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		return nil
+	}
+
+	t, err := db.GetSupportTicket(id)
+	if err != nil {
+		return err
+	}
+	if t.Rating != nil {
+		return ErrTicketAlreadyRated
+	}
+	return ErrTicketNotClosed
+}
+
+// GetTicketStats returns ticket counts per status and customer satisfaction
+// aggregates over rated tickets
+func (db *DB) GetTicketStats() (*models.TicketStats, error) {
+	stats := &models.TicketStats{
+		ByStatus:     make(map[string]int64),
+		RatingCounts: make(map[int]int64),
+	}
+
+	rows, err := db.Query("SELECT COALESCE(status, 'open'), COUNT(*) FROM support_tickets GROUP BY status")
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var status string
+		var count int64
+		if err := rows.Scan(&status, &count); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		stats.ByStatus[status] += count
+		stats.Total += count
+	}
+	rows.Close()
+
+	rows, err = db.Query("SELECT rating, COUNT(*) FROM support_tickets WHERE rating IS NOT NULL GROUP BY rating")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var sum int64
+	for rows.Next() {
+		var rating int
+		var count int64
+		if err := rows.Scan(&rating, &count); err != nil {
+			return nil, err
+		}
+		stats.RatingCounts[rating] = count
+		stats.Rated += count
+		sum += int64(rating) * count
+	}
+	if stats.Rated > 0 {
+		stats.AverageRating = math.Round(float64(sum)/float64(stats.Rated)*100) / 100
+	}
+	return stats, rows.Err()
+}
+
 // UpdateSupportTicket updates a support ticket
 func (db *DB) UpdateSupportTicket(ticket *models.SupportTicket) error {
 	var assignedTo interface{}
@@ -2069,7 +5986,8 @@ func (db *DB) UpdateSupportTicket(ticket *models.SupportTicket) error {
 	}
 
 	_, err := db.Exec(`
-		UPDATE support_tickets SET subject = ?, description = ?, category = ?, priority = ?, status = ?, assigned_to = ?, resolution = ?, updated_at = CURRENT_TIMESTAMP, closed_at = CASE WHEN ? IN ('resolved', 'closed') THEN CURRENT_TIMESTAMP ELSE closed_at END
+		UPDATE support_tickets SET subject = ?, description = ?, category = ?, priority = ?, status = ?, assigned_to = ?, resolution = ?, updated_at = CURRENT_TIMESTAMP, closed_at = CASE WHEN ? IN ('resolved', 'closed') THEN CURRENT_TIMESTAMP ELSE closed_at END,
+		close_warned_at = NULL
 		WHERE id = ?
 	`, ticket.Subject, ticket.Description, ticket.Category, ticket.Priority, ticket.Status, assignedTo, ticket.Resolution, ticket.Status, ticket.ID)
 	return err
@@ -2081,6 +5999,185 @@ func (db *DB) DeleteSupportTicket(id int64) error {
 	return err
 }
 
+// ============== Technician Operations ==============
+
+const technicianColumns = `t.id, t.user_id, u.username, t.areas, t.skills, t.available, t.last_assigned_at, t.created_at`
+
+func scanTechnician(row rowScanner) (*models.Technician, error) {
+	var t models.Technician
+	var areas, skills sql.NullString
+	var lastAssigned sql.NullTime
+	if err := row.Scan(&t.ID, &t.UserID, &t.Username, &areas, &skills, &t.Available, &lastAssigned, &t.CreatedAt); err != nil {
+		return nil, err
+	}
+	json.Unmarshal([]byte(areas.String), &t.Areas)
+	json.Unmarshal([]byte(skills.String), &t.Skills)
+	if lastAssigned.Valid {
+		t.LastAssignedAt = &lastAssigned.Time
+	}
+	return &t, nil
+}
+
+func queryTechnicians(q interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}) ([]*models.Technician, error) {
+	rows, err := q.Query(`SELECT ` + technicianColumns + ` FROM technicians t JOIN users u ON u.id = t.user_id ORDER BY t.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	techs := []*models.Technician{}
+	for rows.Next() {
+		t, err := scanTechnician(rows)
+		if err != nil {
+			return nil, err
+		}
+		techs = append(techs, t)
+	}
+	return techs, rows.Err()
+}
+
+// GetTechnicians retrieves all technicians
+func (db *DB) GetTechnicians() ([]*models.Technician, error) {
+	return queryTechnicians(db)
+}
+
+// GetTechnician retrieves a technician by ID
+func (db *DB) GetTechnician(id int64) (*models.Technician, error) {
+	return scanTechnician(db.QueryRow(`SELECT `+technicianColumns+` FROM technicians t JOIN users u ON u.id = t.user_id WHERE t.id = ?`, id))
+}
+
+// CreateTechnician registers a user as a technician
+func (db *DB) CreateTechnician(t *models.Technician) (*models.Technician, error) {
+	areasJSON, _ := json.Marshal(t.Areas)
+	skillsJSON, _ := json.Marshal(t.Skills)
+	result, err := db.Exec(`
+		INSERT INTO technicians (user_id, areas, skills, available) VALUES (?, ?, ?, ?)
+	`, t.UserID, string(areasJSON), string(skillsJSON), t.Available)
+	if err != nil {
+		return nil, err
+	}
+	id, _ := result.LastInsertId()
+	return db.GetTechnician(id)
+}
+
+// UpdateTechnician updates a technician's areas, skills and availability
+func (db *DB) UpdateTechnician(t *models.Technician) error {
+	areasJSON, _ := json.Marshal(t.Areas)
+	skillsJSON, _ := json.Marshal(t.Skills)
+	_, err := db.Exec(`
+		UPDATE technicians SET areas = ?, skills = ?, available = ? WHERE id = ?
+	`, string(areasJSON), string(skillsJSON), t.Available, t.ID)
+	return err
+}
+
+// DeleteTechnician removes a technician (the user account is kept)
+func (db *DB) DeleteTechnician(id int64) error {
+	_, err := db.Exec("DELETE FROM technicians WHERE id = ?", id)
+	return err
+}
+
+// AutoAssignTicket assigns an unassigned ticket to a technician chosen by
+// models.SelectTechnician and advances the round-robin position. Returns nil
+// when no technician qualifies or the ticket was already assigned.
+func (db *DB) AutoAssignTicket(ticketID int64, mode, address, category string) (*models.Technician, error) {
+	var chosen *models.Technician
+	err := db.WithTx(func(tx *sql.Tx) error {
+		chosen = nil
+		techs, err := queryTechnicians(tx)
+		if err != nil {
+			return err
+		}
+		tech := models.SelectTechnician(techs, mode, address, category)
+		if tech == nil {
+			return nil
+		}
+
+		result, err := tx.Exec("UPDATE support_tickets SET assigned_to = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND assigned_to IS NULL", tech.UserID, ticketID)
+		if err != nil {
+			return err
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return nil
+		}
+		now := time.Now()
+		if _, err := tx.Exec("UPDATE technicians SET last_assigned_at = ? WHERE id = ?", now, tech.ID); err != nil {
+			return err
+		}
+		tech.LastAssignedAt = &now
+		chosen = tech
+		return nil
+	})
+	return chosen, err
+}
+
+// ============== Announcement Operations ==============
+
+const announcementColumns = `id, title, COALESCE(message, ''), COALESCE(area, ''), starts_at, ends_at, created_at`
+
+func scanAnnouncement(row rowScanner) (*models.Announcement, error) {
+	var a models.Announcement
+	var endsAt sql.NullTime
+	if err := row.Scan(&a.ID, &a.Title, &a.Message, &a.Area, &a.StartsAt, &endsAt, &a.CreatedAt); err != nil {
+		return nil, err
+	}
+	if endsAt.Valid {
+		a.EndsAt = &endsAt.Time
+	}
+	return &a, nil
+}
+
+// GetAnnouncements retrieves all announcements, latest start first
+func (db *DB) GetAnnouncements() ([]*models.Announcement, error) {
+	rows, err := db.Query(`SELECT ` + announcementColumns + ` FROM announcements ORDER BY starts_at DESC, id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	announcements := []*models.Announcement{}
+	for rows.Next() {
+		a, err := scanAnnouncement(rows)
+		if err != nil {
+			return nil, err
+		}
+		announcements = append(announcements, a)
+	}
+	return announcements, rows.Err()
+}
+
+// GetAnnouncement retrieves an announcement by ID
+func (db *DB) GetAnnouncement(id int64) (*models.Announcement, error) {
+	return scanAnnouncement(db.QueryRow(`SELECT `+announcementColumns+` FROM announcements WHERE id = ?`, id))
+}
+
+// CreateAnnouncement creates a portal announcement
+func (db *DB) CreateAnnouncement(a *models.Announcement) (*models.Announcement, error) {
+	result, err := db.Exec(`
+		INSERT INTO announcements (title, message, area, starts_at, ends_at) VALUES (?, ?, ?, ?, ?)
+	`, a.Title, a.Message, a.Area, a.StartsAt, a.EndsAt)
+	if err != nil {
+		return nil, err
+	}
+	id, _ := result.LastInsertId()
+	return db.GetAnnouncement(id)
+}
+
+// UpdateAnnouncement updates a portal announcement
+func (db *DB) UpdateAnnouncement(a *models.Announcement) error {
+	_, err := db.Exec(`
+		UPDATE announcements SET title = ?, message = ?, area = ?, starts_at = ?, ends_at = ? WHERE id = ?
+	`, a.Title, a.Message, a.Area, a.StartsAt, a.EndsAt, a.ID)
+	return err
+}
+
+// DeleteAnnouncement removes a portal announcement
+func (db *DB) DeleteAnnouncement(id int64) error {
+	_, err := db.Exec("DELETE FROM announcements WHERE id = ?", id)
+	return err
+}
+
 // RecordBandwidthUsage records bandwidth usage snapshot
 func (db *DB) RecordBandwidthUsage(deviceID int64, sent, received int64) error {
 	_, err := db.Exec("INSERT INTO bandwidth_usage (device_id, bytes_sent, bytes_received) VALUES (?, ?, ?)", deviceID, sent, received)
@@ -2106,6 +6203,105 @@ func (db *DB) GetBandwidthHistory(deviceID int64, limit int) ([]models.Bandwidth
 	return records, nil
 }
 
+// RecordClientHistory marks the active clients of a device as seen at the
+// given time: a new MAC gets a row, a known one (also after reconnecting) has
+// its last_seen, name and IP updated
+func (db *DB) RecordClientHistory(deviceID int64, clients []models.ConnectedClient, seenAt time.Time) error {
+	seenAt = seenAt.UTC()
+	return db.WithTx(func(tx *sql.Tx) error {
+		for _, c := range clients {
+			if !c.Active || c.MAC == "" {
+				continue
+			}
+			if _, err := tx.Exec(`
+				INSERT INTO client_history (device_id, mac, hostname, ip_address, interface, first_seen, last_seen)
+				VALUES (?, ?, ?, ?, ?, ?, ?)
+				ON CONFLICT(device_id, mac) DO UPDATE SET
+					hostname = excluded.hostname,
+					ip_address = excluded.ip_address,
+					interface = excluded.interface,
+					last_seen = excluded.last_seen
+			`, deviceID, strings.ToUpper(c.MAC), c.Name, c.IP, c.Interface, seenAt, seenAt); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetClientHistory returns the clients of a device that were connected at
+// some point between from and to, most recently seen first
+func (db *DB) GetClientHistory(deviceID int64, from, to time.Time) ([]*models.ClientHistoryEntry, error) {
+	rows, err := db.Query(`
+		SELECT mac, COALESCE(hostname, ''), COALESCE(ip_address, ''), COALESCE(interface, ''), first_seen, last_seen
+		FROM client_history
+		WHERE device_id = ? AND first_seen <= ? AND last_seen >= ?
+		ORDER BY last_seen DESC
+	`, deviceID, to.UTC(), from.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*models.ClientHistoryEntry{}
+	for rows.Next() {
+		var e models.ClientHistoryEntry
+		if err := rows.Scan(&e.MAC, &e.Name, &e.IP, &e.Interface, &e.FirstSeen, &e.LastSeen); err != nil {
+			return nil, err
+		}
+		entries = append(entries, &e)
+	}
+	return entries, rows.Err()
+}
+
+// opticalHistoryDays is how long optical samples are retained
+const opticalHistoryDays = 30
+
+// RecordOpticalSample stores an optical snapshot of a device
+func (db *DB) RecordOpticalSample(deviceID int64, sample *models.OpticalSample) error {
+	_, err := db.Exec(`
+		INSERT INTO optical_history (device_id, rx_power, tx_power, temperature, voltage, bias_current)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, deviceID, sample.RXPower, sample.TXPower, sample.Temperature, sample.Voltage, sample.BiasCurrent)
+	return err
+}
+
+// PruneOpticalHistory deletes samples older than opticalHistoryDays
+func (db *DB) PruneOpticalHistory() (int64, error) {
+	result, err := db.Exec("DELETE FROM optical_history WHERE recorded_at < datetime('now', ?)",
+		fmt.Sprintf("-%d days", opticalHistoryDays))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// GetOpticalHistory returns a device's optical samples of the last given
+// hours, oldest first
+func (db *DB) GetOpticalHistory(deviceID int64, hours int) ([]*models.OpticalSample, error) {
+	rows, err := db.Query(`
+		SELECT COALESCE(rx_power, 0), COALESCE(tx_power, 0), COALESCE(temperature, 0),
+			COALESCE(voltage, 0), COALESCE(bias_current, 0), recorded_at
+		FROM optical_history
+		WHERE device_id = ? AND recorded_at >= datetime('now', ?)
+		ORDER BY recorded_at, id
+	`, deviceID, fmt.Sprintf("-%d hours", hours))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	samples := []*models.OpticalSample{}
+	for rows.Next() {
+		var o models.OpticalSample
+		if err := rows.Scan(&o.RXPower, &o.TXPower, &o.Temperature, &o.Voltage, &o.BiasCurrent, &o.RecordedAt); err != nil {
+			return nil, err
+		}
+		samples = append(samples, &o)
+	}
+	return samples, rows.Err()
+}
+
 // GetNetworkStats retrieves aggregated network statistics for today
 func (db *DB) GetNetworkStats() (*models.NetworkStats, error) {
 	stats := &models.NetworkStats{
@@ -0,0 +1,143 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go-acs/internal/models"
+)
+
+// Concurrent writers used to fail with "database is locked" once more
+// transactions waited for the write lock than busy_timeout allowed for
+func TestConcurrentWritesDoNotLock(t *testing.T) {
+	db, err := InitDB(filepath.Join(t.TempDir(), "acs.db"), Options{MaxOpenConns: 8, BusyTimeoutMs: 20})
+	if err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	device, err := db.CreateDevice(&models.Device{SerialNumber: "SN001", Manufacturer: "ZTE", ModelName: "ONT"})
+	if err != nil {
+		t.Fatalf("CreateDevice: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 200)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				// A bare transaction: no WithTx retry to hide a busy error
+				err := func() error {
+					tx, err := db.Begin()
+					if err != nil {
+						return err
+					}
+					defer tx.Rollback()
+					if _, err := tx.Exec(`INSERT INTO logs (device_id, level, category, message, details) VALUES (?, 'info', 'test', ?, '')`,
+						device.ID, fmt.Sprintf("%d-%d", i, j)); err != nil {
+						return err
+					}
+					// Hold the write lock long enough for waiters to exceed busy_timeout
+					time.Sleep(2 * time.Millisecond)
+					if _, err := tx.Exec(`UPDATE devices SET uptime = uptime + 1 WHERE id = ?`, device.ID); err != nil {
+						return err
+					}
+					return tx.Commit()
+				}()
+				if err != nil {
+					errs <- err
+				}
+				var n int
+				if err := db.QueryRow(`SELECT COUNT(*) FROM logs`).Scan(&n); err != nil {
+					errs <- err
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("concurrent access failed: %v", err)
+	}
+
+	got, _ := db.GetDevice(device.ID)
+	if got.Uptime != 200 {
+		t.Fatalf("uptime = %d, want 200 committed updates", got.Uptime)
+	}
+}
+
+func TestReadsDoNotWaitForTheWriter(t *testing.T) {
+	db := newTestDB(t)
+
+	locked := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- db.WithTx(func(tx *sql.Tx) error {
+			if _, err := tx.Exec(`INSERT INTO logs (level, category, message, details) VALUES ('info', 'test', 'held', '')`); err != nil {
+				return err
+			}
+			close(locked)
+			<-release
+			return nil
+		})
+	}()
+	<-locked
+
+	read := make(chan error)
+	go func() {
+		var n int
+		read <- db.QueryRow(`SELECT COUNT(*) FROM logs WHERE message = 'held'`).Scan(&n)
+	}()
+	select {
+	case err := <-read:
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("read blocked behind an open write transaction")
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("write: %v", err)
+	}
+}
+
+func TestReadPoolRejectsWrites(t *testing.T) {
+	db := newTestDB(t)
+	var id int64
+	err := db.QueryRow(`INSERT INTO logs (level, category, message, details) VALUES ('info', 'test', 'x', '') RETURNING id`).Scan(&id)
+	if err == nil {
+		t.Fatal("a write through the read pool succeeded")
+	}
+}
+
+func TestInitDBSupportsOnlySQLite(t *testing.T) {
+	for _, driver := range []string{"", "sqlite", "SQLite3"} {
+		db, err := InitDB(filepath.Join(t.TempDir(), "acs.db"), Options{Driver: driver, BusyTimeoutMs: 1000})
+		if err != nil {
+			t.Fatalf("driver %q: %v", driver, err)
+		}
+		db.Close()
+	}
+
+	for _, driver := range []string{"postgres", "mysql", "oracle"} {
+		if _, err := InitDB(filepath.Join(t.TempDir(), "acs.db"), Options{Driver: driver}); !errors.Is(err, ErrUnsupportedDriver) {
+			t.Errorf("driver %q: err = %v, want ErrUnsupportedDriver", driver, err)
+		}
+	}
+
+	// A server URL left in DATABASE_URL must not become a file named after it
+	url := "postgres://acs:secret@db:5432/acs"
+	if _, err := InitDB(url, Options{}); err == nil || !strings.Contains(err.Error(), "not a path to a SQLite file") {
+		t.Errorf("sqlite with %s: err = %v", url, err)
+	}
+}
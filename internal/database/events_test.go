@@ -0,0 +1,41 @@
+package database
+
+import (
+	"fmt"
+	"testing"
+
+	"go-acs/internal/models"
+)
+
+func TestInformEventsAreTrimmedPerDevice(t *testing.T) {
+	db := newTestDB(t)
+	device, err := db.CreateDevice(&models.Device{SerialNumber: "SN001", Manufacturer: "ZTE", ModelName: "ONT"})
+	if err != nil {
+		t.Fatalf("CreateDevice: %v", err)
+	}
+	other, err := db.CreateDevice(&models.Device{SerialNumber: "SN002", Manufacturer: "ZTE", ModelName: "ONT"})
+	if err != nil {
+		t.Fatalf("CreateDevice: %v", err)
+	}
+	db.RecordInformEvents(other.ID, []string{"1 BOOT"}, []string{""})
+
+	for i := 0; i < informEventsKept+5; i++ {
+		if err := db.RecordInformEvents(device.ID, []string{"2 PERIODIC"}, []string{fmt.Sprint(i)}); err != nil {
+			t.Fatalf("RecordInformEvents: %v", err)
+		}
+	}
+
+	events, err := db.GetInformEvents(device.ID, 1000)
+	if err != nil {
+		t.Fatalf("GetInformEvents: %v", err)
+	}
+	if len(events) != informEventsKept {
+		t.Fatalf("%d events kept, want %d", len(events), informEventsKept)
+	}
+	if newest, oldest := events[0].CommandKeys[0], events[len(events)-1].CommandKeys[0]; newest != fmt.Sprint(informEventsKept+4) || oldest != "5" {
+		t.Fatalf("kept events %s..%s, want 5..%d", oldest, newest, informEventsKept+4)
+	}
+	if events, _ := db.GetInformEvents(other.ID, 10); len(events) != 1 {
+		t.Fatalf("other device has %d events, want 1", len(events))
+	}
+}
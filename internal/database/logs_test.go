@@ -0,0 +1,70 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"go-acs/internal/models"
+)
+
+func TestGetLogsFiltersByCategoryAndDateRange(t *testing.T) {
+	db := newTestDB(t)
+	device, err := db.CreateDevice(&models.Device{SerialNumber: "SN001", Manufacturer: "ZTE", ModelName: "ONT"})
+	if err != nil {
+		t.Fatalf("CreateDevice: %v", err)
+	}
+	db.Exec(`DELETE FROM logs`)
+
+	for _, l := range []struct {
+		device   bool
+		level    string
+		category string
+		at       string
+	}{
+		{true, "warning", "firmware", "2026-03-01 08:00:00"},
+		{true, "warning", "firmware", "2026-03-05 23:59:59"},
+		{true, "warning", "firmware", "2026-03-06 00:00:00"}, // after the range
+		{true, "warning", "firmware", "2026-02-28 23:59:59"}, // before the range
+		{true, "error", "firmware", "2026-03-02 10:00:00"},   // other level
+		{true, "warning", "wan", "2026-03-02 10:00:00"},      // other category
+		{false, "warning", "firmware", "2026-03-03 10:00:00"},
+	} {
+		var deviceID interface{}
+		if l.device {
+			deviceID = device.ID
+		}
+		if _, err := db.Exec(`INSERT INTO logs (device_id, level, category, message, details, created_at) VALUES (?, ?, ?, 'm', '', ?)`,
+			deviceID, l.level, l.category, l.at); err != nil {
+			t.Fatalf("insert log: %v", err)
+		}
+	}
+
+	from := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2026, 3, 5, 23, 59, 59, 0, time.UTC)
+	filter := models.LogFilter{Level: "warning", Category: "firmware", From: &from, To: &to}
+
+	logs, total, err := db.GetLogs(filter, 100, 0)
+	if err != nil {
+		t.Fatalf("GetLogs: %v", err)
+	}
+	if total != 3 || len(logs) != 3 {
+		t.Fatalf("got %d logs (total %d), want 3", len(logs), total)
+	}
+
+	filter.DeviceID = &device.ID
+	logs, total, err = db.GetLogs(filter, 1, 0)
+	if err != nil {
+		t.Fatalf("GetLogs: %v", err)
+	}
+	if total != 2 || len(logs) != 1 {
+		t.Fatalf("device page: got %d logs (total %d), want 1 of 2", len(logs), total)
+	}
+	if logs[0].CreatedAt.Day() != 5 {
+		t.Fatalf("first log is from %v, want the newest", logs[0].CreatedAt)
+	}
+
+	filter.Category = "all"
+	if _, total, _ = db.GetLogs(filter, 100, 0); total != 3 {
+		t.Fatalf("category all: total %d, want 3", total)
+	}
+}
@@ -0,0 +1,43 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestRetiredOpticalSettingsMigrate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "acs.db")
+	db, err := InitDB(path, Options{})
+	if err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	db.Exec(`INSERT INTO settings (key, value) VALUES ('optical_alert_dbm', '-26'), ('rx_power_critical', '-29'), ('rx_warning_dbm', '-28')`)
+	db.Close()
+
+	db, err = InitDB(path, Options{})
+	if err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	defer db.Close()
+
+	settings := map[string]string{}
+	rows, err := db.Query(`SELECT key, value FROM settings WHERE key LIKE 'rx_%' OR key LIKE 'optical_%'`)
+	if err != nil {
+		t.Fatalf("query settings: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var k, v string
+		rows.Scan(&k, &v)
+		settings[k] = v
+	}
+	want := map[string]string{"rx_good_dbm": "-26", "rx_warning_dbm": "-28"}
+	if len(settings) != len(want) {
+		t.Fatalf("settings after migration = %v, want %v", settings, want)
+	}
+	for k, v := range want {
+		if settings[k] != v {
+			t.Errorf("%s = %q, want %q", k, settings[k], v)
+		}
+	}
+}
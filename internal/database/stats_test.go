@@ -0,0 +1,162 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"go-acs/internal/models"
+)
+
+func TestComputeARPU(t *testing.T) {
+	tests := []struct {
+		revenue   float64
+		customers int64
+		want      float64
+	}{
+		{300000, 3, 100000},
+		{100000, 3, 33333.33},
+		{150000, 0, 0},
+		{0, 5, 0},
+	}
+	for _, tt := range tests {
+		if got := computeARPU(tt.revenue, tt.customers); got != tt.want {
+			t.Errorf("computeARPU(%v, %d) = %v, want %v", tt.revenue, tt.customers, got, tt.want)
+		}
+	}
+}
+
+func TestComputeChurnRate(t *testing.T) {
+	tests := []struct {
+		churned, base int64
+		want          float64
+	}{
+		{1, 4, 25},
+		{1, 3, 33.33},
+		{0, 10, 0},
+		{2, 0, 0},
+	}
+	for _, tt := range tests {
+		if got := computeChurnRate(tt.churned, tt.base); got != tt.want {
+			t.Errorf("computeChurnRate(%d, %d) = %v, want %v", tt.churned, tt.base, got, tt.want)
+		}
+	}
+}
+
+func TestBillingStatsARPUAndChurn(t *testing.T) {
+	db := newTestDB(t)
+	// Four customers joined before this month; one of them was terminated
+	// this month, one joined this month, and one left before this month
+	seed := []struct {
+		code, status, joined, updated string
+	}{
+		{"C1", "active", "date('now', 'start of month', '-2 months')", "datetime('now')"},
+		{"C2", "active", "date('now', 'start of month', '-2 months')", "datetime('now')"},
+		{"C3", "active", "date('now', 'start of month', '-1 months')", "datetime('now')"},
+		{"C4", "terminated", "date('now', 'start of month', '-1 months')", "datetime('now')"},
+		{"C5", "active", "date('now')", "datetime('now')"},
+		{"C6", "terminated", "date('now', 'start of month', '-3 months')", "date('now', 'start of month', '-1 months')"},
+	}
+	for _, c := range seed {
+		if _, err := db.Exec(`INSERT INTO customers (customer_code, name, status, join_date, updated_at)
+			VALUES (?, 'Customer', ?, `+c.joined+`, `+c.updated+`)`, c.code, c.status); err != nil {
+			t.Fatalf("insert customer %s: %v", c.code, err)
+		}
+	}
+
+	now := time.Now()
+	for _, p := range []struct {
+		amount float64
+		status string
+		date   time.Time
+	}{
+		{150000, "completed", now},
+		{250000, "completed", now},
+		{100000, "pending", now},
+		{500000, "completed", now.AddDate(0, -2, 0)},
+	} {
+		if _, err := db.CreatePayment(&models.Payment{CustomerID: 1, Amount: p.amount, Status: p.status, PaymentDate: p.date}); err != nil {
+			t.Fatalf("CreatePayment: %v", err)
+		}
+	}
+
+	// The seed dates are SQLite's 'now', which is UTC
+	stats, err := db.GetBillingStats(now.UTC())
+	if err != nil {
+		t.Fatalf("GetBillingStats: %v", err)
+	}
+	if stats.ActiveCustomers != 4 {
+		t.Fatalf("active customers = %d, want 4", stats.ActiveCustomers)
+	}
+	// 400000 completed this month over 4 active customers
+	if stats.ARPU != 100000 {
+		t.Errorf("ARPU = %v, want 100000", stats.ARPU)
+	}
+	if stats.ChurnedCustomers != 1 || stats.NewCustomers != 1 {
+		t.Errorf("churned %d new %d, want 1 and 1", stats.ChurnedCustomers, stats.NewCustomers)
+	}
+	if stats.ChurnRate != 25 {
+		t.Errorf("churn rate = %v, want 25", stats.ChurnRate)
+	}
+}
+
+func TestBillingStatsUseTheLocalDayAndMonth(t *testing.T) {
+	db := newTestDB(t)
+	jakarta, err := time.LoadLocation("Asia/Jakarta")
+	if err != nil {
+		t.Skipf("no timezone database: %v", err)
+	}
+	// 03:00 on 1 November in Jakarta is still 31 October in UTC
+	now := time.Date(2026, 11, 1, 3, 0, 0, 0, jakarta)
+
+	for _, c := range []struct {
+		code, status, joined, updated string
+	}{
+		{"C1", "active", "2026-09-01 00:00:00", "2026-09-01 00:00:00"},
+		{"C2", "active", "2026-10-31 18:00:00", "2026-10-31 18:00:00"},     // 1 Nov 01:00 local
+		{"C3", "terminated", "2026-09-01 00:00:00", "2026-10-31 16:00:00"}, // 31 Oct 23:00 local
+	} {
+		if _, err := db.Exec(`INSERT INTO customers (customer_code, name, status, join_date, updated_at) VALUES (?, 'Customer', ?, ?, ?)`,
+			c.code, c.status, c.joined, c.updated); err != nil {
+			t.Fatalf("insert customer %s: %v", c.code, err)
+		}
+	}
+
+	// CURRENT_TIMESTAMP-style UTC values and Go times with their offset
+	for i, p := range []struct {
+		amount float64
+		date   interface{}
+	}{
+		{100000, "2026-10-31 18:00:00"},                          // 1 Nov 01:00 local
+		{20000, time.Date(2026, 11, 1, 2, 0, 0, 0, jakarta)},     // 1 Nov 02:00 local
+		{500000, "2026-10-31 16:30:00"},                          // 31 Oct 23:30 local
+		{700000, time.Date(2026, 10, 31, 23, 59, 0, 0, jakarta)}, // 31 Oct local
+	} {
+		if _, err := db.Exec(`INSERT INTO payments (payment_no, customer_id, amount, status, payment_date) VALUES (?, 1, ?, 'completed', ?)`,
+			i, p.amount, p.date); err != nil {
+			t.Fatalf("insert payment: %v", err)
+		}
+	}
+	for i, paidAt := range []interface{}{"2026-10-31 17:30:00", "2026-10-31 16:59:59"} {
+		if _, err := db.Exec(`INSERT INTO invoices (invoice_no, customer_id, total, paid_amount, status, paid_at) VALUES (?, 1, 100000, 100000, 'paid', ?)`,
+			i, paidAt); err != nil {
+			t.Fatalf("insert invoice: %v", err)
+		}
+	}
+
+	stats, err := db.GetBillingStats(now)
+	if err != nil {
+		t.Fatalf("GetBillingStats: %v", err)
+	}
+	if stats.TodayPayments != 120000 {
+		t.Errorf("today's payments = %v, want 120000", stats.TodayPayments)
+	}
+	if stats.ARPU != 60000 {
+		t.Errorf("ARPU = %v, want 60000 (120000 this month over 2 active customers)", stats.ARPU)
+	}
+	if stats.MonthlyRevenue != 100000 {
+		t.Errorf("monthly revenue = %v, want 100000", stats.MonthlyRevenue)
+	}
+	if stats.NewCustomers != 1 || stats.ChurnedCustomers != 0 {
+		t.Errorf("new %d churned %d, want 1 and 0", stats.NewCustomers, stats.ChurnedCustomers)
+	}
+}
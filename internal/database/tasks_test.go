@@ -0,0 +1,115 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"go-acs/internal/models"
+)
+
+func newTestDB(t *testing.T) *DB {
+	t.Helper()
+	db, err := InitDB(filepath.Join(t.TempDir(), "acs.db"), Options{BusyTimeoutMs: 5000})
+	if err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestPendingCapNeverDropsTheNewTask(t *testing.T) {
+	db := newTestDB(t)
+	db.SetMaxPendingTasks(2)
+	device, err := db.CreateDevice(&models.Device{SerialNumber: "SN001", Manufacturer: "ZTE", ModelName: "ONT"})
+	if err != nil {
+		t.Fatalf("CreateDevice: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		db.CreateTask(&models.DeviceTask{DeviceID: device.ID, Type: models.TaskReboot, Parameters: json.RawMessage(fmt.Sprintf(`{"n":%d}`, i))})
+	}
+	// Lower priority than everything queued, so it would be the first to go
+	task, err := db.CreateTask(&models.DeviceTask{DeviceID: device.ID, Type: models.TaskSetParameterValues,
+		Priority: models.TaskPriorityLow, Parameters: json.RawMessage(`{"A.B": "1"}`)})
+	if err != nil {
+		t.Fatalf("CreateTask: %v", err)
+	}
+
+	pending, err := db.GetPendingTasks(device.ID)
+	if err != nil {
+		t.Fatalf("GetPendingTasks: %v", err)
+	}
+	if len(pending) != 2 {
+		t.Fatalf("%d pending tasks, want the cap of 2", len(pending))
+	}
+	found := false
+	for _, p := range pending {
+		found = found || p.ID == task.ID
+	}
+	if !found {
+		t.Fatal("the task just created was pruned")
+	}
+}
+
+func TestPendingCapCanChangeWhileCreatingTasks(t *testing.T) {
+	db := newTestDB(t)
+	device, err := db.CreateDevice(&models.Device{SerialNumber: "SN001", Manufacturer: "ZTE", ModelName: "ONT"})
+	if err != nil {
+		t.Fatalf("CreateDevice: %v", err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(n int) {
+			defer wg.Done()
+			db.SetMaxPendingTasks(n % 3)
+		}(i)
+		go func() {
+			defer wg.Done()
+			db.CreateTask(&models.DeviceTask{DeviceID: device.ID, Type: models.TaskRefresh})
+		}()
+	}
+	wg.Wait()
+}
+
+func TestPendingTasksRunHighestPriorityFirst(t *testing.T) {
+	db := newTestDB(t)
+	device, err := db.CreateDevice(&models.Device{SerialNumber: "SN001", Manufacturer: "ZTE", ModelName: "ONT"})
+	if err != nil {
+		t.Fatalf("CreateDevice: %v", err)
+	}
+
+	queue := []*models.DeviceTask{
+		{DeviceID: device.ID, Type: models.TaskSetParameterValues, Parameters: json.RawMessage(`{"n":1}`)},
+		{DeviceID: device.ID, Type: models.TaskSetParameterValues, Priority: models.TaskPriorityLow, Parameters: json.RawMessage(`{"n":2}`)},
+		{DeviceID: device.ID, Type: models.TaskSetParameterValues, Parameters: json.RawMessage(`{"n":3}`)},
+		{DeviceID: device.ID, Type: models.TaskReboot},
+	}
+	for _, task := range queue {
+		if _, err := db.CreateTask(task); err != nil {
+			t.Fatalf("CreateTask: %v", err)
+		}
+	}
+	if queue[3].Priority != models.TaskPriorityHigh || queue[0].Priority != models.TaskPriorityNormal {
+		t.Fatalf("default priorities: reboot %d, set %d", queue[3].Priority, queue[0].Priority)
+	}
+
+	pending, err := db.GetPendingTasks(device.ID)
+	if err != nil {
+		t.Fatalf("GetPendingTasks: %v", err)
+	}
+	// The reboot jumps the queue, equal priorities keep their order and the
+	// low priority task goes last
+	want := []int64{queue[3].ID, queue[0].ID, queue[2].ID, queue[1].ID}
+	if len(pending) != len(want) {
+		t.Fatalf("%d pending tasks, want %d", len(pending), len(want))
+	}
+	for i, p := range pending {
+		if p.ID != want[i] {
+			t.Fatalf("pending[%d] = task %d (%s, priority %d), want task %d", i, p.ID, p.Type, p.Priority, want[i])
+		}
+	}
+}
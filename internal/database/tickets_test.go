@@ -0,0 +1,119 @@
+package database
+
+import (
+	"testing"
+
+	"go-acs/internal/models"
+)
+
+// insertResolvedTicket adds a resolved ticket whose last activity and close
+// warning are SQLite datetime() modifiers relative to now ("" = not warned)
+func insertResolvedTicket(t *testing.T, db *DB, no, updated, warned string) int64 {
+	t.Helper()
+	warnedAt := "NULL"
+	if warned != "" {
+		warnedAt = "datetime('now', " + warned + ")"
+	}
+	res, err := db.Exec(`INSERT INTO support_tickets (ticket_no, customer_id, subject, description, status, updated_at, close_warned_at)
+		VALUES (?, 1, 'No internet', '', 'resolved', datetime('now', `+updated+`), `+warnedAt+`)`, no)
+	if err != nil {
+		t.Fatalf("insert ticket %s: %v", no, err)
+	}
+	id, _ := res.LastInsertId()
+	return id
+}
+
+func ticketNos(tickets []*models.SupportTicket) map[string]bool {
+	nos := make(map[string]bool)
+	for _, t := range tickets {
+		nos[t.TicketNo] = true
+	}
+	return nos
+}
+
+func TestAutoCloseBoundary(t *testing.T) {
+	db := newTestDB(t)
+	if _, err := db.Exec(`INSERT INTO customers (customer_code, name, status) VALUES ('C1', 'Customer', 'active')`); err != nil {
+		t.Fatalf("insert customer: %v", err)
+	}
+	const days = 7
+
+	// The warning goes out once a ticket is a day short of the limit
+	insertResolvedTicket(t, db, "WARN-JUST-BEFORE", "'-6 days', '+1 minute'", "")
+	insertResolvedTicket(t, db, "WARN-JUST-AFTER", "'-6 days', '-1 minute'", "")
+	insertResolvedTicket(t, db, "WARN-ALREADY", "'-6 days', '-1 minute'", "'-1 minute'")
+
+	// A ticket is closed after the full idle period, and only once its
+	// warning is at least a day old
+	insertResolvedTicket(t, db, "CLOSE-JUST-BEFORE", "'-7 days', '+1 minute'", "'-2 days'")
+	insertResolvedTicket(t, db, "CLOSE-JUST-AFTER", "'-7 days', '-1 minute'", "'-1 day', '-1 minute'")
+	insertResolvedTicket(t, db, "CLOSE-WARNED-LATE", "'-7 days', '-1 minute'", "'-1 day', '+1 minute'")
+	insertResolvedTicket(t, db, "CLOSE-NOT-WARNED", "'-30 days'", "")
+
+	warn, err := db.GetTicketsDueForCloseWarning(days)
+	if err != nil {
+		t.Fatalf("GetTicketsDueForCloseWarning: %v", err)
+	}
+	gotWarn := ticketNos(warn)
+	for no, want := range map[string]bool{
+		"WARN-JUST-BEFORE": false,
+		"WARN-JUST-AFTER":  true,
+		"WARN-ALREADY":     false,
+		"CLOSE-NOT-WARNED": true,
+	} {
+		if gotWarn[no] != want {
+			t.Errorf("%s due for a warning = %v, want %v", no, gotWarn[no], want)
+		}
+	}
+
+	closed, err := db.AutoCloseResolvedTickets(days)
+	if err != nil {
+		t.Fatalf("AutoCloseResolvedTickets: %v", err)
+	}
+	gotClosed := ticketNos(closed)
+	for _, no := range []string{"WARN-JUST-AFTER", "CLOSE-JUST-BEFORE", "CLOSE-WARNED-LATE", "CLOSE-NOT-WARNED"} {
+		if gotClosed[no] {
+			t.Errorf("%s was closed too early", no)
+		}
+	}
+	if len(closed) != 1 || !gotClosed["CLOSE-JUST-AFTER"] {
+		t.Fatalf("closed %v, want only CLOSE-JUST-AFTER", gotClosed)
+	}
+
+	ticket, err := db.GetSupportTicket(closed[0].ID)
+	if err != nil {
+		t.Fatalf("GetSupportTicket: %v", err)
+	}
+	if ticket.Status != "closed" || ticket.ClosedAt == nil {
+		t.Errorf("closed ticket status %q closedAt %v, want closed with a time", ticket.Status, ticket.ClosedAt)
+	}
+}
+
+func TestTicketActivityResetsCloseWarning(t *testing.T) {
+	db := newTestDB(t)
+	if _, err := db.Exec(`INSERT INTO customers (customer_code, name, status) VALUES ('C1', 'Customer', 'active')`); err != nil {
+		t.Fatalf("insert customer: %v", err)
+	}
+	id := insertResolvedTicket(t, db, "REPLIED", "'-8 days'", "'-2 days'")
+
+	ticket, err := db.GetSupportTicket(id)
+	if err != nil {
+		t.Fatalf("GetSupportTicket: %v", err)
+	}
+	ticket.Description = "Customer: it dropped again last night"
+	if err := db.UpdateSupportTicket(ticket); err != nil {
+		t.Fatalf("UpdateSupportTicket: %v", err)
+	}
+
+	closed, err := db.AutoCloseResolvedTickets(7)
+	if err != nil {
+		t.Fatalf("AutoCloseResolvedTickets: %v", err)
+	}
+	if len(closed) != 0 {
+		t.Errorf("closed %d tickets after customer activity, want 0", len(closed))
+	}
+	warn, _ := db.GetTicketsDueForCloseWarning(7)
+	if len(warn) != 0 {
+		t.Errorf("%d tickets due for a warning right after activity, want 0", len(warn))
+	}
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"go-acs/internal/models"
+)
+
+func TestPortalDashboardShowsActiveAnnouncements(t *testing.T) {
+	h := newTestHandler(t, nil)
+	customer := createTestCustomer(t, h, "C001", "")
+	if _, err := h.DB.Exec(`UPDATE customers SET address = 'Jl. Merdeka 5, Sukamaju' WHERE id = ?`, customer.ID); err != nil {
+		t.Fatalf("set address: %v", err)
+	}
+
+	now := time.Now()
+	window := func(start, end time.Duration) string {
+		return fmt.Sprintf(`"startsAt":%q,"endsAt":%q`, now.Add(start).Format(time.RFC3339), now.Add(end).Format(time.RFC3339))
+	}
+	for _, body := range []string{
+		`{"title":"Everyone now",` + window(-time.Hour, time.Hour) + `}`,
+		`{"title":"Sukamaju now","area":"sukamaju",` + window(-time.Hour, time.Hour) + `}`,
+		`{"title":"No end"}`,
+		`{"title":"Expired",` + window(-2*time.Hour, -time.Hour) + `}`,
+		`{"title":"Scheduled",` + window(time.Hour, 2*time.Hour) + `}`,
+		`{"title":"Other area","area":"Cibodas",` + window(-time.Hour, time.Hour) + `}`,
+	} {
+		if rec := serve(h.CreateAnnouncement, http.MethodPost, body, nil); rec.Code != http.StatusCreated {
+			t.Fatalf("create %s = %d %s", body, rec.Code, rec.Body)
+		}
+	}
+
+	rec := serveAsCustomer(h, customer.ID, h.GetPortalDashboard, http.MethodGet, "", nil)
+	var resp struct {
+		Announcements []*models.Announcement `json:"announcements"`
+	}
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &resp) != nil {
+		t.Fatalf("portal dashboard = %d %s", rec.Code, rec.Body)
+	}
+	got := map[string]bool{}
+	for _, a := range resp.Announcements {
+		got[a.Title] = true
+	}
+	for title, want := range map[string]bool{
+		"Everyone now": true,
+		"Sukamaju now": true,
+		"No end":       true,
+		"Expired":      false,
+		"Scheduled":    false,
+		"Other area":   false,
+	} {
+		if got[title] != want {
+			t.Errorf("%q shown = %v, want %v", title, got[title], want)
+		}
+	}
+
+	// Operators still see every announcement
+	rec = serve(h.GetAnnouncements, http.MethodGet, "", nil)
+	var all []*models.Announcement
+	if json.Unmarshal(rec.Body.Bytes(), &all) != nil || len(all) != 6 {
+		t.Errorf("announcements = %d %s, want all 6", rec.Code, rec.Body)
+	}
+
+	if rec := serve(h.CreateAnnouncement, http.MethodPost, `{"title":"Backwards",`+window(time.Hour, -time.Hour)+`}`, nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("end before start = %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"go-acs/internal/models"
+)
+
+func TestAnonymizeCustomerClearsPIIAndKeepsInvoices(t *testing.T) {
+	h := newTestHandler(t, nil)
+	customer := createTestCustomer(t, h, "C001", "08123456789")
+	h.DB.Exec(`UPDATE customers SET email = 'budi@example.com', address = 'Jl. Mawar 1' WHERE id = ?`, customer.ID)
+	invoiceID := createTestInvoice(t, h, customer.ID, "INV-1", time.Now(), models.InvoicePaid, 100000)
+
+	if rec := serve(h.AnonymizeCustomer, "POST", "", map[string]string{"id": "1"}); rec.Code != 200 {
+		t.Fatalf("AnonymizeCustomer = %d %s", rec.Code, rec.Body)
+	}
+
+	got, err := h.DB.GetCustomer(customer.ID)
+	if err != nil {
+		t.Fatalf("GetCustomer: %v", err)
+	}
+	if got.Name != "Anonymized C001" || got.Email != "" || got.Phone != "" || got.Address != "" {
+		t.Fatalf("PII left after anonymize: name %q email %q phone %q address %q", got.Name, got.Email, got.Phone, got.Address)
+	}
+	if got.Status != "active" {
+		t.Fatalf("status = %s, want it left active", got.Status)
+	}
+
+	invoice, err := h.DB.GetInvoice(invoiceID)
+	if err != nil {
+		t.Fatalf("GetInvoice: %v", err)
+	}
+	if invoice.CustomerID != customer.ID || invoice.Total != 100000 {
+		t.Fatalf("invoice = customer %d total %v, want still linked to %d with its total", invoice.CustomerID, invoice.Total, customer.ID)
+	}
+}
+
+func TestAnonymizeUnknownCustomer(t *testing.T) {
+	h := newTestHandler(t, nil)
+	if rec := serve(h.AnonymizeCustomer, "POST", "", map[string]string{"id": "42"}); rec.Code != 404 {
+		t.Fatalf("AnonymizeCustomer = %d, want 404", rec.Code)
+	}
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"go-acs/internal/models"
+)
+
+func TestExportAuditStreamsAuditLog(t *testing.T) {
+	h := newTestHandler(t, nil)
+	for _, e := range []*models.AuditEntry{
+		{UserID: 2, Username: "alice", Action: "PUT /api/customers/{id}", TargetType: "customers", TargetID: "7", Status: 200},
+		{UserID: 2, Username: "alice", Action: "POST /api/settings", Status: 200},
+		{UserID: 3, Username: "bob", Action: "PUT /api/packages/{id}", TargetType: "packages", TargetID: "1", Status: 200},
+	} {
+		if err := h.DB.RecordAudit(e); err != nil {
+			t.Fatalf("RecordAudit: %v", err)
+		}
+	}
+	h.DB.Exec(`UPDATE audit_log SET created_at = '2020-01-01 00:00:00' WHERE action = 'POST /api/settings'`)
+
+	export := func(query string) [][]string {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.ExportAudit(rec, httptest.NewRequest("GET", "/api/audit/export?"+query, nil))
+		if rec.Code != 200 {
+			t.Fatalf("ExportAudit(%s) = %d %s", query, rec.Code, rec.Body)
+		}
+		rows, err := csv.NewReader(rec.Body).ReadAll()
+		if err != nil {
+			t.Fatalf("read CSV: %v", err)
+		}
+		return rows[1:]
+	}
+
+	if rows := export(""); len(rows) != 3 {
+		t.Fatalf("unfiltered export has %d rows, want 3", len(rows))
+	}
+	rows := export("user=alice&from=2024-01-01")
+	if len(rows) != 1 || rows[0][4] != "PUT /api/customers/{id}" || rows[0][5] != "customers" || rows[0][6] != "7" {
+		t.Fatalf("filtered export = %v, want alice's customer change only", rows)
+	}
+	if rows := export("action=packages"); len(rows) != 1 || rows[0][3] != "bob" {
+		t.Fatalf("action filter = %v, want bob's package change", rows)
+	}
+}
+
+func TestExportAuditFiltersByLocalDateRange(t *testing.T) {
+	h := newTestHandler(t, nil)
+	h.Config.Location = time.FixedZone("WIB", 7*60*60)
+	// created_at is UTC; 1 March in WIB runs from 28 Feb 17:00 to 1 Mar 17:00 UTC
+	for _, e := range []struct {
+		user, action, at string
+	}{
+		{"alice", "POST /api/customers", "2026-02-28 16:59:59"},
+		{"alice", "PUT /api/customers/{id}", "2026-02-28 17:00:00"},
+		{"bob", "DELETE /api/devices/{id}", "2026-03-01 09:30:00"},
+		{"alice", "POST /api/settings", "2026-03-01 16:59:59"},
+		{"alice", "PUT /api/packages/{id}", "2026-03-01 17:00:00"},
+	} {
+		if err := h.DB.RecordAudit(&models.AuditEntry{Username: e.user, Action: e.action, Status: 200}); err != nil {
+			t.Fatalf("RecordAudit: %v", err)
+		}
+		h.DB.Exec(`UPDATE audit_log SET created_at = ? WHERE action = ?`, e.at, e.action)
+	}
+
+	export := func(query string) [][]string {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.ExportAudit(rec, httptest.NewRequest("GET", "/api/audit/export?"+query, nil))
+		if rec.Code != 200 {
+			t.Fatalf("ExportAudit(%s) = %d %s", query, rec.Code, rec.Body)
+		}
+		rows, err := csv.NewReader(rec.Body).ReadAll()
+		if err != nil {
+			t.Fatalf("read CSV: %v", err)
+		}
+		if want := []string{"id", "created_at", "user_id", "username", "action", "target_type", "target_id", "ip", "status"}; !reflect.DeepEqual(rows[0], want) {
+			t.Fatalf("header = %v, want %v", rows[0], want)
+		}
+		return rows[1:]
+	}
+	actions := func(rows [][]string) []string {
+		var got []string
+		for _, row := range rows {
+			got = append(got, row[4])
+		}
+		return got
+	}
+
+	rows := export("from=2026-03-01&to=2026-03-01")
+	if got, want := actions(rows), []string{"PUT /api/customers/{id}", "DELETE /api/devices/{id}", "POST /api/settings"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("1 March export = %v, want %v in order", got, want)
+	}
+	if rows[0][1] != "2026-02-28T17:00:00Z" {
+		t.Errorf("created_at = %s, want RFC3339 UTC", rows[0][1])
+	}
+
+	// Filters combine, and the user matches regardless of case
+	if got := actions(export("user=ALICE&action=customers&from=2026-03-01")); !reflect.DeepEqual(got, []string{"PUT /api/customers/{id}"}) {
+		t.Errorf("combined filters = %v, want alice's customer change on 1 March", got)
+	}
+	if rows := export("user=carol"); len(rows) != 0 {
+		t.Errorf("unknown user exported %d rows", len(rows))
+	}
+
+	rec := httptest.NewRecorder()
+	h.ExportAudit(rec, httptest.NewRequest("GET", "/api/audit/export?to=yesterday", nil))
+	if rec.Code != 400 {
+		t.Errorf("invalid 'to' = %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"go-acs/internal/models"
+)
+
+// createTestInvoice inserts an invoice for a customer due on due
+func createTestInvoice(t *testing.T, h *Handler, customerID int64, no string, due time.Time, status models.InvoiceStatus, paid float64) int64 {
+	t.Helper()
+	res, err := h.DB.Exec(`
+		INSERT INTO invoices (invoice_no, customer_id, period_start, period_end, due_date, subtotal, total, status, paid_amount)
+		VALUES (?, ?, ?, ?, ?, 100000, 100000, ?, ?)
+	`, no, customerID, due.AddDate(0, -1, 0), due, due, status, paid)
+	if err != nil {
+		t.Fatalf("insert invoice: %v", err)
+	}
+	id, _ := res.LastInsertId()
+	return id
+}
+
+func TestBatchIsolirFindsInvoicesMarkedOverdue(t *testing.T) {
+	h := newTestHandler(t, nil)
+	customer := createTestCustomer(t, h, "C001", "")
+	createTestInvoice(t, h, customer.ID, "INV-1", time.Now().AddDate(0, 0, -40), models.InvoicePending, 0)
+
+	if _, err := h.DB.MarkOverdueInvoices(time.Now().Format("2006-01-02")); err != nil {
+		t.Fatalf("MarkOverdueInvoices: %v", err)
+	}
+	if rec := serve(h.BatchIsolirOverdue, "POST", `{"daysOverdue": 30}`, nil); rec.Code != 200 {
+		t.Fatalf("BatchIsolirOverdue = %d %s", rec.Code, rec.Body)
+	}
+
+	got, _ := h.DB.GetCustomer(customer.ID)
+	if got.Status != "suspended" {
+		t.Fatalf("customer status = %s, want suspended", got.Status)
+	}
+}
+
+func TestUnsuspendCarriesOverdueInvoicesForward(t *testing.T) {
+	h := newTestHandler(t, nil)
+	h.Config.CarryForwardMaxInvoices = 0
+	h.Config.CarryForwardMaxAmount = 0
+	customer := createTestCustomer(t, h, "C001", "")
+	overdue := createTestInvoice(t, h, customer.ID, "INV-1", time.Now().AddDate(0, 0, -40), models.InvoiceOverdue, 0)
+	partial := createTestInvoice(t, h, customer.ID, "INV-2", time.Now().AddDate(0, 0, -10), models.InvoiceOverdue, 50000)
+	h.DB.Exec(`UPDATE customers SET status = 'suspended' WHERE id = ?`, customer.ID)
+
+	rec := serve(h.UnsuspendCustomerWithoutPayment, "POST", "", map[string]string{"id": "1"})
+	if rec.Code != 200 {
+		t.Fatalf("UnsuspendCustomerWithoutPayment = %d %s", rec.Code, rec.Body)
+	}
+
+	for id, want := range map[int64]models.InvoiceStatus{overdue: models.InvoiceCombined, partial: models.InvoiceOverdue} {
+		inv, err := h.DB.GetInvoice(id)
+		if err != nil {
+			t.Fatalf("GetInvoice: %v", err)
+		}
+		if inv.Status != want {
+			t.Errorf("invoice %s status = %s, want %s", inv.InvoiceNo, inv.Status, want)
+		}
+	}
+	count, _, _ := h.DB.GetCarriedForwardTotals(customer.ID)
+	if count != 1 {
+		t.Fatalf("carried forward = %d, want 1", count)
+	}
+}
+
+func TestFirstScheduledRunBillsJoinMonth(t *testing.T) {
+	h := newTestHandler(t, nil)
+	h.Config.ProrationEnabled = true
+	h.Config.TaxPercent = 0
+	now := time.Now()
+	joined := time.Date(now.Year(), now.Month()-1, 20, 10, 0, 0, 0, now.Location())
+	daysInMonth := time.Date(joined.Year(), joined.Month()+1, 0, 0, 0, 0, 0, now.Location()).Day()
+
+	newcomer := createTestCustomer(t, h, "C001", "")
+	existing := createTestCustomer(t, h, "C002", "")
+	h.DB.Exec(`UPDATE customers SET join_date = ?`, joined)
+	createTestInvoice(t, h, existing.ID, "INV-OLD", joined.AddDate(0, 0, 10), models.InvoicePaid, 100000)
+
+	if _, err := h.GenerateInvoicesInternal(); err != nil {
+		t.Fatalf("GenerateInvoicesInternal: %v", err)
+	}
+
+	want := map[int64]float64{
+		newcomer.ID: 100000 + h.currency().Round(100000*float64(daysInMonth-19)/float64(daysInMonth)),
+		existing.ID: 100000,
+	}
+	for id, total := range want {
+		invoices, _, err := h.DB.GetInvoices(&id, string(models.InvoicePending), 10, 0)
+		if err != nil || len(invoices) != 1 {
+			t.Fatalf("customer %d: %d pending invoice(s), err %v", id, len(invoices), err)
+		}
+		if invoices[0].Total != total {
+			t.Errorf("customer %d total = %v, want %v", id, invoices[0].Total, total)
+		}
+	}
+}
+
+func TestMarkInvoicePaidLeavesTheInvoiceUnpaidWhenThePaymentFails(t *testing.T) {
+	h := newTestHandler(t, nil)
+	customer := createTestCustomer(t, h, "C001", "")
+	id := createTestInvoice(t, h, customer.ID, "INV-1", time.Now().AddDate(0, 0, 7), models.InvoicePending, 0)
+	h.DB.Exec(`CREATE TRIGGER fail_payments BEFORE INSERT ON payments BEGIN SELECT RAISE(ABORT, 'disk I/O error'); END`)
+
+	rec := serve(h.MarkInvoicePaid, "POST", `{"method":"cash"}`, map[string]string{"id": fmt.Sprint(id)})
+	if rec.Code != 500 {
+		t.Fatalf("MarkInvoicePaid = %d %s, want 500", rec.Code, rec.Body)
+	}
+	invoice, _ := h.DB.GetInvoice(id)
+	if invoice.Status != models.InvoicePending || invoice.PaidAmount != 0 {
+		t.Fatalf("invoice = %s paid %v, want it untouched", invoice.Status, invoice.PaidAmount)
+	}
+}
+
+func TestMarkInvoicePaidRejectsABodyItCannotDecode(t *testing.T) {
+	h := newTestHandler(t, nil)
+	customer := createTestCustomer(t, h, "C001", "")
+	id := createTestInvoice(t, h, customer.ID, "INV-1", time.Now().AddDate(0, 0, 7), models.InvoicePending, 0)
+	vars := map[string]string{"id": fmt.Sprint(id)}
+
+	// None of these may fall back to paying the whole balance
+	for _, body := range []string{`{"ammount": 40000}`, `{"amount": 40000`, `{"amount": "40000"}`} {
+		if rec := serve(h.MarkInvoicePaid, "POST", body, vars); rec.Code != 400 {
+			t.Errorf("%s = %d %s, want 400", body, rec.Code, rec.Body)
+		}
+	}
+	invoice, _ := h.DB.GetInvoice(id)
+	if invoice.Status != models.InvoicePending || invoice.PaidAmount != 0 {
+		t.Fatalf("invoice = %s paid %v after rejected requests, want it untouched", invoice.Status, invoice.PaidAmount)
+	}
+
+	if rec := serve(h.MarkInvoicePaid, "POST", `{"amount": 40000, "method": "cash"}`, vars); rec.Code != 200 {
+		t.Fatalf("partial payment = %d %s", rec.Code, rec.Body)
+	}
+	if invoice, _ = h.DB.GetInvoice(id); invoice.Status != models.InvoicePartial || invoice.PaidAmount != 40000 {
+		t.Errorf("invoice = %s paid %v, want partial 40000", invoice.Status, invoice.PaidAmount)
+	}
+
+	// An empty body pays the rest
+	if rec := serve(h.MarkInvoicePaid, "POST", "", vars); rec.Code != 200 {
+		t.Fatalf("pay the balance = %d %s", rec.Code, rec.Body)
+	}
+	if invoice, _ = h.DB.GetInvoice(id); invoice.Status != models.InvoicePaid || invoice.PaidAmount != 100000 {
+		t.Errorf("invoice = %s paid %v, want paid 100000", invoice.Status, invoice.PaidAmount)
+	}
+}
@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"go-acs/internal/config"
+	"go-acs/internal/models"
+	"go-acs/internal/payment"
+)
+
+// storeCallback persists a PAID callback the way handlePaymentCallback does
+func storeCallback(t *testing.T, h *Handler, invoiceNo string, amount int64) *models.CallbackEvent {
+	t.Helper()
+	data := payment.CallbackData{InvoiceID: invoiceNo, Status: "PAID", Amount: amount, PaidAt: time.Now().Unix(), ReferenceID: "T-" + invoiceNo}
+	payload, _ := json.Marshal(data)
+	event, err := h.DB.CreateCallbackEvent(&models.CallbackEvent{
+		Gateway: "tripay", Reference: data.ReferenceID, InvoiceNo: invoiceNo, Status: data.Status, Payload: payload,
+	})
+	if err != nil {
+		t.Fatalf("CreateCallbackEvent: %v", err)
+	}
+	return event
+}
+
+func TestFailedCallbackIsRecordedAndRetried(t *testing.T) {
+	h := newTestHandler(t, nil)
+	customer := createTestCustomer(t, h, "C001", "")
+
+	// The invoice does not exist yet, so applying the callback fails
+	event := storeCallback(t, h, "INV-1", 100000)
+	if _, err := h.processCallbackEvent(event); err == nil {
+		t.Fatal("expected the callback for a missing invoice to fail")
+	}
+	stored, _ := h.DB.GetCallbackEvent(event.ID)
+	if stored.ProcessStatus != "failed" || stored.Attempts != 1 || stored.LastError == "" {
+		t.Fatalf("after failure: %+v", stored)
+	}
+
+	invoiceID := createTestInvoice(t, h, customer.ID, "INV-1", time.Now().AddDate(0, 0, 7), models.InvoicePending, 0)
+	h.RetryFailedCallbacks()
+
+	stored, _ = h.DB.GetCallbackEvent(event.ID)
+	if stored.ProcessStatus != "processed" {
+		t.Fatalf("after retry: %+v", stored)
+	}
+	invoice, _ := h.DB.GetInvoice(invoiceID)
+	if invoice.Status != models.InvoicePaid {
+		t.Fatalf("invoice status = %s, want paid", invoice.Status)
+	}
+
+	// Processed events are not retried again
+	events, _ := h.DB.GetRetryableCallbackEvents(h.callbackMaxAttempts())
+	if len(events) != 0 {
+		t.Fatalf("%d retryable events left", len(events))
+	}
+}
+
+func TestCallbackMovesToDeadLetterAfterMaxAttempts(t *testing.T) {
+	cfg := config.Load()
+	cfg.CallbackMaxAttempts = 3
+	h := newTestHandler(t, cfg)
+
+	event := storeCallback(t, h, "INV-404", 100000)
+	h.processCallbackEvent(event)
+	for i := 0; i < 5; i++ {
+		h.RetryFailedCallbacks()
+	}
+
+	stored, _ := h.DB.GetCallbackEvent(event.ID)
+	if stored.ProcessStatus != "dead" || stored.Attempts != 3 {
+		t.Fatalf("event = %+v, want dead after 3 attempts", stored)
+	}
+}
+
+func TestReprocessCallback(t *testing.T) {
+	h := newTestHandler(t, nil)
+	customer := createTestCustomer(t, h, "C001", "")
+
+	event := storeCallback(t, h, "INV-1", 100000)
+	h.DB.UpdateCallbackEventResult(event.ID, "dead", "invoice INV-1 not found")
+	vars := map[string]string{"id": fmt.Sprint(event.ID)}
+
+	if rec := serve(h.ReprocessCallback, "POST", "", vars); rec.Code != 404 {
+		t.Fatalf("reprocess without the invoice = %d %s", rec.Code, rec.Body)
+	}
+
+	createTestInvoice(t, h, customer.ID, "INV-1", time.Now().AddDate(0, 0, 7), models.InvoicePending, 0)
+	if rec := serve(h.ReprocessCallback, "POST", "", vars); rec.Code != 200 {
+		t.Fatalf("reprocess = %d %s", rec.Code, rec.Body)
+	}
+	if rec := serve(h.ReprocessCallback, "POST", "", vars); rec.Code != 409 {
+		t.Fatalf("second reprocess = %d, want 409", rec.Code)
+	}
+}
+
+// fakeGateway accepts any callback whose body is a JSON CallbackData
+type fakeGateway struct{}
+
+func (fakeGateway) CreateTransaction(req payment.TransactionRequest) (*payment.TransactionResponse, error) {
+	return &payment.TransactionResponse{}, nil
+}
+
+func (fakeGateway) GetChannels() ([]payment.PaymentChannel, error) { return nil, nil }
+
+func (fakeGateway) HandleCallback(r *http.Request) (*payment.CallbackData, error) {
+	var data payment.CallbackData
+	err := json.NewDecoder(r.Body).Decode(&data)
+	return &data, err
+}
+
+func callbackBody(invoiceNo, ref string, amount, paidAt int64) string {
+	b, _ := json.Marshal(payment.CallbackData{InvoiceID: invoiceNo, Status: "PAID", Amount: amount, PaidAt: paidAt, ReferenceID: ref})
+	return string(b)
+}
+
+func TestReplayedCallbackIsRejected(t *testing.T) {
+	h := newTestHandler(t, nil)
+	h.Payment = fakeGateway{}
+	customer := createTestCustomer(t, h, "C001", "")
+	createTestInvoice(t, h, customer.ID, "INV-1", time.Now().AddDate(0, 0, 7), models.InvoicePending, 0)
+	createTestInvoice(t, h, customer.ID, "INV-2", time.Now().AddDate(0, 0, 7), models.InvoicePending, 0)
+
+	body := callbackBody("INV-1", "T-1", 100000, time.Now().Unix())
+	if rec := serve(h.HandleTripayCallback, "POST", body, nil); rec.Code != 200 {
+		t.Fatalf("first callback = %d %s", rec.Code, rec.Body)
+	}
+	if rec := serve(h.HandleTripayCallback, "POST", body, nil); rec.Code != http.StatusConflict {
+		t.Fatalf("replayed callback = %d, want 409", rec.Code)
+	}
+	// The same reference can't be reused to pay another invoice either
+	if rec := serve(h.HandleTripayCallback, "POST", callbackBody("INV-2", "T-1", 100000, time.Now().Unix()), nil); rec.Code != http.StatusConflict {
+		t.Fatalf("reused reference = %d, want 409", rec.Code)
+	}
+	// Nor can a captured callback be sent again days later under a new reference
+	old := time.Now().Add(-72 * time.Hour).Unix()
+	if rec := serve(h.HandleTripayCallback, "POST", callbackBody("INV-2", "T-2", 100000, old), nil); rec.Code != http.StatusConflict {
+		t.Fatalf("stale callback = %d, want 409", rec.Code)
+	}
+
+	payments, _, _ := h.DB.GetPayments(&customer.ID, 10, 0)
+	if len(payments) != 1 {
+		t.Fatalf("%d payments recorded, want 1", len(payments))
+	}
+	invoice, _ := h.DB.GetInvoiceByNumber("INV-2")
+	if invoice.Status == models.InvoicePaid {
+		t.Fatal("INV-2 was paid by a replayed callback")
+	}
+}
+
+func TestCallbackWithoutPaidAtUsesTheCurrentTime(t *testing.T) {
+	h := newTestHandler(t, nil)
+	h.Payment = fakeGateway{}
+	customer := createTestCustomer(t, h, "C001", "")
+	createTestInvoice(t, h, customer.ID, "INV-1", time.Now().AddDate(0, 0, 7), models.InvoicePending, 0)
+
+	before := time.Now().Add(-time.Second)
+	if rec := serve(h.HandleTripayCallback, "POST", callbackBody("INV-1", "T-1", 100000, 0), nil); rec.Code != 200 {
+		t.Fatalf("callback = %d %s", rec.Code, rec.Body)
+	}
+	invoice, _ := h.DB.GetInvoiceByNumber("INV-1")
+	if invoice.PaidAt == nil || invoice.PaidAt.Before(before) {
+		t.Fatalf("paidAt = %v, want now rather than 1970", invoice.PaidAt)
+	}
+}
+
+func TestCallbackAmountBelowTheBalanceIsRejected(t *testing.T) {
+	h := newTestHandler(t, nil)
+	h.Payment = fakeGateway{}
+	customer := createTestCustomer(t, h, "C001", "")
+	createTestInvoice(t, h, customer.ID, "INV-1", time.Now().AddDate(0, 0, 7), models.InvoicePending, 0)
+
+	if rec := serve(h.HandleTripayCallback, "POST", callbackBody("INV-1", "T-1", 1000, time.Now().Unix()), nil); rec.Code != http.StatusConflict {
+		t.Fatalf("underpaid callback = %d, want 409", rec.Code)
+	}
+	invoice, _ := h.DB.GetInvoiceByNumber("INV-1")
+	if invoice.Status == models.InvoicePaid || invoice.PaidAmount != 0 {
+		t.Fatalf("invoice = %s paid %v after an underpaid callback", invoice.Status, invoice.PaidAmount)
+	}
+	events, _ := h.DB.GetCallbackEvents("rejected", 10, 0)
+	if len(events) != 1 {
+		t.Fatalf("%d rejected events, want 1", len(events))
+	}
+	// Rejected callbacks are not retried
+	if events, _ := h.DB.GetRetryableCallbackEvents(h.callbackMaxAttempts()); len(events) != 0 {
+		t.Fatalf("%d retryable events", len(events))
+	}
+}
+
+func TestOnlinePaymentPaysTheRemainingBalance(t *testing.T) {
+	h := newTestHandler(t, nil)
+	gateway := &recordingGateway{}
+	h.Payment = gateway
+	customer := createTestCustomer(t, h, "C001", "")
+	id := createTestInvoice(t, h, customer.ID, "INV-1", time.Now().AddDate(0, 0, 7), models.InvoicePending, 0)
+
+	// The customer paid part of it in cash first
+	partial, err := h.DB.AddInvoicePayment(id, &models.Payment{Amount: 40000, PaymentMethod: "cash", Status: "completed", PaymentDate: time.Now()})
+	if err != nil || partial.Status != models.InvoicePartial {
+		t.Fatalf("cash payment = %v (%v), want a partial invoice", partial, err)
+	}
+
+	if rec := payOnline(h, id); rec.Code != http.StatusOK {
+		t.Fatalf("create transaction = %d %s", rec.Code, rec.Body)
+	}
+	if len(gateway.requests) != 1 || gateway.requests[0].Amount != 60000 || gateway.requests[0].Items[0].Price != 60000 {
+		t.Fatalf("gateway requests = %+v, want one charge of the 60000 balance", gateway.requests)
+	}
+
+	if rec := serve(h.HandleTripayCallback, "POST", callbackBody("INV-1", "T-1", 60000, time.Now().Unix()), nil); rec.Code != http.StatusOK {
+		t.Fatalf("callback = %d %s", rec.Code, rec.Body)
+	}
+	invoice, _ := h.DB.GetInvoiceByNumber("INV-1")
+	if invoice.Status != models.InvoicePaid || invoice.PaidAmount != 100000 || invoice.PaidAt == nil {
+		t.Fatalf("invoice = %s paid %v at %v, want paid 100000", invoice.Status, invoice.PaidAmount, invoice.PaidAt)
+	}
+	payments, _, _ := h.DB.GetPayments(&customer.ID, 10, 0)
+	var total float64
+	for _, p := range payments {
+		total += p.Amount
+	}
+	if len(payments) != 2 || total != 100000 {
+		t.Fatalf("%d payments totalling %v, want the cash and online payments totalling 100000", len(payments), total)
+	}
+
+	// Nothing is left to charge
+	if rec := payOnline(h, id); rec.Code != http.StatusConflict {
+		t.Errorf("transaction for a paid invoice = %d, want 409", rec.Code)
+	}
+}
+
+func TestCallbackAboveTheBalanceAppliesOnlyTheBalance(t *testing.T) {
+	h := newTestHandler(t, nil)
+	h.Payment = fakeGateway{}
+	customer := createTestCustomer(t, h, "C001", "")
+	createTestInvoice(t, h, customer.ID, "INV-1", time.Now().AddDate(0, 0, 7), models.InvoicePartial, 40000)
+
+	// A gateway fee charged to the customer comes on top of the balance
+	if rec := serve(h.HandleTripayCallback, "POST", callbackBody("INV-1", "T-1", 62500, time.Now().Unix()), nil); rec.Code != http.StatusOK {
+		t.Fatalf("callback = %d %s", rec.Code, rec.Body)
+	}
+	invoice, _ := h.DB.GetInvoiceByNumber("INV-1")
+	if invoice.Status != models.InvoicePaid || invoice.PaidAmount != 100000 {
+		t.Fatalf("invoice = %s paid %v, want paid 100000", invoice.Status, invoice.PaidAmount)
+	}
+	payments, _, _ := h.DB.GetPayments(&customer.ID, 10, 0)
+	if len(payments) != 1 || payments[0].Amount != 60000 {
+		t.Fatalf("payments = %d, want one of the 60000 balance", len(payments))
+	}
+}
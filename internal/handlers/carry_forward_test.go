@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"go-acs/internal/config"
+	"go-acs/internal/models"
+)
+
+func TestCarryForwardLimitExceeded(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxInvoices int
+		maxAmount   float64
+		count       int
+		amount      float64
+		exceeded    bool
+	}{
+		{"no limits", 0, 0, 12, 5000000, false},
+		{"at the invoice limit", 2, 0, 2, 200000, false},
+		{"over the invoice limit", 2, 0, 3, 300000, true},
+		{"at the amount limit", 0, 300000, 3, 300000, false},
+		{"over the amount limit", 0, 300000, 1, 300001, true},
+		{"amount over, count under", 5, 150000, 2, 200000, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{CarryForwardMaxInvoices: tt.maxInvoices, CarryForwardMaxAmount: tt.maxAmount}
+			if got := carryForwardLimitExceeded(cfg, tt.count, tt.amount); (got != "") != tt.exceeded {
+				t.Fatalf("carryForwardLimitExceeded = %q, want exceeded %v", got, tt.exceeded)
+			}
+		})
+	}
+}
+
+func TestCarryForwardLimitTerminatesInsteadOfReactivating(t *testing.T) {
+	h := newTestHandler(t, nil)
+	h.Config.CarryForwardMaxInvoices = 2
+	h.Config.CarryForwardMaxAmount = 0
+	customer := createTestCustomer(t, h, "C001", "")
+	vars := map[string]string{"id": fmt.Sprint(customer.ID)}
+
+	// Two invoices carried forward earlier, a third is now overdue
+	createTestInvoice(t, h, customer.ID, "INV-1", time.Now().AddDate(0, -2, 0), models.InvoiceCombined, 0)
+	createTestInvoice(t, h, customer.ID, "INV-2", time.Now().AddDate(0, -1, 0), models.InvoiceCombined, 0)
+	third := createTestInvoice(t, h, customer.ID, "INV-3", time.Now().AddDate(0, 0, -5), models.InvoiceOverdue, 0)
+	h.DB.Exec(`UPDATE customers SET status = 'suspended' WHERE id = ?`, customer.ID)
+
+	rec := serve(h.UnsuspendCustomerWithoutPayment, "POST", "", vars)
+	if rec.Code != 409 {
+		t.Fatalf("UnsuspendCustomerWithoutPayment = %d %s, want 409", rec.Code, rec.Body)
+	}
+
+	got, _ := h.DB.GetCustomer(customer.ID)
+	if got.Status != "terminated" {
+		t.Fatalf("customer status = %s, want terminated", got.Status)
+	}
+	// Nothing more is carried forward and no new invoice is issued
+	if inv, _ := h.DB.GetInvoice(third); inv.Status != models.InvoiceOverdue {
+		t.Fatalf("INV-3 status = %s, want it left overdue", inv.Status)
+	}
+	if count, _, _ := h.DB.GetCarriedForwardTotals(customer.ID); count != 2 {
+		t.Fatalf("carried forward = %d, want 2", count)
+	}
+	var invoices int
+	h.DB.QueryRow(`SELECT COUNT(*) FROM invoices WHERE customer_id = ?`, customer.ID).Scan(&invoices)
+	if invoices != 3 {
+		t.Fatalf("%d invoices, want no new one", invoices)
+	}
+}
+
+func TestCarryForwardAmountLimitTerminates(t *testing.T) {
+	h := newTestHandler(t, nil)
+	h.Config.CarryForwardMaxInvoices = 0
+	h.Config.CarryForwardMaxAmount = 150000
+	customer := createTestCustomer(t, h, "C001", "")
+	vars := map[string]string{"id": fmt.Sprint(customer.ID)}
+
+	// The first 100000 is within the limit
+	createTestInvoice(t, h, customer.ID, "INV-1", time.Now().AddDate(0, -1, 0), models.InvoiceOverdue, 0)
+	h.DB.Exec(`UPDATE customers SET status = 'suspended' WHERE id = ?`, customer.ID)
+	if rec := serve(h.UnsuspendCustomerWithoutPayment, "POST", "", vars); rec.Code != 200 {
+		t.Fatalf("first unsuspend = %d %s", rec.Code, rec.Body)
+	}
+	if got, _ := h.DB.GetCustomer(customer.ID); got.Status != "active" {
+		t.Fatalf("customer status = %s, want active", got.Status)
+	}
+
+	// Another 100000 would bring it to 200000
+	createTestInvoice(t, h, customer.ID, "INV-2", time.Now().AddDate(0, 0, -5), models.InvoiceOverdue, 0)
+	h.DB.Exec(`UPDATE customers SET status = 'suspended' WHERE id = ?`, customer.ID)
+	if rec := serve(h.UnsuspendCustomerWithoutPayment, "POST", "", vars); rec.Code != 409 {
+		t.Fatalf("second unsuspend = %d %s, want 409", rec.Code, rec.Body)
+	}
+	if got, _ := h.DB.GetCustomer(customer.ID); got.Status != "terminated" {
+		t.Fatalf("customer status = %s, want terminated", got.Status)
+	}
+}
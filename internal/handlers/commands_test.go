@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go-acs/internal/middleware"
+	"go-acs/internal/models"
+
+	"github.com/gorilla/mux"
+)
+
+// serveAs calls a handler behind the auth middleware with a token for user
+func serveAs(h *Handler, user *models.User, handler http.HandlerFunc, method string, vars map[string]string) *httptest.ResponseRecorder {
+	token, _ := generateJWT(user, h.Config.JWTSecret)
+	r := httptest.NewRequest(method, "/api/devices", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	middleware.AuthMiddleware(h.Config.JWTSecret)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler(w, mux.SetURLVars(r, vars))
+	})).ServeHTTP(rec, r)
+	return rec
+}
+
+func deviceCommands(t *testing.T, h *Handler, deviceID int64) []*models.DeviceCommand {
+	t.Helper()
+	rec := serve(h.GetDeviceCommands, http.MethodGet, "", map[string]string{"id": fmt.Sprint(deviceID)})
+	var resp struct {
+		Data []*models.DeviceCommand `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode command history: %v", err)
+	}
+	return resp.Data
+}
+
+func TestManualRebootAppearsInCommandHistory(t *testing.T) {
+	h := newTestHandler(t, nil)
+	device := createTestDevice(t, h, "SN-CMD", "ZTE")
+	admin := &models.User{ID: 7, Username: "siti", Role: middleware.RoleOperator}
+
+	rec := serveAs(h, admin, h.RebootDevice, http.MethodPost, map[string]string{"id": fmt.Sprint(device.ID)})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("reboot = %d: %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		TaskID int64 `json:"taskId"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+
+	history := deviceCommands(t, h, device.ID)
+	if len(history) != 1 {
+		t.Fatalf("%d history entries, want 1", len(history))
+	}
+	cmd := history[0]
+	if cmd.Command != "reboot" || cmd.Username != "siti" || cmd.UserID == nil || *cmd.UserID != 7 {
+		t.Errorf("entry = %s by %q (user %v), want reboot by siti (7)", cmd.Command, cmd.Username, cmd.UserID)
+	}
+	if cmd.TaskID == nil || *cmd.TaskID != resp.TaskID {
+		t.Errorf("entry task = %v, want %d", cmd.TaskID, resp.TaskID)
+	}
+	if cmd.CreatedAt.IsZero() {
+		t.Error("entry has no timestamp")
+	}
+}
+
+func TestCommandHistoryIsNewestFirstAndPerDevice(t *testing.T) {
+	h := newTestHandler(t, nil)
+	device := createTestDevice(t, h, "SN-CMD", "ZTE")
+	other := createTestDevice(t, h, "SN-OTHER", "ZTE")
+	admin := &models.User{ID: 1, Username: "admin", Role: middleware.RoleAdmin}
+	vars := map[string]string{"id": fmt.Sprint(device.ID)}
+
+	serveAs(h, admin, h.RebootDevice, http.MethodPost, vars)
+	serveAs(h, admin, h.RefreshDevice, http.MethodPost, vars)
+	serveAs(h, admin, h.FactoryResetDevice, http.MethodPost, vars)
+	serveAs(h, admin, h.RebootDevice, http.MethodPost, map[string]string{"id": fmt.Sprint(other.ID)})
+
+	history := deviceCommands(t, h, device.ID)
+	var got []string
+	for _, cmd := range history {
+		got = append(got, cmd.Command)
+	}
+	if fmt.Sprint(got) != "[factory_reset refresh reboot]" {
+		t.Errorf("history = %v, want newest first for this device only", got)
+	}
+
+	// A request that reached the handler without a token is attributed to the system
+	serve(h.RebootDevice, http.MethodPost, "", vars)
+	if cmd := deviceCommands(t, h, device.ID)[0]; cmd.Username != "system" || cmd.UserID != nil {
+		t.Errorf("unauthenticated entry by %q (user %v), want system", cmd.Username, cmd.UserID)
+	}
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"go-acs/internal/models"
+)
+
+func TestCreateInvoiceTotalMatchesRoundedComponents(t *testing.T) {
+	h := newTestHandler(t, nil)
+	h.Config.CurrencyDecimals = 0
+	h.Config.AmountRounding = 100
+	customer := createTestCustomer(t, h, "C001", "")
+
+	// Rounding each component and the raw total separately would give
+	// 123500 + 13600 - 1200 = 135900 against a total of 135800
+	body := fmt.Sprintf(`{"customerId":%d,"subtotal":123456,"tax":13580.16,"discount":1234.5,"total":135801.66}`, customer.ID)
+	rec := serve(h.CreateInvoice, http.MethodPost, body, nil)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var invoice models.Invoice
+	json.Unmarshal(rec.Body.Bytes(), &invoice)
+	if invoice.Subtotal != 123500 || invoice.Tax != 13600 || invoice.Discount != 1200 {
+		t.Errorf("components = %v + %v - %v, want 123500 + 13600 - 1200", invoice.Subtotal, invoice.Tax, invoice.Discount)
+	}
+	if invoice.Total != invoice.Subtotal+invoice.Tax-invoice.Discount {
+		t.Errorf("total = %v, want %v", invoice.Total, invoice.Subtotal+invoice.Tax-invoice.Discount)
+	}
+
+	// An invoice given only as a total is rounded as is
+	rec = serve(h.CreateInvoice, http.MethodPost, fmt.Sprintf(`{"customerId":%d,"total":75049}`, customer.ID), nil)
+	json.Unmarshal(rec.Body.Bytes(), &invoice)
+	if rec.Code != http.StatusCreated || invoice.Total != 75000 {
+		t.Fatalf("total-only invoice = %d total %v, want 201 and 75000", rec.Code, invoice.Total)
+	}
+}
+
+func TestPriceInvoiceDecimalCurrency(t *testing.T) {
+	h := newTestHandler(t, nil)
+	h.Config.CurrencyDecimals = 2
+	h.Config.AmountRounding = 0
+	h.Config.TaxPercent = 7.25
+
+	invoice := &models.Invoice{Subtotal: 49.99}
+	h.priceInvoice(invoice, 10)
+	if invoice.Tax != 3.62 || invoice.Discount != 5 || invoice.Total != 48.61 {
+		t.Fatalf("priced = tax %v discount %v total %v, want 3.62, 5 and 48.61", invoice.Tax, invoice.Discount, invoice.Total)
+	}
+	if got := h.formatMoney(invoice.Total); got != h.Config.CurrencySymbol+" 48.61" {
+		t.Fatalf("formatted = %q", got)
+	}
+}
@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go-acs/internal/models"
+)
+
+func TestDecodeJSONRejectsInvalidBodies(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        string
+	}{
+		{"unknown field", "application/json", `{"name":"a","bogus":1}`, `Unknown field "bogus"`},
+		{"oversized body", "application/json", `{"name":"` + strings.Repeat("a", maxRequestBodyBytes) + `"}`, "must not exceed"},
+		{"wrong content type", "text/plain", `{"name":"a"}`, "Content-Type must be application/json"},
+		{"trailing data", "application/json", `{"name":"a"}{"name":"b"}`, "single JSON object"},
+		{"empty body", "application/json", ``, "must not be empty"},
+		{"wrong type", "application/json", `{"name":1}`, `field "name"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+			var dst struct {
+				Name string `json:"name"`
+			}
+			err := decodeJSON(httptest.NewRecorder(), r, &dst)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("err = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateEndpointsRejectUnknownAndOversizedBodies(t *testing.T) {
+	h := newTestHandler(t, nil)
+	customer := createTestCustomer(t, h, "CUST-0001", "")
+
+	rec := serve(h.CreatePayment, "POST", `{"customerId":1,"amount":1000,"paymentNo":"PAY-X"}`, nil)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "paymentNo") {
+		t.Fatalf("server-assigned field: %d %s", rec.Code, rec.Body.String())
+	}
+
+	big := `{"customerId":1,"subject":"x","description":"` + strings.Repeat("a", maxRequestBodyBytes) + `"}`
+	if rec := serve(h.CreateSupportTicket, "POST", big, nil); rec.Code != http.StatusBadRequest {
+		t.Fatalf("oversized ticket: %d", rec.Code)
+	}
+
+	payments, _, err := h.DB.GetPayments(&customer.ID, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(payments) != 0 {
+		t.Fatalf("rejected request stored %d payments", len(payments))
+	}
+}
+
+// The bundled pages send a few fields under their own names
+func TestUIPayloadsAreAccepted(t *testing.T) {
+	h := newTestHandler(t, nil)
+	customer := createTestCustomer(t, h, "CUST-0001", "")
+
+	t.Run("payment method", func(t *testing.T) {
+		body := `{"customerId":` + fmt.Sprint(customer.ID) + `,"amount":50000,"method":"cash"}`
+		rec := serve(h.CreatePayment, "POST", body, nil)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+		}
+		var p models.Payment
+		json.NewDecoder(rec.Body).Decode(&p)
+		if p.PaymentMethod != "cash" {
+			t.Fatalf("paymentMethod = %q, want cash", p.PaymentMethod)
+		}
+	})
+
+	t.Run("package speeds", func(t *testing.T) {
+		body := `{"name":"Home 20","price":150000,"download_speed":20,"upload_speed":5,"description":"x","isActive":true}`
+		rec := serve(h.CreatePackage, "POST", body, nil)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+		}
+		var p models.Package
+		json.NewDecoder(rec.Body).Decode(&p)
+		if p.DownloadSpeed != 20 || p.UploadSpeed != 5 {
+			t.Fatalf("speeds = %d/%d, want 20/5", p.DownloadSpeed, p.UploadSpeed)
+		}
+	})
+
+	t.Run("customer portal credentials", func(t *testing.T) {
+		body := fmt.Sprintf(`{"name":"Budi","phone":"0812","email":"","packageId":%d,"address":"","status":"active","portalUsername":"budi","portalPassword":"rahasia1"}`, customer.PackageID)
+		rec := serve(h.CreateCustomer, "POST", body, nil)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+		}
+		var c models.Customer
+		json.NewDecoder(rec.Body).Decode(&c)
+		stored, err := h.DB.GetCustomerByUsername("budi")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.Username != "budi" || !h.checkCustomerPassword(stored, "rahasia1") {
+			t.Fatalf("username %q, password set from portalPassword: %v", stored.Username, h.checkCustomerPassword(stored, "rahasia1"))
+		}
+
+		body = fmt.Sprintf(`{"name":"Budi","phone":"0812","email":"","packageId":%d,"address":"","status":"active","portalUsername":"budi2","portalPassword":""}`, customer.PackageID)
+		if rec := serve(h.UpdateCustomer, "PUT", body, map[string]string{"id": fmt.Sprint(c.ID)}); rec.Code != http.StatusOK {
+			t.Fatalf("update status %d: %s", rec.Code, rec.Body.String())
+		}
+		stored, err = h.DB.GetCustomerByUsername("budi2")
+		if err != nil {
+			t.Fatalf("renamed login: %v", err)
+		}
+		if stored.Username != "budi2" || !h.checkCustomerPassword(stored, "rahasia1") {
+			t.Fatalf("after update: username %q, password kept: %v", stored.Username, h.checkCustomerPassword(stored, "rahasia1"))
+		}
+	})
+
+	t.Run("ticket status only", func(t *testing.T) {
+		ticket, err := h.DB.CreateSupportTicket(&models.SupportTicket{CustomerID: customer.ID, Subject: "No internet", Description: "LOS red", Category: "technical", Priority: "high", Status: "open"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec := serve(h.UpdateSupportTicket, "PUT", `{"status":"in_progress"}`, map[string]string{"ticketId": fmt.Sprint(ticket.ID)})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+		}
+		got, _ := h.DB.GetSupportTicket(ticket.ID)
+		if got.Status != "in_progress" || got.Subject != "No internet" || got.Priority != "high" {
+			t.Fatalf("ticket = %+v", got)
+		}
+	})
+
+	t.Run("device edit keeps inform data", func(t *testing.T) {
+		device := createTestDevice(t, h, "ZTEG0001", "ZTE")
+		h.DB.Exec(`UPDATE devices SET status = 'online', ip_address = '10.0.0.2' WHERE id = ?`, device.ID)
+		rec := serve(h.UpdateDevice, "PUT", `{"notes":"rack 2"}`, map[string]string{"id": fmt.Sprint(device.ID)})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+		}
+		got, _ := h.DB.GetDevice(device.ID)
+		if got.Notes != "rack 2" || got.Status != models.StatusOnline || got.IPAddress != "10.0.0.2" || got.Manufacturer != "ZTE" {
+			t.Fatalf("device = %+v", got)
+		}
+	})
+}
+
+func TestOptionalBodiesAreDecodedStrictly(t *testing.T) {
+	h := newTestHandler(t, nil)
+	customer := createTestCustomer(t, h, "C001", "")
+	createTestInvoice(t, h, customer.ID, "INV-1", time.Now().AddDate(0, 0, -40), models.InvoiceOverdue, 0)
+	vars := map[string]string{"id": fmt.Sprint(customer.ID)}
+	status := func() string {
+		got, _ := h.DB.GetCustomer(customer.ID)
+		return got.Status
+	}
+
+	// A mistyped option is an error rather than a run with the defaults
+	if rec := serve(h.BatchIsolirOverdue, "POST", `{"days": 60}`, nil); rec.Code != http.StatusBadRequest {
+		t.Fatalf("batch isolir with an unknown field = %d %s, want 400", rec.Code, rec.Body)
+	}
+	if s := status(); s != "active" {
+		t.Fatalf("status = %s after a rejected batch isolir, want active", s)
+	}
+	if rec := serve(h.BatchIsolirOverdue, "POST", "", nil); rec.Code != http.StatusOK {
+		t.Fatalf("batch isolir without a body = %d %s", rec.Code, rec.Body)
+	}
+	if s := status(); s != "suspended" {
+		t.Fatalf("status = %s, want suspended", s)
+	}
+
+	if rec := serve(h.UnsuspendCustomer, "POST", `{"profil": "default"}`, vars); rec.Code != http.StatusBadRequest {
+		t.Fatalf("unsuspend with an unknown field = %d %s, want 400", rec.Code, rec.Body)
+	}
+	if s := status(); s != "suspended" {
+		t.Fatalf("status = %s after a rejected unsuspend, want suspended", s)
+	}
+	if rec := serve(h.UnsuspendCustomer, "POST", "", vars); rec.Code != http.StatusOK {
+		t.Fatalf("unsuspend without a body = %d %s", rec.Code, rec.Body)
+	}
+	if s := status(); s != "active" {
+		t.Errorf("status = %s, want active", s)
+	}
+}
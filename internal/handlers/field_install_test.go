@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestFieldInstallCombinedFlow(t *testing.T) {
+	h := newTestHandler(t, nil)
+	device := createTestDevice(t, h, "ZTEG1234", "ZTE")
+	customer := createTestCustomer(t, h, "C001", "")
+
+	rec := serve(h.FieldInstall, "POST", `{"serialNumber": "ZTEG1234", "customerId": 1, "ssid": "Rumah",
+		"wifiPassword": "rahasia123", "latitude": -6.2, "longitude": 106.8, "address": "Jl. Mawar 1"}`, nil)
+	if rec.Code != 200 {
+		t.Fatalf("FieldInstall = %d %s", rec.Code, rec.Body)
+	}
+	var res struct {
+		Success bool
+		Steps   []struct {
+			Step    string
+			Success bool
+			Skipped bool
+		}
+	}
+	json.Unmarshal(rec.Body.Bytes(), &res)
+	steps := map[string]bool{}
+	for _, s := range res.Steps {
+		steps[s.Step] = s.Success && !s.Skipped
+	}
+	for _, step := range []string{"lookup", "wifi", "customer", "location"} {
+		if !steps[step] {
+			t.Errorf("step %s not reported as done: %s", step, rec.Body)
+		}
+	}
+
+	got, _ := h.DB.GetDevice(device.ID)
+	if got.CustomerID == nil || *got.CustomerID != customer.ID {
+		t.Errorf("device not assigned to the customer")
+	}
+	if got.Latitude != -6.2 || got.Longitude != 106.8 {
+		t.Errorf("location = %v,%v", got.Latitude, got.Longitude)
+	}
+	tasks, _ := h.DB.GetPendingTasks(device.ID)
+	if len(tasks) != 1 {
+		t.Fatalf("%d pending task(s), want the WiFi task", len(tasks))
+	}
+	var params map[string]string
+	json.Unmarshal(tasks[0].Parameters, &params)
+	if params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.SSID"] != "Rumah" ||
+		params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.KeyPassphrase"] != "rahasia123" {
+		t.Errorf("WiFi task parameters = %v", params)
+	}
+}
+
+func TestFieldInstallValidatesBeforeWriting(t *testing.T) {
+	h := newTestHandler(t, nil)
+	device := createTestDevice(t, h, "ZTEG1234", "ZTE")
+	createTestCustomer(t, h, "C001", "")
+
+	for _, body := range []string{
+		`{"serialNumber": "ZTEG1234", "customerId": 1, "ssid": "Rumah", "wifiPassword": "short", "latitude": -6.2, "longitude": 106.8}`,
+		`{"serialNumber": "ZTEG1234", "customerId": 99, "ssid": "Rumah", "latitude": -6.2, "longitude": 106.8}`,
+	} {
+		if rec := serve(h.FieldInstall, "POST", body, nil); rec.Code != 400 {
+			t.Errorf("FieldInstall(%s) = %d, want 400", body, rec.Code)
+		}
+	}
+
+	got, _ := h.DB.GetDevice(device.ID)
+	if got.CustomerID != nil || got.Latitude != 0 {
+		t.Error("a rejected install changed the device")
+	}
+	if tasks, _ := h.DB.GetPendingTasks(device.ID); len(tasks) != 0 {
+		t.Errorf("a rejected install queued %d task(s)", len(tasks))
+	}
+
+	if rec := serve(h.FieldInstall, "POST", `{"serialNumber": "UNKNOWN"}`, nil); rec.Code != 404 {
+		t.Errorf("unknown serial = %d, want 404", rec.Code)
+	}
+}
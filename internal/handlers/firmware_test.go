@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"go-acs/internal/models"
+)
+
+func downloadTasks(t *testing.T, h *Handler) int {
+	t.Helper()
+	var n int
+	if err := h.DB.QueryRow(`SELECT COUNT(*) FROM tasks WHERE type = ?`, models.TaskDownload).Scan(&n); err != nil {
+		t.Fatalf("count tasks: %v", err)
+	}
+	return n
+}
+
+func TestBulkUpgradeBatchesArePersisted(t *testing.T) {
+	h := newTestHandler(t, nil)
+	for _, sn := range []string{"SN1", "SN2", "SN3"} {
+		createTestDevice(t, h, sn, "ZTE")
+	}
+	createTestDevice(t, h, "SN4", "Huawei")
+
+	body := `{"manufacturer":"zte","url":"http://fw/zte.bin","batchSize":2,"delaySeconds":60}`
+	rec := serve(h.BulkUpgradeFirmware, http.MethodPost, body, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		BatchID   string `json:"batchId"`
+		Matched   int    `json:"matched"`
+		Queued    int    `json:"queued"`
+		Scheduled int    `json:"scheduled"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if resp.Matched != 3 || resp.Queued != 2 || resp.Scheduled != 1 {
+		t.Fatalf("response = %+v, want 3 matched, 2 queued, 1 scheduled", resp)
+	}
+
+	// Nothing is due before the delay; afterwards the stored batch is queued
+	// without any state from the original request
+	h.ProcessFirmwareUpgrades(time.Now())
+	if n := downloadTasks(t, h); n != 2 {
+		t.Fatalf("download tasks before delay = %d, want 2", n)
+	}
+	h.ProcessFirmwareUpgrades(time.Now().Add(2 * time.Minute))
+	if n := downloadTasks(t, h); n != 3 {
+		t.Fatalf("download tasks after delay = %d, want 3", n)
+	}
+	h.ProcessFirmwareUpgrades(time.Now().Add(10 * time.Minute))
+	if n := downloadTasks(t, h); n != 3 {
+		t.Errorf("download tasks after the last batch = %d, want 3", n)
+	}
+
+	due, err := h.DB.GetDueFirmwareUpgrades(time.Now().Add(time.Hour))
+	if err != nil || len(due) != 0 {
+		t.Errorf("due upgrades = %d (%v), want none", len(due), err)
+	}
+}
+
+func TestFirmwareAuditVersionDistribution(t *testing.T) {
+	h := newTestHandler(t, nil)
+	for _, f := range []models.Firmware{
+		{Manufacturer: "ZTE", Version: "1.0.5", URL: "http://fw/zte.bin"},
+		{Manufacturer: "ZTE", ProductClass: "F670L", Version: "2.1.0", URL: "http://fw/f670l.bin"},
+		{Manufacturer: "ZTE", ProductClass: "F670L", Version: "2.0.10", URL: "http://fw/f670l-old.bin"},
+	} {
+		if _, err := h.DB.CreateFirmware(&f); err != nil {
+			t.Fatalf("CreateFirmware: %v", err)
+		}
+	}
+	for i, d := range []struct{ manufacturer, class, version string }{
+		{"ZTE", "F670L", "2.0.9"},
+		{"ZTE", "F670L", "2.0.9"},
+		{"ZTE", "F670L", "2.0.10"},
+		{"ZTE", "F670L", "2.1.0"},
+		{"ZTE", "F609", "1.0.4"},
+		{"ZTE", "F609", "1.0.5"},
+		{"Huawei", "HG8245H", "3.0"},
+	} {
+		if _, err := h.DB.CreateDevice(&models.Device{SerialNumber: fmt.Sprintf("SN%d", i), Manufacturer: d.manufacturer, ProductClass: d.class, SoftwareVersion: d.version}); err != nil {
+			t.Fatalf("CreateDevice: %v", err)
+		}
+	}
+	retired := createTestDevice(t, h, "SN-RETIRED", "ZTE")
+	if _, err := h.DB.Exec(`UPDATE devices SET product_class = 'F670L', software_version = '1.0.0', lifecycle_state = 'retired' WHERE id = ?`, retired.ID); err != nil {
+		t.Fatalf("retire device: %v", err)
+	}
+
+	rec := serve(h.GetFirmwareAudit, http.MethodGet, "", nil)
+	var audit models.FirmwareAudit
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &audit) != nil {
+		t.Fatalf("firmware audit = %d %s", rec.Code, rec.Body)
+	}
+	if audit.TotalDevices != 7 || audit.OutdatedDevices != 4 {
+		t.Errorf("total %d outdated %d, want 7 and 4", audit.TotalDevices, audit.OutdatedDevices)
+	}
+
+	want := []struct {
+		class    string
+		latest   string
+		devices  int64
+		outdated int64
+		versions []models.FirmwareAuditVersion
+	}{
+		{"F670L", "2.1.0", 4, 3, []models.FirmwareAuditVersion{
+			{Version: "2.1.0", Devices: 1},
+			{Version: "2.0.10", Devices: 1, Outdated: true},
+			{Version: "2.0.9", Devices: 2, Outdated: true},
+		}},
+		{"F609", "1.0.5", 2, 1, []models.FirmwareAuditVersion{
+			{Version: "1.0.5", Devices: 1},
+			{Version: "1.0.4", Devices: 1, Outdated: true},
+		}},
+		{"HG8245H", "", 1, 0, []models.FirmwareAuditVersion{
+			{Version: "3.0", Devices: 1},
+		}},
+	}
+	if len(audit.Models) != len(want) {
+		t.Fatalf("got %d models, want %d: %+v", len(audit.Models), len(want), audit.Models)
+	}
+	for i, w := range want {
+		m := audit.Models[i]
+		if m.ProductClass != w.class || m.LatestVersion != w.latest || m.Devices != w.devices || m.OutdatedDevices != w.outdated {
+			t.Errorf("model %d = %s latest %q devices %d outdated %d, want %s latest %q devices %d outdated %d",
+				i, m.ProductClass, m.LatestVersion, m.Devices, m.OutdatedDevices, w.class, w.latest, w.devices, w.outdated)
+			continue
+		}
+		if fmt.Sprint(m.Versions) != fmt.Sprint(w.versions) {
+			t.Errorf("%s versions = %+v, want %+v", w.class, m.Versions, w.versions)
+		}
+	}
+}
@@ -2,15 +2,25 @@ package handlers
 
 import (
 	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
+	"io"
 	"math/big"
+	"net"
 	"net/http"
+	"net/url"
 	"os/exec"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"go-acs/internal/config"
@@ -19,11 +29,14 @@ import (
 	"go-acs/internal/websocket"
 
 	"go-acs/internal/mailer"
+	"go-acs/internal/middleware"
 	"go-acs/internal/mikrotik"
+	"go-acs/internal/notification"
 	"go-acs/internal/notification/fcm"
 	"go-acs/internal/notification/telegram"
 	"go-acs/internal/notification/whatsapp"
 	"go-acs/internal/payment"
+	"go-acs/internal/tr069"
 
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/gorilla/mux"
@@ -41,26 +54,195 @@ type Handler struct {
 	FCM      *fcm.Client
 	Telegram *telegram.Client
 	Config   *config.Config
+	ACS      *tr069.Server
 	tmpl     *template.Template
+
+	resetMu    sync.Mutex
+	resetCodes map[int64]*portalResetCode  // customer ID -> pending forgot-password code
+	resetFails map[int64]*portalResetFails // customer ID -> failed code entries
+
+	// Bounded workers delivering outbox notifications, so bulk jobs can't
+	// flood a provider
+	emailPool *notification.Pool
+	waPool    *notification.Pool
+	pushPool  *notification.Pool
+
+	// Per-provider circuit breakers for the notification outbox, by channel
+	breakers map[string]*notification.Breaker
+
+	// Rate-limited router stats for the dashboard
+	mikrotikResources *mikrotik.ResourceCache
+
+	// When MAX_TASK_RETRIES was last switched on (unix nanoseconds); only
+	// failures after it are requeued automatically
+	taskRetriesSince atomic.Int64
+}
+
+// notifyQueueSize is how many sends per channel may wait for a free worker
+const notifyQueueSize = 256
+
+// portalResetCode is a verification code sent for self-service password reset
+type portalResetCode struct {
+	Code      string
+	Channel   string // whatsapp or email
+	ExpiresAt time.Time
+	SentAt    time.Time
+	Attempts  int
 }
 
+// portalResetFails counts wrong verification codes for a customer across
+// codes, so requesting a fresh code doesn't reset the limit
+type portalResetFails struct {
+	Count       int
+	WindowStart time.Time
+}
+
+const (
+	resetCodeTTL         = 15 * time.Minute
+	resetCodeCooldown    = time.Minute
+	resetCodeMaxAttempts = 5
+	resetMaxFailures     = 10 // wrong codes per customer within resetFailWindow
+	resetFailWindow      = time.Hour
+)
+
 // NewHandler creates a new Handler
-func NewHandler(db *database.DB, wsHub *websocket.Hub, m *mailer.Mailer, mt *mikrotik.Client, pg payment.Gateway, wa *whatsapp.Client, fcmClient *fcm.Client, tg *telegram.Client, cfg *config.Config) *Handler {
+func NewHandler(db *database.DB, wsHub *websocket.Hub, m *mailer.Mailer, mt *mikrotik.Client, pg payment.Gateway, wa *whatsapp.Client, fcmClient *fcm.Client, tg *telegram.Client, cfg *config.Config, acs *tr069.Server) *Handler {
+	h := &Handler{
+		DB:         db,
+		WSHub:      wsHub,
+		Mailer:     m,
+		Mikrotik:   mt,
+		Payment:    pg,
+		WA:         wa,
+		FCM:        fcmClient,
+		Telegram:   tg,
+		Config:     cfg,
+		ACS:        acs,
+		resetCodes: make(map[int64]*portalResetCode),
+		resetFails: make(map[int64]*portalResetFails),
+	}
+
+	emailWorkers, waWorkers, pushWorkers := 5, 2, 10
+	if cfg != nil {
+		emailWorkers, waWorkers, pushWorkers = cfg.NotifyEmailConcurrency, cfg.NotifyWAConcurrency, cfg.NotifyPushConcurrency
+	}
+	h.emailPool = notification.NewPool(emailWorkers, notifyQueueSize)
+	h.waPool = notification.NewPool(waWorkers, notifyQueueSize)
+	h.pushPool = notification.NewPool(pushWorkers, notifyQueueSize)
+
+	threshold, cooldown := 5, time.Minute
+	if cfg != nil {
+		threshold, cooldown = cfg.NotifyBreakerThreshold, time.Duration(cfg.NotifyBreakerCooldown)*time.Second
+	}
+	h.breakers = map[string]*notification.Breaker{
+		channelEmail:    notification.NewBreaker(threshold, cooldown),
+		channelWhatsApp: notification.NewBreaker(threshold, cooldown),
+		channelFCM:      notification.NewBreaker(threshold, cooldown),
+	}
+	h.resetMikrotikResources()
+	if cfg != nil && cfg.MaxTaskRetries > 0 {
+		h.taskRetriesSince.Store(time.Now().UnixNano())
+	}
+
 	// Parse all templates
-	tmpl := template.Must(template.ParseGlob("web/templates/*.html"))
+	h.tmpl = template.Must(template.New("").Funcs(template.FuncMap{
+		"money": h.formatMoney,
+	}).ParseGlob("web/templates/*.html"))
+
+	return h
+}
+
+// currency returns the configured currency, defaulting to whole Rupiah
+func (h *Handler) currency() models.Currency {
+	if h.Config == nil {
+		return models.Currency{Symbol: "Rp", Rounding: 1}
+	}
+	return h.Config.Currency()
+}
+
+// formatMoney formats an amount for notifications and printable pages
+func (h *Handler) formatMoney(amount float64) string {
+	return h.currency().Format(amount)
+}
+
+// subscriptionItem builds the package line item for the period starting at
+// periodStart. With proration enabled, a customer who joined during that month
+// is billed only for the remaining days.
+func (h *Handler) subscriptionItem(pkg *models.Package, joinDate, periodStart time.Time) (models.InvoiceItem, bool) {
+	item := models.InvoiceItem{
+		Description: fmt.Sprintf("Monthly subscription - %s", pkg.Name),
+		Quantity:    1,
+		UnitPrice:   pkg.Price,
+		Amount:      pkg.Price,
+	}
+	if h.Config == nil || !h.Config.ProrationEnabled {
+		return item, false
+	}
+	amount, days, daysInMonth, prorated := models.ProrateFirstMonth(pkg.Price, joinDate, periodStart)
+	if !prorated {
+		return item, false
+	}
+	amount = h.currency().Round(amount)
+	item.Description = fmt.Sprintf("%s (prorated %d/%d days from %s)", pkg.Name, days, daysInMonth, joinDate.Format("02/01/2006"))
+	item.UnitPrice = amount
+	item.Amount = amount
+	return item, true
+}
+
+// joinMonthItem bills the partial month a customer joined in when the run
+// for the following month is their first invoice. The scheduled run starts on
+// the 1st, so without it a customer who joined on the 20th would never be
+// billed for those days.
+func (h *Handler) joinMonthItem(pkg *models.Package, customer *models.Customer, periodStart time.Time) (models.InvoiceItem, bool) {
+	if h.Config == nil || !h.Config.ProrationEnabled {
+		return models.InvoiceItem{}, false
+	}
+	if _, total, err := h.DB.GetInvoices(&customer.ID, "", 1, 0); err != nil || total > 0 {
+		return models.InvoiceItem{}, false
+	}
+	return h.subscriptionItem(pkg, customer.JoinDate, periodStart.AddDate(0, -1, 0))
+}
+
+// roundInvoice rounds the invoice components to the configured currency step
+// and derives the total from them, so Total = Subtotal + Tax - Discount holds
+// after rounding. An invoice given only as a total keeps its rounded total.
+func (h *Handler) roundInvoice(invoice *models.Invoice) {
+	c := h.currency()
+	if invoice.Subtotal == 0 && invoice.Tax == 0 && invoice.Discount == 0 {
+		invoice.Total = c.Round(invoice.Total)
+		return
+	}
+	invoice.Subtotal = c.Round(invoice.Subtotal)
+	invoice.Tax = c.Round(invoice.Tax)
+	invoice.Discount = c.Round(invoice.Discount)
+	// Rounding the sum only removes float noise; the components are already on the step
+	invoice.Total = c.Round(invoice.Subtotal + invoice.Tax - invoice.Discount)
+}
+
+// priceInvoice adds the configured tax and takes discountPercent off the
+// invoice subtotal, both rounded, so that Total = Subtotal + Tax - Discount
+func (h *Handler) priceInvoice(invoice *models.Invoice, discountPercent float64) {
+	taxPercent := 0.0
+	if h.Config != nil {
+		taxPercent = h.Config.TaxPercent
+	}
+	c := h.currency()
+	invoice.Subtotal = c.Round(invoice.Subtotal)
+	invoice.Tax = c.Round(invoice.Subtotal * taxPercent / 100)
+	invoice.Discount = c.Round(invoice.Subtotal * discountPercent / 100)
+	invoice.Total = invoice.Subtotal + invoice.Tax - invoice.Discount
+}
 
-	return &Handler{
-		DB:       db,
-		WSHub:    wsHub,
-		Mailer:   m,
-		Mikrotik: mt,
-		Payment:  pg,
-		WA:       wa,
-		FCM:      fcmClient,
-		Telegram: tg,
-		Config:   cfg,
-		tmpl:     tmpl,
+// invoiceDiscountPercent returns the discount for a customer's invoices: the
+// customer's own discount if set, otherwise the package's
+func (h *Handler) invoiceDiscountPercent(customerID int64, pkg *models.Package) float64 {
+	if pct := h.DB.CustomerDiscountPercent(customerID); pct > 0 {
+		return pct
+	}
+	if pkg != nil {
+		return pkg.DiscountPercent
 	}
+	return 0
 }
 
 // ============== Page Handlers ==============
@@ -154,8 +336,8 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 		Password string `json:"password"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid request")
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
@@ -201,6 +383,27 @@ func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
 
 // ============== Dashboard Handlers ==============
 
+// Search runs one query across devices, customers and invoices for the
+// support search box. limit caps the matches per category (default 10, max 50).
+func (h *Handler) Search(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if len(q) < 2 {
+		respondError(w, http.StatusBadRequest, "q must be at least 2 characters")
+		return
+	}
+	limit := getQueryInt(r, "limit", 10)
+	if limit <= 0 || limit > 50 {
+		limit = 10
+	}
+
+	results, err := h.DB.Search(q, limit)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Search failed")
+		return
+	}
+	respondJSON(w, http.StatusOK, results)
+}
+
 // GetDashboardStats returns dashboard statistics
 func (h *Handler) GetDashboardStats(w http.ResponseWriter, r *http.Request) {
 	stats, err := h.DB.GetDashboardStats()
@@ -221,10 +424,12 @@ func (h *Handler) GetDashboardStats(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) GetDevices(w http.ResponseWriter, r *http.Request) {
 	status := r.URL.Query().Get("status")
 	search := r.URL.Query().Get("search")
+	lifecycle := r.URL.Query().Get("lifecycle")
+	tag := r.URL.Query().Get("tag")
 	limit := getQueryInt(r, "limit", 50)
 	offset := getQueryInt(r, "offset", 0)
 
-	devices, total, err := h.DB.GetDevices(status, search, limit, offset)
+	devices, total, err := h.DB.GetDevices(status, search, lifecycle, tag, limit, offset)
 	if err != nil {
 		respondError(w, http.StatusInternalServerError, "Failed to get devices")
 		return
@@ -294,10 +499,38 @@ func (h *Handler) GetDevices(w http.ResponseWriter, r *http.Request) {
 
 // CreateDevice creates a new device
 func (h *Handler) CreateDevice(w http.ResponseWriter, r *http.Request) {
-	var device models.Device
-	if err := json.NewDecoder(r.Body).Decode(&device); err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid request body")
-		return
+	var req struct {
+		SerialNumber      string                `json:"serialNumber"`
+		OUI               string                `json:"oui"`
+		ProductClass      string                `json:"productClass"`
+		Manufacturer      string                `json:"manufacturer"`
+		ModelName         string                `json:"modelName"`
+		HardwareVersion   string                `json:"hardwareVersion"`
+		SoftwareVersion   string                `json:"softwareVersion"`
+		ConnectionRequest string                `json:"connectionRequestUrl"`
+		Template          string                `json:"template"`
+		Tags              []string              `json:"tags"`
+		Notes             string                `json:"notes"`
+		LifecycleState    models.LifecycleState `json:"lifecycleState"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	device := models.Device{
+		SerialNumber:      req.SerialNumber,
+		OUI:               req.OUI,
+		ProductClass:      req.ProductClass,
+		Manufacturer:      req.Manufacturer,
+		ModelName:         req.ModelName,
+		HardwareVersion:   req.HardwareVersion,
+		SoftwareVersion:   req.SoftwareVersion,
+		ConnectionRequest: req.ConnectionRequest,
+		Template:          req.Template,
+		Tags:              req.Tags,
+		Notes:             req.Notes,
+		LifecycleState:    req.LifecycleState,
 	}
 
 	if device.SerialNumber == "" {
@@ -305,6 +538,13 @@ func (h *Handler) CreateDevice(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	switch device.LifecycleState {
+	case "", models.LifecycleStock, models.LifecycleDeployed:
+	default:
+		respondError(w, http.StatusBadRequest, "lifecycleState must be stock or deployed")
+		return
+	}
+
 	device.Status = models.StatusOffline
 	created, err := h.DB.CreateDevice(&device)
 	if err != nil {
@@ -381,15 +621,96 @@ func (h *Handler) GetDevicePON(w http.ResponseWriter, r *http.Request) {
 	params, _ := h.DB.GetDeviceParameters(id, "")
 	device, _ := h.DB.GetDevice(id)
 
-	pon := models.PONStats{
-		RXPower: -18.5, // default fallback
-		TXPower: 2.3,
-		PONMode: "Ethernet", // default
+	pon, measured := readPONStats(device, params)
+	if !measured {
+		pon.RXPower = -18.5 // default fallback
+	}
+	if pon.TXPower == 0 {
+		pon.TXPower = 2.3
+	}
+	if pon.PONMode == "" {
+		pon.PONMode = "Ethernet" // default
+	}
+
+	pon.SignalQuality = models.SignalUnknown
+	if measured && h.Config != nil {
+		pon.SignalQuality = models.ClassifyRXPower(pon.RXPower, h.Config.RXThresholds())
+	}
+
+	respondJSON(w, http.StatusOK, pon)
+}
+
+// GetDeviceOpticalHistory returns the device's optical samples of the last
+// ?hours= (default 24, max 30 days) for drawing signal trend graphs
+func (h *Handler) GetDeviceOpticalHistory(w http.ResponseWriter, r *http.Request) {
+	id := getPathInt64(r, "id")
+	hours := getQueryInt(r, "hours", 24)
+	if hours < 1 || hours > 30*24 {
+		hours = 24
+	}
+
+	samples, err := h.DB.GetOpticalHistory(id, hours)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch optical history")
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"hours":   hours,
+		"data":    samples,
+	})
+}
+
+// RecordOpticalSamples snapshots the optical values of every online device
+// that reports RX power, and drops samples past the retention period. Run by
+// the scheduler every poll cycle.
+func (h *Handler) RecordOpticalSamples() {
+	devices, _, err := h.DB.GetDevices(string(models.StatusOnline), "", "", "", 100000, 0)
+	if err != nil {
+		fmt.Printf("[OPTICAL] Error fetching devices: %v\n", err)
+		return
+	}
+
+	recorded := 0
+	for _, device := range devices {
+		params, err := h.DB.GetDeviceParameters(device.ID, "")
+		if err != nil {
+			continue
+		}
+		pon, measured := readPONStats(device, params)
+		if !measured {
+			continue
+		}
+		sample := &models.OpticalSample{
+			RXPower:     pon.RXPower,
+			TXPower:     pon.TXPower,
+			Temperature: pon.Temperature,
+			Voltage:     pon.Voltage,
+			BiasCurrent: pon.BiasCurrent,
+		}
+		if err := h.DB.RecordOpticalSample(device.ID, sample); err != nil {
+			fmt.Printf("[OPTICAL] Failed to record sample for %s: %v\n", device.SerialNumber, err)
+			continue
+		}
+		recorded++
+	}
+
+	if _, err := h.DB.PruneOpticalHistory(); err != nil {
+		fmt.Printf("[OPTICAL] Failed to prune optical history: %v\n", err)
 	}
+	if recorded > 0 {
+		fmt.Printf("[OPTICAL] Recorded %d optical samples\n", recorded)
+	}
+}
 
+// readPONStats extracts the optical values of a device from its stored
+// parameters. measured is false when the device never reported RX power;
+// values that were not reported are left zero.
+func readPONStats(device *models.Device, params []*models.DeviceParameter) (pon models.PONStats, measured bool) {
 	if device != nil {
 		if device.RXPower != 0 {
 			pon.RXPower = device.RXPower
+			measured = true
 		}
 		// Use temperature from device if available
 		if device.Temperature != 0 {
@@ -447,7 +768,156 @@ func (h *Handler) GetDevicePON(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	respondJSON(w, http.StatusOK, pon)
+	return pon, measured
+}
+
+// lowSignalMessages renders the customer and operator low optical signal
+// messages. A message is empty when its template is disabled, and the
+// customer message also when the device has no customer with a phone number.
+func (h *Handler) lowSignalMessages(device *models.Device, customer *models.Customer, previousRX float64, level string) (customerMsg, operatorMsg string) {
+	vars := map[string]string{
+		"name":      "-",
+		"serial":    device.SerialNumber,
+		"device":    h.DB.DeviceAlias(device.ID, h.Config.DeviceAliasFormat),
+		"level":     level,
+		"rx":        fmt.Sprintf("%.2f", device.RXPower),
+		"previous":  fmt.Sprintf("%.2f", previousRX),
+		"threshold": fmt.Sprintf("%.2f", h.opticalAlertThreshold(level)),
+	}
+	if customer != nil {
+		vars["name"] = customer.Name
+		if customer.Phone != "" && h.Config.OpticalAlertCustomerMsg != "" {
+			customerMsg = notification.Render(h.Config.OpticalAlertCustomerMsg, vars)
+		}
+	}
+	if h.Config.OpticalAlertOperatorMsg != "" {
+		operatorMsg = notification.Render(h.Config.OpticalAlertOperatorMsg, vars)
+	}
+	return customerMsg, operatorMsg
+}
+
+// opticalAlertThreshold returns the RX threshold (dBm) of an alert level: the
+// lower bound of the band the device dropped out of
+func (h *Handler) opticalAlertThreshold(level string) float64 {
+	if level == models.SignalCritical {
+		return h.Config.RXWarningDBm
+	}
+	return h.Config.RXGoodDBm
+}
+
+// NotifyDeviceStatus pushes a device status transition (e.g. offline ->
+// online) to the live dashboard along with the new online count. Set as
+// DB.OnStatusChange; the hub broadcast never blocks the caller.
+func (h *Handler) NotifyDeviceStatus(deviceID int64, previous, current models.DeviceStatus) {
+	if h.WSHub == nil {
+		return
+	}
+	data := map[string]interface{}{
+		"status":         current,
+		"previousStatus": previous,
+	}
+	if total, online, err := h.DB.CountDeployedDevices(); err == nil {
+		data["totalDevices"] = total
+		data["onlineDevices"] = online
+		data["offlineDevices"] = total - online
+	}
+	h.WSHub.Broadcast(websocket.Message{
+		Type:     "device_status",
+		DeviceID: deviceID,
+		Data:     data,
+	})
+}
+
+// NotifyOpticalAlert logs a change of a device's optical alert level and
+// pushes it to the live feed. When the signal got worse the operators are told
+// via Telegram, and the customer via WhatsApp the first time it leaves the
+// normal band. Called by the TR-069 server, which applies hysteresis so a
+// reading hovering around a threshold doesn't repeat the alert.
+func (h *Handler) NotifyOpticalAlert(device *models.Device, previousRX float64, previousLevel, level string) {
+	if h.WSHub != nil {
+		h.WSHub.Broadcast(websocket.Message{
+			Type:     "optical_alert",
+			DeviceID: device.ID,
+			Data: map[string]interface{}{
+				"level":         level,
+				"previousLevel": previousLevel,
+				"rxPower":       device.RXPower,
+				"previousRx":    previousRX,
+			},
+		})
+	}
+
+	if !models.OpticalAlertWorsened(previousLevel, level) {
+		now := level
+		if now == "" {
+			now = "normal"
+		}
+		h.DB.CreateLog(&device.ID, "info", "optical",
+			fmt.Sprintf("Optical signal recovered: RX %.2f dBm", device.RXPower),
+			fmt.Sprintf("alert level %s -> %s", previousLevel, now))
+		return
+	}
+
+	var customer *models.Customer
+	if device.CustomerID != nil && *device.CustomerID > 0 {
+		customer, _ = h.DB.GetCustomer(*device.CustomerID)
+	}
+
+	logLevel := "warning"
+	if level == models.SignalCritical {
+		logLevel = "error"
+	}
+	h.DB.CreateLog(&device.ID, logLevel, "optical",
+		fmt.Sprintf("Low optical signal (%s): RX %.2f dBm (threshold %.2f dBm)", level, device.RXPower, h.opticalAlertThreshold(level)),
+		fmt.Sprintf("previous %.2f dBm", previousRX))
+
+	customerMsg, operatorMsg := h.lowSignalMessages(device, customer, previousRX, level)
+	if customerMsg != "" && previousLevel == "" && h.WA != nil {
+		h.queueNotification(channelWhatsApp, customer.Phone, "", customerMsg)
+	}
+	if operatorMsg != "" && h.Telegram != nil {
+		if err := h.Telegram.SendMessage(operatorMsg); err != nil {
+			fmt.Printf("[OPTICAL] Telegram alert for %s failed: %v\n", device.SerialNumber, err)
+		}
+	}
+}
+
+// NotifyClientLimit reports a change of a device's WiFi client alert level:
+// near or at the configured maximum suggests an upsell or a shared
+// connection. Set as the TR-069 server's OnClientLimitChange callback.
+func (h *Handler) NotifyClientLimit(device *models.Device, clients, limit int, previousLevel, level string) {
+	if h.WSHub != nil {
+		h.WSHub.Broadcast(websocket.Message{
+			Type:     "client_limit",
+			DeviceID: device.ID,
+			Data: map[string]interface{}{
+				"level":         level,
+				"previousLevel": previousLevel,
+				"clients":       clients,
+				"maxClients":    limit,
+			},
+		})
+	}
+
+	if !models.ClientLimitWorsened(previousLevel, level) {
+		h.DB.CreateLog(&device.ID, "info", "wifi",
+			fmt.Sprintf("WiFi clients back under the alert threshold: %d of max %d", clients, limit), "")
+		return
+	}
+
+	msg := fmt.Sprintf("WiFi clients near the limit: %d of max %d", clients, limit)
+	if level == models.ClientLimitOver {
+		msg = fmt.Sprintf("WiFi client limit reached: %d of max %d", clients, limit)
+	}
+	h.DB.CreateLog(&device.ID, "warning", "wifi", msg, fmt.Sprintf("alert level %q -> %q", previousLevel, level))
+
+	if h.Telegram != nil {
+		text := fmt.Sprintf("📶 <b>%s</b>\nPerangkat: %s\nPelanggan perlu upgrade paket atau koneksi dipakai bersama?",
+			template.HTMLEscapeString(msg), template.HTMLEscapeString(h.DB.DeviceAlias(device.ID, h.Config.DeviceAliasFormat)))
+		if err := h.Telegram.SendMessage(text); err != nil {
+			fmt.Printf("[WIFI] Telegram alert for %s failed: %v\n", device.SerialNumber, err)
+		}
+	}
 }
 
 // GetDeviceWAN returns WAN connection information for a device
@@ -481,6 +951,9 @@ func (h *Handler) GetDeviceWAN(w http.ResponseWriter, r *http.Request) {
 		WANGateway        string            `json:"wanGateway"`
 		WANDNS1           string            `json:"wanDNS1"`
 		WANDNS2           string            `json:"wanDNS2"`
+		WANIPv6           string            `json:"wanIPv6"`
+		WANIPv6Prefix     string            `json:"wanIPv6Prefix"`
+		WANIPv6DNS        string            `json:"wanIPv6DNS"`
 		Parameters        map[string]string `json:"parameters"`
 		AllConnections    []WANConnection   `json:"allConnections"`
 	}{
@@ -575,6 +1048,20 @@ func (h *Handler) GetDeviceWAN(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
+		// Extract IPv6 information (dual-stack)
+		switch classifyIPv6Param(p.Path, p.Value) {
+		case "address":
+			if wanInfo.WANIPv6 == "" {
+				wanInfo.WANIPv6 = p.Value
+			}
+		case "prefix":
+			if wanInfo.WANIPv6Prefix == "" {
+				wanInfo.WANIPv6Prefix = p.Value
+			}
+		case "dns":
+			wanInfo.WANIPv6DNS = appendCSVUnique(wanInfo.WANIPv6DNS, p.Value)
+		}
+
 		// Extract other WAN information
 		if contains(p.Path, "DefaultGateway") || contains(p.Path, "RemoteIPAddress") {
 			if p.Value != "" && p.Value != "0.0.0.0" {
@@ -582,7 +1069,7 @@ func (h *Handler) GetDeviceWAN(w http.ResponseWriter, r *http.Request) {
 				wanInfo.PPPoEGateway = p.Value
 			}
 		}
-		if contains(p.Path, "DNSServers") || strings.HasSuffix(p.Path, ".DNS") {
+		if (contains(p.Path, "DNSServers") || strings.HasSuffix(p.Path, ".DNS")) && !contains(p.Path, "IPv6") {
 			if p.Value != "" {
 				wanInfo.PPPoEDNS = p.Value
 				// Also split for individual DNS
@@ -670,6 +1157,59 @@ type WANConnection struct {
 	LastError      string `json:"lastError"`
 	ConnectionType string `json:"connectionType"`
 	Enable         string `json:"enable"`
+	IPv6           string `json:"ipv6"`
+	IPv6Prefix     string `json:"ipv6Prefix"`
+	IPv6DNS        string `json:"ipv6DNS"`
+}
+
+// classifyIPv6Param reports whether a WAN parameter carries an IPv6 address,
+// a delegated prefix or IPv6 DNS servers ("address", "prefix", "dns"), or ""
+// otherwise. Link-local addresses are ignored.
+func classifyIPv6Param(path, value string) string {
+	if value == "" || !strings.Contains(path, "IPv6") && !strings.Contains(path, "PrefixDelegation") {
+		return ""
+	}
+	switch {
+	case strings.Contains(path, "DNS"):
+		return "dns"
+	case strings.Contains(path, "Prefix"):
+		if strings.HasSuffix(path, ".Prefix") || strings.HasSuffix(path, "Prefix") ||
+			strings.HasSuffix(path, "PrefixDelegationAddress") {
+			return "prefix"
+		}
+	case strings.HasSuffix(path, ".IPAddress") || strings.HasSuffix(path, "IPv6Address") ||
+		strings.HasSuffix(path, "IPv6IPAddress"):
+		ip := net.ParseIP(value)
+		if ip != nil && ip.To4() == nil && !ip.IsLinkLocalUnicast() {
+			return "address"
+		}
+	}
+	return ""
+}
+
+// appendCSVUnique appends comma separated values to list, skipping duplicates
+func appendCSVUnique(list, values string) string {
+	for _, v := range strings.Split(values, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		exists := false
+		for _, e := range strings.Split(list, ", ") {
+			if e == v {
+				exists = true
+				break
+			}
+		}
+		if exists {
+			continue
+		}
+		if list != "" {
+			list += ", "
+		}
+		list += v
+	}
+	return list
 }
 
 // extractConnectionPath extracts the base WAN connection path
@@ -698,6 +1238,17 @@ func updateConnectionField(conn *WANConnection, path, value string) {
 	if value == "" {
 		return
 	}
+	switch classifyIPv6Param(path, value) {
+	case "address":
+		conn.IPv6 = value
+		return
+	case "prefix":
+		conn.IPv6Prefix = value
+		return
+	case "dns":
+		conn.IPv6DNS = appendCSVUnique(conn.IPv6DNS, value)
+		return
+	}
 	switch {
 	case strings.HasSuffix(path, ".Name"):
 		conn.Name = value
@@ -764,64 +1315,52 @@ func formatPPPUptime(value string) string {
 func (h *Handler) GetDeviceClients(w http.ResponseWriter, r *http.Request) {
 	id := getPathInt64(r, "id")
 	params, _ := h.DB.GetDeviceParameters(id, "")
+	clients := models.ParseConnectedClients(params)
 
-	clientsMap := make(map[string]*models.ConnectedClient)
-
-	for _, p := range params {
-		if contains(p.Path, "Hosts.Host.") {
-			parts := strings.Split(p.Path, ".")
-			if len(parts) < 6 {
-				continue
-			}
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"clients":     clients,
+		"wifiClients": models.CountWiFiClients(clients),
+		"maxClients":  models.WiFiClientLimit(params),
+		"alertLevel":  h.DB.GetClientAlertLevel(id),
+	})
+}
 
-			// Find the index part (it's after 'Host')
-			var idx string
-			for i, part := range parts {
-				if part == "Host" && i+1 < len(parts) {
-					idx = parts[i+1]
-					break
-				}
-			}
-			if idx == "" {
-				continue
-			}
+// GetDeviceClientHistory returns the clients connected to a device at some
+// point between ?from= and ?to= (YYYY-MM-DD or RFC3339; default the last 7 days)
+func (h *Handler) GetDeviceClientHistory(w http.ResponseWriter, r *http.Request) {
+	id := getPathInt64(r, "id")
 
-			if clientsMap[idx] == nil {
-				clientsMap[idx] = &models.ConnectedClient{Active: true, Type: "other"}
-			}
-
-			lastPart := parts[len(parts)-1]
-			switch lastPart {
-			case "HostName":
-				clientsMap[idx].Name = p.Value
-			case "MACAddress":
-				clientsMap[idx].MAC = p.Value
-			case "IPAddress":
-				clientsMap[idx].IP = p.Value
-			case "Active":
-				clientsMap[idx].Active = p.Value == "1" || p.Value == "true"
-			case "InterfaceType":
-				clientsMap[idx].Interface = p.Value
-				if strings.Contains(p.Value, "802.11") || strings.Contains(p.Value, "Wireless") {
-					clientsMap[idx].Type = "phone"
-				} else {
-					clientsMap[idx].Type = "laptop"
-				}
-			}
-		}
+	from, err := parseQueryTime(r.URL.Query().Get("from"), false, h.Config.Loc())
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid 'from' date, use YYYY-MM-DD or RFC3339")
+		return
 	}
-
-	var clients []models.ConnectedClient
-	for _, c := range clientsMap {
-		if c.MAC != "" {
-			if c.Name == "" {
-				c.Name = "Unknown Device"
-			}
-			clients = append(clients, *c)
-		}
+	to, err := parseQueryTime(r.URL.Query().Get("to"), true, h.Config.Loc())
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid 'to' date, use YYYY-MM-DD or RFC3339")
+		return
+	}
+	if to == nil {
+		now := time.Now()
+		to = &now
+	}
+	if from == nil {
+		weekAgo := to.AddDate(0, 0, -7)
+		from = &weekAgo
+	}
+	if from.After(*to) {
+		respondError(w, http.StatusBadRequest, "'from' must be before 'to'")
+		return
 	}
 
+	clients, err := h.DB.GetClientHistory(id, *from, *to)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch client history")
+		return
+	}
 	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"from":    from,
+		"to":      to,
 		"clients": clients,
 	})
 }
@@ -834,14 +1373,50 @@ func (h *Handler) UpdateDevice(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var device models.Device
-	if err := json.NewDecoder(r.Body).Decode(&device); err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid request body")
+	// Only the fields present in the request are changed; status, inform
+	// data and parameters are owned by the device
+	var req struct {
+		OUI               *string   `json:"oui"`
+		ProductClass      *string   `json:"productClass"`
+		Manufacturer      *string   `json:"manufacturer"`
+		ModelName         *string   `json:"modelName"`
+		HardwareVersion   *string   `json:"hardwareVersion"`
+		SoftwareVersion   *string   `json:"softwareVersion"`
+		ConnectionRequest *string   `json:"connectionRequestUrl"`
+		Template          *string   `json:"template"`
+		Tags              *[]string `json:"tags"`
+		Notes             *string   `json:"notes"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	device, err := h.DB.GetDevice(id)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Device not found")
 		return
 	}
+	for dst, src := range map[*string]*string{
+		&device.OUI:               req.OUI,
+		&device.ProductClass:      req.ProductClass,
+		&device.Manufacturer:      req.Manufacturer,
+		&device.ModelName:         req.ModelName,
+		&device.HardwareVersion:   req.HardwareVersion,
+		&device.SoftwareVersion:   req.SoftwareVersion,
+		&device.ConnectionRequest: req.ConnectionRequest,
+		&device.Template:          req.Template,
+		&device.Notes:             req.Notes,
+	} {
+		if src != nil {
+			*dst = *src
+		}
+	}
+	if req.Tags != nil {
+		device.Tags = *req.Tags
+	}
 
-	device.ID = id
-	if err := h.DB.UpdateDevice(&device); err != nil {
+	if err := h.DB.UpdateDevice(device); err != nil {
 		respondError(w, http.StatusInternalServerError, "Failed to update device")
 		return
 	}
@@ -904,6 +1479,7 @@ func (h *Handler) RebootDevice(w http.ResponseWriter, r *http.Request) {
 	}
 
 	h.DB.CreateLog(&id, "info", "command", "Reboot command queued", "")
+	h.recordCommand(r, id, "reboot", created, "")
 
 	respondJSON(w, http.StatusOK, map[string]interface{}{
 		"success": true,
@@ -912,6 +1488,101 @@ func (h *Handler) RebootDevice(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// vendorIdentifyParams returns the vendor locator (LED blink) parameters for
+// a device and whether its vendor supports remote identify at all
+func vendorIdentifyParams(device *models.Device, seconds int) (map[string]string, bool) {
+	manufacturer := strings.ToUpper(device.Manufacturer)
+	duration := strconv.Itoa(seconds)
+	if containsString(manufacturer, "HUAWEI") {
+		return map[string]string{
+			"InternetGatewayDevice.X_HW_DEBUG.SMP.LED.BlinkEnable":   "1",
+			"InternetGatewayDevice.X_HW_DEBUG.SMP.LED.BlinkDuration": duration,
+		}, true
+	} else if containsString(manufacturer, "ZTE") {
+		return map[string]string{
+			"InternetGatewayDevice.DeviceInfo.X_ZTE-COM_LEDControl.Blink":         "1",
+			"InternetGatewayDevice.DeviceInfo.X_ZTE-COM_LEDControl.BlinkDuration": duration,
+		}, true
+	} else if containsString(manufacturer, "FIBERHOME") {
+		return map[string]string{
+			"InternetGatewayDevice.X_FH_LEDControl.Locate":         "1",
+			"InternetGatewayDevice.X_FH_LEDControl.LocateDuration": duration,
+		}, true
+	} else if containsString(manufacturer, "ALCATEL") || containsString(manufacturer, "NOKIA") {
+		return map[string]string{
+			"InternetGatewayDevice.X_ALU_LED.Identify":         "true",
+			"InternetGatewayDevice.X_ALU_LED.IdentifyDuration": duration,
+		}, true
+	}
+	return nil, false
+}
+
+// IdentifyDevice blinks the device LEDs so a field technician can locate it
+func (h *Handler) IdentifyDevice(w http.ResponseWriter, r *http.Request) {
+	id := getPathInt64(r, "id")
+
+	var req struct {
+		DurationSeconds int `json:"durationSeconds"`
+	}
+	if r.ContentLength > 0 {
+		if err := decodeJSON(w, r, &req); err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if req.DurationSeconds <= 0 {
+		req.DurationSeconds = 120
+	}
+	if req.DurationSeconds > 900 {
+		respondError(w, http.StatusBadRequest, "durationSeconds must be at most 900")
+		return
+	}
+
+	device, err := h.DB.GetDevice(id)
+	if err != nil || device == nil {
+		respondError(w, http.StatusNotFound, "Device not found")
+		return
+	}
+
+	params, ok := vendorIdentifyParams(device, req.DurationSeconds)
+	if !ok {
+		respondJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{
+			"success":   false,
+			"supported": false,
+			"error": fmt.Sprintf("Identify is not supported for %s %s (supported: Huawei, ZTE, FiberHome, Nokia/Alcatel)",
+				device.Manufacturer, device.ModelName),
+		})
+		return
+	}
+
+	paramsJSON, _ := json.Marshal(params)
+	created, err := h.DB.CreateTask(&models.DeviceTask{
+		DeviceID:   id,
+		Type:       models.TaskSetParameterValues,
+		Parameters: paramsJSON,
+		Priority:   models.TaskPriorityHigh,
+	})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to create identify task")
+		return
+	}
+
+	h.DB.CreateLog(&id, "info", "command",
+		fmt.Sprintf("Identify (LED blink) queued for %d seconds", req.DurationSeconds), string(paramsJSON))
+	h.recordCommand(r, id, "identify", created, fmt.Sprintf("%d seconds", req.DurationSeconds))
+
+	if h.ACS != nil {
+		go h.ACS.SendConnectionRequest(device)
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"success":   true,
+		"supported": true,
+		"taskId":    created.ID,
+		"message":   fmt.Sprintf("Identify queued, LEDs will blink for %d seconds", req.DurationSeconds),
+	})
+}
+
 // FactoryResetDevice sends a factory reset command to a device
 func (h *Handler) FactoryResetDevice(w http.ResponseWriter, r *http.Request) {
 	id := getPathInt64(r, "id")
@@ -928,6 +1599,7 @@ func (h *Handler) FactoryResetDevice(w http.ResponseWriter, r *http.Request) {
 	}
 
 	h.DB.CreateLog(&id, "warning", "command", "Factory reset command queued", "")
+	h.recordCommand(r, id, "factory_reset", created, "")
 
 	respondJSON(w, http.StatusOK, map[string]interface{}{
 		"success": true,
@@ -950,6 +1622,7 @@ func (h *Handler) RefreshDevice(w http.ResponseWriter, r *http.Request) {
 		respondError(w, http.StatusInternalServerError, "Failed to create refresh task")
 		return
 	}
+	h.recordCommand(r, id, "refresh", created, "")
 
 	respondJSON(w, http.StatusOK, map[string]interface{}{
 		"success": true,
@@ -958,7 +1631,485 @@ func (h *Handler) RefreshDevice(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// ============== WiFi Handlers ==============
+// bulkDeviceActions maps a bulk-action name to its task type and the command
+// name recorded in the device's command history
+var bulkDeviceActions = map[string]struct {
+	taskType models.TaskType
+	command  string
+}{
+	"reboot":        {models.TaskReboot, "reboot"},
+	"refresh":       {models.TaskRefresh, "refresh"},
+	"factory-reset": {models.TaskFactoryReset, "factory_reset"},
+}
+
+// deviceFilter selects the targets of a bulk operation: an explicit device
+// list, or every deployed device matching all of the set criteria
+type deviceFilter struct {
+	DeviceIDs    []int64 `json:"deviceIds,omitempty"`
+	Status       string  `json:"status,omitempty"`
+	Tag          string  `json:"tag,omitempty"`
+	Manufacturer string  `json:"manufacturer,omitempty"`
+}
+
+// empty reports whether the filter would select nothing in particular
+func (f deviceFilter) empty() bool {
+	return len(f.DeviceIDs) == 0 && f.Status == "" && f.Tag == "" && f.Manufacturer == ""
+}
+
+// selectDevices returns the devices matching a bulk operation filter.
+// Unknown device IDs are skipped.
+func (h *Handler) selectDevices(f deviceFilter) ([]*models.Device, error) {
+	var devices []*models.Device
+	if len(f.DeviceIDs) > 0 {
+		for _, id := range f.DeviceIDs {
+			if d, err := h.DB.GetDevice(id); err == nil && d != nil {
+				devices = append(devices, d)
+			}
+		}
+		return devices, nil
+	}
+
+	all, _, err := h.DB.GetDevices(f.Status, "", string(models.LifecycleDeployed), f.Tag, 100000, 0)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range all {
+		if f.Manufacturer != "" && !strings.EqualFold(d.Manufacturer, f.Manufacturer) {
+			continue
+		}
+		devices = append(devices, d)
+	}
+	return devices, nil
+}
+
+// BulkDeviceAction queues a reboot, refresh or factory reset on an explicit
+// device list or on every deployed device matching a filter, e.g. all ONUs of
+// an OLT after a PON outage. Factory reset requires confirm: true.
+func (h *Handler) BulkDeviceAction(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Action  string       `json:"action"`
+		Filter  deviceFilter `json:"filter"`
+		Confirm bool         `json:"confirm"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	action, ok := bulkDeviceActions[req.Action]
+	if !ok {
+		respondError(w, http.StatusBadRequest, "action must be reboot, refresh or factory-reset")
+		return
+	}
+	if req.Action == "factory-reset" && !req.Confirm {
+		respondError(w, http.StatusBadRequest, "Bulk factory reset wipes every matched device; repeat with confirm: true")
+		return
+	}
+	if req.Filter.empty() {
+		respondError(w, http.StatusBadRequest, "filter needs deviceIds or status/tag/manufacturer")
+		return
+	}
+
+	devices, err := h.selectDevices(req.Filter)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch devices")
+		return
+	}
+
+	taskIDs := []int64{}
+	failed := 0
+	for _, d := range devices {
+		id := d.ID
+		created, err := h.DB.CreateTask(&models.DeviceTask{DeviceID: id, Type: action.taskType})
+		if err != nil {
+			failed++
+			continue
+		}
+		taskIDs = append(taskIDs, created.ID)
+		h.recordCommand(r, id, action.command, created, "bulk")
+		if req.Action == "factory-reset" {
+			h.DB.CreateLog(&id, "warning", "command", "Factory reset command queued (bulk)", "")
+		}
+	}
+
+	level := "info"
+	if req.Action != "refresh" {
+		level = "warning"
+	}
+	filterJSON, _ := json.Marshal(req.Filter)
+	h.DB.CreateLog(nil, level, "command",
+		fmt.Sprintf("Bulk %s queued for %d of %d devices", req.Action, len(taskIDs), len(devices)), string(filterJSON))
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"action":  req.Action,
+		"matched": len(devices),
+		"queued":  len(taskIDs),
+		"failed":  failed,
+		"taskIds": taskIDs,
+	})
+}
+
+// ApproveDevice admits a device held in pending state so it becomes manageable
+func (h *Handler) ApproveDevice(w http.ResponseWriter, r *http.Request) {
+	id := getPathInt64(r, "id")
+
+	device, err := h.DB.GetDevice(id)
+	if err != nil || device == nil {
+		respondError(w, http.StatusNotFound, "Device not found")
+		return
+	}
+	if device.Status != models.StatusPending {
+		respondError(w, http.StatusBadRequest, "Device is not pending approval")
+		return
+	}
+
+	if err := h.DB.UpdateDeviceStatus(id, models.StatusOffline); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to approve device")
+		return
+	}
+
+	h.DB.CreateLog(&id, "info", "device", fmt.Sprintf("Device %s approved", device.SerialNumber), "")
+
+	// Ask the CPE to inform again so provisioning starts right away
+	if h.ACS != nil {
+		go h.ACS.SendConnectionRequest(device)
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": "Device approved",
+	})
+}
+
+// SetDeviceLifecycle moves a device between stock, deployed and retired
+func (h *Handler) SetDeviceLifecycle(w http.ResponseWriter, r *http.Request) {
+	id := getPathInt64(r, "id")
+
+	var req struct {
+		State models.LifecycleState `json:"state"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	device, err := h.DB.GetDevice(id)
+	if err != nil || device == nil {
+		respondError(w, http.StatusNotFound, "Device not found")
+		return
+	}
+	if device.LifecycleState == req.State {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("Device is already %s", req.State))
+		return
+	}
+	if !device.LifecycleState.CanTransitionTo(req.State) {
+		respondError(w, http.StatusBadRequest,
+			fmt.Sprintf("Cannot move device from %s to %q", device.LifecycleState, req.State))
+		return
+	}
+
+	if err := h.DB.SetDeviceLifecycle(id, req.State); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to update lifecycle state")
+		return
+	}
+
+	h.DB.CreateLog(&id, "info", "device",
+		fmt.Sprintf("Device %s moved from %s to %s", device.SerialNumber, device.LifecycleState, req.State), "")
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"state":   req.State,
+	})
+}
+
+// RequireApprovedDevice rejects state-changing device requests for a device
+// that is still awaiting approval. Approving and deleting it stay possible.
+func (h *Handler) RequireApprovedDevice(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions ||
+			!strings.HasPrefix(r.URL.Path, "/api/devices/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if route := mux.CurrentRoute(r); route != nil {
+			tpl, _ := route.GetPathTemplate()
+			if tpl == "/api/devices/{id}/approve" || (tpl == "/api/devices/{id}" && r.Method == http.MethodDelete) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		vars := mux.Vars(r)
+		for _, key := range []string{"id", "targetId", "sourceId", "oldId"} {
+			id, err := strconv.ParseInt(vars[key], 10, 64)
+			if err != nil {
+				continue
+			}
+			if device, err := h.DB.GetDevice(id); err == nil && device.Status == models.StatusPending {
+				respondError(w, http.StatusConflict, fmt.Sprintf("Device %s is awaiting approval", device.SerialNumber))
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// SetDeviceInformAuth sets or clears per-device credentials the CPE must present on Inform
+func (h *Handler) SetDeviceInformAuth(w http.ResponseWriter, r *http.Request) {
+	id := getPathInt64(r, "id")
+
+	var req struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	passwordHash := ""
+	if req.Username != "" {
+		if req.Password == "" {
+			respondError(w, http.StatusBadRequest, "Password is required")
+			return
+		}
+		hashed, err := hashPassword(req.Password)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "Failed to hash password")
+			return
+		}
+		passwordHash = hashed
+	}
+
+	if err := h.DB.SetDeviceInformCredentials(id, req.Username, passwordHash); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to save inform credentials")
+		return
+	}
+
+	message := "Inform credentials updated"
+	if req.Username == "" {
+		message = "Inform credentials cleared, global credentials apply"
+	}
+	h.DB.CreateLog(&id, "info", "device", message, "")
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": message,
+	})
+}
+
+// SetDeviceManagement re-homes a device by provisioning the ACS URL and/or the
+// management VLAN. A wrong value here can orphan the device, so the request
+// must repeat the device serial number in "confirm".
+func (h *Handler) SetDeviceManagement(w http.ResponseWriter, r *http.Request) {
+	id := getPathInt64(r, "id")
+
+	var req struct {
+		ACSURL         string `json:"acsUrl"`
+		ManagementVLAN int    `json:"managementVlan"`
+		WANIndex       int    `json:"wanIndex"` // WANConnectionDevice carrying TR-069, default 1
+		Confirm        string `json:"confirm"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	device, err := h.DB.GetDevice(id)
+	if err != nil || device == nil {
+		respondError(w, http.StatusNotFound, "Device not found")
+		return
+	}
+
+	if req.ACSURL == "" && req.ManagementVLAN == 0 {
+		respondError(w, http.StatusBadRequest, "acsUrl or managementVlan is required")
+		return
+	}
+	if req.ACSURL != "" {
+		if err := validateACSURL(req.ACSURL); err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if req.ManagementVLAN != 0 && (req.ManagementVLAN < 1 || req.ManagementVLAN > 4094) {
+		respondError(w, http.StatusBadRequest, "managementVlan must be between 1 and 4094")
+		return
+	}
+	if err := checkManagementConfirmation(device, req.Confirm); err != nil {
+		respondError(w, http.StatusPreconditionRequired, err.Error())
+		return
+	}
+
+	if req.WANIndex <= 0 {
+		req.WANIndex = 1
+	}
+	existing, _ := h.DB.GetDeviceParameters(id, "Device.ManagementServer.")
+	params := buildManagementParams(device, len(existing) > 0, req.ACSURL, req.ManagementVLAN, req.WANIndex)
+
+	paramsJSON, _ := json.Marshal(params)
+	task := &models.DeviceTask{
+		DeviceID:   id,
+		Type:       models.TaskSetParameterValues,
+		Parameters: paramsJSON,
+	}
+
+	created, err := h.DB.CreateTask(task)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to create management update task")
+		return
+	}
+
+	h.DB.CreateLog(&id, "warning", "device",
+		fmt.Sprintf("Management update queued (ACS URL: %q, VLAN: %d)", req.ACSURL, req.ManagementVLAN), "")
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"taskId":  created.ID,
+		"message": "Management update queued; the device may stop reporting to this ACS",
+	})
+}
+
+// validateACSURL checks that an ACS URL is an absolute http(s) URL
+func validateACSURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("acsUrl must be an absolute http:// or https:// URL")
+	}
+	return nil
+}
+
+// checkManagementConfirmation guards re-homing: the caller must echo the
+// device serial number exactly
+func checkManagementConfirmation(device *models.Device, confirm string) error {
+	if confirm == "" {
+		return fmt.Errorf("confirmation required: set confirm to the device serial number")
+	}
+	if device == nil || confirm != device.SerialNumber {
+		return fmt.Errorf("confirmation does not match the device serial number")
+	}
+	return nil
+}
+
+// buildManagementParams builds the parameters for ACS URL and management VLAN
+// changes. Zero values are left out.
+func buildManagementParams(device *models.Device, tr181 bool, acsURL string, vlan, wanIndex int) map[string]string {
+	params := make(map[string]string)
+
+	if acsURL != "" {
+		if tr181 {
+			params["Device.ManagementServer.URL"] = acsURL
+		} else {
+			params["InternetGatewayDevice.ManagementServer.URL"] = acsURL
+		}
+	}
+
+	if vlan > 0 {
+		vlanStr := strconv.Itoa(vlan)
+		if tr181 {
+			params[fmt.Sprintf("Device.Ethernet.VLANTermination.%d.VLANID", wanIndex)] = vlanStr
+			return params
+		}
+
+		base := fmt.Sprintf("InternetGatewayDevice.WANDevice.1.WANConnectionDevice.%d.WANIPConnection.1.", wanIndex)
+		params[base+vendorVLANParam(device)] = vlanStr
+	}
+
+	return params
+}
+
+// vendorVLANParam returns the WAN connection parameter name holding the VLAN ID
+func vendorVLANParam(device *models.Device) string {
+	manufacturer := ""
+	if device != nil {
+		manufacturer = strings.ToUpper(device.Manufacturer)
+	}
+	if containsString(manufacturer, "HUAWEI") {
+		return "X_HW_VLAN"
+	} else if containsString(manufacturer, "ZTE") {
+		return "X_ZTE-COM_VLANID"
+	} else if containsString(manufacturer, "FIBERHOME") {
+		return "X_FH_VLAN"
+	} else if containsString(manufacturer, "ALCATEL") || containsString(manufacturer, "NOKIA") {
+		return "X_ALU_VLANID"
+	}
+	return "VLANID"
+}
+
+// QuickFixDevice runs the common first-line support sequence for a device:
+// queue a parameter refresh, read the last known signal and, if the device
+// is offline, attempt a connection request. It returns a diagnostic summary.
+func (h *Handler) QuickFixDevice(w http.ResponseWriter, r *http.Request) {
+	id := getPathInt64(r, "id")
+
+	device, err := h.DB.GetDevice(id)
+	if err != nil || device == nil {
+		respondError(w, http.StatusNotFound, "Device not found")
+		return
+	}
+
+	steps := make([]map[string]interface{}, 0, 3)
+
+	// 1. Refresh parameters
+	refresh := map[string]interface{}{"step": "refresh", "success": false}
+	created, err := h.DB.CreateTask(&models.DeviceTask{
+		DeviceID: id,
+		Type:     models.TaskRefresh,
+	})
+	if err != nil {
+		refresh["message"] = "Failed to create refresh task"
+	} else {
+		refresh["success"] = true
+		refresh["taskId"] = created.ID
+		refresh["message"] = "Refresh command queued"
+	}
+	steps = append(steps, refresh)
+
+	// 2. Read signal
+	signal := map[string]interface{}{
+		"step":        "signal",
+		"success":     device.RXPower != 0,
+		"rxPower":     device.RXPower,
+		"txPower":     device.TXPower,
+		"temperature": device.Temperature,
+	}
+	if device.RXPower == 0 {
+		signal["message"] = "No optical reading recorded yet"
+	} else {
+		signal["message"] = fmt.Sprintf("RX %.2f dBm", device.RXPower)
+	}
+	steps = append(steps, signal)
+
+	// 3. Connection request if offline
+	online := device.Status == models.StatusOnline
+	if !online {
+		connReq := map[string]interface{}{"step": "connection_request", "success": false}
+		if h.ACS == nil {
+			connReq["message"] = "TR-069 server not available"
+		} else if err := h.ACS.SendConnectionRequest(device); err != nil {
+			connReq["message"] = err.Error()
+		} else {
+			connReq["success"] = true
+			connReq["message"] = "Connection request sent"
+		}
+		steps = append(steps, connReq)
+	}
+
+	h.DB.CreateLog(&id, "info", "command", "Quick fix executed", "")
+	h.recordCommand(r, id, "quick_fix", nil, "")
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"success":      true,
+		"deviceId":     id,
+		"serialNumber": device.SerialNumber,
+		"status":       device.Status,
+		"online":       online,
+		"lastInform":   device.LastInform,
+		"steps":        steps,
+	})
+}
+
+// ============== WiFi Handlers ==============
 
 // GetWiFiConfig returns WiFi configuration for a device
 func (h *Handler) GetWiFiConfig(w http.ResponseWriter, r *http.Request) {
@@ -1049,10 +2200,33 @@ func (h *Handler) GetWiFiConfig(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) UpdateWiFiConfig(w http.ResponseWriter, r *http.Request) {
 	id := getPathInt64(r, "id")
 
-	var config models.WiFiConfig
-	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid request body")
-		return
+	var req struct {
+		SSID             string `json:"ssid"`
+		Password         string `json:"password"`
+		SecurityMode     string `json:"securityMode"`
+		Channel          int    `json:"channel"`
+		ChannelBandwidth string `json:"channelBandwidth"`
+		Enabled          bool   `json:"enabled"`
+		HiddenSSID       bool   `json:"hiddenSSID"`
+		MaxClients       int    `json:"maxClients"`
+		Band             string `json:"band"`
+		TransmitPower    int    `json:"transmitPower"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	config := models.WiFiConfig{
+		SSID:             req.SSID,
+		Password:         req.Password,
+		SecurityMode:     req.SecurityMode,
+		Channel:          req.Channel,
+		ChannelBandwidth: req.ChannelBandwidth,
+		Enabled:          req.Enabled,
+		HiddenSSID:       req.HiddenSSID,
+		MaxClients:       req.MaxClients,
+		Band:             req.Band,
+		TransmitPower:    req.TransmitPower,
 	}
 
 	// Get device to determine vendor
@@ -1281,6 +2455,7 @@ func (h *Handler) UpdateWiFiConfig(w http.ResponseWriter, r *http.Request) {
 	}
 
 	h.DB.CreateLog(&id, "info", "wifi", fmt.Sprintf("WiFi configuration update queued (SSID: %s)", config.SSID), "")
+	h.recordCommand(r, id, "wifi", created, "SSID: "+config.SSID)
 
 	respondJSON(w, http.StatusOK, map[string]interface{}{
 		"success": true,
@@ -1296,8 +2471,8 @@ func (h *Handler) UpdateSSID(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		SSID string `json:"ssid"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid request body")
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
@@ -1308,67 +2483,7 @@ func (h *Handler) UpdateSSID(w http.ResponseWriter, r *http.Request) {
 
 	// Get device to determine vendor
 	device, _ := h.DB.GetDevice(id)
-
-	// Build vendor-specific parameter paths
-	params := make(map[string]string)
-
-	if device != nil {
-		manufacturer := strings.ToUpper(device.Manufacturer)
-		if containsString(manufacturer, "HUAWEI") {
-			// Huawei specific paths
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.SSID"] = req.SSID
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.SSID"] = req.SSID
-			params["Device.WiFi.SSID.1.SSID"] = req.SSID
-			params["Device.WiFi.SSID.2.SSID"] = req.SSID
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.X_HW_SSID"] = req.SSID
-		} else if containsString(manufacturer, "ZTE") {
-			// ZTE specific paths
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.SSID"] = req.SSID
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.SSID"] = req.SSID
-			params["Device.WiFi.SSID.1.SSID"] = req.SSID
-			params["Device.WiFi.SSID.2.SSID"] = req.SSID
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.X_ZTE_SSID"] = req.SSID
-		} else if containsString(manufacturer, "FIBERHOME") {
-			// FiberHome specific paths
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.SSID"] = req.SSID
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.SSID"] = req.SSID
-			params["Device.WiFi.SSID.1.SSID"] = req.SSID
-			params["Device.WiFi.SSID.2.SSID"] = req.SSID
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.X_FH_SSID"] = req.SSID
-		} else if containsString(manufacturer, "ALCATEL") || containsString(manufacturer, "NOKIA") {
-			// Alcatel/Nokia specific paths
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.SSID"] = req.SSID
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.SSID"] = req.SSID
-			params["Device.WiFi.SSID.1.SSID"] = req.SSID
-			params["Device.WiFi.SSID.2.SSID"] = req.SSID
-			params["Device.WiFi.SSID.1.Name"] = req.SSID
-		} else if containsString(manufacturer, "CIOT") {
-			// CIOT specific paths
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.SSID"] = req.SSID
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.SSID"] = req.SSID
-			params["Device.WiFi.SSID.1.SSID"] = req.SSID
-			params["Device.WiFi.SSID.2.SSID"] = req.SSID
-		} else if containsString(manufacturer, "TPLINK") || containsString(manufacturer, "TP-LINK") {
-			// TP-Link specific paths
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.SSID"] = req.SSID
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.SSID"] = req.SSID
-			params["Device.WiFi.SSID.1.SSID"] = req.SSID
-			params["Device.WiFi.SSID.2.SSID"] = req.SSID
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.X_TPLINK_SSID"] = req.SSID
-		} else {
-			// Default paths for unknown vendors
-			params["Device.WiFi.SSID.1.SSID"] = req.SSID
-			params["Device.WiFi.SSID.2.SSID"] = req.SSID
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.SSID"] = req.SSID
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.SSID"] = req.SSID
-			params["Device.WiFi.SSID.1.Name"] = req.SSID
-		}
-	} else {
-		// If no device info, try common paths
-		params["Device.WiFi.SSID.1.SSID"] = req.SSID
-		params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.SSID"] = req.SSID
-		params["Device.WiFi.SSID.1.Name"] = req.SSID
-	}
+	params := buildSSIDParams(device, req.SSID)
 
 	paramsJSON, _ := json.Marshal(params)
 	task := &models.DeviceTask{
@@ -1384,6 +2499,7 @@ func (h *Handler) UpdateSSID(w http.ResponseWriter, r *http.Request) {
 	}
 
 	h.DB.CreateLog(&id, "info", "wifi", fmt.Sprintf("SSID update queued: %s", req.SSID), "")
+	h.recordCommand(r, id, "wifi", created, "SSID: "+req.SSID)
 
 	respondJSON(w, http.StatusOK, map[string]interface{}{
 		"success": true,
@@ -1399,8 +2515,8 @@ func (h *Handler) UpdateWiFiPassword(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		Password string `json:"password"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid request body")
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
@@ -1411,7 +2527,33 @@ func (h *Handler) UpdateWiFiPassword(w http.ResponseWriter, r *http.Request) {
 
 	// Get device to determine vendor
 	device, _ := h.DB.GetDevice(id)
+	params := buildWiFiPasswordParams(device, req.Password)
 
+	paramsJSON, _ := json.Marshal(params)
+	task := &models.DeviceTask{
+		DeviceID:   id,
+		Type:       models.TaskSetParameterValues,
+		Parameters: paramsJSON,
+	}
+
+	created, err := h.DB.CreateTask(task)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to create password update task")
+		return
+	}
+
+	h.DB.CreateLog(&id, "info", "wifi", "WiFi password update queued", "")
+	h.recordCommand(r, id, "wifi", created, "password changed")
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"taskId":  created.ID,
+		"message": "WiFi password update queued",
+	})
+}
+
+// buildSSIDParams returns the vendor-specific parameter paths for setting the SSID
+func buildSSIDParams(device *models.Device, ssid string) map[string]string {
 	// Build vendor-specific parameter paths
 	params := make(map[string]string)
 
@@ -1419,88 +2561,135 @@ func (h *Handler) UpdateWiFiPassword(w http.ResponseWriter, r *http.Request) {
 		manufacturer := strings.ToUpper(device.Manufacturer)
 		if containsString(manufacturer, "HUAWEI") {
 			// Huawei specific paths
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.PreSharedKey.1.KeyPassphrase"] = req.Password
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.KeyPassphrase"] = req.Password
-			params["Device.WiFi.AccessPoint.1.Security.KeyPassphrase"] = req.Password
-			params["Device.WiFi.AccessPoint.2.Security.KeyPassphrase"] = req.Password
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.PreSharedKey.1.KeyPassphrase"] = req.Password
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.KeyPassphrase"] = req.Password
+			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.SSID"] = ssid
+			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.SSID"] = ssid
+			params["Device.WiFi.SSID.1.SSID"] = ssid
+			params["Device.WiFi.SSID.2.SSID"] = ssid
+			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.X_HW_SSID"] = ssid
 		} else if containsString(manufacturer, "ZTE") {
 			// ZTE specific paths
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.PreSharedKey.1.KeyPassphrase"] = req.Password
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.KeyPassphrase"] = req.Password
-			params["Device.WiFi.AccessPoint.1.Security.KeyPassphrase"] = req.Password
-			params["Device.WiFi.AccessPoint.2.Security.KeyPassphrase"] = req.Password
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.PreSharedKey.1.KeyPassphrase"] = req.Password
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.KeyPassphrase"] = req.Password
+			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.SSID"] = ssid
+			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.SSID"] = ssid
+			params["Device.WiFi.SSID.1.SSID"] = ssid
+			params["Device.WiFi.SSID.2.SSID"] = ssid
+			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.X_ZTE_SSID"] = ssid
 		} else if containsString(manufacturer, "FIBERHOME") {
 			// FiberHome specific paths
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.PreSharedKey.1.KeyPassphrase"] = req.Password
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.KeyPassphrase"] = req.Password
-			params["Device.WiFi.AccessPoint.1.Security.KeyPassphrase"] = req.Password
-			params["Device.WiFi.AccessPoint.2.Security.KeyPassphrase"] = req.Password
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.PreSharedKey.1.KeyPassphrase"] = req.Password
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.KeyPassphrase"] = req.Password
+			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.SSID"] = ssid
+			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.SSID"] = ssid
+			params["Device.WiFi.SSID.1.SSID"] = ssid
+			params["Device.WiFi.SSID.2.SSID"] = ssid
+			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.X_FH_SSID"] = ssid
 		} else if containsString(manufacturer, "ALCATEL") || containsString(manufacturer, "NOKIA") {
 			// Alcatel/Nokia specific paths
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.PreSharedKey.1.KeyPassphrase"] = req.Password
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.KeyPassphrase"] = req.Password
-			params["Device.WiFi.AccessPoint.1.Security.KeyPassphrase"] = req.Password
-			params["Device.WiFi.AccessPoint.2.Security.KeyPassphrase"] = req.Password
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.PreSharedKey.1.KeyPassphrase"] = req.Password
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.KeyPassphrase"] = req.Password
+			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.SSID"] = ssid
+			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.SSID"] = ssid
+			params["Device.WiFi.SSID.1.SSID"] = ssid
+			params["Device.WiFi.SSID.2.SSID"] = ssid
+			params["Device.WiFi.SSID.1.Name"] = ssid
 		} else if containsString(manufacturer, "CIOT") {
 			// CIOT specific paths
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.PreSharedKey.1.KeyPassphrase"] = req.Password
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.KeyPassphrase"] = req.Password
-			params["Device.WiFi.AccessPoint.1.Security.KeyPassphrase"] = req.Password
-			params["Device.WiFi.AccessPoint.2.Security.KeyPassphrase"] = req.Password
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.PreSharedKey.1.KeyPassphrase"] = req.Password
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.KeyPassphrase"] = req.Password
+			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.SSID"] = ssid
+			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.SSID"] = ssid
+			params["Device.WiFi.SSID.1.SSID"] = ssid
+			params["Device.WiFi.SSID.2.SSID"] = ssid
 		} else if containsString(manufacturer, "TPLINK") || containsString(manufacturer, "TP-LINK") {
 			// TP-Link specific paths
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.PreSharedKey.1.KeyPassphrase"] = req.Password
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.KeyPassphrase"] = req.Password
-			params["Device.WiFi.AccessPoint.1.Security.KeyPassphrase"] = req.Password
-			params["Device.WiFi.AccessPoint.2.Security.KeyPassphrase"] = req.Password
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.PreSharedKey.1.KeyPassphrase"] = req.Password
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.KeyPassphrase"] = req.Password
+			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.SSID"] = ssid
+			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.SSID"] = ssid
+			params["Device.WiFi.SSID.1.SSID"] = ssid
+			params["Device.WiFi.SSID.2.SSID"] = ssid
+			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.X_TPLINK_SSID"] = ssid
 		} else {
 			// Default paths for unknown vendors
-			params["Device.WiFi.AccessPoint.1.Security.KeyPassphrase"] = req.Password
-			params["Device.WiFi.AccessPoint.2.Security.KeyPassphrase"] = req.Password
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.PreSharedKey.1.KeyPassphrase"] = req.Password
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.KeyPassphrase"] = req.Password
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.PreSharedKey.1.KeyPassphrase"] = req.Password
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.KeyPassphrase"] = req.Password
+			params["Device.WiFi.SSID.1.SSID"] = ssid
+			params["Device.WiFi.SSID.2.SSID"] = ssid
+			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.SSID"] = ssid
+			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.SSID"] = ssid
+			params["Device.WiFi.SSID.1.Name"] = ssid
 		}
 	} else {
 		// If no device info, try common paths
-		params["Device.WiFi.AccessPoint.1.Security.KeyPassphrase"] = req.Password
-		params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.PreSharedKey.1.KeyPassphrase"] = req.Password
-		params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.KeyPassphrase"] = req.Password
+		params["Device.WiFi.SSID.1.SSID"] = ssid
+		params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.SSID"] = ssid
+		params["Device.WiFi.SSID.1.Name"] = ssid
 	}
 
-	paramsJSON, _ := json.Marshal(params)
-	task := &models.DeviceTask{
-		DeviceID:   id,
-		Type:       models.TaskSetParameterValues,
-		Parameters: paramsJSON,
-	}
+	return params
+}
 
-	created, err := h.DB.CreateTask(task)
-	if err != nil {
-		respondError(w, http.StatusInternalServerError, "Failed to create password update task")
-		return
-	}
+// buildWiFiPasswordParams returns the vendor-specific parameter paths for setting the WiFi passphrase
+func buildWiFiPasswordParams(device *models.Device, password string) map[string]string {
+	// Build vendor-specific parameter paths
+	params := make(map[string]string)
 
-	h.DB.CreateLog(&id, "info", "wifi", "WiFi password update queued", "")
+	if device != nil {
+		manufacturer := strings.ToUpper(device.Manufacturer)
+		if containsString(manufacturer, "HUAWEI") {
+			// Huawei specific paths
+			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.PreSharedKey.1.KeyPassphrase"] = password
+			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.KeyPassphrase"] = password
+			params["Device.WiFi.AccessPoint.1.Security.KeyPassphrase"] = password
+			params["Device.WiFi.AccessPoint.2.Security.KeyPassphrase"] = password
+			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.PreSharedKey.1.KeyPassphrase"] = password
+			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.KeyPassphrase"] = password
+		} else if containsString(manufacturer, "ZTE") {
+			// ZTE specific paths
+			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.PreSharedKey.1.KeyPassphrase"] = password
+			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.KeyPassphrase"] = password
+			params["Device.WiFi.AccessPoint.1.Security.KeyPassphrase"] = password
+			params["Device.WiFi.AccessPoint.2.Security.KeyPassphrase"] = password
+			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.PreSharedKey.1.KeyPassphrase"] = password
+			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.KeyPassphrase"] = password
+		} else if containsString(manufacturer, "FIBERHOME") {
+			// FiberHome specific paths
+			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.PreSharedKey.1.KeyPassphrase"] = password
+			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.KeyPassphrase"] = password
+			params["Device.WiFi.AccessPoint.1.Security.KeyPassphrase"] = password
+			params["Device.WiFi.AccessPoint.2.Security.KeyPassphrase"] = password
+			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.PreSharedKey.1.KeyPassphrase"] = password
+			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.KeyPassphrase"] = password
+		} else if containsString(manufacturer, "ALCATEL") || containsString(manufacturer, "NOKIA") {
+			// Alcatel/Nokia specific paths
+			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.PreSharedKey.1.KeyPassphrase"] = password
+			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.KeyPassphrase"] = password
+			params["Device.WiFi.AccessPoint.1.Security.KeyPassphrase"] = password
+			params["Device.WiFi.AccessPoint.2.Security.KeyPassphrase"] = password
+			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.PreSharedKey.1.KeyPassphrase"] = password
+			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.KeyPassphrase"] = password
+		} else if containsString(manufacturer, "CIOT") {
+			// CIOT specific paths
+			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.PreSharedKey.1.KeyPassphrase"] = password
+			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.KeyPassphrase"] = password
+			params["Device.WiFi.AccessPoint.1.Security.KeyPassphrase"] = password
+			params["Device.WiFi.AccessPoint.2.Security.KeyPassphrase"] = password
+			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.PreSharedKey.1.KeyPassphrase"] = password
+			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.KeyPassphrase"] = password
+		} else if containsString(manufacturer, "TPLINK") || containsString(manufacturer, "TP-LINK") {
+			// TP-Link specific paths
+			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.PreSharedKey.1.KeyPassphrase"] = password
+			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.KeyPassphrase"] = password
+			params["Device.WiFi.AccessPoint.1.Security.KeyPassphrase"] = password
+			params["Device.WiFi.AccessPoint.2.Security.KeyPassphrase"] = password
+			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.PreSharedKey.1.KeyPassphrase"] = password
+			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.KeyPassphrase"] = password
+		} else {
+			// Default paths for unknown vendors
+			params["Device.WiFi.AccessPoint.1.Security.KeyPassphrase"] = password
+			params["Device.WiFi.AccessPoint.2.Security.KeyPassphrase"] = password
+			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.PreSharedKey.1.KeyPassphrase"] = password
+			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.KeyPassphrase"] = password
+			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.PreSharedKey.1.KeyPassphrase"] = password
+			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.KeyPassphrase"] = password
+		}
+	} else {
+		// If no device info, try common paths
+		params["Device.WiFi.AccessPoint.1.Security.KeyPassphrase"] = password
+		params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.PreSharedKey.1.KeyPassphrase"] = password
+		params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.KeyPassphrase"] = password
+	}
 
-	respondJSON(w, http.StatusOK, map[string]interface{}{
-		"success": true,
-		"taskId":  created.ID,
-		"message": "WiFi password update queued",
-	})
+	return params
 }
 
 // ============== WAN Handlers ==============
@@ -1522,13 +2711,22 @@ func (h *Handler) GetWANConfigs(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) CreateWANConfig(w http.ResponseWriter, r *http.Request) {
 	id := getPathInt64(r, "id")
 
-	var config models.WANConfig
-	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid request body")
+	var req wanConfigRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	config := req.config()
 
 	config.DeviceID = id
+	apply := r.URL.Query().Get("apply") == "true"
+	if apply {
+		if err := validateWANConfig(&config); err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
 	created, err := h.DB.CreateWANConfig(&config)
 	if err != nil {
 		respondError(w, http.StatusInternalServerError, "Failed to create WAN config")
@@ -1537,292 +2735,3007 @@ func (h *Handler) CreateWANConfig(w http.ResponseWriter, r *http.Request) {
 
 	h.DB.CreateLog(&id, "info", "wan", fmt.Sprintf("WAN configuration created: %s", config.Name), "")
 
-	respondJSON(w, http.StatusCreated, created)
-}
+	if apply {
+		if _, err := h.queueWANConfig(r, created, getQueryInt(r, "wanIndex", 1)); err != nil {
+			respondError(w, http.StatusInternalServerError, "WAN config saved but failed to queue apply task")
+			return
+		}
+	}
 
-// GetWANConfig returns a specific WAN configuration
-func (h *Handler) GetWANConfig(w http.ResponseWriter, r *http.Request) {
-	// Implementation for getting a single WAN config
-	respondJSON(w, http.StatusOK, map[string]string{"message": "Not implemented"})
+	respondJSON(w, http.StatusCreated, created)
 }
 
-// UpdateWANConfig updates a WAN configuration
-func (h *Handler) UpdateWANConfig(w http.ResponseWriter, r *http.Request) {
+// ApplyWANConfig pushes a stored WAN configuration to the device as
+// SetParameterValues on WANConnectionDevice.{wanIndex} (default 1)
+func (h *Handler) ApplyWANConfig(w http.ResponseWriter, r *http.Request) {
+	id := getPathInt64(r, "id")
 	wanID := getPathInt64(r, "wanId")
 
-	var config models.WANConfig
-	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid request body")
+	config, err := h.DB.GetWANConfig(wanID)
+	if err != nil || config.DeviceID != id {
+		respondError(w, http.StatusNotFound, "WAN config not found")
+		return
+	}
+	if err := validateWANConfig(config); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	config.ID = wanID
-	if err := h.DB.UpdateWANConfig(&config); err != nil {
-		respondError(w, http.StatusInternalServerError, "Failed to update WAN config")
+	task, err := h.queueWANConfig(r, config, getQueryInt(r, "wanIndex", 1))
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to create WAN apply task")
 		return
 	}
 
 	respondJSON(w, http.StatusOK, map[string]interface{}{
 		"success": true,
-		"message": "WAN configuration updated",
+		"taskId":  task.ID,
+		"message": fmt.Sprintf("WAN configuration '%s' queued for device", config.Name),
 	})
 }
 
-// DeleteWANConfig deletes a WAN configuration
-func (h *Handler) DeleteWANConfig(w http.ResponseWriter, r *http.Request) {
-	wanID := getPathInt64(r, "wanId")
+// queueWANConfig translates a WAN config into vendor parameters and queues them
+func (h *Handler) queueWANConfig(r *http.Request, config *models.WANConfig, wanIndex int) (*models.DeviceTask, error) {
+	if wanIndex <= 0 {
+		wanIndex = 1
+	}
+	device, _ := h.DB.GetDevice(config.DeviceID)
+	applied := *config
+	if applied.VLAN == 0 && !strings.EqualFold(applied.ConnectionType, "bridge") {
+		applied.VLAN = h.packageVLAN(device)
+	}
+	params := buildWANConfigParams(device, &applied, wanIndex)
 
-	if err := h.DB.DeleteWANConfig(wanID); err != nil {
-		respondError(w, http.StatusInternalServerError, "Failed to delete WAN config")
-		return
+	paramsJSON, _ := json.Marshal(params)
+	task, err := h.DB.CreateTask(&models.DeviceTask{
+		DeviceID:   config.DeviceID,
+		Type:       models.TaskSetParameterValues,
+		Parameters: paramsJSON,
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	respondJSON(w, http.StatusOK, map[string]bool{"success": true})
+	h.DB.CreateLog(&config.DeviceID, "info", "wan",
+		fmt.Sprintf("WAN configuration '%s' (%s) queued on WANConnectionDevice.%d", config.Name, config.ConnectionType, wanIndex), "")
+	h.recordCommand(r, config.DeviceID, "wan", task, fmt.Sprintf("%s (%s)", config.Name, config.ConnectionType))
+	return task, nil
 }
 
-// ============== Parameter Handlers ==============
-
-// GetDeviceParameters returns device parameters
-func (h *Handler) GetDeviceParameters(w http.ResponseWriter, r *http.Request) {
-	id := getPathInt64(r, "id")
-	prefix := r.URL.Query().Get("prefix")
+// packageVLAN returns the VLAN of the package subscribed by the device's
+// customer, or 0 when the device is unassigned or the package has none
+func (h *Handler) packageVLAN(device *models.Device) int {
+	if device == nil || device.CustomerID == nil {
+		return 0
+	}
+	customer, err := h.DB.GetCustomer(*device.CustomerID)
+	if err != nil || customer == nil || customer.PackageID == 0 {
+		return 0
+	}
+	pkg, err := h.DB.GetPackage(customer.PackageID)
+	if err != nil || pkg == nil {
+		return 0
+	}
+	return pkg.VLAN
+}
 
-	params, err := h.DB.GetDeviceParameters(id, prefix)
-	if err != nil {
-		respondError(w, http.StatusInternalServerError, "Failed to get parameters")
-		return
+// wanConfigRequest is the body of the WAN config create and update endpoints
+type wanConfigRequest struct {
+	Name           string `json:"name"`
+	ConnectionType string `json:"connectionType"`
+	VLAN           int    `json:"vlan"`
+	Username       string `json:"username"`
+	Password       string `json:"password"`
+	IPAddress      string `json:"ipAddress"`
+	SubnetMask     string `json:"subnetMask"`
+	Gateway        string `json:"gateway"`
+	DNS1           string `json:"dns1"`
+	DNS2           string `json:"dns2"`
+	MTU            int    `json:"mtu"`
+	Enabled        bool   `json:"enabled"`
+	NATEnabled     bool   `json:"natEnabled"`
+}
+
+func (req wanConfigRequest) config() models.WANConfig {
+	return models.WANConfig{
+		Name:           req.Name,
+		ConnectionType: req.ConnectionType,
+		VLAN:           req.VLAN,
+		Username:       req.Username,
+		Password:       req.Password,
+		IPAddress:      req.IPAddress,
+		SubnetMask:     req.SubnetMask,
+		Gateway:        req.Gateway,
+		DNS1:           req.DNS1,
+		DNS2:           req.DNS2,
+		MTU:            req.MTU,
+		Enabled:        req.Enabled,
+		NATEnabled:     req.NATEnabled,
+	}
+}
+
+// validateWANConfig checks that a WAN config can be pushed to a device
+func validateWANConfig(config *models.WANConfig) error {
+	switch strings.ToLower(config.ConnectionType) {
+	case "pppoe":
+		if config.Username == "" {
+			return fmt.Errorf("PPPoE username is required")
+		}
+	case "dhcp", "bridge":
+	case "static":
+		if net.ParseIP(config.IPAddress).To4() == nil {
+			return fmt.Errorf("invalid static IP address")
+		}
+		if net.ParseIP(config.SubnetMask).To4() == nil {
+			return fmt.Errorf("invalid subnet mask")
+		}
+		if config.Gateway != "" && net.ParseIP(config.Gateway).To4() == nil {
+			return fmt.Errorf("invalid gateway")
+		}
+	default:
+		return fmt.Errorf("connectionType must be PPPoE, DHCP, Static or Bridge")
+	}
+	if config.VLAN < 0 || config.VLAN > 4094 {
+		return fmt.Errorf("vlan must be between 0 and 4094")
 	}
+	if config.MTU != 0 && (config.MTU < 576 || config.MTU > 1500) {
+		return fmt.Errorf("mtu must be between 576 and 1500")
+	}
+	return nil
+}
 
-	respondJSON(w, http.StatusOK, params)
+// wanConnectionBase returns the TR-098 connection object for a WAN config on
+// WANConnectionDevice.{wanIndex}: WANPPPConnection.1 for PPPoE, otherwise
+// WANIPConnection.1
+func wanConnectionBase(connectionType string, wanIndex int) string {
+	base := fmt.Sprintf("InternetGatewayDevice.WANDevice.1.WANConnectionDevice.%d.", wanIndex)
+	if strings.EqualFold(connectionType, "pppoe") {
+		return base + "WANPPPConnection.1."
+	}
+	return base + "WANIPConnection.1."
 }
 
-// SetDeviceParameters sets device parameters
-func (h *Handler) SetDeviceParameters(w http.ResponseWriter, r *http.Request) {
-	id := getPathInt64(r, "id")
+// buildWANConfigParams builds TR-098 SetParameterValues for a WAN config on
+// WANConnectionDevice.{wanIndex}. PPPoE uses WANPPPConnection.1, DHCP, static
+// and bridge use WANIPConnection.1; the VLAN parameter name is vendor specific.
+func buildWANConfigParams(device *models.Device, config *models.WANConfig, wanIndex int) map[string]string {
+	params := make(map[string]string)
+	connType := strings.ToLower(config.ConnectionType)
+	base := wanConnectionBase(connType, wanIndex)
+
+	params[base+"Enable"] = strconv.FormatBool(config.Enabled)
+	if config.Name != "" {
+		params[base+"Name"] = config.Name
+	}
+	if connType == "bridge" {
+		// Bridged WANs (e.g. IPTV) carry no IP settings of their own
+		params[base+"ConnectionType"] = "IP_Bridged"
+		if config.VLAN > 0 {
+			params[base+vendorVLANParam(device)] = strconv.Itoa(config.VLAN)
+		}
+		return params
+	}
+	params[base+"ConnectionType"] = "IP_Routed"
+	params[base+"NATEnabled"] = strconv.FormatBool(config.NATEnabled)
 
-	var params map[string]string
-	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid request body")
-		return
+	switch connType {
+	case "pppoe":
+		params[base+"Username"] = config.Username
+		if config.Password != "" {
+			params[base+"Password"] = config.Password
+		}
+		if config.MTU > 0 {
+			params[base+"MaxMRUSize"] = strconv.Itoa(config.MTU)
+		}
+	case "dhcp":
+		params[base+"AddressingType"] = "DHCP"
+	case "static":
+		params[base+"AddressingType"] = "Static"
+		params[base+"ExternalIPAddress"] = config.IPAddress
+		params[base+"SubnetMask"] = config.SubnetMask
+		if config.Gateway != "" {
+			params[base+"DefaultGateway"] = config.Gateway
+		}
+	}
+	if connType != "pppoe" && config.MTU > 0 {
+		params[base+"MaxMTUSize"] = strconv.Itoa(config.MTU)
 	}
 
-	paramsJSON, _ := json.Marshal(params)
-	task := &models.DeviceTask{
-		DeviceID:   id,
-		Type:       models.TaskSetParameterValues,
-		Parameters: paramsJSON,
+	var dns []string
+	for _, d := range []string{config.DNS1, config.DNS2} {
+		if d != "" {
+			dns = append(dns, d)
+		}
+	}
+	if len(dns) > 0 {
+		params[base+"DNSServers"] = strings.Join(dns, ",")
 	}
 
-	created, err := h.DB.CreateTask(task)
-	if err != nil {
-		respondError(w, http.StatusInternalServerError, "Failed to create parameter update task")
-		return
+	if config.VLAN > 0 {
+		params[base+vendorVLANParam(device)] = strconv.Itoa(config.VLAN)
 	}
 
-	respondJSON(w, http.StatusOK, map[string]interface{}{
-		"success": true,
-		"taskId":  created.ID,
-		"message": fmt.Sprintf("Parameter update queued (%d parameters)", len(params)),
-	})
+	return params
 }
 
-// GetDeviceParameter returns a specific parameter
-func (h *Handler) GetDeviceParameter(w http.ResponseWriter, r *http.Request) {
-	id := getPathInt64(r, "id")
-	path := mux.Vars(r)["path"]
-
-	params, err := h.DB.GetDeviceParameters(id, path)
-	if err != nil || len(params) == 0 {
-		respondError(w, http.StatusNotFound, "Parameter not found")
-		return
+// vendorServiceListParam returns the WAN connection parameter that tags the
+// service a connection carries (INTERNET, IPTV, VOIP, TR069), or "" when the
+// vendor has no such parameter
+func vendorServiceListParam(device *models.Device) string {
+	manufacturer := ""
+	if device != nil {
+		manufacturer = strings.ToUpper(device.Manufacturer)
+	}
+	if containsString(manufacturer, "HUAWEI") {
+		return "X_HW_SERVICELIST"
+	} else if containsString(manufacturer, "ZTE") {
+		return "X_ZTE-COM_ServiceList"
+	} else if containsString(manufacturer, "FIBERHOME") {
+		return "X_FH_ServiceList"
 	}
+	return ""
+}
 
-	respondJSON(w, http.StatusOK, params[0])
+// validWANServices are the service tags accepted in a WAN template
+var validWANServices = map[string]bool{"INTERNET": true, "IPTV": true, "VOIP": true, "TR069": true, "OTHER": true}
+
+// validateWANTemplate checks a multi-WAN template before it is stored
+func validateWANTemplate(t *models.WANTemplate) error {
+	if strings.TrimSpace(t.Name) == "" {
+		return fmt.Errorf("name is required")
+	}
+	if len(t.Connections) == 0 {
+		return fmt.Errorf("at least one connection is required")
+	}
+	seen := make(map[int]bool)
+	for i := range t.Connections {
+		c := &t.Connections[i]
+		c.Service = strings.ToUpper(strings.TrimSpace(c.Service))
+		if c.Service == "" {
+			c.Service = "INTERNET"
+		}
+		if !validWANServices[c.Service] {
+			return fmt.Errorf("connection %d: service must be INTERNET, IPTV, VOIP, TR069 or OTHER", i+1)
+		}
+		if c.WANIndex <= 0 {
+			return fmt.Errorf("connection %d: wanIndex must be 1 or higher", i+1)
+		}
+		if seen[c.WANIndex] {
+			return fmt.Errorf("connection %d: wanIndex %d is used twice", i+1, c.WANIndex)
+		}
+		seen[c.WANIndex] = true
+		switch strings.ToLower(c.ConnectionType) {
+		case "pppoe", "dhcp", "bridge":
+		default:
+			return fmt.Errorf("connection %d: connectionType must be PPPoE, DHCP or Bridge", i+1)
+		}
+		if c.VLAN < 0 || c.VLAN > 4094 {
+			return fmt.Errorf("connection %d: vlan must be between 0 and 4094", i+1)
+		}
+		if c.MTU != 0 && (c.MTU < 576 || c.MTU > 1500) {
+			return fmt.Errorf("connection %d: mtu must be between 576 and 1500", i+1)
+		}
+	}
+	return nil
 }
 
-// ============== Firmware Handlers ==============
+// buildWANTemplateParams builds one SetParameterValues set covering every
+// connection of a template. PPPoE connections use the given credentials, and
+// each connection is tagged with its service where the vendor supports it.
+// INTERNET connections without a VLAN of their own get internetVLAN.
+func buildWANTemplateParams(device *models.Device, t *models.WANTemplate, username, password string, internetVLAN int) map[string]string {
+	params := make(map[string]string)
+	serviceParam := vendorServiceListParam(device)
+
+	for _, c := range t.Connections {
+		config := &models.WANConfig{
+			Name:           c.Name,
+			ConnectionType: c.ConnectionType,
+			VLAN:           c.VLAN,
+			MTU:            c.MTU,
+			Enabled:        true,
+			NATEnabled:     c.NATEnabled,
+		}
+		if config.VLAN == 0 && c.Service == "INTERNET" {
+			config.VLAN = internetVLAN
+		}
+		if strings.EqualFold(c.ConnectionType, "pppoe") {
+			config.Username = username
+			config.Password = password
+		}
+		for path, value := range buildWANConfigParams(device, config, c.WANIndex) {
+			params[path] = value
+		}
+		if serviceParam != "" && c.Service != "" {
+			params[wanConnectionBase(c.ConnectionType, c.WANIndex)+serviceParam] = c.Service
+		}
+	}
+	return params
+}
 
-// GetFirmwareInfo returns firmware information
-func (h *Handler) GetFirmwareInfo(w http.ResponseWriter, r *http.Request) {
-	id := getPathInt64(r, "id")
+// wanConnectionDevices is the TR-098 object WAN connections live under
+const wanConnectionDevices = "InternetGatewayDevice.WANDevice.1.WANConnectionDevice."
 
-	device, err := h.DB.GetDevice(id)
-	if err != nil {
-		respondError(w, http.StatusNotFound, "Device not found")
-		return
+// planWANTemplate splits the parameters of buildWANTemplateParams by whether
+// the device already has the connection object they belong to, judged from
+// the parameters it reported (existing). Values for existing connections are
+// returned as one set; each missing connection becomes an AddObject of the
+// connection (and its WANConnectionDevice, if that is missing too) that then
+// writes its values on the instance the CPE created.
+func planWANTemplate(t *models.WANTemplate, params map[string]string, existing []*models.DeviceParameter) (map[string]string, []*models.AddObjectRequest) {
+	has := func(prefix string) bool {
+		for _, p := range existing {
+			if strings.HasPrefix(p.Path, prefix) {
+				return true
+			}
+		}
+		return false
 	}
 
-	info := map[string]interface{}{
-		"currentVersion":  device.SoftwareVersion,
-		"hardwareVersion": device.HardwareVersion,
-		"updateAvailable": false,
+	set := make(map[string]string, len(params))
+	for path, value := range params {
+		set[path] = value
 	}
+	var adds []*models.AddObjectRequest
+	for _, c := range t.Connections {
+		base := wanConnectionBase(c.ConnectionType, c.WANIndex)
+		if has(base) {
+			continue
+		}
+		values := make(map[string]string)
+		for path, value := range set {
+			if strings.HasPrefix(path, base) {
+				values[strings.TrimPrefix(path, base)] = value
+				delete(set, path)
+			}
+		}
+		// "WANPPPConnection." or "WANIPConnection."
+		device := fmt.Sprintf("%s%d.", wanConnectionDevices, c.WANIndex)
+		connection := strings.TrimSuffix(strings.TrimPrefix(base, device), "1.")
 
-	respondJSON(w, http.StatusOK, info)
+		add := &models.AddObjectRequest{ObjectName: device + connection, Values: values}
+		if !has(device) {
+			add = &models.AddObjectRequest{ObjectName: wanConnectionDevices, Then: &models.AddObjectRequest{ObjectName: connection, Values: values}}
+		}
+		adds = append(adds, add)
+	}
+	return set, adds
 }
 
-// UpgradeFirmware starts a firmware upgrade
-func (h *Handler) UpgradeFirmware(w http.ResponseWriter, r *http.Request) {
-	id := getPathInt64(r, "id")
-
-	var req struct {
-		URL      string `json:"url"`
-		Username string `json:"username"`
-		Password string `json:"password"`
+// GetWANTemplates returns all multi-WAN templates
+func (h *Handler) GetWANTemplates(w http.ResponseWriter, r *http.Request) {
+	templates, err := h.DB.GetWANTemplates()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get WAN templates")
+		return
 	}
+	respondJSON(w, http.StatusOK, templates)
+}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid request body")
+// CreateWANTemplate creates a multi-WAN template
+func (h *Handler) CreateWANTemplate(w http.ResponseWriter, r *http.Request) {
+	var t models.WANTemplate
+	if err := decodeJSON(w, r, &t); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
 		return
 	}
-
-	if req.URL == "" {
-		respondError(w, http.StatusBadRequest, "Firmware URL is required")
+	if err := validateWANTemplate(&t); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	paramsJSON, _ := json.Marshal(req)
-	task := &models.DeviceTask{
-		DeviceID:   id,
-		Type:       models.TaskDownload,
-		Parameters: paramsJSON,
+	created, err := h.DB.CreateWANTemplate(&t)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to create WAN template, the name may already exist")
+		return
 	}
+	respondJSON(w, http.StatusCreated, created)
+}
 
-	created, err := h.DB.CreateTask(task)
+// GetWANTemplate returns a specific multi-WAN template
+func (h *Handler) GetWANTemplate(w http.ResponseWriter, r *http.Request) {
+	t, err := h.DB.GetWANTemplate(getPathInt64(r, "id"))
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, "Failed to create firmware upgrade task")
+		respondError(w, http.StatusNotFound, "WAN template not found")
 		return
 	}
-
-	h.DB.CreateLog(&id, "warning", "firmware", "Firmware upgrade initiated", req.URL)
-
-	respondJSON(w, http.StatusOK, map[string]interface{}{
-		"success": true,
-		"taskId":  created.ID,
-		"message": "Firmware upgrade started",
-	})
+	respondJSON(w, http.StatusOK, t)
 }
 
-// GetDeviceStatusLogs returns uptime history logs for a device
-func (h *Handler) GetDeviceStatusLogs(w http.ResponseWriter, r *http.Request) {
+// UpdateWANTemplate updates a multi-WAN template
+func (h *Handler) UpdateWANTemplate(w http.ResponseWriter, r *http.Request) {
 	id := getPathInt64(r, "id")
-	limit := getQueryInt(r, "limit", 50)
-
-	logs, err := h.DB.GetDeviceLogs(id, limit)
+	t, err := h.DB.GetWANTemplate(id)
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, "Failed to fetch logs")
+		respondError(w, http.StatusNotFound, "WAN template not found")
+		return
+	}
+	if err := decodeJSON(w, r, t); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	t.ID = id
+	if err := validateWANTemplate(t); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := h.DB.UpdateWANTemplate(t); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to update WAN template")
+		return
+	}
+	respondJSON(w, http.StatusOK, t)
+}
+
+// DeleteWANTemplate deletes a multi-WAN template
+func (h *Handler) DeleteWANTemplate(w http.ResponseWriter, r *http.Request) {
+	if err := h.DB.DeleteWANTemplate(getPathInt64(r, "id")); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to delete WAN template")
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]bool{"success": true})
+}
+
+// ApplyWANTemplate provisions every WAN connection of a template on a device
+// in a single task. The WANConnectionDevice instances must already exist.
+func (h *Handler) ApplyWANTemplate(w http.ResponseWriter, r *http.Request) {
+	id := getPathInt64(r, "id")
+
+	var req struct {
+		TemplateID int64  `json:"templateId"`
+		Username   string `json:"username"` // PPPoE credentials for the Internet WAN
+		Password   string `json:"password"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	device, err := h.DB.GetDevice(id)
+	if err != nil || device == nil {
+		respondError(w, http.StatusNotFound, "Device not found")
+		return
+	}
+	t, err := h.DB.GetWANTemplate(req.TemplateID)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "WAN template not found")
+		return
+	}
+	for _, c := range t.Connections {
+		if strings.EqualFold(c.ConnectionType, "pppoe") && req.Username == "" {
+			respondError(w, http.StatusBadRequest, "PPPoE username is required for this template")
+			return
+		}
+	}
+
+	// Templates write TR-098 paths; which connections exist is taken from
+	// the WAN tree the device last reported
+	existing, _ := h.DB.GetDeviceParameters(id, "InternetGatewayDevice.WANDevice.1.")
+	if len(existing) == 0 {
+		if tr181, _ := h.DB.GetDeviceParameters(id, "Device.DeviceInfo."); len(tr181) > 0 {
+			respondError(w, http.StatusUnprocessableEntity, "WAN templates support TR-098 (InternetGatewayDevice) devices only")
+			return
+		}
+		respondError(w, http.StatusConflict, "The device has not reported its WAN parameters yet; refresh it first")
+		return
+	}
+
+	params := buildWANTemplateParams(device, t, req.Username, req.Password, h.packageVLAN(device))
+	set, adds := planWANTemplate(t, params, existing)
+
+	var tasks []*models.DeviceTask
+	if len(set) > 0 {
+		paramsJSON, _ := json.Marshal(set)
+		tasks = append(tasks, &models.DeviceTask{DeviceID: id, Type: models.TaskSetParameterValues, Parameters: paramsJSON})
+	}
+	for _, add := range adds {
+		addJSON, _ := json.Marshal(add)
+		tasks = append(tasks, &models.DeviceTask{DeviceID: id, Type: models.TaskAddObject, Parameters: addJSON})
+	}
+	taskIDs := make([]int64, 0, len(tasks))
+	for _, task := range tasks {
+		created, err := h.DB.CreateTask(task)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "Failed to create WAN template task")
+			return
+		}
+		taskIDs = append(taskIDs, created.ID)
+		h.recordCommand(r, id, "wan_template", created, t.Name)
+	}
+
+	h.DB.CreateLog(&id, "info", "wan",
+		fmt.Sprintf("WAN template '%s' queued (%d connections, %d to create)", t.Name, len(t.Connections), len(adds)), "")
+
+	if h.ACS != nil {
+		go h.ACS.SendConnectionRequest(device)
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"taskId":  taskIDs[0],
+		"taskIds": taskIDs,
+		"created": len(adds),
+		"message": fmt.Sprintf("WAN template '%s' queued for device", t.Name),
+	})
+}
+
+// GetWANConfig returns a specific WAN configuration
+func (h *Handler) GetWANConfig(w http.ResponseWriter, r *http.Request) {
+	id := getPathInt64(r, "id")
+	wanID := getPathInt64(r, "wanId")
+
+	config, err := h.DB.GetWANConfig(wanID)
+	if err != nil || config.DeviceID != id {
+		respondError(w, http.StatusNotFound, "WAN config not found")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, config)
+}
+
+// UpdateWANConfig updates a WAN configuration
+func (h *Handler) UpdateWANConfig(w http.ResponseWriter, r *http.Request) {
+	wanID := getPathInt64(r, "wanId")
+
+	var req wanConfigRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	config := req.config()
+
+	config.ID = wanID
+	config.DeviceID = getPathInt64(r, "id")
+	apply := r.URL.Query().Get("apply") == "true"
+	if apply {
+		if err := validateWANConfig(&config); err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	if err := h.DB.UpdateWANConfig(&config); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to update WAN config")
+		return
+	}
+
+	if apply {
+		if _, err := h.queueWANConfig(r, &config, getQueryInt(r, "wanIndex", 1)); err != nil {
+			respondError(w, http.StatusInternalServerError, "WAN config saved but failed to queue apply task")
+			return
+		}
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": "WAN configuration updated",
+	})
+}
+
+// DeleteWANConfig deletes a WAN configuration
+func (h *Handler) DeleteWANConfig(w http.ResponseWriter, r *http.Request) {
+	wanID := getPathInt64(r, "wanId")
+
+	if err := h.DB.DeleteWANConfig(wanID); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to delete WAN config")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]bool{"success": true})
+}
+
+// ============== Parameter Handlers ==============
+
+// GetDeviceParameters returns device parameters
+func (h *Handler) GetDeviceParameters(w http.ResponseWriter, r *http.Request) {
+	id := getPathInt64(r, "id")
+	prefix := r.URL.Query().Get("prefix")
+
+	params, err := h.DB.GetDeviceParameters(id, prefix)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get parameters")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, params)
+}
+
+// SetDeviceParameters sets device parameters
+func (h *Handler) SetDeviceParameters(w http.ResponseWriter, r *http.Request) {
+	id := getPathInt64(r, "id")
+
+	var params map[string]string
+	if err := decodeJSON(w, r, &params); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	paramsJSON, _ := json.Marshal(params)
+	task := &models.DeviceTask{
+		DeviceID:   id,
+		Type:       models.TaskSetParameterValues,
+		Parameters: paramsJSON,
+	}
+
+	created, err := h.DB.CreateTask(task)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to create parameter update task")
+		return
+	}
+
+	paths := make([]string, 0, len(params))
+	for path := range params {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	h.recordCommand(r, id, "set_parameters", created, strings.Join(paths, ", "))
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"taskId":  created.ID,
+		"message": fmt.Sprintf("Parameter update queued (%d parameters)", len(params)),
+	})
+}
+
+// GetDeviceParameter returns a specific parameter
+func (h *Handler) GetDeviceParameter(w http.ResponseWriter, r *http.Request) {
+	id := getPathInt64(r, "id")
+	path := mux.Vars(r)["path"]
+
+	params, err := h.DB.GetDeviceParameters(id, path)
+	if err != nil || len(params) == 0 {
+		respondError(w, http.StatusNotFound, "Parameter not found")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, params[0])
+}
+
+// GetParameterHistory returns the last ?limit= (default 50) value changes of
+// a device parameter
+func (h *Handler) GetParameterHistory(w http.ResponseWriter, r *http.Request) {
+	id := getPathInt64(r, "id")
+	path := mux.Vars(r)["path"]
+	limit := getQueryInt(r, "limit", 50)
+	if limit <= 0 || limit > 1000 {
+		limit = 50
+	}
+
+	changes, err := h.DB.GetParameterHistory(id, path, limit)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get parameter history")
+		return
+	}
+	respondJSON(w, http.StatusOK, changes)
+}
+
+// GetPinnedParameters lists pinned parameter config; ?model= returns the set
+// that applies to that model
+func (h *Handler) GetPinnedParameters(w http.ResponseWriter, r *http.Request) {
+	var pins []*models.PinnedParameter
+	var err error
+	if model := r.URL.Query().Get("model"); model != "" {
+		pins, err = h.DB.GetPinnedParametersForModel(model)
+	} else {
+		pins, err = h.DB.GetPinnedParameters()
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get pinned parameters")
+		return
+	}
+	respondJSON(w, http.StatusOK, pins)
+}
+
+// CreatePinnedParameter pins a parameter path for a model (or all models)
+func (h *Handler) CreatePinnedParameter(w http.ResponseWriter, r *http.Request) {
+	var pin models.PinnedParameter
+	if err := decodeJSON(w, r, &pin); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	pin.ModelName = strings.TrimSpace(pin.ModelName)
+	pin.Path = strings.TrimSpace(pin.Path)
+	pin.Label = strings.TrimSpace(pin.Label)
+	if !strings.HasPrefix(pin.Path, "InternetGatewayDevice.") && !strings.HasPrefix(pin.Path, "Device.") {
+		respondError(w, http.StatusBadRequest, "invalid parameter path")
+		return
+	}
+
+	created, err := h.DB.CreatePinnedParameter(&pin)
+	if err != nil {
+		respondError(w, http.StatusConflict, "Parameter is already pinned for this model")
+		return
+	}
+	respondJSON(w, http.StatusCreated, created)
+}
+
+// DeletePinnedParameter unpins a parameter
+func (h *Handler) DeletePinnedParameter(w http.ResponseWriter, r *http.Request) {
+	id := getPathInt64(r, "id")
+	if err := h.DB.DeletePinnedParameter(id); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to delete pinned parameter")
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]bool{"success": true})
+}
+
+// GetDevicePinnedParameters returns the pinned parameters for the device's
+// model with their current values; paths the device has not reported yet are
+// included with present=false
+func (h *Handler) GetDevicePinnedParameters(w http.ResponseWriter, r *http.Request) {
+	id := getPathInt64(r, "id")
+	device, err := h.DB.GetDevice(id)
+	if err != nil || device == nil {
+		respondError(w, http.StatusNotFound, "Device not found")
+		return
+	}
+
+	pins, err := h.DB.GetPinnedParametersForModel(device.ModelName)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get pinned parameters")
+		return
+	}
+	params, err := h.DB.GetDeviceParameters(id, "")
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get parameters")
+		return
+	}
+	byPath := make(map[string]*models.DeviceParameter, len(params))
+	for _, p := range params {
+		byPath[p.Path] = p
+	}
+
+	result := make([]map[string]interface{}, 0, len(pins))
+	for _, pin := range pins {
+		item := map[string]interface{}{
+			"path":    pin.Path,
+			"label":   pin.Label,
+			"present": false,
+		}
+		if p, ok := byPath[pin.Path]; ok {
+			item["present"] = true
+			item["value"] = p.Value
+			item["type"] = p.Type
+			item["writable"] = p.Writable
+			item["updatedAt"] = p.UpdatedAt
+		}
+		result = append(result, item)
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"success":    true,
+		"modelName":  device.ModelName,
+		"parameters": result,
+	})
+}
+
+// GetParameterWatches lists the parameter watches of a device, or of all
+// devices on /parameter-watches
+func (h *Handler) GetParameterWatches(w http.ResponseWriter, r *http.Request) {
+	var watches []*models.ParameterWatch
+	var err error
+	if id := getPathInt64(r, "id"); id > 0 {
+		watches, err = h.DB.GetDeviceParameterWatches(id)
+	} else {
+		watches, err = h.DB.GetParameterWatches()
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get parameter watches")
+		return
+	}
+	respondJSON(w, http.StatusOK, watches)
+}
+
+// CreateParameterWatch watches a device parameter: when the device reports a
+// new value, a log entry is written and operators are notified
+func (h *Handler) CreateParameterWatch(w http.ResponseWriter, r *http.Request) {
+	id := getPathInt64(r, "id")
+	if device, err := h.DB.GetDevice(id); err != nil || device == nil {
+		respondError(w, http.StatusNotFound, "Device not found")
+		return
+	}
+
+	var watch models.ParameterWatch
+	if err := decodeJSON(w, r, &watch); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	watch.DeviceID = id
+	watch.Path = strings.TrimSpace(watch.Path)
+	watch.Label = strings.TrimSpace(watch.Label)
+	if !strings.HasPrefix(watch.Path, "InternetGatewayDevice.") && !strings.HasPrefix(watch.Path, "Device.") {
+		respondError(w, http.StatusBadRequest, "invalid parameter path")
+		return
+	}
+
+	created, err := h.DB.CreateParameterWatch(&watch)
+	if err != nil {
+		respondError(w, http.StatusConflict, "Parameter is already watched on this device")
+		return
+	}
+	respondJSON(w, http.StatusCreated, created)
+}
+
+// DeleteParameterWatch removes a parameter watch
+func (h *Handler) DeleteParameterWatch(w http.ResponseWriter, r *http.Request) {
+	id := getPathInt64(r, "id")
+	if err := h.DB.DeleteParameterWatch(id); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to delete parameter watch")
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]bool{"success": true})
+}
+
+// NotifyParameterWatch logs a change of a watched parameter, pushes it to the
+// live feed and tells the operators via Telegram. Called by the TR-069 server.
+func (h *Handler) NotifyParameterWatch(device *models.Device, watch *models.ParameterWatch, change *models.ParameterChange) {
+	name := watch.Label
+	if name == "" {
+		name = watch.Path
+	}
+	h.DB.CreateLog(&device.ID, "info", "watch",
+		fmt.Sprintf("%s changed: %s -> %s", name, change.OldValue, change.NewValue), watch.Path)
+
+	if h.WSHub != nil {
+		h.WSHub.Broadcast(websocket.Message{
+			Type:     "parameter_changed",
+			DeviceID: device.ID,
+			Data: map[string]interface{}{
+				"watchId":  watch.ID,
+				"path":     watch.Path,
+				"label":    watch.Label,
+				"oldValue": change.OldValue,
+				"newValue": change.NewValue,
+			},
+		})
+	}
+
+	if h.Telegram != nil {
+		msg := fmt.Sprintf("🔔 <b>%s berubah</b>\nPerangkat: %s (%s)\n%s → %s",
+			template.HTMLEscapeString(name), template.HTMLEscapeString(h.DB.DeviceAlias(device.ID, h.Config.DeviceAliasFormat)),
+			template.HTMLEscapeString(device.SerialNumber), template.HTMLEscapeString(change.OldValue), template.HTMLEscapeString(change.NewValue))
+		if err := h.Telegram.SendMessage(msg); err != nil {
+			fmt.Printf("[WATCH] Telegram notification for %s failed: %v\n", device.SerialNumber, err)
+		}
+	}
+}
+
+// ============== Reboot Schedule Handlers ==============
+
+// GetRebootSchedules returns the reboot schedules of a device, or all of them
+func (h *Handler) GetRebootSchedules(w http.ResponseWriter, r *http.Request) {
+	var schedules []*models.RebootSchedule
+	var err error
+	if id := getPathInt64(r, "id"); id > 0 {
+		schedules, err = h.DB.GetDeviceRebootSchedules(id)
+	} else {
+		schedules, err = h.DB.GetRebootSchedules()
+	}
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get reboot schedules")
+		return
+	}
+	respondJSON(w, http.StatusOK, schedules)
+}
+
+// CreateRebootSchedule schedules recurring reboots from a cron spec, for the
+// device in the path or, on /reboot-schedules, for every device with a tag
+func (h *Handler) CreateRebootSchedule(w http.ResponseWriter, r *http.Request) {
+	schedule := models.RebootSchedule{Enabled: true}
+	if err := decodeJSON(w, r, &schedule); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	schedule.Spec = strings.TrimSpace(schedule.Spec)
+	schedule.Tag = strings.TrimSpace(schedule.Tag)
+	schedule.DeviceID = nil
+
+	if id := getPathInt64(r, "id"); id > 0 {
+		if device, err := h.DB.GetDevice(id); err != nil || device == nil {
+			respondError(w, http.StatusNotFound, "Device not found")
+			return
+		}
+		schedule.DeviceID = &id
+		schedule.Tag = ""
+	} else if schedule.Tag == "" {
+		respondError(w, http.StatusBadRequest, "tag is required")
+		return
+	}
+	if _, err := models.ParseCronSpec(schedule.Spec); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	created, err := h.DB.CreateRebootSchedule(&schedule)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to create reboot schedule")
+		return
+	}
+	respondJSON(w, http.StatusCreated, created)
+}
+
+// DeleteRebootSchedule removes a reboot schedule
+func (h *Handler) DeleteRebootSchedule(w http.ResponseWriter, r *http.Request) {
+	id := getPathInt64(r, "id")
+	if err := h.DB.DeleteRebootSchedule(id); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to delete reboot schedule")
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]bool{"success": true})
+}
+
+// RunRebootSchedules queues a reboot for every device whose schedule fires in
+// the minute of now. Offline devices are skipped rather than left with a
+// reboot that would run whenever they come back. Called by the scheduler
+// every minute.
+func (h *Handler) RunRebootSchedules(now time.Time) {
+	schedules, err := h.DB.GetEnabledRebootSchedules()
+	if err != nil {
+		fmt.Printf("[REBOOT] Error loading reboot schedules: %v\n", err)
+		return
+	}
+	minute := now.Truncate(time.Minute)
+	for _, rs := range schedules {
+		spec, err := models.ParseCronSpec(rs.Spec)
+		if err != nil || !spec.Matches(minute) {
+			continue
+		}
+		if ok, err := h.DB.ClaimRebootScheduleRun(rs.ID, minute); err != nil || !ok {
+			continue
+		}
+
+		var devices []*models.Device
+		if rs.DeviceID != nil {
+			if d, err := h.DB.GetDevice(*rs.DeviceID); err == nil && d != nil {
+				devices = append(devices, d)
+			}
+		} else if devices, _, err = h.DB.GetDevices("", "", "", rs.Tag, 100000, 0); err != nil {
+			fmt.Printf("[REBOOT] Schedule #%d: error loading devices tagged %q: %v\n", rs.ID, rs.Tag, err)
+			continue
+		}
+
+		queued, skipped := 0, 0
+		for _, d := range devices {
+			if d.Status != models.StatusOnline {
+				skipped++
+				h.DB.CreateLog(&d.ID, "info", "command",
+					fmt.Sprintf("Scheduled reboot skipped: device is %s", d.Status), fmt.Sprintf("schedule #%d", rs.ID))
+				continue
+			}
+			task, err := h.DB.CreateTask(&models.DeviceTask{DeviceID: d.ID, Type: models.TaskReboot})
+			if err != nil {
+				fmt.Printf("[REBOOT] Schedule #%d: failed to queue reboot for %s: %v\n", rs.ID, d.SerialNumber, err)
+				continue
+			}
+			queued++
+			h.DB.CreateLog(&d.ID, "info", "command", "Scheduled reboot queued", fmt.Sprintf("schedule #%d (%s)", rs.ID, rs.Spec))
+			if err := h.DB.RecordDeviceCommand(&models.DeviceCommand{
+				DeviceID: d.ID,
+				Command:  "reboot",
+				Username: "scheduler",
+				TaskID:   &task.ID,
+				Details:  fmt.Sprintf("schedule #%d", rs.ID),
+			}); err != nil {
+				fmt.Printf("[COMMAND] Failed to record reboot for device %d: %v\n", d.ID, err)
+			}
+		}
+		if queued > 0 || skipped > 0 {
+			fmt.Printf("[REBOOT] Schedule #%d: queued %d reboots, skipped %d offline devices\n", rs.ID, queued, skipped)
+		}
+	}
+}
+
+// ============== Firmware Handlers ==============
+
+// GetFirmwareInfo returns firmware information
+func (h *Handler) GetFirmwareInfo(w http.ResponseWriter, r *http.Request) {
+	id := getPathInt64(r, "id")
+
+	device, err := h.DB.GetDevice(id)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Device not found")
+		return
+	}
+
+	info := map[string]interface{}{
+		"currentVersion":  device.SoftwareVersion,
+		"hardwareVersion": device.HardwareVersion,
+		"updateAvailable": false,
+	}
+
+	latest, err := h.DB.GetLatestFirmware(device.Manufacturer, device.ProductClass)
+	if err == nil && device.SoftwareVersion != "" && models.CompareVersions(latest.Version, device.SoftwareVersion) > 0 {
+		info["updateAvailable"] = true
+		info["latestVersion"] = latest.Version
+		info["url"] = latest.URL
+		info["releaseNotes"] = latest.ReleaseNotes
+		info["firmwareId"] = latest.ID
+	}
+
+	respondJSON(w, http.StatusOK, info)
+}
+
+// GetFirmwares lists the firmware repository
+func (h *Handler) GetFirmwares(w http.ResponseWriter, r *http.Request) {
+	images, err := h.DB.GetFirmwares()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get firmware")
+		return
+	}
+	respondJSON(w, http.StatusOK, images)
+}
+
+// GetFirmwareAudit reports how many devices run each software version per
+// model, flagging versions older than the firmware repository's newest
+func (h *Handler) GetFirmwareAudit(w http.ResponseWriter, r *http.Request) {
+	counts, err := h.DB.GetFirmwareVersionCounts()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to count firmware versions")
+		return
+	}
+	catalog, err := h.DB.GetFirmwares()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get firmware")
+		return
+	}
+	respondJSON(w, http.StatusOK, models.BuildFirmwareAudit(counts, catalog))
+}
+
+// CreateFirmware adds a firmware image to the repository
+func (h *Handler) CreateFirmware(w http.ResponseWriter, r *http.Request) {
+	var f models.Firmware
+	if err := decodeJSON(w, r, &f); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	f.Manufacturer = strings.TrimSpace(f.Manufacturer)
+	f.ProductClass = strings.TrimSpace(f.ProductClass)
+	f.Version = strings.TrimSpace(f.Version)
+	f.URL = strings.TrimSpace(f.URL)
+	if f.Manufacturer == "" || f.Version == "" {
+		respondError(w, http.StatusBadRequest, "manufacturer and version are required")
+		return
+	}
+	if !strings.HasPrefix(f.URL, "http://") && !strings.HasPrefix(f.URL, "https://") && !strings.HasPrefix(f.URL, "ftp://") {
+		respondError(w, http.StatusBadRequest, "url must be an http, https or ftp URL")
+		return
+	}
+
+	created, err := h.DB.CreateFirmware(&f)
+	if err != nil {
+		respondError(w, http.StatusConflict, "This firmware version is already in the repository")
+		return
+	}
+	respondJSON(w, http.StatusCreated, created)
+}
+
+// DeleteFirmware removes a firmware image from the repository
+func (h *Handler) DeleteFirmware(w http.ResponseWriter, r *http.Request) {
+	id := getPathInt64(r, "id")
+	if err := h.DB.DeleteFirmware(id); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to delete firmware")
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]bool{"success": true})
+}
+
+// UpgradeFirmware starts a firmware upgrade
+func (h *Handler) UpgradeFirmware(w http.ResponseWriter, r *http.Request) {
+	id := getPathInt64(r, "id")
+
+	var req struct {
+		URL      string `json:"url"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if req.URL == "" {
+		respondError(w, http.StatusBadRequest, "Firmware URL is required")
+		return
+	}
+
+	paramsJSON, _ := json.Marshal(req)
+	task := &models.DeviceTask{
+		DeviceID:   id,
+		Type:       models.TaskDownload,
+		Parameters: paramsJSON,
+	}
+
+	created, err := h.DB.CreateTask(task)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to create firmware upgrade task")
+		return
+	}
+
+	h.DB.CreateLog(&id, "warning", "firmware", "Firmware upgrade initiated", req.URL)
+	h.recordCommand(r, id, "firmware", created, req.URL)
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"taskId":  created.ID,
+		"message": "Firmware upgrade started",
+	})
+}
+
+// BulkUpgradeFirmware queues a firmware download on every device matching a
+// filter. Devices are split into batches of batchSize; the first batch is
+// queued immediately and each following batch delaySeconds later, so a large
+// rollout doesn't pull the image onto every ONU at once. The upgrade is
+// stored and later batches are queued by ProcessFirmwareUpgrades.
+func (h *Handler) BulkUpgradeFirmware(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		DeviceIDs    []int64 `json:"deviceIds"`
+		Manufacturer string  `json:"manufacturer"`
+		ProductClass string  `json:"productClass"`
+		URL          string  `json:"url"`
+		FirmwareID   int64   `json:"firmwareId"` // Use an image from the firmware repository instead of url
+		Username     string  `json:"username"`
+		Password     string  `json:"password"`
+		BatchSize    int     `json:"batchSize"`    // 0 = all devices in one batch
+		DelaySeconds int     `json:"delaySeconds"` // Pause between batches, default 60
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var firmware *models.Firmware
+	if req.FirmwareID > 0 {
+		f, err := h.DB.GetFirmware(req.FirmwareID)
+		if err != nil {
+			respondError(w, http.StatusNotFound, "Firmware not found")
+			return
+		}
+		firmware = f
+		req.URL = f.URL
+	}
+	if req.URL == "" {
+		respondError(w, http.StatusBadRequest, "Firmware url or firmwareId is required")
+		return
+	}
+	if len(req.DeviceIDs) == 0 && req.Manufacturer == "" && req.ProductClass == "" {
+		respondError(w, http.StatusBadRequest, "deviceIds or a filter (manufacturer/productClass) is required")
+		return
+	}
+	if req.BatchSize < 0 || req.DelaySeconds < 0 {
+		respondError(w, http.StatusBadRequest, "batchSize and delaySeconds must not be negative")
+		return
+	}
+	if req.DelaySeconds == 0 {
+		req.DelaySeconds = 60
+	}
+
+	var devices []*models.Device
+	if len(req.DeviceIDs) > 0 {
+		for _, id := range req.DeviceIDs {
+			if d, err := h.DB.GetDevice(id); err == nil && d != nil {
+				devices = append(devices, d)
+			}
+		}
+	} else {
+		matched, err := h.DB.GetDevicesByModel(req.Manufacturer, req.ProductClass)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "Failed to fetch devices")
+			return
+		}
+		devices = matched
+	}
+
+	// With a repository image, devices already on that version or newer are skipped
+	skipped := 0
+	if firmware != nil {
+		targets := devices[:0]
+		for _, d := range devices {
+			if d.SoftwareVersion != "" && models.CompareVersions(firmware.Version, d.SoftwareVersion) <= 0 {
+				skipped++
+				continue
+			}
+			targets = append(targets, d)
+		}
+		devices = targets
+	}
+
+	if len(devices) == 0 {
+		respondJSON(w, http.StatusOK, map[string]interface{}{
+			"success": true,
+			"matched": 0,
+			"skipped": skipped,
+			"queued":  0,
+		})
+		return
+	}
+
+	batchSize := req.BatchSize
+	if batchSize == 0 || batchSize > len(devices) {
+		batchSize = len(devices)
+	}
+	var batches [][]int64
+	for start := 0; start < len(devices); start += batchSize {
+		end := start + batchSize
+		if end > len(devices) {
+			end = len(devices)
+		}
+		ids := make([]int64, 0, end-start)
+		for _, d := range devices[start:end] {
+			ids = append(ids, d.ID)
+		}
+		batches = append(batches, ids)
+	}
+
+	suffix, _ := rand.Int(rand.Reader, big.NewInt(10000))
+	params, _ := json.Marshal(map[string]string{
+		"url":      req.URL,
+		"username": req.Username,
+		"password": req.Password,
+	})
+	upgrade := &models.FirmwareUpgrade{
+		BatchID:      fmt.Sprintf("FW-%s-%04d", time.Now().Format("20060102-150405"), suffix.Int64()),
+		URL:          req.URL,
+		Parameters:   params,
+		DelaySeconds: req.DelaySeconds,
+		CreatedBy:    "system",
+	}
+	if claims := middleware.GetUserFromContext(r.Context()); claims != nil {
+		upgrade.UserID = &claims.UserID
+		upgrade.CreatedBy = claims.Username
+	}
+	created, err := h.DB.CreateFirmwareUpgrade(upgrade, batches)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to create bulk upgrade")
+		return
+	}
+
+	h.DB.CreateLog(nil, "warning", "firmware",
+		fmt.Sprintf("Bulk firmware upgrade %s: %d devices in %d batches of %d, %ds apart",
+			created.BatchID, len(devices), len(batches), batchSize, req.DelaySeconds), req.URL)
+	queued := h.queueFirmwareBatch(created)
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"success":   true,
+		"batchId":   created.BatchID,
+		"matched":   len(devices),
+		"skipped":   skipped,
+		"queued":    queued,
+		"scheduled": len(devices) - len(batches[0]),
+		"batches":   len(batches),
+	})
+}
+
+// ProcessFirmwareUpgrades queues the batches of bulk firmware upgrades whose
+// delay has passed
+func (h *Handler) ProcessFirmwareUpgrades(now time.Time) {
+	due, err := h.DB.GetDueFirmwareUpgrades(now)
+	if err != nil {
+		fmt.Printf("[FIRMWARE] Error loading bulk upgrades: %v\n", err)
+		return
+	}
+	for _, u := range due {
+		h.queueFirmwareBatch(u)
+	}
+}
+
+// queueFirmwareBatch creates the download tasks for the next batch of a bulk
+// upgrade, schedules the batch after it and returns how many were queued.
+// A batch already claimed (e.g. by a concurrent run) queues nothing.
+func (h *Handler) queueFirmwareBatch(u *models.FirmwareUpgrade) int {
+	n := u.NextBatch
+	var next *time.Time
+	if n < u.Batches {
+		at := time.Now().Add(time.Duration(u.DelaySeconds) * time.Second)
+		next = &at
+	}
+	if ok, err := h.DB.ClaimFirmwareUpgradeBatch(u.ID, n, next); err != nil || !ok {
+		return 0
+	}
+	ids, err := h.DB.GetFirmwareUpgradeDeviceIDs(u.ID, n)
+	if err != nil {
+		fmt.Printf("[FIRMWARE] Bulk upgrade %s: error loading batch %d: %v\n", u.BatchID, n, err)
+		return 0
+	}
+
+	queued := 0
+	for _, id := range ids {
+		d, err := h.DB.GetDevice(id)
+		if err != nil {
+			continue
+		}
+		task, err := h.DB.CreateTask(&models.DeviceTask{
+			DeviceID:   d.ID,
+			Type:       models.TaskDownload,
+			Parameters: u.Parameters,
+		})
+		if err != nil {
+			fmt.Printf("[FIRMWARE] Bulk upgrade %s: failed to queue device %d: %v\n", u.BatchID, d.ID, err)
+			continue
+		}
+		queued++
+		h.DB.SetFirmwareUpgradeDeviceTask(u.ID, d.ID, task.ID)
+		h.DB.CreateLog(&d.ID, "warning", "firmware",
+			fmt.Sprintf("Firmware upgrade queued (bulk %s, batch %d)", u.BatchID, n), u.URL)
+		h.DB.RecordDeviceCommand(&models.DeviceCommand{
+			DeviceID: d.ID,
+			Command:  "firmware",
+			TaskID:   &task.ID,
+			UserID:   u.UserID,
+			Username: u.CreatedBy,
+			Details:  fmt.Sprintf("%s (bulk %s)", u.URL, u.BatchID),
+		})
+		if h.ACS != nil {
+			go h.ACS.SendConnectionRequest(d)
+		}
+	}
+	if next == nil && u.Batches > 1 {
+		fmt.Printf("[FIRMWARE] Bulk upgrade %s: all %d batches queued\n", u.BatchID, u.Batches)
+	}
+	return queued
+}
+
+// GetDeviceStatusLogs returns uptime history logs for a device
+func (h *Handler) GetDeviceStatusLogs(w http.ResponseWriter, r *http.Request) {
+	id := getPathInt64(r, "id")
+	limit := getQueryInt(r, "limit", 50)
+
+	logs, err := h.DB.GetDeviceLogs(id, limit)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch logs")
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]interface{}{"success": true, "data": logs})
+}
+
+// GetDeviceInformEvents returns the event codes of recent informs, showing
+// why the device contacted the ACS (boot, periodic, value change, ...)
+func (h *Handler) GetDeviceInformEvents(w http.ResponseWriter, r *http.Request) {
+	id := getPathInt64(r, "id")
+	limit := getQueryInt(r, "limit", 50)
+	if limit < 1 || limit > 200 {
+		limit = 50
+	}
+
+	events, err := h.DB.GetInformEvents(id, limit)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch inform events")
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]interface{}{"success": true, "data": events})
+}
+
+// recordCommand adds a manual device action to the device's command history,
+// attributed to the admin whose token made the request
+func (h *Handler) recordCommand(r *http.Request, deviceID int64, command string, task *models.DeviceTask, details string) {
+	cmd := &models.DeviceCommand{
+		DeviceID: deviceID,
+		Command:  command,
+		Username: "system",
+		Details:  details,
+	}
+	if task != nil {
+		cmd.TaskID = &task.ID
+	}
+	if claims := middleware.GetUserFromContext(r.Context()); claims != nil {
+		cmd.UserID = &claims.UserID
+		cmd.Username = claims.Username
+	}
+	if err := h.DB.RecordDeviceCommand(cmd); err != nil {
+		fmt.Printf("[COMMAND] Failed to record %s for device %d: %v\n", command, deviceID, err)
+	}
+}
+
+// GetDeviceCommands returns the manual command history of a device
+func (h *Handler) GetDeviceCommands(w http.ResponseWriter, r *http.Request) {
+	id := getPathInt64(r, "id")
+	limit := getQueryInt(r, "limit", 50)
+	if limit < 1 || limit > 200 {
+		limit = 50
+	}
+
+	commands, err := h.DB.GetDeviceCommands(id, limit)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch command history")
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]interface{}{"success": true, "data": commands})
+}
+
+// GetDeviceReboots returns detected reboots per day for stability reporting
+func (h *Handler) GetDeviceReboots(w http.ResponseWriter, r *http.Request) {
+	id := getPathInt64(r, "id")
+	days := getQueryInt(r, "days", 7)
+	if days < 1 || days > 365 {
+		days = 7
+	}
+
+	counts, err := h.DB.GetDeviceRebootCounts(id, days)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch reboot counts")
+		return
+	}
+
+	total := 0
+	for _, c := range counts {
+		total += c.Count
+	}
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"days":    days,
+		"total":   total,
+		"data":    counts,
+	})
+}
+
+// ============== Task Handlers ==============
+
+// GetDeviceTasks returns tasks for a device
+func (h *Handler) GetDeviceTasks(w http.ResponseWriter, r *http.Request) {
+	id := getPathInt64(r, "id")
+
+	tasks, err := h.DB.GetPendingTasks(id)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get tasks")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, tasks)
+}
+
+// GetDevicePendingSummary summarizes everything queued for a device that it
+// will receive on its next session
+func (h *Handler) GetDevicePendingSummary(w http.ResponseWriter, r *http.Request) {
+	id := getPathInt64(r, "id")
+	if _, err := h.DB.GetDevice(id); err != nil {
+		respondError(w, http.StatusNotFound, "Device not found")
+		return
+	}
+
+	tasks, err := h.DB.GetPendingTasks(id)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get tasks")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, models.SummarizePendingTasks(id, tasks))
+}
+
+// CreateDeviceTask creates a new task
+func (h *Handler) CreateDeviceTask(w http.ResponseWriter, r *http.Request) {
+	id := getPathInt64(r, "id")
+
+	var req struct {
+		Type       models.TaskType `json:"type"`
+		Parameters json.RawMessage `json:"parameters"`
+		Priority   int             `json:"priority"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	task := models.DeviceTask{DeviceID: id, Type: req.Type, Parameters: req.Parameters, Priority: req.Priority}
+	created, err := h.DB.CreateTask(&task)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to create task")
+		return
+	}
+	h.recordCommand(r, id, string(created.Type), created, "")
+
+	respondJSON(w, http.StatusCreated, created)
+}
+
+// GetTask returns a specific task
+func (h *Handler) GetTask(w http.ResponseWriter, r *http.Request) {
+	taskID := getPathInt64(r, "taskId")
+	task, err := h.DB.GetTask(taskID)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Task not found")
+		return
+	}
+	respondJSON(w, http.StatusOK, task)
+}
+
+// RevertTask queues the parameter values captured before a SetParameterValues
+// task, rolling the device back to its last known good config
+func (h *Handler) RevertTask(w http.ResponseWriter, r *http.Request) {
+	taskID := getPathInt64(r, "taskId")
+
+	task, err := h.DB.GetTask(taskID)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Task not found")
+		return
+	}
+	if task.Type != models.TaskSetParameterValues {
+		respondError(w, http.StatusBadRequest, "Only setParameterValues tasks can be reverted")
+		return
+	}
+
+	var previous map[string]string
+	if len(task.PreviousValues) > 0 {
+		json.Unmarshal(task.PreviousValues, &previous)
+	}
+	if len(previous) == 0 {
+		respondError(w, http.StatusBadRequest, "No previous values were captured for this task")
+		return
+	}
+
+	// Report parameters written by the task that have no known previous value
+	var written map[string]interface{}
+	json.Unmarshal(task.Parameters, &written)
+	skipped := []string{}
+	for path := range written {
+		if _, ok := previous[path]; !ok {
+			skipped = append(skipped, path)
+		}
+	}
+	sort.Strings(skipped)
+
+	paramsJSON, _ := json.Marshal(previous)
+	revert, err := h.DB.CreateTask(&models.DeviceTask{
+		DeviceID:   task.DeviceID,
+		Type:       models.TaskSetParameterValues,
+		Parameters: paramsJSON,
+	})
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to queue revert task")
+		return
+	}
+
+	h.DB.CreateLog(&task.DeviceID, "info", "task",
+		fmt.Sprintf("Reverting task %d: restoring %d parameter(s)", task.ID, len(previous)), string(paramsJSON))
+	h.recordCommand(r, task.DeviceID, "revert", revert, fmt.Sprintf("task %d", task.ID))
+
+	if device, err := h.DB.GetDevice(task.DeviceID); err == nil && h.ACS != nil {
+		go h.ACS.SendConnectionRequest(device)
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"taskId":  revert.ID,
+		"values":  previous,
+		"skipped": skipped,
+	})
+}
+
+// CloneDeviceConfig copies the WiFi, WAN/PPPoE and LAN configuration of a
+// source device to a target, e.g. when a customer's ONT is swapped. Values
+// come from the source's reported parameters, overlaid with what was last
+// written to it so secrets the device reports empty are copied too. One
+// SetParameterValues task is queued per category, each revertible on its own.
+// Devices from different manufacturers use different parameter paths, so
+// cloning between them is refused unless force is set.
+func (h *Handler) CloneDeviceConfig(w http.ResponseWriter, r *http.Request) {
+	targetID := getPathInt64(r, "targetId")
+	sourceID := getPathInt64(r, "sourceId")
+	if targetID == sourceID {
+		respondError(w, http.StatusBadRequest, "Source and target must be different devices")
+		return
+	}
+
+	var req struct {
+		Force      bool     `json:"force"`
+		Categories []string `json:"categories"` // wifi, wan, lan; default all
+	}
+	if r.ContentLength > 0 {
+		if err := decodeJSON(w, r, &req); err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if r.URL.Query().Get("force") == "true" {
+		req.Force = true
+	}
+	if len(req.Categories) == 0 {
+		req.Categories = models.CloneCategories
+	}
+	categories := make(map[string]bool)
+	for _, c := range req.Categories {
+		c = strings.ToLower(strings.TrimSpace(c))
+		if c != "wifi" && c != "wan" && c != "lan" {
+			respondError(w, http.StatusBadRequest, fmt.Sprintf("Unknown category %q (use wifi, wan or lan)", c))
+			return
+		}
+		categories[c] = true
+	}
+
+	target, err := h.DB.GetDevice(targetID)
+	if err != nil || target == nil {
+		respondError(w, http.StatusNotFound, "Target device not found")
+		return
+	}
+	source, err := h.DB.GetDevice(sourceID)
+	if err != nil || source == nil {
+		respondError(w, http.StatusNotFound, "Source device not found")
+		return
+	}
+	if !req.Force && !sameManufacturer(source, target) {
+		respondError(w, http.StatusConflict, fmt.Sprintf(
+			"Source is a %s device and target is a %s device; parameter paths won't match (set force to clone anyway)",
+			source.Manufacturer, target.Manufacturer))
+		return
+	}
+
+	plan, missing, err := h.clonePlan(sourceID, targetID, categories)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to read source configuration")
+		return
+	}
+	if len(plan) == 0 {
+		respondJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{
+			"success": false,
+			"error":   "Source device has no settings to clone to this target",
+			"missing": missing,
+		})
+		return
+	}
+
+	tasks, count, err := h.queueClone(r, source, target, plan)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to queue clone tasks")
+		return
+	}
+
+	if h.ACS != nil {
+		go h.ACS.SendConnectionRequest(target)
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"success":    true,
+		"taskIds":    tasks,
+		"parameters": count,
+		"missing":    missing,
+	})
+}
+
+// sameManufacturer reports whether two devices come from the same vendor and
+// so share parameter paths
+func sameManufacturer(a, b *models.Device) bool {
+	return strings.EqualFold(strings.TrimSpace(a.Manufacturer), strings.TrimSpace(b.Manufacturer))
+}
+
+// clonePlan collects the configuration to copy from one device to another
+// (see models.ClonePlan). Source values are its reported parameters overlaid
+// with what was last written to it, so secrets the device reports empty are
+// included; writes are limited to paths the target has once it has reported.
+func (h *Handler) clonePlan(sourceID, targetID int64, categories map[string]bool) (map[string]map[string]string, []string, error) {
+	params, err := h.DB.GetDeviceParameters(sourceID, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	values := make(map[string]string, len(params))
+	for _, p := range params {
+		values[p.Path] = p.Value
+	}
+	written, err := h.DB.GetWrittenParameterValues(sourceID)
+	if err != nil {
+		return nil, nil, err
+	}
+	for path, value := range written {
+		if value != "" {
+			values[path] = value
+		}
+	}
+
+	var targetPaths map[string]bool
+	if targetParams, err := h.DB.GetDeviceParameters(targetID, ""); err == nil && len(targetParams) > 0 {
+		targetPaths = make(map[string]bool, len(targetParams))
+		for _, p := range targetParams {
+			targetPaths[p.Path] = true
+		}
+	}
+
+	plan, missing := models.ClonePlan(values, targetPaths, categories)
+	return plan, missing, nil
+}
+
+// queueClone queues one SetParameterValues task per category of a clone plan
+// on target, returning the task IDs by category and the parameter count
+func (h *Handler) queueClone(r *http.Request, source, target *models.Device, plan map[string]map[string]string) (map[string]int64, int, error) {
+	tasks := make(map[string]int64)
+	count := 0
+	for _, category := range models.CloneCategories {
+		writes := plan[category]
+		if len(writes) == 0 {
+			continue
+		}
+		paramsJSON, _ := json.Marshal(writes)
+		created, err := h.DB.CreateTask(&models.DeviceTask{
+			DeviceID:   target.ID,
+			Type:       models.TaskSetParameterValues,
+			Parameters: paramsJSON,
+		})
+		if err != nil {
+			return tasks, count, fmt.Errorf("queue %s clone task: %w", category, err)
+		}
+		tasks[category] = created.ID
+		count += len(writes)
+
+		h.DB.CreateLog(&target.ID, "info", "command",
+			fmt.Sprintf("Cloning %s config from %s: %d parameter(s)", category, source.SerialNumber, len(writes)), "")
+		h.recordCommand(r, target.ID, "clone", created, fmt.Sprintf("%s from %s", category, source.SerialNumber))
+	}
+	return tasks, count, nil
+}
+
+// ReplaceDevice swaps a dead device for a new unit identified by serial. The
+// customer assignment, PPPoE username, location and label move to the new
+// device, the old device's WiFi/WAN/LAN config is queued onto it (as with
+// CloneDeviceConfig) and the old device is retired. A replacement from
+// another manufacturer needs force to copy the config, or skipConfig to
+// transfer the site without it.
+func (h *Handler) ReplaceDevice(w http.ResponseWriter, r *http.Request) {
+	oldID := getPathInt64(r, "oldId")
+
+	var req struct {
+		NewSerial  string `json:"newSerial"`
+		Force      bool   `json:"force"`
+		SkipConfig bool   `json:"skipConfig"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	req.NewSerial = strings.TrimSpace(req.NewSerial)
+	if req.NewSerial == "" {
+		respondError(w, http.StatusBadRequest, "newSerial is required")
+		return
+	}
+
+	old, err := h.DB.GetDevice(oldID)
+	if err != nil || old == nil {
+		respondError(w, http.StatusNotFound, "Device not found")
+		return
+	}
+	if old.LifecycleState == models.LifecycleRetired {
+		respondError(w, http.StatusConflict, "Device is already retired")
+		return
+	}
+	replacement, err := h.DB.GetDeviceBySerial(req.NewSerial)
+	if err != nil || replacement == nil {
+		respondError(w, http.StatusNotFound,
+			fmt.Sprintf("Device %s not found; power it on so it contacts the ACS first", req.NewSerial))
+		return
+	}
+	if replacement.ID == old.ID {
+		respondError(w, http.StatusBadRequest, "A device cannot replace itself")
+		return
+	}
+	if replacement.LifecycleState == models.LifecycleRetired {
+		respondError(w, http.StatusConflict, fmt.Sprintf("Device %s is retired", replacement.SerialNumber))
+		return
+	}
+	if replacement.CustomerID != nil && (old.CustomerID == nil || *replacement.CustomerID != *old.CustomerID) {
+		respondError(w, http.StatusConflict, fmt.Sprintf("Device %s is assigned to another customer", replacement.SerialNumber))
+		return
+	}
+	if !req.SkipConfig && !req.Force && !sameManufacturer(old, replacement) {
+		respondError(w, http.StatusConflict, fmt.Sprintf(
+			"Old device is a %s and the replacement a %s; parameter paths won't match (set force to copy the config anyway, or skipConfig to transfer without it)",
+			old.Manufacturer, replacement.Manufacturer))
+		return
+	}
+
+	var plan map[string]map[string]string
+	missing := []string{}
+	if !req.SkipConfig {
+		all := make(map[string]bool)
+		for _, c := range models.CloneCategories {
+			all[c] = true
+		}
+		if plan, missing, err = h.clonePlan(old.ID, replacement.ID, all); err != nil {
+			respondError(w, http.StatusInternalServerError, "Failed to read the old device's configuration")
+			return
+		}
+	}
+
+	if err := h.DB.ReplaceDevice(old.ID, replacement.ID); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to replace device")
+		return
+	}
+
+	tasks, count, err := h.queueClone(r, old, replacement, plan)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Device replaced, but queueing its configuration failed")
+		return
+	}
+
+	msg := fmt.Sprintf("Device %s replaced by %s", old.SerialNumber, replacement.SerialNumber)
+	h.DB.CreateLog(&old.ID, "info", "device", msg+", retired", "")
+	h.DB.CreateLog(&replacement.ID, "info", "device", msg, fmt.Sprintf("%d parameter(s) copied", count))
+	h.recordCommand(r, old.ID, "replace", nil, "by "+replacement.SerialNumber)
+	h.recordCommand(r, replacement.ID, "replace", nil, "of "+old.SerialNumber)
+
+	if h.ACS != nil && len(tasks) > 0 {
+		go h.ACS.SendConnectionRequest(replacement)
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"success":    true,
+		"deviceId":   replacement.ID,
+		"customerId": old.CustomerID,
+		"taskIds":    tasks,
+		"parameters": count,
+		"missing":    missing,
+	})
+}
+
+// RetryTask requeues a failed task and asks the device to connect so it is
+// picked up right away
+func (h *Handler) RetryTask(w http.ResponseWriter, r *http.Request) {
+	taskID := getPathInt64(r, "taskId")
+	task, err := h.DB.GetTask(taskID)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Task not found")
+		return
+	}
+
+	if err := h.DB.RetryTask(taskID); err != nil {
+		switch {
+		case errors.Is(err, database.ErrTaskNotFailed):
+			respondError(w, http.StatusConflict, fmt.Sprintf("Only failed tasks can be retried (task is %s)", task.Status))
+		case errors.Is(err, sql.ErrNoRows):
+			respondError(w, http.StatusNotFound, "Task not found")
+		default:
+			respondError(w, http.StatusInternalServerError, "Failed to retry task")
+		}
+		return
+	}
+
+	h.DB.CreateLog(&task.DeviceID, "info", "task", fmt.Sprintf("Failed %s task %d requeued", task.Type, task.ID), task.Error)
+	if device, err := h.DB.GetDevice(task.DeviceID); err == nil && h.ACS != nil {
+		go h.ACS.SendConnectionRequest(device)
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"success":    true,
+		"taskId":     task.ID,
+		"retryCount": task.RetryCount + 1,
+	})
+}
+
+// RequeueFailedTasks requeues failed tasks that have retries left under
+// MAX_TASK_RETRIES and wakes the affected devices
+func (h *Handler) RequeueFailedTasks() {
+	if h.Config == nil || h.Config.MaxTaskRetries <= 0 {
+		return
+	}
+	deviceIDs, err := h.DB.RequeueFailedTasks(h.Config.MaxTaskRetries, time.Unix(0, h.taskRetriesSince.Load()))
+	if err != nil {
+		fmt.Printf("[TASK] Error requeueing failed tasks: %v\n", err)
+		return
+	}
+	if len(deviceIDs) == 0 {
+		return
+	}
+	fmt.Printf("[TASK] Requeued failed tasks for %d device(s)\n", len(deviceIDs))
+	if h.ACS == nil {
+		return
+	}
+	for _, id := range deviceIDs {
+		if device, err := h.DB.GetDevice(id); err == nil {
+			go h.ACS.SendConnectionRequest(device)
+		}
+	}
+}
+
+// DeleteTask cancels a task that has not been sent to the device yet
+func (h *Handler) DeleteTask(w http.ResponseWriter, r *http.Request) {
+	taskID := getPathInt64(r, "taskId")
+	task, err := h.DB.GetTask(taskID)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Task not found")
+		return
+	}
+
+	if err := h.DB.DeleteTask(taskID); err != nil {
+		switch {
+		case errors.Is(err, database.ErrTaskNotPending):
+			current, _ := h.DB.GetTask(taskID)
+			if current != nil {
+				task = current
+			}
+			respondError(w, http.StatusConflict, fmt.Sprintf("Task is already %s and can no longer be cancelled", task.Status))
+		case errors.Is(err, sql.ErrNoRows):
+			respondError(w, http.StatusNotFound, "Task not found")
+		default:
+			respondError(w, http.StatusInternalServerError, "Failed to delete task")
+		}
+		return
+	}
+
+	h.DB.CreateLog(&task.DeviceID, "info", "task", fmt.Sprintf("Pending %s task %d cancelled", task.Type, task.ID), string(task.Parameters))
+	respondJSON(w, http.StatusOK, map[string]bool{"success": true})
+}
+
+// ============== Preset Handlers ==============
+
+// GetPresets returns all presets ordered by weight
+func (h *Handler) GetPresets(w http.ResponseWriter, r *http.Request) {
+	presets, err := h.DB.GetPresets()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get presets")
+		return
+	}
+	respondJSON(w, http.StatusOK, presets)
+}
+
+// validatePreset checks a preset's name and JSON documents
+func validatePreset(p *models.Preset) error {
+	p.Name = strings.TrimSpace(p.Name)
+	if p.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if len(p.Filter) > 0 && !json.Valid(p.Filter) {
+		return fmt.Errorf("filter must be valid JSON")
+	}
+	if len(p.Provisions) > 0 && !json.Valid(p.Provisions) {
+		return fmt.Errorf("provisions must be valid JSON")
+	}
+	if len(p.Filter) > 0 {
+		var f models.PresetFilter
+		if err := json.Unmarshal(p.Filter, &f); err != nil {
+			return fmt.Errorf("filter must be an object of manufacturer, productClass, modelName, softwareVersion, tag")
+		}
+	}
+	if _, err := p.ParameterValues(); err != nil {
+		return err
+	}
+	events := []string{}
+	for _, e := range p.Events {
+		if e = strings.TrimSpace(e); e != "" {
+			events = append(events, e)
+		}
+	}
+	p.Events = events
+	return nil
+}
+
+// CreatePreset creates a new preset
+func (h *Handler) CreatePreset(w http.ResponseWriter, r *http.Request) {
+	preset := models.Preset{Enabled: true}
+	if err := decodeJSON(w, r, &preset); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := validatePreset(&preset); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	created, err := h.DB.CreatePreset(&preset)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to create preset, the name may already exist")
+		return
+	}
+	respondJSON(w, http.StatusCreated, created)
+}
+
+// GetPreset returns a specific preset
+func (h *Handler) GetPreset(w http.ResponseWriter, r *http.Request) {
+	id := getPathInt64(r, "id")
+	preset, err := h.DB.GetPreset(id)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Preset not found")
+		return
+	}
+	respondJSON(w, http.StatusOK, preset)
+}
+
+// UpdatePreset updates a preset
+func (h *Handler) UpdatePreset(w http.ResponseWriter, r *http.Request) {
+	id := getPathInt64(r, "id")
+	preset, err := h.DB.GetPreset(id)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Preset not found")
+		return
+	}
+	if err := decodeJSON(w, r, preset); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	preset.ID = id
+	if err := validatePreset(preset); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := h.DB.UpdatePreset(preset); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to update preset")
+		return
+	}
+	updated, err := h.DB.GetPreset(id)
+	if err != nil {
+		respondJSON(w, http.StatusOK, preset)
+		return
+	}
+	respondJSON(w, http.StatusOK, updated)
+}
+
+// DeletePreset deletes a preset
+func (h *Handler) DeletePreset(w http.ResponseWriter, r *http.Request) {
+	id := getPathInt64(r, "id")
+	if err := h.DB.DeletePreset(id); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to delete preset")
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]bool{"success": true})
+}
+
+// ============== Rollout Handlers ==============
+
+// GetRollouts returns the most recent provisioning rollouts
+func (h *Handler) GetRollouts(w http.ResponseWriter, r *http.Request) {
+	limit := getQueryInt(r, "limit", 50)
+	if limit < 1 || limit > 500 {
+		limit = 50
+	}
+	rollouts, err := h.DB.GetRollouts(limit)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get rollouts")
+		return
+	}
+	respondJSON(w, http.StatusOK, rollouts)
+}
+
+// GetRollout returns a rollout with the current health of its canary and control groups
+func (h *Handler) GetRollout(w http.ResponseWriter, r *http.Request) {
+	ro, err := h.DB.GetRollout(getPathInt64(r, "id"))
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Rollout not found")
+		return
+	}
+	canary, control, err := h.DB.GetRolloutHealth(ro.ID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get rollout health")
+		return
+	}
+	ro.Canary, ro.Control = &canary, &control
+	respondJSON(w, http.StatusOK, ro)
+}
+
+// CreateRollout starts a canary rollout: the parameter values (given directly
+// or taken from a preset) are queued on a sample of the online devices
+// matching the filter. The scheduler queues them on the rest once the wait is
+// over, unless the canary's failure rate is elevated.
+func (h *Handler) CreateRollout(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name              string            `json:"name"`
+		Parameters        map[string]string `json:"parameters"`
+		PresetID          int64             `json:"presetId"` // Use a preset's provisions instead of parameters
+		Filter            deviceFilter      `json:"filter"`
+		CanaryPercent     int               `json:"canaryPercent"`     // Default 10
+		WaitMinutes       int               `json:"waitMinutes"`       // Default 30
+		MaxFailurePercent *float64          `json:"maxFailurePercent"` // Default 10
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if req.PresetID > 0 {
+		preset, err := h.DB.GetPreset(req.PresetID)
+		if err != nil {
+			respondError(w, http.StatusNotFound, "Preset not found")
+			return
+		}
+		values, err := preset.ParameterValues()
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if len(req.Parameters) == 0 {
+			req.Parameters = values
+		}
+		if req.Name == "" {
+			req.Name = preset.Name
+		}
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		respondError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+	if len(req.Parameters) == 0 {
+		respondError(w, http.StatusBadRequest, "parameters or presetId is required")
+		return
+	}
+	if req.Filter.empty() {
+		respondError(w, http.StatusBadRequest, "filter needs deviceIds or status/tag/manufacturer")
+		return
+	}
+	if req.CanaryPercent == 0 {
+		req.CanaryPercent = 10
+	}
+	if req.WaitMinutes == 0 {
+		req.WaitMinutes = 30
+	}
+	maxFailure := 10.0
+	if req.MaxFailurePercent != nil {
+		maxFailure = *req.MaxFailurePercent
+	}
+	if req.CanaryPercent < 1 || req.CanaryPercent > 50 {
+		respondError(w, http.StatusBadRequest, "canaryPercent must be between 1 and 50")
+		return
+	}
+	if req.WaitMinutes < 1 || req.WaitMinutes > 1440 {
+		respondError(w, http.StatusBadRequest, "waitMinutes must be between 1 and 1440")
+		return
+	}
+	if maxFailure < 0 || maxFailure > 100 {
+		respondError(w, http.StatusBadRequest, "maxFailurePercent must be between 0 and 100")
+		return
+	}
+
+	devices, err := h.selectDevices(req.Filter)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch devices")
 		return
 	}
-	respondJSON(w, http.StatusOK, map[string]interface{}{"success": true, "data": logs})
-}
-
-// ============== Task Handlers ==============
 
-// GetDeviceTasks returns tasks for a device
-func (h *Handler) GetDeviceTasks(w http.ResponseWriter, r *http.Request) {
+	// The canary is drawn from online devices only, spread evenly over the
+	// list, since offline ones can't apply the change within the wait
+	online := make(map[int64]bool)
+	var onlineDevices []*models.Device
+	for _, d := range devices {
+		if d.Status == models.StatusOnline {
+			online[d.ID] = true
+			onlineDevices = append(onlineDevices, d)
+		}
+	}
+	if len(onlineDevices) == 0 {
+		respondError(w, http.StatusUnprocessableEntity, fmt.Sprintf("None of the %d matched devices is online to run a canary on", len(devices)))
+		return
+	}
+	size := models.CanarySize(len(devices), req.CanaryPercent)
+	if size > len(onlineDevices) {
+		size = len(onlineDevices)
+	}
+	inCanary := make(map[int64]bool, size)
+	var canary []*models.Device
+	for i := 0; i < size; i++ {
+		d := onlineDevices[i*len(onlineDevices)/size]
+		inCanary[d.ID] = true
+		canary = append(canary, d)
+	}
+	canaryIDs := make([]int64, 0, len(canary))
+	for _, d := range canary {
+		canaryIDs = append(canaryIDs, d.ID)
+	}
+	var restIDs []int64
+	for _, d := range devices {
+		if !inCanary[d.ID] {
+			restIDs = append(restIDs, d.ID)
+		}
+	}
+
+	filterJSON, _ := json.Marshal(req.Filter)
+	ro := &models.Rollout{
+		Name:              req.Name,
+		Parameters:        req.Parameters,
+		Filter:            filterJSON,
+		CanaryPercent:     req.CanaryPercent,
+		WaitMinutes:       req.WaitMinutes,
+		MaxFailurePercent: maxFailure,
+		CreatedBy:         "system",
+		CanaryUntil:       time.Now().Add(time.Duration(req.WaitMinutes) * time.Minute),
+	}
+	var userID *int64
+	if claims := middleware.GetUserFromContext(r.Context()); claims != nil {
+		ro.CreatedBy = claims.Username
+		userID = &claims.UserID
+	}
+
+	created, err := h.DB.CreateRollout(ro, canaryIDs, restIDs, online)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to create rollout")
+		return
+	}
+	queued := h.queueRolloutTasks(created, canary, userID)
+
+	h.DB.CreateLog(nil, "info", "rollout",
+		fmt.Sprintf("Rollout #%d %q started: canary of %d/%d devices, deciding in %d minutes",
+			created.ID, created.Name, queued, len(devices), created.WaitMinutes), string(filterJSON))
+
+	respondJSON(w, http.StatusCreated, map[string]interface{}{
+		"rollout": created,
+		"matched": len(devices),
+		"canary":  len(canary),
+		"queued":  queued,
+	})
+}
+
+// AbortRollout stops a rollout during its canary phase and cancels the
+// canary tasks that have not reached their device yet
+func (h *Handler) AbortRollout(w http.ResponseWriter, r *http.Request) {
+	ro, err := h.DB.GetRollout(getPathInt64(r, "id"))
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Rollout not found")
+		return
+	}
+	username := "system"
+	if claims := middleware.GetUserFromContext(r.Context()); claims != nil {
+		username = claims.Username
+	}
+	if !h.abortRollout(ro, "aborted by "+username) {
+		respondError(w, http.StatusConflict, fmt.Sprintf("Rollout is already %s", ro.Status))
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]bool{"success": true})
+}
+
+// queueRolloutTasks queues the rollout's parameter values on devices and
+// returns how many were queued
+func (h *Handler) queueRolloutTasks(ro *models.Rollout, devices []*models.Device, userID *int64) int {
+	params, _ := json.Marshal(ro.Parameters)
+	paths := make([]string, 0, len(ro.Parameters))
+	for path := range ro.Parameters {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	details := fmt.Sprintf("%s (rollout #%d)", strings.Join(paths, ", "), ro.ID)
+
+	queued := 0
+	for _, d := range devices {
+		task, err := h.DB.CreateTask(&models.DeviceTask{
+			DeviceID:   d.ID,
+			Type:       models.TaskSetParameterValues,
+			Parameters: params,
+		})
+		if err != nil {
+			fmt.Printf("[ROLLOUT] #%d: failed to queue device %d: %v\n", ro.ID, d.ID, err)
+			continue
+		}
+		queued++
+		h.DB.SetRolloutDeviceTask(ro.ID, d.ID, task.ID)
+		h.DB.RecordDeviceCommand(&models.DeviceCommand{
+			DeviceID: d.ID,
+			Command:  "set_parameters",
+			TaskID:   &task.ID,
+			UserID:   userID,
+			Username: ro.CreatedBy,
+			Details:  details,
+		})
+		if h.ACS != nil {
+			go h.ACS.SendConnectionRequest(d)
+		}
+	}
+	return queued
+}
+
+// abortRollout marks a rollout aborted and cancels its pending tasks. It
+// returns false when the rollout was already decided.
+func (h *Handler) abortRollout(ro *models.Rollout, reason string) bool {
+	ok, err := h.DB.FinishRollout(ro.ID, models.RolloutAborted, reason)
+	if err != nil || !ok {
+		return false
+	}
+	cancelled := 0
+	if ids, err := h.DB.GetRolloutPendingTaskIDs(ro.ID); err == nil {
+		for _, id := range ids {
+			if h.DB.DeleteTask(id) == nil {
+				cancelled++
+			}
+		}
+	}
+	h.DB.CreateLog(nil, "error", "rollout",
+		fmt.Sprintf("Rollout #%d %q aborted, %d pending tasks cancelled", ro.ID, ro.Name, cancelled), reason)
+	return true
+}
+
+// ProcessRollouts decides the rollouts whose canary wait is over: the rest of
+// the devices get the change when the canary is healthy, otherwise the
+// rollout aborts and the operator is alerted. Called by the scheduler.
+func (h *Handler) ProcessRollouts() {
+	due, err := h.DB.GetDueRollouts(time.Now())
+	if err != nil {
+		fmt.Printf("[ROLLOUT] Error loading rollouts: %v\n", err)
+		return
+	}
+	for _, ro := range due {
+		canary, control, err := h.DB.GetRolloutHealth(ro.ID)
+		if err != nil {
+			fmt.Printf("[ROLLOUT] #%d: error reading health: %v\n", ro.ID, err)
+			continue
+		}
+
+		proceed, reason := models.CanaryVerdict(canary, control, ro.MaxFailurePercent)
+		if !proceed {
+			if h.abortRollout(ro, reason) && h.Telegram != nil {
+				msg := fmt.Sprintf("🛑 <b>Rollout dibatalkan</b>\n%s (#%d)\n%s",
+					template.HTMLEscapeString(ro.Name), ro.ID, template.HTMLEscapeString(reason))
+				if err := h.Telegram.SendMessage(msg); err != nil {
+					fmt.Printf("[ROLLOUT] Telegram notification for #%d failed: %v\n", ro.ID, err)
+				}
+			}
+			continue
+		}
+
+		if ok, err := h.DB.FinishRollout(ro.ID, models.RolloutCompleted, reason); err != nil || !ok {
+			continue
+		}
+		ids, err := h.DB.GetRolloutDeviceIDs(ro.ID, database.RolloutPhaseRest)
+		if err != nil {
+			fmt.Printf("[ROLLOUT] #%d: error loading devices: %v\n", ro.ID, err)
+			continue
+		}
+		var rest []*models.Device
+		for _, id := range ids {
+			if d, err := h.DB.GetDevice(id); err == nil {
+				rest = append(rest, d)
+			}
+		}
+		queued := h.queueRolloutTasks(ro, rest, nil)
+		h.DB.CreateLog(nil, "info", "rollout",
+			fmt.Sprintf("Rollout #%d %q passed its canary, queued on %d more devices", ro.ID, ro.Name, queued), reason)
+	}
+}
+
+// ============== Poll Profile Handlers ==============
+
+// GetPollProfiles returns all parameter poll profiles
+func (h *Handler) GetPollProfiles(w http.ResponseWriter, r *http.Request) {
+	profiles, err := h.DB.GetPollProfiles()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get poll profiles")
+		return
+	}
+	respondJSON(w, http.StatusOK, profiles)
+}
+
+// validatePollProfile checks a poll profile and normalizes its paths
+func validatePollProfile(p *models.PollProfile) error {
+	p.Name = strings.TrimSpace(p.Name)
+	p.Manufacturer = strings.TrimSpace(p.Manufacturer)
+	p.ModelName = strings.TrimSpace(p.ModelName)
+	if p.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if p.Manufacturer == "" {
+		return fmt.Errorf("manufacturer is required")
+	}
+	if strings.Count(p.ModelName, "*") > 1 || (strings.Contains(p.ModelName, "*") && !strings.HasSuffix(p.ModelName, "*")) {
+		return fmt.Errorf("modelName wildcard is only allowed as a trailing *")
+	}
+
+	paths := []string{}
+	seen := make(map[string]bool)
+	for _, path := range p.Paths {
+		path = strings.TrimSpace(path)
+		if path == "" || seen[path] {
+			continue
+		}
+		if !strings.HasPrefix(path, "InternetGatewayDevice.") && !strings.HasPrefix(path, "Device.") {
+			return fmt.Errorf("invalid parameter path %q", path)
+		}
+		seen[path] = true
+		paths = append(paths, path)
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("at least one parameter path is required")
+	}
+	p.Paths = paths
+	return nil
+}
+
+// CreatePollProfile creates a parameter poll profile for a device model
+func (h *Handler) CreatePollProfile(w http.ResponseWriter, r *http.Request) {
+	profile := models.PollProfile{Enabled: true}
+	if err := decodeJSON(w, r, &profile); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := validatePollProfile(&profile); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	created, err := h.DB.CreatePollProfile(&profile)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to create poll profile")
+		return
+	}
+	respondJSON(w, http.StatusCreated, created)
+}
+
+// UpdatePollProfile updates a parameter poll profile
+func (h *Handler) UpdatePollProfile(w http.ResponseWriter, r *http.Request) {
 	id := getPathInt64(r, "id")
+	profile, err := h.DB.GetPollProfile(id)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Poll profile not found")
+		return
+	}
+	if err := decodeJSON(w, r, profile); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	profile.ID = id
+	if err := validatePollProfile(profile); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
-	tasks, err := h.DB.GetPendingTasks(id)
+	if err := h.DB.UpdatePollProfile(profile); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to update poll profile")
+		return
+	}
+	respondJSON(w, http.StatusOK, profile)
+}
+
+// DeletePollProfile deletes a parameter poll profile
+func (h *Handler) DeletePollProfile(w http.ResponseWriter, r *http.Request) {
+	id := getPathInt64(r, "id")
+	if err := h.DB.DeletePollProfile(id); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to delete poll profile")
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]bool{"success": true})
+}
+
+// GetDevicePollProfile shows which poll profile applies to a device
+func (h *Handler) GetDevicePollProfile(w http.ResponseWriter, r *http.Request) {
+	id := getPathInt64(r, "id")
+	device, err := h.DB.GetDevice(id)
+	if err != nil || device == nil {
+		respondError(w, http.StatusNotFound, "Device not found")
+		return
+	}
+
+	profiles, err := h.DB.GetPollProfiles()
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, "Failed to get tasks")
+		respondError(w, http.StatusInternalServerError, "Failed to get poll profiles")
 		return
 	}
 
-	respondJSON(w, http.StatusOK, tasks)
+	profile := models.SelectPollProfile(profiles, device.Manufacturer, device.ModelName)
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"profile": profile, // null = generic parameter set
+	})
+}
+
+// ============== Tag Handlers ==============
+
+// GetTags returns all tags with the number of devices carrying each
+func (h *Handler) GetTags(w http.ResponseWriter, r *http.Request) {
+	tags, err := h.DB.GetTags()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch tags")
+		return
+	}
+	respondJSON(w, http.StatusOK, tags)
+}
+
+// CreateTag registers a managed tag
+func (h *Handler) CreateTag(w http.ResponseWriter, r *http.Request) {
+	var tag models.Tag
+	if err := decodeJSON(w, r, &tag); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	tag.Name = strings.TrimSpace(tag.Name)
+	if tag.Name == "" {
+		respondError(w, http.StatusBadRequest, "Tag name is required")
+		return
+	}
+
+	created, err := h.DB.CreateTag(&tag)
+	if err != nil {
+		respondError(w, http.StatusConflict, "Tag already exists")
+		return
+	}
+	respondJSON(w, http.StatusCreated, created)
+}
+
+// DeleteTag deletes a tag and removes it from all devices
+func (h *Handler) DeleteTag(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	updated, err := h.DB.DeleteTag(name)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to delete tag")
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"updated": updated,
+	})
+}
+
+// BulkTagDevices adds/removes tags on an explicit device list or on every device matching a filter (status, search, tag)
+func (h *Handler) BulkTagDevices(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		DeviceIDs []int64  `json:"deviceIds"`
+		Status    string   `json:"status"`
+		Search    string   `json:"search"`
+		Tag       string   `json:"tag"`
+		Add       []string `json:"add"`
+		Remove    []string `json:"remove"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(req.Add) == 0 && len(req.Remove) == 0 {
+		respondError(w, http.StatusBadRequest, "Nothing to add or remove")
+		return
+	}
+
+	ids := req.DeviceIDs
+	if len(ids) == 0 {
+		if req.Status == "" && req.Search == "" && req.Tag == "" {
+			respondError(w, http.StatusBadRequest, "deviceIds or a filter (status/search/tag) is required")
+			return
+		}
+		devices, _, err := h.DB.GetDevices(req.Status, req.Search, "", req.Tag, 100000, 0)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "Failed to fetch devices")
+			return
+		}
+		for _, d := range devices {
+			ids = append(ids, d.ID)
+		}
+	}
+
+	updated, err := h.DB.BulkUpdateDeviceTags(ids, req.Add, req.Remove)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to update tags")
+		return
+	}
+
+	h.DB.CreateLog(nil, "info", "device",
+		fmt.Sprintf("Bulk tag update: %d of %d devices changed", updated, len(ids)), "")
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"matched": len(ids),
+		"updated": updated,
+	})
+}
+
+// AddDeviceTags adds one or more tags to a device
+func (h *Handler) AddDeviceTags(w http.ResponseWriter, r *http.Request) {
+	id := getPathInt64(r, "id")
+	if id == 0 {
+		respondError(w, http.StatusBadRequest, "Invalid device ID")
+		return
+	}
+
+	var req struct {
+		Tags []string `json:"tags"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(req.Tags) == 0 {
+		respondError(w, http.StatusBadRequest, "tags is required")
+		return
+	}
+
+	h.updateDeviceTags(w, id, req.Tags, nil)
+}
+
+// RemoveDeviceTag removes a tag from a device, ignoring case
+func (h *Handler) RemoveDeviceTag(w http.ResponseWriter, r *http.Request) {
+	id := getPathInt64(r, "id")
+	if id == 0 {
+		respondError(w, http.StatusBadRequest, "Invalid device ID")
+		return
+	}
+
+	device, err := h.DB.GetDevice(id)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Device not found")
+		return
+	}
+
+	tag := mux.Vars(r)["tag"]
+	var remove []string
+	for _, t := range device.Tags {
+		if strings.EqualFold(t, tag) {
+			remove = append(remove, t)
+		}
+	}
+	if len(remove) == 0 {
+		respondError(w, http.StatusNotFound, "Device does not have this tag")
+		return
+	}
+
+	h.updateDeviceTags(w, id, nil, remove)
+}
+
+// updateDeviceTags applies a tag change to a single device and responds with its tags
+func (h *Handler) updateDeviceTags(w http.ResponseWriter, id int64, add, remove []string) {
+	if _, err := h.DB.GetDevice(id); err != nil {
+		respondError(w, http.StatusNotFound, "Device not found")
+		return
+	}
+	if _, err := h.DB.BulkUpdateDeviceTags([]int64{id}, add, remove); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to update tags")
+		return
+	}
+
+	device, err := h.DB.GetDevice(id)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch device")
+		return
+	}
+	tags := device.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"tags":    tags,
+	})
+}
+
+// ============== Log Handlers ==============
+
+// GetLogs returns system logs
+func (h *Handler) GetLogs(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseLogFilter(r, h.Config.Loc())
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	limit := getQueryInt(r, "limit", 100)
+	offset := getQueryInt(r, "offset", 0)
+
+	logs, total, err := h.DB.GetLogs(filter, limit, offset)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get logs")
+		return
+	}
+	h.resolveLogAliases(logs)
+
+	w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+	respondJSON(w, http.StatusOK, logs)
 }
 
-// CreateDeviceTask creates a new task
-func (h *Handler) CreateDeviceTask(w http.ResponseWriter, r *http.Request) {
-	id := getPathInt64(r, "id")
+// ExportLogs streams logs matching the GetLogs filters (plus optional
+// deviceId) as CSV or NDJSON (?format=ndjson), oldest first, for offline
+// analysis or forwarding to a SIEM
+func (h *Handler) ExportLogs(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseLogFilter(r, h.Config.Loc())
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if deviceID := getQueryInt64(r, "deviceId"); deviceID > 0 {
+		filter.DeviceID = &deviceID
+	}
+	format := strings.ToLower(r.URL.Query().Get("format"))
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "ndjson" {
+		respondError(w, http.StatusBadRequest, "format must be csv or ndjson")
+		return
+	}
+
+	aliasFormat := ""
+	if h.Config != nil {
+		aliasFormat = h.Config.DeviceAliasFormat
+	}
+	alias := func(l *models.Log) {
+		if l.DeviceID != nil {
+			l.DeviceAlias = h.DB.DeviceAlias(*l.DeviceID, aliasFormat)
+		}
+	}
 
-	var task models.DeviceTask
-	if err := json.NewDecoder(r.Body).Decode(&task); err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid request body")
-		return
+	filename := fmt.Sprintf("logs-%s.%s", time.Now().Format("20060102-150405"), format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	flusher, _ := w.(http.Flusher)
+	count := 0
+	flush := func() {
+		if count++; count%500 == 0 && flusher != nil {
+			flusher.Flush()
+		}
 	}
 
-	task.DeviceID = id
-	created, err := h.DB.CreateTask(&task)
+	if format == "ndjson" {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		enc := json.NewEncoder(w)
+		err = h.DB.StreamLogs(filter, func(l *models.Log) error {
+			alias(l)
+			flush()
+			return enc.Encode(l)
+		})
+	} else {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"id", "created_at", "level", "category", "device_id", "device", "message", "details"})
+		err = h.DB.StreamLogs(filter, func(l *models.Log) error {
+			alias(l)
+			deviceID := ""
+			if l.DeviceID != nil {
+				deviceID = strconv.FormatInt(*l.DeviceID, 10)
+			}
+			flush()
+			return cw.Write([]string{
+				strconv.FormatInt(l.ID, 10), l.CreatedAt.UTC().Format(time.RFC3339), l.Level, l.Category,
+				deviceID, l.DeviceAlias, l.Message, l.Details,
+			})
+		})
+		cw.Flush()
+	}
+	// Headers are already sent, so a failure can only be logged
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, "Failed to create task")
-		return
+		h.DB.CreateLog(nil, "error", "system", "Log export aborted", err.Error())
 	}
+}
 
-	respondJSON(w, http.StatusCreated, created)
+// statusRecorder captures the response status for the audit log. It passes
+// Flush through so streaming handlers keep working.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
 }
 
-// GetTask returns a specific task
-func (h *Handler) GetTask(w http.ResponseWriter, r *http.Request) {
-	respondJSON(w, http.StatusOK, map[string]string{"message": "Not implemented"})
+func (s *statusRecorder) WriteHeader(code int) {
+	s.status = code
+	s.ResponseWriter.WriteHeader(code)
 }
 
-// DeleteTask deletes a task
-func (h *Handler) DeleteTask(w http.ResponseWriter, r *http.Request) {
-	respondJSON(w, http.StatusOK, map[string]bool{"success": true})
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
 }
 
-// ============== Preset Handlers ==============
+// AuditMiddleware records every state-changing (POST/PUT/DELETE) admin API
+// request in the audit log with the user from its JWT, the route, its target
+// and the response status. Request bodies are not stored.
+func (h *Handler) AuditMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims := middleware.GetUserFromContext(r.Context())
+		if claims == nil || r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
 
-// GetPresets returns all presets
-func (h *Handler) GetPresets(w http.ResponseWriter, r *http.Request) {
-	respondJSON(w, http.StatusOK, []interface{}{})
-}
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
 
-// CreatePreset creates a new preset
-func (h *Handler) CreatePreset(w http.ResponseWriter, r *http.Request) {
-	respondJSON(w, http.StatusCreated, map[string]string{"message": "Not implemented"})
+		action := r.URL.Path
+		if route := mux.CurrentRoute(r); route != nil {
+			if tpl, err := route.GetPathTemplate(); err == nil {
+				action = tpl
+			}
+		}
+		targetType, targetID := auditTarget(r)
+		entry := &models.AuditEntry{
+			UserID:     claims.UserID,
+			Username:   claims.Username,
+			Action:     r.Method + " " + action,
+			TargetType: targetType,
+			TargetID:   targetID,
+			IP:         middleware.ClientIP(r, h.Config.TrustedProxies),
+			Status:     rec.status,
+		}
+		if err := h.DB.RecordAudit(entry); err != nil {
+			fmt.Printf("[AUDIT] Failed to record %s by %s: %v\n", entry.Action, entry.Username, err)
+		}
+	})
 }
 
-// GetPreset returns a specific preset
-func (h *Handler) GetPreset(w http.ResponseWriter, r *http.Request) {
-	respondJSON(w, http.StatusOK, map[string]string{"message": "Not implemented"})
-}
+// auditTarget returns the resource a request acts on: the first path segment
+// under /api (e.g. "customers") and its {id} (or only) route variable
+func auditTarget(r *http.Request) (targetType, targetID string) {
+	segments := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/"), "/")
+	targetType = segments[0]
 
-// UpdatePreset updates a preset
-func (h *Handler) UpdatePreset(w http.ResponseWriter, r *http.Request) {
-	respondJSON(w, http.StatusOK, map[string]string{"message": "Not implemented"})
+	vars := mux.Vars(r)
+	if id, ok := vars["id"]; ok {
+		return targetType, id
+	}
+	if len(vars) == 1 {
+		for _, v := range vars {
+			targetID = v
+		}
+	}
+	return targetType, targetID
 }
 
-// DeletePreset deletes a preset
-func (h *Handler) DeletePreset(w http.ResponseWriter, r *http.Request) {
-	respondJSON(w, http.StatusOK, map[string]bool{"success": true})
+// GetAuditLog returns recorded admin actions, newest first. Filters: user
+// (username), action (substring, e.g. "isolir" or "DELETE /api/customers"),
+// from/to (RFC3339 or YYYY-MM-DD) and limit (default 100, max 1000).
+func (h *Handler) GetAuditLog(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	from, err := parseQueryTime(q.Get("from"), false, h.Config.Loc())
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid 'from' date, use YYYY-MM-DD or RFC3339")
+		return
+	}
+	to, err := parseQueryTime(q.Get("to"), true, h.Config.Loc())
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid 'to' date, use YYYY-MM-DD or RFC3339")
+		return
+	}
+	limit := getQueryInt(r, "limit", 100)
+	if limit <= 0 || limit > 1000 {
+		limit = 1000
+	}
+
+	entries, err := h.DB.GetAuditLog(models.AuditFilter{Action: q.Get("action"), User: q.Get("user"), From: from, To: to}, limit)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get audit log")
+		return
+	}
+	respondJSON(w, http.StatusOK, entries)
 }
 
-// ============== Log Handlers ==============
+// ExportAudit streams the admin audit log (every state-changing request with
+// the user who made it) as CSV for compliance review, oldest first. Filters:
+// from/to (RFC3339 or YYYY-MM-DD), action (partial match) and user.
+func (h *Handler) ExportAudit(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	from, err := parseQueryTime(q.Get("from"), false, h.Config.Loc())
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid 'from' date, use YYYY-MM-DD or RFC3339")
+		return
+	}
+	to, err := parseQueryTime(q.Get("to"), true, h.Config.Loc())
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid 'to' date, use YYYY-MM-DD or RFC3339")
+		return
+	}
+	filter := models.AuditFilter{Action: q.Get("action"), User: q.Get("user"), From: from, To: to}
 
-// GetLogs returns system logs
-func (h *Handler) GetLogs(w http.ResponseWriter, r *http.Request) {
-	level := r.URL.Query().Get("level")
-	limit := getQueryInt(r, "limit", 100)
-	offset := getQueryInt(r, "offset", 0)
+	filename := fmt.Sprintf("audit-%s.csv", time.Now().Format("20060102-150405"))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	flusher, _ := w.(http.Flusher)
+	count := 0
 
-	logs, err := h.DB.GetLogs(nil, level, limit, offset)
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"id", "created_at", "user_id", "username", "action", "target_type", "target_id", "ip", "status"})
+	err = h.DB.StreamAuditLog(filter, func(e *models.AuditEntry) error {
+		if count++; count%500 == 0 && flusher != nil {
+			flusher.Flush()
+		}
+		return cw.Write([]string{
+			strconv.FormatInt(e.ID, 10), e.CreatedAt.UTC().Format(time.RFC3339), strconv.FormatInt(e.UserID, 10), e.Username,
+			e.Action, e.TargetType, e.TargetID, e.IP, strconv.Itoa(e.Status),
+		})
+	})
+	cw.Flush()
+	// Headers are already sent, so a failure can only be logged
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, "Failed to get logs")
-		return
+		h.DB.CreateLog(nil, "error", "system", "Audit export aborted", err.Error())
 	}
+}
 
-	respondJSON(w, http.StatusOK, logs)
+// resolveLogAliases fills the friendly device name of each log entry
+func (h *Handler) resolveLogAliases(logs []*models.Log) {
+	format := ""
+	if h.Config != nil {
+		format = h.Config.DeviceAliasFormat
+	}
+	for _, l := range logs {
+		if l.DeviceID != nil {
+			l.DeviceAlias = h.DB.DeviceAlias(*l.DeviceID, format)
+		}
+	}
 }
 
 // GetDeviceLogs returns logs for a specific device
 func (h *Handler) GetDeviceLogs(w http.ResponseWriter, r *http.Request) {
 	id := getPathInt64(r, "id")
-	level := r.URL.Query().Get("level")
+	filter, err := parseLogFilter(r, h.Config.Loc())
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter.DeviceID = &id
 	limit := getQueryInt(r, "limit", 100)
 	offset := getQueryInt(r, "offset", 0)
 
-	logs, err := h.DB.GetLogs(&id, level, limit, offset)
+	logs, total, err := h.DB.GetLogs(filter, limit, offset)
 	if err != nil {
 		respondError(w, http.StatusInternalServerError, "Failed to get logs")
 		return
 	}
+	h.resolveLogAliases(logs)
 
+	w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
 	respondJSON(w, http.StatusOK, logs)
 }
 
+// parseLogFilter reads level, category, from and to query parameters.
+// Dates accept RFC3339 or YYYY-MM-DD (a day in loc); a date-only "to" covers
+// the whole day.
+func parseLogFilter(r *http.Request, loc *time.Location) (models.LogFilter, error) {
+	q := r.URL.Query()
+	filter := models.LogFilter{
+		Level:    q.Get("level"),
+		Category: q.Get("category"),
+	}
+
+	from, err := parseQueryTime(q.Get("from"), false, loc)
+	if err != nil {
+		return filter, fmt.Errorf("Invalid 'from' date, use YYYY-MM-DD or RFC3339")
+	}
+	to, err := parseQueryTime(q.Get("to"), true, loc)
+	if err != nil {
+		return filter, fmt.Errorf("Invalid 'to' date, use YYYY-MM-DD or RFC3339")
+	}
+	filter.From = from
+	filter.To = to
+	return filter, nil
+}
+
+// parseQueryTime parses an optional RFC3339 or YYYY-MM-DD value; a date-only
+// value is a day in loc. With endOfDay set, it is moved to the last second of
+// that day.
+func parseQueryTime(value string, endOfDay bool, loc *time.Location) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, loc)
+	if err != nil {
+		return nil, err
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1).Add(-time.Second)
+	}
+	return &t, nil
+}
+
 // ============== Helper Functions ==============
 
+// maxRequestBodyBytes caps JSON request bodies accepted by decodeJSON
+const maxRequestBodyBytes = 1 << 20 // 1 MB
+
+// decodeJSON strictly decodes a JSON request body into dst. It enforces the
+// Content-Type (when sent), a body size limit, rejects unknown fields and
+// trailing data, and returns an error message suitable for a 400 response.
+func decodeJSON(w http.ResponseWriter, r *http.Request, dst interface{}) error {
+	if ct := r.Header.Get("Content-Type"); ct != "" && !strings.HasPrefix(strings.ToLower(ct), "application/json") {
+		return fmt.Errorf("Content-Type must be application/json")
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodyBytes)
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(dst); err != nil {
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		var maxBytesErr *http.MaxBytesError
+		switch {
+		case errors.As(err, &syntaxErr):
+			return fmt.Errorf("Malformed JSON at position %d", syntaxErr.Offset)
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			return fmt.Errorf("Malformed JSON")
+		case errors.As(err, &typeErr):
+			if typeErr.Field != "" {
+				return fmt.Errorf("Invalid value for field %q: expected %s", typeErr.Field, typeErr.Type)
+			}
+			return fmt.Errorf("Invalid value at position %d", typeErr.Offset)
+		case strings.HasPrefix(err.Error(), "json: unknown field "):
+			return fmt.Errorf("Unknown field %s", strings.TrimPrefix(err.Error(), "json: unknown field "))
+		case errors.Is(err, io.EOF):
+			return fmt.Errorf("Request body must not be empty")
+		case errors.As(err, &maxBytesErr):
+			return fmt.Errorf("Request body must not exceed %d bytes", maxRequestBodyBytes)
+		default:
+			return fmt.Errorf("Invalid request body")
+		}
+	}
+
+	if err := dec.Decode(&struct{}{}); !errors.Is(err, io.EOF) {
+		return fmt.Errorf("Request body must contain a single JSON object")
+	}
+	return nil
+}
+
 func respondJSON(w http.ResponseWriter, status int, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -1855,6 +5768,28 @@ func generateJWT(user *models.User, jwtSecret string) (string, error) {
 	return signedToken, nil
 }
 
+// generateCustomerJWT creates a portal token for a customer. It is only
+// accepted by the customer portal API, never by the admin API.
+func generateCustomerJWT(customer *models.Customer, jwtSecret string) (string, error) {
+	if jwtSecret == "" {
+		return "", fmt.Errorf("JWT secret is required")
+	}
+
+	claims := middleware.CustomerClaims{
+		CustomerID: customer.ID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Audience:  jwt.ClaimStrings{middleware.PortalAudience},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour * 24)),
+		},
+	}
+	signedToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(jwtSecret))
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token: %v", err)
+	}
+
+	return signedToken, nil
+}
+
 // generateUsernameFromName creates a username from customer name
 func generateUsernameFromName(name string) string {
 	// Remove special characters and spaces, keep only alphanumeric
@@ -1920,6 +5855,17 @@ func containsString(s, substr string) bool {
 	return false
 }
 
+// firstNonEmpty returns the first non-empty string, for request fields that
+// accept an alternate key
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
 // ============== Billing Handlers ==============
 
 // GetPackages returns all packages
@@ -1933,11 +5879,64 @@ func (h *Handler) GetPackages(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, http.StatusOK, packages)
 }
 
+// packageRequest is the body of the package create and update endpoints
+type packageRequest struct {
+	Name            string  `json:"name"`
+	Description     string  `json:"description"`
+	DownloadSpeed   int     `json:"downloadSpeed"`
+	UploadSpeed     int     `json:"uploadSpeed"`
+	Quota           int64   `json:"quota"`
+	Price           float64 `json:"price"`
+	SetupFee        float64 `json:"setupFee"`
+	VLAN            int     `json:"vlan"`
+	DiscountPercent float64 `json:"discountPercent"`
+	IsActive        bool    `json:"isActive"`
+	// Speeds as sent by the packages page
+	DownloadSpeedMbps int `json:"download_speed"`
+	UploadSpeedMbps   int `json:"upload_speed"`
+}
+
+func (req packageRequest) pkg() models.Package {
+	pkg := models.Package{
+		Name:            req.Name,
+		Description:     req.Description,
+		DownloadSpeed:   req.DownloadSpeed,
+		UploadSpeed:     req.UploadSpeed,
+		Quota:           req.Quota,
+		Price:           req.Price,
+		SetupFee:        req.SetupFee,
+		VLAN:            req.VLAN,
+		DiscountPercent: req.DiscountPercent,
+		IsActive:        req.IsActive,
+	}
+	if pkg.DownloadSpeed == 0 {
+		pkg.DownloadSpeed = req.DownloadSpeedMbps
+	}
+	if pkg.UploadSpeed == 0 {
+		pkg.UploadSpeed = req.UploadSpeedMbps
+	}
+	return pkg
+}
+
 // CreatePackage creates a new package
 func (h *Handler) CreatePackage(w http.ResponseWriter, r *http.Request) {
-	var pkg models.Package
-	if err := json.NewDecoder(r.Body).Decode(&pkg); err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid request body")
+	var req packageRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	pkg := req.pkg()
+	rateLimit, err := mikrotik.RateLimit(pkg.UploadSpeed, pkg.DownloadSpeed)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if pkg.VLAN < 0 || pkg.VLAN > 4094 {
+		respondError(w, http.StatusBadRequest, "vlan must be between 0 and 4094")
+		return
+	}
+	if pkg.DiscountPercent < 0 || pkg.DiscountPercent > 100 {
+		respondError(w, http.StatusBadRequest, "discountPercent must be between 0 and 100")
 		return
 	}
 	pkg.IsActive = true
@@ -1949,7 +5948,6 @@ func (h *Handler) CreatePackage(w http.ResponseWriter, r *http.Request) {
 
 	// Sync to MikroTik
 	if h.Mikrotik != nil {
-		rateLimit := fmt.Sprintf("%dM/%dM", pkg.UploadSpeed, pkg.DownloadSpeed)
 		go h.Mikrotik.SyncPPPProfile(pkg.Name, rateLimit)
 	}
 
@@ -1970,12 +5968,26 @@ func (h *Handler) GetPackageByID(w http.ResponseWriter, r *http.Request) {
 // UpdatePackage updates a package
 func (h *Handler) UpdatePackage(w http.ResponseWriter, r *http.Request) {
 	id := getPathInt64(r, "id")
-	var pkg models.Package
-	if err := json.NewDecoder(r.Body).Decode(&pkg); err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid request body")
+	var req packageRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	pkg := req.pkg()
 	pkg.ID = id
+	rateLimit, err := mikrotik.RateLimit(pkg.UploadSpeed, pkg.DownloadSpeed)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if pkg.VLAN < 0 || pkg.VLAN > 4094 {
+		respondError(w, http.StatusBadRequest, "vlan must be between 0 and 4094")
+		return
+	}
+	if pkg.DiscountPercent < 0 || pkg.DiscountPercent > 100 {
+		respondError(w, http.StatusBadRequest, "discountPercent must be between 0 and 100")
+		return
+	}
 	if err := h.DB.UpdatePackage(&pkg); err != nil {
 		respondError(w, http.StatusInternalServerError, "Failed to update package")
 		return
@@ -1985,7 +5997,6 @@ func (h *Handler) UpdatePackage(w http.ResponseWriter, r *http.Request) {
 
 	// Sync to MikroTik
 	if h.Mikrotik != nil && updated != nil {
-		rateLimit := fmt.Sprintf("%dM/%dM", updated.UploadSpeed, updated.DownloadSpeed)
 		go h.Mikrotik.SyncPPPProfile(updated.Name, rateLimit)
 	}
 
@@ -2009,17 +6020,304 @@ func (h *Handler) GetCustomers(w http.ResponseWriter, r *http.Request) {
 	limit := getQueryInt(r, "limit", 50)
 	offset := getQueryInt(r, "offset", 0)
 
-	customers, total, err := h.DB.GetCustomers(status, search, limit, offset)
+	customers, total, err := h.DB.GetCustomers(status, search, limit, offset)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get customers")
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"customers": customers,
+		"total":     total,
+		"limit":     limit,
+		"offset":    offset,
+	})
+}
+
+// ExportCustomers streams every customer matching the status/search filters
+// as CSV (default) or as one JSON array, without pagination
+func (h *Handler) ExportCustomers(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	search := r.URL.Query().Get("search")
+	format := strings.ToLower(r.URL.Query().Get("format"))
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "json" {
+		respondError(w, http.StatusBadRequest, "format must be csv or json")
+		return
+	}
+
+	filename := fmt.Sprintf("customers-%s.%s", time.Now().Format("20060102-150405"), format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	flusher, _ := w.(http.Flusher)
+	count := 0
+	flush := func() {
+		if count++; count%500 == 0 && flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	var err error
+	if format == "json" {
+		// Written element by element so the array is never held in memory
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("["))
+		err = h.DB.StreamCustomers(status, search, func(c *models.Customer) error {
+			data, err := json.Marshal(c)
+			if err != nil {
+				return err
+			}
+			if count > 0 {
+				w.Write([]byte(","))
+			}
+			flush()
+			_, err = w.Write(data)
+			return err
+		})
+		w.Write([]byte("]\n"))
+	} else {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"customer_code", "name", "phone", "email", "address", "package", "status", "balance"})
+		err = h.DB.StreamCustomers(status, search, func(c *models.Customer) error {
+			pkg := ""
+			if c.Package != nil {
+				pkg = c.Package.Name
+			}
+			flush()
+			return cw.Write([]string{
+				c.CustomerCode, c.Name, c.Phone, c.Email, c.Address, pkg, c.Status,
+				strconv.FormatFloat(c.Balance, 'f', -1, 64),
+			})
+		})
+		cw.Flush()
+	}
+	// Headers are already sent, so a failure can only be logged
+	if err != nil {
+		h.DB.CreateLog(nil, "error", "system", "Customer export aborted", err.Error())
+	}
+}
+
+// maxImportBytes caps the size of an uploaded customer CSV
+const maxImportBytes = 10 << 20 // 10 MB
+
+// customerImportRow is the outcome of one CSV row of a customer import
+type customerImportRow struct {
+	Row          int    `json:"row"` // Line number in the file, header = 1
+	CustomerCode string `json:"customerCode,omitempty"`
+	Action       string `json:"action"` // created, updated or skipped
+	Reason       string `json:"reason,omitempty"`
+}
+
+// ImportCustomers creates or updates customers from an uploaded CSV (form
+// field "file") in the ExportCustomers layout. Rows are matched on
+// customer_code; each row succeeds or is skipped on its own. Packages are
+// referenced by name, and blank usernames/passwords are generated as in
+// CreateCustomer. Balance is only taken for new customers.
+func (h *Handler) ImportCustomers(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "CSV file is required (multipart field \"file\")")
+		return
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Failed to read CSV header")
+		return
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	if _, ok := columns["name"]; !ok {
+		respondError(w, http.StatusBadRequest, "CSV must have a name column")
+		return
+	}
+
+	packages, err := h.DB.GetPackages(false)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get packages")
+		return
+	}
+	packageIDs := make(map[string]int64)
+	for _, p := range packages {
+		packageIDs[strings.ToLower(p.Name)] = p.ID
+	}
+
+	results := []customerImportRow{}
+	counts := map[string]int{"created": 0, "updated": 0, "skipped": 0}
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		var result customerImportRow
+		if err != nil {
+			result = customerImportRow{Row: line, Action: "skipped", Reason: err.Error()}
+		} else {
+			result = h.importCustomerRow(record, columns, packageIDs)
+			result.Row = line
+		}
+		counts[result.Action]++
+		results = append(results, result)
+	}
+
+	h.DB.CreateLog(nil, "info", "customer",
+		fmt.Sprintf("Customer import: %d created, %d updated, %d skipped", counts["created"], counts["updated"], counts["skipped"]), "")
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"created": counts["created"],
+		"updated": counts["updated"],
+		"skipped": counts["skipped"],
+		"rows":    results,
+	})
+}
+
+// importCustomerRow applies one CSV record of a customer import
+func (h *Handler) importCustomerRow(record []string, columns map[string]int, packageIDs map[string]int64) customerImportRow {
+	field := func(name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+	skip := func(code, reason string) customerImportRow {
+		return customerImportRow{CustomerCode: code, Action: "skipped", Reason: reason}
+	}
+
+	code := field("customer_code")
+	customer := models.Customer{
+		CustomerCode: code,
+		Name:         field("name"),
+		Phone:        field("phone"),
+		Email:        field("email"),
+		Address:      field("address"),
+		Status:       strings.ToLower(field("status")),
+	}
+	if customer.Name == "" {
+		return skip(code, "name is required")
+	}
+	switch customer.Status {
+	case "":
+		customer.Status = "active"
+	case "active", "suspended", "terminated":
+	default:
+		return skip(code, fmt.Sprintf("unknown status %q", customer.Status))
+	}
+	if pkg := field("package"); pkg != "" {
+		id, ok := packageIDs[strings.ToLower(pkg)]
+		if !ok {
+			return skip(code, fmt.Sprintf("package %q does not exist", pkg))
+		}
+		customer.PackageID = id
+	}
+	if v := field("balance"); v != "" {
+		balance, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return skip(code, fmt.Sprintf("invalid balance %q", v))
+		}
+		customer.Balance = balance
+	}
+
+	// Rows are matched on customer_code, else username, else phone, so
+	// re-importing a file without codes updates instead of duplicating
+	var existingID int64
+	username := field("username")
+	switch {
+	case code != "":
+		id, err := h.DB.GetCustomerIDByCode(code)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return skip(code, "failed to look up customer")
+		}
+		existingID = id
+	case username != "":
+		c, err := h.DB.GetCustomerByUsername(username)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return skip(code, "failed to look up customer")
+		}
+		if c != nil {
+			existingID = c.ID
+		}
+	case customer.Phone != "":
+		ids, err := h.DB.GetCustomerIDsByPhone(customer.Phone)
+		if err != nil {
+			return skip(code, "failed to look up customer")
+		}
+		if len(ids) > 1 {
+			return skip(code, fmt.Sprintf("phone %s matches %d customers, add customer_code", customer.Phone, len(ids)))
+		}
+		if len(ids) == 1 {
+			existingID = ids[0]
+		}
+	default:
+		return skip(code, "customer_code, username or phone is required")
+	}
+
+	if existingID > 0 {
+		existing, err := h.DB.GetCustomer(existingID)
+		if err != nil {
+			return skip(code, "failed to load existing customer")
+		}
+		customer.ID = existingID
+		if customer.PackageID == 0 {
+			customer.PackageID = existing.PackageID
+		}
+		if err := h.DB.UpdateCustomerProfile(&customer); err != nil {
+			return skip(code, "failed to update customer: "+err.Error())
+		}
+		h.syncImportedCustomer(existing)
+		return customerImportRow{CustomerCode: existing.CustomerCode, Action: "updated"}
+	}
+
+	if customer.PackageID == 0 {
+		return skip(code, "package is required for new customers")
+	}
+	customer.Username = username
+	if customer.Username == "" {
+		customer.Username = generateUsernameFromName(customer.Name)
+	}
+	password := field("password")
+	if password == "" {
+		password = generateRandomPassword()
+	}
+	hashed, err := hashPassword(password)
+	if err != nil {
+		return skip(code, "failed to hash password")
+	}
+	customer.Password = hashed
+
+	created, err := h.DB.CreateCustomer(&customer)
+	if err != nil {
+		if strings.Contains(err.Error(), "customers.username") {
+			return skip(code, fmt.Sprintf("username %q is already taken", customer.Username))
+		}
+		return skip(code, "failed to create customer: "+err.Error())
+	}
+	return customerImportRow{CustomerCode: created.CustomerCode, Action: "created"}
+}
+
+// syncImportedCustomer carries a status or package change made by an import
+// over to MikroTik and the suspension events, as the suspend and unsuspend
+// actions do. before is the customer as it was prior to the update.
+func (h *Handler) syncImportedCustomer(before *models.Customer) {
+	after, err := h.DB.GetCustomer(before.ID)
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, "Failed to get customers")
 		return
 	}
-	respondJSON(w, http.StatusOK, map[string]interface{}{
-		"customers": customers,
-		"total":     total,
-		"limit":     limit,
-		"offset":    offset,
-	})
+	switch {
+	case after.Status == "suspended" && before.Status != "suspended":
+		h.isolirConnection(after)
+		h.notifySuspension(after, "import")
+	case after.Status == "active" && (before.Status == "suspended" || after.PackageID != before.PackageID):
+		h.restoreConnection(after, "")
+	}
 }
 
 // GetLocations returns all customer locations
@@ -2040,8 +6338,8 @@ func (h *Handler) UpdateCustomerLocation(w http.ResponseWriter, r *http.Request)
 		Longitude float64 `json:"longitude"`
 		Address   string  `json:"address"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid request")
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
@@ -2058,8 +6356,8 @@ func (h *Handler) UpdateCustomerFCM(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		FCMToken string `json:"fcmToken"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid request")
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
@@ -2070,12 +6368,114 @@ func (h *Handler) UpdateCustomerFCM(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, http.StatusOK, map[string]bool{"success": true})
 }
 
+// GetCustomerNotificationPreferences returns which notifications a customer accepts
+func (h *Handler) GetCustomerNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	id := getPathInt64(r, "id")
+	if _, err := h.DB.GetCustomer(id); err != nil {
+		respondError(w, http.StatusNotFound, "Customer not found")
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]bool{"receipts": h.DB.CustomerWantsReceipts(id)})
+}
+
+// UpdateCustomerNotificationPreferences sets whether a customer receives payment receipts
+func (h *Handler) UpdateCustomerNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	id := getPathInt64(r, "id")
+	var req struct {
+		Receipts *bool `json:"receipts"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.Receipts == nil {
+		respondError(w, http.StatusBadRequest, "receipts is required")
+		return
+	}
+
+	if err := h.DB.SetCustomerWantsReceipts(id, *req.Receipts); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondError(w, http.StatusNotFound, "Customer not found")
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "Failed to update notification preferences")
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]interface{}{"success": true, "receipts": *req.Receipts})
+}
+
+// GetCustomerDiscount returns the discount applied to a customer's generated invoices
+func (h *Handler) GetCustomerDiscount(w http.ResponseWriter, r *http.Request) {
+	id := getPathInt64(r, "id")
+	if _, err := h.DB.GetCustomer(id); err != nil {
+		respondError(w, http.StatusNotFound, "Customer not found")
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]float64{"discountPercent": h.DB.CustomerDiscountPercent(id)})
+}
+
+// UpdateCustomerDiscount sets a customer's own invoice discount; 0 falls back
+// to the package discount
+func (h *Handler) UpdateCustomerDiscount(w http.ResponseWriter, r *http.Request) {
+	id := getPathInt64(r, "id")
+	var req struct {
+		DiscountPercent *float64 `json:"discountPercent"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.DiscountPercent == nil || *req.DiscountPercent < 0 || *req.DiscountPercent > 100 {
+		respondError(w, http.StatusBadRequest, "discountPercent must be between 0 and 100")
+		return
+	}
+
+	if err := h.DB.SetCustomerDiscountPercent(id, *req.DiscountPercent); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			respondError(w, http.StatusNotFound, "Customer not found")
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "Failed to update discount")
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]interface{}{"success": true, "discountPercent": *req.DiscountPercent})
+}
+
 // CreateCustomer creates a new customer
 func (h *Handler) CreateCustomer(w http.ResponseWriter, r *http.Request) {
-	var customer models.Customer
-	if err := json.NewDecoder(r.Body).Decode(&customer); err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid request body")
-		return
+	var req struct {
+		Name      string  `json:"name"`
+		Email     string  `json:"email"`
+		Phone     string  `json:"phone"`
+		Address   string  `json:"address"`
+		Latitude  float64 `json:"latitude"`
+		Longitude float64 `json:"longitude"`
+		PackageID int64   `json:"packageId"`
+		Username  string  `json:"username"`
+		Password  string  `json:"password"`
+		Status    string  `json:"status"`
+		Balance   float64 `json:"balance"`
+		// Portal credentials as sent by the customers page
+		PortalUsername string `json:"portalUsername"`
+		PortalPassword string `json:"portalPassword"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	customer := models.Customer{
+		Name:          req.Name,
+		Email:         req.Email,
+		Phone:         req.Phone,
+		Address:       req.Address,
+		Latitude:      req.Latitude,
+		Longitude:     req.Longitude,
+		PackageID:     req.PackageID,
+		Username:      firstNonEmpty(req.Username, req.PortalUsername),
+		InputPassword: firstNonEmpty(req.Password, req.PortalPassword),
+		Status:        req.Status,
+		Balance:       req.Balance,
 	}
 
 	// Generate portal username if not provided
@@ -2114,6 +6514,236 @@ func (h *Handler) CreateCustomer(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, http.StatusCreated, created)
 }
 
+// OnboardCustomer runs the new-customer workflow in one request: create the
+// customer, add the PPPoE secret on MikroTik, assign the ONU, queue its WiFi
+// settings and issue the first invoice. Inputs are validated up front; the
+// database writes are a single transaction and the MikroTik secret is removed
+// again if they fail, so a failed onboarding leaves nothing behind.
+func (h *Handler) OnboardCustomer(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Customer        models.Customer `json:"customer"`
+		PPPoEUsername   string          `json:"pppoeUsername"`
+		PPPoEPassword   string          `json:"pppoePassword"`
+		SerialNumber    string          `json:"serialNumber"`
+		SSID            string          `json:"ssid"`
+		WiFiPassword    string          `json:"wifiPassword"`
+		GenerateInvoice *bool           `json:"generateInvoice"` // default true when a package is set
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	customer := &req.Customer
+	customer.Name = strings.TrimSpace(customer.Name)
+	req.PPPoEUsername = strings.TrimSpace(req.PPPoEUsername)
+	req.SerialNumber = strings.TrimSpace(req.SerialNumber)
+
+	steps := make([]map[string]interface{}, 0, 5)
+	step := func(name, status string, extra map[string]interface{}) {
+		s := map[string]interface{}{"step": name, "status": status}
+		for k, v := range extra {
+			s[k] = v
+		}
+		steps = append(steps, s)
+	}
+	fail := func(httpStatus int, message string) {
+		respondJSON(w, httpStatus, map[string]interface{}{
+			"success": false,
+			"error":   message,
+			"steps":   steps,
+		})
+	}
+
+	// 1. Validate everything before touching MikroTik or the database
+	if customer.Name == "" {
+		fail(http.StatusBadRequest, "customer.name is required")
+		return
+	}
+	var pkg *models.Package
+	if customer.PackageID > 0 {
+		p, err := h.DB.GetPackage(customer.PackageID)
+		if err != nil || p == nil {
+			fail(http.StatusBadRequest, "Package not found")
+			return
+		}
+		pkg = p
+	}
+	if req.PPPoEUsername != "" {
+		if req.PPPoEPassword == "" {
+			fail(http.StatusBadRequest, "pppoePassword is required with pppoeUsername")
+			return
+		}
+		if h.Mikrotik == nil {
+			fail(http.StatusServiceUnavailable, "MikroTik client not initialized")
+			return
+		}
+		if existing, err := h.DB.GetCustomerByPPPoE(req.PPPoEUsername); err == nil && existing != nil {
+			fail(http.StatusConflict, "PPPoE username is already used by "+existing.Name)
+			return
+		}
+		// Isolir and reactivation address the PPPoE secret by customer.Username
+		if customer.Username != "" && customer.Username != req.PPPoEUsername {
+			fail(http.StatusBadRequest, "customer.username must match pppoeUsername")
+			return
+		}
+		if existing, err := h.DB.GetCustomerByUsername(req.PPPoEUsername); err == nil && existing != nil {
+			fail(http.StatusConflict, "PPPoE username is already used by "+existing.Name)
+			return
+		}
+		customer.Username = req.PPPoEUsername
+	}
+	var device *models.Device
+	if req.SerialNumber != "" {
+		d, err := h.DB.GetDeviceBySerial(req.SerialNumber)
+		if err != nil || d == nil {
+			fail(http.StatusBadRequest, "Device not found")
+			return
+		}
+		if d.CustomerID != nil && *d.CustomerID > 0 {
+			fail(http.StatusConflict, "Device is already assigned to another customer")
+			return
+		}
+		device = d
+	} else if req.SSID != "" || req.WiFiPassword != "" {
+		fail(http.StatusBadRequest, "serialNumber is required to set WiFi")
+		return
+	}
+	if req.WiFiPassword != "" && len(req.WiFiPassword) < 8 {
+		fail(http.StatusBadRequest, "Password must be at least 8 characters")
+		return
+	}
+
+	if customer.Username == "" {
+		customer.Username = generateUsernameFromName(customer.Name)
+	}
+	portalPassword := customer.InputPassword
+	if portalPassword == "" {
+		portalPassword = generateRandomPassword()
+	}
+	hashed, err := hashPassword(portalPassword)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to hash password")
+		return
+	}
+	customer.Password = hashed
+	if customer.Status == "" {
+		customer.Status = "active"
+	}
+
+	wifiParams := make(map[string]string)
+	var deviceID int64
+	if device != nil {
+		deviceID = device.ID
+		if req.SSID != "" {
+			for k, v := range buildSSIDParams(device, req.SSID) {
+				wifiParams[k] = v
+			}
+		}
+		if req.WiFiPassword != "" {
+			for k, v := range buildWiFiPasswordParams(device, req.WiFiPassword) {
+				wifiParams[k] = v
+			}
+		}
+	}
+
+	var invoice *models.Invoice
+	if pkg != nil && (req.GenerateInvoice == nil || *req.GenerateInvoice) {
+		now := h.Config.Now()
+		periodStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+		item, prorated := h.subscriptionItem(pkg, now, periodStart)
+		invoice = &models.Invoice{
+			Subtotal:    item.Amount + pkg.SetupFee,
+			PeriodStart: periodStart,
+			PeriodEnd:   time.Date(now.Year(), now.Month()+1, 0, 0, 0, 0, 0, now.Location()),
+			DueDate:     time.Date(now.Year(), now.Month()+1, 10, 0, 0, 0, 0, now.Location()),
+			Status:      models.InvoicePending,
+			Items:       []models.InvoiceItem{item},
+			Notes:       fmt.Sprintf("First month subscription - %s", pkg.Name),
+		}
+		if prorated {
+			invoice.Notes += " (prorated)"
+		}
+		if pkg.SetupFee > 0 {
+			invoice.Items = append(invoice.Items, models.InvoiceItem{
+				Description: "Setup fee", Quantity: 1, UnitPrice: pkg.SetupFee, Amount: pkg.SetupFee,
+			})
+			invoice.Notes += " (incl. setup fee)"
+		}
+		h.priceInvoice(invoice, pkg.DiscountPercent)
+	}
+
+	// 2. MikroTik secret (external, so it is compensated rather than rolled back)
+	secretCreated := false
+	if req.PPPoEUsername != "" {
+		profile := ""
+		if pkg != nil {
+			profile = pkg.Name
+		}
+		if err := h.Mikrotik.CreatePPPSecret(req.PPPoEUsername, req.PPPoEPassword, profile); err != nil {
+			step("mikrotik", "failed", map[string]interface{}{"message": err.Error()})
+			fail(http.StatusBadGateway, "Failed to create PPPoE secret, nothing was changed")
+			return
+		}
+		secretCreated = true
+	}
+
+	// 3. Customer, device, WiFi and invoice in one transaction
+	taskID, err := h.DB.OnboardCustomer(customer, deviceID, req.PPPoEUsername, wifiParams, invoice)
+	if err != nil {
+		mikrotikStatus := "skipped"
+		if secretCreated {
+			mikrotikStatus = "rolled_back"
+			if rmErr := h.Mikrotik.RemovePPPSecret(req.PPPoEUsername); rmErr != nil {
+				mikrotikStatus = "rollback_failed"
+				h.DB.CreateLog(nil, "error", "customer", fmt.Sprintf("Onboarding rollback left PPPoE secret %s on MikroTik", req.PPPoEUsername), rmErr.Error())
+			}
+		}
+		step("customer", "rolled_back", nil)
+		step("mikrotik", mikrotikStatus, nil)
+		step("device", "rolled_back", nil)
+		step("wifi", "rolled_back", nil)
+		step("invoice", "rolled_back", map[string]interface{}{"message": err.Error()})
+		fail(http.StatusInternalServerError, "Failed to save onboarding, changes were rolled back")
+		return
+	}
+
+	step("customer", "done", map[string]interface{}{"customerId": customer.ID, "customerCode": customer.CustomerCode})
+	if secretCreated {
+		step("mikrotik", "done", map[string]interface{}{"username": req.PPPoEUsername})
+	} else {
+		step("mikrotik", "skipped", nil)
+	}
+	if device != nil {
+		step("device", "done", map[string]interface{}{"deviceId": device.ID, "serialNumber": device.SerialNumber, "label": h.labelDevice(device.ID)})
+	} else {
+		step("device", "skipped", nil)
+	}
+	if taskID > 0 {
+		step("wifi", "done", map[string]interface{}{"taskId": taskID})
+		if h.ACS != nil {
+			go h.ACS.SendConnectionRequest(device)
+		}
+	} else {
+		step("wifi", "skipped", nil)
+	}
+	if invoice != nil {
+		step("invoice", "done", map[string]interface{}{"invoiceId": invoice.ID, "invoiceNo": invoice.InvoiceNo, "total": invoice.Total})
+		h.notifyInvoice(customer, invoice, nil)
+	} else {
+		step("invoice", "skipped", nil)
+	}
+
+	h.DB.CreateLog(nil, "info", "customer", fmt.Sprintf("Customer %s onboarded", customer.Name), customer.CustomerCode)
+
+	respondJSON(w, http.StatusCreated, map[string]interface{}{
+		"success":        true,
+		"customerId":     customer.ID,
+		"portalUsername": customer.Username,
+		"portalPassword": portalPassword,
+		"steps":          steps,
+	})
+}
+
 // GetCustomer returns a specific customer
 func (h *Handler) GetCustomer(w http.ResponseWriter, r *http.Request) {
 	id := getPathInt64(r, "id")
@@ -2140,10 +6770,13 @@ func (h *Handler) UpdateCustomer(w http.ResponseWriter, r *http.Request) {
 		Status        string  `json:"status"`
 		Balance       float64 `json:"balance"`
 		InputPassword string  `json:"password"` // Password might be in request
+		// Portal credentials as sent by the customers page
+		PortalUsername string `json:"portalUsername"`
+		PortalPassword string `json:"portalPassword"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid request body")
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
@@ -2162,13 +6795,17 @@ func (h *Handler) UpdateCustomer(w http.ResponseWriter, r *http.Request) {
 	existingCustomer.Latitude = req.Latitude
 	existingCustomer.Longitude = req.Longitude
 	existingCustomer.PackageID = req.PackageID
-	existingCustomer.Username = req.Username
+	existingCustomer.Username = firstNonEmpty(req.Username, req.PortalUsername)
+	if req.Status == "hold" && existingCustomer.Status != "hold" {
+		respondError(w, http.StatusBadRequest, "Use POST /api/customers/{id}/hold to put a customer on hold")
+		return
+	}
 	existingCustomer.Status = req.Status
 	existingCustomer.Balance = req.Balance
 
 	// Only update password if a new one is provided
-	if req.InputPassword != "" {
-		hashedPassword, err := hashPassword(req.InputPassword)
+	if password := firstNonEmpty(req.InputPassword, req.PortalPassword); password != "" {
+		hashedPassword, err := hashPassword(password)
 		if err != nil {
 			respondError(w, http.StatusInternalServerError, "Failed to hash password")
 			return
@@ -2195,6 +6832,30 @@ func (h *Handler) DeleteCustomer(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, http.StatusOK, map[string]bool{"success": true})
 }
 
+// AnonymizeCustomer scrubs a customer's PII for privacy requests, keeping billing history linked
+func (h *Handler) AnonymizeCustomer(w http.ResponseWriter, r *http.Request) {
+	id := getPathInt64(r, "id")
+	customer, err := h.DB.GetCustomer(id)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Customer not found")
+		return
+	}
+
+	if err := h.DB.AnonymizeCustomer(id); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to anonymize customer")
+		return
+	}
+
+	h.DB.CreateLog(nil, "warning", "audit",
+		fmt.Sprintf("Customer %s anonymized", customer.CustomerCode), "")
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"success":      true,
+		"customerCode": customer.CustomerCode,
+		"message":      "Customer personal data removed",
+	})
+}
+
 // IsolirCustomer suspends a customer (isolir)
 func (h *Handler) IsolirCustomer(w http.ResponseWriter, r *http.Request) {
 	id := getPathInt64(r, "id")
@@ -2216,37 +6877,10 @@ func (h *Handler) IsolirCustomer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Change PPPoE profile to isolir profile via MikroTik API
-	if h.Mikrotik != nil {
-		// Create isolir profile if it doesn't exist
-		isolirProfile := "isolir-profile"
-		err = h.Mikrotik.CreateIsolirProfile(isolirProfile, "64k/64k")
-		if err != nil {
-			// Log error but don't fail the operation
-			fmt.Printf("Failed to create isolir profile: %v\n", err)
-		}
-
-		// Change customer's PPPoE profile to isolir profile
-		if customer.Username != "" {
-			err = h.Mikrotik.SetPPPProfile(customer.Username, isolirProfile)
-			if err != nil {
-				// Log error but don't fail the operation
-				fmt.Printf("Failed to change PPPoE profile for customer %s: %v\n", customer.Username, err)
-			} else {
-				// Disconnect active PPP session to force the new profile
-				err = h.Mikrotik.DisconnectPPPUser(customer.Username)
-				if err != nil {
-					// Log error but don't fail the operation
-					fmt.Printf("Failed to disconnect PPP session for customer %s: %v\n", customer.Username, err)
-				}
-			}
-		}
-	}
+	h.isolirConnection(customer)
 
-	// Send notification to customer
-	if customer.Phone != "" && h.WA != nil {
-		go h.WA.Send(customer.Phone, whatsapp.GenerateSuspensionMessage(customer.Name))
-	}
+	// Send notification to customer (once per suspension)
+	h.notifySuspension(customer, "manual")
 
 	respondJSON(w, http.StatusOK, map[string]interface{}{
 		"success": true,
@@ -2254,6 +6888,52 @@ func (h *Handler) IsolirCustomer(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// isolirConnection moves a customer's PPPoE secret to the isolir profile on
+// MikroTik and drops the session so it takes effect. MikroTik errors are
+// logged and don't fail the caller.
+func (h *Handler) isolirConnection(customer *models.Customer) {
+	if h.Mikrotik == nil {
+		return
+	}
+	// Create isolir profile if it doesn't exist
+	isolirProfile := "isolir-profile"
+	if err := h.Mikrotik.CreateIsolirProfile(isolirProfile, "64k/64k"); err != nil {
+		fmt.Printf("Failed to create isolir profile: %v\n", err)
+	}
+
+	if customer.Username == "" {
+		return
+	}
+	if err := h.Mikrotik.SetPPPProfile(customer.Username, isolirProfile); err != nil {
+		fmt.Printf("Failed to change PPPoE profile for customer %s: %v\n", customer.Username, err)
+		return
+	}
+	// Disconnect active PPP session to force the new profile
+	if err := h.Mikrotik.DisconnectPPPUser(customer.Username); err != nil {
+		fmt.Printf("Failed to disconnect PPP session for customer %s: %v\n", customer.Username, err)
+	}
+}
+
+// notifySuspension records the suspension event of a customer and sends the
+// suspension WhatsApp message only if this event has not been notified yet
+func (h *Handler) notifySuspension(customer *models.Customer, reason string) {
+	if _, err := h.DB.OpenSuspensionEvent(customer.ID, reason); err != nil {
+		fmt.Printf("Failed to record suspension of customer %s: %v\n", customer.CustomerCode, err)
+		return
+	}
+	if customer.Phone == "" || h.WA == nil {
+		return
+	}
+	claimed, err := h.DB.ClaimSuspensionNotice(customer.ID)
+	if err != nil {
+		fmt.Printf("Failed to claim suspension notice for customer %s: %v\n", customer.CustomerCode, err)
+		return
+	}
+	if claimed {
+		h.queueNotification(channelWhatsApp, customer.Phone, "", whatsapp.GenerateSuspensionMessage(customer.Name))
+	}
+}
+
 // UnsuspendCustomer reactivates a suspended customer
 func (h *Handler) UnsuspendCustomer(w http.ResponseWriter, r *http.Request) {
 	id := getPathInt64(r, "id")
@@ -2265,7 +6945,12 @@ func (h *Handler) UnsuspendCustomer(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		Profile string `json:"profile"`
 	}
-	json.NewDecoder(r.Body).Decode(&req)
+	if r.ContentLength != 0 {
+		if err := decodeJSON(w, r, &req); err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
 
 	// Update customer status to active
 	customer, err := h.DB.GetCustomer(id)
@@ -2274,51 +6959,79 @@ func (h *Handler) UnsuspendCustomer(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	customer.Status = "active"
-	if err := h.DB.UpdateCustomer(customer); err != nil {
+	if err := h.reactivateCustomer(customer, req.Profile); err != nil {
 		respondError(w, http.StatusInternalServerError, "Failed to unsuspend customer")
 		return
 	}
 
-	// Change PPPoE profile back to active profile via MikroTik API
-	if h.Mikrotik != nil {
-		// If no profile is specified, use the customer's package name as the profile
-		profile := req.Profile
-		if profile == "" {
-			if customer.Package != nil {
-				profile = customer.Package.Name
-			} else {
-				// Default to a standard profile name
-				profile = "default-profile"
-			}
-		}
+	// Send notification to customer
+	if customer.Phone != "" && h.WA != nil {
+		h.queueNotification(channelWhatsApp, customer.Phone, "", whatsapp.GenerateSuspensionMessage(customer.Name))
+	}
 
-		// Change customer's PPPoE profile back to active profile
-		if customer.Username != "" {
-			err = h.Mikrotik.SetPPPProfile(customer.Username, profile)
-			if err != nil {
-				// Log error but don't fail the operation
-				fmt.Printf("Failed to change PPPoE profile for customer %s: %v\n", customer.Username, err)
-			} else {
-				// Disconnect active PPP session to force the new profile
-				err = h.Mikrotik.DisconnectPPPUser(customer.Username)
-				if err != nil {
-					// Log error but don't fail the operation
-					fmt.Printf("Failed to disconnect PPP session for customer %s: %v\n", customer.Username, err)
-				}
-			}
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": fmt.Sprintf("Customer %s has been reactivated", customer.Name),
+	})
+}
+
+// reactivateCustomer sets a customer active again and restores their PPPoE
+// profile on MikroTik (the package name unless profile is given), then drops
+// the session so the profile takes effect. MikroTik errors are logged and
+// don't undo the reactivation.
+func (h *Handler) reactivateCustomer(customer *models.Customer, profile string) error {
+	customer.Status = "active"
+	if err := h.DB.UpdateCustomer(customer); err != nil {
+		return err
+	}
+	h.DB.CloseSuspensionEvent(customer.ID)
+	h.restoreConnection(customer, profile)
+	return nil
+}
+
+// restoreConnection puts a customer's PPPoE secret back on their package
+// profile (or profile, if given) and drops the session so it takes effect.
+// MikroTik errors are logged.
+func (h *Handler) restoreConnection(customer *models.Customer, profile string) {
+	if h.Mikrotik == nil || customer.Username == "" {
+		return
+	}
+	// If no profile is specified, use the customer's package name as the profile
+	if profile == "" {
+		if customer.Package != nil {
+			profile = customer.Package.Name
+		} else {
+			// Default to a standard profile name
+			profile = "default-profile"
 		}
 	}
-
-	// Send notification to customer
-	if customer.Phone != "" && h.WA != nil {
-		go h.WA.Send(customer.Phone, whatsapp.GenerateSuspensionMessage(customer.Name))
+	if err := h.Mikrotik.SetPPPProfile(customer.Username, profile); err != nil {
+		fmt.Printf("Failed to change PPPoE profile for customer %s: %v\n", customer.Username, err)
+		return
+	}
+	// Disconnect active PPP session to force the new profile
+	if err := h.Mikrotik.DisconnectPPPUser(customer.Username); err != nil {
+		fmt.Printf("Failed to disconnect PPP session for customer %s: %v\n", customer.Username, err)
+	}
+}
+
+// autoUnsuspend reactivates a suspended customer once a payment leaves them
+// with no overdue invoices, so service returns without manual intervention
+func (h *Handler) autoUnsuspend(customerID int64) {
+	customer, err := h.DB.GetCustomer(customerID)
+	if err != nil || customer.Status != "suspended" {
+		return
+	}
+	overdue, err := h.DB.CountOverdueInvoices(customerID, h.Config.Now().Format("2006-01-02"))
+	if err != nil || overdue > 0 {
+		return
+	}
+	if err := h.reactivateCustomer(customer, ""); err != nil {
+		fmt.Printf("[PAYMENT] Failed to reactivate customer %s: %v\n", customer.CustomerCode, err)
+		return
 	}
-
-	respondJSON(w, http.StatusOK, map[string]interface{}{
-		"success": true,
-		"message": fmt.Sprintf("Customer %s has been reactivated", customer.Name),
-	})
+	h.DB.CreateLog(nil, "info", "billing",
+		fmt.Sprintf("Customer %s reactivated automatically after payment", customer.CustomerCode), "")
 }
 
 // UnsuspendCustomerWithoutPayment reactivates a suspended customer without requiring payment
@@ -2338,28 +7051,56 @@ func (h *Handler) UnsuspendCustomerWithoutPayment(w http.ResponseWriter, r *http
 	}
 
 	// Get all unpaid invoices for this customer
-	invoices, _, err := h.DB.GetInvoices(&customer.ID, "pending", 1000, 0)
+	invoices, err := h.DB.GetUnpaidInvoices(customer.ID)
 	if err != nil {
 		respondError(w, http.StatusInternalServerError, "Failed to get customer invoices")
 		return
 	}
 
+	// Only combine invoices nothing was paid on yet (pending, or marked
+	// overdue); partially paid ones stay open
+	var combineIDs []int64
+	var combineAmount float64
+	for _, invoice := range invoices {
+		if (invoice.Status == models.InvoicePending || invoice.Status == models.InvoiceOverdue) && invoice.PaidAmount == 0 {
+			combineIDs = append(combineIDs, invoice.ID)
+			combineAmount += invoice.Total - invoice.PaidAmount
+		}
+	}
+
+	// Enforce the carry-forward policy: once the limit would be exceeded the
+	// customer is terminated instead of being reactivated again
+	carriedCount, carriedAmount, err := h.DB.GetCarriedForwardTotals(customer.ID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get carried forward invoices")
+		return
+	}
+	if reason := carryForwardLimitExceeded(h.Config, carriedCount+len(combineIDs), carriedAmount+combineAmount); reason != "" {
+		customer.Status = "terminated"
+		if err := h.DB.UpdateCustomer(customer); err != nil {
+			respondError(w, http.StatusInternalServerError, "Failed to terminate customer")
+			return
+		}
+		h.DB.CreateLog(nil, "warning", "billing", fmt.Sprintf("Customer %s terminated: carry-forward limit reached", customer.CustomerCode), reason)
+		respondJSON(w, http.StatusConflict, map[string]interface{}{
+			"success":    false,
+			"terminated": true,
+			"error":      fmt.Sprintf("Carry-forward limit reached (%s); customer %s has been terminated", reason, customer.Name),
+		})
+		return
+	}
+
 	// Update customer status to active
 	customer.Status = "active"
 	if err := h.DB.UpdateCustomer(customer); err != nil {
 		respondError(w, http.StatusInternalServerError, "Failed to unsuspend customer")
 		return
 	}
+	h.DB.CloseSuspensionEvent(customer.ID)
 
 	// Mark all unpaid invoices as 'combined' status instead of paid
-	for _, invoice := range invoices {
-		// Only combine invoices that are pending (not partially paid)
-		if invoice.Status == models.InvoicePending {
-			invoice.Status = models.InvoiceCombined // New status to indicate combined to next month
-			if err := h.DB.UpdateInvoice(invoice); err != nil {
-				fmt.Printf("Failed to update invoice status: %v\n", err) // Log error but don't fail
-			}
-		}
+	if err := h.DB.CombineInvoices(combineIDs); err != nil {
+		fmt.Printf("Failed to update invoice status: %v\n", err) // Log error but don't fail
 	}
 
 	// Generate new invoice that includes the combined amounts
@@ -2393,16 +7134,307 @@ func (h *Handler) UnsuspendCustomerWithoutPayment(w http.ResponseWriter, r *http
 
 	// Send notification to customer
 	if customer.Phone != "" && h.WA != nil {
-		go h.WA.Send(customer.Phone, fmt.Sprintf("Dear %s, your service has been reactivated. Please settle your outstanding bills soon.", customer.Name))
+		h.queueNotification(channelWhatsApp, customer.Phone, "", fmt.Sprintf("Dear %s, your service has been reactivated. Please settle your outstanding bills soon.", customer.Name))
 	}
 
 	respondJSON(w, http.StatusOK, map[string]interface{}{
 		"success":          true,
 		"message":          fmt.Sprintf("Customer %s has been reactivated without payment. Unpaid invoices combined to next month.", customer.Name),
-		"combinedInvoices": len(invoices),
+		"combinedInvoices": len(combineIDs),
+	})
+}
+
+// ============== Vacation Hold ==============
+
+// HoldCustomer pauses an active customer's billing until resumeOn
+// (YYYY-MM-DD), optionally isolating their connection for the duration.
+// Invoices already issued stay due; no new ones are generated while held.
+func (h *Handler) HoldCustomer(w http.ResponseWriter, r *http.Request) {
+	id := getPathInt64(r, "id")
+	customer, err := h.DB.GetCustomer(id)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Customer not found")
+		return
+	}
+
+	var req struct {
+		ResumeOn          string `json:"resumeOn"`
+		SuspendConnection bool   `json:"suspendConnection"`
+		Reason            string `json:"reason"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	resumeOn, err := time.ParseInLocation("2006-01-02", strings.TrimSpace(req.ResumeOn), h.Config.Loc())
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "resumeOn must be a date (YYYY-MM-DD)")
+		return
+	}
+	now := h.Config.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	if !resumeOn.After(today) {
+		respondError(w, http.StatusBadRequest, "resumeOn must be after today")
+		return
+	}
+	if max := h.Config.HoldMaxDays; max > 0 && resumeOn.After(today.AddDate(0, 0, max)) {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("A hold may last at most %d days", max))
+		return
+	}
+
+	hold := &models.CustomerHold{
+		CustomerID:        customer.ID,
+		ResumeOn:          resumeOn.Format("2006-01-02"),
+		SuspendConnection: req.SuspendConnection,
+		Reason:            strings.TrimSpace(req.Reason),
+		CreatedBy:         "system",
+	}
+	if claims := middleware.GetUserFromContext(r.Context()); claims != nil {
+		hold.CreatedBy = claims.Username
+	}
+	if _, err := h.DB.StartCustomerHold(hold); err != nil {
+		if errors.Is(err, database.ErrCustomerNotActive) {
+			respondError(w, http.StatusConflict, "Only active customers can be put on hold")
+			return
+		}
+		respondError(w, http.StatusInternalServerError, "Failed to put customer on hold")
+		return
+	}
+
+	if hold.SuspendConnection {
+		h.isolirConnection(customer)
+	}
+	h.DB.CreateLog(nil, "info", "billing",
+		fmt.Sprintf("Customer %s put on hold until %s", customer.CustomerCode, hold.ResumeOn), hold.Reason)
+	h.queueNotification(channelWhatsApp, customer.Phone, "",
+		fmt.Sprintf("Dear %s, your subscription is on hold until %s. No invoices will be issued during the hold and service resumes automatically on that date.",
+			customer.Name, resumeOn.Format("02/01/2006")))
+
+	respondJSON(w, http.StatusCreated, hold)
+}
+
+// GetCustomerHold returns the open hold of a customer
+func (h *Handler) GetCustomerHold(w http.ResponseWriter, r *http.Request) {
+	hold, err := h.DB.GetCustomerHold(getPathInt64(r, "id"))
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Customer is not on hold")
+		return
+	}
+	respondJSON(w, http.StatusOK, hold)
+}
+
+// GetCustomerHolds lists the customers currently on hold
+func (h *Handler) GetCustomerHolds(w http.ResponseWriter, r *http.Request) {
+	holds, err := h.DB.GetOpenCustomerHolds()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get holds")
+		return
+	}
+	respondJSON(w, http.StatusOK, holds)
+}
+
+// ResumeCustomerHold ends a customer's hold before its resume date
+func (h *Handler) ResumeCustomerHold(w http.ResponseWriter, r *http.Request) {
+	hold, err := h.DB.GetCustomerHold(getPathInt64(r, "id"))
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Customer is not on hold")
+		return
+	}
+	if err := h.resumeHold(hold, "manually"); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to resume customer")
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": fmt.Sprintf("Customer %s has been resumed", hold.CustomerName),
+	})
+}
+
+// resumeHold ends a hold and makes the customer active again, restoring their
+// connection if the hold isolated it. A customer whose status was changed
+// during the hold (e.g. terminated) keeps that status.
+func (h *Handler) resumeHold(hold *models.CustomerHold, how string) error {
+	ended, err := h.DB.EndCustomerHold(hold.ID)
+	if err != nil || !ended {
+		return err
+	}
+	customer, err := h.DB.GetCustomer(hold.CustomerID)
+	if err != nil {
+		return err
+	}
+	if customer.Status != "hold" {
+		return nil
+	}
+
+	if hold.SuspendConnection {
+		err = h.reactivateCustomer(customer, "")
+	} else {
+		customer.Status = "active"
+		err = h.DB.UpdateCustomer(customer)
+	}
+	if err != nil {
+		return err
+	}
+	h.DB.CreateLog(nil, "info", "billing",
+		fmt.Sprintf("Customer %s resumed from hold %s", customer.CustomerCode, how), "")
+	h.queueNotification(channelWhatsApp, customer.Phone, "",
+		fmt.Sprintf("Dear %s, welcome back! Your subscription is active again and billing resumes from the next invoice.", customer.Name))
+	return nil
+}
+
+// ResumeDueHolds resumes the customers whose hold ends today or earlier.
+// Called by the scheduler.
+func (h *Handler) ResumeDueHolds() {
+	holds, err := h.DB.GetDueCustomerHolds(h.Config.Now().Format("2006-01-02"))
+	if err != nil {
+		fmt.Printf("[BILLING] Error loading due holds: %v\n", err)
+		return
+	}
+	for _, hold := range holds {
+		if err := h.resumeHold(hold, "on schedule"); err != nil {
+			fmt.Printf("[BILLING] Failed to resume customer %s from hold: %v\n", hold.CustomerCode, err)
+		}
+	}
+}
+
+// setRXThreshold applies one RX power band or alert hysteresis setting to the config
+func setRXThreshold(cfg *config.Config, key string, value float64) {
+	switch key {
+	case "rx_excellent_dbm":
+		cfg.RXExcellentDBm = value
+	case "rx_good_dbm":
+		cfg.RXGoodDBm = value
+	case "rx_warning_dbm":
+		cfg.RXWarningDBm = value
+	case "rx_overload_dbm":
+		cfg.RXOverloadDBm = value
+	case "rx_alert_hysteresis":
+		if value >= 0 {
+			cfg.RXAlertHysteresisDB = value
+		}
+	}
+}
+
+// carryForwardLimitExceeded reports why carrying forward the given number of
+// invoices and amount would break the configured policy, or "" if allowed
+func carryForwardLimitExceeded(cfg *config.Config, count int, amount float64) string {
+	if cfg == nil {
+		return ""
+	}
+	if cfg.CarryForwardMaxInvoices > 0 && count > cfg.CarryForwardMaxInvoices {
+		return fmt.Sprintf("%d invoices carried forward, maximum is %d", count, cfg.CarryForwardMaxInvoices)
+	}
+	if cfg.CarryForwardMaxAmount > 0 && amount > cfg.CarryForwardMaxAmount {
+		return fmt.Sprintf("%.0f carried forward, maximum is %.0f", amount, cfg.CarryForwardMaxAmount)
+	}
+	return ""
+}
+
+// GetCustomerStatement returns a statement of invoices and payments for a
+// period. Use format=html for a printable document (print to PDF from the browser).
+func (h *Handler) GetCustomerStatement(w http.ResponseWriter, r *http.Request) {
+	id := getPathInt64(r, "id")
+
+	customer, err := h.DB.GetCustomer(id)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Customer not found")
+		return
+	}
+
+	from, err := parseQueryTime(r.URL.Query().Get("from"), false, h.Config.Loc())
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid 'from' date, use YYYY-MM-DD or RFC3339")
+		return
+	}
+	to, err := parseQueryTime(r.URL.Query().Get("to"), true, h.Config.Loc())
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid 'to' date, use YYYY-MM-DD or RFC3339")
+		return
+	}
+	if to == nil {
+		now := time.Now()
+		to = &now
+	}
+	if from != nil && from.After(*to) {
+		respondError(w, http.StatusBadRequest, "'from' must be before 'to'")
+		return
+	}
+
+	invoices, _, err := h.DB.GetInvoices(&customer.ID, "", 10000, 0)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get invoices")
+		return
+	}
+	payments, _, err := h.DB.GetPayments(&customer.ID, 10000, 0)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get payments")
+		return
+	}
+
+	statement := buildStatement(customer, invoices, payments, from, *to)
+
+	if r.URL.Query().Get("format") == "html" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := h.tmpl.ExecuteTemplate(w, "statement.html", statement); err != nil {
+			fmt.Printf("[BILLING] Failed to render statement: %v\n", err)
+		}
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data":    statement,
 	})
 }
 
+// buildStatement computes the opening balance from everything before from,
+// then lists invoices (debit) and completed payments (credit) in [from, to]
+// in date order with a running balance. Cancelled invoices are ignored.
+func buildStatement(customer *models.Customer, invoices []*models.Invoice, payments []*models.Payment, from *time.Time, to time.Time) *models.Statement {
+	st := &models.Statement{
+		Customer: customer,
+		From:     from,
+		To:       to,
+		Lines:    []models.StatementLine{},
+	}
+
+	var lines []models.StatementLine
+	for _, inv := range invoices {
+		if inv.Status == models.InvoiceCancelled || inv.CreatedAt.After(to) {
+			continue
+		}
+		if from != nil && inv.CreatedAt.Before(*from) {
+			st.OpeningBalance += inv.Total
+			continue
+		}
+		lines = append(lines, models.StatementLine{Date: inv.CreatedAt, Type: "invoice", Reference: inv.InvoiceNo, Debit: inv.Total})
+	}
+	for _, p := range payments {
+		if p.Status != "completed" || p.PaymentDate.After(to) {
+			continue
+		}
+		if from != nil && p.PaymentDate.Before(*from) {
+			st.OpeningBalance -= p.Amount
+			continue
+		}
+		lines = append(lines, models.StatementLine{Date: p.PaymentDate, Type: "payment", Reference: p.PaymentNo, Credit: p.Amount})
+	}
+
+	sort.SliceStable(lines, func(i, j int) bool { return lines[i].Date.Before(lines[j].Date) })
+
+	balance := st.OpeningBalance
+	for _, line := range lines {
+		balance += line.Debit - line.Credit
+		line.Balance = balance
+		st.TotalInvoiced += line.Debit
+		st.TotalPaid += line.Credit
+		st.Lines = append(st.Lines, line)
+	}
+	st.ClosingBalance = balance
+
+	return st
+}
+
 // GetInvoices returns all invoices
 func (h *Handler) GetInvoices(w http.ResponseWriter, r *http.Request) {
 	status := r.URL.Query().Get("status")
@@ -2417,58 +7449,392 @@ func (h *Handler) GetInvoices(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	invoices, total, err := h.DB.GetInvoices(customerID, status, limit, offset)
-	if err != nil {
-		respondError(w, http.StatusInternalServerError, "Failed to get invoices")
+	invoices, total, err := h.DB.GetInvoices(customerID, status, limit, offset)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get invoices")
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"invoices": invoices,
+		"total":    total,
+		"limit":    limit,
+		"offset":   offset,
+	})
+}
+
+// CreateInvoice creates a new invoice
+func (h *Handler) CreateInvoice(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		CustomerID  int64                `json:"customerId"`
+		PeriodStart time.Time            `json:"periodStart"`
+		PeriodEnd   time.Time            `json:"periodEnd"`
+		DueDate     time.Time            `json:"dueDate"`
+		Subtotal    float64              `json:"subtotal"`
+		Tax         float64              `json:"tax"`
+		Discount    float64              `json:"discount"`
+		Total       float64              `json:"total"`
+		Status      models.InvoiceStatus `json:"status"`
+		Items       []models.InvoiceItem `json:"items"`
+		Notes       string               `json:"notes"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	invoice := models.Invoice{
+		CustomerID:  req.CustomerID,
+		PeriodStart: req.PeriodStart,
+		PeriodEnd:   req.PeriodEnd,
+		DueDate:     req.DueDate,
+		Subtotal:    req.Subtotal,
+		Tax:         req.Tax,
+		Discount:    req.Discount,
+		Total:       req.Total,
+		Status:      req.Status,
+		Items:       req.Items,
+		Notes:       req.Notes,
+	}
+	if invoice.Status == "" {
+		invoice.Status = models.InvoicePending
+	}
+	h.roundInvoice(&invoice)
+	created, err := h.DB.CreateInvoice(&invoice)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to create invoice")
+		return
+	}
+	respondJSON(w, http.StatusCreated, created)
+}
+
+// Notification channels, also the outbox providers
+const (
+	channelEmail    = "email"
+	channelWhatsApp = "whatsapp"
+	channelFCM      = "fcm"
+)
+
+// notifyInvoice dispatches the invoice notification over email, WhatsApp and
+// FCM through the bounded send pools. channels limits the dispatch to the given channels (nil = all). It
+// returns the channels the notification was actually sent to.
+func (h *Handler) notifyInvoice(customer *models.Customer, invoice *models.Invoice, channels map[string]bool) []string {
+	want := func(ch string) bool { return channels == nil || channels[ch] }
+	dueDate := invoice.DueDate.Format("02/01/2006")
+	sent := []string{}
+
+	// Send Email Notification
+	if want(channelEmail) && customer.Email != "" && h.Mailer != nil {
+		html := mailer.GenerateInvoiceHTML(
+			customer.Name,
+			invoice.InvoiceNo,
+			dueDate,
+			h.formatMoney(invoice.Total),
+		)
+		h.queueNotification(channelEmail, customer.Email, "New Invoice Generated - GO-ACS", html)
+		sent = append(sent, channelEmail)
+	}
+
+	// Send WA Notification
+	if want(channelWhatsApp) && customer.Phone != "" && h.WA != nil {
+		msg := whatsapp.GenerateInvoiceMessage(
+			customer.Name,
+			invoice.InvoiceNo,
+			dueDate,
+			h.formatMoney(invoice.Total),
+		)
+		h.queueNotification(channelWhatsApp, customer.Phone, "", msg)
+		sent = append(sent, channelWhatsApp)
+	}
+
+	// Send FCM Notification
+	if want(channelFCM) && customer.FCMToken != "" && h.FCM != nil {
+		title := "New Invoice Generated - GO-ACS"
+		body := fmt.Sprintf("Dear %s, a new invoice %s for %s has been generated. Due date: %s.",
+			customer.Name, invoice.InvoiceNo, h.formatMoney(invoice.Total), dueDate)
+		h.queueNotification(channelFCM, customer.FCMToken, title, body)
+		sent = append(sent, channelFCM)
+	}
+
+	return sent
+}
+
+// parseReminderDays parses a comma separated list of days before the due date,
+// e.g. "3,0" (0 = on the due date)
+func parseReminderDays(s string) ([]int, error) {
+	var days []int
+	seen := make(map[int]bool)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		d, err := strconv.Atoi(part)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid reminder day %q", part)
+		}
+		if !seen[d] {
+			seen[d] = true
+			days = append(days, d)
+		}
+	}
+	return days, nil
+}
+
+// ProcessInvoiceReminders reminds customers of invoices due in each of the
+// configured number of days, then marks pending invoices past their due date
+// overdue and notifies those customers. Every reminder is claimed before it is
+// sent, so running this more than once a day doesn't repeat messages.
+func (h *Handler) ProcessInvoiceReminders() {
+	days, err := parseReminderDays(h.Config.InvoiceReminderDays)
+	if err != nil {
+		fmt.Printf("[BILLING] Invalid INVOICE_REMINDER_DAYS: %v\n", err)
+	}
+
+	now := h.Config.Now()
+	reminded := 0
+	for _, d := range days {
+		due, err := h.DB.GetInvoicesDueOn(now.AddDate(0, 0, d).Format("2006-01-02"))
+		if err != nil {
+			fmt.Printf("[BILLING] Error fetching invoices due in %d days: %v\n", d, err)
+			continue
+		}
+		for _, inv := range due {
+			if claimed, err := h.DB.ClaimInvoiceReminder(inv.ID, d); err != nil || !claimed {
+				continue
+			}
+			customer, err := h.DB.GetCustomer(inv.CustomerID)
+			if err != nil {
+				continue
+			}
+			status := "is due today"
+			if d > 0 {
+				status = fmt.Sprintf("is due in %d days", d)
+			}
+			h.notifyInvoiceReminder(customer, inv, status,
+				whatsapp.GenerateInvoiceReminderMessage(customer.Name, inv.InvoiceNo, inv.DueDate.Format("02/01/2006"), h.formatMoney(inv.Total-inv.PaidAmount), d))
+			reminded++
+		}
+	}
+
+	overdue, err := h.DB.MarkOverdueInvoices(now.Format("2006-01-02"))
+	if err != nil {
+		fmt.Printf("[BILLING] Error marking overdue invoices: %v\n", err)
+		return
+	}
+	for _, inv := range overdue {
+		customer, err := h.DB.GetCustomer(inv.CustomerID)
+		if err != nil {
+			continue
+		}
+		h.notifyInvoiceReminder(customer, inv, "is overdue",
+			whatsapp.GenerateInvoiceOverdueMessage(customer.Name, inv.InvoiceNo, inv.DueDate.Format("02/01/2006"), h.formatMoney(inv.Total-inv.PaidAmount)))
+	}
+
+	if len(overdue) > 0 {
+		h.DB.CreateLog(nil, "info", "billing", fmt.Sprintf("Marked %d invoice(s) overdue", len(overdue)), "")
+	}
+	if reminded > 0 || len(overdue) > 0 {
+		fmt.Printf("[BILLING] Sent %d due-date reminder(s), marked %d invoice(s) overdue\n", reminded, len(overdue))
+	}
+}
+
+// notifyInvoiceReminder sends a reminder about an unpaid invoice by email and WhatsApp
+func (h *Handler) notifyInvoiceReminder(customer *models.Customer, invoice *models.Invoice, status, waMessage string) {
+	if customer.Email != "" && h.Mailer != nil {
+		html := mailer.GenerateInvoiceReminderHTML(customer.Name, invoice.InvoiceNo,
+			invoice.DueDate.Format("02/01/2006"), h.formatMoney(invoice.Total-invoice.PaidAmount), status)
+		h.queueNotification(channelEmail, customer.Email, "Invoice Reminder - GO-ACS", html)
+	}
+	if customer.Phone != "" && h.WA != nil {
+		h.queueNotification(channelWhatsApp, customer.Phone, "", waMessage)
+	}
+}
+
+// parseChannels validates the requested notification channels; empty means all
+func parseChannels(requested []string) (map[string]bool, error) {
+	if len(requested) == 0 {
+		return nil, nil
+	}
+	channels := make(map[string]bool)
+	for _, ch := range requested {
+		ch = strings.ToLower(strings.TrimSpace(ch))
+		switch ch {
+		case channelEmail, channelWhatsApp, channelFCM:
+			channels[ch] = true
+		default:
+			return nil, fmt.Errorf("unknown channel %q (use email, whatsapp or fcm)", ch)
+		}
+	}
+	return channels, nil
+}
+
+// ResendInvoice re-sends the notification of a single unpaid invoice
+func (h *Handler) ResendInvoice(w http.ResponseWriter, r *http.Request) {
+	id := getPathInt64(r, "id")
+	if id == 0 {
+		respondError(w, http.StatusBadRequest, "Invalid invoice ID")
+		return
+	}
+
+	var req struct {
+		Channels []string `json:"channels"`
+	}
+	if r.ContentLength > 0 {
+		if err := decodeJSON(w, r, &req); err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	channels, err := parseChannels(req.Channels)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	invoice, err := h.DB.GetInvoice(id)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Invoice not found")
+		return
+	}
+	if invoice.Status == models.InvoicePaid || invoice.Status == models.InvoiceCancelled {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("Invoice is %s", invoice.Status))
+		return
+	}
+
+	customer, err := h.DB.GetCustomer(invoice.CustomerID)
+	if err != nil || customer == nil {
+		respondError(w, http.StatusNotFound, "Customer not found")
+		return
+	}
+
+	sent := h.notifyInvoice(customer, invoice, channels)
+	if len(sent) == 0 {
+		respondError(w, http.StatusBadRequest, "Customer has no contact for the requested channels")
 		return
 	}
+
+	h.DB.CreateLog(nil, "info", "billing",
+		fmt.Sprintf("Invoice %s notification re-sent", invoice.InvoiceNo), strings.Join(sent, ","))
 	respondJSON(w, http.StatusOK, map[string]interface{}{
-		"invoices": invoices,
-		"total":    total,
-		"limit":    limit,
-		"offset":   offset,
+		"success":  true,
+		"channels": sent,
+		"message":  fmt.Sprintf("Invoice %s re-sent via %s", invoice.InvoiceNo, strings.Join(sent, ", ")),
 	})
 }
 
-// CreateInvoice creates a new invoice
-func (h *Handler) CreateInvoice(w http.ResponseWriter, r *http.Request) {
-	var invoice models.Invoice
-	if err := json.NewDecoder(r.Body).Decode(&invoice); err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid request body")
-		return
+// ResendPendingInvoices re-sends notifications for all unpaid invoices,
+// e.g. after fixing SMTP or WhatsApp credentials
+func (h *Handler) ResendPendingInvoices(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Status   string   `json:"status"` // pending, overdue or unpaid (both; default)
+		Channels []string `json:"channels"`
 	}
-	if invoice.Status == "" {
-		invoice.Status = models.InvoicePending
+	if r.ContentLength > 0 {
+		if err := decodeJSON(w, r, &req); err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
 	}
-	created, err := h.DB.CreateInvoice(&invoice)
+	channels, err := parseChannels(req.Channels)
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, "Failed to create invoice")
+		respondError(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	respondJSON(w, http.StatusCreated, created)
+
+	var statuses []string
+	switch req.Status {
+	case "", "unpaid":
+		statuses = []string{string(models.InvoicePending), string(models.InvoiceOverdue)}
+	case string(models.InvoicePending), string(models.InvoiceOverdue):
+		statuses = []string{req.Status}
+	default:
+		respondError(w, http.StatusBadRequest, "status must be pending, overdue or unpaid")
+		return
+	}
+
+	var invoices []*models.Invoice
+	for _, status := range statuses {
+		list, _, err := h.DB.GetInvoices(nil, status, 10000, 0)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "Failed to get invoices")
+			return
+		}
+		invoices = append(invoices, list...)
+	}
+
+	sentCount := 0
+	skipped := []models.InvoiceRunCustomer{}
+	customers := make(map[int64]*models.Customer)
+	for _, invoice := range invoices {
+		customer, ok := customers[invoice.CustomerID]
+		if !ok {
+			customer, _ = h.DB.GetCustomer(invoice.CustomerID)
+			customers[invoice.CustomerID] = customer
+		}
+		if customer == nil {
+			skipped = append(skipped, models.InvoiceRunCustomer{
+				CustomerID: invoice.CustomerID, Reason: fmt.Sprintf("%s: customer not found", invoice.InvoiceNo),
+			})
+			continue
+		}
+		if len(h.notifyInvoice(customer, invoice, channels)) == 0 {
+			skipped = append(skipped, models.InvoiceRunCustomer{
+				CustomerID: customer.ID, CustomerCode: customer.CustomerCode, Name: customer.Name,
+				Reason: fmt.Sprintf("%s: no contact for the requested channels", invoice.InvoiceNo),
+			})
+			continue
+		}
+		sentCount++
+	}
+
+	h.DB.CreateLog(nil, "info", "billing",
+		fmt.Sprintf("Re-sent %d unpaid invoice notification(s), skipped %d", sentCount, len(skipped)), "")
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"count":   sentCount,
+		"skipped": skipped,
+		"message": fmt.Sprintf("Re-sent %d invoice(s)", sentCount),
+	})
 }
 
 // GenerateMonthlyInvoices creates invoices for all active customers for the current month
 // GenerateInvoicesInternal handles the core logic for invoice generation
-func (h *Handler) GenerateInvoicesInternal() (int, error) {
+// Customers without a usable package are reported rather than silently
+// skipped; if a default package is configured they are billed with it.
+func (h *Handler) GenerateInvoicesInternal() (*models.InvoiceRunReport, error) {
 	// Get all active customers with packages
 	customers, _, err := h.DB.GetCustomers("active", "", 1000, 0)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
-	now := time.Now()
+	now := h.Config.Now()
 	monthYear := now.Format("200601")
-	generated := 0
+	report := &models.InvoiceRunReport{
+		Skipped:        []models.InvoiceRunCustomer{},
+		DefaultPackage: []models.InvoiceRunCustomer{},
+	}
+	skip := func(c *models.Customer, reason string) {
+		report.Skipped = append(report.Skipped, models.InvoiceRunCustomer{
+			CustomerID: c.ID, CustomerCode: c.CustomerCode, Name: c.Name, Reason: reason,
+		})
+	}
 
 	for _, customer := range customers {
-		if customer.PackageID == 0 {
-			continue // Skip customers without package
+		packageID := customer.PackageID
+		usedDefault := false
+		if packageID == 0 {
+			if h.Config == nil || h.Config.DefaultPackageID == 0 {
+				skip(customer, "no package assigned")
+				continue
+			}
+			packageID = h.Config.DefaultPackageID
+			usedDefault = true
 		}
 
 		// Get package to get price
-		pkg, err := h.DB.GetPackage(customer.PackageID)
+		pkg, err := h.DB.GetPackage(packageID)
 		if err != nil || pkg == nil {
+			skip(customer, fmt.Sprintf("package %d not found", packageID))
 			continue
 		}
 
@@ -2476,68 +7842,69 @@ func (h *Handler) GenerateInvoicesInternal() (int, error) {
 		invoiceNo := fmt.Sprintf("INV-%s-%04d", monthYear, customer.ID)
 
 		// Create invoice
+		periodStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+		item, prorated := h.subscriptionItem(pkg, customer.JoinDate, periodStart)
 		invoice := &models.Invoice{
 			CustomerID:  customer.ID,
 			InvoiceNo:   invoiceNo,
-			Subtotal:    pkg.Price,
-			Total:       pkg.Price,
-			PeriodStart: time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location()),
+			Subtotal:    item.Amount,
+			PeriodStart: periodStart,
 			PeriodEnd:   time.Date(now.Year(), now.Month()+1, 0, 0, 0, 0, 0, now.Location()),
 			DueDate:     time.Date(now.Year(), now.Month()+1, 10, 0, 0, 0, 0, now.Location()),
 			Status:      models.InvoicePending,
+			Items:       []models.InvoiceItem{item},
 			Notes:       fmt.Sprintf("Monthly subscription - %s", pkg.Name),
 		}
+		if prorated {
+			invoice.Notes = "First month subscription - " + item.Description
+		} else if joinItem, ok := h.joinMonthItem(pkg, customer, periodStart); ok {
+			invoice.Items = append(invoice.Items, joinItem)
+			invoice.Subtotal += joinItem.Amount
+			invoice.Notes += " + join month " + joinItem.Description
+		}
+		h.priceInvoice(invoice, h.invoiceDiscountPercent(customer.ID, pkg))
 
 		_, err = h.DB.CreateInvoice(invoice)
 		if err == nil {
-			generated++
-
-			// Send Email Notification
-			if customer.Email != "" && h.Mailer != nil {
-				html := mailer.GenerateInvoiceHTML(
-					customer.Name,
-					invoiceNo,
-					invoice.DueDate.Format("02/01/2006"),
-					fmt.Sprintf("Rp %.2f", invoice.Total),
-				)
-				go h.Mailer.Send(customer.Email, "New Invoice Generated - GO-ACS", html)
+			report.Generated++
+			if usedDefault {
+				report.DefaultPackage = append(report.DefaultPackage, models.InvoiceRunCustomer{
+					CustomerID: customer.ID, CustomerCode: customer.CustomerCode, Name: customer.Name,
+					Reason: fmt.Sprintf("no package assigned, billed with default package %s", pkg.Name),
+				})
 			}
 
-			// Send WA Notification
-			if customer.Phone != "" && h.WA != nil {
-				msg := whatsapp.GenerateInvoiceMessage(
-					customer.Name,
-					invoiceNo,
-					invoice.DueDate.Format("02/01/2006"),
-					fmt.Sprintf("Rp %.2f", invoice.Total),
-				)
-				go h.WA.Send(customer.Phone, msg)
-			}
-
-			// Send FCM Notification
-			if customer.FCMToken != "" && h.FCM != nil {
-				title := "New Invoice Generated - GO-ACS"
-				body := fmt.Sprintf("Dear %s, a new invoice %s for Rp %.2f has been generated. Due date: %s.",
-					customer.Name, invoiceNo, invoice.Total, invoice.DueDate.Format("02/01/2006"))
-				go h.FCM.Send(customer.FCMToken, title, body)
-			}
+			h.notifyInvoice(customer, invoice, nil)
 		}
 	}
-	return generated, nil
+
+	if len(report.Skipped) > 0 {
+		details, _ := json.Marshal(report.Skipped)
+		h.DB.CreateLog(nil, "warning", "billing",
+			fmt.Sprintf("Invoice generation skipped %d customer(s)", len(report.Skipped)), string(details))
+	}
+	return report, nil
 }
 
 // GenerateMonthlyInvoices creates invoices for all active customers for the current month
 func (h *Handler) GenerateMonthlyInvoices(w http.ResponseWriter, r *http.Request) {
-	generated, err := h.GenerateInvoicesInternal()
+	report, err := h.GenerateInvoicesInternal()
 	if err != nil {
 		respondError(w, http.StatusInternalServerError, "Failed to generate invoices")
 		return
 	}
 
+	message := fmt.Sprintf("Generated %d invoices", report.Generated)
+	if len(report.Skipped) > 0 {
+		message += fmt.Sprintf(", skipped %d customer(s)", len(report.Skipped))
+	}
+
 	respondJSON(w, http.StatusOK, map[string]interface{}{
-		"success": true,
-		"count":   generated,
-		"message": fmt.Sprintf("Generated %d invoices", generated),
+		"success":        true,
+		"count":          report.Generated,
+		"skipped":        report.Skipped,
+		"defaultPackage": report.DefaultPackage,
+		"message":        message,
 	})
 }
 
@@ -2564,7 +7931,53 @@ func (h *Handler) GetInvoice(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// MarkInvoicePaid marks an invoice as paid
+// sendPaymentReceipt sends the payment receipt for a paid invoice over email,
+// WhatsApp and push. Nothing is sent when receipts are disabled globally
+// (SEND_RECEIPT) or the customer opted out.
+func (h *Handler) sendPaymentReceipt(invoice *models.Invoice, paidAt time.Time) {
+	if h.Config != nil && !h.Config.SendReceipt {
+		return
+	}
+	if !h.DB.CustomerWantsReceipts(invoice.CustomerID) {
+		return
+	}
+	customer, err := h.DB.GetCustomer(invoice.CustomerID)
+	if err != nil {
+		return
+	}
+
+	if customer.Email != "" && h.Mailer != nil {
+		html := mailer.GeneratePaymentReceiptHTML(
+			customer.Name,
+			invoice.InvoiceNo,
+			h.formatMoney(invoice.Total),
+			paidAt.Format("02/01/2006 15:04"),
+		)
+		h.queueNotification(channelEmail, customer.Email, "Payment Receipt - GO-ACS", html)
+	}
+
+	if customer.Phone != "" && h.WA != nil {
+		msg := whatsapp.GeneratePaymentReceiptMessage(
+			customer.Name,
+			invoice.InvoiceNo,
+			paidAt.Format("02/01/2006 15:04"),
+			h.formatMoney(invoice.Total),
+		)
+		h.queueNotification(channelWhatsApp, customer.Phone, "", msg)
+	}
+
+	if customer.FCMToken != "" && h.FCM != nil {
+		title := "Payment Receipt - GO-ACS"
+		body := fmt.Sprintf("Dear %s, payment for invoice %s has been received. Amount: %s.",
+			customer.Name, invoice.InvoiceNo, h.formatMoney(invoice.Total))
+		h.queueNotification(channelFCM, customer.FCMToken, title, body)
+	}
+}
+
+// MarkInvoicePaid records a payment on an invoice. An amount below the
+// outstanding balance is a partial payment: it is added to the paid amount and
+// the invoice stays partial until it is paid in full. Without a body or an
+// amount the whole outstanding balance is paid.
 func (h *Handler) MarkInvoicePaid(w http.ResponseWriter, r *http.Request) {
 	id := getPathInt64(r, "id")
 	if id == 0 {
@@ -2576,72 +7989,60 @@ func (h *Handler) MarkInvoicePaid(w http.ResponseWriter, r *http.Request) {
 		Amount float64 `json:"amount"`
 		Method string  `json:"method"`
 	}
-	json.NewDecoder(r.Body).Decode(&req)
-
-	invoice, err := h.DB.GetInvoice(id)
-	if err != nil {
-		respondError(w, http.StatusNotFound, "Invoice not found")
-		return
+	// Only an empty body pays the whole balance: a body that fails to decode
+	// must not turn into a full payment
+	if r.ContentLength != 0 {
+		if err := decodeJSON(w, r, &req); err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
 	}
-
-	// Update invoice status
-	now := time.Now()
-	invoice.Status = models.InvoicePaid
-	invoice.PaidAmount = invoice.Total
-	invoice.PaidAt = &now
-
-	if err := h.DB.UpdateInvoice(invoice); err != nil {
-		respondError(w, http.StatusInternalServerError, "Failed to update invoice")
+	if req.Amount < 0 {
+		respondError(w, http.StatusBadRequest, "amount must not be negative")
 		return
 	}
 
-	// Create payment record
+	// Update invoice status and record the payment in one transaction
+	now := h.Config.Now()
 	payment := &models.Payment{
-		CustomerID:    invoice.CustomerID,
-		InvoiceID:     &invoice.ID,
-		Amount:        invoice.Total,
+		Amount:        req.Amount,
 		PaymentMethod: req.Method,
 		Status:        "completed",
 		PaymentDate:   now,
 	}
-	h.DB.CreatePayment(payment)
-
-	// Send Email Receipt
-	customer, _ := h.DB.GetCustomer(invoice.CustomerID)
-	if customer != nil {
-		if customer.Email != "" && h.Mailer != nil {
-			html := mailer.GeneratePaymentReceiptHTML(
-				customer.Name,
-				invoice.InvoiceNo,
-				fmt.Sprintf("Rp %.2f", invoice.Total),
-				now.Format("02/01/2006 15:04"),
-			)
-			go h.Mailer.Send(customer.Email, "Payment Receipt - GO-ACS", html)
-		}
-
-		// Send WA Receipt
-		if customer.Phone != "" && h.WA != nil {
-			msg := whatsapp.GeneratePaymentReceiptMessage(
-				customer.Name,
-				invoice.InvoiceNo,
-				now.Format("02/01/2006 15:04"),
-				fmt.Sprintf("Rp %.2f", invoice.Total),
-			)
-			go h.WA.Send(customer.Phone, msg)
+	invoice, err := h.DB.AddInvoicePayment(id, payment)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			respondError(w, http.StatusNotFound, "Invoice not found")
+		case errors.Is(err, database.ErrInvoiceAlreadyPaid), errors.Is(err, database.ErrPaymentExceedsBalance):
+			respondError(w, http.StatusBadRequest, err.Error())
+		default:
+			fmt.Printf("[BILLING] Failed to record payment on invoice %d: %v\n", id, err)
+			respondError(w, http.StatusInternalServerError, "Failed to update invoice")
 		}
+		return
+	}
 
-		// Send FCM Receipt
-		if customer.FCMToken != "" && h.FCM != nil {
-			title := "Payment Receipt - GO-ACS"
-			body := fmt.Sprintf("Dear %s, payment for invoice %s has been received. Amount: Rp %.2f.",
-				customer.Name, invoice.InvoiceNo, invoice.Total)
-			go h.FCM.Send(customer.FCMToken, title, body)
-		}
+	message := "Invoice marked as paid"
+	if invoice.Status == models.InvoicePaid {
+		h.sendPaymentReceipt(invoice, now)
+	} else {
+		message = fmt.Sprintf("Partial payment of %s recorded, %s outstanding",
+			h.formatMoney(payment.Amount), h.formatMoney(invoice.Total-invoice.PaidAmount))
 	}
 
 	respondJSON(w, http.StatusOK, map[string]interface{}{
-		"success": true,
-		"message": "Invoice marked as paid",
+		"success":    true,
+		"message":    message,
+		"paymentId":  payment.ID,
+		"invoiceNo":  invoice.InvoiceNo,
+		"subtotal":   invoice.Subtotal,
+		"tax":        invoice.Tax,
+		"discount":   invoice.Discount,
+		"total":      invoice.Total,
+		"paidAmount": invoice.PaidAmount,
+		"status":     invoice.Status,
 	})
 }
 
@@ -2650,11 +8051,14 @@ func (h *Handler) BatchIsolirOverdue(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		DaysOverdue int `json:"daysOverdue"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		req.DaysOverdue = 30 // Default 30 days
+	if r.ContentLength != 0 {
+		if err := decodeJSON(w, r, &req); err != nil {
+			respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
 	}
 	if req.DaysOverdue < 1 {
-		req.DaysOverdue = 30
+		req.DaysOverdue = 30 // Default 30 days
 	}
 
 	// Get customers with overdue invoices
@@ -2663,7 +8067,7 @@ func (h *Handler) BatchIsolirOverdue(w http.ResponseWriter, r *http.Request) {
 	suspended := 0
 	for _, customer := range customers {
 		// Check if customer has overdue invoices
-		invoices, _, _ := h.DB.GetInvoices(&customer.ID, "pending", 100, 0)
+		invoices, _ := h.DB.GetUnpaidInvoices(customer.ID)
 
 		hasOverdue := false
 		for _, inv := range invoices {
@@ -2677,10 +8081,8 @@ func (h *Handler) BatchIsolirOverdue(w http.ResponseWriter, r *http.Request) {
 			customer.Status = "suspended"
 			if err := h.DB.UpdateCustomer(customer); err == nil {
 				suspended++
-				// Send WA Notification
-				if customer.Phone != "" && h.WA != nil {
-					go h.WA.Send(customer.Phone, whatsapp.GenerateSuspensionMessage(customer.Name))
-				}
+				// Send WA Notification (skipped if this suspension was already notified)
+				h.notifySuspension(customer, fmt.Sprintf("invoice overdue > %d days", req.DaysOverdue))
 			}
 		}
 	}
@@ -2730,11 +8132,31 @@ func (h *Handler) GetPayments(w http.ResponseWriter, r *http.Request) {
 
 // CreatePayment creates a new payment
 func (h *Handler) CreatePayment(w http.ResponseWriter, r *http.Request) {
-	var payment models.Payment
-	if err := json.NewDecoder(r.Body).Decode(&payment); err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid request body")
+	var req struct {
+		CustomerID    int64   `json:"customerId"`
+		InvoiceID     *int64  `json:"invoiceId"`
+		Amount        float64 `json:"amount"`
+		PaymentMethod string  `json:"paymentMethod"`
+		Method        string  `json:"method"` // as sent by the billing page
+		Reference     string  `json:"reference"`
+		Status        string  `json:"status"`
+		Notes         string  `json:"notes"`
+		ReceivedBy    string  `json:"receivedBy"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	payment := models.Payment{
+		CustomerID:    req.CustomerID,
+		InvoiceID:     req.InvoiceID,
+		Amount:        req.Amount,
+		PaymentMethod: firstNonEmpty(req.PaymentMethod, req.Method),
+		Reference:     req.Reference,
+		Status:        req.Status,
+		Notes:         req.Notes,
+		ReceivedBy:    req.ReceivedBy,
+	}
 	if payment.Status == "" {
 		payment.Status = "completed"
 	}
@@ -2748,7 +8170,7 @@ func (h *Handler) CreatePayment(w http.ResponseWriter, r *http.Request) {
 
 // GetBillingStats returns billing statistics
 func (h *Handler) GetBillingStats(w http.ResponseWriter, r *http.Request) {
-	stats, err := h.DB.GetBillingStats()
+	stats, err := h.DB.GetBillingStats(h.Config.Now())
 	if err != nil {
 		respondError(w, http.StatusInternalServerError, "Failed to get billing stats")
 		return
@@ -2765,8 +8187,8 @@ func (h *Handler) CustomerLogin(w http.ResponseWriter, r *http.Request) {
 		Password string `json:"password"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid request")
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
@@ -2778,6 +8200,9 @@ func (h *Handler) CustomerLogin(w http.ResponseWriter, r *http.Request) {
 			if id, parseErr := strconv.ParseInt(req.Username, 10, 64); parseErr == nil {
 				customer, err = h.DB.GetCustomer(id)
 			}
+			if err != nil && h.Config != nil && h.Config.PortalPhoneLogin {
+				customer, err = h.findCustomerByPhone(req.Username, req.Password)
+			}
 			if err != nil {
 				respondError(w, http.StatusUnauthorized, "Invalid credentials")
 				return
@@ -2785,31 +8210,375 @@ func (h *Handler) CustomerLogin(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Verify password using bcrypt
-	if err := bcrypt.CompareHashAndPassword([]byte(customer.Password), []byte(req.Password)); err != nil {
-		respondError(w, http.StatusUnauthorized, "Invalid credentials")
+	// Verify password using bcrypt
+	if !h.checkCustomerPassword(customer, req.Password) {
+		respondError(w, http.StatusUnauthorized, "Invalid credentials")
+		return
+	}
+
+	// Check if customer is active
+	if customer.Status == "suspended" || customer.Status == "terminated" {
+		respondError(w, http.StatusForbidden, "Account is suspended. Please contact support.")
+		return
+	}
+
+	token, err := generateCustomerJWT(customer, h.Config.JWTSecret)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to generate token")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"token":   token,
+		"customer": map[string]interface{}{
+			"id":           customer.ID,
+			"customerCode": customer.CustomerCode,
+			"name":         customer.Name,
+			"email":        customer.Email,
+			"status":       customer.Status,
+		},
+	})
+}
+
+// checkCustomerPassword verifies a portal password against the stored bcrypt
+// hash. Legacy plaintext passwords (rows written outside CreateCustomer after
+// the startup migration) are compared directly and rehashed on success.
+func (h *Handler) checkCustomerPassword(customer *models.Customer, password string) bool {
+	if customer.Password == "" || password == "" {
+		return false
+	}
+	if strings.HasPrefix(customer.Password, "$2") {
+		return bcrypt.CompareHashAndPassword([]byte(customer.Password), []byte(password)) == nil
+	}
+
+	if subtle.ConstantTimeCompare([]byte(customer.Password), []byte(password)) != 1 {
+		return false
+	}
+	if hashed, err := h.DB.HashPassword(password); err == nil {
+		if err := h.DB.SetCustomerPassword(customer.ID, hashed); err == nil {
+			customer.Password = hashed
+			fmt.Printf("✓ Rehashed legacy portal password for customer %d\n", customer.ID)
+		}
+	}
+	return true
+}
+
+// findCustomerByPhone looks up a customer by normalized phone number. Several
+// customers may share a phone, so the one whose password matches is returned.
+func (h *Handler) findCustomerByPhone(phone, password string) (*models.Customer, error) {
+	normalized := normalizePhone(phone, h.Config.PhoneCountryCode)
+	if len(normalized) < 8 {
+		return nil, fmt.Errorf("not a phone number")
+	}
+
+	candidates, err := h.DB.GetCustomersByPhoneSuffix(normalized[len(normalized)-8:])
+	if err != nil {
+		return nil, err
+	}
+
+	var match *models.Customer
+	for _, c := range candidates {
+		if normalizePhone(c.Phone, h.Config.PhoneCountryCode) != normalized {
+			continue
+		}
+		if match == nil {
+			match = c
+		}
+		if h.checkCustomerPassword(c, password) {
+			return c, nil
+		}
+	}
+	if match == nil {
+		return nil, fmt.Errorf("customer not found")
+	}
+	// Let the caller reject the password
+	return match, nil
+}
+
+// normalizePhone reduces a phone number to digits in international form:
+// "+62 812-3456", "0812 3456" and "812 3456" all become "628123456" for
+// country code 62. Input that is not a phone number yields "".
+func normalizePhone(phone, countryCode string) string {
+	var b strings.Builder
+	for i, r := range strings.TrimSpace(phone) {
+		switch {
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case r == '+' && i == 0, r == ' ', r == '-', r == '.', r == '(', r == ')':
+		default:
+			return ""
+		}
+	}
+	digits := b.String()
+	if digits == "" {
+		return ""
+	}
+
+	switch {
+	case strings.HasPrefix(digits, "00"):
+		return digits[2:]
+	case strings.HasPrefix(digits, "0"):
+		return countryCode + digits[1:]
+	case countryCode != "" && strings.HasPrefix(digits, countryCode):
+		return digits
+	default:
+		return countryCode + digits
+	}
+}
+
+// ResetPortalPassword generates a new portal password for a customer, stores
+// its hash and sends it over WhatsApp and/or email. The password is never
+// returned; customers without a reachable phone or email are refused.
+func (h *Handler) ResetPortalPassword(w http.ResponseWriter, r *http.Request) {
+	id := getPathInt64(r, "id")
+
+	customer, err := h.DB.GetCustomer(id)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Customer not found")
+		return
+	}
+	if !(customer.Phone != "" && h.WA != nil) && !(customer.Email != "" && h.Mailer != nil) {
+		respondError(w, http.StatusBadRequest, "Customer has no phone or email to send the password to; set a password on the customer instead")
+		return
+	}
+
+	password := generateRandomPassword()
+	hashed, err := hashPassword(password)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to hash password")
+		return
+	}
+	if err := h.DB.SetCustomerPassword(customer.ID, hashed); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to save password")
+		return
+	}
+
+	channels := h.sendPortalPassword(customer, password, "")
+	h.DB.CreateLog(nil, "info", "customer",
+		fmt.Sprintf("Portal password reset for customer %s", customer.CustomerCode), strings.Join(channels, ","))
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"sentVia": channels,
+		"message": "Portal password has been reset",
+	})
+}
+
+// sendPortalPassword delivers a new portal password. channel limits delivery
+// to "whatsapp" or "email"; empty uses every channel the customer has.
+// It returns the channels the message was dispatched to.
+func (h *Handler) sendPortalPassword(customer *models.Customer, password, channel string) []string {
+	login := customer.Username
+	if login == "" {
+		login = customer.CustomerCode
+	}
+	message := fmt.Sprintf("Dear %s, your customer portal password has been reset.\nLogin: %s\nPassword: %s\nPlease change it after logging in.",
+		customer.Name, login, password)
+
+	channels := []string{}
+	if (channel == "" || channel == "whatsapp") && customer.Phone != "" && h.WA != nil {
+		h.sendCredential(channelWhatsApp, customer.Phone, "", message)
+		channels = append(channels, "whatsapp")
+	}
+	if (channel == "" || channel == "email") && customer.Email != "" && h.Mailer != nil {
+		h.sendCredential(channelEmail, customer.Email, "Portal Password Reset - GO-ACS", strings.ReplaceAll(message, "\n", "<br>"))
+		channels = append(channels, "email")
+	}
+	return channels
+}
+
+// resetLocked reports whether a customer used up their wrong-code allowance
+// for the current window. The caller holds resetMu.
+func (h *Handler) resetLocked(customerID int64) bool {
+	fails := h.resetFails[customerID]
+	if fails == nil {
+		return false
+	}
+	if time.Since(fails.WindowStart) > resetFailWindow {
+		delete(h.resetFails, customerID)
+		return false
+	}
+	return fails.Count >= resetMaxFailures
+}
+
+// recordResetFailure counts a wrong code. The caller holds resetMu.
+func (h *Handler) recordResetFailure(customerID int64) {
+	fails := h.resetFails[customerID]
+	if fails == nil || time.Since(fails.WindowStart) > resetFailWindow {
+		fails = &portalResetFails{WindowStart: time.Now()}
+		h.resetFails[customerID] = fails
+	}
+	fails.Count++
+}
+
+// findCustomerForReset resolves a forgot-password identifier (email or phone)
+// and returns the customer with the channel the identifier belongs to
+func (h *Handler) findCustomerForReset(identifier string) (*models.Customer, string) {
+	identifier = strings.TrimSpace(identifier)
+	if strings.Contains(identifier, "@") {
+		if c, err := h.DB.GetCustomerByEmail(identifier); err == nil && c.Email != "" {
+			return c, "email"
+		}
+		return nil, ""
+	}
+
+	countryCode := ""
+	if h.Config != nil {
+		countryCode = h.Config.PhoneCountryCode
+	}
+	normalized := normalizePhone(identifier, countryCode)
+	if len(normalized) < 8 {
+		return nil, ""
+	}
+	candidates, err := h.DB.GetCustomersByPhoneSuffix(normalized[len(normalized)-8:])
+	if err != nil {
+		return nil, ""
+	}
+	for _, c := range candidates {
+		if normalizePhone(c.Phone, countryCode) == normalized {
+			customer, err := h.DB.GetCustomer(c.ID)
+			if err != nil {
+				return nil, ""
+			}
+			return customer, "whatsapp"
+		}
+	}
+	return nil, ""
+}
+
+// ForgotPortalPassword sends a one-time verification code to the phone or
+// email the customer registered. The response never reveals whether the
+// account exists.
+func (h *Handler) ForgotPortalPassword(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Identifier string `json:"identifier"` // phone number or email
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.Identifier == "" {
+		respondError(w, http.StatusBadRequest, "Phone number or email is required")
+		return
+	}
+
+	generic := map[string]interface{}{
+		"success": true,
+		"message": "If the account exists, a verification code has been sent",
+	}
+
+	customer, channel := h.findCustomerForReset(req.Identifier)
+	if customer == nil || customer.Status == "terminated" {
+		respondJSON(w, http.StatusOK, generic)
+		return
+	}
+
+	h.resetMu.Lock()
+	existing := h.resetCodes[customer.ID]
+	if h.resetLocked(customer.ID) || (existing != nil && time.Since(existing.SentAt) < resetCodeCooldown) {
+		h.resetMu.Unlock()
+		respondJSON(w, http.StatusOK, generic)
+		return
+	}
+	n, _ := rand.Int(rand.Reader, big.NewInt(1000000))
+	code := fmt.Sprintf("%06d", n.Int64())
+	h.resetCodes[customer.ID] = &portalResetCode{
+		Code:      code,
+		Channel:   channel,
+		ExpiresAt: time.Now().Add(resetCodeTTL),
+		SentAt:    time.Now(),
+	}
+	h.resetMu.Unlock()
+
+	message := fmt.Sprintf("Your GO-ACS portal verification code is %s. It expires in %d minutes. Ignore this message if you did not request a password reset.",
+		code, int(resetCodeTTL.Minutes()))
+	if channel == "whatsapp" && h.WA != nil {
+		h.sendCredential(channelWhatsApp, customer.Phone, "", message)
+	} else if channel == "email" && h.Mailer != nil {
+		h.sendCredential(channelEmail, customer.Email, "Portal Verification Code - GO-ACS", message)
+	}
+	h.DB.CreateLog(nil, "info", "customer",
+		fmt.Sprintf("Portal password reset code sent to customer %s", customer.CustomerCode), channel)
+
+	respondJSON(w, http.StatusOK, generic)
+}
+
+// ResetPortalPasswordWithCode verifies a forgot-password code, then sets a new
+// password (newPassword, or a generated one sent over the same channel)
+func (h *Handler) ResetPortalPasswordWithCode(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Identifier  string `json:"identifier"`
+		Code        string `json:"code"`
+		NewPassword string `json:"newPassword"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.NewPassword != "" && len(req.NewPassword) < 6 {
+		respondError(w, http.StatusBadRequest, "Password must be at least 6 characters")
+		return
+	}
+
+	customer, _ := h.findCustomerForReset(req.Identifier)
+	if customer == nil {
+		respondError(w, http.StatusBadRequest, "Invalid or expired verification code")
+		return
+	}
+
+	h.resetMu.Lock()
+	if h.resetLocked(customer.ID) {
+		h.resetMu.Unlock()
+		respondError(w, http.StatusTooManyRequests, "Too many failed attempts, try again later")
+		return
+	}
+	pending := h.resetCodes[customer.ID]
+	valid := false
+	if pending != nil && time.Now().Before(pending.ExpiresAt) && pending.Attempts < resetCodeMaxAttempts {
+		pending.Attempts++
+		valid = subtle.ConstantTimeCompare([]byte(pending.Code), []byte(strings.TrimSpace(req.Code))) == 1
+	}
+	if valid {
+		delete(h.resetFails, customer.ID)
+	} else {
+		h.recordResetFailure(customer.ID)
+	}
+	if valid || (pending != nil && (pending.Attempts >= resetCodeMaxAttempts || time.Now().After(pending.ExpiresAt))) {
+		delete(h.resetCodes, customer.ID)
+	}
+	h.resetMu.Unlock()
+
+	if !valid {
+		respondError(w, http.StatusBadRequest, "Invalid or expired verification code")
 		return
 	}
 
-	// Check if customer is active
-	if customer.Status == "suspended" || customer.Status == "terminated" {
-		respondError(w, http.StatusForbidden, "Account is suspended. Please contact support.")
+	password := req.NewPassword
+	if password == "" {
+		password = generateRandomPassword()
+	}
+	hashed, err := hashPassword(password)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to hash password")
+		return
+	}
+	if err := h.DB.SetCustomerPassword(customer.ID, hashed); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to save password")
 		return
 	}
 
-	// Generate token (in production, use JWT)
-	token := fmt.Sprintf("customer-%d-%d", customer.ID, time.Now().Unix())
+	message := "Password has been changed, you can now log in"
+	if req.NewPassword == "" {
+		h.sendPortalPassword(customer, password, pending.Channel)
+		message = "A new password has been sent to you"
+	}
+	h.DB.CreateLog(nil, "info", "customer",
+		fmt.Sprintf("Customer %s reset portal password via verification code", customer.CustomerCode), "")
 
 	respondJSON(w, http.StatusOK, map[string]interface{}{
 		"success": true,
-		"token":   token,
-		"customer": map[string]interface{}{
-			"id":           customer.ID,
-			"customerCode": customer.CustomerCode,
-			"name":         customer.Name,
-			"email":        customer.Email,
-			"status":       customer.Status,
-		},
+		"message": message,
 	})
 }
 
@@ -2820,9 +8589,9 @@ func (h *Handler) CustomerLogout(w http.ResponseWriter, r *http.Request) {
 
 // GetPortalDashboard returns customer portal dashboard data
 func (h *Handler) GetPortalDashboard(w http.ResponseWriter, r *http.Request) {
-	customerID := getQueryInt64(r, "customerId")
+	customerID := middleware.GetCustomerFromContext(r.Context())
 	if customerID == 0 {
-		respondError(w, http.StatusBadRequest, "Customer ID required")
+		respondError(w, http.StatusUnauthorized, "Not logged in")
 		return
 	}
 
@@ -2845,18 +8614,19 @@ func (h *Handler) GetPortalDashboard(w http.ResponseWriter, r *http.Request) {
 	invoices, _, _ := h.DB.GetInvoices(&customerID, "", 5, 0)
 
 	respondJSON(w, http.StatusOK, map[string]interface{}{
-		"customer": customer,
-		"package":  pkg,
-		"devices":  devices,
-		"invoices": invoices,
+		"customer":      customer,
+		"package":       pkg,
+		"devices":       devices,
+		"invoices":      invoices,
+		"announcements": h.activeAnnouncements(customer),
 	})
 }
 
 // GetPortalInvoices returns customer's invoices
 func (h *Handler) GetPortalInvoices(w http.ResponseWriter, r *http.Request) {
-	customerID := getQueryInt64(r, "customerId")
+	customerID := middleware.GetCustomerFromContext(r.Context())
 	if customerID == 0 {
-		respondError(w, http.StatusBadRequest, "Customer ID required")
+		respondError(w, http.StatusUnauthorized, "Not logged in")
 		return
 	}
 
@@ -2870,22 +8640,41 @@ func (h *Handler) GetPortalInvoices(w http.ResponseWriter, r *http.Request) {
 	}
 
 	respondJSON(w, http.StatusOK, map[string]interface{}{
-		"invoices": invoices,
-		"total":    total,
+		"invoices":               invoices,
+		"total":                  total,
+		"onlinePaymentMinAmount": h.onlinePaymentMinAmount(),
 	})
 }
 
 // CreatePortalTicket allows customers to submit support tickets from the portal
 func (h *Handler) CreatePortalTicket(w http.ResponseWriter, r *http.Request) {
-	var ticket models.SupportTicket
-	if err := json.NewDecoder(r.Body).Decode(&ticket); err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid request")
+	customerID := middleware.GetCustomerFromContext(r.Context())
+	if customerID == 0 {
+		respondError(w, http.StatusUnauthorized, "Not logged in")
+		return
+	}
+
+	var req struct {
+		Subject     string `json:"subject"`
+		Description string `json:"description"`
+		Category    string `json:"category"`
+		Priority    string `json:"priority"`
+		Status      string `json:"status"` // ignored, portal tickets always start open
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	ticket := models.SupportTicket{
+		CustomerID:  customerID,
+		Subject:     req.Subject,
+		Description: req.Description,
+		Category:    req.Category,
+		Priority:    req.Priority,
+	}
 
-	// Ensure customer ID is set
-	if ticket.CustomerID == 0 {
-		respondError(w, http.StatusBadRequest, "Customer ID required")
+	if _, err := h.DB.GetCustomer(ticket.CustomerID); err != nil {
+		respondError(w, http.StatusNotFound, "Customer not found")
 		return
 	}
 
@@ -2900,6 +8689,7 @@ func (h *Handler) CreatePortalTicket(w http.ResponseWriter, r *http.Request) {
 		respondError(w, http.StatusInternalServerError, "Failed to create ticket")
 		return
 	}
+	h.autoAssignTicket(created)
 
 	respondJSON(w, http.StatusCreated, map[string]interface{}{
 		"success": true,
@@ -2908,6 +8698,78 @@ func (h *Handler) CreatePortalTicket(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// GetPortalTickets returns the support tickets of the logged-in customer
+func (h *Handler) GetPortalTickets(w http.ResponseWriter, r *http.Request) {
+	customerID := middleware.GetCustomerFromContext(r.Context())
+	if customerID == 0 {
+		respondError(w, http.StatusUnauthorized, "Not logged in")
+		return
+	}
+
+	limit := getQueryInt(r, "limit", 20)
+	offset := getQueryInt(r, "offset", 0)
+
+	tickets, total, err := h.DB.GetSupportTickets(&customerID, "", limit, offset)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get tickets")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"tickets": tickets,
+		"total":   total,
+	})
+}
+
+// RatePortalTicket lets the logged-in customer rate how their resolved or
+// closed ticket was handled (1-5, with an optional comment)
+func (h *Handler) RatePortalTicket(w http.ResponseWriter, r *http.Request) {
+	customerID := middleware.GetCustomerFromContext(r.Context())
+	if customerID == 0 {
+		respondError(w, http.StatusUnauthorized, "Not logged in")
+		return
+	}
+	id := getPathInt64(r, "id")
+
+	var req struct {
+		Rating  int    `json:"rating"`
+		Comment string `json:"comment"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.Rating < 1 || req.Rating > 5 {
+		respondError(w, http.StatusBadRequest, "rating must be between 1 and 5")
+		return
+	}
+
+	ticket, err := h.DB.GetSupportTicket(id)
+	if err != nil || ticket.CustomerID != customerID {
+		respondError(w, http.StatusNotFound, "Ticket not found")
+		return
+	}
+
+	if err := h.DB.RateSupportTicket(id, req.Rating, strings.TrimSpace(req.Comment)); err != nil {
+		switch {
+		case errors.Is(err, database.ErrTicketNotClosed):
+			respondError(w, http.StatusConflict, "Only resolved or closed tickets can be rated")
+		case errors.Is(err, database.ErrTicketAlreadyRated):
+			respondError(w, http.StatusConflict, "This ticket has already been rated")
+		default:
+			respondError(w, http.StatusInternalServerError, "Failed to save rating")
+		}
+		return
+	}
+
+	h.DB.CreateLog(nil, "info", "ticket", fmt.Sprintf("Ticket %s rated %d/5 by customer", ticket.TicketNo, req.Rating), req.Comment)
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": "Thank you for your feedback",
+	})
+}
+
 // GetCustomerDashboard is an alias for GetPortalDashboard
 func (h *Handler) GetCustomerDashboard(w http.ResponseWriter, r *http.Request) {
 	h.GetPortalDashboard(w, r)
@@ -2920,9 +8782,9 @@ func (h *Handler) GetCustomerInvoices(w http.ResponseWriter, r *http.Request) {
 
 // GetCustomerWiFi returns WiFi settings for customer's device
 func (h *Handler) GetCustomerWiFi(w http.ResponseWriter, r *http.Request) {
-	customerID := getQueryInt64(r, "customerId")
+	customerID := middleware.GetCustomerFromContext(r.Context())
 	if customerID == 0 {
-		respondError(w, http.StatusBadRequest, "Customer ID required")
+		respondError(w, http.StatusUnauthorized, "Not logged in")
 		return
 	}
 
@@ -3018,9 +8880,9 @@ func (h *Handler) GetCustomerWiFi(w http.ResponseWriter, r *http.Request) {
 
 // UpdateCustomerWiFi updates WiFi settings for customer's device
 func (h *Handler) UpdateCustomerWiFi(w http.ResponseWriter, r *http.Request) {
-	customerID := getQueryInt64(r, "customerId")
+	customerID := middleware.GetCustomerFromContext(r.Context())
 	if customerID == 0 {
-		respondError(w, http.StatusBadRequest, "Customer ID required")
+		respondError(w, http.StatusUnauthorized, "Not logged in")
 		return
 	}
 
@@ -3029,8 +8891,8 @@ func (h *Handler) UpdateCustomerWiFi(w http.ResponseWriter, r *http.Request) {
 		Password string `json:"password"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid request body")
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
@@ -3057,8 +8919,8 @@ func (h *Handler) UpdateDeviceLocation(w http.ResponseWriter, r *http.Request) {
 		Address   string  `json:"address"`
 	}
 	id := getPathInt64(r, "id")
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid request")
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 	if err := h.DB.UpdateDeviceLocation(id, req.Latitude, req.Longitude, req.Address); err != nil {
@@ -3070,19 +8932,239 @@ func (h *Handler) UpdateDeviceLocation(w http.ResponseWriter, r *http.Request) {
 
 // ==== Support Ticket Handlers ==== //
 func (h *Handler) CreateSupportTicket(w http.ResponseWriter, r *http.Request) {
-	var ticket models.SupportTicket
-	if err := json.NewDecoder(r.Body).Decode(&ticket); err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid request")
-		return
+	var req struct {
+		CustomerID  int64  `json:"customerId"`
+		Subject     string `json:"subject"`
+		Description string `json:"description"`
+		Category    string `json:"category"`
+		Priority    string `json:"priority"`
+		Status      string `json:"status"`
+		AssignedTo  *int64 `json:"assignedTo"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	ticket := models.SupportTicket{
+		CustomerID:  req.CustomerID,
+		Subject:     req.Subject,
+		Description: req.Description,
+		Category:    req.Category,
+		Priority:    req.Priority,
+		Status:      req.Status,
+		AssignedTo:  req.AssignedTo,
 	}
 	created, err := h.DB.CreateSupportTicket(&ticket)
 	if err != nil {
 		respondError(w, http.StatusInternalServerError, "Failed to create ticket")
 		return
 	}
+	h.autoAssignTicket(created)
+	respondJSON(w, http.StatusCreated, created)
+}
+
+// autoAssignTicket assigns a new unassigned ticket per TICKET_AUTO_ASSIGN.
+// Failures are logged; the ticket simply stays unassigned.
+func (h *Handler) autoAssignTicket(ticket *models.SupportTicket) {
+	mode := h.Config.TicketAutoAssign
+	if ticket.AssignedTo != nil || (mode != models.TicketAssignRoundRobin && mode != models.TicketAssignArea) {
+		return
+	}
+
+	address := ""
+	if customer, err := h.DB.GetCustomer(ticket.CustomerID); err == nil && customer != nil {
+		address = customer.Address
+	}
+	tech, err := h.DB.AutoAssignTicket(ticket.ID, mode, address, ticket.Category)
+	if err != nil {
+		h.DB.CreateLog(nil, "error", "ticket", fmt.Sprintf("Ticket %s auto-assignment failed", ticket.TicketNo), err.Error())
+		return
+	}
+	if tech == nil {
+		return
+	}
+	ticket.AssignedTo = &tech.UserID
+	h.DB.CreateLog(nil, "info", "ticket", fmt.Sprintf("Ticket %s auto-assigned to %s", ticket.TicketNo, tech.Username), "")
+}
+
+// ==== Technician Handlers ==== //
+
+// GetTechnicians lists technicians eligible for ticket auto-assignment
+func (h *Handler) GetTechnicians(w http.ResponseWriter, r *http.Request) {
+	techs, err := h.DB.GetTechnicians()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get technicians")
+		return
+	}
+	respondJSON(w, http.StatusOK, techs)
+}
+
+// normalizeTechnician trims areas and skills and drops empty entries
+func normalizeTechnician(t *models.Technician) {
+	clean := func(in []string) []string {
+		out := []string{}
+		for _, s := range in {
+			if s = strings.TrimSpace(s); s != "" {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	t.Areas = clean(t.Areas)
+	t.Skills = clean(t.Skills)
+}
+
+// CreateTechnician registers an existing user as a technician
+func (h *Handler) CreateTechnician(w http.ResponseWriter, r *http.Request) {
+	tech := models.Technician{Available: true}
+	if err := decodeJSON(w, r, &tech); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if _, err := h.DB.GetUserByID(tech.UserID); err != nil {
+		respondError(w, http.StatusBadRequest, "User not found")
+		return
+	}
+	normalizeTechnician(&tech)
+
+	created, err := h.DB.CreateTechnician(&tech)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to create technician")
+		return
+	}
+	respondJSON(w, http.StatusCreated, created)
+}
+
+// UpdateTechnician updates a technician's areas, skills and availability
+func (h *Handler) UpdateTechnician(w http.ResponseWriter, r *http.Request) {
+	id := getPathInt64(r, "id")
+	tech, err := h.DB.GetTechnician(id)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Technician not found")
+		return
+	}
+	if err := decodeJSON(w, r, tech); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	tech.ID = id
+	normalizeTechnician(tech)
+
+	if err := h.DB.UpdateTechnician(tech); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to update technician")
+		return
+	}
+	respondJSON(w, http.StatusOK, tech)
+}
+
+// DeleteTechnician removes a technician from auto-assignment
+func (h *Handler) DeleteTechnician(w http.ResponseWriter, r *http.Request) {
+	id := getPathInt64(r, "id")
+	if err := h.DB.DeleteTechnician(id); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to delete technician")
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]bool{"success": true})
+}
+
+// GetAnnouncements lists all portal announcements, including scheduled and expired ones
+func (h *Handler) GetAnnouncements(w http.ResponseWriter, r *http.Request) {
+	announcements, err := h.DB.GetAnnouncements()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get announcements")
+		return
+	}
+	respondJSON(w, http.StatusOK, announcements)
+}
+
+// validateAnnouncement trims the announcement and checks its time window.
+// A missing start means it shows immediately.
+func validateAnnouncement(a *models.Announcement) error {
+	a.Title = strings.TrimSpace(a.Title)
+	a.Area = strings.TrimSpace(a.Area)
+	if a.Title == "" {
+		return fmt.Errorf("Title is required")
+	}
+	if a.StartsAt.IsZero() {
+		a.StartsAt = time.Now()
+	}
+	if a.EndsAt != nil && !a.EndsAt.After(a.StartsAt) {
+		return fmt.Errorf("endsAt must be after startsAt")
+	}
+	return nil
+}
+
+// CreateAnnouncement creates a portal-wide or per-area announcement
+func (h *Handler) CreateAnnouncement(w http.ResponseWriter, r *http.Request) {
+	var a models.Announcement
+	if err := decodeJSON(w, r, &a); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := validateAnnouncement(&a); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	created, err := h.DB.CreateAnnouncement(&a)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to create announcement")
+		return
+	}
 	respondJSON(w, http.StatusCreated, created)
 }
 
+// UpdateAnnouncement updates an announcement's text, area or time window
+func (h *Handler) UpdateAnnouncement(w http.ResponseWriter, r *http.Request) {
+	id := getPathInt64(r, "id")
+	a, err := h.DB.GetAnnouncement(id)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Announcement not found")
+		return
+	}
+	if err := decodeJSON(w, r, a); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	a.ID = id
+	if err := validateAnnouncement(a); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := h.DB.UpdateAnnouncement(a); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to update announcement")
+		return
+	}
+	respondJSON(w, http.StatusOK, a)
+}
+
+// DeleteAnnouncement removes an announcement
+func (h *Handler) DeleteAnnouncement(w http.ResponseWriter, r *http.Request) {
+	id := getPathInt64(r, "id")
+	if err := h.DB.DeleteAnnouncement(id); err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to delete announcement")
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]bool{"success": true})
+}
+
+// activeAnnouncements returns the announcements currently showing for a customer
+func (h *Handler) activeAnnouncements(customer *models.Customer) []*models.Announcement {
+	active := []*models.Announcement{}
+	all, err := h.DB.GetAnnouncements()
+	if err != nil {
+		return active
+	}
+	now := time.Now()
+	for _, a := range all {
+		if a.ActiveFor(customer.Address, now) {
+			active = append(active, a)
+		}
+	}
+	return active
+}
+
 func (h *Handler) GetSupportTickets(w http.ResponseWriter, r *http.Request) {
 	customerID := getQueryInt64(r, "customerId")
 	status := r.URL.Query().Get("status")
@@ -3096,6 +9178,54 @@ func (h *Handler) GetSupportTickets(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, http.StatusOK, map[string]interface{}{"tickets": tickets, "total": total})
 }
 
+// AutoCloseResolvedTickets closes tickets that stayed resolved for
+// TicketAutoCloseDays without activity. Customers are told a day ahead, and a
+// ticket is only closed once that warning is at least a day old.
+func (h *Handler) AutoCloseResolvedTickets() {
+	days := h.Config.TicketAutoCloseDays
+	if days <= 0 {
+		return
+	}
+
+	due, err := h.DB.GetTicketsDueForCloseWarning(days)
+	if err != nil {
+		fmt.Printf("[TICKETS] Error fetching resolved tickets: %v\n", err)
+		return
+	}
+	for _, t := range due {
+		if err := h.DB.MarkTicketCloseWarned(t.ID); err != nil {
+			continue
+		}
+		customer, err := h.DB.GetCustomer(t.CustomerID)
+		if err == nil && customer.Phone != "" && h.WA != nil {
+			h.queueNotification(channelWhatsApp, customer.Phone, "", whatsapp.GenerateTicketAutoCloseMessage(customer.Name, t.TicketNo))
+		}
+	}
+
+	closed, err := h.DB.AutoCloseResolvedTickets(days)
+	if err != nil {
+		fmt.Printf("[TICKETS] Error auto-closing tickets: %v\n", err)
+		return
+	}
+	for _, t := range closed {
+		h.DB.CreateLog(nil, "info", "ticket",
+			fmt.Sprintf("Ticket %s closed automatically after %d days resolved", t.TicketNo, days), "")
+	}
+	if len(due) > 0 || len(closed) > 0 {
+		fmt.Printf("[TICKETS] Auto-close: %d customers warned, %d tickets closed\n", len(due), len(closed))
+	}
+}
+
+// GetTicketStats returns ticket counts per status and average customer satisfaction
+func (h *Handler) GetTicketStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := h.DB.GetTicketStats()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get ticket stats")
+		return
+	}
+	respondJSON(w, http.StatusOK, stats)
+}
+
 func (h *Handler) GetSupportTicket(w http.ResponseWriter, r *http.Request) {
 	id := getPathInt64(r, "ticketId")
 	ticket, err := h.DB.GetSupportTicket(id)
@@ -3108,13 +9238,42 @@ func (h *Handler) GetSupportTicket(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) UpdateSupportTicket(w http.ResponseWriter, r *http.Request) {
 	id := getPathInt64(r, "ticketId")
-	var ticket models.SupportTicket
-	if err := json.NewDecoder(r.Body).Decode(&ticket); err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid request")
+	// Only the fields present in the request are changed (the tickets page
+	// sends just the status)
+	var req struct {
+		Subject     *string `json:"subject"`
+		Description *string `json:"description"`
+		Category    *string `json:"category"`
+		Priority    *string `json:"priority"`
+		Status      *string `json:"status"`
+		AssignedTo  *int64  `json:"assignedTo"`
+		Resolution  *string `json:"resolution"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	ticket, err := h.DB.GetSupportTicket(id)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Ticket not found")
 		return
 	}
-	ticket.ID = id
-	if err := h.DB.UpdateSupportTicket(&ticket); err != nil {
+	for dst, src := range map[*string]*string{
+		&ticket.Subject:     req.Subject,
+		&ticket.Description: req.Description,
+		&ticket.Category:    req.Category,
+		&ticket.Priority:    req.Priority,
+		&ticket.Status:      req.Status,
+		&ticket.Resolution:  req.Resolution,
+	} {
+		if src != nil {
+			*dst = *src
+		}
+	}
+	if req.AssignedTo != nil {
+		ticket.AssignedTo = req.AssignedTo
+	}
+	if err := h.DB.UpdateSupportTicket(ticket); err != nil {
 		respondError(w, http.StatusInternalServerError, "Failed to update ticket")
 		return
 	}
@@ -3130,81 +9289,47 @@ func (h *Handler) DeleteSupportTicket(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, http.StatusOK, map[string]bool{"success": true})
 }
 
-// UpdatePortalWiFiSSID updates the WiFi SSID for customer's device
-func (h *Handler) UpdatePortalWiFiSSID(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		CustomerID int64  `json:"customerId"`
-		DeviceID   int64  `json:"deviceId"`
-		SSID       string `json:"ssid"`
+// customerDevice returns a device if it is assigned to the customer, else nil
+func (h *Handler) customerDevice(customerID, deviceID int64) *models.Device {
+	devices, err := h.DB.GetCustomerDevices(customerID)
+	if err != nil {
+		return nil
 	}
-
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid request")
-		return
+	for _, d := range devices {
+		if d.ID == deviceID {
+			return d
+		}
 	}
+	return nil
+}
 
-	if req.SSID == "" {
-		respondError(w, http.StatusBadRequest, "SSID cannot be empty")
-		return
+// UpdatePortalWiFiSSID updates the WiFi SSID for customer's device
+func (h *Handler) UpdatePortalWiFiSSID(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		DeviceID int64  `json:"deviceId"`
+		SSID     string `json:"ssid"`
 	}
-
-	// Get device to determine vendor
-	device, _ := h.DB.GetDevice(req.DeviceID)
-
-	// Create task to update SSID on device with vendor-specific parameters
-	params := make(map[string]string)
-
-	if device != nil {
-		manufacturer := strings.ToUpper(device.Manufacturer)
-		if containsString(manufacturer, "HUAWEI") {
-			// Huawei specific paths
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.SSID"] = req.SSID
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.SSID"] = req.SSID
-			params["Device.WiFi.SSID.1.SSID"] = req.SSID
-			params["Device.WiFi.SSID.2.SSID"] = req.SSID
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.X_HW_SSID"] = req.SSID
-		} else if containsString(manufacturer, "ZTE") {
-			// ZTE specific paths
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.SSID"] = req.SSID
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.SSID"] = req.SSID
-			params["Device.WiFi.SSID.1.SSID"] = req.SSID
-			params["Device.WiFi.SSID.2.SSID"] = req.SSID
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.X_ZTE_SSID"] = req.SSID
-		} else if containsString(manufacturer, "FIBERHOME") {
-			// FiberHome specific paths
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.SSID"] = req.SSID
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.SSID"] = req.SSID
-			params["Device.WiFi.SSID.1.SSID"] = req.SSID
-			params["Device.WiFi.SSID.2.SSID"] = req.SSID
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.X_FH_SSID"] = req.SSID
-		} else if containsString(manufacturer, "ALCATEL") || containsString(manufacturer, "NOKIA") {
-			// Alcatel/Nokia specific paths
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.SSID"] = req.SSID
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.SSID"] = req.SSID
-			params["Device.WiFi.SSID.1.SSID"] = req.SSID
-			params["Device.WiFi.SSID.2.SSID"] = req.SSID
-			params["Device.WiFi.SSID.1.Name"] = req.SSID
-		} else if containsString(manufacturer, "CIOT") {
-			// CIOT specific paths
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.SSID"] = req.SSID
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.SSID"] = req.SSID
-			params["Device.WiFi.SSID.1.SSID"] = req.SSID
-			params["Device.WiFi.SSID.2.SSID"] = req.SSID
-		} else {
-			// Default paths for unknown vendors
-			params["Device.WiFi.SSID.1.SSID"] = req.SSID
-			params["Device.WiFi.SSID.2.SSID"] = req.SSID
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.SSID"] = req.SSID
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.SSID"] = req.SSID
-			params["Device.WiFi.SSID.1.Name"] = req.SSID
-		}
-	} else {
-		// If no device info, try common paths
-		params["Device.WiFi.SSID.1.SSID"] = req.SSID
-		params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.SSID"] = req.SSID
-		params["Device.WiFi.SSID.1.Name"] = req.SSID
+
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if req.SSID == "" {
+		respondError(w, http.StatusBadRequest, "SSID cannot be empty")
+		return
+	}
+
+	// The device must belong to the customer making the change
+	device := h.customerDevice(middleware.GetCustomerFromContext(r.Context()), req.DeviceID)
+	if device == nil {
+		respondError(w, http.StatusNotFound, "Device not found")
+		return
 	}
 
+	// Create task to update SSID on device with vendor-specific parameters
+	params := buildSSIDParams(device, req.SSID)
+
 	paramsJSON, _ := json.Marshal(params)
 
 	task := &models.DeviceTask{
@@ -3228,13 +9353,12 @@ func (h *Handler) UpdatePortalWiFiSSID(w http.ResponseWriter, r *http.Request) {
 // UpdatePortalWiFiPassword updates the WiFi password for customer's device
 func (h *Handler) UpdatePortalWiFiPassword(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		CustomerID int64  `json:"customerId"`
-		DeviceID   int64  `json:"deviceId"`
-		Password   string `json:"password"`
+		DeviceID int64  `json:"deviceId"`
+		Password string `json:"password"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid request")
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
@@ -3243,77 +9367,15 @@ func (h *Handler) UpdatePortalWiFiPassword(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// Get device to determine vendor
-	device, _ := h.DB.GetDevice(req.DeviceID)
+	// The device must belong to the customer making the change
+	device := h.customerDevice(middleware.GetCustomerFromContext(r.Context()), req.DeviceID)
+	if device == nil {
+		respondError(w, http.StatusNotFound, "Device not found")
+		return
+	}
 
 	// Create task to update password on device with vendor-specific parameters
-	params := make(map[string]string)
-
-	if device != nil {
-		manufacturer := strings.ToUpper(device.Manufacturer)
-		if containsString(manufacturer, "HUAWEI") {
-			// Huawei specific paths
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.PreSharedKey.1.KeyPassphrase"] = req.Password
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.KeyPassphrase"] = req.Password
-			params["Device.WiFi.AccessPoint.1.Security.KeyPassphrase"] = req.Password
-			params["Device.WiFi.AccessPoint.2.Security.KeyPassphrase"] = req.Password
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.PreSharedKey.1.KeyPassphrase"] = req.Password
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.KeyPassphrase"] = req.Password
-		} else if containsString(manufacturer, "ZTE") {
-			// ZTE specific paths
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.PreSharedKey.1.KeyPassphrase"] = req.Password
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.KeyPassphrase"] = req.Password
-			params["Device.WiFi.AccessPoint.1.Security.KeyPassphrase"] = req.Password
-			params["Device.WiFi.AccessPoint.2.Security.KeyPassphrase"] = req.Password
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.PreSharedKey.1.KeyPassphrase"] = req.Password
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.KeyPassphrase"] = req.Password
-		} else if containsString(manufacturer, "FIBERHOME") {
-			// FiberHome specific paths
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.PreSharedKey.1.KeyPassphrase"] = req.Password
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.KeyPassphrase"] = req.Password
-			params["Device.WiFi.AccessPoint.1.Security.KeyPassphrase"] = req.Password
-			params["Device.WiFi.AccessPoint.2.Security.KeyPassphrase"] = req.Password
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.PreSharedKey.1.KeyPassphrase"] = req.Password
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.KeyPassphrase"] = req.Password
-		} else if containsString(manufacturer, "ALCATEL") || containsString(manufacturer, "NOKIA") {
-			// Alcatel/Nokia specific paths
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.PreSharedKey.1.KeyPassphrase"] = req.Password
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.KeyPassphrase"] = req.Password
-			params["Device.WiFi.AccessPoint.1.Security.KeyPassphrase"] = req.Password
-			params["Device.WiFi.AccessPoint.2.Security.KeyPassphrase"] = req.Password
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.PreSharedKey.1.KeyPassphrase"] = req.Password
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.KeyPassphrase"] = req.Password
-		} else if containsString(manufacturer, "CIOT") {
-			// CIOT specific paths
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.PreSharedKey.1.KeyPassphrase"] = req.Password
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.KeyPassphrase"] = req.Password
-			params["Device.WiFi.AccessPoint.1.Security.KeyPassphrase"] = req.Password
-			params["Device.WiFi.AccessPoint.2.Security.KeyPassphrase"] = req.Password
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.PreSharedKey.1.KeyPassphrase"] = req.Password
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.KeyPassphrase"] = req.Password
-		} else if containsString(manufacturer, "TPLINK") || containsString(manufacturer, "TP-LINK") {
-			// TP-Link specific paths
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.PreSharedKey.1.KeyPassphrase"] = req.Password
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.KeyPassphrase"] = req.Password
-			params["Device.WiFi.AccessPoint.1.Security.KeyPassphrase"] = req.Password
-			params["Device.WiFi.AccessPoint.2.Security.KeyPassphrase"] = req.Password
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.PreSharedKey.1.KeyPassphrase"] = req.Password
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.KeyPassphrase"] = req.Password
-		} else {
-			// Default paths for unknown vendors
-			params["Device.WiFi.AccessPoint.1.Security.KeyPassphrase"] = req.Password
-			params["Device.WiFi.AccessPoint.2.Security.KeyPassphrase"] = req.Password
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.PreSharedKey.1.KeyPassphrase"] = req.Password
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.KeyPassphrase"] = req.Password
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.PreSharedKey.1.KeyPassphrase"] = req.Password
-			params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.2.KeyPassphrase"] = req.Password
-		}
-	} else {
-		// If no device info, try common paths
-		params["Device.WiFi.AccessPoint.1.Security.KeyPassphrase"] = req.Password
-		params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.PreSharedKey.1.KeyPassphrase"] = req.Password
-		params["InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.KeyPassphrase"] = req.Password
-	}
+	params := buildWiFiPasswordParams(device, req.Password)
 
 	paramsJSON, _ := json.Marshal(params)
 
@@ -3337,6 +9399,15 @@ func (h *Handler) UpdatePortalWiFiPassword(w http.ResponseWriter, r *http.Reques
 
 // ============== Payment Gateway Handlers ==============
 
+// onlinePaymentMinAmount returns the smallest invoice total that may be paid
+// through the payment gateway; smaller invoices aren't worth its fees
+func (h *Handler) onlinePaymentMinAmount() float64 {
+	if h.Config == nil {
+		return 0
+	}
+	return h.Config.OnlinePaymentMinAmount
+}
+
 // GetPaymentChannels returns available payment channels
 func (h *Handler) GetPaymentChannels(w http.ResponseWriter, r *http.Request) {
 	if h.Payment == nil {
@@ -3362,136 +9433,587 @@ func (h *Handler) CreatePaymentTransaction(w http.ResponseWriter, r *http.Reques
 		respondError(w, http.StatusServiceUnavailable, "Payment gateway not configured")
 		return
 	}
-
-	id := getPathInt64(r, "id")
-	if id == 0 {
-		respondError(w, http.StatusBadRequest, "Invalid invoice ID")
+
+	id := getPathInt64(r, "id")
+	if id == 0 {
+		respondError(w, http.StatusBadRequest, "Invalid invoice ID")
+		return
+	}
+
+	invoice, err := h.DB.GetInvoice(id)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Invoice not found")
+		return
+	}
+
+	if minAmount := h.onlinePaymentMinAmount(); invoice.Total < minAmount {
+		respondError(w, http.StatusUnprocessableEntity, fmt.Sprintf(
+			"Online payment is only available for invoices of %s or more, please pay this invoice by cash or bank transfer", h.formatMoney(minAmount)))
+		return
+	}
+
+	// Only what is still owed is charged, so earlier partial payments count
+	balance := invoice.Total - invoice.PaidAmount
+	if invoice.Status == models.InvoicePaid || balance < 0.5 {
+		respondError(w, http.StatusConflict, "Invoice is already paid")
+		return
+	}
+	amount := int64(balance + 0.5)
+
+	customer, err := h.DB.GetCustomer(invoice.CustomerID)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Customer not found")
+		return
+	}
+
+	// Prepare request
+	base, external := h.publicBaseURL(r)
+	req := payment.TransactionRequest{
+		InvoiceID: invoice.InvoiceNo,
+		Amount:    amount,
+		Customer: payment.Customer{
+			Name:  customer.Name,
+			Email: customer.Email,
+			Phone: customer.Phone,
+		},
+		Description: fmt.Sprintf("Payment for %s", invoice.InvoiceNo),
+		Items: []payment.Item{
+			{
+				Name:     fmt.Sprintf("Invoice %s", invoice.InvoiceNo),
+				Price:    amount,
+				Quantity: 1,
+			},
+		},
+		ReturnURL: base + "/portal/invoices",
+	}
+	// Only override the gateway's configured webhook with an address it can reach
+	if external {
+		req.CallbackURL = base + "/api/callbacks/" + h.paymentGatewayName()
+	}
+
+	resp, err := h.Payment.CreateTransaction(req)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Payment creation failed: "+err.Error())
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data":    resp,
+	})
+}
+
+// publicBaseURL returns the scheme://host clients reach this server at:
+// PUBLIC_URL when set, otherwise the request as seen through a trusted proxy
+// (TRUSTED_PROXIES). external is false when it fell back to the raw request
+// address, which may not be reachable from outside.
+func (h *Handler) publicBaseURL(r *http.Request) (base string, external bool) {
+	if h.Config.PublicURL != "" {
+		return strings.TrimRight(h.Config.PublicURL, "/"), true
+	}
+	return middleware.ExternalBaseURL(r, h.Config.TrustedProxies)
+}
+
+// HandleTripayCallback processes webhook from Tripay
+func (h *Handler) HandleTripayCallback(w http.ResponseWriter, r *http.Request) {
+	h.handlePaymentCallback(w, r, "tripay")
+}
+
+// HandleMidtransCallback processes HTTP notifications from Midtrans
+func (h *Handler) HandleMidtransCallback(w http.ResponseWriter, r *http.Request) {
+	h.handlePaymentCallback(w, r, "midtrans")
+}
+
+// handlePaymentCallback processes webhook from Payment Gateway. Every verified
+// callback is persisted first so a failed update can be retried or reprocessed.
+// Callbacks are only accepted for the configured gateway, whose key verifies them.
+func (h *Handler) handlePaymentCallback(w http.ResponseWriter, r *http.Request, gateway string) {
+	if h.Payment == nil || h.paymentGatewayName() != gateway {
+		respondJSON(w, http.StatusServiceUnavailable, map[string]interface{}{"success": false, "message": "Gateway not configured"})
+		return
+	}
+
+	data, err := h.Payment.HandleCallback(r)
+	if err != nil {
+		fmt.Printf("[PAYMENT] Callback error: %v\n", err)
+		respondJSON(w, http.StatusBadRequest, map[string]interface{}{"success": false, "error": err.Error()})
+		return
+	}
+
+	payload, _ := json.Marshal(data)
+	event, err := h.DB.CreateCallbackEvent(&models.CallbackEvent{
+		Gateway:   gateway,
+		Reference: data.ReferenceID,
+		InvoiceNo: data.InvoiceID,
+		Status:    data.Status,
+		Payload:   payload,
+	})
+	if err == nil {
+		if reason := h.checkCallbackReplay(gateway, data); reason != "" {
+			fmt.Printf("[PAYMENT] Callback rejected (%s): ref=%s invoice=%s\n", reason, data.ReferenceID, data.InvoiceID)
+			h.DB.UpdateCallbackEventResult(event.ID, "rejected", reason)
+			h.DB.CreateLog(nil, "warning", "payment",
+				fmt.Sprintf("Payment callback for %s rejected: %s", data.InvoiceID, reason), data.ReferenceID)
+			respondJSON(w, http.StatusConflict, map[string]interface{}{"success": false, "message": reason})
+			return
+		}
+	} else {
+		// Not fatal: still try to apply the payment, but it won't be retryable
+		fmt.Printf("[PAYMENT] Failed to persist callback event: %v\n", err)
+		if status, err := h.applyPaymentCallback(data); err != nil {
+			respondJSON(w, status, map[string]interface{}{"success": false, "message": err.Error()})
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]interface{}{"success": true})
+		return
+	}
+
+	if status, err := h.processCallbackEvent(event); err != nil {
+		respondJSON(w, status, map[string]interface{}{"success": false, "message": err.Error()})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{"success": true})
+}
+
+// paymentGatewayName returns the configured payment gateway, tripay by default
+func (h *Handler) paymentGatewayName() string {
+	if h.Config.PaymentGateway == "midtrans" {
+		return "midtrans"
+	}
+	return "tripay"
+}
+
+// checkCallbackReplay returns a rejection reason if the callback looks like a replay:
+// its reference was already applied, or its paid_at is outside the accepted window.
+func (h *Handler) checkCallbackReplay(gateway string, data *payment.CallbackData) string {
+	if data.ReferenceID == "" {
+		return "missing payment reference"
+	}
+	if processed, err := h.DB.IsCallbackReferenceProcessed(gateway, data.ReferenceID, data.Status); err == nil && processed {
+		return "duplicate payment reference"
+	}
+
+	if data.Status == "PAID" && data.PaidAt > 0 && h.Config.CallbackMaxAgeHours > 0 {
+		paidAt := time.Unix(data.PaidAt, 0)
+		maxAge := time.Duration(h.Config.CallbackMaxAgeHours) * time.Hour
+		if time.Since(paidAt) > maxAge {
+			return "callback timestamp too old"
+		}
+		if time.Until(paidAt) > 5*time.Minute {
+			return "callback timestamp in the future"
+		}
+	}
+	return ""
+}
+
+// defaultCallbackAttempts is how many times a callback event is tried before
+// it is dead-lettered when CALLBACK_MAX_ATTEMPTS is not set
+const defaultCallbackAttempts = 5
+
+// callbackMaxAttempts returns the configured attempts per callback event
+func (h *Handler) callbackMaxAttempts() int {
+	if h.Config != nil && h.Config.CallbackMaxAttempts > 0 {
+		return h.Config.CallbackMaxAttempts
+	}
+	return defaultCallbackAttempts
+}
+
+// processCallbackEvent applies a stored callback and records the outcome on the event.
+// Events that keep failing are moved to the "dead" state after callbackMaxAttempts.
+func (h *Handler) processCallbackEvent(event *models.CallbackEvent) (int, error) {
+	var data payment.CallbackData
+	if err := json.Unmarshal(event.Payload, &data); err != nil {
+		h.DB.UpdateCallbackEventResult(event.ID, "dead", "invalid payload: "+err.Error())
+		return http.StatusBadRequest, fmt.Errorf("invalid callback payload")
+	}
+
+	if reason := h.checkCallbackAmount(&data); reason != "" {
+		h.DB.UpdateCallbackEventResult(event.ID, "rejected", reason)
+		h.DB.CreateLog(nil, "warning", "payment",
+			fmt.Sprintf("Payment callback for %s rejected: %s", data.InvoiceID, reason), data.ReferenceID)
+		return http.StatusConflict, fmt.Errorf("%s", reason)
+	}
+
+	status, err := h.applyPaymentCallback(&data)
+	if err != nil {
+		processStatus := "failed"
+		if event.Attempts+1 >= h.callbackMaxAttempts() {
+			processStatus = "dead"
+			h.DB.CreateLog(nil, "error", "payment",
+				fmt.Sprintf("Payment callback %d for %s moved to dead-letter", event.ID, event.InvoiceNo), err.Error())
+		}
+		h.DB.UpdateCallbackEventResult(event.ID, processStatus, err.Error())
+		return status, err
+	}
+
+	h.DB.UpdateCallbackEventResult(event.ID, "processed", "")
+	return http.StatusOK, nil
+}
+
+// checkCallbackAmount returns a rejection reason if a PAID callback pays less
+// than the invoice balance. Unknown and already paid invoices are left to
+// applyPaymentCallback.
+func (h *Handler) checkCallbackAmount(data *payment.CallbackData) string {
+	if data.Status != "PAID" {
+		return ""
+	}
+	invoice, err := h.DB.GetInvoiceByNumber(data.InvoiceID)
+	if err != nil || invoice.Status == models.InvoicePaid {
+		return ""
+	}
+	if balance := invoice.Total - invoice.PaidAmount; float64(data.Amount) < balance-0.5 {
+		return fmt.Sprintf("amount %d is less than the invoice balance %s", data.Amount, h.formatMoney(balance))
+	}
+	return ""
+}
+
+// applyPaymentCallback records the payment of the invoice balance, which
+// marks the invoice paid, and notifies the customer. It is idempotent: an
+// already paid invoice is a no-op.
+func (h *Handler) applyPaymentCallback(data *payment.CallbackData) (int, error) {
+	invoice, err := h.DB.GetInvoiceByNumber(data.InvoiceID)
+	if err != nil {
+		fmt.Printf("[PAYMENT] Invoice not found: %s\n", data.InvoiceID)
+		return http.StatusNotFound, fmt.Errorf("invoice %s not found", data.InvoiceID)
+	}
+
+	// Idempotency check
+	if invoice.Status == models.InvoicePaid {
+		return http.StatusOK, nil
+	}
+
+	if data.Status == "PAID" {
+		now := h.Config.Now()
+		if data.PaidAt > 0 {
+			now = time.Unix(data.PaidAt, 0).In(now.Location())
+		}
+		// checkCallbackAmount already refused less than the balance, and the
+		// gateway charges it rounded to whole units. The balance is what is
+		// applied; more than that (e.g. a gateway fee charged to the customer)
+		// is flagged for review.
+		balance := invoice.Total - invoice.PaidAmount
+		if float64(data.Amount) > balance+0.5 {
+			h.DB.CreateLog(nil, "warning", "payment",
+				fmt.Sprintf("Payment callback for %s paid %d, more than the balance %s", invoice.InvoiceNo, data.Amount, h.formatMoney(balance)),
+				data.ReferenceID)
+		}
+
+		payment := &models.Payment{
+			Amount:        balance,
+			PaymentMethod: data.PaymentMethod,
+			Status:        "completed",
+			PaymentDate:   now,
+			Reference:     data.ReferenceID,
+			ReceivedBy:    "SYSTEM (ONLINE)",
+		}
+		paid, err := h.DB.AddInvoicePayment(invoice.ID, payment)
+		if errors.Is(err, database.ErrInvoiceAlreadyPaid) {
+			return http.StatusOK, nil
+		}
+		if err != nil {
+			fmt.Printf("[PAYMENT] Failed to apply payment: %v\n", err)
+			return http.StatusInternalServerError, err
+		}
+
+		h.sendPaymentReceipt(paid, now)
+		if paid.Status == models.InvoicePaid {
+			h.autoUnsuspend(paid.CustomerID)
+		}
+	}
+
+	return http.StatusOK, nil
+}
+
+// RetryFailedCallbacks reprocesses failed callback events that still have attempts left
+func (h *Handler) RetryFailedCallbacks() {
+	events, err := h.DB.GetRetryableCallbackEvents(h.callbackMaxAttempts())
+	if err != nil {
+		fmt.Printf("[PAYMENT] Error fetching failed callbacks: %v\n", err)
+		return
+	}
+	for _, event := range events {
+		if _, err := h.processCallbackEvent(event); err != nil {
+			fmt.Printf("[PAYMENT] Retry of callback %d failed: %v\n", event.ID, err)
+		} else {
+			fmt.Printf("[PAYMENT] Callback %d reprocessed successfully\n", event.ID)
+		}
+	}
+}
+
+// GetCallbackEvents lists stored payment callbacks, filterable by ?status=failed|dead|processed
+func (h *Handler) GetCallbackEvents(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	limit := getQueryInt(r, "limit", 50)
+	offset := getQueryInt(r, "offset", 0)
+
+	events, err := h.DB.GetCallbackEvents(status, limit, offset)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch callback events")
+		return
+	}
+	respondJSON(w, http.StatusOK, events)
+}
+
+// ReprocessCallback manually re-runs a stored callback event (e.g. from the dead-letter)
+func (h *Handler) ReprocessCallback(w http.ResponseWriter, r *http.Request) {
+	id := getPathInt64(r, "id")
+	event, err := h.DB.GetCallbackEvent(id)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Callback event not found")
+		return
+	}
+	if event.ProcessStatus == "processed" {
+		respondError(w, http.StatusConflict, "Callback event already processed")
+		return
+	}
+
+	if status, err := h.processCallbackEvent(event); err != nil {
+		respondError(w, status, err.Error())
+		return
+	}
+
+	h.DB.CreateLog(nil, "info", "payment", fmt.Sprintf("Payment callback %d reprocessed manually", id), "")
+	respondJSON(w, http.StatusOK, map[string]interface{}{"success": true})
+}
+
+// ============== Notification Outbox ==============
+
+// notificationProviders lists the outbox channels in display order
+var notificationProviders = []string{channelEmail, channelWhatsApp, channelFCM}
+
+// notificationConfigured reports whether a channel has a client to send with
+func (h *Handler) notificationConfigured(channel string) bool {
+	switch channel {
+	case channelEmail:
+		return h.Mailer != nil
+	case channelWhatsApp:
+		return h.WA != nil
+	case channelFCM:
+		return h.FCM != nil
+	}
+	return false
+}
+
+// notificationPool returns the bounded worker pool of a channel
+func (h *Handler) notificationPool(channel string) *notification.Pool {
+	switch channel {
+	case channelEmail:
+		return h.emailPool
+	case channelWhatsApp:
+		return h.waPool
+	}
+	return h.pushPool
+}
+
+// sendOutboxMessage hands one message to its channel's provider
+func (h *Handler) sendOutboxMessage(m *models.OutboxMessage) error {
+	switch m.Channel {
+	case channelEmail:
+		return h.Mailer.Send(m.Recipient, m.Subject, m.Body)
+	case channelWhatsApp:
+		return h.WA.Send(m.Recipient, m.Body)
+	case channelFCM:
+		return h.FCM.Send(m.Recipient, m.Subject, m.Body)
+	}
+	return fmt.Errorf("unknown notification channel %q", m.Channel)
+}
+
+// queueNotification stores a customer notification in the outbox and starts
+// delivering it right away unless its provider is down, in which case it
+// waits for the outbox flush. subject is the email subject or push title.
+func (h *Handler) queueNotification(channel, recipient, subject, body string) {
+	if recipient == "" || !h.notificationConfigured(channel) {
+		return
+	}
+	m, err := h.DB.EnqueueNotification(&models.OutboxMessage{
+		Channel:   channel,
+		Recipient: recipient,
+		Subject:   subject,
+		Body:      body,
+	})
+	if err != nil {
+		fmt.Printf("[NOTIFY] Failed to queue %s to %s: %v\n", channel, recipient, err)
+		return
+	}
+	if h.breakers[channel].State() == notification.BreakerClosed {
+		h.notificationPool(channel).Submit(func() { h.deliverNotification(m, false) })
+	}
+}
+
+// sendCredential delivers a message carrying a secret (portal password,
+// reset code) straight to its provider. It bypasses the outbox so the secret
+// is never stored or listed, and is not retried: the customer can simply
+// request a new one.
+func (h *Handler) sendCredential(channel, recipient, subject, body string) {
+	if recipient == "" || !h.notificationConfigured(channel) {
+		return
+	}
+	m := &models.OutboxMessage{Channel: channel, Recipient: recipient, Subject: subject, Body: body}
+	h.notificationPool(channel).Submit(func() {
+		if err := h.sendOutboxMessage(m); err != nil {
+			fmt.Printf("[NOTIFY] Failed to send %s credential message to %s: %v\n", channel, recipient, err)
+		}
+	})
+}
+
+// deliverNotification makes one delivery attempt. A failed message is
+// retried with backoff until the retry window is over; a probe is the single
+// send that tells whether a down provider has recovered.
+func (h *Handler) deliverNotification(m *models.OutboxMessage, probe bool) {
+	breaker := h.breakers[m.Channel]
+	if ok, err := h.DB.ClaimNotification(m.ID); err != nil || !ok {
+		if probe {
+			// Let the next flush pick another probe
+			breaker.Release()
+		}
+		return
+	}
+	if !probe && breaker.State() != notification.BreakerClosed {
+		// The provider went down after this message was queued
+		h.DB.RescheduleNotification(m.ID, time.Now(), "")
 		return
 	}
 
-	invoice, err := h.DB.GetInvoice(id)
-	if err != nil {
-		respondError(w, http.StatusNotFound, "Invoice not found")
+	err := h.sendOutboxMessage(m)
+	if err == nil {
+		if err := h.DB.MarkNotificationSent(m.ID); err != nil {
+			fmt.Printf("[NOTIFY] Failed to mark message %d sent: %v\n", m.ID, err)
+		}
+		if breaker.Success() {
+			h.notifyProviderHealth(m.Channel, nil)
+			go h.FlushNotificationOutbox()
+		}
 		return
 	}
 
-	customer, err := h.DB.GetCustomer(invoice.CustomerID)
-	if err != nil {
-		respondError(w, http.StatusNotFound, "Customer not found")
+	if breaker.Failure(err) {
+		h.notifyProviderHealth(m.Channel, err)
+	}
+	window := 24 * time.Hour
+	if h.Config != nil {
+		window = time.Duration(h.Config.NotifyRetryHours) * time.Hour
+	}
+	if time.Since(m.CreatedAt) >= window {
+		h.DB.FailNotification(m.ID, err.Error())
+		h.DB.CreateLog(nil, "error", "notification",
+			fmt.Sprintf("Gave up on %s notification to %s after %d attempts", m.Channel, m.Recipient, m.Attempts+1), err.Error())
 		return
 	}
+	h.DB.RescheduleNotification(m.ID, time.Now().Add(models.OutboxRetryDelay(m.Attempts+1)), err.Error())
+}
 
-	// Prepare request
-	req := payment.TransactionRequest{
-		InvoiceID: invoice.InvoiceNo,
-		Amount:    int64(invoice.Total),
-		Customer: payment.Customer{
-			Name:  customer.Name,
-			Email: customer.Email,
-			Phone: customer.Phone,
-		},
-		Description: fmt.Sprintf("Payment for %s", invoice.InvoiceNo),
-		Items: []payment.Item{
-			{
-				Name:     fmt.Sprintf("Invoice %s", invoice.InvoiceNo),
-				Price:    int64(invoice.Total),
-				Quantity: 1,
-			},
-		},
-		ReturnURL: "http://localhost:8080/portal/invoices", // Should be configurable
+// notifyProviderHealth logs and alerts the operators once when a provider
+// goes down (cause set) and once when it recovers
+func (h *Handler) notifyProviderHealth(channel string, cause error) {
+	level, details := "info", ""
+	msg := fmt.Sprintf("Notification provider %s recovered, flushing queued messages", channel)
+	tgMsg := fmt.Sprintf("✅ <b>Provider notifikasi pulih</b>\n%s: antrean pesan dikirim ulang", channel)
+	if cause != nil {
+		level, details = "error", cause.Error()
+		msg = fmt.Sprintf("Notification provider %s is down, messages are held in the outbox", channel)
+		tgMsg = fmt.Sprintf("⚠️ <b>Provider notifikasi down</b>\n%s: pesan ditahan di antrean dan dicoba ulang\n%s",
+			channel, template.HTMLEscapeString(details))
 	}
+	h.DB.CreateLog(nil, level, "notification", msg, details)
 
-	resp, err := h.Payment.CreateTransaction(req)
+	if h.Telegram != nil {
+		if err := h.Telegram.SendMessage(tgMsg); err != nil {
+			fmt.Printf("[NOTIFY] Telegram alert for %s failed: %v\n", channel, err)
+		}
+	}
+}
+
+// FlushNotificationOutbox hands due outbox messages to the workers. A channel
+// whose provider is down is skipped, except for one probe message once its
+// cooldown is over. Called by the scheduler every minute.
+func (h *Handler) FlushNotificationOutbox() {
+	due, err := h.DB.GetDueNotifications(time.Now(), 500)
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, "Payment creation failed: "+err.Error())
+		fmt.Printf("[NOTIFY] Error loading outbox: %v\n", err)
 		return
 	}
+	probing := make(map[string]bool)
+	for _, m := range due {
+		m := m
+		if !h.notificationConfigured(m.Channel) {
+			continue
+		}
+		breaker := h.breakers[m.Channel]
+		probe := false
+		if breaker.State() != notification.BreakerClosed {
+			if probing[m.Channel] || !breaker.Allow() {
+				continue
+			}
+			probing[m.Channel], probe = true, true
+		}
+		h.notificationPool(m.Channel).Submit(func() { h.deliverNotification(m, probe) })
+	}
 
-	respondJSON(w, http.StatusOK, map[string]interface{}{
-		"success": true,
-		"data":    resp,
-	})
+	if _, err := h.DB.PruneNotificationOutbox(); err != nil {
+		fmt.Printf("[NOTIFY] Failed to prune outbox: %v\n", err)
+	}
 }
 
-// HandleTripayCallback processes webhook from Payment Gateway
-func (h *Handler) HandleTripayCallback(w http.ResponseWriter, r *http.Request) {
-	if h.Payment == nil {
-		respondJSON(w, http.StatusServiceUnavailable, map[string]interface{}{"success": false, "message": "Gateway not configured"})
-		return
+// GetNotificationHealth reports per provider whether it is up, why it last
+// failed and how many messages are waiting or were given up on
+func (h *Handler) GetNotificationHealth(w http.ResponseWriter, r *http.Request) {
+	counts, err := h.DB.GetOutboxCounts()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get outbox counts")
+		return
+	}
+
+	providers := make([]map[string]interface{}, 0, len(notificationProviders))
+	for _, channel := range notificationProviders {
+		health := h.breakers[channel].Health(channel)
+		providers = append(providers, map[string]interface{}{
+			"provider":      channel,
+			"configured":    h.notificationConfigured(channel),
+			"state":         health.State,
+			"failures":      health.Failures,
+			"lastError":     health.LastError,
+			"lastFailureAt": health.LastFailure,
+			"retryAt":       health.RetryAt,
+			"pending":       counts[channel][models.OutboxPending] + counts[channel][models.OutboxSending],
+			"failed":        counts[channel][models.OutboxFailed],
+			"sent":          counts[channel][models.OutboxSent],
+		})
 	}
+	respondJSON(w, http.StatusOK, providers)
+}
 
-	data, err := h.Payment.HandleCallback(r)
+// GetNotificationOutbox lists outbox messages, filterable by ?status= and ?channel=
+func (h *Handler) GetNotificationOutbox(w http.ResponseWriter, r *http.Request) {
+	limit := getQueryInt(r, "limit", 50)
+	if limit < 1 || limit > 500 {
+		limit = 50
+	}
+	messages, err := h.DB.GetOutboxMessages(r.URL.Query().Get("status"), r.URL.Query().Get("channel"), limit, getQueryInt(r, "offset", 0))
 	if err != nil {
-		fmt.Printf("[PAYMENT] Callback error: %v\n", err)
-		respondJSON(w, http.StatusBadRequest, map[string]interface{}{"success": false, "error": err.Error()})
+		respondError(w, http.StatusInternalServerError, "Failed to get outbox")
 		return
 	}
+	respondJSON(w, http.StatusOK, messages)
+}
 
-	invoice, err := h.DB.GetInvoiceByNumber(data.InvoiceID)
+// RetryNotification re-queues a message the outbox gave up on, with a fresh
+// retry window
+func (h *Handler) RetryNotification(w http.ResponseWriter, r *http.Request) {
+	id := getPathInt64(r, "id")
+	ok, err := h.DB.RetryNotification(id)
 	if err != nil {
-		fmt.Printf("[PAYMENT] Invoice not found: %s\n", data.InvoiceID)
-		respondJSON(w, http.StatusNotFound, map[string]interface{}{"success": false, "message": "Invoice not found"})
+		respondError(w, http.StatusInternalServerError, "Failed to retry notification")
 		return
 	}
-
-	// Idempotency check
-	if invoice.Status == models.InvoicePaid {
-		respondJSON(w, http.StatusOK, map[string]interface{}{"success": true})
+	if !ok {
+		respondError(w, http.StatusConflict, "Only failed notifications can be retried")
 		return
 	}
-
-	if data.Status == "PAID" {
-		now := time.Unix(data.PaidAt, 0)
-		invoice.Status = models.InvoicePaid
-		invoice.PaidAmount = float64(data.Amount)
-		invoice.PaidAt = &now
-
-		if err := h.DB.UpdateInvoice(invoice); err != nil {
-			fmt.Printf("[PAYMENT] Failed to update invoice: %v\n", err)
-			respondJSON(w, http.StatusInternalServerError, map[string]interface{}{"success": false})
-			return
-		}
-
-		// Record Payment
-		payment := &models.Payment{
-			CustomerID:    invoice.CustomerID,
-			InvoiceID:     &invoice.ID,
-			Amount:        float64(data.Amount),
-			PaymentMethod: data.PaymentMethod,
-			Status:        "completed",
-			PaymentDate:   now,
-			Reference:     data.ReferenceID,
-			ReceivedBy:    "SYSTEM (ONLINE)",
-		}
-		h.DB.CreatePayment(payment)
-
-		// Send Receipt Email
-		customer, _ := h.DB.GetCustomer(invoice.CustomerID)
-		if customer != nil {
-			if customer.Email != "" && h.Mailer != nil {
-				html := mailer.GeneratePaymentReceiptHTML(
-					customer.Name,
-					invoice.InvoiceNo,
-					fmt.Sprintf("Rp %.2f", invoice.Total),
-					now.Format("02/01/2006 15:04"),
-				)
-				go h.Mailer.Send(customer.Email, "Payment Receipt - GO-ACS", html)
-			}
-
-			// Send WA Notification
-			if customer.Phone != "" && h.WA != nil {
-				msg := whatsapp.GeneratePaymentReceiptMessage(
-					customer.Name,
-					invoice.InvoiceNo,
-					now.Format("02/01/2006 15:04"),
-					fmt.Sprintf("Rp %.2f", invoice.Total),
-				)
-				go h.WA.Send(customer.Phone, msg)
-			}
-		}
+	if m, err := h.DB.GetOutboxMessage(id); err == nil && h.breakers[m.Channel].State() == notification.BreakerClosed {
+		h.notificationPool(m.Channel).Submit(func() { h.deliverNotification(m, false) })
 	}
-
-	respondJSON(w, http.StatusOK, map[string]interface{}{"success": true})
+	respondJSON(w, http.StatusOK, map[string]bool{"success": true})
 }
 
 // Helper function for getting int64 from query
@@ -3536,6 +10058,131 @@ func (h *Handler) GetMobileUsage(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// FieldInstall runs the technician install flow for a freshly mounted ONU: look up the
+// serial, check it has informed, then assign the customer, record GPS and queue WiFi
+// settings. All inputs are validated before anything is written, and the writes are atomic.
+func (h *Handler) FieldInstall(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		SerialNumber string  `json:"serialNumber"`
+		CustomerID   int64   `json:"customerId"`
+		SSID         string  `json:"ssid"`
+		WiFiPassword string  `json:"wifiPassword"`
+		Latitude     float64 `json:"latitude"`
+		Longitude    float64 `json:"longitude"`
+		Address      string  `json:"address"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	req.SerialNumber = strings.TrimSpace(req.SerialNumber)
+	if req.SerialNumber == "" {
+		respondError(w, http.StatusBadRequest, "serialNumber is required")
+		return
+	}
+
+	steps := make([]map[string]interface{}, 0, 5)
+	fail := func(status int, step, message string) {
+		steps = append(steps, map[string]interface{}{"step": step, "success": false, "message": message})
+		respondJSON(w, status, map[string]interface{}{
+			"success": false,
+			"error":   message,
+			"steps":   steps,
+		})
+	}
+
+	// 1. Lookup device by scanned serial
+	device, err := h.DB.GetDeviceBySerial(req.SerialNumber)
+	if err != nil || device == nil {
+		fail(http.StatusNotFound, "lookup", "Device not found, make sure the ONU is powered and has informed")
+		return
+	}
+	informed := device.LastInform != nil
+	steps = append(steps, map[string]interface{}{
+		"step":       "lookup",
+		"success":    true,
+		"deviceId":   device.ID,
+		"status":     device.Status,
+		"informed":   informed,
+		"lastInform": device.LastInform,
+		"rxPower":    device.RXPower,
+	})
+
+	// 2. Validate everything before writing
+	var customer *models.Customer
+	if req.CustomerID > 0 {
+		customer, err = h.DB.GetCustomer(req.CustomerID)
+		if err != nil {
+			fail(http.StatusBadRequest, "customer", "Customer not found")
+			return
+		}
+	}
+	if req.WiFiPassword != "" && len(req.WiFiPassword) < 8 {
+		fail(http.StatusBadRequest, "wifi", "Password must be at least 8 characters")
+		return
+	}
+
+	wifiParams := make(map[string]string)
+	if req.SSID != "" {
+		for k, v := range buildSSIDParams(device, req.SSID) {
+			wifiParams[k] = v
+		}
+	}
+	if req.WiFiPassword != "" {
+		for k, v := range buildWiFiPasswordParams(device, req.WiFiPassword) {
+			wifiParams[k] = v
+		}
+	}
+
+	// 3. Apply
+	taskID, err := h.DB.InstallDevice(device.ID, req.CustomerID, req.Latitude, req.Longitude, req.Address, wifiParams)
+	if err != nil {
+		fmt.Printf("Field install failed for %s: %v\n", device.SerialNumber, err)
+		fail(http.StatusInternalServerError, "apply", "Failed to save install, nothing was changed")
+		return
+	}
+
+	if len(wifiParams) > 0 {
+		steps = append(steps, map[string]interface{}{"step": "wifi", "success": true, "taskId": taskID, "message": "WiFi update queued"})
+	} else {
+		steps = append(steps, map[string]interface{}{"step": "wifi", "success": true, "skipped": true})
+	}
+	if customer != nil {
+		steps = append(steps, map[string]interface{}{"step": "customer", "success": true, "customerCode": customer.CustomerCode, "label": h.labelDevice(device.ID), "message": "Assigned to " + customer.Name})
+	} else {
+		steps = append(steps, map[string]interface{}{"step": "customer", "success": true, "skipped": true})
+	}
+	if req.Latitude != 0 || req.Longitude != 0 {
+		steps = append(steps, map[string]interface{}{"step": "location", "success": true, "latitude": req.Latitude, "longitude": req.Longitude})
+	} else {
+		steps = append(steps, map[string]interface{}{"step": "location", "success": true, "skipped": true})
+	}
+
+	h.DB.CreateLog(&device.ID, "info", "device", fmt.Sprintf("Field install completed for %s", device.SerialNumber), "")
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"success":  true,
+		"deviceId": device.ID,
+		"informed": informed,
+		"steps":    steps,
+	})
+}
+
+// labelDevice applies DEVICE_LABEL_TEMPLATE to a freshly assigned device and
+// returns the new label ("" when the template is disabled or fails)
+func (h *Handler) labelDevice(deviceID int64) string {
+	template := ""
+	if h.Config != nil {
+		template = h.Config.DeviceLabelTemplate
+	}
+	label, err := h.DB.ApplyDeviceLabel(deviceID, template)
+	if err != nil {
+		fmt.Printf("[LABEL] Failed to label device %d: %v\n", deviceID, err)
+		return ""
+	}
+	return label
+}
+
 // GetSettings return all system settings (Mikrotik, Radius, etc)
 func (h *Handler) GetSettings(w http.ResponseWriter, r *http.Request) {
 	settings, err := h.DB.GetSettings()
@@ -3549,30 +10196,82 @@ func (h *Handler) GetSettings(w http.ResponseWriter, r *http.Request) {
 // SaveSettings updates multiple settings
 func (h *Handler) SaveSettings(w http.ResponseWriter, r *http.Request) {
 	var req map[string]string
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid request body")
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
+	warning, status, err := h.applySettings(req)
+	if err != nil {
+		respondError(w, status, err.Error())
+		return
+	}
+	resp := map[string]interface{}{"success": true}
+	if warning != "" {
+		resp["warning"] = warning
+	}
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// applySettings validates and saves settings, then updates the in-memory
+// config. Returns the HTTP status to respond with on error, and a warning
+// when MikroTik settings were rejected (those keys are removed from req).
+func (h *Handler) applySettings(req map[string]string) (string, int, error) {
+	if tz := req["timezone"]; tz != "" {
+		if _, err := time.LoadLocation(tz); err != nil {
+			return "", http.StatusBadRequest, fmt.Errorf("Invalid timezone: %s", tz)
+		}
+	}
+	// The payment gateway is only switched on restart
+	if gw := req["payment_gateway"]; gw != "" && gw != "tripay" && gw != "midtrans" {
+		return "", http.StatusBadRequest, fmt.Errorf("payment_gateway must be tripay or midtrans")
+	}
+	if req["payment_gateway"] == "midtrans" && req["midtrans_server_key"] == "" && h.Config.MidtransServerKey == "" {
+		return "", http.StatusBadRequest, fmt.Errorf("Midtrans requires a server key")
+	}
+	if pub := req["public_url"]; pub != "" {
+		if u, err := url.Parse(pub); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return "", http.StatusBadRequest, fmt.Errorf("public_url must be an absolute http:// or https:// URL")
+		}
+	}
+
+	// New MikroTik credentials only replace the working client once they
+	// reach the router; otherwise isolir/unsuspend would silently break
+	warning := ""
+	candidate := *h.Config
+	mikrotikChanged := false
+	for k, v := range req {
+		if setMikrotikSetting(&candidate, k, v) {
+			mikrotikChanged = true
+		}
+	}
+	if v, ok := req["test_mode"]; ok {
+		candidate.TestMode = v == "true" || v == "1"
+	}
+	mikrotikChanged = mikrotikChanged && (candidate.MikrotikHost != h.Config.MikrotikHost ||
+		candidate.MikrotikUser != h.Config.MikrotikUser || candidate.MikrotikPass != h.Config.MikrotikPass ||
+		candidate.MikrotikPort != h.Config.MikrotikPort)
+	if mikrotikChanged && !candidate.TestMode && candidate.MikrotikHost != "" {
+		if err := verifyMikrotik(mikrotik.New(&candidate)); err != nil {
+			for _, k := range mikrotikSettingKeys {
+				delete(req, k)
+			}
+			mikrotikChanged = false
+			warning = fmt.Sprintf("MikroTik settings not saved: cannot connect to %s:%d (%v); keeping the previous connection",
+				candidate.MikrotikHost, candidate.MikrotikPort, err)
+			h.DB.CreateLog(nil, "warning", "system", warning, "")
+		}
+	}
+
 	for k, v := range req {
 		if err := h.DB.SaveSetting(k, v); err != nil {
-			respondError(w, http.StatusInternalServerError, "Failed to save setting: "+k)
-			return
+			return "", http.StatusInternalServerError, fmt.Errorf("Failed to save setting: %s", k)
 		}
 
 		// Update in-memory config
 		switch k {
-		case "mikrotik_host":
-			h.Config.MikrotikHost = v
-		case "mikrotik_user":
-			h.Config.MikrotikUser = v
-		case "mikrotik_pass":
-			h.Config.MikrotikPass = v
-		case "mikrotik_port":
-			port, _ := strconv.Atoi(v)
-			if port > 0 {
-				h.Config.MikrotikPort = port
-			}
+		case "mikrotik_host", "mikrotik_user", "mikrotik_pass", "mikrotik_port":
+			setMikrotikSetting(h.Config, k, v)
 		case "tripay_api_key":
 			h.Config.TripayAPIKey = v
 		case "tripay_private_key":
@@ -3581,18 +10280,260 @@ func (h *Handler) SaveSettings(w http.ResponseWriter, r *http.Request) {
 			h.Config.TripayMerchantCode = v
 		case "tripay_mode":
 			h.Config.TripayMode = v
+		case "midtrans_server_key":
+			h.Config.MidtransServerKey = v
+		case "midtrans_mode":
+			h.Config.MidtransMode = v
+		case "tr069_username":
+			h.Config.TR069Username = v
+		case "tr069_password":
+			h.Config.TR069Password = v
+		case "device_registration":
+			h.Config.DeviceRegistration = v
+		case "device_alias_format":
+			if v == database.AliasCustomer || v == database.AliasPPPoE || v == database.AliasSerial {
+				h.Config.DeviceAliasFormat = v
+			}
+		case "auto_assign_pppoe":
+			h.Config.AutoAssignPPPoE = v == "true" || v == "1"
+		case "optical_alert_enabled":
+			h.Config.OpticalAlertEnabled = v == "true" || v == "1"
+		case "device_label_template":
+			h.Config.DeviceLabelTemplate = v
+		case "conn_req_scheme":
+			if v == "" || v == "http" || v == "https" {
+				h.Config.ConnReqScheme = v
+			}
+		case "conn_req_port":
+			if port, err := strconv.Atoi(v); err == nil && port >= 0 && port <= 65535 {
+				h.Config.ConnReqPort = port
+			}
+		case "conn_req_tls_verify":
+			h.Config.ConnReqTLSVerify = v == "true" || v == "1"
+		case "public_url":
+			h.Config.PublicURL = strings.TrimRight(v, "/")
+		case "trusted_proxies":
+			h.Config.TrustedProxies = v
+		case "max_pending_tasks":
+			if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+				h.Config.MaxPendingTasks = n
+				h.DB.SetMaxPendingTasks(n)
+			}
+		case "test_mode":
+			h.Config.TestMode = v == "true" || v == "1"
+		case "send_receipt":
+			h.Config.SendReceipt = v == "true" || v == "1"
+		case "max_task_retries":
+			if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+				if n > 0 && h.Config.MaxTaskRetries == 0 {
+					h.taskRetriesSince.Store(time.Now().UnixNano())
+				}
+				h.Config.MaxTaskRetries = n
+			}
+		case "rx_excellent_dbm", "rx_good_dbm", "rx_warning_dbm", "rx_overload_dbm", "rx_alert_hysteresis":
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				setRXThreshold(h.Config, k, f)
+			}
+		case "optical_alert_customer_template":
+			h.Config.OpticalAlertCustomerMsg = v
+		case "optical_alert_operator_template":
+			h.Config.OpticalAlertOperatorMsg = v
+		case "wifi_client_alert":
+			if f, err := strconv.ParseFloat(v, 64); err == nil && f >= 0 && f <= 100 {
+				h.Config.WiFiClientAlertPercent = f
+			}
+		case "default_package_id":
+			if id, err := strconv.ParseInt(v, 10, 64); err == nil && id >= 0 {
+				h.Config.DefaultPackageID = id
+			}
+		case "currency_symbol":
+			h.Config.CurrencySymbol = v
+		case "currency_decimals":
+			if n, err := strconv.Atoi(v); err == nil && n >= 0 && n <= 4 {
+				h.Config.CurrencyDecimals = n
+			}
+		case "amount_rounding":
+			if step, err := strconv.ParseFloat(v, 64); err == nil && step >= 0 {
+				h.Config.AmountRounding = step
+			}
+		case "timezone":
+			if v != "" {
+				if loc, err := time.LoadLocation(v); err == nil {
+					h.Config.Timezone, h.Config.Location = v, loc
+				}
+			}
+		case "ticket_auto_assign":
+			if v == models.TicketAssignOff || v == models.TicketAssignRoundRobin || v == models.TicketAssignArea {
+				h.Config.TicketAutoAssign = v
+			}
+		case "tax_percent":
+			if pct, err := strconv.ParseFloat(v, 64); err == nil && pct >= 0 && pct <= 100 {
+				h.Config.TaxPercent = pct
+			}
+		case "online_payment_min_amount":
+			if amount, err := strconv.ParseFloat(v, 64); err == nil && amount >= 0 {
+				h.Config.OnlinePaymentMinAmount = amount
+			}
+		case "invoice_reminder_days":
+			if _, err := parseReminderDays(v); err == nil {
+				h.Config.InvoiceReminderDays = v
+			}
+		case "proration_enabled":
+			h.Config.ProrationEnabled = v == "true" || v == "1"
+		case "ticket_auto_close_days":
+			if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+				h.Config.TicketAutoCloseDays = n
+			}
+		case "hold_max_days":
+			if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+				h.Config.HoldMaxDays = n
+			}
+		case "carry_forward_max_invoices":
+			if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+				h.Config.CarryForwardMaxInvoices = n
+			}
+		case "carry_forward_max_amount":
+			if amount, err := strconv.ParseFloat(v, 64); err == nil && amount >= 0 {
+				h.Config.CarryForwardMaxAmount = amount
+			}
 		}
 	}
 
 	// Re-initialize MikroTik client if MikroTik settings were changed
-	for k := range req {
-		if k == "mikrotik_host" || k == "mikrotik_user" || k == "mikrotik_pass" || k == "mikrotik_port" {
-			h.Mikrotik = mikrotik.New(h.Config)
-			break
+	if _, ok := req["test_mode"]; mikrotikChanged || ok {
+		h.Mikrotik = mikrotik.New(h.Config)
+		h.resetMikrotikResources()
+	}
+	return warning, http.StatusOK, nil
+}
+
+// mikrotikSettingKeys are the settings that make up the router connection
+var mikrotikSettingKeys = []string{"mikrotik_host", "mikrotik_user", "mikrotik_pass", "mikrotik_port"}
+
+// setMikrotikSetting applies a router connection setting to cfg and reports
+// whether k was one
+func setMikrotikSetting(cfg *config.Config, k, v string) bool {
+	switch k {
+	case "mikrotik_host":
+		cfg.MikrotikHost = v
+	case "mikrotik_user":
+		cfg.MikrotikUser = v
+	case "mikrotik_pass":
+		cfg.MikrotikPass = v
+	case "mikrotik_port":
+		if port, _ := strconv.Atoi(v); port > 0 {
+			cfg.MikrotikPort = port
 		}
+	default:
+		return false
 	}
+	return true
+}
 
-	respondJSON(w, http.StatusOK, map[string]bool{"success": true})
+// mikrotikVerifyTimeout bounds the connection check for new MikroTik
+// settings, so an unreachable host can't hang the settings request
+const mikrotikVerifyTimeout = 10 * time.Second
+
+// verifyMikrotik checks that a client can log in and read the router's resources
+func verifyMikrotik(client *mikrotik.Client) error {
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.GetSystemResource()
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(mikrotikVerifyTimeout):
+		return fmt.Errorf("timed out after %s", mikrotikVerifyTimeout)
+	}
+}
+
+// isSecretSetting reports whether a setting holds a credential (passwords,
+// API/private keys, tokens), which backups leave out unless asked for
+func isSecretSetting(key string) bool {
+	key = strings.ToLower(key)
+	for _, marker := range []string{"pass", "secret", "_key", "token"} {
+		if strings.Contains(key, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// ExportSettings dumps the settings table for backup or for cloning the
+// configuration to a new instance. Secrets are omitted (and listed) unless
+// includeSecrets=true.
+func (h *Handler) ExportSettings(w http.ResponseWriter, r *http.Request) {
+	settings, err := h.DB.GetSettings()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to fetch settings")
+		return
+	}
+
+	includeSecrets := r.URL.Query().Get("includeSecrets") == "true"
+	omitted := []string{}
+	if !includeSecrets {
+		for k := range settings {
+			if isSecretSetting(k) {
+				omitted = append(omitted, k)
+				delete(settings, k)
+			}
+		}
+		sort.Strings(omitted)
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=settings-%s.json", time.Now().Format("20060102")))
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"exportedAt":     time.Now(),
+		"includeSecrets": includeSecrets,
+		"omitted":        omitted,
+		"settings":       settings,
+	})
+}
+
+// ImportSettings restores settings from an ExportSettings document. Secrets in
+// the document are skipped unless includeSecrets=true, so a stray backup can't
+// silently overwrite credentials on the target instance.
+func (h *Handler) ImportSettings(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Settings map[string]string `json:"settings"`
+	}
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(req.Settings) == 0 {
+		respondError(w, http.StatusBadRequest, "No settings to import")
+		return
+	}
+
+	includeSecrets := r.URL.Query().Get("includeSecrets") == "true"
+	skipped := []string{}
+	if !includeSecrets {
+		for k := range req.Settings {
+			if isSecretSetting(k) {
+				skipped = append(skipped, k)
+				delete(req.Settings, k)
+			}
+		}
+		sort.Strings(skipped)
+	}
+
+	warning, status, err := h.applySettings(req.Settings)
+	if err != nil {
+		respondError(w, status, err.Error())
+		return
+	}
+
+	h.DB.CreateLog(nil, "info", "system",
+		fmt.Sprintf("Imported %d settings", len(req.Settings)), strings.Join(skipped, ","))
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"success":  true,
+		"imported": len(req.Settings),
+		"skipped":  skipped,
+		"warning":  warning,
+	})
 }
 
 // TestMikrotik tests connection to MikroTik router using current config
@@ -3617,6 +10558,49 @@ func (h *Handler) TestMikrotik(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// resetMikrotikResources (re)creates the dashboard's router stats cache for the current client
+func (h *Handler) resetMikrotikResources() {
+	if h.Mikrotik == nil {
+		h.mikrotikResources = nil
+		return
+	}
+	interval := 30
+	if h.Config != nil && h.Config.MikrotikPollSeconds > 0 {
+		interval = h.Config.MikrotikPollSeconds
+	}
+	h.mikrotikResources = mikrotik.NewResourceCache(h.Mikrotik, time.Duration(interval)*time.Second)
+}
+
+// GetMikrotikResource returns router stats for the dashboard from a cache
+// refreshed at most every MIKROTIK_POLL_SECONDS
+func (h *Handler) GetMikrotikResource(w http.ResponseWriter, r *http.Request) {
+	if h.mikrotikResources == nil {
+		respondError(w, http.StatusServiceUnavailable, "MikroTik client not initialized")
+		return
+	}
+
+	resource, fetchedAt, err := h.mikrotikResources.Get()
+	if err != nil {
+		respondJSON(w, http.StatusOK, map[string]interface{}{
+			"success":   false,
+			"error":     err.Error(),
+			"fetchedAt": fetchedAt,
+		})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"success":     true,
+		"version":     resource["version"],
+		"uptime":      resource["uptime"],
+		"board":       resource["board-name"],
+		"cpuLoad":     resource["cpu-load"],
+		"freeMemory":  resource["free-memory"],
+		"totalMemory": resource["total-memory"],
+		"fetchedAt":   fetchedAt,
+	})
+}
+
 // GetMikrotikProfiles returns all PPP profiles from MikroTik
 func (h *Handler) GetMikrotikProfiles(w http.ResponseWriter, r *http.Request) {
 	if h.Mikrotik == nil {
@@ -3645,8 +10629,8 @@ func (h *Handler) CreateMikrotikProfile(w http.ResponseWriter, r *http.Request)
 		RateLimit string `json:"rate_limit"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid request body")
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
@@ -3654,6 +10638,10 @@ func (h *Handler) CreateMikrotikProfile(w http.ResponseWriter, r *http.Request)
 		respondError(w, http.StatusBadRequest, "Profile name is required")
 		return
 	}
+	if err := mikrotik.ValidateRateLimit(req.RateLimit); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
 	if err := h.Mikrotik.SyncPPPProfile(req.Name, req.RateLimit); err != nil {
 		respondError(w, http.StatusBadGateway, "Failed to create profile: "+err.Error())
@@ -3916,8 +10904,8 @@ func (h *Handler) SyncCustomerToDeviceByPPPoE(w http.ResponseWriter, r *http.Req
 		PPPoEUsername string `json:"pppoeUsername"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid request body")
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
@@ -3936,6 +10924,9 @@ func (h *Handler) SyncCustomerToDeviceByPPPoE(w http.ResponseWriter, r *http.Req
 		respondError(w, http.StatusInternalServerError, "Failed to sync customer to device: "+err.Error())
 		return
 	}
+	if device, err := h.DB.GetDeviceByTemplate(req.PPPoEUsername); err == nil {
+		h.labelDevice(device.ID)
+	}
 
 	// Get the updated customer with device info
 	customer, err := h.DB.GetCustomer(req.CustomerID)
@@ -4055,8 +11046,8 @@ func (h *Handler) UpdateLANConfig(w http.ResponseWriter, r *http.Request) {
 	id := getPathInt64(r, "id")
 
 	var config LANConfig
-	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid request body")
+	if err := decodeJSON(w, r, &config); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
@@ -4145,6 +11136,7 @@ func (h *Handler) UpdateLANConfig(w http.ResponseWriter, r *http.Request) {
 	}
 
 	h.DB.CreateLog(&id, "info", "lan", "LAN configuration update queued", "")
+	h.recordCommand(r, id, "lan", created, "")
 
 	respondJSON(w, http.StatusOK, map[string]interface{}{
 		"success": true,
@@ -4232,8 +11224,8 @@ func (h *Handler) CreatePortForwardingRule(w http.ResponseWriter, r *http.Reques
 	id := getPathInt64(r, "id")
 
 	var rule PortForwardingRule
-	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid request body")
+	if err := decodeJSON(w, r, &rule); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
@@ -4302,8 +11294,8 @@ func (h *Handler) SetBridgeMode(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		Enable bool `json:"enable"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid request body")
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
@@ -4401,8 +11393,8 @@ func (h *Handler) UpdateQoSConfig(w http.ResponseWriter, r *http.Request) {
 	id := getPathInt64(r, "id")
 
 	var config QoSConfig
-	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid request body")
+	if err := decodeJSON(w, r, &config); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
@@ -4454,6 +11446,7 @@ func (h *Handler) UpdateQoSConfig(w http.ResponseWriter, r *http.Request) {
 	}
 
 	h.DB.CreateLog(&id, "info", "qos", "QoS configuration update queued", "")
+	h.recordCommand(r, id, "qos", created, "")
 
 	respondJSON(w, http.StatusOK, map[string]interface{}{
 		"success": true,
@@ -4470,8 +11463,8 @@ func (h *Handler) ChangeAdminPassword(w http.ResponseWriter, r *http.Request) {
 		NewPassword     string `json:"new_password"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid request body")
+	if err := decodeJSON(w, r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
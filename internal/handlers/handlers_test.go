@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go-acs/internal/config"
+	"go-acs/internal/database"
+	"go-acs/internal/models"
+
+	"github.com/gorilla/mux"
+)
+
+func TestMain(m *testing.M) {
+	// NewHandler parses web/templates relative to the repository root
+	if err := os.Chdir("../.."); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+// newTestHandler returns a handler backed by a fresh database. cfg may be
+// nil for the defaults.
+func newTestHandler(t *testing.T, cfg *config.Config) *Handler {
+	t.Helper()
+	db, err := database.InitDB(filepath.Join(t.TempDir(), "acs.db"), database.Options{BusyTimeoutMs: 5000})
+	if err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if cfg == nil {
+		cfg = config.Load()
+	}
+	cfg.TestMode = true
+	return NewHandler(db, nil, nil, nil, nil, nil, nil, nil, cfg, nil)
+}
+
+// createTestCustomer inserts an active customer on a fresh package
+func createTestCustomer(t *testing.T, h *Handler, code, phone string) *models.Customer {
+	t.Helper()
+	res, err := h.DB.Exec(`INSERT INTO packages (name, price, is_active) VALUES (?, 100000, 1)`, "Paket "+code)
+	if err != nil {
+		t.Fatalf("insert package: %v", err)
+	}
+	pkgID, _ := res.LastInsertId()
+	res, err = h.DB.Exec(`INSERT INTO customers (customer_code, name, phone, package_id, status) VALUES (?, ?, ?, ?, 'active')`,
+		code, "Customer "+code, phone, pkgID)
+	if err != nil {
+		t.Fatalf("insert customer: %v", err)
+	}
+	id, _ := res.LastInsertId()
+	customer, err := h.DB.GetCustomer(id)
+	if err != nil {
+		t.Fatalf("GetCustomer: %v", err)
+	}
+	return customer
+}
+
+// createTestDevice registers a device the way the first Inform does
+func createTestDevice(t *testing.T, h *Handler, serial, manufacturer string) *models.Device {
+	t.Helper()
+	device, err := h.DB.CreateDevice(&models.Device{SerialNumber: serial, Manufacturer: manufacturer, ModelName: "ONT"})
+	if err != nil {
+		t.Fatalf("CreateDevice: %v", err)
+	}
+	return device
+}
+
+// serve calls a handler with a JSON body and route variables
+func serve(handler http.HandlerFunc, method, body string, vars map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, "/", bytes.NewBufferString(body))
+	if vars != nil {
+		r = mux.SetURLVars(r, vars)
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, r)
+	return rec
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"go-acs/internal/models"
+)
+
+// holdCustomer puts a customer on hold until days after today
+func holdCustomer(t *testing.T, h *Handler, customerID int64, days int) {
+	t.Helper()
+	body := fmt.Sprintf(`{"resumeOn": %q, "reason": "mudik"}`, h.Config.Now().AddDate(0, 0, days).Format("2006-01-02"))
+	if rec := serve(h.HoldCustomer, http.MethodPost, body, map[string]string{"id": fmt.Sprint(customerID)}); rec.Code != http.StatusCreated {
+		t.Fatalf("HoldCustomer = %d %s", rec.Code, rec.Body)
+	}
+}
+
+func customerStatus(t *testing.T, h *Handler, customerID int64) string {
+	t.Helper()
+	customer, err := h.DB.GetCustomer(customerID)
+	if err != nil {
+		t.Fatalf("GetCustomer: %v", err)
+	}
+	return customer.Status
+}
+
+func TestHeldCustomerIsNotInvoiced(t *testing.T) {
+	h := newTestHandler(t, nil)
+	held := createTestCustomer(t, h, "C001", "")
+	billed := createTestCustomer(t, h, "C002", "")
+	holdCustomer(t, h, held.ID, 14)
+
+	if status := customerStatus(t, h, held.ID); status != "hold" {
+		t.Fatalf("status = %q, want hold", status)
+	}
+	report, err := h.GenerateInvoicesInternal()
+	if err != nil {
+		t.Fatalf("GenerateInvoicesInternal: %v", err)
+	}
+	if report.Generated != 1 {
+		t.Errorf("generated %d invoices, want 1", report.Generated)
+	}
+	for _, c := range []struct {
+		id   int64
+		want int
+	}{{held.ID, 0}, {billed.ID, 1}} {
+		invoices, _, err := h.DB.GetInvoices(&c.id, "", 10, 0)
+		if err != nil || len(invoices) != c.want {
+			t.Errorf("customer %d has %d invoice(s) (err %v), want %d", c.id, len(invoices), err, c.want)
+		}
+	}
+
+	// A second hold while one is open is refused
+	body := fmt.Sprintf(`{"resumeOn": %q}`, h.Config.Now().AddDate(0, 0, 7).Format("2006-01-02"))
+	if rec := serve(h.HoldCustomer, http.MethodPost, body, map[string]string{"id": fmt.Sprint(held.ID)}); rec.Code != http.StatusConflict {
+		t.Errorf("second hold = %d, want 409", rec.Code)
+	}
+}
+
+func TestHoldRejectsPastAndTooLongResumeDates(t *testing.T) {
+	h := newTestHandler(t, nil)
+	h.Config.HoldMaxDays = 30
+	customer := createTestCustomer(t, h, "C001", "")
+	vars := map[string]string{"id": fmt.Sprint(customer.ID)}
+
+	for _, days := range []int{0, -3, 31} {
+		body := fmt.Sprintf(`{"resumeOn": %q}`, h.Config.Now().AddDate(0, 0, days).Format("2006-01-02"))
+		if rec := serve(h.HoldCustomer, http.MethodPost, body, vars); rec.Code != http.StatusBadRequest {
+			t.Errorf("resume in %d days = %d, want 400", days, rec.Code)
+		}
+	}
+	if status := customerStatus(t, h, customer.ID); status != "active" {
+		t.Errorf("status = %q after rejected holds, want active", status)
+	}
+}
+
+func TestDueHoldsResumeAutomatically(t *testing.T) {
+	h := newTestHandler(t, nil)
+	due := createTestCustomer(t, h, "C001", "")
+	overdue := createTestCustomer(t, h, "C002", "")
+	later := createTestCustomer(t, h, "C003", "")
+	for _, c := range []*models.Customer{due, overdue, later} {
+		// Portal usernames are unique; customers created through the API always have one
+		h.DB.Exec(`UPDATE customers SET username = ? WHERE id = ?`, strings.ToLower(c.CustomerCode), c.ID)
+		holdCustomer(t, h, c.ID, 5)
+	}
+
+	// Move the first two holds' end to today and to a missed run yesterday
+	today := h.Config.Now()
+	h.DB.Exec(`UPDATE customer_holds SET resume_on = ? WHERE customer_id = ?`, today.Format("2006-01-02"), due.ID)
+	h.DB.Exec(`UPDATE customer_holds SET resume_on = ? WHERE customer_id = ?`, today.AddDate(0, 0, -1).Format("2006-01-02"), overdue.ID)
+
+	h.ResumeDueHolds()
+
+	for _, c := range []struct {
+		id      int64
+		status  string
+		resumed bool
+	}{{due.ID, "active", true}, {overdue.ID, "active", true}, {later.ID, "hold", false}} {
+		if status := customerStatus(t, h, c.id); status != c.status {
+			t.Errorf("customer %d status = %q, want %q", c.id, status, c.status)
+		}
+		var resumed int
+		h.DB.QueryRow(`SELECT COUNT(*) FROM customer_holds WHERE customer_id = ? AND resumed_at IS NOT NULL`, c.id).Scan(&resumed)
+		if (resumed == 1) != c.resumed {
+			t.Errorf("customer %d hold resumed = %v, want %v", c.id, resumed == 1, c.resumed)
+		}
+	}
+
+	// Resumed customers are billed again; the one still on hold is not
+	report, err := h.GenerateInvoicesInternal()
+	if err != nil || report.Generated != 2 {
+		t.Fatalf("after resuming generated %+v (err %v), want 2 invoices", report, err)
+	}
+
+	// Running again resumes nobody twice
+	h.ResumeDueHolds()
+	var logged int
+	h.DB.QueryRow(`SELECT COUNT(*) FROM logs WHERE message LIKE '%resumed from hold on schedule'`).Scan(&logged)
+	if logged != 2 {
+		t.Errorf("%d resume logs, want 2", logged)
+	}
+}
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"go-acs/internal/models"
+)
+
+func TestIdentifyQueuesVendorLocatorParameters(t *testing.T) {
+	for _, tc := range []struct {
+		manufacturer string
+		want         map[string]string
+	}{
+		{"Huawei Technologies Co., Ltd", map[string]string{
+			"InternetGatewayDevice.X_HW_DEBUG.SMP.LED.BlinkEnable":   "1",
+			"InternetGatewayDevice.X_HW_DEBUG.SMP.LED.BlinkDuration": "60",
+		}},
+		{"ZTE", map[string]string{
+			"InternetGatewayDevice.DeviceInfo.X_ZTE-COM_LEDControl.Blink":         "1",
+			"InternetGatewayDevice.DeviceInfo.X_ZTE-COM_LEDControl.BlinkDuration": "60",
+		}},
+		{"FiberHome", map[string]string{
+			"InternetGatewayDevice.X_FH_LEDControl.Locate":         "1",
+			"InternetGatewayDevice.X_FH_LEDControl.LocateDuration": "60",
+		}},
+		{"Nokia", map[string]string{
+			"InternetGatewayDevice.X_ALU_LED.Identify":         "true",
+			"InternetGatewayDevice.X_ALU_LED.IdentifyDuration": "60",
+		}},
+	} {
+		t.Run(tc.manufacturer, func(t *testing.T) {
+			h := newTestHandler(t, nil)
+			device := createTestDevice(t, h, "SN-IDENTIFY", tc.manufacturer)
+
+			rec := serve(h.IdentifyDevice, http.MethodPost, `{"durationSeconds": 60}`, map[string]string{"id": fmt.Sprint(device.ID)})
+			if rec.Code != http.StatusOK {
+				t.Fatalf("identify = %d: %s", rec.Code, rec.Body)
+			}
+			var resp struct {
+				TaskID int64 `json:"taskId"`
+			}
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			task, err := h.DB.GetTask(resp.TaskID)
+			if err != nil {
+				t.Fatalf("GetTask: %v", err)
+			}
+			if task.Type != models.TaskSetParameterValues || task.Priority != models.TaskPriorityHigh {
+				t.Errorf("task = %s priority %d, want a high-priority setParameterValues", task.Type, task.Priority)
+			}
+			var params map[string]string
+			json.Unmarshal(task.Parameters, &params)
+			if !reflect.DeepEqual(params, tc.want) {
+				t.Errorf("params = %v, want %v", params, tc.want)
+			}
+		})
+	}
+}
+
+func TestIdentifyReportsUnsupportedModel(t *testing.T) {
+	h := newTestHandler(t, nil)
+	device := createTestDevice(t, h, "SN-TPLINK", "TP-Link")
+
+	rec := serve(h.IdentifyDevice, http.MethodPost, "", map[string]string{"id": fmt.Sprint(device.ID)})
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("identify = %d, want 422", rec.Code)
+	}
+	var resp struct {
+		Success   bool   `json:"success"`
+		Supported bool   `json:"supported"`
+		Error     string `json:"error"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if resp.Success || resp.Supported || resp.Error != "Identify is not supported for TP-Link ONT (supported: Huawei, ZTE, FiberHome, Nokia/Alcatel)" {
+		t.Errorf("response = %+v", resp)
+	}
+	if tasks, _ := h.DB.GetPendingTasks(device.ID); len(tasks) != 0 {
+		t.Errorf("%d tasks queued for an unsupported model", len(tasks))
+	}
+}
+
+func TestIdentifyDurationDefaultsAndLimits(t *testing.T) {
+	h := newTestHandler(t, nil)
+	device := createTestDevice(t, h, "SN-IDENTIFY", "ZTE")
+	vars := map[string]string{"id": fmt.Sprint(device.ID)}
+
+	rec := serve(h.IdentifyDevice, http.MethodPost, "", vars)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("identify without body = %d: %s", rec.Code, rec.Body)
+	}
+	tasks, _ := h.DB.GetPendingTasks(device.ID)
+	if len(tasks) != 1 {
+		t.Fatalf("%d tasks queued, want 1", len(tasks))
+	}
+	var params map[string]string
+	json.Unmarshal(tasks[0].Parameters, &params)
+	if got := params["InternetGatewayDevice.DeviceInfo.X_ZTE-COM_LEDControl.BlinkDuration"]; got != "120" {
+		t.Errorf("default duration = %q, want 120", got)
+	}
+
+	if rec := serve(h.IdentifyDevice, http.MethodPost, `{"durationSeconds": 901}`, vars); rec.Code != http.StatusBadRequest {
+		t.Errorf("901 seconds = %d, want 400", rec.Code)
+	}
+	if rec := serve(h.IdentifyDevice, http.MethodPost, "", map[string]string{"id": "999"}); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown device = %d, want 404", rec.Code)
+	}
+}
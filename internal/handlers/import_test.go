@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http/httptest"
+	"testing"
+)
+
+func importCSV(t *testing.T, h *Handler, content string) map[string]interface{} {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, _ := mw.CreateFormFile("file", "customers.csv")
+	part.Write([]byte(content))
+	mw.Close()
+
+	r := httptest.NewRequest("POST", "/api/customers/import", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	rec := httptest.NewRecorder()
+	h.ImportCustomers(rec, r)
+	if rec.Code != 200 {
+		t.Fatalf("ImportCustomers = %d %s", rec.Code, rec.Body)
+	}
+	var resp map[string]interface{}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	return resp
+}
+
+func TestImportWithoutCodeMatchesExistingCustomer(t *testing.T) {
+	h := newTestHandler(t, nil)
+	h.DB.Exec(`INSERT INTO packages (name, price, is_active) VALUES ('Paket 10M', 100000, 1)`)
+	csv := "name,phone,package\nBudi,0811111,Paket 10M\n"
+
+	if resp := importCSV(t, h, csv); resp["created"] != 1.0 {
+		t.Fatalf("first import = %v", resp)
+	}
+	if resp := importCSV(t, h, csv); resp["updated"] != 1.0 || resp["created"] != 0.0 {
+		t.Fatalf("re-import = %v, want one update", resp)
+	}
+	var count int
+	h.DB.QueryRow(`SELECT COUNT(*) FROM customers`).Scan(&count)
+	if count != 1 {
+		t.Fatalf("%d customers after re-import, want 1", count)
+	}
+
+	if resp := importCSV(t, h, "name,package\nAnonim,Paket 10M\n"); resp["skipped"] != 1.0 {
+		t.Fatalf("row without code, username or phone = %v, want skipped", resp)
+	}
+}
+
+func TestImportStatusChangeTracksSuspension(t *testing.T) {
+	h, provider := newOutboxHandler(t)
+	customer := createTestCustomer(t, h, "C001", "0811111")
+	openEvents := func() int {
+		var n int
+		h.DB.QueryRow(`SELECT COUNT(*) FROM suspension_events WHERE customer_id = ? AND reactivated_at IS NULL`, customer.ID).Scan(&n)
+		return n
+	}
+
+	importCSV(t, h, "customer_code,name,phone,status\nC001,Budi,0811111,suspended\n")
+	h.waPool.Wait()
+	if openEvents() != 1 || provider.received.Load() != 1 {
+		t.Fatalf("after suspending import: %d open event(s), %d notice(s); want 1 and 1", openEvents(), provider.received.Load())
+	}
+
+	importCSV(t, h, "customer_code,name,phone,status\nC001,Budi,0811111,suspended\n")
+	h.waPool.Wait()
+	if provider.received.Load() != 1 {
+		t.Fatalf("re-import sent another suspension notice")
+	}
+
+	importCSV(t, h, "customer_code,name,phone,status\nC001,Budi,0811111,active\n")
+	if openEvents() != 0 {
+		t.Fatalf("suspension event still open after importing active")
+	}
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"go-acs/internal/models"
+)
+
+// createCustomerWithoutPackage inserts an active customer with no package
+func createCustomerWithoutPackage(t *testing.T, h *Handler, code string) int64 {
+	t.Helper()
+	res, err := h.DB.Exec(`INSERT INTO customers (customer_code, name, status) VALUES (?, ?, 'active')`, code, "Customer "+code)
+	if err != nil {
+		t.Fatalf("insert customer: %v", err)
+	}
+	id, _ := res.LastInsertId()
+	return id
+}
+
+func TestInvoiceRunReportsCustomersWithoutPackage(t *testing.T) {
+	h := newTestHandler(t, nil)
+	h.Config.DefaultPackageID = 0
+	createTestCustomer(t, h, "C001", "")
+	missing := createCustomerWithoutPackage(t, h, "C002")
+
+	rec := serve(h.GenerateMonthlyInvoices, http.MethodPost, "", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		Count   int                         `json:"count"`
+		Skipped []models.InvoiceRunCustomer `json:"skipped"`
+		Message string                      `json:"message"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Count != 1 {
+		t.Errorf("count = %d, want 1", resp.Count)
+	}
+	if len(resp.Skipped) != 1 || resp.Skipped[0].CustomerID != missing || resp.Skipped[0].CustomerCode != "C002" ||
+		resp.Skipped[0].Reason != "no package assigned" {
+		t.Fatalf("skipped = %+v, want C002 without a package", resp.Skipped)
+	}
+	if resp.Message != "Generated 1 invoices, skipped 1 customer(s)" {
+		t.Errorf("message = %q", resp.Message)
+	}
+
+	var logged int
+	h.DB.QueryRow(`SELECT COUNT(*) FROM logs WHERE level = 'warning' AND category = 'billing' AND message LIKE 'Invoice generation skipped 1 %'`).Scan(&logged)
+	if logged != 1 {
+		t.Errorf("%d skip warnings logged, want 1", logged)
+	}
+}
+
+func TestInvoiceRunBillsDefaultPackage(t *testing.T) {
+	h := newTestHandler(t, nil)
+	h.Config.TaxPercent = 0
+	billed := createTestCustomer(t, h, "C001", "")
+	missing := createCustomerWithoutPackage(t, h, "C002")
+	h.Config.DefaultPackageID = billed.PackageID
+
+	report, err := h.GenerateInvoicesInternal()
+	if err != nil {
+		t.Fatalf("GenerateInvoicesInternal: %v", err)
+	}
+	if report.Generated != 2 || len(report.Skipped) != 0 {
+		t.Fatalf("report = %+v, want 2 generated and none skipped", report)
+	}
+	if len(report.DefaultPackage) != 1 || report.DefaultPackage[0].CustomerID != missing {
+		t.Fatalf("default package customers = %+v, want C002", report.DefaultPackage)
+	}
+	invoices, _, err := h.DB.GetInvoices(&missing, "", 10, 0)
+	if err != nil || len(invoices) != 1 {
+		t.Fatalf("C002 has %d invoice(s), err %v", len(invoices), err)
+	}
+}
+
+func TestInvoiceRunSkipsUnknownDefaultPackage(t *testing.T) {
+	h := newTestHandler(t, nil)
+	h.Config.DefaultPackageID = 9999
+	missing := createCustomerWithoutPackage(t, h, "C002")
+
+	report, err := h.GenerateInvoicesInternal()
+	if err != nil {
+		t.Fatalf("GenerateInvoicesInternal: %v", err)
+	}
+	if report.Generated != 0 || len(report.Skipped) != 1 || report.Skipped[0].CustomerID != missing ||
+		report.Skipped[0].Reason != "package 9999 not found" {
+		t.Fatalf("report = %+v, want C002 skipped for the missing package", report)
+	}
+}
+
+func TestInvoiceRunUsesTheConfiguredTimezone(t *testing.T) {
+	// Between them, these are a day ahead of or behind UTC at any hour, so the
+	// run must take the month and due date from the ISP's clock, not UTC
+	for _, loc := range []*time.Location{time.FixedZone("UTC+14", 14*60*60), time.FixedZone("UTC-11", -11*60*60)} {
+		h := newTestHandler(t, nil)
+		h.Config.Location = loc
+		h.Config.TaxPercent = 0
+		customer := createTestCustomer(t, h, "C001", "")
+
+		if _, err := h.GenerateInvoicesInternal(); err != nil {
+			t.Fatalf("%s: GenerateInvoicesInternal: %v", loc, err)
+		}
+		invoices, _, err := h.DB.GetInvoices(&customer.ID, "", 10, 0)
+		if err != nil || len(invoices) != 1 {
+			t.Fatalf("%s: %d invoice(s), err %v", loc, len(invoices), err)
+		}
+		inv := invoices[0]
+
+		now := time.Now().In(loc)
+		periodStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc)
+		due := time.Date(now.Year(), now.Month()+1, 10, 0, 0, 0, 0, loc)
+		if !inv.PeriodStart.Equal(periodStart) {
+			t.Errorf("%s: period start = %v, want %v", loc, inv.PeriodStart, periodStart)
+		}
+		if !inv.DueDate.Equal(due) || inv.DueDate.Format("2006-01-02") != due.Format("2006-01-02") {
+			t.Errorf("%s: due date = %v, want %v", loc, inv.DueDate, due)
+		}
+		if want := "INV-" + now.Format("200601") + "-"; !strings.HasPrefix(inv.InvoiceNo, want) {
+			t.Errorf("%s: invoice number = %s, want the %s prefix", loc, inv.InvoiceNo, want)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestClassifyIPv6Param(t *testing.T) {
+	const ppp = "InternetGatewayDevice.WANDevice.1.WANConnectionDevice.1.WANPPPConnection.1."
+	tests := []struct {
+		path, value, want string
+	}{
+		{ppp + "X_HW_IPv6.IPv6Address.1.IPAddress", "2001:db8::10", "address"},
+		{"Device.IP.Interface.1.IPv6Address.1.IPAddress", "2001:db8::1", "address"},
+		{ppp + "X_HW_IPv6.IPv6Address.1.IPAddress", "fe80::1", ""},
+		{ppp + "X_HW_IPv6.IPv6Address.1.IPAddress", "10.0.0.2", ""},
+		{ppp + "X_HW_IPv6.IPv6Address.1.IPAddress", "", ""},
+		{ppp + "X_HW_IPv6.IPv6Prefix.1.Prefix", "2001:db8:100::/56", "prefix"},
+		{"Device.DHCPv6.Client.1.PrefixDelegationAddress", "2001:db8:200::/56", "prefix"},
+		{ppp + "X_HW_IPv6.IPv6DNSServers", "2001:4860:4860::8888", "dns"},
+		{ppp + "ExternalIPAddress", "2001:db8::10", ""},
+		{ppp + "DNSServers", "8.8.8.8", ""},
+	}
+	for _, tt := range tests {
+		if got := classifyIPv6Param(tt.path, tt.value); got != tt.want {
+			t.Errorf("classifyIPv6Param(%q, %q) = %q, want %q", tt.path, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestAppendCSVUnique(t *testing.T) {
+	list := appendCSVUnique("", "2001:db8::53, 2001:db8::54")
+	list = appendCSVUnique(list, "2001:db8::54,2001:db8::55,")
+	if want := "2001:db8::53, 2001:db8::54, 2001:db8::55"; list != want {
+		t.Fatalf("list = %q, want %q", list, want)
+	}
+}
+
+func TestGetDeviceWANReadsDualStack(t *testing.T) {
+	h := newTestHandler(t, nil)
+	device := createTestDevice(t, h, "SN-V6", "Huawei")
+	const conn = "InternetGatewayDevice.WANDevice.1.WANConnectionDevice.1.WANPPPConnection.1"
+	for path, value := range map[string]string{
+		conn + ".ExternalIPAddress":                 "100.64.0.2",
+		conn + ".DNSServers":                        "8.8.8.8,1.1.1.1",
+		conn + ".X_HW_IPv6.IPv6Address.1.IPAddress": "2001:db8::10",
+		conn + ".X_HW_IPv6.IPv6Address.2.IPAddress": "fe80::1",
+		conn + ".X_HW_IPv6.IPv6Prefix.1.Prefix":     "2001:db8:100::/56",
+		conn + ".X_HW_IPv6.IPv6DNSServers":          "2001:4860:4860::8888,2001:4860:4860::8844",
+	} {
+		if _, err := h.DB.SetDeviceParameter(device.ID, path, value, "xsd:string", false); err != nil {
+			t.Fatalf("SetDeviceParameter %s: %v", path, err)
+		}
+	}
+
+	rec := serve(h.GetDeviceWAN, http.MethodGet, "", map[string]string{"id": fmt.Sprint(device.ID)})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var got struct {
+		WANIP          string          `json:"wanIP"`
+		WANDNS1        string          `json:"wanDNS1"`
+		WANDNS2        string          `json:"wanDNS2"`
+		WANIPv6        string          `json:"wanIPv6"`
+		WANIPv6Prefix  string          `json:"wanIPv6Prefix"`
+		WANIPv6DNS     string          `json:"wanIPv6DNS"`
+		AllConnections []WANConnection `json:"allConnections"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.WANIP != "100.64.0.2" || got.WANDNS1 != "8.8.8.8" || got.WANDNS2 != "1.1.1.1" {
+		t.Errorf("IPv4 = %s dns %s/%s, want 100.64.0.2 dns 8.8.8.8/1.1.1.1", got.WANIP, got.WANDNS1, got.WANDNS2)
+	}
+	if got.WANIPv6 != "2001:db8::10" || got.WANIPv6Prefix != "2001:db8:100::/56" {
+		t.Errorf("IPv6 = %s prefix %s, want 2001:db8::10 prefix 2001:db8:100::/56", got.WANIPv6, got.WANIPv6Prefix)
+	}
+	if want := "2001:4860:4860::8888, 2001:4860:4860::8844"; got.WANIPv6DNS != want {
+		t.Errorf("IPv6 DNS = %q, want %q", got.WANIPv6DNS, want)
+	}
+	if len(got.AllConnections) != 1 {
+		t.Fatalf("connections = %d, want 1", len(got.AllConnections))
+	}
+	if c := got.AllConnections[0]; c.IPv6 != "2001:db8::10" || c.IPv6Prefix != "2001:db8:100::/56" || c.DNS != "8.8.8.8,1.1.1.1" {
+		t.Errorf("connection = %+v", c)
+	}
+}
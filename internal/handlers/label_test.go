@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestManualAssignLabelsDevice(t *testing.T) {
+	h := newTestHandler(t, nil)
+	h.Config.DeviceLabelTemplate = "{name} - {code}"
+	customer := createTestCustomer(t, h, "C001", "0812")
+	device := createTestDevice(t, h, "SN001", "ZTE")
+	if _, err := h.DB.Exec(`UPDATE devices SET template = 'budi@net' WHERE id = ?`, device.ID); err != nil {
+		t.Fatalf("set template: %v", err)
+	}
+
+	body := `{"customerId":` + strconv.FormatInt(customer.ID, 10) + `,"pppoeUsername":"budi@net"}`
+	if rec := serve(h.SyncCustomerToDeviceByPPPoE, http.MethodPost, body, nil); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+
+	got, err := h.DB.GetDevice(device.ID)
+	if err != nil {
+		t.Fatalf("GetDevice: %v", err)
+	}
+	if got.Label != "Customer C001 - C001" {
+		t.Errorf("label = %q, want %q", got.Label, "Customer C001 - C001")
+	}
+}
+
+func TestLabelDeviceWithoutConfig(t *testing.T) {
+	h := newTestHandler(t, nil)
+	device := createTestDevice(t, h, "SN001", "ZTE")
+	h.Config = nil
+	if label := h.labelDevice(device.ID); label != "" {
+		t.Errorf("label = %q, want none without a config", label)
+	}
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"go-acs/internal/models"
+)
+
+func TestSetDeviceLifecycleTransitions(t *testing.T) {
+	h := newTestHandler(t, nil)
+	device := createTestDevice(t, h, "SN-STOCK", "ZTE")
+	vars := map[string]string{"id": fmt.Sprint(device.ID)}
+	move := func(state string) int {
+		return serve(h.SetDeviceLifecycle, http.MethodPut, fmt.Sprintf(`{"state":%q}`, state), vars).Code
+	}
+
+	for _, step := range []struct {
+		state string
+		want  int
+	}{
+		{"deployed", http.StatusBadRequest}, // already deployed
+		{"stock", http.StatusOK},
+		{"retired", http.StatusOK},
+		{"deployed", http.StatusBadRequest}, // retired units go back to stock first
+		{"lost", http.StatusBadRequest},
+		{"stock", http.StatusOK},
+		{"deployed", http.StatusOK},
+	} {
+		if got := move(step.state); got != step.want {
+			t.Fatalf("move to %s = %d, want %d", step.state, got, step.want)
+		}
+	}
+	if got, _ := h.DB.GetDevice(device.ID); got.LifecycleState != models.LifecycleDeployed {
+		t.Fatalf("state = %s, want deployed", got.LifecycleState)
+	}
+
+	vars["id"] = "9999"
+	if got := move("stock"); got != http.StatusNotFound {
+		t.Fatalf("unknown device = %d, want 404", got)
+	}
+}
+
+func TestDashboardStatsExcludeStockDevices(t *testing.T) {
+	h := newTestHandler(t, nil)
+	online := createTestDevice(t, h, "SN-1", "ZTE")
+	createTestDevice(t, h, "SN-2", "ZTE")
+	stock, err := h.DB.CreateDevice(&models.Device{SerialNumber: "SN-3", Manufacturer: "ZTE", ModelName: "ONT", LifecycleState: models.LifecycleStock})
+	if err != nil {
+		t.Fatalf("CreateDevice: %v", err)
+	}
+	retired := createTestDevice(t, h, "SN-4", "ZTE")
+	h.DB.SetDeviceLifecycle(retired.ID, models.LifecycleRetired)
+	h.DB.Exec(`UPDATE devices SET status = 'online' WHERE id IN (?, ?)`, online.ID, stock.ID)
+
+	stats, err := h.DB.GetDashboardStats()
+	if err != nil {
+		t.Fatalf("GetDashboardStats: %v", err)
+	}
+	if stats.TotalDevices != 2 || stats.OnlineDevices != 1 || stats.OfflineDevices != 1 {
+		t.Errorf("health = %d total, %d online, %d offline; want 2, 1, 1",
+			stats.TotalDevices, stats.OnlineDevices, stats.OfflineDevices)
+	}
+	if stats.StockDevices != 1 || stats.RetiredDevices != 1 {
+		t.Errorf("inventory = %d stock, %d retired; want 1 and 1", stats.StockDevices, stats.RetiredDevices)
+	}
+
+	devices, total, err := h.DB.GetDevices("", "", string(models.LifecycleStock), "", 50, 0)
+	if err != nil || total != 1 || len(devices) != 1 || devices[0].ID != stock.ID {
+		t.Fatalf("stock filter = %d devices (total %d, err %v), want SN-3", len(devices), total, err)
+	}
+}
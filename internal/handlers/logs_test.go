@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go-acs/internal/models"
+
+	"github.com/gorilla/mux"
+)
+
+func TestParseLogFilter(t *testing.T) {
+	// Date-only values are days in the ISP's timezone, not the server's
+	jakarta := time.FixedZone("WIB", 7*60*60)
+	r := httptest.NewRequest("GET", "/api/logs?level=warning&category=firmware&from=2026-03-01&to=2026-03-05", nil)
+	filter, err := parseLogFilter(r, jakarta)
+	if err != nil {
+		t.Fatalf("parseLogFilter: %v", err)
+	}
+	if filter.Level != "warning" || filter.Category != "firmware" {
+		t.Fatalf("filter = %+v", filter)
+	}
+	if want := time.Date(2026, 3, 1, 0, 0, 0, 0, jakarta); !filter.From.Equal(want) {
+		t.Fatalf("from = %v, want %v", filter.From, want)
+	}
+	// A date-only "to" covers the whole day
+	if want := time.Date(2026, 3, 5, 23, 59, 59, 0, jakarta); !filter.To.Equal(want) {
+		t.Fatalf("to = %v, want %v", filter.To, want)
+	}
+
+	r = httptest.NewRequest("GET", "/api/logs?from=2026-03-01T10:00:00Z", nil)
+	if filter, err = parseLogFilter(r, jakarta); err != nil || !filter.From.Equal(time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)) || filter.To != nil {
+		t.Fatalf("RFC3339 from: %+v, %v", filter, err)
+	}
+
+	for _, q := range []string{"from=yesterday", "to=2026-13-01"} {
+		if _, err := parseLogFilter(httptest.NewRequest("GET", "/api/logs?"+q, nil), jakarta); err == nil {
+			t.Errorf("%s: expected an error", q)
+		}
+	}
+}
+
+func TestGetDeviceLogsSetsTotalCount(t *testing.T) {
+	h := newTestHandler(t, nil)
+	device := createTestDevice(t, h, "SN001", "ZTE")
+	for i := 0; i < 3; i++ {
+		h.DB.CreateLog(&device.ID, "warning", "firmware", "download failed", "")
+	}
+	h.DB.CreateLog(&device.ID, "info", "wan", "pppoe up", "")
+
+	r := httptest.NewRequest("GET", "/?category=firmware&limit=2", nil)
+	r = mux.SetURLVars(r, map[string]string{"id": fmt.Sprint(device.ID)})
+	rec := httptest.NewRecorder()
+	h.GetDeviceLogs(rec, r)
+	if rec.Code != 200 || rec.Header().Get("X-Total-Count") != "3" {
+		t.Fatalf("status %d, X-Total-Count %q", rec.Code, rec.Header().Get("X-Total-Count"))
+	}
+	var logs []models.Log
+	json.NewDecoder(rec.Body).Decode(&logs)
+	if len(logs) != 2 {
+		t.Fatalf("got %d logs, want the 2 of the page", len(logs))
+	}
+
+	rec = serve(h.GetDeviceLogs, "GET", "", map[string]string{"id": fmt.Sprint(device.ID)})
+	if rec.Header().Get("X-Total-Count") != "4" {
+		t.Fatalf("unfiltered X-Total-Count %q, want 4", rec.Header().Get("X-Total-Count"))
+	}
+}
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"go-acs/internal/models"
+)
+
+func TestBuildManagementParams(t *testing.T) {
+	tests := []struct {
+		name         string
+		manufacturer string
+		tr181        bool
+		acsURL       string
+		vlan         int
+		want         map[string]string
+	}{
+		{"TR-098 ACS URL only", "ZTE", false, "https://acs.example.net:7547", 0,
+			map[string]string{"InternetGatewayDevice.ManagementServer.URL": "https://acs.example.net:7547"}},
+		{"TR-181 URL and VLAN", "ZTE", true, "http://10.0.0.1:7547", 100, map[string]string{
+			"Device.ManagementServer.URL":              "http://10.0.0.1:7547",
+			"Device.Ethernet.VLANTermination.2.VLANID": "100",
+		}},
+		{"Huawei VLAN", "Huawei Technologies", false, "", 200,
+			map[string]string{"InternetGatewayDevice.WANDevice.1.WANConnectionDevice.2.WANIPConnection.1.X_HW_VLAN": "200"}},
+		{"ZTE VLAN", "ZTE", false, "", 200,
+			map[string]string{"InternetGatewayDevice.WANDevice.1.WANConnectionDevice.2.WANIPConnection.1.X_ZTE-COM_VLANID": "200"}},
+		{"FiberHome VLAN", "FIBERHOME", false, "", 200,
+			map[string]string{"InternetGatewayDevice.WANDevice.1.WANConnectionDevice.2.WANIPConnection.1.X_FH_VLAN": "200"}},
+		{"Nokia VLAN", "Nokia", false, "", 200,
+			map[string]string{"InternetGatewayDevice.WANDevice.1.WANConnectionDevice.2.WANIPConnection.1.X_ALU_VLANID": "200"}},
+		{"generic VLAN", "Acme", false, "", 200,
+			map[string]string{"InternetGatewayDevice.WANDevice.1.WANConnectionDevice.2.WANIPConnection.1.VLANID": "200"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := buildManagementParams(&models.Device{Manufacturer: tt.manufacturer}, tt.tr181, tt.acsURL, tt.vlan, 2)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("params = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateACSURL(t *testing.T) {
+	for raw, ok := range map[string]bool{
+		"https://acs.example.net:7547/": true,
+		"http://10.0.0.1:7547":          true,
+		"ftp://acs.example.net":         false,
+		"acs.example.net:7547":          false,
+		"https://":                      false,
+	} {
+		if err := validateACSURL(raw); (err == nil) != ok {
+			t.Errorf("validateACSURL(%q) = %v, want ok %v", raw, err, ok)
+		}
+	}
+}
+
+func TestSetDeviceManagementRequiresConfirmation(t *testing.T) {
+	h := newTestHandler(t, nil)
+	device := createTestDevice(t, h, "ZTEG0001", "ZTE")
+	vars := map[string]string{"id": fmt.Sprint(device.ID)}
+
+	for _, body := range []string{
+		`{"acsUrl":"https://acs2.example.net:7547"}`,
+		`{"acsUrl":"https://acs2.example.net:7547","confirm":"ZTEG0002"}`,
+		`{"acsUrl":"https://acs2.example.net:7547","confirm":"zteg0001"}`,
+	} {
+		if rec := serve(h.SetDeviceManagement, "PUT", body, vars); rec.Code != 428 {
+			t.Fatalf("%s: status %d, want 428", body, rec.Code)
+		}
+	}
+	if tasks, _ := h.DB.GetPendingTasks(device.ID); len(tasks) != 0 {
+		t.Fatalf("%d tasks queued without confirmation", len(tasks))
+	}
+
+	rec := serve(h.SetDeviceManagement, "PUT", `{"acsUrl":"https://acs2.example.net:7547","managementVlan":100,"confirm":"ZTEG0001"}`, vars)
+	if rec.Code != 200 {
+		t.Fatalf("confirmed update = %d %s", rec.Code, rec.Body)
+	}
+	tasks, _ := h.DB.GetPendingTasks(device.ID)
+	if len(tasks) != 1 || tasks[0].Type != models.TaskSetParameterValues {
+		t.Fatalf("tasks = %+v, want one setParameterValues", tasks)
+	}
+	var params map[string]string
+	json.Unmarshal(tasks[0].Parameters, &params)
+	if params["InternetGatewayDevice.ManagementServer.URL"] != "https://acs2.example.net:7547" ||
+		params["InternetGatewayDevice.WANDevice.1.WANConnectionDevice.1.WANIPConnection.1.X_ZTE-COM_VLANID"] != "100" {
+		t.Fatalf("params = %v", params)
+	}
+}
+
+func TestSetDeviceManagementValidatesBeforeConfirming(t *testing.T) {
+	h := newTestHandler(t, nil)
+	device := createTestDevice(t, h, "ZTEG0001", "ZTE")
+	vars := map[string]string{"id": fmt.Sprint(device.ID)}
+
+	for _, body := range []string{
+		`{"confirm":"ZTEG0001"}`,
+		`{"acsUrl":"acs.example.net","confirm":"ZTEG0001"}`,
+		`{"managementVlan":5000,"confirm":"ZTEG0001"}`,
+	} {
+		if rec := serve(h.SetDeviceManagement, "PUT", body, vars); rec.Code != 400 {
+			t.Fatalf("%s: status %d, want 400", body, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"go-acs/internal/mikrotik"
+)
+
+// closedPort returns a local port nothing listens on
+func closedPort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+	return port
+}
+
+func TestUnreachableMikrotikSettingsKeepTheWorkingClient(t *testing.T) {
+	h := newTestHandler(t, nil)
+	h.Config.TestMode = false
+	h.Config.MikrotikHost, h.Config.MikrotikPort, h.Config.MikrotikUser = "10.0.0.1", 8728, "admin"
+	working := mikrotik.New(h.Config)
+	h.Mikrotik = working
+
+	port := closedPort(t)
+	body := fmt.Sprintf(`{"mikrotik_host":"127.0.0.1","mikrotik_port":"%d","mikrotik_user":"ops","tax_percent":"11"}`, port)
+	rec := serve(h.SaveSettings, http.MethodPost, body, nil)
+	var resp struct {
+		Success bool   `json:"success"`
+		Warning string `json:"warning"`
+	}
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &resp) != nil {
+		t.Fatalf("save settings = %d %s", rec.Code, rec.Body)
+	}
+	if !resp.Success || !strings.Contains(resp.Warning, fmt.Sprintf("127.0.0.1:%d", port)) {
+		t.Errorf("response = %+v, want success with a warning naming the new router", resp)
+	}
+
+	if h.Mikrotik != working {
+		t.Error("the working MikroTik client was replaced")
+	}
+	if h.Config.MikrotikHost != "10.0.0.1" || h.Config.MikrotikPort != 8728 || h.Config.MikrotikUser != "admin" {
+		t.Errorf("config = %s:%d as %s, want the previous connection", h.Config.MikrotikHost, h.Config.MikrotikPort, h.Config.MikrotikUser)
+	}
+	for _, k := range mikrotikSettingKeys {
+		if v, _ := h.DB.GetSetting(k); v != "" {
+			t.Errorf("%s saved as %q", k, v)
+		}
+	}
+
+	// The rest of the request still applies, and the failure is logged
+	if v, _ := h.DB.GetSetting("tax_percent"); v != "11" || h.Config.TaxPercent != 11 {
+		t.Errorf("tax_percent = %q (config %v), want 11", v, h.Config.TaxPercent)
+	}
+	var logged int
+	h.DB.QueryRow(`SELECT COUNT(*) FROM logs WHERE level = 'warning' AND message LIKE 'MikroTik settings not saved%'`).Scan(&logged)
+	if logged != 1 {
+		t.Errorf("%d warnings logged, want 1", logged)
+	}
+}
+
+func TestMikrotikSettingsInTestModeAreNotVerified(t *testing.T) {
+	h := newTestHandler(t, nil)
+	h.Mikrotik = mikrotik.New(h.Config)
+
+	body := fmt.Sprintf(`{"mikrotik_host":"127.0.0.1","mikrotik_port":"%d"}`, closedPort(t))
+	rec := serve(h.SaveSettings, http.MethodPost, body, nil)
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "warning") {
+		t.Fatalf("save settings = %d %s, want success without a warning", rec.Code, rec.Body)
+	}
+	if v, _ := h.DB.GetSetting("mikrotik_host"); v != "127.0.0.1" || h.Config.MikrotikHost != "127.0.0.1" {
+		t.Errorf("mikrotik_host = %q (config %q), want it saved", v, h.Config.MikrotikHost)
+	}
+}
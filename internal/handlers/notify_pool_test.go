@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go-acs/internal/config"
+	"go-acs/internal/notification/whatsapp"
+)
+
+// slowWhatsApp is a WhatsApp provider that records how many sends it
+// handled at once
+type slowWhatsApp struct {
+	cur, max, received atomic.Int32
+}
+
+func (f *slowWhatsApp) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n := f.cur.Add(1)
+	defer f.cur.Add(-1)
+	for {
+		m := f.max.Load()
+		if n <= m || f.max.CompareAndSwap(m, n) {
+			break
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	f.received.Add(1)
+}
+
+func TestInvoiceRunSendsWithinConcurrencyCap(t *testing.T) {
+	cfg := config.Load()
+	cfg.NotifyWAConcurrency = 2
+	h := newTestHandler(t, cfg)
+	provider := &slowWhatsApp{}
+	srv := httptest.NewServer(provider)
+	t.Cleanup(srv.Close)
+
+	waCfg := *h.Config
+	waCfg.TestMode = false
+	waCfg.WAApiKey = "key"
+	waCfg.WAProviderURL = srv.URL
+	h.WA = whatsapp.New(&waCfg)
+
+	const customers = 12
+	for i := 1; i <= customers; i++ {
+		createTestCustomer(t, h, fmt.Sprintf("C%03d", i), fmt.Sprintf("0812%04d", i))
+	}
+	if _, err := h.GenerateInvoicesInternal(); err != nil {
+		t.Fatalf("GenerateInvoicesInternal: %v", err)
+	}
+	h.waPool.Wait()
+
+	if got := provider.received.Load(); got != customers {
+		t.Fatalf("provider received %d messages, want %d", got, customers)
+	}
+	if got := provider.max.Load(); got > 2 {
+		t.Errorf("%d WhatsApp sends ran at once, want at most 2", got)
+	}
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"go-acs/internal/mikrotik"
+)
+
+func onboardBody(serial string) string {
+	return `{"customer":{"name":"Budi Santoso","packageId":1},"pppoeUsername":"budi-pppoe","pppoePassword":"secret",` +
+		`"serialNumber":"` + serial + `","ssid":"Budi","wifiPassword":"wifipass123"}`
+}
+
+func newOnboardHandler(t *testing.T) *Handler {
+	t.Helper()
+	h := newTestHandler(t, nil)
+	h.Mikrotik = mikrotik.New(h.Config)
+	if _, err := h.DB.Exec(`INSERT INTO packages (id, name, price, is_active) VALUES (1, 'Paket 10M', 100000, 1)`); err != nil {
+		t.Fatalf("insert package: %v", err)
+	}
+	return h
+}
+
+func TestOnboardStoresPPPoEUsername(t *testing.T) {
+	h := newOnboardHandler(t)
+	device := createTestDevice(t, h, "SN001", "ZTE")
+
+	rec := serve(h.OnboardCustomer, "POST", onboardBody("SN001"), nil)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("onboard = %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var username string
+	h.DB.QueryRow(`SELECT username FROM customers`).Scan(&username)
+	if username != "budi-pppoe" {
+		t.Fatalf("customer username = %q, want the PPPoE username", username)
+	}
+	var tasks, priority int
+	h.DB.QueryRow(`SELECT COUNT(*), COALESCE(MAX(priority), 0) FROM tasks WHERE device_id = ?`, device.ID).Scan(&tasks, &priority)
+	if tasks != 1 || priority == 0 {
+		t.Fatalf("wifi task count %d priority %d, want one prioritised task", tasks, priority)
+	}
+}
+
+func TestOnboardRejectsMismatchedUsername(t *testing.T) {
+	h := newOnboardHandler(t)
+	body := `{"customer":{"name":"Budi","username":"budi"},"pppoeUsername":"budi-pppoe","pppoePassword":"secret"}`
+	if rec := serve(h.OnboardCustomer, "POST", body, nil); rec.Code != http.StatusBadRequest {
+		t.Fatalf("mismatched username = %d, want 400", rec.Code)
+	}
+}
+
+func TestOnboardRollsBackWhenInvoiceFails(t *testing.T) {
+	h := newOnboardHandler(t)
+	device := createTestDevice(t, h, "SN001", "ZTE")
+	if _, err := h.DB.Exec(`CREATE TRIGGER fail_invoice BEFORE INSERT ON invoices BEGIN SELECT RAISE(ABORT, 'invoice failed'); END`); err != nil {
+		t.Fatalf("create trigger: %v", err)
+	}
+
+	rec := serve(h.OnboardCustomer, "POST", onboardBody("SN001"), nil)
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("onboard = %d, want 500: %s", rec.Code, rec.Body.String())
+	}
+
+	var resp struct {
+		Steps []map[string]interface{} `json:"steps"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	statuses := make(map[string]interface{})
+	for _, s := range resp.Steps {
+		statuses[s["step"].(string)] = s["status"]
+	}
+	if statuses["mikrotik"] != "rolled_back" {
+		t.Errorf("mikrotik step = %v, want rolled_back", statuses["mikrotik"])
+	}
+
+	var customers, tasks int
+	h.DB.QueryRow(`SELECT COUNT(*) FROM customers`).Scan(&customers)
+	h.DB.QueryRow(`SELECT COUNT(*) FROM tasks`).Scan(&tasks)
+	if customers != 0 || tasks != 0 {
+		t.Fatalf("after rollback: %d customers, %d tasks, want none", customers, tasks)
+	}
+	d, err := h.DB.GetDevice(device.ID)
+	if err != nil {
+		t.Fatalf("GetDevice: %v", err)
+	}
+	if d.CustomerID != nil && *d.CustomerID > 0 {
+		t.Fatalf("device still assigned to customer %d", *d.CustomerID)
+	}
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go-acs/internal/models"
+	"go-acs/internal/notification"
+	"go-acs/internal/notification/whatsapp"
+)
+
+// fakeWhatsApp is a WhatsApp provider that can be taken down
+type fakeWhatsApp struct {
+	down     atomic.Bool
+	received atomic.Int32
+}
+
+func (f *fakeWhatsApp) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if f.down.Load() {
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	f.received.Add(1)
+}
+
+func newOutboxHandler(t *testing.T) (*Handler, *fakeWhatsApp) {
+	t.Helper()
+	h := newTestHandler(t, nil)
+	provider := &fakeWhatsApp{}
+	srv := httptest.NewServer(provider)
+	t.Cleanup(srv.Close)
+
+	waCfg := *h.Config
+	waCfg.TestMode = false
+	waCfg.WAApiKey = "key"
+	waCfg.WAProviderURL = srv.URL
+	h.WA = whatsapp.New(&waCfg)
+	h.breakers[channelWhatsApp] = notification.NewBreaker(2, 50*time.Millisecond)
+	return h, provider
+}
+
+func outboxCount(t *testing.T, h *Handler, status string) int64 {
+	t.Helper()
+	counts, err := h.DB.GetOutboxCounts()
+	if err != nil {
+		t.Fatalf("GetOutboxCounts: %v", err)
+	}
+	return counts[channelWhatsApp][status]
+}
+
+func TestOutboxHoldsMessagesDuringOutageAndFlushesOnRecovery(t *testing.T) {
+	h, provider := newOutboxHandler(t)
+	provider.down.Store(true)
+
+	for _, phone := range []string{"0811", "0812", "0813"} {
+		h.queueNotification(channelWhatsApp, phone, "", "hello")
+		h.waPool.Wait()
+	}
+	if state := h.breakers[channelWhatsApp].State(); state != notification.BreakerOpen {
+		t.Fatalf("breaker state after outage = %s, want open", state)
+	}
+	if got := outboxCount(t, h, models.OutboxPending); got != 3 {
+		t.Fatalf("pending during outage = %d, want 3", got)
+	}
+	if provider.received.Load() != 0 {
+		t.Fatalf("provider received messages while down")
+	}
+
+	// A flush while the cooldown runs sends nothing
+	h.DB.Exec(`UPDATE notification_outbox SET next_attempt_at = ?`, time.Now().Add(-time.Minute))
+	h.FlushNotificationOutbox()
+	h.waPool.Wait()
+	if provider.received.Load() != 0 {
+		t.Fatalf("flush sent during cooldown")
+	}
+
+	provider.down.Store(false)
+	time.Sleep(60 * time.Millisecond)
+	h.FlushNotificationOutbox()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for outboxCount(t, h, models.OutboxSent) < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("sent after recovery = %d, want 3", outboxCount(t, h, models.OutboxSent))
+		}
+		time.Sleep(20 * time.Millisecond)
+		h.waPool.Wait()
+	}
+	if state := h.breakers[channelWhatsApp].State(); state != notification.BreakerClosed {
+		t.Fatalf("breaker state after recovery = %s, want closed", state)
+	}
+	if got := provider.received.Load(); got != 3 {
+		t.Fatalf("provider received %d messages, want 3", got)
+	}
+}
+
+func TestOutboxProbeReleasedWhenClaimFails(t *testing.T) {
+	h, provider := newOutboxHandler(t)
+	provider.down.Store(true)
+	h.queueNotification(channelWhatsApp, "0811", "", "one")
+	h.waPool.Wait()
+	h.queueNotification(channelWhatsApp, "0812", "", "two")
+	h.waPool.Wait()
+
+	breaker := h.breakers[channelWhatsApp]
+	time.Sleep(60 * time.Millisecond)
+	if !breaker.Allow() {
+		t.Fatal("breaker did not allow a probe after the cooldown")
+	}
+	// The probe's message was already handled elsewhere
+	h.DB.Exec(`UPDATE notification_outbox SET status = ?`, models.OutboxSent)
+	h.deliverNotification(&models.OutboxMessage{ID: 1, Channel: channelWhatsApp}, true)
+
+	if state := breaker.State(); state != notification.BreakerOpen {
+		t.Fatalf("breaker state after lost probe = %s, want open", state)
+	}
+	if !breaker.Allow() {
+		t.Fatal("breaker stuck: no new probe allowed")
+	}
+}
+
+func TestCredentialsBypassOutbox(t *testing.T) {
+	h, provider := newOutboxHandler(t)
+	customer := createTestCustomer(t, h, "C001", "08123456789")
+
+	channels := h.sendPortalPassword(customer, "s3cret-pass", "")
+	h.waPool.Wait()
+
+	if len(channels) != 1 || channels[0] != "whatsapp" {
+		t.Fatalf("sent via %v, want [whatsapp]", channels)
+	}
+	if provider.received.Load() != 1 {
+		t.Fatalf("provider received %d messages, want 1", provider.received.Load())
+	}
+	var stored int
+	h.DB.QueryRow(`SELECT COUNT(*) FROM notification_outbox`).Scan(&stored)
+	if stored != 0 {
+		t.Fatalf("outbox stored %d credential messages", stored)
+	}
+}
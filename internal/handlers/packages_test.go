@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestPackageSpeedsMustBePositive(t *testing.T) {
+	h := newTestHandler(t, nil)
+
+	for _, body := range []string{
+		`{"name":"Lite","downloadSpeed":0,"uploadSpeed":5,"price":100000}`,
+		`{"name":"Lite","downloadSpeed":10,"uploadSpeed":-1,"price":100000}`,
+		`{"name":"Lite","price":100000}`,
+	} {
+		rec := serve(h.CreatePackage, http.MethodPost, body, nil)
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "greater than 0 Mbps") {
+			t.Errorf("create %s = %d %s, want 400", body, rec.Code, rec.Body)
+		}
+	}
+	var count int
+	h.DB.QueryRow(`SELECT COUNT(*) FROM packages`).Scan(&count)
+	if count != 0 {
+		t.Fatalf("%d packages created with invalid speeds", count)
+	}
+
+	rec := serve(h.CreatePackage, http.MethodPost, `{"name":"Home","downloadSpeed":20,"uploadSpeed":5,"price":150000}`, nil)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create valid package = %d: %s", rec.Code, rec.Body)
+	}
+	var id int64
+	h.DB.QueryRow(`SELECT id FROM packages WHERE name = 'Home'`).Scan(&id)
+
+	rec = serve(h.UpdatePackage, http.MethodPut, `{"name":"Home","downloadSpeed":0,"uploadSpeed":5,"price":150000}`,
+		map[string]string{"id": fmt.Sprint(id)})
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("update with zero download = %d, want 400", rec.Code)
+	}
+	pkg, err := h.DB.GetPackage(id)
+	if err != nil {
+		t.Fatalf("GetPackage: %v", err)
+	}
+	if pkg.DownloadSpeed != 20 || pkg.UploadSpeed != 5 {
+		t.Fatalf("package speeds changed to %d/%d by a rejected update", pkg.UploadSpeed, pkg.DownloadSpeed)
+	}
+}
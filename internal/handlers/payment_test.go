@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go-acs/internal/models"
+	"go-acs/internal/payment"
+)
+
+// recordingGateway is a fakeGateway that keeps the transactions it was asked
+// to create
+type recordingGateway struct {
+	fakeGateway
+	requests []payment.TransactionRequest
+}
+
+func (g *recordingGateway) CreateTransaction(req payment.TransactionRequest) (*payment.TransactionResponse, error) {
+	g.requests = append(g.requests, req)
+	return &payment.TransactionResponse{}, nil
+}
+
+func payOnline(h *Handler, invoiceID int64) *httptest.ResponseRecorder {
+	return serve(h.CreatePaymentTransaction, http.MethodPost, "", map[string]string{"id": fmt.Sprint(invoiceID)})
+}
+
+func TestOnlinePaymentMinimumAmount(t *testing.T) {
+	h := newTestHandler(t, nil)
+	gateway := &recordingGateway{}
+	h.Payment = gateway
+	h.Config.OnlinePaymentMinAmount = 150000
+	customer := createTestCustomer(t, h, "C001", "")
+
+	invoice := func(no string, total float64) int64 {
+		id := createTestInvoice(t, h, customer.ID, no, time.Now().AddDate(0, 0, 7), models.InvoicePending, 0)
+		if _, err := h.DB.Exec(`UPDATE invoices SET subtotal = ?, total = ? WHERE id = ?`, total, total, id); err != nil {
+			t.Fatalf("set invoice total: %v", err)
+		}
+		return id
+	}
+	below := invoice("INV-BELOW", 149999)
+	equal := invoice("INV-EQUAL", 150000)
+	above := invoice("INV-ABOVE", 200000)
+
+	rec := payOnline(h, below)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("below the minimum = %d, want 422", rec.Code)
+	}
+	if msg := rec.Body.String(); !strings.Contains(msg, h.formatMoney(150000)) || !strings.Contains(msg, "cash or bank transfer") {
+		t.Errorf("below the minimum message = %s", msg)
+	}
+	if len(gateway.requests) != 0 {
+		t.Fatalf("gateway called %d times for an invoice below the minimum", len(gateway.requests))
+	}
+
+	for _, tc := range []struct {
+		id     int64
+		amount int64
+	}{{equal, 150000}, {above, 200000}} {
+		if rec := payOnline(h, tc.id); rec.Code != http.StatusOK {
+			t.Fatalf("invoice of %d = %d %s, want 200", tc.amount, rec.Code, rec.Body)
+		}
+		if got := gateway.requests[len(gateway.requests)-1].Amount; got != tc.amount {
+			t.Errorf("transaction amount = %d, want %d", got, tc.amount)
+		}
+	}
+
+	// The portal is told the minimum so it can hide the option
+	rec = serveAsCustomer(h, customer.ID, h.GetPortalInvoices, http.MethodGet, "", nil)
+	var resp struct {
+		OnlinePaymentMinAmount float64 `json:"onlinePaymentMinAmount"`
+	}
+	if json.Unmarshal(rec.Body.Bytes(), &resp) != nil || resp.OnlinePaymentMinAmount != 150000 {
+		t.Errorf("portal invoices = %d %s, want onlinePaymentMinAmount 150000", rec.Code, rec.Body)
+	}
+}
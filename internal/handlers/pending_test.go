@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"go-acs/internal/models"
+)
+
+func TestPendingSummaryOfMixedTasks(t *testing.T) {
+	h := newTestHandler(t, nil)
+	device := createTestDevice(t, h, "SN-PENDING", "ZTE")
+	const ssid = "InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.SSID"
+	const wifiKey = "InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.PreSharedKey.1.KeyPassphrase"
+	const pppUser = "InternetGatewayDevice.WANDevice.1.WANConnectionDevice.1.WANPPPConnection.1.Username"
+	const informInterval = "InternetGatewayDevice.ManagementServer.PeriodicInformInterval"
+
+	queue := func(taskType models.TaskType, params string) *models.DeviceTask {
+		t.Helper()
+		task, err := h.DB.CreateTask(&models.DeviceTask{DeviceID: device.ID, Type: taskType, Parameters: json.RawMessage(params)})
+		if err != nil {
+			t.Fatalf("CreateTask: %v", err)
+		}
+		return task
+	}
+	queue(models.TaskSetParameterValues, fmt.Sprintf(`{%q: "Rumah", %q: "rahasia123"}`, ssid, wifiKey))
+	queue(models.TaskDownload, `{"url": "http://fw.example/f670l-v2.bin", "fileType": "1 Firmware Upgrade Image"}`)
+	queue(models.TaskSetParameterValues, fmt.Sprintf(`{%q: "budi@isp", %q: 300}`, pppUser, informInterval))
+	latest := queue(models.TaskSetParameterValues, fmt.Sprintf(`{%q: "Rumah Budi"}`, ssid))
+	queue(models.TaskGetParameterValues, `["InternetGatewayDevice.DeviceInfo.", "InternetGatewayDevice.WANDevice.1."]`)
+	queue(models.TaskReboot, `{}`)
+	done := queue(models.TaskFactoryReset, `{}`)
+	h.DB.UpdateTaskStatus(done.ID, models.TaskCompleted, nil, "")
+
+	rec := serve(h.GetDevicePendingSummary, http.MethodGet, "", map[string]string{"id": fmt.Sprint(device.ID)})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("pending = %d: %s", rec.Code, rec.Body)
+	}
+	var summary models.PendingSummary
+	if err := json.Unmarshal(rec.Body.Bytes(), &summary); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+
+	if summary.TaskCount != 6 || !summary.Reboot || summary.FactoryReset || summary.Refresh {
+		t.Errorf("summary = %d tasks, reboot %v, factory reset %v, refresh %v; want 6 pending with only a reboot",
+			summary.TaskCount, summary.Reboot, summary.FactoryReset, summary.Refresh)
+	}
+	if !reflect.DeepEqual(summary.Firmware, []string{"http://fw.example/f670l-v2.bin"}) {
+		t.Errorf("firmware = %v", summary.Firmware)
+	}
+	if len(summary.ReadPaths) != 2 {
+		t.Errorf("read paths = %v, want 2", summary.ReadPaths)
+	}
+	wifi := summary.Changes["wifi"]
+	if len(wifi) != 2 || wifi[1].Path != ssid || wifi[1].Value != "Rumah Budi" || wifi[1].TaskID != latest.ID {
+		t.Errorf("wifi changes = %+v, want the latest SSID write", wifi)
+	}
+
+	want := []string{
+		"Firmware upgrade from http://fw.example/f670l-v2.bin",
+		"[management] Set " + informInterval + " = 300",
+		"[wan] Set " + pppUser + " = budi@isp",
+		"[wifi] Set " + wifiKey + " = ********",
+		"[wifi] Set " + ssid + " = Rumah Budi",
+		"Read 2 parameter path(s)",
+		"Reboot",
+	}
+	if !reflect.DeepEqual(summary.Lines, want) {
+		t.Errorf("lines =\n%q\nwant\n%q", summary.Lines, want)
+	}
+}
+
+func TestPendingSummaryOfIdleDevice(t *testing.T) {
+	h := newTestHandler(t, nil)
+	device := createTestDevice(t, h, "SN-IDLE", "ZTE")
+
+	rec := serve(h.GetDevicePendingSummary, http.MethodGet, "", map[string]string{"id": fmt.Sprint(device.ID)})
+	var summary models.PendingSummary
+	json.Unmarshal(rec.Body.Bytes(), &summary)
+	if rec.Code != http.StatusOK || summary.TaskCount != 0 || len(summary.Lines) != 0 {
+		t.Errorf("idle device = %d %+v, want an empty summary", rec.Code, summary)
+	}
+	if rec := serve(h.GetDevicePendingSummary, http.MethodGet, "", map[string]string{"id": "999"}); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown device = %d, want 404", rec.Code)
+	}
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"go-acs/internal/models"
+)
+
+func TestGetDevicePONClassifiesSignal(t *testing.T) {
+	h := newTestHandler(t, nil)
+	h.Config.RXExcellentDBm, h.Config.RXGoodDBm, h.Config.RXWarningDBm, h.Config.RXOverloadDBm = -20, -25, -27, -8
+	device := createTestDevice(t, h, "SN-PON", "ZTE")
+
+	quality := func() string {
+		t.Helper()
+		rec := serve(h.GetDevicePON, http.MethodGet, "", map[string]string{"id": fmt.Sprint(device.ID)})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+		}
+		var pon models.PONStats
+		json.Unmarshal(rec.Body.Bytes(), &pon)
+		return pon.SignalQuality
+	}
+
+	// The fallback reading shown before the device reports one is not classified
+	if got := quality(); got != models.SignalUnknown {
+		t.Fatalf("unmeasured quality = %q, want unknown", got)
+	}
+
+	h.DB.Exec(`UPDATE devices SET rx_power = -26.2 WHERE id = ?`, device.ID)
+	if got := quality(); got != models.SignalWarning {
+		t.Fatalf("quality at -26.2 dBm = %q, want warning", got)
+	}
+
+	// Thresholds changed from the settings page apply immediately
+	h.Config.RXWarningDBm = -28
+	h.Config.RXGoodDBm = -26.5
+	if got := quality(); got != models.SignalGood {
+		t.Fatalf("quality at -26.2 dBm with relaxed bands = %q, want good", got)
+	}
+}
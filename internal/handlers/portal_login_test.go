@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// setTestPortalPassword stores a bcrypt hashed portal password for a customer
+func setTestPortalPassword(t *testing.T, h *Handler, customerID int64, password string) {
+	t.Helper()
+	hashed, err := hashPassword(password)
+	if err != nil {
+		t.Fatalf("hashPassword: %v", err)
+	}
+	if err := h.DB.SetCustomerPassword(customerID, hashed); err != nil {
+		t.Fatalf("SetCustomerPassword: %v", err)
+	}
+}
+
+func portalLogin(h *Handler, username, password string) int {
+	body := fmt.Sprintf(`{"username": %q, "password": %q}`, username, password)
+	return serve(h.CustomerLogin, http.MethodPost, body, nil).Code
+}
+
+func TestNormalizePhone(t *testing.T) {
+	for phone, want := range map[string]string{
+		"+62 812-3456-7890":  "6281234567890",
+		"0812 3456 7890":     "6281234567890",
+		"812.3456.7890":      "6281234567890",
+		"6281234567890":      "6281234567890",
+		"(0812) 3456-7890":   "6281234567890",
+		"0065 9123 4567":     "6591234567",
+		"  081234567890  ":   "6281234567890",
+		"C-0001":             "",
+		"0812+3456":          "",
+		"":                   "",
+		"budi@example.local": "",
+	} {
+		if got := normalizePhone(phone, "62"); got != want {
+			t.Errorf("normalizePhone(%q) = %q, want %q", phone, got, want)
+		}
+	}
+}
+
+func TestPortalLoginByPhone(t *testing.T) {
+	h := newTestHandler(t, nil)
+	h.Config.PortalPhoneLogin = true
+	h.Config.PhoneCountryCode = "62"
+	customer := createTestCustomer(t, h, "C-0001", "0812-3456-7890")
+	setTestPortalPassword(t, h, customer.ID, "rahasia")
+
+	for _, phone := range []string{"081234567890", "+62 812 3456 7890", "6281234567890", "812-3456-7890"} {
+		if code := portalLogin(h, phone, "rahasia"); code != http.StatusOK {
+			t.Errorf("login as %q = %d, want 200", phone, code)
+		}
+	}
+	if code := portalLogin(h, "081234567890", "salah"); code != http.StatusUnauthorized {
+		t.Errorf("wrong password = %d, want 401", code)
+	}
+	// Same last eight digits, different number
+	if code := portalLogin(h, "081134567890", "rahasia"); code != http.StatusUnauthorized {
+		t.Errorf("other number = %d, want 401", code)
+	}
+
+	h.Config.PortalPhoneLogin = false
+	if code := portalLogin(h, "081234567890", "rahasia"); code != http.StatusUnauthorized {
+		t.Errorf("phone login disabled = %d, want 401", code)
+	}
+}
+
+func TestPortalLoginBySharedPhonePicksThePasswordOwner(t *testing.T) {
+	h := newTestHandler(t, nil)
+	h.Config.PortalPhoneLogin = true
+	h.Config.PhoneCountryCode = "62"
+	first := createTestCustomer(t, h, "C-0001", "081234567890")
+	second := createTestCustomer(t, h, "C-0002", "+6281234567890")
+	setTestPortalPassword(t, h, first.ID, "rumah")
+	setTestPortalPassword(t, h, second.ID, "toko")
+
+	for password, want := range map[string]string{"rumah": "C-0001", "toko": "C-0002"} {
+		body := fmt.Sprintf(`{"username": "0812 3456 7890", "password": %q}`, password)
+		rec := serve(h.CustomerLogin, http.MethodPost, body, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("login with %q = %d, want 200", password, rec.Code)
+		}
+		var resp struct {
+			Customer struct {
+				CustomerCode string `json:"customerCode"`
+			} `json:"customer"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		if resp.Customer.CustomerCode != want {
+			t.Errorf("login with %q signed in %s, want %s", password, resp.Customer.CustomerCode, want)
+		}
+	}
+}
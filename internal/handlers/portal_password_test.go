@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"go-acs/internal/models"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// storedPortalPassword reads the portal password column as stored
+func storedPortalPassword(t *testing.T, h *Handler, customerID int64) string {
+	t.Helper()
+	var password string
+	if err := h.DB.QueryRow(`SELECT password FROM customers WHERE id = ?`, customerID).Scan(&password); err != nil {
+		t.Fatalf("read password: %v", err)
+	}
+	return password
+}
+
+func TestPortalLoginWithPasswordFromCreateCustomer(t *testing.T) {
+	h := newTestHandler(t, nil)
+	res, err := h.DB.Exec(`INSERT INTO packages (name, price, is_active) VALUES ('Paket 10M', 100000, 1)`)
+	if err != nil {
+		t.Fatalf("insert package: %v", err)
+	}
+	packageID, _ := res.LastInsertId()
+
+	rec := serve(h.CreateCustomer, http.MethodPost, fmt.Sprintf(
+		`{"name": "Budi Santoso", "phone": "0812", "packageId": %d, "portalUsername": "budi", "portalPassword": "rahasia123"}`, packageID), nil)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create customer = %d: %s", rec.Code, rec.Body)
+	}
+	var created models.Customer
+	json.Unmarshal(rec.Body.Bytes(), &created)
+
+	if stored := storedPortalPassword(t, h, created.ID); bcrypt.CompareHashAndPassword([]byte(stored), []byte("rahasia123")) != nil {
+		t.Fatalf("stored password is not a bcrypt hash of the original")
+	}
+
+	if code := portalLogin(h, "budi", "rahasia123"); code != http.StatusOK {
+		t.Errorf("login with the original password = %d, want 200", code)
+	}
+	if code := portalLogin(h, "budi", "rahasia"); code != http.StatusUnauthorized {
+		t.Errorf("login with a wrong password = %d, want 401", code)
+	}
+	if code := portalLogin(h, "budi", ""); code == http.StatusOK {
+		t.Errorf("login with an empty password succeeded")
+	}
+}
+
+func TestPortalLoginRehashesLegacyPlaintextPassword(t *testing.T) {
+	h := newTestHandler(t, nil)
+	customer := createTestCustomer(t, h, "C-0001", "")
+	h.DB.Exec(`UPDATE customers SET username = 'legacy', password = 'lama123' WHERE id = ?`, customer.ID)
+
+	if code := portalLogin(h, "legacy", "salah"); code != http.StatusUnauthorized {
+		t.Fatalf("wrong password = %d, want 401", code)
+	}
+	if storedPortalPassword(t, h, customer.ID) != "lama123" {
+		t.Fatalf("failed login changed the stored password")
+	}
+
+	if code := portalLogin(h, "legacy", "lama123"); code != http.StatusOK {
+		t.Fatalf("login with the legacy password = %d, want 200", code)
+	}
+	stored := storedPortalPassword(t, h, customer.ID)
+	if !strings.HasPrefix(stored, "$2") || bcrypt.CompareHashAndPassword([]byte(stored), []byte("lama123")) != nil {
+		t.Fatalf("legacy password was not rehashed, stored %q", stored)
+	}
+
+	// The rehashed password keeps working, and its hash is not a valid password
+	if code := portalLogin(h, "legacy", "lama123"); code != http.StatusOK {
+		t.Errorf("login after rehash = %d, want 200", code)
+	}
+	if code := portalLogin(h, "legacy", stored); code != http.StatusUnauthorized {
+		t.Errorf("login with the hash itself = %d, want 401", code)
+	}
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResetCodeFailuresLimitedPerCustomer(t *testing.T) {
+	h := newTestHandler(t, nil)
+	customer := createTestCustomer(t, h, "C001", "081234567890")
+
+	issue := func(code string) {
+		h.resetMu.Lock()
+		h.resetCodes[customer.ID] = &portalResetCode{Code: code, Channel: "whatsapp", ExpiresAt: time.Now().Add(resetCodeTTL), SentAt: time.Now()}
+		h.resetMu.Unlock()
+	}
+	submit := func(code string) *http.Response {
+		body := `{"identifier":"081234567890","code":"` + code + `","newPassword":"secret123"}`
+		return serve(h.ResetPortalPasswordWithCode, http.MethodPost, body, nil).Result()
+	}
+
+	// A fresh code per request must not reset the allowance
+	for i := 0; i < resetMaxFailures; i++ {
+		issue("123456")
+		if res := submit("000000"); res.StatusCode != http.StatusBadRequest {
+			t.Fatalf("attempt %d: status = %d, want %d", i+1, res.StatusCode, http.StatusBadRequest)
+		}
+	}
+	issue("123456")
+	if res := submit("123456"); res.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("correct code after lockout: status = %d, want %d", res.StatusCode, http.StatusTooManyRequests)
+	}
+
+	// Once the window passes the right code works again and clears the count
+	h.resetMu.Lock()
+	h.resetFails[customer.ID].WindowStart = time.Now().Add(-resetFailWindow - time.Minute)
+	h.resetMu.Unlock()
+	res := submit("123456")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("after window: status = %d, want %d", res.StatusCode, http.StatusOK)
+	}
+	var body map[string]interface{}
+	json.NewDecoder(res.Body).Decode(&body)
+	if _, ok := body["password"]; ok {
+		t.Error("response echoes the password")
+	}
+	if h.resetFails[customer.ID] != nil {
+		t.Error("successful reset left the failure count behind")
+	}
+}
+
+func TestAdminPortalResetDoesNotEchoPassword(t *testing.T) {
+	h := newTestHandler(t, nil)
+	customer := createTestCustomer(t, h, "C001", "")
+
+	rec := serve(h.ResetPortalPassword, http.MethodPost, "", map[string]string{"id": strconv.FormatInt(customer.ID, 10)})
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if strings.Contains(rec.Body.String(), `"password"`) {
+		t.Errorf("response echoes a password: %s", rec.Body.String())
+	}
+	var hash string
+	h.DB.QueryRow(`SELECT COALESCE(password, '') FROM customers WHERE id = ?`, customer.ID).Scan(&hash)
+	if hash != "" {
+		t.Error("password changed although it could not be delivered")
+	}
+}
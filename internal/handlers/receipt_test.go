@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"go-acs/internal/mailer"
+	"go-acs/internal/models"
+)
+
+// receiptCount returns how many payment receipt emails were queued
+func receiptCount(t *testing.T, h *Handler) int {
+	t.Helper()
+	var n int
+	if err := h.DB.QueryRow(`SELECT COUNT(*) FROM notification_outbox WHERE channel = ? AND subject = 'Payment Receipt - GO-ACS'`,
+		channelEmail).Scan(&n); err != nil {
+		t.Fatalf("count receipts: %v", err)
+	}
+	return n
+}
+
+// payByCallback pays an invoice through the gateway callback
+func payByCallback(t *testing.T, h *Handler, customerID int64, invoiceNo string) {
+	t.Helper()
+	id := createTestInvoice(t, h, customerID, invoiceNo, time.Now().AddDate(0, 0, 7), models.InvoicePending, 0)
+	body := callbackBody(invoiceNo, "T-"+invoiceNo, 100000, time.Now().Unix())
+	if rec := serve(h.HandleTripayCallback, http.MethodPost, body, nil); rec.Code != http.StatusOK {
+		t.Fatalf("callback for %s = %d: %s", invoiceNo, rec.Code, rec.Body)
+	}
+	if invoice, _ := h.DB.GetInvoice(id); invoice.Status != models.InvoicePaid {
+		t.Fatalf("%s status = %s, want paid", invoiceNo, invoice.Status)
+	}
+}
+
+func newReceiptHandler(t *testing.T) (*Handler, *models.Customer) {
+	t.Helper()
+	h := newTestHandler(t, nil)
+	h.Payment = fakeGateway{}
+	h.Mailer = mailer.New(mailer.Config{})
+	customer := createTestCustomer(t, h, "C001", "")
+	h.DB.Exec(`UPDATE customers SET email = 'budi@example.com' WHERE id = ?`, customer.ID)
+	return h, customer
+}
+
+func TestCallbackSendsNoReceiptWhenDisabled(t *testing.T) {
+	h, customer := newReceiptHandler(t)
+	h.Config.SendReceipt = false
+
+	payByCallback(t, h, customer.ID, "INV-1")
+	h.emailPool.Wait()
+	if n := receiptCount(t, h); n != 0 {
+		t.Fatalf("%d receipts queued with send_receipt off", n)
+	}
+
+	// Turning receipts back on sends them again
+	h.Config.SendReceipt = true
+	payByCallback(t, h, customer.ID, "INV-2")
+	h.emailPool.Wait()
+	if n := receiptCount(t, h); n != 1 {
+		t.Errorf("%d receipts queued with send_receipt on, want 1", n)
+	}
+}
+
+func TestCallbackHonorsCustomerReceiptPreference(t *testing.T) {
+	h, customer := newReceiptHandler(t)
+	h.Config.SendReceipt = true
+	vars := map[string]string{"id": fmt.Sprint(customer.ID)}
+
+	if rec := serve(h.UpdateCustomerNotificationPreferences, http.MethodPut, `{"receipts": false}`, vars); rec.Code != http.StatusOK {
+		t.Fatalf("opt out = %d: %s", rec.Code, rec.Body)
+	}
+	payByCallback(t, h, customer.ID, "INV-1")
+	h.emailPool.Wait()
+	if n := receiptCount(t, h); n != 0 {
+		t.Fatalf("%d receipts queued for a customer who opted out", n)
+	}
+
+	if rec := serve(h.UpdateCustomerNotificationPreferences, http.MethodPut, `{}`, vars); rec.Code != http.StatusBadRequest {
+		t.Errorf("preferences without receipts = %d, want 400", rec.Code)
+	}
+	if rec := serve(h.UpdateCustomerNotificationPreferences, http.MethodPut, `{"receipts": true}`, map[string]string{"id": "999"}); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown customer = %d, want 404", rec.Code)
+	}
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"go-acs/internal/models"
+)
+
+const (
+	replaceSSID     = "InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.SSID"
+	replacePPPoE    = "InternetGatewayDevice.WANDevice.1.WANConnectionDevice.1.WANPPPConnection.1.Username"
+	replaceDHCPFrom = "InternetGatewayDevice.LANDevice.1.LANHostConfigManagement.MinAddress"
+	replaceSoftware = "InternetGatewayDevice.DeviceInfo.SoftwareVersion"
+)
+
+// deployedDevice creates a device installed at customer's site with a WiFi,
+// WAN and LAN configuration
+func deployedDevice(t *testing.T, h *Handler, serial string, customer *models.Customer) *models.Device {
+	t.Helper()
+	device := createTestDevice(t, h, serial, "ZTE")
+	if _, err := h.DB.Exec(`
+		UPDATE devices SET customer_id = ?, template = 'budi@net', label = 'Budi - C001',
+			latitude = -6.9, longitude = 107.6, address = 'Jl. Merdeka 1', lifecycle_state = 'deployed'
+		WHERE id = ?
+	`, customer.ID, device.ID); err != nil {
+		t.Fatalf("deploy device: %v", err)
+	}
+	h.DB.Exec(`INSERT INTO device_customer_map (device_id, customer_id) VALUES (?, ?)`, device.ID, customer.ID)
+	h.DB.SetDeviceParameter(device.ID, replaceSSID, "Budi Home", "xsd:string", true)
+	h.DB.SetDeviceParameter(device.ID, replacePPPoE, "budi@net", "xsd:string", true)
+	h.DB.SetDeviceParameter(device.ID, replaceDHCPFrom, "192.168.1.100", "xsd:string", true)
+	h.DB.SetDeviceParameter(device.ID, replaceSoftware, "V1.0", "xsd:string", false)
+	return device
+}
+
+func replaceDevice(h *Handler, oldID int64, body string) *httptest.ResponseRecorder {
+	return serve(h.ReplaceDevice, http.MethodPost, body, map[string]string{"oldId": fmt.Sprint(oldID)})
+}
+
+func TestReplaceDeviceMovesCustomerAndConfig(t *testing.T) {
+	h := newTestHandler(t, nil)
+	customer := createTestCustomer(t, h, "C001", "")
+	old := deployedDevice(t, h, "SN-OLD", customer)
+	replacement := createTestDevice(t, h, "SN-NEW", "zte ")
+
+	rec := replaceDevice(h, old.ID, `{"newSerial": " SN-NEW "}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("replace = %d %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		DeviceID   int64            `json:"deviceId"`
+		TaskIDs    map[string]int64 `json:"taskIds"`
+		Parameters int              `json:"parameters"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if resp.DeviceID != replacement.ID || resp.Parameters != 3 || len(resp.TaskIDs) != 3 {
+		t.Errorf("response = %+v, want 3 parameters in 3 tasks on the replacement", resp)
+	}
+
+	got, _ := h.DB.GetDevice(replacement.ID)
+	if got.CustomerID == nil || *got.CustomerID != customer.ID {
+		t.Errorf("replacement customer = %v, want %d", got.CustomerID, customer.ID)
+	}
+	if got.Template != "budi@net" || got.Label != "Budi - C001" || got.LifecycleState != models.LifecycleDeployed {
+		t.Errorf("replacement template %q label %q state %s, want the old device's", got.Template, got.Label, got.LifecycleState)
+	}
+	if got.Latitude != -6.9 || got.Longitude != 107.6 || got.Address != "Jl. Merdeka 1" {
+		t.Errorf("replacement location = %v,%v %q, want the old site", got.Latitude, got.Longitude, got.Address)
+	}
+	var mapped int64
+	h.DB.QueryRow(`SELECT device_id FROM device_customer_map WHERE customer_id = ?`, customer.ID).Scan(&mapped)
+	if mapped != replacement.ID {
+		t.Errorf("customer mapped to device %d, want %d", mapped, replacement.ID)
+	}
+
+	retired, _ := h.DB.GetDevice(old.ID)
+	if retired.LifecycleState != models.LifecycleRetired || retired.CustomerID != nil || retired.Template != "" || retired.Label != "" {
+		t.Errorf("old device state %s customer %v template %q label %q, want retired and unassigned",
+			retired.LifecycleState, retired.CustomerID, retired.Template, retired.Label)
+	}
+
+	// The old configuration is queued onto the replacement, status values are not
+	tasks, err := h.DB.GetPendingTasks(replacement.ID)
+	if err != nil {
+		t.Fatalf("GetPendingTasks: %v", err)
+	}
+	queued := make(map[string]string)
+	for _, task := range tasks {
+		var params map[string]string
+		if task.Type != models.TaskSetParameterValues || json.Unmarshal(task.Parameters, &params) != nil {
+			t.Fatalf("unexpected task %+v", task)
+		}
+		for path, value := range params {
+			queued[path] = value
+		}
+	}
+	want := map[string]string{replaceSSID: "Budi Home", replacePPPoE: "budi@net", replaceDHCPFrom: "192.168.1.100"}
+	if !reflect.DeepEqual(queued, want) {
+		t.Errorf("queued config = %v, want %v", queued, want)
+	}
+
+	if rec := replaceDevice(h, old.ID, `{"newSerial": "SN-NEW"}`); rec.Code != http.StatusConflict {
+		t.Errorf("replacing a retired device = %d, want 409", rec.Code)
+	}
+}
+
+func TestReplaceDeviceChecksTheReplacement(t *testing.T) {
+	h := newTestHandler(t, nil)
+	customer := createTestCustomer(t, h, "C001", "")
+	other := createTestCustomer(t, h, "C002", "")
+	old := deployedDevice(t, h, "SN-OLD", customer)
+	taken := deployedDevice(t, h, "SN-TAKEN", other)
+	createTestDevice(t, h, "SN-HW", "Huawei")
+
+	for _, tt := range []struct {
+		body string
+		want int
+	}{
+		{`{"newSerial": ""}`, http.StatusBadRequest},
+		{`{"newSerial": "SN-OLD"}`, http.StatusBadRequest},
+		{`{"newSerial": "SN-MISSING"}`, http.StatusNotFound},
+		{`{"newSerial": "SN-TAKEN"}`, http.StatusConflict},
+		{`{"newSerial": "SN-HW"}`, http.StatusConflict},
+	} {
+		if rec := replaceDevice(h, old.ID, tt.body); rec.Code != tt.want {
+			t.Errorf("%s = %d %s, want %d", tt.body, rec.Code, rec.Body, tt.want)
+		}
+	}
+	if got, _ := h.DB.GetDevice(old.ID); got.LifecycleState == models.LifecycleRetired {
+		t.Fatal("rejected replacement retired the old device")
+	}
+	if got, _ := h.DB.GetDevice(taken.ID); got.CustomerID == nil || *got.CustomerID != other.ID {
+		t.Fatal("rejected replacement took the other customer's device")
+	}
+
+	// skipConfig moves the site to another vendor's unit without its config
+	if rec := replaceDevice(h, old.ID, `{"newSerial": "SN-HW", "skipConfig": true}`); rec.Code != http.StatusOK {
+		t.Fatalf("replace with skipConfig = %d %s", rec.Code, rec.Body)
+	}
+	hw, _ := h.DB.GetDeviceBySerial("SN-HW")
+	if hw.CustomerID == nil || *hw.CustomerID != customer.ID {
+		t.Errorf("replacement customer = %v, want %d", hw.CustomerID, customer.ID)
+	}
+	if tasks, _ := h.DB.GetPendingTasks(hw.ID); len(tasks) != 0 {
+		t.Errorf("%d task(s) queued with skipConfig, want none", len(tasks))
+	}
+}
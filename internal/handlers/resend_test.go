@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"go-acs/internal/models"
+)
+
+// outboxRecipients lists the WhatsApp recipients queued in the outbox
+func outboxRecipients(t *testing.T, h *Handler) []string {
+	t.Helper()
+	rows, err := h.DB.Query(`SELECT recipient FROM notification_outbox WHERE channel = ? ORDER BY id`, channelWhatsApp)
+	if err != nil {
+		t.Fatalf("query outbox: %v", err)
+	}
+	defer rows.Close()
+	var recipients []string
+	for rows.Next() {
+		var r string
+		rows.Scan(&r)
+		recipients = append(recipients, r)
+	}
+	return recipients
+}
+
+func TestResendInvoiceDispatchesNotification(t *testing.T) {
+	h, provider := newOutboxHandler(t)
+	customer := createTestCustomer(t, h, "C001", "081234")
+	due := time.Now().AddDate(0, 0, 7)
+	pending := createTestInvoice(t, h, customer.ID, "INV-1", due, models.InvoicePending, 0)
+	paid := createTestInvoice(t, h, customer.ID, "INV-2", due, models.InvoicePaid, 100000)
+
+	rec := serve(h.ResendInvoice, http.MethodPost, "", map[string]string{"id": fmt.Sprint(pending)})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("resend = %d: %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		Channels []string `json:"channels"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if len(resp.Channels) != 1 || resp.Channels[0] != channelWhatsApp {
+		t.Fatalf("channels = %v, want whatsapp", resp.Channels)
+	}
+	h.waPool.Wait()
+	if got := provider.received.Load(); got != 1 {
+		t.Fatalf("provider received %d messages, want 1", got)
+	}
+
+	if rec := serve(h.ResendInvoice, http.MethodPost, "", map[string]string{"id": fmt.Sprint(paid)}); rec.Code != http.StatusBadRequest {
+		t.Errorf("resend of a paid invoice = %d, want 400", rec.Code)
+	}
+	// Email is not configured, so there is nothing to send on that channel
+	if rec := serve(h.ResendInvoice, http.MethodPost, `{"channels":["email"]}`, map[string]string{"id": fmt.Sprint(pending)}); rec.Code != http.StatusBadRequest {
+		t.Errorf("resend via email only = %d, want 400", rec.Code)
+	}
+	if rec := serve(h.ResendInvoice, http.MethodPost, `{"channels":["sms"]}`, map[string]string{"id": fmt.Sprint(pending)}); rec.Code != http.StatusBadRequest {
+		t.Errorf("resend via an unknown channel = %d, want 400", rec.Code)
+	}
+	h.waPool.Wait()
+	if got := provider.received.Load(); got != 1 {
+		t.Fatalf("provider received %d messages after rejected resends, want 1", got)
+	}
+}
+
+func TestResendPendingInvoicesDispatchesUnpaidOnly(t *testing.T) {
+	h, provider := newOutboxHandler(t)
+	first := createTestCustomer(t, h, "C001", "0811")
+	second := createTestCustomer(t, h, "C002", "0812")
+	noPhone := createTestCustomer(t, h, "C003", "")
+	due := time.Now().AddDate(0, 0, 7)
+	createTestInvoice(t, h, first.ID, "INV-1", due, models.InvoicePending, 0)
+	createTestInvoice(t, h, second.ID, "INV-2", due.AddDate(0, 0, -30), models.InvoiceOverdue, 0)
+	createTestInvoice(t, h, second.ID, "INV-3", due, models.InvoicePaid, 100000)
+	createTestInvoice(t, h, noPhone.ID, "INV-4", due, models.InvoicePending, 0)
+
+	rec := serve(h.ResendPendingInvoices, http.MethodPost, "", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("bulk resend = %d: %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		Count   int                         `json:"count"`
+		Skipped []models.InvoiceRunCustomer `json:"skipped"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if resp.Count != 2 {
+		t.Errorf("count = %d, want 2", resp.Count)
+	}
+	if len(resp.Skipped) != 1 || resp.Skipped[0].CustomerID != noPhone.ID {
+		t.Errorf("skipped = %+v, want C003 without a phone", resp.Skipped)
+	}
+	h.waPool.Wait()
+	if got := provider.received.Load(); got != 2 {
+		t.Fatalf("provider received %d messages, want 2", got)
+	}
+
+	// Only overdue invoices
+	rec = serve(h.ResendPendingInvoices, http.MethodPost, `{"status":"overdue"}`, nil)
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if rec.Code != http.StatusOK || resp.Count != 1 {
+		t.Fatalf("overdue resend = %d count %d, want 200 and 1", rec.Code, resp.Count)
+	}
+	h.waPool.Wait()
+	if got := outboxRecipients(t, h); len(got) != 3 || got[2] != "0812" {
+		t.Fatalf("outbox recipients = %v, want the overdue customer last", got)
+	}
+
+	if rec := serve(h.ResendPendingInvoices, http.MethodPost, `{"status":"paid"}`, nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("resend of paid invoices = %d, want 400", rec.Code)
+	}
+}
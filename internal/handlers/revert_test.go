@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"go-acs/internal/models"
+)
+
+func TestRevertTaskQueuesPreviousValues(t *testing.T) {
+	h := newTestHandler(t, nil)
+	device := createTestDevice(t, h, "SN-REVERT", "ZTE")
+	const ssid = "InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.SSID"
+	const channel = "InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.Channel"
+	const unread = "InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.X_Unread"
+	h.DB.SetDeviceParameter(device.ID, ssid, "Rumah", "xsd:string", true)
+	h.DB.SetDeviceParameter(device.ID, channel, "6", "xsd:unsignedInt", true)
+
+	task, err := h.DB.CreateTask(&models.DeviceTask{
+		DeviceID:   device.ID,
+		Type:       models.TaskSetParameterValues,
+		Parameters: json.RawMessage(fmt.Sprintf(`{%q: "Broken", %q: "13", %q: "1"}`, ssid, channel, unread)),
+	})
+	if err != nil {
+		t.Fatalf("CreateTask: %v", err)
+	}
+
+	rec := serve(h.RevertTask, http.MethodPost, "", map[string]string{"taskId": fmt.Sprint(task.ID)})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("revert = %d: %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		TaskID  int64    `json:"taskId"`
+		Skipped []string `json:"skipped"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if !reflect.DeepEqual(resp.Skipped, []string{unread}) {
+		t.Errorf("skipped = %v, want the parameter never read", resp.Skipped)
+	}
+
+	revert, err := h.DB.GetTask(resp.TaskID)
+	if err != nil {
+		t.Fatalf("GetTask: %v", err)
+	}
+	if revert.Type != models.TaskSetParameterValues || revert.Status != models.TaskPending {
+		t.Fatalf("revert task = %s %s, want a pending setParameterValues", revert.Type, revert.Status)
+	}
+	var values map[string]string
+	json.Unmarshal(revert.Parameters, &values)
+	if want := map[string]string{ssid: "Rumah", channel: "6"}; !reflect.DeepEqual(values, want) {
+		t.Fatalf("revert values = %v, want %v", values, want)
+	}
+}
+
+func TestRevertTaskRejectsTasksWithoutSnapshot(t *testing.T) {
+	h := newTestHandler(t, nil)
+	device := createTestDevice(t, h, "SN-REVERT", "ZTE")
+	reboot, _ := h.DB.CreateTask(&models.DeviceTask{DeviceID: device.ID, Type: models.TaskReboot})
+	fresh, _ := h.DB.CreateTask(&models.DeviceTask{DeviceID: device.ID, Type: models.TaskSetParameterValues,
+		Parameters: json.RawMessage(`{"InternetGatewayDevice.X_New": "1"}`)})
+
+	for name, tc := range map[string]struct {
+		id   string
+		want int
+	}{
+		"reboot task":      {fmt.Sprint(reboot.ID), http.StatusBadRequest},
+		"nothing captured": {fmt.Sprint(fresh.ID), http.StatusBadRequest},
+		"unknown task":     {"9999", http.StatusNotFound},
+	} {
+		if rec := serve(h.RevertTask, http.MethodPost, "", map[string]string{"taskId": tc.id}); rec.Code != tc.want {
+			t.Errorf("%s: revert = %d, want %d", name, rec.Code, tc.want)
+		}
+	}
+}
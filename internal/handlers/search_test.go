@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"go-acs/internal/models"
+)
+
+func search(t *testing.T, h *Handler, query string) (int, *models.SearchResults) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.Search(rec, httptest.NewRequest(http.MethodGet, "/api/search?"+query, nil))
+	var results models.SearchResults
+	if rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), &results); err != nil {
+			t.Fatalf("decode search results: %v", err)
+		}
+	}
+	return rec.Code, &results
+}
+
+func TestSearchMatchesAcrossCategories(t *testing.T) {
+	h := newTestHandler(t, nil)
+	budi := createTestCustomer(t, h, "C001", "081277880000")
+	rina := createTestCustomer(t, h, "C002", "081200001111")
+	createTestInvoice(t, h, budi.ID, "INV-2026-7788", time.Now().AddDate(0, 0, 7), models.InvoicePending, 0)
+	createTestInvoice(t, h, rina.ID, "INV-2026-0001", time.Now().AddDate(0, 0, 7), models.InvoicePending, 0)
+
+	match := createTestDevice(t, h, "ZTEGC0A77880", "ZTE")
+	createTestDevice(t, h, "HWTC00000001", "Huawei")
+	pppoe := createTestDevice(t, h, "HWTC00000002", "Huawei")
+	if _, err := h.DB.Exec(`UPDATE devices SET template = 'rina.home', customer_id = ? WHERE id = ?`, rina.ID, pppoe.ID); err != nil {
+		t.Fatalf("set PPPoE username: %v", err)
+	}
+	if err := h.DB.AssignDeviceToCustomer(pppoe.ID, rina.ID); err != nil {
+		t.Fatalf("AssignDeviceToCustomer: %v", err)
+	}
+
+	code, results := search(t, h, "q=7788")
+	if code != http.StatusOK {
+		t.Fatalf("search = %d, want 200", code)
+	}
+	if len(results.Devices) != 1 || results.Devices[0].ID != match.ID {
+		t.Errorf("devices = %+v, want only %s", results.Devices, match.SerialNumber)
+	}
+	if len(results.Customers) != 1 || results.Customers[0].ID != budi.ID {
+		t.Errorf("customers = %+v, want only the one with the matching phone", results.Customers)
+	}
+	if len(results.Invoices) != 1 || results.Invoices[0].InvoiceNo != "INV-2026-7788" || results.Invoices[0].CustomerName != budi.Name {
+		t.Errorf("invoices = %+v, want INV-2026-7788 of %s", results.Invoices, budi.Name)
+	}
+
+	// A PPPoE username finds the customer it is provisioned for
+	_, results = search(t, h, "q="+url.QueryEscape("rina.home"))
+	if len(results.Customers) != 1 || results.Customers[0].ID != rina.ID {
+		t.Errorf("PPPoE customers = %+v, want %s", results.Customers, rina.CustomerCode)
+	}
+
+	// Each category is capped at limit
+	_, results = search(t, h, "q=HWTC&limit=1")
+	if len(results.Devices) != 1 {
+		t.Errorf("limit=1 returned %d devices", len(results.Devices))
+	}
+
+	_, results = search(t, h, "q=nothing-matches")
+	if len(results.Devices)+len(results.Customers)+len(results.Invoices) != 0 {
+		t.Errorf("unmatched query returned %+v", results)
+	}
+	if code, _ := search(t, h, "q=7"); code != http.StatusBadRequest {
+		t.Errorf("one-character query = %d, want 400", code)
+	}
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+type settingsExport struct {
+	IncludeSecrets bool              `json:"includeSecrets"`
+	Omitted        []string          `json:"omitted"`
+	Settings       map[string]string `json:"settings"`
+}
+
+func exportSettings(t *testing.T, h *Handler, query string) settingsExport {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ExportSettings(rec, httptest.NewRequest(http.MethodGet, "/api/settings/export"+query, nil))
+	var export settingsExport
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &export) != nil {
+		t.Fatalf("export = %d %s", rec.Code, rec.Body)
+	}
+	return export
+}
+
+func importSettings(h *Handler, query string, settings map[string]string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(map[string]interface{}{"settings": settings})
+	rec := httptest.NewRecorder()
+	h.ImportSettings(rec, httptest.NewRequest(http.MethodPost, "/api/settings/import"+query, bytes.NewReader(body)))
+	return rec
+}
+
+func TestSettingsExportImportRoundTrip(t *testing.T) {
+	source := newTestHandler(t, nil)
+	for k, v := range map[string]string{
+		"company_name":       "Net Sukamaju",
+		"tax_percent":        "11",
+		"hold_max_days":      "60",
+		"tripay_private_key": "priv-123",
+		"tr069_password":     "acs-pass",
+	} {
+		if err := source.DB.SaveSetting(k, v); err != nil {
+			t.Fatalf("SaveSetting %s: %v", k, err)
+		}
+	}
+
+	export := exportSettings(t, source, "")
+	if export.IncludeSecrets || !reflect.DeepEqual(export.Omitted, []string{"tr069_password", "tripay_private_key"}) {
+		t.Errorf("omitted = %v, want the two secrets", export.Omitted)
+	}
+	for _, k := range export.Omitted {
+		if _, ok := export.Settings[k]; ok {
+			t.Errorf("export without secrets contains %s", k)
+		}
+	}
+
+	// The clone keeps its own credentials and takes everything else
+	target := newTestHandler(t, nil)
+	target.DB.SaveSetting("tr069_password", "target-pass")
+	if rec := importSettings(target, "", export.Settings); rec.Code != http.StatusOK {
+		t.Fatalf("import = %d %s", rec.Code, rec.Body)
+	}
+	cloned := exportSettings(t, target, "")
+	if !reflect.DeepEqual(cloned.Settings, export.Settings) {
+		t.Errorf("imported settings = %v, want %v", cloned.Settings, export.Settings)
+	}
+	if target.Config.TaxPercent != 11 || target.Config.HoldMaxDays != 60 {
+		t.Errorf("config tax %v hold %d, want 11 and 60", target.Config.TaxPercent, target.Config.HoldMaxDays)
+	}
+	if pass, _ := target.DB.GetSetting("tr069_password"); pass != "target-pass" {
+		t.Errorf("target tr069_password = %q, want it kept", pass)
+	}
+
+	// Secrets in a full backup are skipped unless the import confirms them
+	full := exportSettings(t, source, "?includeSecrets=true")
+	if full.Settings["tripay_private_key"] != "priv-123" || len(full.Omitted) != 0 {
+		t.Fatalf("full export = %+v, want the secrets included", full)
+	}
+	rec := importSettings(target, "", full.Settings)
+	var resp struct {
+		Skipped []string `json:"skipped"`
+	}
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &resp) != nil ||
+		!reflect.DeepEqual(resp.Skipped, []string{"tr069_password", "tripay_private_key"}) {
+		t.Fatalf("import of a full backup = %d %s, want both secrets skipped", rec.Code, rec.Body)
+	}
+	if pass, _ := target.DB.GetSetting("tr069_password"); pass != "target-pass" {
+		t.Errorf("unconfirmed import overwrote tr069_password with %q", pass)
+	}
+
+	if rec := importSettings(target, "?includeSecrets=true", full.Settings); rec.Code != http.StatusOK {
+		t.Fatalf("confirmed import = %d %s", rec.Code, rec.Body)
+	}
+	if got := exportSettings(t, target, "?includeSecrets=true"); !reflect.DeepEqual(got.Settings, full.Settings) {
+		t.Errorf("after a confirmed import settings = %v, want %v", got.Settings, full.Settings)
+	}
+	if target.Config.TripayPrivateKey != "priv-123" || target.Config.TR069Password != "acs-pass" {
+		t.Errorf("config secrets = %q %q, want them imported", target.Config.TripayPrivateKey, target.Config.TR069Password)
+	}
+}
+
+func TestSettingsImportRejectsInvalidValues(t *testing.T) {
+	h := newTestHandler(t, nil)
+	if rec := importSettings(h, "", map[string]string{"timezone": "Mars/Olympus"}); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid timezone = %d, want 400", rec.Code)
+	}
+	if rec := importSettings(h, "", map[string]string{}); rec.Code != http.StatusBadRequest {
+		t.Errorf("empty import = %d, want 400", rec.Code)
+	}
+	if v, _ := h.DB.GetSetting("timezone"); v != "" {
+		t.Errorf("timezone saved as %q after a rejected import", v)
+	}
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go-acs/internal/models"
+
+	"github.com/gorilla/mux"
+)
+
+func TestBuildStatementForPeriod(t *testing.T) {
+	day := func(m time.Month, d int) time.Time { return time.Date(2026, m, d, 10, 0, 0, 0, time.UTC) }
+	invoices := []*models.Invoice{
+		{InvoiceNo: "INV-JAN", Total: 100000, Status: models.InvoicePaid, CreatedAt: day(1, 1)},
+		{InvoiceNo: "INV-FEB", Total: 100000, Status: models.InvoicePending, CreatedAt: day(2, 1)},
+		{InvoiceNo: "INV-FEB-X", Total: 50000, Status: models.InvoiceCancelled, CreatedAt: day(2, 2)},
+		{InvoiceNo: "INV-MAR", Total: 120000, Status: models.InvoicePending, CreatedAt: day(3, 1)},
+		{InvoiceNo: "INV-APR", Total: 100000, Status: models.InvoicePending, CreatedAt: day(4, 1)},
+	}
+	payments := []*models.Payment{
+		{PaymentNo: "PAY-JAN", Amount: 100000, Status: "completed", PaymentDate: day(1, 5)},
+		{PaymentNo: "PAY-FEB", Amount: 60000, Status: "completed", PaymentDate: day(2, 10)},
+		{PaymentNo: "PAY-FAILED", Amount: 40000, Status: "failed", PaymentDate: day(2, 11)},
+		{PaymentNo: "PAY-MAR", Amount: 40000, Status: "completed", PaymentDate: day(3, 15)},
+	}
+	from := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2026, 3, 31, 23, 59, 59, 0, time.UTC)
+
+	st := buildStatement(&models.Customer{Name: "Budi"}, invoices, payments, &from, to)
+
+	// January's invoice and payment net out before the period
+	if st.OpeningBalance != 0 {
+		t.Fatalf("opening balance = %v, want 0", st.OpeningBalance)
+	}
+	want := []struct {
+		ref     string
+		balance float64
+	}{
+		{"INV-FEB", 100000},
+		{"PAY-FEB", 40000},
+		{"INV-MAR", 160000},
+		{"PAY-MAR", 120000},
+	}
+	if len(st.Lines) != len(want) {
+		t.Fatalf("lines = %+v, want %d", st.Lines, len(want))
+	}
+	for i, w := range want {
+		if st.Lines[i].Reference != w.ref || st.Lines[i].Balance != w.balance {
+			t.Errorf("line %d = %s balance %v, want %s balance %v", i, st.Lines[i].Reference, st.Lines[i].Balance, w.ref, w.balance)
+		}
+	}
+	if st.TotalInvoiced != 220000 || st.TotalPaid != 100000 || st.ClosingBalance != 120000 {
+		t.Fatalf("totals: invoiced %v, paid %v, closing %v", st.TotalInvoiced, st.TotalPaid, st.ClosingBalance)
+	}
+}
+
+func TestBuildStatementOpeningBalance(t *testing.T) {
+	invoices := []*models.Invoice{
+		{InvoiceNo: "INV-1", Total: 100000, Status: models.InvoicePending, CreatedAt: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	payments := []*models.Payment{
+		{PaymentNo: "PAY-1", Amount: 30000, Status: "completed", PaymentDate: time.Date(2026, 1, 20, 0, 0, 0, 0, time.UTC)},
+	}
+	from := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+
+	st := buildStatement(&models.Customer{}, invoices, payments, &from, from.AddDate(0, 1, 0))
+	if st.OpeningBalance != 70000 || st.ClosingBalance != 70000 || len(st.Lines) != 0 {
+		t.Fatalf("opening %v, closing %v, %d lines", st.OpeningBalance, st.ClosingBalance, len(st.Lines))
+	}
+}
+
+func TestGetCustomerStatement(t *testing.T) {
+	h := newTestHandler(t, nil)
+	customer := createTestCustomer(t, h, "C001", "")
+	createTestInvoice(t, h, customer.ID, "INV-1", time.Now().AddDate(0, 0, 7), models.InvoicePending, 0)
+	vars := map[string]string{"id": fmt.Sprint(customer.ID)}
+
+	statement := func(query string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/?"+query, nil)
+		r = mux.SetURLVars(r, vars)
+		rec := httptest.NewRecorder()
+		h.GetCustomerStatement(rec, r)
+		return rec
+	}
+
+	rec := statement("")
+	if rec.Code != 200 {
+		t.Fatalf("statement = %d %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		Data models.Statement `json:"data"`
+	}
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if len(resp.Data.Lines) != 1 || resp.Data.Lines[0].Reference != "INV-1" {
+		t.Fatalf("lines = %+v", resp.Data.Lines)
+	}
+
+	rec = statement("format=html")
+	if rec.Code != 200 || !strings.Contains(rec.Body.String(), "INV-1") {
+		t.Fatalf("html statement = %d, contains INV-1: %v", rec.Code, strings.Contains(rec.Body.String(), "INV-1"))
+	}
+
+	if rec := statement("from=2026-03-01&to=2026-02-01"); rec.Code != 400 {
+		t.Fatalf("reversed period = %d, want 400", rec.Code)
+	}
+}
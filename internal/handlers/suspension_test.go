@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"go-acs/internal/models"
+)
+
+func TestSuspensionNoticeOncePerEvent(t *testing.T) {
+	h, provider := newOutboxHandler(t)
+	customer := createTestCustomer(t, h, "C001", "08123456789")
+	createTestInvoice(t, h, customer.ID, "INV-1", time.Now().AddDate(0, 0, -40), models.InvoiceOverdue, 0)
+
+	isolir := func() {
+		t.Helper()
+		if rec := serve(h.BatchIsolirOverdue, "POST", `{"daysOverdue": 30}`, nil); rec.Code != 200 {
+			t.Fatalf("BatchIsolirOverdue = %d %s", rec.Code, rec.Body)
+		}
+		h.waPool.Wait()
+	}
+
+	isolir()
+	isolir()
+	if got := provider.received.Load(); got != 1 {
+		t.Fatalf("notices after re-run = %d, want 1", got)
+	}
+
+	// Reactivating by editing the status closes the event
+	body := fmt.Sprintf(`{"name": %q, "phone": %q, "packageId": %d, "status": "active"}`, customer.Name, customer.Phone, customer.PackageID)
+	if rec := serve(h.UpdateCustomer, "PUT", body, map[string]string{"id": fmt.Sprint(customer.ID)}); rec.Code != 200 {
+		t.Fatalf("UpdateCustomer = %d %s", rec.Code, rec.Body)
+	}
+	var open int
+	h.DB.QueryRow(`SELECT COUNT(*) FROM suspension_events WHERE reactivated_at IS NULL`).Scan(&open)
+	if open != 0 {
+		t.Fatalf("%d suspension event(s) still open after reactivation", open)
+	}
+
+	isolir()
+	if got := provider.received.Load(); got != 2 {
+		t.Fatalf("notices after second suspension = %d, want 2", got)
+	}
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"encoding/json"
+	"testing"
+
+	"go-acs/internal/models"
+)
+
+func TestBulkTagDevicesAndCounts(t *testing.T) {
+	h := newTestHandler(t, nil)
+	createTestDevice(t, h, "ZTE001", "ZTE")
+	createTestDevice(t, h, "ZTE002", "ZTE")
+	createTestDevice(t, h, "HWI001", "Huawei")
+
+	if rec := serve(h.CreateTag, "POST", `{"name": "vip", "color": "#f00"}`, nil); rec.Code != 201 {
+		t.Fatalf("CreateTag = %d %s", rec.Code, rec.Body)
+	}
+	if rec := serve(h.BulkTagDevices, "POST", `{"deviceIds": [1, 2], "add": ["fiber", "fiber"]}`, nil); rec.Code != 200 {
+		t.Fatalf("BulkTagDevices by id = %d %s", rec.Code, rec.Body)
+	}
+	if rec := serve(h.BulkTagDevices, "POST", `{"search": "ZTE002", "add": ["vip"]}`, nil); rec.Code != 200 {
+		t.Fatalf("BulkTagDevices by filter = %d %s", rec.Code, rec.Body)
+	}
+	// Removing a tag a device does not carry changes nothing
+	rec := serve(h.BulkTagDevices, "POST", `{"deviceIds": [3], "remove": ["fiber"]}`, nil)
+	var res struct{ Updated int }
+	json.Unmarshal(rec.Body.Bytes(), &res)
+	if res.Updated != 0 {
+		t.Errorf("removing an absent tag updated %d device(s)", res.Updated)
+	}
+
+	rec = serve(h.GetTags, "GET", "", nil)
+	var tags []models.Tag
+	if err := json.Unmarshal(rec.Body.Bytes(), &tags); err != nil {
+		t.Fatalf("GetTags: %v (%s)", err, rec.Body)
+	}
+	counts := map[string]int{}
+	for _, tag := range tags {
+		counts[tag.Name] = tag.DeviceCount
+	}
+	if counts["fiber"] != 2 || counts["vip"] != 1 || len(counts) != 2 {
+		t.Errorf("tag counts = %v, want fiber:2 vip:1", counts)
+	}
+}
+
+func TestDeleteTagStripsDevices(t *testing.T) {
+	h := newTestHandler(t, nil)
+	createTestDevice(t, h, "ZTE001", "ZTE")
+	if _, err := h.DB.BulkUpdateDeviceTags([]int64{1}, []string{"fiber", "vip"}, nil); err != nil {
+		t.Fatalf("BulkUpdateDeviceTags: %v", err)
+	}
+
+	if rec := serve(h.DeleteTag, "DELETE", "", map[string]string{"name": "vip"}); rec.Code != 200 {
+		t.Fatalf("DeleteTag = %d %s", rec.Code, rec.Body)
+	}
+	device, _ := h.DB.GetDevice(1)
+	if len(device.Tags) != 1 || device.Tags[0] != "fiber" {
+		t.Errorf("tags = %v, want [fiber]", device.Tags)
+	}
+}
@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRequeueSkipsPrunedAndOldFailures(t *testing.T) {
+	h := newTestHandler(t, nil)
+	device := createTestDevice(t, h, "SN001", "ZTE")
+	h.Config.MaxTaskRetries = 3
+	h.taskRetriesSince.Store(time.Now().Add(-time.Hour).UnixNano())
+
+	failed := func(errMsg string, completed time.Time) int64 {
+		res, err := h.DB.Exec(`INSERT INTO tasks (device_id, type, status, error, completed_at) VALUES (?, 'reboot', 'failed', ?, ?)`,
+			device.ID, errMsg, completed.UTC().Format("2006-01-02 15:04:05"))
+		if err != nil {
+			t.Fatalf("insert task: %v", err)
+		}
+		id, _ := res.LastInsertId()
+		return id
+	}
+	recent := time.Now().Add(-time.Minute)
+	timeout := failed("timeout", recent)
+	stale := failed("timeout", time.Now().Add(-48*time.Hour))
+	superseded := failed("superseded by a newer task", recent)
+	dropped := failed("dropped: pending task limit reached", recent)
+
+	h.RequeueFailedTasks()
+
+	for id, want := range map[int64]string{timeout: "pending", stale: "failed", superseded: "failed", dropped: "failed"} {
+		var status string
+		h.DB.QueryRow(`SELECT status FROM tasks WHERE id = ?`, id).Scan(&status)
+		if status != want {
+			t.Errorf("task %d status = %s, want %s", id, status, want)
+		}
+	}
+}
+
+func TestEnablingRetriesStartsFromNow(t *testing.T) {
+	h := newTestHandler(t, nil)
+	h.Config.MaxTaskRetries = 0
+	before := time.Now()
+	h.applySettings(map[string]string{"max_task_retries": "2"})
+	if since := time.Unix(0, h.taskRetriesSince.Load()); since.Before(before) {
+		t.Fatalf("retries active since %v, want after %v", since, before)
+	}
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"go-acs/internal/models"
+)
+
+// createTestTechnician registers a new user as an available technician
+func createTestTechnician(t *testing.T, h *Handler, username string, areas ...string) *models.Technician {
+	t.Helper()
+	res, err := h.DB.Exec(`INSERT INTO users (username, password, role) VALUES (?, '', 'operator')`, username)
+	if err != nil {
+		t.Fatalf("insert user: %v", err)
+	}
+	userID, _ := res.LastInsertId()
+	tech, err := h.DB.CreateTechnician(&models.Technician{UserID: userID, Areas: areas, Available: true})
+	if err != nil {
+		t.Fatalf("CreateTechnician: %v", err)
+	}
+	return tech
+}
+
+// createAssignedTicket creates a ticket through the API and returns who got it
+func createAssignedTicket(t *testing.T, h *Handler, customerID int64) *int64 {
+	t.Helper()
+	rec := serve(h.CreateSupportTicket, http.MethodPost,
+		fmt.Sprintf(`{"customerId": %d, "subject": "No internet", "category": "connection"}`, customerID), nil)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create ticket = %d: %s", rec.Code, rec.Body)
+	}
+	var ticket models.SupportTicket
+	json.Unmarshal(rec.Body.Bytes(), &ticket)
+	stored, err := h.DB.GetSupportTicket(ticket.ID)
+	if err != nil {
+		t.Fatalf("GetSupportTicket: %v", err)
+	}
+	if (ticket.AssignedTo == nil) != (stored.AssignedTo == nil) ||
+		(ticket.AssignedTo != nil && *ticket.AssignedTo != *stored.AssignedTo) {
+		t.Fatalf("response assignee %v differs from stored %v", ticket.AssignedTo, stored.AssignedTo)
+	}
+	return stored.AssignedTo
+}
+
+func TestTicketsRoundRobinAcrossTechnicians(t *testing.T) {
+	h := newTestHandler(t, nil)
+	h.Config.TicketAutoAssign = models.TicketAssignRoundRobin
+	customer := createTestCustomer(t, h, "C001", "")
+	andi := createTestTechnician(t, h, "andi")
+	budi := createTestTechnician(t, h, "budi")
+
+	want := []int64{andi.UserID, budi.UserID, andi.UserID, budi.UserID}
+	for i, userID := range want {
+		got := createAssignedTicket(t, h, customer.ID)
+		if got == nil || *got != userID {
+			t.Fatalf("ticket %d assigned to %v, want user %d", i+1, got, userID)
+		}
+	}
+
+	// An unavailable technician is skipped
+	budi.Available = false
+	if err := h.DB.UpdateTechnician(budi); err != nil {
+		t.Fatalf("UpdateTechnician: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if got := createAssignedTicket(t, h, customer.ID); got == nil || *got != andi.UserID {
+			t.Fatalf("assigned to %v while budi is unavailable, want andi", got)
+		}
+	}
+}
+
+func TestTicketsAssignedByCustomerArea(t *testing.T) {
+	h := newTestHandler(t, nil)
+	h.Config.TicketAutoAssign = models.TicketAssignArea
+	customer := createTestCustomer(t, h, "C001", "")
+	h.DB.Exec(`UPDATE customers SET address = 'Jl. Mawar 3, Sukamaju' WHERE id = ?`, customer.ID)
+	createTestTechnician(t, h, "andi", "Cibubur")
+	budi := createTestTechnician(t, h, "budi", "sukamaju")
+
+	for i := 0; i < 2; i++ {
+		if got := createAssignedTicket(t, h, customer.ID); got == nil || *got != budi.UserID {
+			t.Fatalf("ticket %d assigned to %v, want the Sukamaju technician", i+1, got)
+		}
+	}
+}
+
+func TestTicketsStayUnassignedWhenAutoAssignIsOff(t *testing.T) {
+	h := newTestHandler(t, nil)
+	h.Config.TicketAutoAssign = models.TicketAssignOff
+	customer := createTestCustomer(t, h, "C001", "")
+	createTestTechnician(t, h, "andi")
+
+	if got := createAssignedTicket(t, h, customer.ID); got != nil {
+		t.Errorf("ticket assigned to %d with auto-assignment off", *got)
+	}
+}
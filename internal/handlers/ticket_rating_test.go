@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go-acs/internal/middleware"
+	"go-acs/internal/models"
+
+	"github.com/gorilla/mux"
+)
+
+// serveAsCustomer calls a portal handler behind the portal auth middleware
+// with a token for customerID
+func serveAsCustomer(h *Handler, customerID int64, handler http.HandlerFunc, method, body string, vars map[string]string) *httptest.ResponseRecorder {
+	token, _ := generateCustomerJWT(&models.Customer{ID: customerID}, h.Config.JWTSecret)
+	r := httptest.NewRequest(method, "/api/portal/tickets", bytes.NewBufferString(body))
+	r.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	middleware.CustomerAuthMiddleware(h.Config.JWTSecret)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler(w, mux.SetURLVars(r, vars))
+	})).ServeHTTP(rec, r)
+	return rec
+}
+
+func createTestTicket(t *testing.T, h *Handler, customerID int64, status string) *models.SupportTicket {
+	t.Helper()
+	ticket, err := h.DB.CreateSupportTicket(&models.SupportTicket{CustomerID: customerID, Subject: "No internet", Priority: "medium", Status: status})
+	if err != nil {
+		t.Fatalf("CreateSupportTicket: %v", err)
+	}
+	return ticket
+}
+
+func rate(h *Handler, customerID, ticketID int64, body string) int {
+	return serveAsCustomer(h, customerID, h.RatePortalTicket, http.MethodPost, body, map[string]string{"id": fmt.Sprint(ticketID)}).Code
+}
+
+func TestCustomerRatesOwnClosedTicket(t *testing.T) {
+	h := newTestHandler(t, nil)
+	alice := createTestCustomer(t, h, "C001", "081200000001")
+	bob := createTestCustomer(t, h, "C002", "081200000002")
+	resolved := createTestTicket(t, h, alice.ID, "resolved")
+	open := createTestTicket(t, h, alice.ID, "open")
+	bobs := createTestTicket(t, h, bob.ID, "closed")
+
+	if code := rate(h, alice.ID, resolved.ID, `{"rating":4,"comment":" Fixed quickly "}`); code != http.StatusOK {
+		t.Fatalf("rate resolved ticket = %d, want 200", code)
+	}
+	got, _ := h.DB.GetSupportTicket(resolved.ID)
+	if got.Rating == nil || *got.Rating != 4 || got.RatingComment != "Fixed quickly" || got.RatedAt == nil {
+		t.Errorf("stored rating = %v %q %v, want 4 %q and a rated time", got.Rating, got.RatingComment, got.RatedAt, "Fixed quickly")
+	}
+
+	for _, tc := range []struct {
+		name     string
+		ticketID int64
+		body     string
+		want     int
+	}{
+		{"already rated", resolved.ID, `{"rating":5}`, http.StatusConflict},
+		{"still open", open.ID, `{"rating":5}`, http.StatusConflict},
+		{"another customer's ticket", bobs.ID, `{"rating":1}`, http.StatusNotFound},
+		{"customer named in body", bobs.ID, fmt.Sprintf(`{"customerId":%d,"rating":1}`, bob.ID), http.StatusBadRequest},
+		{"rating out of range", open.ID, `{"rating":6}`, http.StatusBadRequest},
+		{"no rating", open.ID, `{}`, http.StatusBadRequest},
+	} {
+		if code := rate(h, alice.ID, tc.ticketID, tc.body); code != tc.want {
+			t.Errorf("%s: status = %d, want %d", tc.name, code, tc.want)
+		}
+	}
+	if got, _ := h.DB.GetSupportTicket(bobs.ID); got.Rating != nil {
+		t.Errorf("another customer's ticket was rated %d", *got.Rating)
+	}
+
+	// Without a portal session the middleware refuses the request
+	rec := serve(func(w http.ResponseWriter, r *http.Request) {
+		middleware.CustomerAuthMiddleware(h.Config.JWTSecret)(http.HandlerFunc(h.RatePortalTicket)).ServeHTTP(w, r)
+	}, http.MethodPost, `{"rating":5}`, map[string]string{"id": fmt.Sprint(open.ID)})
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("rate without a session = %d, want 401", rec.Code)
+	}
+}
+
+func TestPortalTicketsListsOnlyOwnTickets(t *testing.T) {
+	h := newTestHandler(t, nil)
+	alice := createTestCustomer(t, h, "C001", "081200000001")
+	bob := createTestCustomer(t, h, "C002", "081200000002")
+	createTestTicket(t, h, alice.ID, "open")
+	createTestTicket(t, h, alice.ID, "resolved")
+	createTestTicket(t, h, bob.ID, "open")
+
+	rec := serveAsCustomer(h, alice.ID, h.GetPortalTickets, http.MethodGet, "", nil)
+	var resp struct {
+		Tickets []*models.SupportTicket `json:"tickets"`
+		Total   int64                   `json:"total"`
+	}
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &resp) != nil {
+		t.Fatalf("portal tickets = %d %s", rec.Code, rec.Body)
+	}
+	if resp.Total != 2 || len(resp.Tickets) != 2 {
+		t.Fatalf("got %d tickets (total %d), want 2", len(resp.Tickets), resp.Total)
+	}
+	for _, ticket := range resp.Tickets {
+		if ticket.CustomerID != alice.ID {
+			t.Errorf("ticket %s belongs to customer %d", ticket.TicketNo, ticket.CustomerID)
+		}
+	}
+}
+
+func TestTicketStatsAverageSatisfaction(t *testing.T) {
+	h := newTestHandler(t, nil)
+	alice := createTestCustomer(t, h, "C001", "081200000001")
+	bob := createTestCustomer(t, h, "C002", "081200000002")
+	for customerID, ratings := range map[int64][]int{alice.ID: {5, 4}, bob.ID: {2}} {
+		for _, rating := range ratings {
+			ticket := createTestTicket(t, h, customerID, "closed")
+			if code := rate(h, customerID, ticket.ID, fmt.Sprintf(`{"rating":%d}`, rating)); code != http.StatusOK {
+				t.Fatalf("rate %d = %d", rating, code)
+			}
+		}
+	}
+	createTestTicket(t, h, alice.ID, "resolved") // resolved but not rated
+	createTestTicket(t, h, bob.ID, "open")
+
+	rec := serve(h.GetTicketStats, http.MethodGet, "", nil)
+	var stats models.TicketStats
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &stats) != nil {
+		t.Fatalf("ticket stats = %d %s", rec.Code, rec.Body)
+	}
+	if stats.Total != 5 || stats.Rated != 3 {
+		t.Errorf("total %d rated %d, want 5 and 3", stats.Total, stats.Rated)
+	}
+	if stats.AverageRating < 3.66 || stats.AverageRating > 3.67 {
+		t.Errorf("averageRating = %v, want 3.67", stats.AverageRating)
+	}
+	if stats.ByStatus["closed"] != 3 || stats.ByStatus["resolved"] != 1 || stats.ByStatus["open"] != 1 {
+		t.Errorf("byStatus = %v", stats.ByStatus)
+	}
+	want := map[int]int64{5: 1, 4: 1, 2: 1}
+	for rating, count := range want {
+		if stats.RatingCounts[rating] != count {
+			t.Errorf("ratingCounts[%d] = %d, want %d", rating, stats.RatingCounts[rating], count)
+		}
+	}
+	if len(stats.RatingCounts) != len(want) {
+		t.Errorf("ratingCounts = %v, want %v", stats.RatingCounts, want)
+	}
+}
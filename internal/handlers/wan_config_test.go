@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"go-acs/internal/models"
+)
+
+func TestBuildWANConfigParamsPPPoE(t *testing.T) {
+	const base = "InternetGatewayDevice.WANDevice.1.WANConnectionDevice.2.WANPPPConnection.1."
+	config := &models.WANConfig{Name: "INTERNET", ConnectionType: "PPPoE", Username: "user@isp", Password: "secret",
+		VLAN: 100, MTU: 1492, DNS1: "8.8.8.8", DNS2: "1.1.1.1", Enabled: true, NATEnabled: true}
+
+	for manufacturer, vlanParam := range map[string]string{
+		"Huawei Technologies": "X_HW_VLAN",
+		"ZTE":                 "X_ZTE-COM_VLANID",
+		"FiberHome":           "X_FH_VLAN",
+		"Nokia":               "X_ALU_VLANID",
+		"Acme":                "VLANID",
+	} {
+		t.Run(manufacturer, func(t *testing.T) {
+			want := map[string]string{
+				base + "Enable":         "true",
+				base + "Name":           "INTERNET",
+				base + "ConnectionType": "IP_Routed",
+				base + "NATEnabled":     "true",
+				base + "Username":       "user@isp",
+				base + "Password":       "secret",
+				base + "MaxMRUSize":     "1492",
+				base + "DNSServers":     "8.8.8.8,1.1.1.1",
+				base + vlanParam:        "100",
+			}
+			got := buildWANConfigParams(&models.Device{Manufacturer: manufacturer}, config, 2)
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("params = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestBuildWANConfigParamsStatic(t *testing.T) {
+	const base = "InternetGatewayDevice.WANDevice.1.WANConnectionDevice.1.WANIPConnection.1."
+	config := &models.WANConfig{ConnectionType: "static", IPAddress: "203.0.113.10", SubnetMask: "255.255.255.248",
+		Gateway: "203.0.113.9", DNS1: "203.0.113.1", MTU: 1500, Enabled: true}
+
+	want := map[string]string{
+		base + "Enable":            "true",
+		base + "ConnectionType":    "IP_Routed",
+		base + "NATEnabled":        "false",
+		base + "AddressingType":    "Static",
+		base + "ExternalIPAddress": "203.0.113.10",
+		base + "SubnetMask":        "255.255.255.248",
+		base + "DefaultGateway":    "203.0.113.9",
+		base + "MaxMTUSize":        "1500",
+		base + "DNSServers":        "203.0.113.1",
+	}
+	got := buildWANConfigParams(&models.Device{Manufacturer: "ZTE"}, config, 1)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("params = %v, want %v", got, want)
+	}
+
+	// DHCP only sets the addressing type and never sends static addresses
+	config = &models.WANConfig{ConnectionType: "DHCP", IPAddress: "203.0.113.10", Enabled: true}
+	got = buildWANConfigParams(nil, config, 1)
+	if got[base+"AddressingType"] != "DHCP" || got[base+"ExternalIPAddress"] != "" {
+		t.Fatalf("DHCP params = %v", got)
+	}
+}
+
+func TestValidateWANConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config models.WANConfig
+		ok     bool
+	}{
+		{"pppoe", models.WANConfig{ConnectionType: "PPPoE", Username: "user"}, true},
+		{"pppoe without username", models.WANConfig{ConnectionType: "PPPoE"}, false},
+		{"dhcp", models.WANConfig{ConnectionType: "DHCP", VLAN: 4094}, true},
+		{"static", models.WANConfig{ConnectionType: "Static", IPAddress: "10.0.0.2", SubnetMask: "255.255.255.0"}, true},
+		{"static without mask", models.WANConfig{ConnectionType: "Static", IPAddress: "10.0.0.2"}, false},
+		{"static bad gateway", models.WANConfig{ConnectionType: "Static", IPAddress: "10.0.0.2", SubnetMask: "255.255.255.0", Gateway: "x"}, false},
+		{"unknown type", models.WANConfig{ConnectionType: "L2TP"}, false},
+		{"vlan out of range", models.WANConfig{ConnectionType: "DHCP", VLAN: 4095}, false},
+		{"mtu too small", models.WANConfig{ConnectionType: "DHCP", MTU: 500}, false},
+	}
+	for _, tt := range tests {
+		if err := validateWANConfig(&tt.config); (err == nil) != tt.ok {
+			t.Errorf("%s: validateWANConfig = %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestApplyWANConfigQueuesSetParameterValues(t *testing.T) {
+	h := newTestHandler(t, nil)
+	device := createTestDevice(t, h, "SN-WAN", "Huawei")
+	config, err := h.DB.CreateWANConfig(&models.WANConfig{DeviceID: device.ID, Name: "INTERNET",
+		ConnectionType: "PPPoE", Username: "user@isp", VLAN: 100, Enabled: true})
+	if err != nil {
+		t.Fatalf("CreateWANConfig: %v", err)
+	}
+
+	vars := map[string]string{"id": fmt.Sprint(device.ID), "wanId": fmt.Sprint(config.ID)}
+	rec := serve(h.ApplyWANConfig, http.MethodPost, "", vars)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	tasks, err := h.DB.GetPendingTasks(device.ID)
+	if err != nil || len(tasks) != 1 {
+		t.Fatalf("pending tasks = %d (%v), want 1", len(tasks), err)
+	}
+	if tasks[0].Type != models.TaskSetParameterValues {
+		t.Fatalf("task type = %s", tasks[0].Type)
+	}
+	var params map[string]string
+	json.Unmarshal(tasks[0].Parameters, &params)
+	const base = "InternetGatewayDevice.WANDevice.1.WANConnectionDevice.1.WANPPPConnection.1."
+	if params[base+"Username"] != "user@isp" || params[base+"X_HW_VLAN"] != "100" {
+		t.Fatalf("queued params = %v", params)
+	}
+
+	// A config belonging to another device is not found
+	other := createTestDevice(t, h, "SN-OTHER", "ZTE")
+	vars["id"] = fmt.Sprint(other.ID)
+	if rec := serve(h.ApplyWANConfig, http.MethodPost, "", vars); rec.Code != http.StatusNotFound {
+		t.Fatalf("other device status = %d, want 404", rec.Code)
+	}
+}
+
+func TestApplyWANConfigUsesPackageVLAN(t *testing.T) {
+	h := newTestHandler(t, nil)
+	customer := createTestCustomer(t, h, "C001", "081200000001")
+	if _, err := h.DB.Exec(`UPDATE packages SET vlan = 300 WHERE id = ?`, customer.PackageID); err != nil {
+		t.Fatalf("set package vlan: %v", err)
+	}
+	assigned := createTestDevice(t, h, "SN-ASSIGNED", "Huawei")
+	if _, err := h.DB.Exec(`UPDATE devices SET customer_id = ? WHERE id = ?`, customer.ID, assigned.ID); err != nil {
+		t.Fatalf("assign device: %v", err)
+	}
+	unassigned := createTestDevice(t, h, "SN-STOCK", "Huawei")
+
+	const ppp = "InternetGatewayDevice.WANDevice.1.WANConnectionDevice.1.WANPPPConnection.1.X_HW_VLAN"
+	const ip = "InternetGatewayDevice.WANDevice.1.WANConnectionDevice.1.WANIPConnection.1.X_HW_VLAN"
+	for _, tc := range []struct {
+		name     string
+		device   *models.Device
+		config   models.WANConfig
+		path     string
+		wantVLAN string // "" = no VLAN parameter
+	}{
+		{"package VLAN", assigned, models.WANConfig{ConnectionType: "PPPoE", Username: "user@isp"}, ppp, "300"},
+		{"own VLAN wins", assigned, models.WANConfig{ConnectionType: "PPPoE", Username: "user@isp", VLAN: 100}, ppp, "100"},
+		{"bridge untouched", assigned, models.WANConfig{ConnectionType: "Bridge"}, ip, ""},
+		{"unassigned device", unassigned, models.WANConfig{ConnectionType: "PPPoE", Username: "user@isp"}, ppp, ""},
+	} {
+		config := tc.config
+		config.DeviceID, config.Name, config.Enabled = tc.device.ID, "INTERNET", true
+		created, err := h.DB.CreateWANConfig(&config)
+		if err != nil {
+			t.Fatalf("%s: CreateWANConfig: %v", tc.name, err)
+		}
+		vars := map[string]string{"id": fmt.Sprint(tc.device.ID), "wanId": fmt.Sprint(created.ID)}
+		if rec := serve(h.ApplyWANConfig, http.MethodPost, "", vars); rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d: %s", tc.name, rec.Code, rec.Body)
+		}
+
+		tasks, err := h.DB.GetPendingTasks(tc.device.ID)
+		if err != nil || len(tasks) == 0 {
+			t.Fatalf("%s: no task queued (%v)", tc.name, err)
+		}
+		var params map[string]string
+		json.Unmarshal(tasks[len(tasks)-1].Parameters, &params)
+		if got, ok := params[tc.path]; got != tc.wantVLAN || ok != (tc.wantVLAN != "") {
+			t.Errorf("%s: VLAN parameter = %q (set %v), want %q", tc.name, got, ok, tc.wantVLAN)
+		}
+		if _, err := h.DB.Exec(`DELETE FROM tasks WHERE device_id = ?`, tc.device.ID); err != nil {
+			t.Fatalf("clear tasks: %v", err)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"go-acs/internal/models"
+)
+
+func tripleplayTemplate() *models.WANTemplate {
+	return &models.WANTemplate{Name: "Triple Play", Connections: []models.WANTemplateConnection{
+		{WANIndex: 1, Name: "INTERNET", Service: "INTERNET", ConnectionType: "PPPoE", VLAN: 100, NATEnabled: true},
+		{WANIndex: 2, Name: "IPTV", Service: "IPTV", ConnectionType: "Bridge", VLAN: 200},
+		{WANIndex: 3, Name: "VOIP", Service: "VOIP", ConnectionType: "DHCP", VLAN: 300},
+	}}
+}
+
+func TestPlanWANTemplateAddsMissingConnections(t *testing.T) {
+	device := &models.Device{Manufacturer: "Huawei"}
+	tpl := tripleplayTemplate()
+	params := buildWANTemplateParams(device, tpl, "budi", "secret", 0)
+	existing := []*models.DeviceParameter{
+		{Path: wanConnectionDevices + "1.WANPPPConnection.1.Enable"},
+		{Path: wanConnectionDevices + "2.WANEthernetLinkConfig.EthernetLinkStatus"},
+	}
+
+	set, adds := planWANTemplate(tpl, params, existing)
+
+	if set[wanConnectionDevices+"1.WANPPPConnection.1.X_HW_VLAN"] != "100" || set[wanConnectionDevices+"1.WANPPPConnection.1.Username"] != "budi" {
+		t.Errorf("existing INTERNET connection not set directly: %v", set)
+	}
+	for path := range set {
+		if path[:len(wanConnectionDevices)+1] != wanConnectionDevices+"1" {
+			t.Errorf("value for a missing connection left in the set: %s", path)
+		}
+	}
+	if len(adds) != 2 {
+		t.Fatalf("adds = %d, want 2", len(adds))
+	}
+
+	iptv := adds[0]
+	if iptv.ObjectName != wanConnectionDevices+"2.WANIPConnection." || iptv.Then != nil {
+		t.Errorf("IPTV add = %+v, want a connection under WANConnectionDevice.2", iptv)
+	}
+	if iptv.Values["ConnectionType"] != "IP_Bridged" || iptv.Values["X_HW_VLAN"] != "200" || iptv.Values["X_HW_SERVICELIST"] != "IPTV" {
+		t.Errorf("IPTV values = %v", iptv.Values)
+	}
+
+	voip := adds[1]
+	if voip.ObjectName != wanConnectionDevices || voip.Then == nil || voip.Then.ObjectName != "WANIPConnection." {
+		t.Fatalf("VOIP add = %+v, want a new WANConnectionDevice with a WANIPConnection", voip)
+	}
+	if voip.Then.Values["AddressingType"] != "DHCP" || voip.Then.Values["X_HW_VLAN"] != "300" {
+		t.Errorf("VOIP values = %v", voip.Then.Values)
+	}
+}
+
+func TestApplyWANTemplateQueuesAddObject(t *testing.T) {
+	h := newTestHandler(t, nil)
+	device := createTestDevice(t, h, "SN001", "Huawei")
+	tpl, err := h.DB.CreateWANTemplate(tripleplayTemplate())
+	if err != nil {
+		t.Fatalf("CreateWANTemplate: %v", err)
+	}
+	vars := map[string]string{"id": strconv.FormatInt(device.ID, 10)}
+	body := `{"templateId":` + strconv.FormatInt(tpl.ID, 10) + `,"username":"budi","password":"secret"}`
+
+	if rec := serve(h.ApplyWANTemplate, http.MethodPost, body, vars); rec.Code != http.StatusConflict {
+		t.Errorf("without WAN parameters: status = %d, want %d", rec.Code, http.StatusConflict)
+	}
+
+	h.DB.SetDeviceParameter(device.ID, wanConnectionDevices+"1.WANPPPConnection.1.Enable", "1", "xsd:boolean", true)
+	rec := serve(h.ApplyWANTemplate, http.MethodPost, body, vars)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	tasks, err := h.DB.GetPendingTasks(device.ID)
+	if err != nil {
+		t.Fatalf("GetPendingTasks: %v", err)
+	}
+	types := map[models.TaskType]int{}
+	for _, task := range tasks {
+		types[task.Type]++
+		if task.Type == models.TaskAddObject {
+			var add models.AddObjectRequest
+			if err := json.Unmarshal(task.Parameters, &add); err != nil || add.ObjectName != wanConnectionDevices {
+				t.Errorf("addObject task = %s", task.Parameters)
+			}
+		}
+	}
+	if types[models.TaskSetParameterValues] != 1 || types[models.TaskAddObject] != 2 {
+		t.Errorf("queued tasks = %v, want 1 setParameterValues and 2 addObject", types)
+	}
+}
+
+func TestApplyWANTemplateRejectsTR181(t *testing.T) {
+	h := newTestHandler(t, nil)
+	device := createTestDevice(t, h, "SN001", "Huawei")
+	h.DB.SetDeviceParameter(device.ID, "Device.DeviceInfo.SerialNumber", "SN001", "xsd:string", false)
+	tpl, err := h.DB.CreateWANTemplate(tripleplayTemplate())
+	if err != nil {
+		t.Fatalf("CreateWANTemplate: %v", err)
+	}
+
+	body := `{"templateId":` + strconv.FormatInt(tpl.ID, 10) + `,"username":"budi"}`
+	rec := serve(h.ApplyWANTemplate, http.MethodPost, body, map[string]string{"id": strconv.FormatInt(device.ID, 10)})
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusUnprocessableEntity)
+	}
+}
+
+func TestWANTemplateInternetUsesPackageVLAN(t *testing.T) {
+	device := &models.Device{Manufacturer: "Huawei"}
+	tpl := tripleplayTemplate()
+	tpl.Connections[0].VLAN = 0 // INTERNET without a VLAN of its own
+	tpl.Connections[2].VLAN = 0 // VOIP without a VLAN stays untagged
+
+	params := buildWANTemplateParams(device, tpl, "budi", "secret", 500)
+	if got := params[wanConnectionDevices+"1.WANPPPConnection.1.X_HW_VLAN"]; got != "500" {
+		t.Errorf("INTERNET VLAN = %q, want the package VLAN 500", got)
+	}
+	for path, value := range params {
+		if strings.HasPrefix(path, wanConnectionDevices+"3.") && strings.HasSuffix(path, "X_HW_VLAN") {
+			t.Errorf("VOIP got VLAN %s, want none", value)
+		}
+	}
+	if got := params[wanConnectionDevices+"2.WANIPConnection.1.X_HW_VLAN"]; got != "200" {
+		t.Errorf("IPTV VLAN = %q, want its own 200", got)
+	}
+
+	tpl.Connections[0].VLAN = 100
+	params = buildWANTemplateParams(device, tpl, "budi", "secret", 500)
+	if got := params[wanConnectionDevices+"1.WANPPPConnection.1.X_HW_VLAN"]; got != "100" {
+		t.Errorf("INTERNET VLAN = %q, want the template's own 100", got)
+	}
+}
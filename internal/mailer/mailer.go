@@ -17,6 +17,9 @@ type Config struct {
 // Mailer handles email sending
 type Mailer struct {
 	config Config
+
+	// TestMode, when set and returning true, logs emails instead of sending them
+	TestMode func() bool
 }
 
 // New creates a new Mailer
@@ -27,7 +30,7 @@ func New(config Config) *Mailer {
 // Send sends an email
 func (m *Mailer) Send(to string, subject string, body string) error {
 	// If no config, just log (mock mode)
-	if m.config.Host == "" {
+	if m.config.Host == "" || (m.TestMode != nil && m.TestMode()) {
 		fmt.Printf("[MOCK MAIL] To: %s | Subject: %s | Body length: %d\n", to, subject, len(body))
 		return nil
 	}
@@ -63,6 +66,25 @@ func GenerateInvoiceHTML(customerName, invoiceNo, dueDate, totals string) string
 	`, customerName, invoiceNo, totals, dueDate)
 }
 
+// GenerateInvoiceReminderHTML generates HTML for a due-date or overdue reminder;
+// status describes the invoice, e.g. "is due in 3 days" or "is overdue"
+func GenerateInvoiceReminderHTML(customerName, invoiceNo, dueDate, totals, status string) string {
+	return fmt.Sprintf(`
+		<html>
+		<body>
+			<h2>Invoice Reminder</h2>
+			<p>Dear %s,</p>
+			<p>Your invoice <strong>%s</strong> %s.</p>
+			<p><strong>Total Amount:</strong> %s</p>
+			<p><strong>Due Date:</strong> %s</p>
+			<p>Please make payment to avoid service interruption. Ignore this message if you have already paid.</p>
+			<br>
+			<p>Thank you,<br>GO-ACS Team</p>
+		</body>
+		</html>
+	`, customerName, invoiceNo, status, totals, dueDate)
+}
+
 // GeneratePaymentReceiptHTML generates HTML for payment receipt
 func GeneratePaymentReceiptHTML(customerName, invoiceNo, amount, paidDate string) string {
 	return fmt.Sprintf(`
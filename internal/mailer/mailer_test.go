@@ -0,0 +1,48 @@
+package mailer
+
+import (
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+func TestTestModeSendsNoMail(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer ln.Close()
+	var dials atomic.Int32
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			dials.Add(1)
+			conn.Close()
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	p, _ := strconv.Atoi(port)
+	m := New(Config{Host: host, Port: p, From: "billing@isp.local"})
+	testMode := true
+	m.TestMode = func() bool { return testMode }
+
+	if err := m.Send("budi@example.com", "Invoice", "<p>INV-1</p>"); err != nil {
+		t.Fatalf("Send in test mode: %v", err)
+	}
+	if n := dials.Load(); n != 0 {
+		t.Fatalf("SMTP server dialed %d times in test mode", n)
+	}
+
+	testMode = false
+	if err := m.Send("budi@example.com", "Invoice", "<p>INV-1</p>"); err == nil {
+		t.Fatal("Send succeeded against a server that hangs up")
+	}
+	if n := dials.Load(); n != 1 {
+		t.Errorf("SMTP server dialed %d times with test mode off, want 1", n)
+	}
+}
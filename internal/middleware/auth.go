@@ -24,10 +24,11 @@ type Claims struct {
 func AuthMiddleware(jwtSecret string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// Skip auth for login and public endpoints
+			// Skip auth for login and public endpoints. The customer portal
+			// API authenticates with its own tokens (CustomerAuthMiddleware).
 			if strings.HasPrefix(r.URL.Path, "/api/auth/login") ||
-				strings.HasPrefix(r.URL.Path, "/api/portal/auth/login") ||
-				strings.HasPrefix(r.URL.Path, "/api/callbacks/") ||
+				strings.HasPrefix(r.URL.Path, "/api/portal/") ||
+				isGatewayCallback(r.URL.Path) ||
 				r.URL.Path == "/health" ||
 				r.URL.Path == "/favicon.ico" {
 				next.ServeHTTP(w, r)
@@ -58,7 +59,7 @@ func AuthMiddleware(jwtSecret string) func(http.Handler) http.Handler {
 				return []byte(jwtSecret), nil
 			})
 
-			if err != nil || !token.Valid {
+			if err != nil || !token.Valid || claims.VerifyAudience(PortalAudience, true) {
 				http.Error(w, "Invalid token", http.StatusUnauthorized)
 				return
 			}
@@ -70,10 +71,66 @@ func AuthMiddleware(jwtSecret string) func(http.Handler) http.Handler {
 	}
 }
 
+// isGatewayCallback reports whether path is a public payment gateway webhook.
+// Admin operations under /api/callbacks/ (e.g. reprocess) still require a token.
+func isGatewayCallback(path string) bool {
+	return strings.HasPrefix(path, "/api/callbacks/") && !strings.HasSuffix(path, "/reprocess")
+}
+
 // GetUserFromContext retrieves user claims from context
 func GetUserFromContext(ctx context.Context) *Claims {
 	if claims, ok := ctx.Value(userContextKey).(*Claims); ok {
 		return claims
 	}
 	return nil
+}
+
+// Admin user roles. Roles other than these (e.g. the legacy "user" default)
+// are treated like RoleViewer.
+const (
+	RoleAdmin    = "admin"    // Everything, including system update, settings and user management
+	RoleOperator = "operator" // Manage devices, customers and billing
+	RoleViewer   = "viewer"   // Read-only
+)
+
+// RequireRole rejects requests whose JWT role is not one of roles with 403.
+// It must run behind AuthMiddleware; requests without claims reached the
+// router through one of its public paths and are passed through.
+func RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims := GetUserFromContext(r.Context())
+			if claims != nil && !hasRole(claims.Role, roles) {
+				http.Error(w, "Insufficient permissions", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireRoleForWrites is RequireRole for state-changing requests only;
+// GET, HEAD and OPTIONS are allowed for every authenticated user.
+func RequireRoleForWrites(roles ...string) func(http.Handler) http.Handler {
+	requireRole := RequireRole(roles...)
+	return func(next http.Handler) http.Handler {
+		guarded := requireRole(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+				next.ServeHTTP(w, r)
+			default:
+				guarded.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+func hasRole(role string, roles []string) bool {
+	for _, r := range roles {
+		if role == r {
+			return true
+		}
+	}
+	return false
 }
\ No newline at end of file
@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const customerContextKey contextKey = "customer"
+
+// PortalAudience is the audience of customer portal tokens. AuthMiddleware
+// rejects them, so a customer session never reaches the admin API.
+const PortalAudience = "portal"
+
+// CustomerClaims represents the JWT claims of a customer portal session
+type CustomerClaims struct {
+	CustomerID int64 `json:"customer_id"`
+	jwt.RegisteredClaims
+}
+
+// CustomerAuthMiddleware validates customer portal tokens and stores the
+// customer ID in the request context. Staff tokens are rejected.
+func CustomerAuthMiddleware(jwtSecret string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tokenString := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if tokenString == "" || tokenString == r.Header.Get("Authorization") {
+				http.Error(w, "Authorization header required", http.StatusUnauthorized)
+				return
+			}
+
+			claims := &CustomerClaims{}
+			token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+				if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+					return nil, jwt.ErrSignatureInvalid
+				}
+				return []byte(jwtSecret), nil
+			})
+			if err != nil || !token.Valid || !claims.VerifyAudience(PortalAudience, true) || claims.CustomerID <= 0 {
+				http.Error(w, "Invalid token", http.StatusUnauthorized)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), customerContextKey, claims.CustomerID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// GetCustomerFromContext returns the customer ID of the portal session, or 0
+func GetCustomerFromContext(ctx context.Context) int64 {
+	id, _ := ctx.Value(customerContextKey).(int64)
+	return id
+}
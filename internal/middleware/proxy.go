@@ -0,0 +1,96 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TrustsProxy reports whether the peer of r is listed in trusted, a
+// comma-separated list of IP addresses and CIDR ranges (e.g.
+// "127.0.0.1,10.0.0.0/8"). An empty list trusts no one.
+func TrustsProxy(r *http.Request, trusted string) bool {
+	return trustsIP(remoteIP(r), trusted)
+}
+
+// ExternalBaseURL returns the scheme://host the client used to reach the
+// server. X-Forwarded-Proto and X-Forwarded-Host are only honoured when the
+// request came from a trusted proxy; forwarded reports whether they were.
+func ExternalBaseURL(r *http.Request, trusted string) (base string, forwarded bool) {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	host := r.Host
+
+	if TrustsProxy(r, trusted) {
+		if proto := firstHeaderValue(r, "X-Forwarded-Proto"); proto == "http" || proto == "https" {
+			scheme = proto
+			forwarded = true
+		}
+		if fwdHost := firstHeaderValue(r, "X-Forwarded-Host"); fwdHost != "" {
+			host = fwdHost
+			forwarded = true
+		}
+	}
+	return scheme + "://" + host, forwarded
+}
+
+// ClientIP returns the address of the client behind any trusted proxies: the
+// right-most X-Forwarded-For entry that is not itself a trusted proxy, or the
+// peer address when the request did not come through one.
+func ClientIP(r *http.Request, trusted string) string {
+	ip := remoteIP(r)
+	if !trustsIP(ip, trusted) {
+		return ip
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		ip = hop
+		if !trustsIP(hop, trusted) {
+			break
+		}
+	}
+	return ip
+}
+
+// remoteIP returns the peer IP of r without the port
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func trustsIP(ip, trusted string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, entry := range strings.Split(trusted, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if strings.Contains(entry, "/") {
+			if _, network, err := net.ParseCIDR(entry); err == nil && network.Contains(addr) {
+				return true
+			}
+		} else if proxy := net.ParseIP(entry); proxy != nil && proxy.Equal(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// firstHeaderValue returns the first comma-separated value of a header, as
+// set by the proxy nearest the client
+func firstHeaderValue(r *http.Request, name string) string {
+	v, _, _ := strings.Cut(r.Header.Get(name), ",")
+	return strings.ToLower(strings.TrimSpace(v))
+}
@@ -0,0 +1,111 @@
+package middleware
+
+import (
+	"crypto/tls"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestExternalBaseURL(t *testing.T) {
+	const trusted = "127.0.0.1, 10.0.0.0/8"
+	tests := []struct {
+		name      string
+		peer      string
+		tls       bool
+		headers   map[string]string
+		want      string
+		forwarded bool
+	}{
+		{"direct", "203.0.113.9:5000", false, nil, "http://acs.local:8080", false},
+		{"direct TLS", "203.0.113.9:5000", true, nil, "https://acs.local:8080", false},
+		{"trusted proxy", "127.0.0.1:5000", false,
+			map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "acs.example.net"}, "https://acs.example.net", true},
+		{"trusted CIDR", "10.20.30.40:5000", false,
+			map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "acs.example.net"}, "https://acs.example.net", true},
+		{"nearest proxy's values", "10.0.0.2:5000", false,
+			map[string]string{"X-Forwarded-Proto": "HTTPS, http", "X-Forwarded-Host": "acs.example.net, internal:8080"}, "https://acs.example.net", true},
+		{"proto only", "127.0.0.1:5000", false, map[string]string{"X-Forwarded-Proto": "https"}, "https://acs.local:8080", true},
+		{"unknown proto ignored", "127.0.0.1:5000", false, map[string]string{"X-Forwarded-Proto": "javascript"}, "http://acs.local:8080", false},
+		// Anyone else can't redirect links by spoofing the headers
+		{"spoofed by a client", "203.0.113.9:5000", false,
+			map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "evil.example"}, "http://acs.local:8080", false},
+		{"just outside the CIDR", "11.0.0.1:5000", false,
+			map[string]string{"X-Forwarded-Host": "evil.example"}, "http://acs.local:8080", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "http://acs.local:8080/portal", nil)
+		r.RemoteAddr = tt.peer
+		if tt.tls {
+			r.TLS = &tls.ConnectionState{}
+		}
+		for k, v := range tt.headers {
+			r.Header.Set(k, v)
+		}
+		got, forwarded := ExternalBaseURL(r, trusted)
+		if got != tt.want || forwarded != tt.forwarded {
+			t.Errorf("%s: ExternalBaseURL = %q, %v; want %q, %v", tt.name, got, forwarded, tt.want, tt.forwarded)
+		}
+	}
+
+	// Without trusted proxies no forwarded header is honoured
+	r := httptest.NewRequest("GET", "http://acs.local:8080/", nil)
+	r.RemoteAddr = "127.0.0.1:5000"
+	r.Header.Set("X-Forwarded-Host", "acs.example.net")
+	if got, forwarded := ExternalBaseURL(r, ""); got != "http://acs.local:8080" || forwarded {
+		t.Errorf("no trusted proxies: ExternalBaseURL = %q, %v", got, forwarded)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	const trusted = "127.0.0.1,10.0.0.0/8"
+	tests := []struct {
+		name string
+		peer string
+		xff  []string
+		want string
+	}{
+		{"direct", "203.0.113.9:5000", nil, "203.0.113.9"},
+		{"direct with spoofed header", "203.0.113.9:5000", []string{"1.2.3.4"}, "203.0.113.9"},
+		{"trusted proxy", "127.0.0.1:5000", []string{"198.51.100.7"}, "198.51.100.7"},
+		{"trusted proxy without header", "127.0.0.1:5000", nil, "127.0.0.1"},
+		{"chain of trusted proxies", "127.0.0.1:5000", []string{"198.51.100.7, 10.1.1.1, 10.2.2.2"}, "198.51.100.7"},
+		// The client can prepend anything; only hops added by trusted proxies count
+		{"spoofed left-most entry", "10.0.0.2:5000", []string{"1.2.3.4, 198.51.100.7"}, "198.51.100.7"},
+		{"headers split across lines", "10.0.0.2:5000", []string{"1.2.3.4", "198.51.100.7, 10.3.3.3"}, "198.51.100.7"},
+		{"garbage stops the walk", "10.0.0.2:5000", []string{"198.51.100.7, not-an-ip"}, "10.0.0.2"},
+		{"all hops trusted", "10.0.0.2:5000", []string{"10.9.9.9"}, "10.9.9.9"},
+		{"IPv6 peer", "[2001:db8::1]:5000", []string{"1.2.3.4"}, "2001:db8::1"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.peer
+		for _, v := range tt.xff {
+			r.Header.Add("X-Forwarded-For", v)
+		}
+		if got := ClientIP(r, trusted); got != tt.want {
+			t.Errorf("%s: ClientIP = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTrustsProxy(t *testing.T) {
+	tests := []struct {
+		peer, trusted string
+		want          bool
+	}{
+		{"127.0.0.1:1", "127.0.0.1", true},
+		{"127.0.0.2:1", "127.0.0.1", false},
+		{"10.255.0.1:1", " 192.168.1.1 , 10.0.0.0/8 ", true},
+		{"192.168.2.1:1", "192.168.1.0/24", false},
+		{"[2001:db8::5]:1", "2001:db8::/32", true},
+		{"127.0.0.1:1", "", false},
+		{"127.0.0.1:1", "not-an-ip, 10.0.0.0/33", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.peer
+		if got := TrustsProxy(r, tt.trusted); got != tt.want {
+			t.Errorf("TrustsProxy(%s, %q) = %v, want %v", tt.peer, tt.trusted, got, tt.want)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package mikrotik
 
 import (
+	"errors"
 	"fmt"
 	"go-acs/internal/config"
 
@@ -20,8 +21,23 @@ func New(cfg *config.Config) *Client {
 	return &Client{cfg: cfg}
 }
 
+// ErrTestMode is returned by reads while test mode keeps the router untouched
+var ErrTestMode = errors.New("MikroTik is disabled in test mode")
+
+// skipInTestMode logs a router change instead of making it when test mode is on
+func (c *Client) skipInTestMode(action string, args ...string) bool {
+	if !c.cfg.TestMode {
+		return false
+	}
+	fmt.Printf("[TEST MODE] MikroTik %s skipped\n", strings.Join(append([]string{action}, args...), " "))
+	return true
+}
+
 // connect establishes a connection to the router
 func (c *Client) connect() (*routeros.Client, error) {
+	if c.cfg.TestMode {
+		return nil, ErrTestMode
+	}
 	if c.cfg.MikrotikHost == "" {
 		return nil, fmt.Errorf("MikroTik host not configured")
 	}
@@ -36,6 +52,9 @@ func (c *Client) connect() (*routeros.Client, error) {
 
 // CreatePPPProfile creates or updates a PPP profile
 func (c *Client) SyncPPPProfile(name, rateLimit string) error {
+	if c.skipInTestMode("sync profile", name, rateLimit) {
+		return nil
+	}
 	client, err := c.connect()
 	if err != nil {
 		return err // In real app, maybe just log error if MT is offline
@@ -60,6 +79,42 @@ func (c *Client) SyncPPPProfile(name, rateLimit string) error {
 	return err
 }
 
+// RateLimit builds a PPP profile rate-limit from package speeds in Mbps.
+// RouterOS reads rate-limit as rx/tx from the router's side: rx is what the
+// router receives from the subscriber (upload), tx is what it sends to the
+// subscriber (download), so the string is "upload/download".
+func RateLimit(uploadMbps, downloadMbps int) (string, error) {
+	if uploadMbps <= 0 || downloadMbps <= 0 {
+		return "", fmt.Errorf("upload and download speed must be greater than 0 Mbps (got %d/%d)", uploadMbps, downloadMbps)
+	}
+	return fmt.Sprintf("%dM/%dM", uploadMbps, downloadMbps), nil
+}
+
+// ValidateRateLimit checks the rx/tx part of a RouterOS rate-limit string,
+// e.g. "10M/20M" or "512k/1M 1M/2M 512k/1M 8/8". Optional burst fields are
+// passed through unchecked.
+func ValidateRateLimit(rateLimit string) error {
+	fields := strings.Fields(rateLimit)
+	if len(fields) == 0 {
+		return fmt.Errorf("rate limit is required")
+	}
+	parts := strings.Split(fields[0], "/")
+	if len(parts) != 2 {
+		return fmt.Errorf("rate limit must be in rx/tx form, e.g. 10M/20M")
+	}
+	for _, p := range parts {
+		num := strings.TrimRight(p, "kKM")
+		if len(p)-len(num) > 1 {
+			return fmt.Errorf("invalid rate %q", p)
+		}
+		n, err := strconv.Atoi(num)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid rate %q, must be a positive number with optional k/M suffix", p)
+		}
+	}
+	return nil
+}
+
 // GetPPPProfiles retrieves all PPP profiles
 func (c *Client) GetPPPProfiles() ([]map[string]string, error) {
 	client, err := c.connect()
@@ -149,6 +204,9 @@ func (c *Client) GetSystemResource() (map[string]string, error) {
 
 // SetPPPProfile changes the PPP profile for a specific user
 func (c *Client) SetPPPProfile(username, profile string) error {
+	if c.skipInTestMode("set profile", username, profile) {
+		return nil
+	}
 	client, err := c.connect()
 	if err != nil {
 		return err
@@ -173,6 +231,56 @@ func (c *Client) SetPPPProfile(username, profile string) error {
 	return err
 }
 
+// CreatePPPSecret adds a PPPoE secret; it fails if the name is already taken
+func (c *Client) CreatePPPSecret(username, password, profile string) error {
+	if c.skipInTestMode("add secret", username, profile) {
+		return nil
+	}
+	client, err := c.connect()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	res, err := client.Run("/ppp/secret/print", "?name="+username)
+	if err != nil {
+		return err
+	}
+	if len(res.Re) > 0 {
+		return fmt.Errorf("PPP secret already exists for user: %s", username)
+	}
+
+	args := []string{"/ppp/secret/add", "=name=" + username, "=password=" + password, "=service=pppoe"}
+	if profile != "" {
+		args = append(args, "=profile="+profile)
+	}
+	_, err = client.Run(args...)
+	return err
+}
+
+// RemovePPPSecret deletes the PPPoE secret for a user, if present
+func (c *Client) RemovePPPSecret(username string) error {
+	if c.skipInTestMode("remove secret", username) {
+		return nil
+	}
+	client, err := c.connect()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	res, err := client.Run("/ppp/secret/print", "?name="+username)
+	if err != nil {
+		return err
+	}
+	for _, re := range res.Re {
+		if _, err := client.Run("/ppp/secret/remove", "=.id="+re.Map["_id"]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // GetPPPUsers retrieves all PPP users
 func (c *Client) GetPPPUsers() ([]map[string]string, error) {
 	client, err := c.connect()
@@ -195,6 +303,9 @@ func (c *Client) GetPPPUsers() ([]map[string]string, error) {
 
 // CreateIsolirProfile creates an isolir PPP profile with limited bandwidth
 func (c *Client) CreateIsolirProfile(name, rateLimit string) error {
+	if c.skipInTestMode("create isolir profile", name, rateLimit) {
+		return nil
+	}
 	client, err := c.connect()
 	if err != nil {
 		return err
@@ -221,6 +332,9 @@ func (c *Client) CreateIsolirProfile(name, rateLimit string) error {
 
 // DisconnectPPPUser disconnects an active PPP session for a specific user
 func (c *Client) DisconnectPPPUser(username string) error {
+	if c.skipInTestMode("disconnect", username) {
+		return nil
+	}
 	client, err := c.connect()
 	if err != nil {
 		return err
@@ -248,6 +362,9 @@ func (c *Client) DisconnectPPPUser(username string) error {
 
 // DisconnectAllPPPUsers disconnects all active PPP sessions
 func (c *Client) DisconnectAllPPPUsers() error {
+	if c.skipInTestMode("disconnect all") {
+		return nil
+	}
 	client, err := c.connect()
 	if err != nil {
 		return err
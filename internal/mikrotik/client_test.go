@@ -0,0 +1,50 @@
+package mikrotik
+
+import "testing"
+
+func TestRateLimit(t *testing.T) {
+	tests := []struct {
+		upload, download int
+		want             string
+		ok               bool
+	}{
+		// Upload is what the router receives (rx), download what it sends (tx)
+		{5, 20, "5M/20M", true},
+		{10, 10, "10M/10M", true},
+		{0, 20, "", false},
+		{5, 0, "", false},
+		{-1, 20, "", false},
+	}
+	for _, tt := range tests {
+		got, err := RateLimit(tt.upload, tt.download)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("RateLimit(%d, %d) = %q, %v; want %q ok %v", tt.upload, tt.download, got, err, tt.want, tt.ok)
+		}
+		if err == nil {
+			if err := ValidateRateLimit(got); err != nil {
+				t.Errorf("RateLimit(%d, %d) = %q fails validation: %v", tt.upload, tt.download, got, err)
+			}
+		}
+	}
+}
+
+func TestValidateRateLimit(t *testing.T) {
+	for rateLimit, ok := range map[string]bool{
+		"10M/20M":                   true,
+		"512k/1M":                   true,
+		"512000/1024000":            true,
+		"512k/1M 1M/2M 512k/1M 8/8": true,
+		"":                          false,
+		"10M":                       false,
+		"10M/20M/30M":               false,
+		"0M/20M":                    false,
+		"-5M/20M":                   false,
+		"10G/20M":                   false,
+		"10MM/20M":                  false,
+		"fast/slow":                 false,
+	} {
+		if err := ValidateRateLimit(rateLimit); (err == nil) != ok {
+			t.Errorf("ValidateRateLimit(%q) = %v, want ok %v", rateLimit, err, ok)
+		}
+	}
+}
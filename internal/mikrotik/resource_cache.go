@@ -0,0 +1,45 @@
+package mikrotik
+
+import (
+	"sync"
+	"time"
+)
+
+// ResourceCache serves /system/resource from memory and refreshes it from the
+// router at most once per TTL, so dashboard auto-refresh in many browser tabs
+// costs one API login per interval instead of one per request. Failures are
+// cached too, which keeps an unreachable router from being dialed repeatedly.
+type ResourceCache struct {
+	fetch func() (map[string]string, error)
+	ttl   time.Duration
+
+	mu        sync.Mutex
+	resource  map[string]string
+	err       error
+	fetchedAt time.Time
+}
+
+// NewResourceCache creates a cache in front of client.GetSystemResource
+func NewResourceCache(client *Client, ttl time.Duration) *ResourceCache {
+	return &ResourceCache{fetch: client.GetSystemResource, ttl: ttl}
+}
+
+// Get returns the cached resource, refreshing it first when older than the
+// TTL. Concurrent callers share one refresh.
+func (rc *ResourceCache) Get() (map[string]string, time.Time, error) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rc.fetchedAt.IsZero() || time.Since(rc.fetchedAt) >= rc.ttl {
+		rc.resource, rc.err = rc.fetch()
+		rc.fetchedAt = time.Now()
+	}
+	return rc.resource, rc.fetchedAt, rc.err
+}
+
+// Invalidate forces the next Get to query the router
+func (rc *ResourceCache) Invalidate() {
+	rc.mu.Lock()
+	rc.fetchedAt = time.Time{}
+	rc.mu.Unlock()
+}
@@ -0,0 +1,93 @@
+package mikrotik
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingFetch stands in for the router and counts how often it is queried
+type countingFetch struct {
+	mu    sync.Mutex
+	calls int
+	err   error
+}
+
+func (f *countingFetch) fetch() (map[string]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return map[string]string{"cpu-load": "7", "version": "7.14"}, nil
+}
+
+func (f *countingFetch) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+func TestResourceCacheServesReadsWithinTTL(t *testing.T) {
+	router := &countingFetch{}
+	rc := &ResourceCache{fetch: router.fetch, ttl: time.Hour}
+
+	first, fetchedAt, err := rc.Get()
+	if err != nil || first["cpu-load"] != "7" {
+		t.Fatalf("Get = %v, %v", first, err)
+	}
+	for i := 0; i < 10; i++ {
+		_, at, _ := rc.Get()
+		if !at.Equal(fetchedAt) {
+			t.Fatalf("fetchedAt changed within the TTL")
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rc.Get()
+		}()
+	}
+	wg.Wait()
+	if n := router.count(); n != 1 {
+		t.Fatalf("router queried %d times within the TTL, want 1", n)
+	}
+
+	rc.Invalidate()
+	rc.Get()
+	if n := router.count(); n != 2 {
+		t.Errorf("router queried %d times after Invalidate, want 2", n)
+	}
+}
+
+func TestResourceCacheRefreshesAfterTTL(t *testing.T) {
+	router := &countingFetch{}
+	rc := &ResourceCache{fetch: router.fetch, ttl: 20 * time.Millisecond}
+
+	rc.Get()
+	time.Sleep(30 * time.Millisecond)
+	rc.Get()
+	rc.Get()
+	if n := router.count(); n != 2 {
+		t.Errorf("router queried %d times, want once per TTL (2)", n)
+	}
+}
+
+func TestResourceCacheCachesFailures(t *testing.T) {
+	router := &countingFetch{err: errors.New("dial tcp: i/o timeout")}
+	rc := &ResourceCache{fetch: router.fetch, ttl: time.Hour}
+
+	for i := 0; i < 3; i++ {
+		if _, _, err := rc.Get(); err == nil {
+			t.Fatal("Get succeeded against an unreachable router")
+		}
+	}
+	if n := router.count(); n != 1 {
+		t.Errorf("unreachable router dialed %d times, want 1", n)
+	}
+}
@@ -0,0 +1,80 @@
+package mikrotik
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"go-acs/internal/config"
+)
+
+// routerListener accepts and counts connections on a local port standing in
+// for the router API
+func routerListener(t *testing.T) (*config.Config, *atomic.Int32) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	var dials atomic.Int32
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			dials.Add(1)
+			conn.Close()
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return &config.Config{MikrotikHost: host, MikrotikPort: p, MikrotikUser: "admin", TestMode: true}, &dials
+}
+
+func TestTestModeNeverDialsTheRouter(t *testing.T) {
+	cfg, dials := routerListener(t)
+	c := New(cfg)
+
+	for name, change := range map[string]func() error{
+		"SyncPPPProfile":        func() error { return c.SyncPPPProfile("10M", "5M/10M") },
+		"SetPPPProfile":         func() error { return c.SetPPPProfile("budi", "isolir") },
+		"CreatePPPSecret":       func() error { return c.CreatePPPSecret("budi", "secret", "10M") },
+		"RemovePPPSecret":       func() error { return c.RemovePPPSecret("budi") },
+		"CreateIsolirProfile":   func() error { return c.CreateIsolirProfile("isolir", "1M/1M") },
+		"DisconnectPPPUser":     func() error { return c.DisconnectPPPUser("budi") },
+		"DisconnectAllPPPUsers": c.DisconnectAllPPPUsers,
+	} {
+		if err := change(); err != nil {
+			t.Errorf("%s in test mode = %v, want a logged no-op", name, err)
+		}
+	}
+
+	if _, err := c.GetSystemResource(); !errors.Is(err, ErrTestMode) {
+		t.Errorf("GetSystemResource err = %v, want ErrTestMode", err)
+	}
+	if _, err := c.GetPPPUsers(); !errors.Is(err, ErrTestMode) {
+		t.Errorf("GetPPPUsers err = %v, want ErrTestMode", err)
+	}
+	if _, err := c.GetPPPProfiles(); !errors.Is(err, ErrTestMode) {
+		t.Errorf("GetPPPProfiles err = %v, want ErrTestMode", err)
+	}
+	if _, err := c.GetQueueStats("budi"); !errors.Is(err, ErrTestMode) {
+		t.Errorf("GetQueueStats err = %v, want ErrTestMode", err)
+	}
+
+	if n := dials.Load(); n != 0 {
+		t.Errorf("router dialed %d times in test mode", n)
+	}
+
+	// The listener does see the router being dialed once test mode is off
+	cfg.TestMode = false
+	c.GetSystemResource()
+	if dials.Load() == 0 {
+		t.Error("router not dialed with test mode off")
+	}
+}
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProrateFirstMonth(t *testing.T) {
+	september := time.Date(2026, time.September, 1, 0, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name     string
+		join     time.Time
+		amount   float64
+		days     int
+		prorated bool
+	}{
+		{"20th of a 30-day month", time.Date(2026, time.September, 20, 9, 0, 0, 0, time.UTC), 110000, 11, true},
+		{"last day", time.Date(2026, time.September, 30, 0, 0, 0, 0, time.UTC), 10000, 1, true},
+		{"joined on the 1st", time.Date(2026, time.September, 1, 0, 0, 0, 0, time.UTC), 300000, 0, false},
+		{"joined a month earlier", time.Date(2026, time.August, 20, 0, 0, 0, 0, time.UTC), 300000, 0, false},
+	} {
+		amount, days, daysInMonth, prorated := ProrateFirstMonth(300000, tc.join, september)
+		if amount != tc.amount || days != tc.days || prorated != tc.prorated {
+			t.Errorf("%s: got %v for %d day(s), prorated %v; want %v for %d, prorated %v",
+				tc.name, amount, days, prorated, tc.amount, tc.days, tc.prorated)
+		}
+		if prorated && daysInMonth != 30 {
+			t.Errorf("%s: daysInMonth = %d, want 30", tc.name, daysInMonth)
+		}
+	}
+}
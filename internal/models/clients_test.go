@@ -0,0 +1,58 @@
+package models
+
+import "testing"
+
+func wlanParams(values map[string]string) []*DeviceParameter {
+	params := make([]*DeviceParameter, 0, len(values))
+	for path, v := range values {
+		params = append(params, &DeviceParameter{Path: "InternetGatewayDevice.LANDevice.1.WLANConfiguration." + path, Value: v})
+	}
+	return params
+}
+
+func TestWiFiClientLimit(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		params map[string]string
+		want   int
+	}{
+		{"WLAN 1 wins over other SSIDs", map[string]string{
+			"1.MaxAssociatedDevices": "16", "1.Enable": "1",
+			"2.MaxAssociatedDevices": "32", "2.Enable": "1",
+			"5.MaxAssociatedDevices": "8", "5.Enable": "0",
+		}, 16},
+		{"enabled WLANs without WLAN 1", map[string]string{
+			"2.MaxAssociatedDevices": "10", "2.Enable": "true",
+			"3.MaxAssociatedDevices": "20", "3.Enable": "false",
+			"4.MaxAssociatedDevices": "5", "4.Enable": "1",
+		}, 15},
+		{"no limit", map[string]string{"1.Enable": "1"}, 0},
+	} {
+		if got := WiFiClientLimit(wlanParams(tc.params)); got != tc.want {
+			t.Errorf("%s: limit = %d, want %d", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestClientLimitLevel(t *testing.T) {
+	for _, tc := range []struct {
+		clients, limit int
+		percent        float64
+		want           string
+	}{
+		{7, 10, 80, ""},
+		{8, 10, 80, ClientLimitNear},
+		{9, 10, 80, ClientLimitNear},
+		{10, 10, 80, ClientLimitOver},
+		{12, 10, 80, ClientLimitOver},
+		{12, 0, 80, ""},
+		{12, 10, 0, ""},
+	} {
+		if got := ClientLimitLevel(tc.clients, tc.limit, tc.percent); got != tc.want {
+			t.Errorf("ClientLimitLevel(%d, %d, %v) = %q, want %q", tc.clients, tc.limit, tc.percent, got, tc.want)
+		}
+	}
+	if !ClientLimitWorsened(ClientLimitNear, ClientLimitOver) || ClientLimitWorsened(ClientLimitOver, ClientLimitNear) {
+		t.Error("ClientLimitWorsened ranks near below over")
+	}
+}
@@ -0,0 +1,52 @@
+package models
+
+import "testing"
+
+func TestCurrencyRound(t *testing.T) {
+	tests := []struct {
+		name   string
+		c      Currency
+		amount float64
+		want   float64
+	}{
+		{"rupiah to 1", Currency{Decimals: 0, Rounding: 1}, 150000.49, 150000},
+		{"rupiah half up", Currency{Decimals: 0, Rounding: 1}, 150000.5, 150001},
+		{"rupiah to 100", Currency{Decimals: 0, Rounding: 100}, 123450, 123500},
+		{"rupiah to 1000", Currency{Decimals: 0, Rounding: 1000}, 123499, 123000},
+		{"rupiah no step", Currency{Decimals: 0}, 99.6, 100},
+		{"dollar cents", Currency{Decimals: 2}, 10.005, 10.01},
+		{"dollar to 0.05", Currency{Decimals: 2, Rounding: 0.05}, 10.03, 10.05},
+		{"dollar keeps cents", Currency{Decimals: 2, Rounding: 0.01}, 19.99, 19.99},
+	}
+	for _, tt := range tests {
+		if got := tt.c.Round(tt.amount); got != tt.want {
+			t.Errorf("%s: Round(%v) = %v, want %v", tt.name, tt.amount, got, tt.want)
+		}
+	}
+}
+
+func TestCurrencyFormat(t *testing.T) {
+	rupiah := Currency{Symbol: "Rp", Decimals: 0, Rounding: 1}
+	dollar := Currency{Symbol: "$", Decimals: 2}
+	tests := []struct {
+		c      Currency
+		amount float64
+		want   string
+	}{
+		{rupiah, 150000, "Rp 150.000"},
+		{rupiah, 1234567.4, "Rp 1.234.567"},
+		{rupiah, 999, "Rp 999"},
+		{rupiah, 0, "Rp 0"},
+		{rupiah, -25000, "-Rp 25.000"},
+		{rupiah, -0.4, "Rp 0"},
+		{dollar, 1234.5, "$ 1,234.50"},
+		{dollar, 0.07, "$ 0.07"},
+		{dollar, -1000000, "-$ 1,000,000.00"},
+		{Currency{Decimals: 0}, 5000, "5.000"},
+	}
+	for _, tt := range tests {
+		if got := tt.c.Format(tt.amount); got != tt.want {
+			t.Errorf("%+v Format(%v) = %q, want %q", tt.c, tt.amount, got, tt.want)
+		}
+	}
+}
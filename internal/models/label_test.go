@@ -0,0 +1,29 @@
+package models
+
+import "testing"
+
+func TestDeviceLabel(t *testing.T) {
+	customer := &Customer{Name: "Budi", Address: "Jl. Melati 5", CustomerCode: "C001", Phone: "0812"}
+	device := &Device{SerialNumber: "ZTEG123", Template: "budi@net"}
+
+	for _, tc := range []struct {
+		name string
+		tpl  string
+		c    *Customer
+		want string
+	}{
+		{"all placeholders", "{code} {name} {phone} {serial} {pppoe}", customer, "C001 Budi 0812 ZTEG123 budi@net"},
+		{"name and address", "{name} - {address}", customer, "Budi - Jl. Melati 5"},
+		{"dangling separator trimmed", "{name} - {address}", &Customer{Name: "Budi"}, "Budi"},
+		{"leading separator trimmed", "{address} / {name}", &Customer{Name: "Budi"}, "Budi"},
+		{"whitespace collapsed", "{name}   {code}", customer, "Budi C001"},
+		{"empty template", "", customer, ""},
+		{"no customer", "{name}", nil, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := DeviceLabel(tc.tpl, tc.c, device); got != tc.want {
+				t.Errorf("DeviceLabel(%q) = %q, want %q", tc.tpl, got, tc.want)
+			}
+		})
+	}
+}
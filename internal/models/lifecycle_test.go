@@ -0,0 +1,22 @@
+package models
+
+import "testing"
+
+func TestLifecycleTransitions(t *testing.T) {
+	allowed := map[[2]LifecycleState]bool{
+		{LifecycleStock, LifecycleDeployed}:   true,
+		{LifecycleStock, LifecycleRetired}:    true,
+		{LifecycleDeployed, LifecycleStock}:   true,
+		{LifecycleDeployed, LifecycleRetired}: true,
+		{LifecycleRetired, LifecycleStock}:    true,
+	}
+	states := []LifecycleState{LifecycleStock, LifecycleDeployed, LifecycleRetired, "lost"}
+	for _, from := range states {
+		for _, to := range states {
+			want := allowed[[2]LifecycleState{from, to}]
+			if got := from.CanTransitionTo(to); got != want {
+				t.Errorf("%s -> %s allowed = %v, want %v", from, to, got, want)
+			}
+		}
+	}
+}
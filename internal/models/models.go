@@ -2,6 +2,11 @@ package models
 
 import (
 	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -43,11 +48,57 @@ type Device struct {
 	Address   string  `json:"address"`
 	// Customer relation
 	CustomerID *int64            `json:"customerId,omitempty"`
-	Parameters map[string]string `json:"parameters,omitempty"`
-	Tags       []string          `json:"tags,omitempty"`
-	Notes      string            `json:"notes"`
-	CreatedAt  time.Time         `json:"createdAt"`
-	UpdatedAt  time.Time         `json:"updatedAt"`
+	// Inventory lifecycle (stock, deployed, retired)
+	LifecycleState LifecycleState    `json:"lifecycleState"`
+	// Display label, auto-filled from DEVICE_LABEL_TEMPLATE on customer assignment
+	Label      string            `json:"label,omitempty"`
+	Parameters     map[string]string `json:"parameters,omitempty"`
+	Tags           []string          `json:"tags,omitempty"`
+	Notes          string            `json:"notes"`
+	CreatedAt      time.Time         `json:"createdAt"`
+	UpdatedAt      time.Time         `json:"updatedAt"`
+}
+
+// LifecycleState tracks where a device is in the inventory
+type LifecycleState string
+
+const (
+	LifecycleStock    LifecycleState = "stock"    // Spare unit in the warehouse, not yet deployed
+	LifecycleDeployed LifecycleState = "deployed" // Installed at a customer site
+	LifecycleRetired  LifecycleState = "retired"  // Broken or decommissioned
+)
+
+// CanTransitionTo reports whether a device may move from s to next.
+// Retired units can only return to stock (after refurbishing).
+func (s LifecycleState) CanTransitionTo(next LifecycleState) bool {
+	switch next {
+	case LifecycleStock:
+		return s == LifecycleDeployed || s == LifecycleRetired
+	case LifecycleDeployed:
+		return s == LifecycleStock
+	case LifecycleRetired:
+		return s == LifecycleStock || s == LifecycleDeployed
+	}
+	return false
+}
+
+// DeviceLabel renders a device label template for the customer it is assigned
+// to. Supported placeholders are {name}, {address}, {code}, {phone}, {serial}
+// and {pppoe}. Separators left dangling by empty fields are trimmed, so
+// "{name} - {address}" yields just the name when the address is unknown.
+func DeviceLabel(tpl string, c *Customer, d *Device) string {
+	if tpl == "" || c == nil || d == nil {
+		return ""
+	}
+	label := strings.NewReplacer(
+		"{name}", c.Name,
+		"{address}", c.Address,
+		"{code}", c.CustomerCode,
+		"{phone}", c.Phone,
+		"{serial}", d.SerialNumber,
+		"{pppoe}", d.Template,
+	).Replace(tpl)
+	return strings.Trim(strings.Join(strings.Fields(label), " "), " -,/|")
 }
 
 // DeviceStatus represents the online/offline status
@@ -57,6 +108,7 @@ const (
 	StatusOnline  DeviceStatus = "online"
 	StatusOffline DeviceStatus = "offline"
 	StatusUnknown DeviceStatus = "unknown"
+	StatusPending DeviceStatus = "pending" // Awaiting operator approval
 )
 
 // DeviceParameter represents a TR-069 parameter
@@ -70,6 +122,233 @@ type DeviceParameter struct {
 	UpdatedAt time.Time `json:"updatedAt"`
 }
 
+// PinnedParameter is an operator-curated parameter surfaced first on the
+// parameter page for a device model
+type PinnedParameter struct {
+	ID        int64     `json:"id"`
+	ModelName string    `json:"modelName"` // Exact model (case-insensitive); empty = all models
+	Path      string    `json:"path"`
+	Label     string    `json:"label"`
+	Position  int       `json:"position"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ParameterChange is one recorded change of a device parameter value
+type ParameterChange struct {
+	ID        int64     `json:"id"`
+	DeviceID  int64     `json:"deviceId"`
+	Path      string    `json:"path"`
+	OldValue  string    `json:"oldValue"`
+	NewValue  string    `json:"newValue"`
+	ChangedAt time.Time `json:"changedAt"`
+}
+
+// ParameterWatch makes a change of one device parameter (e.g. the WAN IP or
+// firmware version) raise a log entry and notifications
+type ParameterWatch struct {
+	ID        int64     `json:"id"`
+	DeviceID  int64     `json:"deviceId"`
+	Path      string    `json:"path"`
+	Label     string    `json:"label"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Firmware is an image in the firmware repository, offered to devices of the
+// same manufacturer and product class running an older version
+type Firmware struct {
+	ID           int64     `json:"id"`
+	Manufacturer string    `json:"manufacturer"`
+	ProductClass string    `json:"productClass"` // Empty = every product class of the manufacturer
+	Version      string    `json:"version"`
+	URL          string    `json:"url"`
+	ReleaseNotes string    `json:"releaseNotes"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// FirmwareUpgrade is a bulk firmware upgrade queued in batches. It is stored
+// so batches that are still waiting survive a restart; NextRunAt is nil once
+// every batch has been queued.
+type FirmwareUpgrade struct {
+	ID           int64           `json:"id"`
+	BatchID      string          `json:"batchId"`
+	URL          string          `json:"url"`
+	Parameters   json.RawMessage `json:"-"` // Download task payload, may hold credentials
+	DelaySeconds int             `json:"delaySeconds"`
+	Batches      int             `json:"batches"`
+	NextBatch    int             `json:"nextBatch"`
+	NextRunAt    *time.Time      `json:"nextRunAt"`
+	UserID       *int64          `json:"-"`
+	CreatedBy    string          `json:"createdBy"`
+	CreatedAt    time.Time       `json:"createdAt"`
+}
+
+// versionTokens splits a vendor version string into alternating numeric and
+// alphabetic runs, e.g. "V5R019C10S115" -> [v 5 r 19 c 10 s 115]
+func versionTokens(v string) []string {
+	var tokens []string
+	var cur strings.Builder
+	curDigit := false
+	flush := func() {
+		if cur.Len() > 0 {
+			tokens = append(tokens, cur.String())
+			cur.Reset()
+		}
+	}
+	for _, r := range strings.ToLower(strings.TrimSpace(v)) {
+		isDigit := r >= '0' && r <= '9'
+		isAlpha := r >= 'a' && r <= 'z'
+		if !isDigit && !isAlpha {
+			flush()
+			continue
+		}
+		if cur.Len() > 0 && isDigit != curDigit {
+			flush()
+		}
+		curDigit = isDigit
+		cur.WriteRune(r)
+	}
+	flush()
+	return tokens
+}
+
+// CompareVersions compares two firmware versions and returns -1, 0 or 1.
+// Numeric parts compare as numbers ("V5.0.10" > "V5.0.9"), letters
+// case-insensitively, and a version with extra trailing parts is newer
+// ("1.0.1" > "1.0"). A leading "v" is ignored.
+func CompareVersions(a, b string) int {
+	ta, tb := versionTokens(a), versionTokens(b)
+	if len(ta) > 0 && ta[0] == "v" {
+		ta = ta[1:]
+	}
+	if len(tb) > 0 && tb[0] == "v" {
+		tb = tb[1:]
+	}
+	for i := 0; i < len(ta) && i < len(tb); i++ {
+		x, y := ta[i], tb[i]
+		nx, errX := strconv.ParseUint(x, 10, 64)
+		ny, errY := strconv.ParseUint(y, 10, 64)
+		switch {
+		case errX == nil && errY == nil:
+			if nx != ny {
+				if nx < ny {
+					return -1
+				}
+				return 1
+			}
+		case errX == nil:
+			return 1 // numbers sort after letters, e.g. "1.0.1" > "1.0.beta"
+		case errY == nil:
+			return -1
+		default:
+			if c := strings.Compare(x, y); c != 0 {
+				return c
+			}
+		}
+	}
+	switch {
+	case len(ta) < len(tb):
+		return -1
+	case len(ta) > len(tb):
+		return 1
+	}
+	return 0
+}
+
+// LatestFirmware returns the entry with the highest version, or nil
+func LatestFirmware(images []*Firmware) *Firmware {
+	var latest *Firmware
+	for _, f := range images {
+		if latest == nil || CompareVersions(f.Version, latest.Version) > 0 {
+			latest = f
+		}
+	}
+	return latest
+}
+
+// FirmwareVersionCount is the number of devices of one model running one
+// software version
+type FirmwareVersionCount struct {
+	Manufacturer    string
+	ProductClass    string
+	SoftwareVersion string
+	Devices         int64
+}
+
+// FirmwareAudit summarizes the software versions deployed per model against
+// the firmware repository
+type FirmwareAudit struct {
+	TotalDevices    int64                `json:"totalDevices"`
+	OutdatedDevices int64                `json:"outdatedDevices"`
+	Models          []FirmwareAuditModel `json:"models"`
+}
+
+// FirmwareAuditModel is the version distribution of one manufacturer/product class
+type FirmwareAuditModel struct {
+	Manufacturer    string                 `json:"manufacturer"`
+	ProductClass    string                 `json:"productClass"`
+	Devices         int64                  `json:"devices"`
+	LatestVersion   string                 `json:"latestVersion,omitempty"` // Newest repository version; empty = not in the repository
+	OutdatedDevices int64                  `json:"outdatedDevices"`
+	Versions        []FirmwareAuditVersion `json:"versions"` // Newest first
+}
+
+// FirmwareAuditVersion is one software version of a FirmwareAuditModel
+type FirmwareAuditVersion struct {
+	Version  string `json:"version"`
+	Devices  int64  `json:"devices"`
+	Outdated bool   `json:"outdated"`
+}
+
+// BuildFirmwareAudit groups version counts by model and flags the versions
+// older than the newest matching repository image. Models are ordered by
+// device count, largest first.
+func BuildFirmwareAudit(counts []FirmwareVersionCount, catalog []*Firmware) *FirmwareAudit {
+	audit := &FirmwareAudit{Models: []FirmwareAuditModel{}}
+	index := make(map[string]int)
+
+	for _, c := range counts {
+		key := strings.ToLower(c.Manufacturer) + "\x00" + strings.ToLower(c.ProductClass)
+		i, ok := index[key]
+		if !ok {
+			m := FirmwareAuditModel{Manufacturer: c.Manufacturer, ProductClass: c.ProductClass}
+			var images []*Firmware
+			for _, f := range catalog {
+				if strings.EqualFold(f.Manufacturer, c.Manufacturer) &&
+					(f.ProductClass == "" || strings.EqualFold(f.ProductClass, c.ProductClass)) {
+					images = append(images, f)
+				}
+			}
+			if latest := LatestFirmware(images); latest != nil {
+				m.LatestVersion = latest.Version
+			}
+			audit.Models = append(audit.Models, m)
+			i = len(audit.Models) - 1
+			index[key] = i
+		}
+
+		m := &audit.Models[i]
+		outdated := m.LatestVersion != "" && c.SoftwareVersion != "" && CompareVersions(m.LatestVersion, c.SoftwareVersion) > 0
+		m.Versions = append(m.Versions, FirmwareAuditVersion{Version: c.SoftwareVersion, Devices: c.Devices, Outdated: outdated})
+		m.Devices += c.Devices
+		audit.TotalDevices += c.Devices
+		if outdated {
+			m.OutdatedDevices += c.Devices
+			audit.OutdatedDevices += c.Devices
+		}
+	}
+
+	for i := range audit.Models {
+		versions := audit.Models[i].Versions
+		sort.SliceStable(versions, func(a, b int) bool {
+			return CompareVersions(versions[a].Version, versions[b].Version) > 0
+		})
+	}
+	sort.SliceStable(audit.Models, func(a, b int) bool {
+		return audit.Models[a].Devices > audit.Models[b].Devices
+	})
+	return audit
+}
+
 // WiFiConfig represents WiFi configuration
 type WiFiConfig struct {
 	SSID             string `json:"ssid"`
@@ -111,6 +390,29 @@ type WANConfig struct {
 	UpdatedAt      time.Time `json:"updatedAt"`
 }
 
+// WANTemplate is a reusable multi-WAN layout (e.g. Internet + IPTV + VoIP)
+// applied to a device in one SetParameterValues task. Per-customer PPPoE
+// credentials are supplied when the template is applied, not stored here.
+type WANTemplate struct {
+	ID          int64                   `json:"id"`
+	Name        string                  `json:"name"`
+	Description string                  `json:"description"`
+	Connections []WANTemplateConnection `json:"connections"`
+	CreatedAt   time.Time               `json:"createdAt"`
+	UpdatedAt   time.Time               `json:"updatedAt"`
+}
+
+// WANTemplateConnection is one WAN connection of a WANTemplate
+type WANTemplateConnection struct {
+	WANIndex       int    `json:"wanIndex"` // WANConnectionDevice instance
+	Name           string `json:"name"`
+	Service        string `json:"service"`        // INTERNET, IPTV, VOIP, TR069 or OTHER
+	ConnectionType string `json:"connectionType"` // PPPoE, DHCP or Bridge
+	VLAN           int    `json:"vlan"`
+	NATEnabled     bool   `json:"natEnabled"`
+	MTU            int    `json:"mtu"`
+}
+
 // LANConfig represents LAN configuration
 type LANConfig struct {
 	IPAddress   string `json:"ipAddress"`
@@ -128,11 +430,90 @@ type DeviceTask struct {
 	Type        TaskType        `json:"type"`
 	Status      TaskStatus      `json:"status"`
 	Parameters  json.RawMessage `json:"parameters"`
+	Priority    int             `json:"priority"` // Higher runs first; 0 = default for the task type
 	Result      json.RawMessage `json:"result,omitempty"`
 	Error       string          `json:"error,omitempty"`
 	CreatedAt   time.Time       `json:"createdAt"`
 	StartedAt   *time.Time      `json:"startedAt,omitempty"`
 	CompletedAt *time.Time      `json:"completedAt,omitempty"`
+	// Values of the written parameters at queue time (SetParameterValues only), used to revert
+	PreviousValues json.RawMessage `json:"previousValues,omitempty"`
+	// Times the task was requeued after failing
+	RetryCount int `json:"retryCount"`
+	// Read-back check of a completed SetParameterValues task (see Verification*)
+	Verification string `json:"verification,omitempty"`
+}
+
+// Read-back verification states of a completed SetParameterValues task
+const (
+	VerificationPending    = "pending"              // Read-back queued
+	VerificationApplied    = "applied"              // The device reports every written value
+	VerificationNotApplied = "accepted_not_applied" // The device accepted the write but reports other values
+	VerificationUnverified = "unverified"           // The read-back failed
+)
+
+// ParameterMismatch is a written parameter whose read-back value differs
+// from the value that was set
+type ParameterMismatch struct {
+	Path     string `json:"path"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+	Missing  bool   `json:"missing,omitempty"` // Not reported in the read-back at all
+}
+
+// VerifiableWrites returns the values written by a SetParameterValues task
+// that can be checked by reading them back. Secrets are left out since
+// devices usually report them empty.
+func VerifiableWrites(parameters json.RawMessage) map[string]string {
+	var params map[string]interface{}
+	dec := json.NewDecoder(strings.NewReader(string(parameters)))
+	dec.UseNumber() // keep numbers as written, e.g. 1000000 not 1e+06
+	if err := dec.Decode(&params); err != nil {
+		return nil
+	}
+	writes := make(map[string]string, len(params))
+	for path, v := range params {
+		if !isSecretPath(path) {
+			writes[path] = fmt.Sprint(v)
+		}
+	}
+	return writes
+}
+
+// CompareParameterValues returns the intended values that the read-back does
+// not confirm, sorted by path. Values are compared ignoring surrounding
+// whitespace, and booleans match across "1"/"true" and "0"/"false".
+func CompareParameterValues(intended, readBack map[string]string) []ParameterMismatch {
+	var mismatches []ParameterMismatch
+	for path, want := range intended {
+		got, ok := readBack[path]
+		if !ok {
+			mismatches = append(mismatches, ParameterMismatch{Path: path, Expected: want, Missing: true})
+			continue
+		}
+		if !sameParameterValue(want, got) {
+			mismatches = append(mismatches, ParameterMismatch{Path: path, Expected: want, Actual: got})
+		}
+	}
+	sort.Slice(mismatches, func(i, j int) bool { return mismatches[i].Path < mismatches[j].Path })
+	return mismatches
+}
+
+func sameParameterValue(a, b string) bool {
+	a, b = strings.TrimSpace(a), strings.TrimSpace(b)
+	if a == b {
+		return true
+	}
+	boolValue := func(v string) string {
+		switch strings.ToLower(v) {
+		case "1", "true":
+			return "true"
+		case "0", "false":
+			return "false"
+		}
+		return ""
+	}
+	return boolValue(a) != "" && boolValue(a) == boolValue(b)
 }
 
 // TaskType represents the type of task
@@ -145,8 +526,36 @@ const (
 	TaskFactoryReset       TaskType = "factoryReset"
 	TaskDownload           TaskType = "download"
 	TaskRefresh            TaskType = "refresh"
+	TaskAddObject          TaskType = "addObject"
+)
+
+// AddObjectRequest is the payload of an addObject task. Once the CPE has
+// created the instance, Then (whose ObjectName is relative to the new
+// instance) is added beneath it the same way, or Values, also relative to the
+// new instance, are written with SetParameterValues.
+type AddObjectRequest struct {
+	ObjectName string            `json:"objectName"` // e.g. "InternetGatewayDevice.WANDevice.1.WANConnectionDevice."
+	Then       *AddObjectRequest `json:"then,omitempty"`
+	Values     map[string]string `json:"values,omitempty"`
+}
+
+// Task priorities; pending tasks are executed highest priority first
+const (
+	TaskPriorityLow    = 1
+	TaskPriorityNormal = 5
+	TaskPriorityHigh   = 10
 )
 
+// DefaultTaskPriority returns the priority used when a task is queued without one.
+// Reboots and factory resets are urgent and jump ahead of bulk parameter changes.
+func DefaultTaskPriority(t TaskType) int {
+	switch t {
+	case TaskReboot, TaskFactoryReset:
+		return TaskPriorityHigh
+	}
+	return TaskPriorityNormal
+}
+
 // TaskStatus represents the status of a task
 type TaskStatus string
 
@@ -157,6 +566,207 @@ const (
 	TaskFailed    TaskStatus = "failed"
 )
 
+// PendingChange is one queued parameter write in a PendingSummary
+type PendingChange struct {
+	Path   string `json:"path"`
+	Value  string `json:"value"`
+	TaskID int64  `json:"taskId"`
+}
+
+// PendingSummary describes everything queued for a device, in the order the
+// ACS will send it
+type PendingSummary struct {
+	DeviceID     int64                      `json:"deviceId"`
+	TaskCount    int                        `json:"taskCount"`
+	Reboot       bool                       `json:"reboot"`
+	FactoryReset bool                       `json:"factoryReset"`
+	Refresh      bool                       `json:"refresh"`
+	Firmware     []string                   `json:"firmware"`  // Download URLs
+	Changes      map[string][]PendingChange `json:"changes"`   // Parameter writes by category
+	ReadPaths    []string                   `json:"readPaths"` // Paths queued for GetParameterValues
+	Lines        []string                   `json:"lines"`     // Human-readable summary
+}
+
+// ParameterCategory groups a TR-069 parameter path for display: wifi, wan,
+// lan, management, time, device or other
+func ParameterCategory(path string) string {
+	switch {
+	case strings.Contains(path, "WLANConfiguration") || strings.Contains(path, ".WiFi."):
+		return "wifi"
+	case strings.Contains(path, "WANDevice") || strings.Contains(path, "WANPPPConnection") ||
+		strings.Contains(path, "WANIPConnection") || strings.Contains(path, ".PPP.") || strings.Contains(path, ".IP.Interface"):
+		return "wan"
+	case strings.Contains(path, "LANDevice") || strings.Contains(path, "LANHostConfigManagement") || strings.Contains(path, ".DHCPv4."):
+		return "lan"
+	case strings.Contains(path, "ManagementServer"):
+		return "management"
+	case strings.Contains(path, ".Time."):
+		return "time"
+	case strings.Contains(path, "DeviceInfo"):
+		return "device"
+	}
+	return "other"
+}
+
+// cloneLeaves are the configuration parameters a device clone copies, per
+// category. Status, counters and identity values (MAC, serial, addresses
+// handed out by the network) are never copied.
+var cloneLeaves = map[string][]string{
+	"wifi": {"SSID", "Enable", "KeyPassphrase", "PreSharedKey", "BeaconType", "BasicEncryptionModes",
+		"WPAEncryptionModes", "IEEE11iEncryptionModes", "BasicAuthenticationMode", "WPAAuthenticationMode",
+		"IEEE11iAuthenticationMode", "ModeEnabled", "Channel", "AutoChannelEnable", "SSIDAdvertisementEnabled",
+		"MaxAssociatedDevices", "Standard", "OperatingStandards", "OperatingChannelBandwidth", "TransmitPower"},
+	"wan": {"Enable", "ConnectionType", "AddressingType", "Username", "Password", "NATEnabled", "MaxMRUSize",
+		"ConnectionTrigger"},
+	"lan": {"DHCPServerEnable", "MinAddress", "MaxAddress", "SubnetMask", "DNSServers", "DHCPLeaseTime",
+		"LeaseTime", "IPRouters", "DomainName", "IPInterfaceIPAddress", "IPInterfaceSubnetMask"},
+}
+
+// CloneCategories are the categories a device clone copies, in queue order
+var CloneCategories = []string{"wifi", "wan", "lan"}
+
+// CloneCategory returns the category (wifi, wan or lan) of a parameter that a
+// device clone copies, or "" if the parameter is not part of a clone.
+// Vendor extensions are included for WiFi bandwidth/hidden SSID and WAN VLAN
+// and service list settings.
+func CloneCategory(path string) string {
+	category := ParameterCategory(path)
+	leaf := path[strings.LastIndex(path, ".")+1:]
+	if strings.HasPrefix(leaf, "X_") {
+		switch {
+		case category == "wifi" && (strings.Contains(leaf, "BandWidth") || strings.Contains(leaf, "WlanHidden")):
+			return category
+		case category == "wan" && (strings.Contains(leaf, "VLAN") || strings.Contains(leaf, "ServiceList")):
+			return category
+		}
+		return ""
+	}
+	for _, l := range cloneLeaves[category] {
+		if l == leaf {
+			return category
+		}
+	}
+	return ""
+}
+
+// ClonePlan picks the source values to write to a target device, grouped by
+// category. Empty values (e.g. secrets the source never reported) are left
+// out. When target is non-nil (the target has reported its parameters),
+// paths it does not have are returned sorted in missing instead.
+func ClonePlan(source map[string]string, target map[string]bool, categories map[string]bool) (writes map[string]map[string]string, missing []string) {
+	writes, missing = make(map[string]map[string]string), []string{}
+	for path, value := range source {
+		category := CloneCategory(path)
+		if category == "" || !categories[category] || strings.TrimSpace(value) == "" {
+			continue
+		}
+		if target != nil && !target[path] {
+			missing = append(missing, path)
+			continue
+		}
+		if writes[category] == nil {
+			writes[category] = make(map[string]string)
+		}
+		writes[category][path] = value
+	}
+	sort.Strings(missing)
+	return writes, missing
+}
+
+// isSecretPath reports whether a parameter holds a password or key that
+// should not be echoed back
+func isSecretPath(path string) bool {
+	p := strings.ToLower(path)
+	return strings.Contains(p, "password") || strings.Contains(p, "passphrase") || strings.Contains(p, "presharedkey")
+}
+
+// SummarizePendingTasks builds a PendingSummary from pending tasks sorted in
+// execution order. When several tasks write the same path the last one wins,
+// as it does on the device; secret values are masked.
+func SummarizePendingTasks(deviceID int64, tasks []*DeviceTask) *PendingSummary {
+	s := &PendingSummary{
+		DeviceID:  deviceID,
+		TaskCount: len(tasks),
+		Firmware:  []string{},
+		Changes:   make(map[string][]PendingChange),
+		ReadPaths: []string{},
+		Lines:     []string{},
+	}
+
+	latest := make(map[string]PendingChange)
+	var order []string
+	for _, t := range tasks {
+		switch t.Type {
+		case TaskReboot:
+			s.Reboot = true
+		case TaskFactoryReset:
+			s.FactoryReset = true
+		case TaskRefresh:
+			s.Refresh = true
+		case TaskDownload:
+			var dl struct {
+				URL string `json:"url"`
+			}
+			json.Unmarshal(t.Parameters, &dl)
+			s.Firmware = append(s.Firmware, dl.URL)
+		case TaskGetParameterValues:
+			var paths []string
+			json.Unmarshal(t.Parameters, &paths)
+			s.ReadPaths = append(s.ReadPaths, paths...)
+		case TaskSetParameterValues:
+			var params map[string]interface{}
+			json.Unmarshal(t.Parameters, &params)
+			paths := make([]string, 0, len(params))
+			for path := range params {
+				paths = append(paths, path)
+			}
+			sort.Strings(paths)
+			for _, path := range paths {
+				value := fmt.Sprint(params[path])
+				if isSecretPath(path) {
+					value = "********"
+				}
+				if _, seen := latest[path]; !seen {
+					order = append(order, path)
+				}
+				latest[path] = PendingChange{Path: path, Value: value, TaskID: t.ID}
+			}
+		}
+	}
+
+	for _, path := range order {
+		category := ParameterCategory(path)
+		s.Changes[category] = append(s.Changes[category], latest[path])
+	}
+
+	if s.FactoryReset {
+		s.Lines = append(s.Lines, "Factory reset")
+	}
+	for _, url := range s.Firmware {
+		s.Lines = append(s.Lines, "Firmware upgrade from "+url)
+	}
+	categories := make([]string, 0, len(s.Changes))
+	for category := range s.Changes {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	for _, category := range categories {
+		for _, c := range s.Changes[category] {
+			s.Lines = append(s.Lines, fmt.Sprintf("[%s] Set %s = %s", category, c.Path, c.Value))
+		}
+	}
+	if len(s.ReadPaths) > 0 {
+		s.Lines = append(s.Lines, fmt.Sprintf("Read %d parameter path(s)", len(s.ReadPaths)))
+	}
+	if s.Refresh {
+		s.Lines = append(s.Lines, "Refresh all parameters")
+	}
+	if s.Reboot {
+		s.Lines = append(s.Lines, "Reboot")
+	}
+	return s
+}
+
 // Preset represents a provision/preset configuration
 type Preset struct {
 	ID          int64           `json:"id"`
@@ -164,29 +774,502 @@ type Preset struct {
 	Description string          `json:"description"`
 	Filter      json.RawMessage `json:"filter"`     // Device filter criteria
 	Provisions  json.RawMessage `json:"provisions"` // Actions to perform
-	Weight      int             `json:"weight"`     // Priority (lower = higher priority)
+	Weight      int             `json:"weight"`     // Higher weight wins when presets set the same path
 	Enabled     bool            `json:"enabled"`
 	Events      []string        `json:"events"` // Trigger events
 	CreatedAt   time.Time       `json:"createdAt"`
 	UpdatedAt   time.Time       `json:"updatedAt"`
 }
 
-// Log represents a system log entry
-type Log struct {
+// PresetFilter is the device match criteria stored in Preset.Filter. Empty
+// fields match any device; all set fields must match.
+type PresetFilter struct {
+	Manufacturer    string `json:"manufacturer"`    // Case-insensitive substring
+	ProductClass    string `json:"productClass"`    // Exact (case-insensitive) or prefix ending in "*"
+	ModelName       string `json:"modelName"`       // Exact (case-insensitive) or prefix ending in "*"
+	SoftwareVersion string `json:"softwareVersion"` // Exact (case-insensitive) or prefix ending in "*"
+	Tag             string `json:"tag"`             // Device must carry this tag
+}
+
+func matchPattern(pattern, value string) bool {
+	if pattern == "" {
+		return true
+	}
+	if strings.HasSuffix(pattern, "*") {
+		return strings.HasPrefix(strings.ToUpper(value), strings.ToUpper(strings.TrimSuffix(pattern, "*")))
+	}
+	return strings.EqualFold(pattern, value)
+}
+
+// Matches reports whether the preset is enabled and its filter selects the device
+func (p *Preset) Matches(device *Device) bool {
+	if !p.Enabled {
+		return false
+	}
+	var f PresetFilter
+	if len(p.Filter) > 0 && string(p.Filter) != "null" {
+		if err := json.Unmarshal(p.Filter, &f); err != nil {
+			return false
+		}
+	}
+	if f.Manufacturer != "" && !strings.Contains(strings.ToUpper(device.Manufacturer), strings.ToUpper(f.Manufacturer)) {
+		return false
+	}
+	if !matchPattern(f.ProductClass, device.ProductClass) || !matchPattern(f.ModelName, device.ModelName) ||
+		!matchPattern(f.SoftwareVersion, device.SoftwareVersion) {
+		return false
+	}
+	if f.Tag != "" {
+		for _, tag := range device.Tags {
+			if strings.EqualFold(tag, f.Tag) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// TriggeredBy reports whether an Inform with these event codes applies the
+// preset; a preset without events applies on every Inform
+func (p *Preset) TriggeredBy(eventCodes []string) bool {
+	if len(p.Events) == 0 {
+		return true
+	}
+	for _, want := range p.Events {
+		for _, code := range eventCodes {
+			if strings.EqualFold(strings.TrimSpace(want), code) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ParameterValues decodes Preset.Provisions, either an object of
+// {"path": value} or a list of {"path": ..., "value": ...}
+func (p *Preset) ParameterValues() (map[string]string, error) {
+	values := make(map[string]string)
+	if len(p.Provisions) == 0 || string(p.Provisions) == "null" {
+		return values, nil
+	}
+
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(p.Provisions, &object); err == nil {
+		for path, v := range object {
+			values[path] = presetValue(v)
+		}
+		return values, nil
+	}
+
+	var list []struct {
+		Path  string          `json:"path"`
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal(p.Provisions, &list); err != nil {
+		return nil, fmt.Errorf("provisions must be an object of path/value pairs or a list of {path, value}")
+	}
+	for _, item := range list {
+		if item.Path != "" {
+			values[item.Path] = presetValue(item.Value)
+		}
+	}
+	return values, nil
+}
+
+// presetValue renders a provision value the way TR-069 carries it: strings
+// as-is, numbers exactly as written (no 1e+06) and booleans as true/false
+func presetValue(raw json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	if string(raw) == "null" || len(raw) == 0 {
+		return ""
+	}
+	return strings.TrimSpace(string(raw))
+}
+
+// SameParameterValue reports whether two parameter values mean the same
+// thing: xsd:boolean accepts true/false and 1/0, and numbers compare by value
+func SameParameterValue(a, b string) bool {
+	if a == b {
+		return true
+	}
+	a, b = strings.TrimSpace(a), strings.TrimSpace(b)
+	if ba, okA := parseParameterBool(a); okA {
+		if bb, okB := parseParameterBool(b); okB {
+			return ba == bb
+		}
+	}
+	fa, errA := strconv.ParseFloat(a, 64)
+	fb, errB := strconv.ParseFloat(b, 64)
+	return errA == nil && errB == nil && fa == fb
+}
+
+func parseParameterBool(v string) (bool, bool) {
+	switch strings.ToLower(v) {
+	case "true", "1":
+		return true, true
+	case "false", "0":
+		return false, true
+	}
+	return false, false
+}
+
+// IsSecretParameter reports whether a path holds a write-only secret that
+// devices report back empty
+func IsSecretParameter(path string) bool {
+	return strings.Contains(path, "KeyPassphrase") || strings.Contains(path, "PreSharedKey") ||
+		strings.HasSuffix(path, "Password") || strings.HasSuffix(path, "WEPKey")
+}
+
+// MergePresetParameters combines the parameter values of presets; when two
+// set the same path the higher weight wins (ties go to the later preset)
+func MergePresetParameters(presets []*Preset) map[string]string {
+	ordered := make([]*Preset, len(presets))
+	copy(ordered, presets)
+	sort.SliceStable(ordered, func(i, j int) bool { return ordered[i].Weight < ordered[j].Weight })
+
+	merged := make(map[string]string)
+	for _, p := range ordered {
+		values, err := p.ParameterValues()
+		if err != nil {
+			continue
+		}
+		for path, v := range values {
+			merged[path] = v
+		}
+	}
+	return merged
+}
+
+// Rollout pushes parameter values to a group of devices in two phases: a
+// canary sample first, then, if the canary stays healthy for WaitMinutes,
+// everyone else
+type Rollout struct {
+	ID                int64             `json:"id"`
+	Name              string            `json:"name"`
+	Parameters        map[string]string `json:"parameters"`
+	Filter            json.RawMessage   `json:"filter"` // deviceIds, status, tag, manufacturer
+	CanaryPercent     int               `json:"canaryPercent"`
+	WaitMinutes       int               `json:"waitMinutes"`
+	MaxFailurePercent float64           `json:"maxFailurePercent"` // Allowed canary failure rate above the control group
+	Status            string            `json:"status"`            // canary, completed, aborted
+	Reason            string            `json:"reason,omitempty"`  // Why it completed or aborted
+	CreatedBy         string            `json:"createdBy"`
+	CreatedAt         time.Time         `json:"createdAt"`
+	CanaryUntil       time.Time         `json:"canaryUntil"`
+	DecidedAt         *time.Time        `json:"decidedAt,omitempty"`
+
+	// Filled when reading a single rollout
+	Canary  *RolloutHealth `json:"canary,omitempty"`
+	Control *RolloutHealth `json:"control,omitempty"` // Devices not in the canary
+}
+
+// Rollout statuses
+const (
+	RolloutCanary    = "canary"
+	RolloutCompleted = "completed"
+	RolloutAborted   = "aborted"
+)
+
+// RolloutHealth counts how the devices of one rollout phase are doing. Each
+// device is counted once: Offline (online at start, offline now) wins over
+// the state of its task.
+type RolloutHealth struct {
+	Devices int `json:"devices"`
+	Applied int `json:"applied"` // Task completed
+	Pending int `json:"pending"` // Task not run yet
+	Failed  int `json:"failed"`  // Task faulted (including ones being retried)
+	Offline int `json:"offline"`
+}
+
+// FailurePercent is the share of devices that failed, went offline or, once
+// the canary wait is over, still haven't applied the change
+func (h RolloutHealth) FailurePercent() float64 {
+	if h.Devices == 0 {
+		return 0
+	}
+	return float64(h.Failed+h.Offline+h.Pending) * 100 / float64(h.Devices)
+}
+
+// CanarySize is how many of total devices go into a canary of percent,
+// rounded up and at least one
+func CanarySize(total, percent int) int {
+	if total == 0 {
+		return 0
+	}
+	n := (total*percent + 99) / 100
+	if n < 1 {
+		n = 1
+	}
+	if n > total {
+		n = total
+	}
+	return n
+}
+
+// CanaryVerdict decides at the end of the canary wait whether a rollout
+// proceeds. The control group (devices not touched yet) absorbs background
+// noise such as a PON outage: the rollout aborts only when the canary's
+// failure rate exceeds the control's by more than maxFailurePercent.
+func CanaryVerdict(canary, control RolloutHealth, maxFailurePercent float64) (proceed bool, reason string) {
+	canaryRate, controlRate := canary.FailurePercent(), control.FailurePercent()
+	if canaryRate-controlRate > maxFailurePercent {
+		return false, fmt.Sprintf("canary failure rate %.0f%% (%d failed, %d offline, %d not applied of %d) exceeds control %.0f%% by more than %.0f%%",
+			canaryRate, canary.Failed, canary.Offline, canary.Pending, canary.Devices, controlRate, maxFailurePercent)
+	}
+	return true, fmt.Sprintf("canary failure rate %.0f%% within %.0f%% of control %.0f%%", canaryRate, maxFailurePercent, controlRate)
+}
+
+// RebootSchedule reboots one device, or every device carrying a tag, at the
+// times of a cron spec. Exactly one of DeviceID and Tag is set.
+type RebootSchedule struct {
+	ID        int64      `json:"id"`
+	DeviceID  *int64     `json:"deviceId,omitempty"`
+	Tag       string     `json:"tag,omitempty"`
+	Spec      string     `json:"spec"` // minute hour day-of-month month day-of-week, e.g. "0 4 * * *"
+	Enabled   bool       `json:"enabled"`
+	LastRunAt *time.Time `json:"lastRunAt,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+// CronSpec is a parsed five-field cron expression
+type CronSpec struct {
+	minute, hour, dom, month, dow uint64
+	domAny, dowAny                bool
+}
+
+var cronAliases = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+// ParseCronSpec parses "minute hour day-of-month month day-of-week" with
+// *, lists, ranges and steps (e.g. "0 4 * * *", "30 2 * * 1-5",
+// "0 */6 * * *") or one of @hourly, @daily, @weekly, @monthly. Day of week
+// runs 0-7 with both 0 and 7 meaning Sunday.
+func ParseCronSpec(spec string) (*CronSpec, error) {
+	spec = strings.TrimSpace(spec)
+	if alias, ok := cronAliases[strings.ToLower(spec)]; ok {
+		spec = alias
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron spec needs 5 fields (minute hour day month weekday), got %d", len(fields))
+	}
+
+	bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	names := [5]string{"minute", "hour", "day of month", "month", "day of week"}
+	var sets [5]uint64
+	for i, field := range fields {
+		set, err := parseCronField(field, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %v", names[i], field, err)
+		}
+		sets[i] = set
+	}
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+	return &CronSpec{
+		minute: sets[0], hour: sets[1], dom: sets[2], month: sets[3], dow: sets[4],
+		domAny: fields[2] == "*", dowAny: fields[4] == "*",
+	}, nil
+}
+
+func parseCronField(field string, min, max int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("bad step in %q", part)
+			}
+			rangePart, step = part[:i], n
+		}
+
+		lo, hi := min, max
+		if rangePart != "*" {
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("bad value %q", bounds[0])
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("bad value %q", bounds[1])
+				}
+			} else if step > 1 {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q out of range %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+// Matches reports whether t falls in a minute the spec fires on. As in cron,
+// when both day of month and day of week are restricted either may match.
+func (c *CronSpec) Matches(t time.Time) bool {
+	if c.minute&(1<<uint(t.Minute())) == 0 || c.hour&(1<<uint(t.Hour())) == 0 || c.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+	domMatch := c.dom&(1<<uint(t.Day())) != 0
+	dowMatch := c.dow&(1<<uint(t.Weekday())) != 0
+	switch {
+	case c.domAny && c.dowAny:
+		return true
+	case c.domAny:
+		return dowMatch
+	case c.dowAny:
+		return domMatch
+	default:
+		return domMatch || dowMatch
+	}
+}
+
+// InformEvent records the TR-069 event codes that triggered one Inform
+type InformEvent struct {
+	ID          int64     `json:"id"`
+	DeviceID    int64     `json:"deviceId"`
+	EventCodes  []string  `json:"eventCodes"`  // e.g. "1 BOOT", "2 PERIODIC", "M Reboot"
+	CommandKeys []string  `json:"commandKeys"` // CommandKey per event, same order
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// DeviceCommand is one manual action on a device (reboot, reset, refresh,
+// configuration change) with the admin who triggered it
+type DeviceCommand struct {
 	ID        int64     `json:"id"`
-	DeviceID  *int64    `json:"deviceId,omitempty"`
-	Level     string    `json:"level"` // info, warning, error
-	Category  string    `json:"category"`
-	Message   string    `json:"message"`
+	DeviceID  int64     `json:"deviceId"`
+	Command   string    `json:"command"` // reboot, factory_reset, refresh, identify, set_parameters, wifi, lan, wan, qos, firmware, revert, clone, replace
+	TaskID    *int64    `json:"taskId,omitempty"`
+	UserID    *int64    `json:"userId,omitempty"`
+	Username  string    `json:"username"`
 	Details   string    `json:"details,omitempty"`
 	CreatedAt time.Time `json:"createdAt"`
 }
 
+// PollProfile lists the parameter paths read from matching devices on
+// bootstrap instead of the generic all-vendor parameter set
+type PollProfile struct {
+	ID           int64     `json:"id"`
+	Name         string    `json:"name"`
+	Manufacturer string    `json:"manufacturer"` // Case-insensitive substring, e.g. "ZTE"
+	ModelName    string    `json:"modelName"`    // Exact model or prefix ending in "*"; empty = any model
+	Paths        []string  `json:"paths"`
+	Enabled      bool      `json:"enabled"`
+	CreatedAt    time.Time `json:"createdAt"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+}
+
+// pollProfileScore rates how specifically a profile matches a device:
+// 0 = no match, then manufacturer-only < model prefix (longer wins) < exact model
+func pollProfileScore(p *PollProfile, manufacturer, model string) int {
+	if !p.Enabled || p.Manufacturer == "" ||
+		!strings.Contains(strings.ToUpper(manufacturer), strings.ToUpper(p.Manufacturer)) {
+		return 0
+	}
+	switch {
+	case p.ModelName == "":
+		return 1
+	case strings.HasSuffix(p.ModelName, "*"):
+		prefix := strings.TrimSuffix(p.ModelName, "*")
+		if strings.HasPrefix(strings.ToUpper(model), strings.ToUpper(prefix)) {
+			return 2 + len(prefix)
+		}
+	case strings.EqualFold(p.ModelName, model):
+		return 1000
+	}
+	return 0
+}
+
+// SelectPollProfile returns the most specific enabled profile matching the
+// device, or nil when none applies
+func SelectPollProfile(profiles []*PollProfile, manufacturer, model string) *PollProfile {
+	var best *PollProfile
+	bestScore := 0
+	for _, p := range profiles {
+		if score := pollProfileScore(p, manufacturer, model); score > bestScore {
+			best, bestScore = p, score
+		}
+	}
+	return best
+}
+
+// Tag represents a device tag with its usage count
+type Tag struct {
+	ID          int64     `json:"id,omitempty"`
+	Name        string    `json:"name"`
+	Color       string    `json:"color,omitempty"`
+	Description string    `json:"description,omitempty"`
+	DeviceCount int       `json:"deviceCount"`
+	CreatedAt   time.Time `json:"createdAt,omitempty"`
+}
+
+// Log represents a system log entry
+type Log struct {
+	ID          int64     `json:"id"`
+	DeviceID    *int64    `json:"deviceId,omitempty"`
+	DeviceAlias string    `json:"deviceAlias,omitempty"`
+	Level       string    `json:"level"` // info, warning, error
+	Category    string    `json:"category"`
+	Message     string    `json:"message"`
+	Details     string    `json:"details,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// LogFilter holds optional criteria for querying logs
+type LogFilter struct {
+	DeviceID *int64
+	Level    string
+	Category string
+	From     *time.Time
+	To       *time.Time
+}
+
+// AuditFilter selects admin activity. User matches exactly ignoring case;
+// Action matches a device command name exactly, or is a substring of an
+// audit log action. From/To bound created_at.
+type AuditFilter struct {
+	Action string
+	User   string
+	From   *time.Time
+	To     *time.Time
+}
+
+// AuditEntry is one state-changing admin API request, recorded with the
+// user from its JWT for accountability
+type AuditEntry struct {
+	ID         int64     `json:"id"`
+	UserID     int64     `json:"userId"`
+	Username   string    `json:"username"`
+	Action     string    `json:"action"` // Method and route, e.g. "POST /api/customers/{id}/isolir"
+	TargetType string    `json:"targetType,omitempty"`
+	TargetID   string    `json:"targetId,omitempty"`
+	IP         string    `json:"ip"`
+	Status     int       `json:"status"` // HTTP response status; 403 for requests the user's role may not make
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
 // DashboardStats represents dashboard statistics
 type DashboardStats struct {
-	TotalDevices   int64            `json:"totalDevices"`
+	TotalDevices   int64            `json:"totalDevices"` // Deployed devices only
 	OnlineDevices  int64            `json:"onlineDevices"`
 	OfflineDevices int64            `json:"offlineDevices"`
+	StockDevices   int64            `json:"stockDevices"`
+	RetiredDevices int64            `json:"retiredDevices"`
 	PendingTasks   int64            `json:"pendingTasks"`
 	ActiveSessions int64            `json:"activeSessions"`
 	DevicesByModel map[string]int64 `json:"devicesByModel"`
@@ -276,7 +1359,7 @@ type Customer struct {
 	// For input purposes (when creating/updating)
 	InputPassword string `json:"password"`
 	// Status
-	Status   string    `json:"status"` // active, suspended, terminated
+	Status   string    `json:"status"` // active, suspended, hold, terminated
 	FCMToken string    `json:"fcmToken"`
 	JoinDate time.Time `json:"joinDate"`
 	// Balance
@@ -287,20 +1370,38 @@ type Customer struct {
 	UpdatedAt time.Time `json:"updatedAt"`
 }
 
+// CustomerHold pauses a customer's service and billing, e.g. while they
+// travel. Held customers are not invoiced; the hold ends automatically on
+// ResumeOn or earlier when an admin resumes it.
+type CustomerHold struct {
+	ID                int64      `json:"id"`
+	CustomerID        int64      `json:"customerId"`
+	CustomerCode      string     `json:"customerCode,omitempty"`
+	CustomerName      string     `json:"customerName,omitempty"`
+	ResumeOn          string     `json:"resumeOn"`          // YYYY-MM-DD in the ISP's timezone
+	SuspendConnection bool       `json:"suspendConnection"` // PPPoE moved to the isolir profile for the hold
+	Reason            string     `json:"reason,omitempty"`
+	CreatedBy         string     `json:"createdBy"`
+	StartedAt         time.Time  `json:"startedAt"`
+	ResumedAt         *time.Time `json:"resumedAt,omitempty"`
+}
+
 // Package represents an internet package/plan
 type Package struct {
-	ID            int64     `json:"id"`
-	Name          string    `json:"name"` // e.g., "Home 20 Mbps"
-	Description   string    `json:"description"`
-	DownloadSpeed int       `json:"downloadSpeed"` // in Mbps
-	UploadSpeed   int       `json:"uploadSpeed"`   // in Mbps
-	Quota         int64     `json:"quota"`         // in bytes, 0 = unlimited
-	Price         float64   `json:"price"`         // Monthly price
-	SetupFee      float64   `json:"setupFee"`      // One-time fee
-	IsActive      bool      `json:"isActive"`
-	Subscribers   int       `json:"subscribers"`
-	CreatedAt     time.Time `json:"createdAt"`
-	UpdatedAt     time.Time `json:"updatedAt"`
+	ID              int64     `json:"id"`
+	Name            string    `json:"name"` // e.g., "Home 20 Mbps"
+	Description     string    `json:"description"`
+	DownloadSpeed   int       `json:"downloadSpeed"`   // in Mbps
+	UploadSpeed     int       `json:"uploadSpeed"`     // in Mbps
+	Quota           int64     `json:"quota"`           // in bytes, 0 = unlimited
+	Price           float64   `json:"price"`           // Monthly price
+	SetupFee        float64   `json:"setupFee"`        // One-time fee
+	VLAN            int       `json:"vlan"`            // Internet WAN VLAN, 0 = none
+	DiscountPercent float64   `json:"discountPercent"` // Off the subtotal of generated invoices
+	IsActive        bool      `json:"isActive"`
+	Subscribers     int       `json:"subscribers"`
+	CreatedAt       time.Time `json:"createdAt"`
+	UpdatedAt       time.Time `json:"updatedAt"`
 }
 
 type DeviceLog struct {
@@ -310,6 +1411,12 @@ type DeviceLog struct {
 	ChangedAt time.Time `json:"changedAt"`
 }
 
+// RebootCount is the number of detected reboots of a device on one day
+type RebootCount struct {
+	Date  string `json:"date"` // YYYY-MM-DD
+	Count int    `json:"count"`
+}
+
 // Invoice represents a monthly bill
 type Invoice struct {
 	ID         int64     `json:"id"`
@@ -348,6 +1455,60 @@ const (
 	InvoiceCombined  InvoiceStatus = "combined"
 )
 
+// Currency describes how amounts are rounded and displayed
+type Currency struct {
+	Symbol   string  // e.g. "Rp"
+	Decimals int     // Digits after the decimal point; 0 for Rupiah
+	Rounding float64 // Round computed amounts to a multiple of this (1, 100, 1000); 0 = no rounding
+}
+
+// Round rounds an amount half-up to the configured step and decimal precision
+func (c Currency) Round(amount float64) float64 {
+	if c.Rounding > 0 {
+		amount = math.Round(amount/c.Rounding) * c.Rounding
+	}
+	scale := math.Pow10(c.Decimals)
+	return math.Round(amount*scale) / scale
+}
+
+// Format renders an amount with the currency symbol and thousands separators.
+// Zero-decimal currencies use Indonesian grouping ("Rp 150.000"); others use
+// "1,234.56". Only the decimal precision is applied, not the rounding step.
+func (c Currency) Format(amount float64) string {
+	thousandSep, decimalSep := ",", "."
+	if c.Decimals == 0 {
+		thousandSep = "."
+	}
+
+	negative := amount < 0
+	digits := strconv.FormatFloat(math.Abs(amount), 'f', c.Decimals, 64)
+	intPart, fracPart := digits, ""
+	if i := strings.IndexByte(digits, '.'); i >= 0 {
+		intPart, fracPart = digits[:i], digits[i+1:]
+	}
+
+	var b strings.Builder
+	for i, ch := range intPart {
+		if i > 0 && (len(intPart)-i)%3 == 0 {
+			b.WriteString(thousandSep)
+		}
+		b.WriteRune(ch)
+	}
+	if fracPart != "" {
+		b.WriteString(decimalSep)
+		b.WriteString(fracPart)
+	}
+
+	sign := ""
+	if negative && strings.Trim(digits, "0.") != "" {
+		sign = "-"
+	}
+	if c.Symbol == "" {
+		return sign + b.String()
+	}
+	return sign + c.Symbol + " " + b.String()
+}
+
 // InvoiceItem represents a line item in an invoice
 type InvoiceItem struct {
 	ID          int64   `json:"id"`
@@ -358,6 +1519,20 @@ type InvoiceItem struct {
 	Amount      float64 `json:"amount"`
 }
 
+// ProrateFirstMonth returns what a customer who joined on joinDate owes for
+// the monthly period starting at periodStart: price * remaining days (join day
+// included) / days in the month. Customers who joined in another month or on
+// the 1st pay the full price and prorated is false.
+func ProrateFirstMonth(price float64, joinDate, periodStart time.Time) (amount float64, days, daysInMonth int, prorated bool) {
+	joinDate = joinDate.In(periodStart.Location())
+	if joinDate.Year() != periodStart.Year() || joinDate.Month() != periodStart.Month() || joinDate.Day() <= 1 {
+		return price, 0, 0, false
+	}
+	daysInMonth = time.Date(periodStart.Year(), periodStart.Month()+1, 0, 0, 0, 0, 0, periodStart.Location()).Day()
+	days = daysInMonth - joinDate.Day() + 1
+	return price * float64(days) / float64(daysInMonth), days, daysInMonth, true
+}
+
 // Payment represents a payment record
 type Payment struct {
 	ID            int64     `json:"id"`
@@ -375,6 +1550,43 @@ type Payment struct {
 	UpdatedAt     time.Time `json:"updatedAt"`
 }
 
+// InvoiceRunCustomer identifies a customer that needs attention after an invoice run
+type InvoiceRunCustomer struct {
+	CustomerID   int64  `json:"customerId"`
+	CustomerCode string `json:"customerCode"`
+	Name         string `json:"name"`
+	Reason       string `json:"reason"`
+}
+
+// InvoiceRunReport summarizes a monthly invoice generation run
+type InvoiceRunReport struct {
+	Generated      int                  `json:"generated"`
+	Skipped        []InvoiceRunCustomer `json:"skipped"`        // Not billed, e.g. no package
+	DefaultPackage []InvoiceRunCustomer `json:"defaultPackage"` // Billed with the configured default package
+}
+
+// StatementLine is one invoice or payment on a customer statement
+type StatementLine struct {
+	Date      time.Time `json:"date"`
+	Type      string    `json:"type"` // invoice, payment
+	Reference string    `json:"reference"`
+	Debit     float64   `json:"debit"`
+	Credit    float64   `json:"credit"`
+	Balance   float64   `json:"balance"` // Running balance after this line
+}
+
+// Statement lists a customer's invoices and payments for a period with a running balance
+type Statement struct {
+	Customer       *Customer       `json:"customer"`
+	From           *time.Time      `json:"from,omitempty"`
+	To             time.Time       `json:"to"`
+	OpeningBalance float64         `json:"openingBalance"`
+	TotalInvoiced  float64         `json:"totalInvoiced"`
+	TotalPaid      float64         `json:"totalPaid"`
+	ClosingBalance float64         `json:"closingBalance"`
+	Lines          []StatementLine `json:"lines"`
+}
+
 // SupportTicket represents a customer support ticket
 type SupportTicket struct {
 	ID          int64      `json:"id"`
@@ -391,6 +1603,165 @@ type SupportTicket struct {
 	CreatedAt   time.Time  `json:"createdAt"`
 	UpdatedAt   time.Time  `json:"updatedAt"`
 	ClosedAt    *time.Time `json:"closedAt,omitempty"`
+	// Customer satisfaction (1-5), given once the ticket is resolved or closed
+	Rating        *int       `json:"rating,omitempty"`
+	RatingComment string     `json:"ratingComment,omitempty"`
+	RatedAt       *time.Time `json:"ratedAt,omitempty"`
+}
+
+// TicketStats aggregates ticket counts and customer satisfaction ratings
+type TicketStats struct {
+	Total         int64            `json:"total"`
+	ByStatus      map[string]int64 `json:"byStatus"`
+	Rated         int64            `json:"rated"`
+	AverageRating float64          `json:"averageRating"`
+	RatingCounts  map[int]int64    `json:"ratingCounts"` // Rating (1-5) -> number of tickets
+}
+
+// Ticket auto-assignment modes (TICKET_AUTO_ASSIGN)
+const (
+	TicketAssignOff        = "off"
+	TicketAssignRoundRobin = "round_robin" // Least recently assigned available technician
+	TicketAssignArea       = "area"        // Prefer technicians covering the customer's address, then round-robin
+)
+
+// Technician is a user who can be auto-assigned support tickets
+type Technician struct {
+	ID             int64      `json:"id"`
+	UserID         int64      `json:"userId"`
+	Username       string     `json:"username"`
+	Areas          []string   `json:"areas"`  // Keywords matched against the customer address, e.g. "Sukamaju"
+	Skills         []string   `json:"skills"` // Ticket categories handled; empty = any
+	Available      bool       `json:"available"`
+	LastAssignedAt *time.Time `json:"lastAssignedAt,omitempty"`
+	CreatedAt      time.Time  `json:"createdAt"`
+}
+
+func (t *Technician) handles(category string) bool {
+	if len(t.Skills) == 0 {
+		return true
+	}
+	for _, s := range t.Skills {
+		if strings.EqualFold(s, category) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Technician) covers(address string) bool {
+	address = strings.ToLower(address)
+	for _, a := range t.Areas {
+		if a != "" && strings.Contains(address, strings.ToLower(a)) {
+			return true
+		}
+	}
+	return false
+}
+
+// SelectTechnician picks the technician for a new ticket: available ones with
+// a matching skill, narrowed to those covering the address in area mode (when
+// any do), then the least recently assigned. Returns nil when none qualifies.
+func SelectTechnician(techs []*Technician, mode, address, category string) *Technician {
+	var candidates, local []*Technician
+	for _, t := range techs {
+		if !t.Available || !t.handles(category) {
+			continue
+		}
+		candidates = append(candidates, t)
+		if t.covers(address) {
+			local = append(local, t)
+		}
+	}
+	if mode == TicketAssignArea && len(local) > 0 {
+		candidates = local
+	}
+
+	var best *Technician
+	for _, t := range candidates {
+		switch {
+		case best == nil:
+			best = t
+		case t.LastAssignedAt == nil && best.LastAssignedAt != nil:
+			best = t
+		case t.LastAssignedAt != nil && best.LastAssignedAt != nil && t.LastAssignedAt.Before(*best.LastAssignedAt):
+			best = t
+		}
+	}
+	return best
+}
+
+// Announcement is a notice (e.g. planned maintenance) shown on the customer
+// portal dashboard between StartsAt and EndsAt
+type Announcement struct {
+	ID        int64      `json:"id"`
+	Title     string     `json:"title"`
+	Message   string     `json:"message"`
+	Area      string     `json:"area"` // Keyword matched against the customer address; empty = all customers
+	StartsAt  time.Time  `json:"startsAt"`
+	EndsAt    *time.Time `json:"endsAt,omitempty"` // nil = until deleted
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+// ActiveFor reports whether the announcement is showing at now for a customer
+// with the given address
+func (a *Announcement) ActiveFor(address string, now time.Time) bool {
+	if now.Before(a.StartsAt) || (a.EndsAt != nil && !now.Before(*a.EndsAt)) {
+		return false
+	}
+	return a.Area == "" || strings.Contains(strings.ToLower(address), strings.ToLower(a.Area))
+}
+
+// CallbackEvent is a persisted payment gateway callback, kept for retry and audit
+type CallbackEvent struct {
+	ID            int64           `json:"id"`
+	Gateway       string          `json:"gateway"`
+	Reference     string          `json:"reference"`
+	InvoiceNo     string          `json:"invoiceNo"`
+	Status        string          `json:"status"` // gateway status, e.g. PAID
+	Payload       json.RawMessage `json:"payload"`
+	ProcessStatus string          `json:"processStatus"` // received, processed, failed, dead, rejected
+	Attempts      int             `json:"attempts"`
+	LastError     string          `json:"lastError,omitempty"`
+	CreatedAt     time.Time       `json:"createdAt"`
+	UpdatedAt     time.Time       `json:"updatedAt"`
+}
+
+// OutboxMessage is a customer notification waiting to be delivered, or
+// retried while its provider is down
+type OutboxMessage struct {
+	ID            int64      `json:"id"`
+	Channel       string     `json:"channel"` // email, whatsapp, fcm
+	Recipient     string     `json:"recipient"`
+	Subject       string     `json:"subject,omitempty"` // Email subject or push title
+	Body          string     `json:"body"`
+	Status        string     `json:"status"` // pending, sending, sent, failed
+	Attempts      int        `json:"attempts"`
+	LastError     string     `json:"lastError,omitempty"`
+	NextAttemptAt time.Time  `json:"nextAttemptAt"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	SentAt        *time.Time `json:"sentAt,omitempty"`
+}
+
+// Outbox statuses
+const (
+	OutboxPending = "pending"
+	OutboxSending = "sending"
+	OutboxSent    = "sent"
+	OutboxFailed  = "failed" // Gave up after the retry window
+)
+
+// OutboxRetryDelay is how long a message waits before its next attempt after
+// attempts failures: 30s doubling up to 30 minutes
+func OutboxRetryDelay(attempts int) time.Duration {
+	delay := 30 * time.Second
+	for i := 1; i < attempts && delay < 30*time.Minute; i++ {
+		delay *= 2
+	}
+	if delay > 30*time.Minute {
+		delay = 30 * time.Minute
+	}
+	return delay
 }
 
 // BillingStats represents billing dashboard statistics
@@ -402,6 +1773,10 @@ type BillingStats struct {
 	PendingInvoices    int64   `json:"pendingInvoices"`
 	OverdueAmount      float64 `json:"overdueAmount"`
 	TodayPayments      float64 `json:"todayPayments"`
+	ARPU               float64 `json:"arpu"`      // This month's completed payments per active customer
+	ChurnRate          float64 `json:"churnRate"` // % of start-of-month customers terminated this month
+	ChurnedCustomers   int64   `json:"churnedCustomers"`
+	NewCustomers       int64   `json:"newCustomers"`
 }
 
 // BandwidthRecord represents a bandwidth usage snapshot
@@ -411,6 +1786,17 @@ type BandwidthRecord struct {
 	BytesReceived int64     `json:"bytesReceived"`
 }
 
+// OpticalSample is a periodic snapshot of a device's optical values, kept
+// for signal trend graphs. Values the device doesn't report are zero.
+type OpticalSample struct {
+	RXPower     float64   `json:"rxPower"`
+	TXPower     float64   `json:"txPower"`
+	Temperature float64   `json:"temperature"`
+	Voltage     float64   `json:"voltage"`
+	BiasCurrent float64   `json:"biasCurrent"`
+	RecordedAt  time.Time `json:"recordedAt"`
+}
+
 // NetworkStats represents aggregated network statistics
 type NetworkStats struct {
 	TotalDownload int64       `json:"totalDownload"`
@@ -435,6 +1821,47 @@ type CustomerLocation struct {
 	Address      string  `json:"address"`
 }
 
+// SearchResults holds the matches of a unified search, grouped by category
+type SearchResults struct {
+	Query     string           `json:"query"`
+	Devices   []SearchDevice   `json:"devices"`
+	Customers []SearchCustomer `json:"customers"`
+	Invoices  []SearchInvoice  `json:"invoices"`
+}
+
+// SearchDevice is a device matched by serial, model, IP or MAC
+type SearchDevice struct {
+	ID            int64  `json:"id"`
+	SerialNumber  string `json:"serialNumber"`
+	Manufacturer  string `json:"manufacturer"`
+	ModelName     string `json:"modelName"`
+	IPAddress     string `json:"ipAddress"`
+	MACAddress    string `json:"macAddress"`
+	Status        string `json:"status"`
+	CustomerID    *int64 `json:"customerId,omitempty"`
+	PPPoEUsername string `json:"pppoeUsername"`
+}
+
+// SearchCustomer is a customer matched by code, name, phone or PPPoE username
+type SearchCustomer struct {
+	ID           int64  `json:"id"`
+	CustomerCode string `json:"customerCode"`
+	Name         string `json:"name"`
+	Phone        string `json:"phone"`
+	Status       string `json:"status"`
+}
+
+// SearchInvoice is an invoice matched by number
+type SearchInvoice struct {
+	ID           int64         `json:"id"`
+	InvoiceNo    string        `json:"invoiceNo"`
+	CustomerID   int64         `json:"customerId"`
+	CustomerName string        `json:"customerName"`
+	Total        float64       `json:"total"`
+	Status       InvoiceStatus `json:"status"`
+	DueDate      time.Time     `json:"dueDate"`
+}
+
 // ConnectedClient represents a device connected to the ONU
 type ConnectedClient struct {
 	Name      string `json:"name"`
@@ -446,6 +1873,156 @@ type ConnectedClient struct {
 	Interface string `json:"interface"`
 }
 
+// ParseConnectedClients builds the LAN host list from a device's stored
+// Hosts.Host.{i}.* parameters. Hosts without a MAC address are skipped.
+func ParseConnectedClients(params []*DeviceParameter) []ConnectedClient {
+	clientsMap := make(map[string]*ConnectedClient)
+
+	for _, p := range params {
+		if strings.Contains(p.Path, "Hosts.Host.") {
+			parts := strings.Split(p.Path, ".")
+			if len(parts) < 6 {
+				continue
+			}
+
+			// Find the index part (it's after 'Host')
+			var idx string
+			for i, part := range parts {
+				if part == "Host" && i+1 < len(parts) {
+					idx = parts[i+1]
+					break
+				}
+			}
+			if idx == "" {
+				continue
+			}
+
+			if clientsMap[idx] == nil {
+				clientsMap[idx] = &ConnectedClient{Active: true, Type: "other"}
+			}
+
+			lastPart := parts[len(parts)-1]
+			switch lastPart {
+			case "HostName":
+				clientsMap[idx].Name = p.Value
+			case "MACAddress":
+				clientsMap[idx].MAC = p.Value
+			case "IPAddress":
+				clientsMap[idx].IP = p.Value
+			case "Active":
+				clientsMap[idx].Active = p.Value == "1" || p.Value == "true"
+			case "InterfaceType":
+				clientsMap[idx].Interface = p.Value
+				if strings.Contains(p.Value, "802.11") || strings.Contains(p.Value, "Wireless") {
+					clientsMap[idx].Type = "phone"
+				} else {
+					clientsMap[idx].Type = "laptop"
+				}
+			}
+		}
+	}
+
+	var clients []ConnectedClient
+	for _, c := range clientsMap {
+		if c.MAC != "" {
+			if c.Name == "" {
+				c.Name = "Unknown Device"
+			}
+			clients = append(clients, *c)
+		}
+	}
+	return clients
+}
+
+// ClientHistoryEntry is a LAN client (by MAC) seen on a device, with when it
+// was first and last reported as connected
+type ClientHistoryEntry struct {
+	MAC       string    `json:"mac"`
+	Name      string    `json:"name"`
+	IP        string    `json:"ip"`
+	Interface string    `json:"interface"`
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+}
+
+// WiFi client limit alert levels
+const (
+	ClientLimitNear = "near" // Connected clients reached the configured share of the limit
+	ClientLimitOver = "over" // Connected clients reached or exceeded the limit
+)
+
+// WiFiClientLimit returns the MaxAssociatedDevices of WLANConfiguration.1,
+// the one the MaxClients setting writes. Without it, the limits of the enabled
+// WLANs are added up; disabled and guest SSIDs that are switched off don't
+// count. 0 when no WLAN sets a limit.
+func WiFiClientLimit(params []*DeviceParameter) int {
+	limits := make(map[string]int)
+	enabled := make(map[string]bool)
+	for _, p := range params {
+		if !strings.Contains(p.Path, "WLANConfiguration.") {
+			continue
+		}
+		dot := strings.LastIndex(p.Path, ".")
+		instance, field := p.Path[:dot], p.Path[dot+1:]
+		switch field {
+		case "MaxAssociatedDevices":
+			if v, err := strconv.Atoi(p.Value); err == nil && v > 0 {
+				limits[instance] = v
+			}
+		case "Enable":
+			enabled[instance] = p.Value == "1" || strings.EqualFold(p.Value, "true")
+		}
+	}
+
+	for instance, v := range limits {
+		if strings.HasSuffix(instance, "LANDevice.1.WLANConfiguration.1") {
+			return v
+		}
+	}
+	total := 0
+	for instance, v := range limits {
+		if enabled[instance] {
+			total += v
+		}
+	}
+	return total
+}
+
+// CountWiFiClients counts the active clients that are not on a wired port.
+// Hosts that don't report an interface type are counted as wireless.
+func CountWiFiClients(clients []ConnectedClient) int {
+	n := 0
+	for _, c := range clients {
+		if c.Active && !strings.Contains(strings.ToLower(c.Interface), "ethernet") {
+			n++
+		}
+	}
+	return n
+}
+
+// ClientLimitLevel returns a device's WiFi client alert level: ClientLimitOver
+// once clients reach limit, ClientLimitNear once they reach nearPercent of it,
+// "" otherwise. A zero limit or nearPercent disables the alert.
+func ClientLimitLevel(clients, limit int, nearPercent float64) string {
+	if limit <= 0 || nearPercent <= 0 {
+		return ""
+	}
+	switch {
+	case clients >= limit:
+		return ClientLimitOver
+	case float64(clients) >= float64(limit)*nearPercent/100:
+		return ClientLimitNear
+	}
+	return ""
+}
+
+// ClientLimitWorsened reports whether moving between client alert levels
+// should be alerted on
+func ClientLimitWorsened(previous, current string) bool {
+	rank := map[string]int{ClientLimitNear: 1, ClientLimitOver: 2}
+	return rank[current] > rank[previous]
+}
+
 // PONStats represents optical signal statistics
 type PONStats struct {
 	RXPower     float64 `json:"rxPower"`
@@ -456,4 +2033,95 @@ type PONStats struct {
 	ONU_ID      string  `json:"onuId"`
 	Distance    string  `json:"distance"`
 	PONMode     string  `json:"ponMode"`
+	// Derived from RXPower: excellent, good, warning, critical or unknown
+	SignalQuality string `json:"signalQuality"`
+}
+
+// Signal quality labels for optical RX power
+const (
+	SignalExcellent = "excellent"
+	SignalGood      = "good"
+	SignalWarning   = "warning"
+	SignalCritical  = "critical"
+	SignalUnknown   = "unknown"
+)
+
+// RXThresholds are the lower bounds (dBm) of each RX power quality band.
+// Readings above Overload are too hot for the receiver and count as warning.
+type RXThresholds struct {
+	Excellent float64
+	Good      float64
+	Warning   float64
+	Overload  float64
+}
+
+// opticalAlertRank orders optical alert levels from none ("") to critical
+func opticalAlertRank(level string) int {
+	switch level {
+	case SignalWarning:
+		return 1
+	case SignalCritical:
+		return 2
+	}
+	return 0
+}
+
+// rxAlertBand returns the alert level of a reading against the warn and
+// critical thresholds; a zero threshold disables its band
+func rxAlertBand(rx, warn, critical float64) string {
+	switch {
+	case critical != 0 && rx < critical:
+		return SignalCritical
+	case warn != 0 && rx < warn:
+		return SignalWarning
+	}
+	return ""
+}
+
+// OpticalAlertLevel returns a device's new optical alert level ("", warning or
+// critical) given its RX reading and previous level. The levels follow the
+// quality bands of ClassifyRXPower on the low side: below Good is a warning,
+// below Warning critical. A device enters a worse band as soon as it drops
+// below its threshold but only leaves it once RX is hysteresis dB above it, so
+// a reading hovering around a threshold doesn't flap. A zero reading means no
+// measurement and keeps the previous level.
+func OpticalAlertLevel(rx float64, previous string, t RXThresholds, hysteresis float64) string {
+	if rx == 0 {
+		return previous
+	}
+	warn, critical := t.Good, t.Warning
+	level := rxAlertBand(rx, warn, critical)
+	held := rxAlertBand(rx-hysteresis, warn, critical)
+	if opticalAlertRank(held) > opticalAlertRank(previous) {
+		held = previous
+	}
+	if opticalAlertRank(held) > opticalAlertRank(level) {
+		return held
+	}
+	return level
+}
+
+// OpticalAlertWorsened reports whether moving between alert levels is a
+// degradation that should be alerted on
+func OpticalAlertWorsened(previous, current string) bool {
+	return opticalAlertRank(current) > opticalAlertRank(previous)
+}
+
+// ClassifyRXPower maps an RX power reading to a quality label. A zero
+// reading means no measurement and is reported as unknown.
+func ClassifyRXPower(rx float64, t RXThresholds) string {
+	switch {
+	case rx == 0:
+		return SignalUnknown
+	case t.Overload != 0 && rx > t.Overload:
+		return SignalWarning
+	case rx >= t.Excellent:
+		return SignalExcellent
+	case rx >= t.Good:
+		return SignalGood
+	case rx >= t.Warning:
+		return SignalWarning
+	default:
+		return SignalCritical
+	}
 }
@@ -0,0 +1,64 @@
+package models
+
+import "testing"
+
+func TestClassifyRXPower(t *testing.T) {
+	bands := RXThresholds{Excellent: -20, Good: -25, Warning: -27, Overload: -8}
+	for _, tc := range []struct {
+		rx   float64
+		want string
+	}{
+		{-15, SignalExcellent},
+		{-20, SignalExcellent},
+		{-22.4, SignalGood},
+		{-25, SignalGood},
+		{-26, SignalWarning},
+		{-27, SignalWarning},
+		{-27.1, SignalCritical},
+		{-35, SignalCritical},
+		{-7, SignalWarning}, // overloaded receiver
+		{0, SignalUnknown},
+	} {
+		if got := ClassifyRXPower(tc.rx, bands); got != tc.want {
+			t.Errorf("ClassifyRXPower(%v) = %q, want %q", tc.rx, got, tc.want)
+		}
+	}
+
+	// Without an overload limit strong signals stay excellent
+	if got := ClassifyRXPower(-5, RXThresholds{Excellent: -20, Good: -25, Warning: -27}); got != SignalExcellent {
+		t.Errorf("no overload limit: ClassifyRXPower(-5) = %q, want excellent", got)
+	}
+}
+
+func TestOpticalAlertLevelFollowsQualityBands(t *testing.T) {
+	bands := RXThresholds{Excellent: -20, Good: -25, Warning: -27, Overload: -8}
+	for _, tc := range []struct {
+		rx       float64
+		previous string
+		want     string
+	}{
+		{-22, "", ""},
+		{-25.5, "", SignalWarning},
+		{-27.5, "", SignalCritical},
+		{-27.5, SignalWarning, SignalCritical},
+		// Recovery needs the hysteresis margin above the band
+		{-26.5, SignalCritical, SignalCritical},
+		{-25.9, SignalCritical, SignalWarning},
+		{-24.5, SignalWarning, SignalWarning},
+		{-23.9, SignalWarning, ""},
+		{0, SignalWarning, SignalWarning},
+	} {
+		if got := OpticalAlertLevel(tc.rx, tc.previous, bands, 1); got != tc.want {
+			t.Errorf("OpticalAlertLevel(%v, %q) = %q, want %q", tc.rx, tc.previous, got, tc.want)
+		}
+	}
+
+	// The alert agrees with the label shown on the PON page
+	for _, rx := range []float64{-24, -26, -27.5, -30} {
+		label := ClassifyRXPower(rx, bands)
+		level := OpticalAlertLevel(rx, "", bands, 1)
+		if (label == SignalWarning || label == SignalCritical) != (level != "") || (level != "" && level != label) {
+			t.Errorf("RX %v: label %q but alert %q", rx, label, level)
+		}
+	}
+}
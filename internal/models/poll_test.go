@@ -0,0 +1,48 @@
+package models
+
+import "testing"
+
+func TestSelectPollProfile(t *testing.T) {
+	zteAny := &PollProfile{Name: "zte-any", Manufacturer: "ZTE", Enabled: true}
+	zteF6 := &PollProfile{Name: "zte-f6", Manufacturer: "ZTE", ModelName: "F6*", Enabled: true}
+	zteF670 := &PollProfile{Name: "zte-f670", Manufacturer: "zte", ModelName: "F670*", Enabled: true}
+	zteExact := &PollProfile{Name: "zte-f670l", Manufacturer: "ZTE", ModelName: "F670L", Enabled: true}
+	huawei := &PollProfile{Name: "huawei", Manufacturer: "Huawei", ModelName: "HG8245H", Enabled: true}
+	disabled := &PollProfile{Name: "disabled", Manufacturer: "FiberHome", ModelName: "AN5506", Enabled: false}
+	all := []*PollProfile{zteAny, zteF6, zteF670, zteExact, huawei, disabled}
+
+	for _, tc := range []struct {
+		name         string
+		profiles     []*PollProfile
+		manufacturer string
+		model        string
+		want         *PollProfile
+	}{
+		{"exact model beats prefixes", all, "ZTE", "F670L", zteExact},
+		{"exact model is case-insensitive", all, "zte", "f670l", zteExact},
+		{"longer prefix beats shorter", all, "ZTE", "F670V9", zteF670},
+		{"prefix beats manufacturer only", all, "ZTE", "F609", zteF6},
+		{"manufacturer only for other models", all, "ZTE", "ZXHN H298A", zteAny},
+		{"manufacturer is a substring match", all, "ZTE Corporation", "F609", zteF6},
+		{"exact model of another vendor", all, "Huawei Technologies", "HG8245H", huawei},
+		{"exact model must match", all, "Huawei", "HG8546M", nil},
+		{"disabled profile ignored", all, "FiberHome", "AN5506", nil},
+		{"unknown manufacturer", all, "Nokia", "G-140W", nil},
+		{"order does not matter", []*PollProfile{zteExact, zteF670, zteF6, zteAny}, "ZTE", "F670V9", zteF670},
+		{"no profiles", nil, "ZTE", "F670L", nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := SelectPollProfile(tc.profiles, tc.manufacturer, tc.model)
+			if got != tc.want {
+				t.Errorf("SelectPollProfile(%q, %q) = %v, want %v", tc.manufacturer, tc.model, profileName(got), profileName(tc.want))
+			}
+		})
+	}
+}
+
+func profileName(p *PollProfile) string {
+	if p == nil {
+		return "<nil>"
+	}
+	return p.Name
+}
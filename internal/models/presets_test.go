@@ -0,0 +1,52 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSameParameterValue(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		want bool
+	}{
+		{"true", "1", true},
+		{"false", "0", true},
+		{"TRUE", "true", true},
+		{"true", "0", false},
+		{"1000000", "1e+06", true},
+		{"10", "10.0", true},
+		{"10", "11", false},
+		{"MyWiFi", "MyWiFi", true},
+		{"MyWiFi", "mywifi", false},
+		{"", "0", false},
+	} {
+		if got := SameParameterValue(tc.a, tc.b); got != tc.want {
+			t.Errorf("SameParameterValue(%q, %q) = %v, want %v", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+func TestPresetParameterValuesKeepNumbersExact(t *testing.T) {
+	for _, provisions := range []string{
+		`{"A.Enable": true, "A.Rate": 1000000, "A.Name": "x"}`,
+		`[{"path": "A.Enable", "value": true}, {"path": "A.Rate", "value": 1000000}, {"path": "A.Name", "value": "x"}]`,
+	} {
+		p := &Preset{Provisions: json.RawMessage(provisions)}
+		values, err := p.ParameterValues()
+		if err != nil {
+			t.Fatalf("ParameterValues(%s): %v", provisions, err)
+		}
+		if values["A.Enable"] != "true" || values["A.Rate"] != "1000000" || values["A.Name"] != "x" {
+			t.Errorf("ParameterValues(%s) = %v", provisions, values)
+		}
+	}
+}
+
+func TestMergePresetParametersHigherWeightWins(t *testing.T) {
+	high := &Preset{Name: "high", Weight: 10, Provisions: json.RawMessage(`{"A.SSID": "high"}`)}
+	low := &Preset{Name: "low", Weight: 1, Provisions: json.RawMessage(`{"A.SSID": "low"}`)}
+	if got := MergePresetParameters([]*Preset{high, low})["A.SSID"]; got != "high" {
+		t.Fatalf("merged SSID = %q, want the higher weight's value", got)
+	}
+}
@@ -0,0 +1,70 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCanaryVerdict(t *testing.T) {
+	tests := []struct {
+		name            string
+		canary, control RolloutHealth
+		maxFailure      float64
+		proceed         bool
+	}{
+		{"all applied", RolloutHealth{Devices: 10, Applied: 10}, RolloutHealth{Devices: 90}, 10, true},
+		{"canary fails, control healthy", RolloutHealth{Devices: 10, Applied: 7, Failed: 3}, RolloutHealth{Devices: 90}, 10, false},
+		{"canary goes offline", RolloutHealth{Devices: 10, Applied: 8, Offline: 2}, RolloutHealth{Devices: 90, Offline: 1}, 10, false},
+		{"canary never applied", RolloutHealth{Devices: 4, Applied: 2, Pending: 2}, RolloutHealth{Devices: 40}, 25, false},
+		{"at the threshold", RolloutHealth{Devices: 10, Applied: 9, Failed: 1}, RolloutHealth{Devices: 90}, 10, true},
+		// A PON outage takes down both groups alike: not the change's fault
+		{"control degrades as much", RolloutHealth{Devices: 10, Applied: 6, Offline: 4}, RolloutHealth{Devices: 90, Offline: 36}, 10, true},
+		{"control degrades more", RolloutHealth{Devices: 10, Applied: 8, Offline: 2}, RolloutHealth{Devices: 90, Offline: 45}, 10, true},
+		{"canary degrades beyond the control", RolloutHealth{Devices: 10, Applied: 2, Failed: 4, Offline: 4}, RolloutHealth{Devices: 90, Offline: 27}, 10, false},
+		{"empty control", RolloutHealth{Devices: 5, Applied: 5}, RolloutHealth{}, 0, true},
+		{"zero tolerance", RolloutHealth{Devices: 10, Applied: 9, Failed: 1}, RolloutHealth{Devices: 90}, 0, false},
+	}
+	for _, tt := range tests {
+		proceed, reason := CanaryVerdict(tt.canary, tt.control, tt.maxFailure)
+		if proceed != tt.proceed {
+			t.Errorf("%s: proceed = %v (%s), want %v", tt.name, proceed, reason, tt.proceed)
+		}
+		if !proceed && !strings.Contains(reason, "exceeds control") {
+			t.Errorf("%s: abort reason %q does not compare against the control", tt.name, reason)
+		}
+	}
+}
+
+func TestRolloutHealthFailurePercent(t *testing.T) {
+	tests := []struct {
+		health RolloutHealth
+		want   float64
+	}{
+		{RolloutHealth{}, 0},
+		{RolloutHealth{Devices: 4, Applied: 4}, 0},
+		{RolloutHealth{Devices: 4, Failed: 1, Offline: 1, Pending: 1, Applied: 1}, 75},
+		{RolloutHealth{Devices: 8, Offline: 2, Applied: 6}, 25},
+	}
+	for _, tt := range tests {
+		if got := tt.health.FailurePercent(); got != tt.want {
+			t.Errorf("FailurePercent(%+v) = %v, want %v", tt.health, got, tt.want)
+		}
+	}
+}
+
+func TestCanarySize(t *testing.T) {
+	tests := []struct {
+		total, percent, want int
+	}{
+		{100, 10, 10},
+		{95, 10, 10}, // rounded up
+		{5, 10, 1},   // at least one device
+		{3, 100, 3},
+		{0, 10, 0},
+	}
+	for _, tt := range tests {
+		if got := CanarySize(tt.total, tt.percent); got != tt.want {
+			t.Errorf("CanarySize(%d, %d) = %d, want %d", tt.total, tt.percent, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSelectTechnician(t *testing.T) {
+	earlier := time.Date(2026, 10, 1, 8, 0, 0, 0, time.UTC)
+	later := earlier.Add(time.Hour)
+	andi := &Technician{Username: "andi", Available: true, Areas: []string{"Cibubur"}, LastAssignedAt: &later}
+	budi := &Technician{Username: "budi", Available: true, Areas: []string{"Sukamaju"}, LastAssignedAt: &earlier}
+	cici := &Technician{Username: "cici", Available: true, Skills: []string{"Billing"}}
+	dedi := &Technician{Username: "dedi", Available: false}
+	techs := []*Technician{andi, budi, cici, dedi}
+
+	for _, tc := range []struct {
+		name     string
+		techs    []*Technician
+		mode     string
+		address  string
+		category string
+		want     *Technician
+	}{
+		{"never assigned goes first", techs, TicketAssignRoundRobin, "", "billing", cici},
+		{"least recently assigned", techs, TicketAssignRoundRobin, "", "connection", budi},
+		{"area mode prefers covering technician", techs, TicketAssignArea, "Jl. Raya CIBUBUR 1", "connection", andi},
+		{"round robin ignores the area", techs, TicketAssignRoundRobin, "Jl. Raya Cibubur 1", "connection", budi},
+		{"area mode falls back to round robin", techs, TicketAssignArea, "Depok", "connection", budi},
+		{"unavailable technicians skipped", []*Technician{dedi}, TicketAssignRoundRobin, "", "", nil},
+		{"skill must match", []*Technician{cici}, TicketAssignRoundRobin, "", "connection", nil},
+		{"no technicians", nil, TicketAssignArea, "Sukamaju", "", nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := SelectTechnician(tc.techs, tc.mode, tc.address, tc.category)
+			if got != tc.want {
+				name := "<nil>"
+				if got != nil {
+					name = got.Username
+				}
+				t.Errorf("SelectTechnician = %s", name)
+			}
+		})
+	}
+}
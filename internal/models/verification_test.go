@@ -0,0 +1,53 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestVerifiableWrites(t *testing.T) {
+	writes := VerifiableWrites([]byte(`{
+		"InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.SSID": "Home",
+		"InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.Enable": true,
+		"InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.MaxBitRate": 1000000,
+		"InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.KeyPassphrase": "s3cret"
+	}`))
+	want := map[string]string{
+		"InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.SSID":       "Home",
+		"InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.Enable":     "true",
+		"InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.MaxBitRate": "1000000",
+	}
+	if !reflect.DeepEqual(writes, want) {
+		t.Errorf("VerifiableWrites = %v, want %v (secrets left out, numbers as written)", writes, want)
+	}
+	if writes := VerifiableWrites([]byte(`["not", "a", "map"]`)); writes != nil {
+		t.Errorf("VerifiableWrites of a list = %v, want nil", writes)
+	}
+}
+
+func TestCompareParameterValues(t *testing.T) {
+	tests := []struct {
+		name               string
+		intended, readBack map[string]string
+		want               []ParameterMismatch
+	}{
+		{"applied", map[string]string{"A": "Home", "B": "10"}, map[string]string{"A": "Home", "B": "10", "C": "extra"}, nil},
+		{"surrounding whitespace", map[string]string{"A": "Home"}, map[string]string{"A": " Home\n"}, nil},
+		{"boolean spellings", map[string]string{"A": "1", "B": "false", "C": "TRUE"}, map[string]string{"A": "true", "B": "0", "C": "1"}, nil},
+		{"drifted value", map[string]string{"A": "Home"}, map[string]string{"A": "Home-old"},
+			[]ParameterMismatch{{Path: "A", Expected: "Home", Actual: "Home-old"}}},
+		{"boolean not applied", map[string]string{"A": "1"}, map[string]string{"A": "false"},
+			[]ParameterMismatch{{Path: "A", Expected: "1", Actual: "false"}}},
+		{"not a boolean", map[string]string{"A": "1"}, map[string]string{"A": "yes"},
+			[]ParameterMismatch{{Path: "A", Expected: "1", Actual: "yes"}}},
+		{"case matters outside booleans", map[string]string{"A": "home"}, map[string]string{"A": "Home"},
+			[]ParameterMismatch{{Path: "A", Expected: "home", Actual: "Home"}}},
+		{"missing and drifted, sorted by path", map[string]string{"C": "3", "A": "1x", "B": "2"}, map[string]string{"A": "1y", "B": "2"},
+			[]ParameterMismatch{{Path: "A", Expected: "1x", Actual: "1y"}, {Path: "C", Expected: "3", Missing: true}}},
+	}
+	for _, tt := range tests {
+		if got := CompareParameterValues(tt.intended, tt.readBack); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: mismatches = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
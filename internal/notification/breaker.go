@@ -0,0 +1,130 @@
+package notification
+
+import (
+	"sync"
+	"time"
+)
+
+// Breaker states
+const (
+	BreakerClosed   = "closed"    // Provider healthy, sends go through
+	BreakerOpen     = "open"      // Provider down, sends wait in the outbox
+	BreakerHalfOpen = "half_open" // Cooldown over, one probe send is in flight
+)
+
+// Breaker is a circuit breaker for one notification provider. After
+// threshold consecutive failures it opens and holds sends back for cooldown,
+// then lets a single probe through: success closes it again, failure
+// reopens it for another cooldown.
+type Breaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+
+	state       string
+	failures    int
+	lastError   string
+	lastFailure time.Time
+	openedAt    time.Time
+}
+
+// ProviderHealth is a snapshot of a breaker for the dashboard
+type ProviderHealth struct {
+	Provider    string     `json:"provider"`
+	State       string     `json:"state"`
+	Failures    int        `json:"failures"` // Consecutive failures
+	LastError   string     `json:"lastError,omitempty"`
+	LastFailure *time.Time `json:"lastFailureAt,omitempty"`
+	RetryAt     *time.Time `json:"retryAt,omitempty"` // When an open breaker lets a probe through
+}
+
+// NewBreaker creates a closed breaker
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	if threshold < 1 {
+		threshold = 1
+	}
+	if cooldown <= 0 {
+		cooldown = time.Minute
+	}
+	return &Breaker{threshold: threshold, cooldown: cooldown, state: BreakerClosed}
+}
+
+// Allow reports whether a send may be attempted now. Once the cooldown of an
+// open breaker is over, the first caller gets the probe and the rest keep
+// waiting until its result is known.
+func (b *Breaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerClosed:
+		return true
+	case BreakerOpen:
+		if time.Since(b.openedAt) >= b.cooldown {
+			b.state = BreakerHalfOpen
+			return true
+		}
+	}
+	return false
+}
+
+// State returns the current breaker state
+func (b *Breaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Release hands back a probe that was never sent, reopening the breaker
+// without a new cooldown so the next caller can probe instead
+func (b *Breaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerHalfOpen {
+		b.state = BreakerOpen
+	}
+}
+
+// Success records a delivered message and closes the breaker. It reports
+// whether the breaker was open, i.e. the provider just recovered.
+func (b *Breaker) Success() (recovered bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	recovered = b.state != BreakerClosed
+	b.state = BreakerClosed
+	b.failures = 0
+	return recovered
+}
+
+// Failure records a failed send. It reports whether this failure opened the
+// breaker, so the caller can alert once per outage.
+func (b *Breaker) Failure(err error) (opened bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.lastFailure = time.Now()
+	if err != nil {
+		b.lastError = err.Error()
+	}
+	if b.state == BreakerHalfOpen || (b.state == BreakerClosed && b.failures >= b.threshold) {
+		opened = b.state == BreakerClosed
+		b.state = BreakerOpen
+		b.openedAt = b.lastFailure
+	}
+	return opened
+}
+
+// Health returns the current state of the breaker
+func (b *Breaker) Health(provider string) ProviderHealth {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	h := ProviderHealth{Provider: provider, State: b.state, Failures: b.failures, LastError: b.lastError}
+	if !b.lastFailure.IsZero() {
+		t := b.lastFailure
+		h.LastFailure = &t
+	}
+	if b.state == BreakerOpen {
+		t := b.openedAt.Add(b.cooldown)
+		h.RetryAt = &t
+	}
+	return h
+}
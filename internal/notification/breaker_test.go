@@ -0,0 +1,68 @@
+package notification
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreakerOpensAfterThreshold(t *testing.T) {
+	b := NewBreaker(3, time.Hour)
+	for i := 0; i < 2; i++ {
+		if b.Failure(errors.New("down")) {
+			t.Fatalf("failure %d opened the breaker early", i+1)
+		}
+	}
+	if !b.Failure(errors.New("down")) {
+		t.Fatal("third failure did not open the breaker")
+	}
+	if b.State() != BreakerOpen || b.Allow() {
+		t.Fatalf("open breaker allowed a send during cooldown (state %s)", b.State())
+	}
+	if h := b.Health("whatsapp"); h.RetryAt == nil || h.LastError != "down" || h.Failures != 3 {
+		t.Fatalf("unexpected health %+v", h)
+	}
+}
+
+func TestBreakerProbe(t *testing.T) {
+	b := NewBreaker(1, 10*time.Millisecond)
+	b.Failure(errors.New("down"))
+	time.Sleep(15 * time.Millisecond)
+
+	if !b.Allow() {
+		t.Fatal("no probe after cooldown")
+	}
+	if b.Allow() {
+		t.Fatal("second caller got a probe while one is in flight")
+	}
+	if b.Failure(errors.New("still down")) {
+		t.Fatal("failed probe reported a new outage")
+	}
+	if b.State() != BreakerOpen {
+		t.Fatalf("failed probe left state %s, want open", b.State())
+	}
+
+	time.Sleep(15 * time.Millisecond)
+	b.Allow()
+	if !b.Success() {
+		t.Fatal("successful probe did not report recovery")
+	}
+	if b.State() != BreakerClosed {
+		t.Fatalf("state after recovery = %s, want closed", b.State())
+	}
+}
+
+func TestBreakerRelease(t *testing.T) {
+	b := NewBreaker(1, 10*time.Millisecond)
+	b.Failure(errors.New("down"))
+	time.Sleep(15 * time.Millisecond)
+	b.Allow()
+
+	b.Release()
+	if b.State() != BreakerOpen {
+		t.Fatalf("state after release = %s, want open", b.State())
+	}
+	if !b.Allow() {
+		t.Fatal("released probe was not handed to the next caller")
+	}
+}
@@ -41,6 +41,10 @@ func New(cfg *config.Config) *Client {
 
 // Send sends a push notification to a specific token
 func (c *Client) Send(token, title, body string) error {
+	if c.cfg.TestMode {
+		log.Printf("[MOCK FCM] Token: %s | %s: %s", token, title, body)
+		return nil
+	}
 	if c.app == nil {
 		return nil // FCM not initialized
 	}
@@ -0,0 +1,43 @@
+package notification
+
+import "sync"
+
+// Pool runs notification sends on a fixed number of workers so bulk jobs
+// (e.g. monthly invoice generation) cannot spawn one goroutine per message
+// and overwhelm the provider or exhaust sockets.
+type Pool struct {
+	jobs chan func()
+	wg   sync.WaitGroup
+}
+
+// NewPool starts a pool with the given number of workers. Up to queueSize
+// jobs are buffered; Submit blocks once the buffer is full.
+func NewPool(workers, queueSize int) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+	p := &Pool{jobs: make(chan func(), queueSize)}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for job := range p.jobs {
+				job()
+				p.wg.Done()
+			}
+		}()
+	}
+	return p
+}
+
+// Submit queues a send, blocking while the queue is full
+func (p *Pool) Submit(job func()) {
+	p.wg.Add(1)
+	p.jobs <- job
+}
+
+// Wait blocks until every submitted send has finished
+func (p *Pool) Wait() {
+	p.wg.Wait()
+}
@@ -0,0 +1,64 @@
+package notification
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// inFlight records the highest number of jobs running at once
+type inFlight struct {
+	cur, max atomic.Int32
+}
+
+func (f *inFlight) run() {
+	n := f.cur.Add(1)
+	for {
+		m := f.max.Load()
+		if n <= m || f.max.CompareAndSwap(m, n) {
+			break
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+	f.cur.Add(-1)
+}
+
+func TestPoolStaysWithinWorkerCap(t *testing.T) {
+	for _, workers := range []int{1, 3, 8} {
+		var f inFlight
+		var done atomic.Int32
+		p := NewPool(workers, 4)
+		for i := 0; i < 40; i++ {
+			p.Submit(func() {
+				f.run()
+				done.Add(1)
+			})
+		}
+		p.Wait()
+		if got := done.Load(); got != 40 {
+			t.Errorf("workers=%d: %d jobs ran, want 40", workers, got)
+		}
+		if got := f.max.Load(); got > int32(workers) {
+			t.Errorf("workers=%d: %d jobs ran at once", workers, got)
+		}
+	}
+}
+
+func TestPoolClampsWorkerCount(t *testing.T) {
+	var f inFlight
+	p := NewPool(0, -1)
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.Submit(f.run)
+		}()
+	}
+	wg.Wait()
+	p.Wait()
+	if got := f.max.Load(); got != 1 {
+		t.Errorf("%d jobs ran at once with 0 workers, want 1", got)
+	}
+}
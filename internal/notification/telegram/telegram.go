@@ -13,6 +13,9 @@ import (
 type Client struct {
 	Token  string
 	ChatID string
+
+	// TestMode, when set and returning true, logs messages instead of sending them
+	TestMode func() bool
 }
 
 // New creates a new Telegram client
@@ -32,6 +35,10 @@ type Message struct {
 
 // SendMessage sends a message to Telegram
 func (c *Client) SendMessage(message string) error {
+	if c.TestMode != nil && c.TestMode() {
+		fmt.Printf("[MOCK TELEGRAM] %s\n", message)
+		return nil
+	}
 	if c.Token == "" || c.ChatID == "" {
 		return fmt.Errorf("telegram token or chat_id not configured")
 	}
@@ -0,0 +1,41 @@
+package telegram
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+// countingTransport fails every request and counts how many were attempted
+type countingTransport struct{ requests int }
+
+func (c *countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	c.requests++
+	return nil, errors.New("network disabled in tests")
+}
+
+func TestTestModeSendsNothing(t *testing.T) {
+	transport := &countingTransport{}
+	saved := http.DefaultTransport
+	http.DefaultTransport = transport
+	t.Cleanup(func() { http.DefaultTransport = saved })
+
+	testMode := true
+	c := New("123:token", "-100123")
+	c.TestMode = func() bool { return testMode }
+
+	if err := c.SendMessage("Device SN100 offline"); err != nil {
+		t.Fatalf("SendMessage in test mode: %v", err)
+	}
+	if transport.requests != 0 {
+		t.Fatalf("%d requests sent to Telegram in test mode", transport.requests)
+	}
+
+	testMode = false
+	if err := c.SendMessage("Device SN100 offline"); err == nil {
+		t.Fatal("SendMessage succeeded with the network disabled")
+	}
+	if transport.requests != 1 {
+		t.Errorf("%d requests with test mode off, want 1", transport.requests)
+	}
+}
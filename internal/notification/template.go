@@ -0,0 +1,14 @@
+package notification
+
+import "strings"
+
+// Render fills {placeholder} fields in a configurable message template.
+// Unknown placeholders are left as-is and a literal "\n" becomes a newline,
+// so templates can be set from a single-line env var.
+func Render(tpl string, vars map[string]string) string {
+	pairs := []string{`\n`, "\n"}
+	for k, v := range vars {
+		pairs = append(pairs, "{"+k+"}", v)
+	}
+	return strings.NewReplacer(pairs...).Replace(tpl)
+}
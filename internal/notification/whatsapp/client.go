@@ -21,7 +21,7 @@ func New(cfg *config.Config) *Client {
 
 // Send sends a WhatsApp message
 func (c *Client) Send(phone, message string) error {
-	if c.cfg.WAApiKey == "" {
+	if c.cfg.WAApiKey == "" || c.cfg.TestMode {
 		fmt.Printf("[MOCK WA] To: %s | Message: %s\n", phone, message)
 		return nil
 	}
@@ -71,6 +71,22 @@ func GeneratePaymentReceiptMessage(customerName, invoiceNo, paymentDate, amount
 	return fmt.Sprintf("*Pembayaran Diterima - GO-ACS*\n\nHalo %s,\nPembayaran tagihan #%s sebesar %s telah kami terima pada %s.\n\nLayanan Anda aktif kembali/diperpanjang.\nTerima kasih.", customerName, invoiceNo, amount, paymentDate)
 }
 
+func GenerateTicketAutoCloseMessage(customerName, ticketNo string) string {
+	return fmt.Sprintf("*Tiket Akan Ditutup - GO-ACS*\n\nHalo %s,\nTiket #%s sudah kami selesaikan dan akan ditutup otomatis dalam 24 jam.\n\nJika masalah masih terjadi, silahkan hubungi kami sebelum tiket ditutup.\nTerima kasih.", customerName, ticketNo)
+}
+
+func GenerateInvoiceReminderMessage(customerName, invoiceNo, dueDate, amount string, daysLeft int) string {
+	when := "hari ini"
+	if daysLeft > 0 {
+		when = fmt.Sprintf("dalam %d hari", daysLeft)
+	}
+	return fmt.Sprintf("*Pengingat Tagihan - GO-ACS*\n\nHalo %s,\nTagihan #%s sebesar %s jatuh tempo %s (%s).\n\nMohon segera lakukan pembayaran untuk menghindari isolir layanan.\nAbaikan pesan ini jika sudah membayar.", customerName, invoiceNo, amount, when, dueDate)
+}
+
+func GenerateInvoiceOverdueMessage(customerName, invoiceNo, dueDate, amount string) string {
+	return fmt.Sprintf("*Tagihan Terlambat - GO-ACS*\n\nHalo %s,\nTagihan #%s sebesar %s telah melewati jatuh tempo (%s).\n\nSilahkan lakukan pembayaran agar layanan tidak diisolir.\nTerima kasih.", customerName, invoiceNo, amount, dueDate)
+}
+
 func GenerateSuspensionMessage(customerName string) string {
 	return fmt.Sprintf("*Layanan Diisolir - GO-ACS*\n\nHalo %s,\nMohon maaf, layanan internet Anda diisolir sementara karena keterlambatan pembayaran.\n\nSilahkan lakukan pembayaran untuk mengaktifkan kembali layanan otomatis.\nTerima kasih.", customerName)
 }
@@ -0,0 +1,35 @@
+package whatsapp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"go-acs/internal/config"
+)
+
+func TestTestModeSendsNothing(t *testing.T) {
+	var received atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received.Add(1)
+	}))
+	defer srv.Close()
+
+	cfg := &config.Config{WAApiKey: "key", WAProviderURL: srv.URL, TestMode: true}
+	c := New(cfg)
+	if err := c.Send("081234567890", "Tagihan INV-1"); err != nil {
+		t.Fatalf("Send in test mode: %v", err)
+	}
+	if n := received.Load(); n != 0 {
+		t.Fatalf("provider received %d messages in test mode", n)
+	}
+
+	cfg.TestMode = false
+	if err := c.Send("081234567890", "Tagihan INV-1"); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if n := received.Load(); n != 1 {
+		t.Errorf("provider received %d messages with test mode off, want 1", n)
+	}
+}
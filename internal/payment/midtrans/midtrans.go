@@ -0,0 +1,251 @@
+package midtrans
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go-acs/internal/config"
+	"go-acs/internal/payment"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// orderSeparator splits the invoice number from the attempt suffix in a
+// Midtrans order_id. Midtrans rejects a reused order_id, so every checkout
+// attempt for the same invoice gets its own.
+const orderSeparator = "~"
+
+// wib is the timezone Midtrans reports transaction times in
+var wib = time.FixedZone("WIB", 7*60*60)
+
+type MidtransGateway struct {
+	cfg *config.Config
+}
+
+func New(cfg *config.Config) *MidtransGateway {
+	return &MidtransGateway{cfg: cfg}
+}
+
+func (m *MidtransGateway) getSnapURL() string {
+	if m.cfg.MidtransMode == "production" {
+		return "https://app.midtrans.com/snap/v1/transactions"
+	}
+	return "https://app.sandbox.midtrans.com/snap/v1/transactions"
+}
+
+// signature computes the notification signature_key:
+// SHA512(order_id + status_code + gross_amount + server key)
+func (m *MidtransGateway) signature(orderID, statusCode, grossAmount string) string {
+	sum := sha512.Sum512([]byte(orderID + statusCode + grossAmount + m.cfg.MidtransServerKey))
+	return hex.EncodeToString(sum[:])
+}
+
+func (m *MidtransGateway) CreateTransaction(req payment.TransactionRequest) (*payment.TransactionResponse, error) {
+	if m.cfg.TestMode {
+		fmt.Printf("[TEST MODE] Midtrans transaction for %s (%d) not created\n", req.InvoiceID, req.Amount)
+		return sandboxTransaction(req), nil
+	}
+
+	var items []map[string]interface{}
+	for i, item := range req.Items {
+		items = append(items, map[string]interface{}{
+			"id":       fmt.Sprintf("%s-%d", req.InvoiceID, i+1),
+			"name":     item.Name,
+			"price":    item.Price,
+			"quantity": item.Quantity,
+		})
+	}
+
+	payload := map[string]interface{}{
+		"transaction_details": map[string]interface{}{
+			"order_id":     req.InvoiceID + orderSeparator + strconv.FormatInt(time.Now().Unix(), 10),
+			"gross_amount": req.Amount,
+		},
+		"customer_details": map[string]interface{}{
+			"first_name": req.Customer.Name,
+			"email":      req.Customer.Email,
+			"phone":      req.Customer.Phone,
+		},
+		"item_details": items,
+		"callbacks": map[string]interface{}{
+			"finish": req.ReturnURL,
+		},
+		"expiry": map[string]interface{}{
+			"unit":     "hours",
+			"duration": 24,
+		},
+	}
+
+	jsonPayload, _ := json.Marshal(payload)
+	request, _ := http.NewRequest("POST", m.getSnapURL(), bytes.NewBuffer(jsonPayload))
+	request.SetBasicAuth(m.cfg.MidtransServerKey, "")
+	request.Header.Set("Accept", "application/json")
+	request.Header.Set("Content-Type", "application/json")
+	if req.CallbackURL != "" {
+		request.Header.Set("X-Override-Notification", req.CallbackURL)
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+
+	var result struct {
+		Token         string   `json:"token"`
+		RedirectURL   string   `json:"redirect_url"`
+		ErrorMessages []string `json:"error_messages"`
+	}
+	json.Unmarshal(body, &result)
+
+	if resp.StatusCode != http.StatusCreated || result.RedirectURL == "" {
+		// Same as Tripay: keep development going without a merchant account
+		if m.cfg.MidtransMode == "sandbox" && m.cfg.MidtransServerKey == "" {
+			return sandboxTransaction(req), nil
+		}
+		return nil, fmt.Errorf("midtrans error (%d): %s", resp.StatusCode, strings.Join(result.ErrorMessages, "; "))
+	}
+
+	return &payment.TransactionResponse{
+		ReferenceID: result.Token,
+		CheckoutURL: result.RedirectURL,
+		Amount:      req.Amount,
+		Status:      "pending",
+		ExpiryDate:  time.Now().Add(24 * time.Hour).Unix(),
+	}, nil
+}
+
+// sandboxTransaction is the fake pending transaction returned in test mode
+// and in sandbox mode without a server key
+func sandboxTransaction(req payment.TransactionRequest) *payment.TransactionResponse {
+	return &payment.TransactionResponse{
+		ReferenceID: "MIDTRANS-SANDBOX-" + req.InvoiceID,
+		CheckoutURL: "https://app.sandbox.midtrans.com/snap/v4/redirection/sandbox-demo", // Fake URL
+		Amount:      req.Amount,
+		Status:      "pending",
+	}
+}
+
+// channels are the Snap payment methods. Midtrans has no API listing the
+// methods enabled for a merchant; they are configured in its dashboard and
+// Snap only shows the enabled ones.
+var channels = []payment.PaymentChannel{
+	{Code: "bca_va", Name: "BCA Virtual Account", Type: "VA"},
+	{Code: "bni_va", Name: "BNI Virtual Account", Type: "VA"},
+	{Code: "bri_va", Name: "BRI Virtual Account", Type: "VA"},
+	{Code: "permata_va", Name: "Permata Virtual Account", Type: "VA"},
+	{Code: "echannel", Name: "Mandiri Bill Payment", Type: "VA"},
+	{Code: "gopay", Name: "GoPay", Type: "EWALLET"},
+	{Code: "shopeepay", Name: "ShopeePay", Type: "EWALLET"},
+	{Code: "other_qris", Name: "QRIS", Type: "QRIS"},
+	{Code: "alfamart", Name: "Alfamart", Type: "RETAIL"},
+	{Code: "indomaret", Name: "Indomaret", Type: "RETAIL"},
+}
+
+func (m *MidtransGateway) GetChannels() ([]payment.PaymentChannel, error) {
+	return channels, nil
+}
+
+// HandleCallback verifies and parses a Midtrans HTTP notification. The
+// signature_key is always required, and without a server key nothing is
+// accepted: the signature would then be computable by anyone.
+func (m *MidtransGateway) HandleCallback(r *http.Request) (*payment.CallbackData, error) {
+	if m.cfg.MidtransServerKey == "" {
+		return nil, fmt.Errorf("midtrans server key is not configured")
+	}
+
+	// 1. Read Body
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body = io.NopCloser(bytes.NewBuffer(body)) // restore body
+
+	// 2. Parse JSON
+	var payload struct {
+		OrderID           string `json:"order_id"`
+		StatusCode        string `json:"status_code"`
+		GrossAmount       string `json:"gross_amount"`
+		SignatureKey      string `json:"signature_key"`
+		TransactionID     string `json:"transaction_id"`
+		TransactionStatus string `json:"transaction_status"`
+		FraudStatus       string `json:"fraud_status"`
+		PaymentType       string `json:"payment_type"`
+		TransactionTime   string `json:"transaction_time"`
+		SettlementTime    string `json:"settlement_time"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+
+	// 3. Validate Signature
+	if payload.SignatureKey == "" {
+		return nil, fmt.Errorf("missing callback signature")
+	}
+	expected := m.signature(payload.OrderID, payload.StatusCode, payload.GrossAmount)
+	if subtle.ConstantTimeCompare([]byte(strings.ToLower(payload.SignatureKey)), []byte(expected)) != 1 {
+		return nil, fmt.Errorf("invalid callback signature")
+	}
+
+	amount, err := strconv.ParseFloat(payload.GrossAmount, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gross_amount %q", payload.GrossAmount)
+	}
+
+	// 4. Map to CallbackData
+	data := &payment.CallbackData{
+		InvoiceID:     invoiceFromOrderID(payload.OrderID),
+		Status:        mapStatus(payload.TransactionStatus, payload.FraudStatus),
+		Amount:        int64(amount),
+		PaymentMethod: payload.PaymentType,
+		ReferenceID:   payload.TransactionID,
+	}
+	paidAt := payload.SettlementTime
+	if paidAt == "" {
+		paidAt = payload.TransactionTime
+	}
+	if t, err := time.ParseInLocation("2006-01-02 15:04:05", paidAt, wib); err == nil {
+		data.PaidAt = t.Unix()
+	}
+	return data, nil
+}
+
+// invoiceFromOrderID strips the attempt suffix added in CreateTransaction
+func invoiceFromOrderID(orderID string) string {
+	if i := strings.LastIndex(orderID, orderSeparator); i >= 0 {
+		return orderID[:i]
+	}
+	return orderID
+}
+
+// mapStatus translates a Midtrans transaction status into the gateway-neutral
+// PAID, UNPAID, EXPIRED, FAILED or REFUND
+func mapStatus(transactionStatus, fraudStatus string) string {
+	switch transactionStatus {
+	case "settlement":
+		return "PAID"
+	case "capture":
+		// Card payments: only accepted captures are money in the bank
+		if fraudStatus == "" || fraudStatus == "accept" {
+			return "PAID"
+		}
+		return "UNPAID"
+	case "pending":
+		return "UNPAID"
+	case "expire":
+		return "EXPIRED"
+	case "refund", "partial_refund":
+		return "REFUND"
+	default: // deny, cancel, failure
+		return "FAILED"
+	}
+}
@@ -0,0 +1,71 @@
+package midtrans
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go-acs/internal/config"
+	"go-acs/internal/payment"
+)
+
+func callback(m *MidtransGateway, orderID, status, amount string) error {
+	body := `{"order_id":"` + orderID + `","status_code":"` + status + `","gross_amount":"` + amount +
+		`","signature_key":"` + m.signature(orderID, status, amount) + `","transaction_status":"settlement"}`
+	_, err := m.HandleCallback(httptest.NewRequest("POST", "/api/callbacks/midtrans", strings.NewReader(body)))
+	return err
+}
+
+func TestCallbackRejectedWithoutServerKey(t *testing.T) {
+	// With an empty key the "signature" is plain SHA512 of public fields
+	m := New(&config.Config{})
+	if err := callback(m, "INV-1~1", "200", "100000.00"); err == nil {
+		t.Fatal("callback accepted without a server key")
+	}
+}
+
+func TestCallbackSignature(t *testing.T) {
+	m := New(&config.Config{MidtransServerKey: "SB-Mid-server-key"})
+	if err := callback(m, "INV-1~1", "200", "100000.00"); err != nil {
+		t.Fatalf("valid callback rejected: %v", err)
+	}
+
+	forged := New(&config.Config{MidtransServerKey: "guess"})
+	body := `{"order_id":"INV-1~1","status_code":"200","gross_amount":"100000.00","signature_key":"` +
+		forged.signature("INV-1~1", "200", "100000.00") + `"}`
+	if _, err := m.HandleCallback(httptest.NewRequest("POST", "/", strings.NewReader(body))); err == nil {
+		t.Fatal("callback signed with the wrong key accepted")
+	}
+}
+
+// countingTransport fails every request and counts how many were attempted
+type countingTransport struct{ requests int }
+
+func (c *countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	c.requests++
+	return nil, errors.New("network disabled in tests")
+}
+
+func TestTestModeMakesNoRequests(t *testing.T) {
+	transport := &countingTransport{}
+	saved := http.DefaultTransport
+	http.DefaultTransport = transport
+	t.Cleanup(func() { http.DefaultTransport = saved })
+
+	m := New(&config.Config{MidtransServerKey: "SB-Mid-server-key", TestMode: true})
+	tx, err := m.CreateTransaction(payment.TransactionRequest{InvoiceID: "INV-1", Amount: 100000})
+	if err != nil || tx.Status != "pending" || tx.Amount != 100000 {
+		t.Fatalf("CreateTransaction = %+v, %v, want a pending sandbox transaction", tx, err)
+	}
+	if transport.requests != 0 {
+		t.Errorf("%d requests sent to Midtrans in test mode", transport.requests)
+	}
+
+	m = New(&config.Config{MidtransServerKey: "SB-Mid-server-key"})
+	m.CreateTransaction(payment.TransactionRequest{InvoiceID: "INV-1", Amount: 100000})
+	if transport.requests == 0 {
+		t.Error("no request attempted with test mode off")
+	}
+}
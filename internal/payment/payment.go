@@ -46,6 +46,7 @@ type TransactionRequest struct {
 	Items       []Item
 	Description string
 	ReturnURL   string
+	CallbackURL string // Webhook URL for this transaction; empty = the gateway's configured default
 }
 
 type Customer struct {
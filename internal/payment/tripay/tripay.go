@@ -36,6 +36,11 @@ func (t *TripayGateway) sign(payload string) string {
 }
 
 func (t *TripayGateway) CreateTransaction(req payment.TransactionRequest) (*payment.TransactionResponse, error) {
+	if t.cfg.TestMode {
+		fmt.Printf("[TEST MODE] Tripay transaction for %s (%d) not created\n", req.InvoiceID, req.Amount)
+		return sandboxTransaction(req), nil
+	}
+
 	baseURL := t.getBaseURL()
 	endpoint := baseURL + "transaction/create"
 
@@ -61,6 +66,9 @@ func (t *TripayGateway) CreateTransaction(req payment.TransactionRequest) (*paym
 		"expired_time":   time.Now().Add(24 * time.Hour).Unix(), // 24 hours exp
 		"signature":      t.sign(t.cfg.TripayMerchantCode + req.InvoiceID + fmt.Sprintf("%d", req.Amount)),
 	}
+	if req.CallbackURL != "" {
+		payload["callback_url"] = req.CallbackURL
+	}
 
 	jsonPayload, _ := json.Marshal(payload)
 	request, _ := http.NewRequest("POST", endpoint, bytes.NewBuffer(jsonPayload))
@@ -88,12 +96,7 @@ func (t *TripayGateway) CreateTransaction(req payment.TransactionRequest) (*paym
 	if !success {
 		// Just for DEVELOPMENT purposes to proceed without valid API KEY
 		if t.cfg.TripayMode == "sandbox" {
-			return &payment.TransactionResponse{
-				ReferenceID: "TRIPAY-SANDBOX-" + req.InvoiceID,
-				CheckoutURL: "https://tripay.co.id/checkout/sandbox-demo", // Fake URL
-				Amount:      req.Amount,
-				Status:      "pending",
-			}, nil
+			return sandboxTransaction(req), nil
 		}
 		return nil, fmt.Errorf("tripay error: %v", result["message"])
 	}
@@ -107,7 +110,33 @@ func (t *TripayGateway) CreateTransaction(req payment.TransactionRequest) (*paym
 	}, nil
 }
 
+// sandboxTransaction is the fake pending transaction returned in sandbox and
+// test mode so checkout flows can be exercised without a Tripay account
+func sandboxTransaction(req payment.TransactionRequest) *payment.TransactionResponse {
+	return &payment.TransactionResponse{
+		ReferenceID: "TRIPAY-SANDBOX-" + req.InvoiceID,
+		CheckoutURL: "https://tripay.co.id/checkout/sandbox-demo", // Fake URL
+		Amount:      req.Amount,
+		Status:      "pending",
+	}
+}
+
+// sandboxChannels are the mock payment channels used in sandbox and test mode
+var sandboxChannels = []payment.PaymentChannel{
+	{Code: "MYBVA", Name: "Maybank Virtual Account", Type: "VA"},
+	{Code: "PERMATAVA", Name: "Permata Virtual Account", Type: "VA"},
+	{Code: "BNIVA", Name: "BNI Virtual Account", Type: "VA"},
+	{Code: "BRIVA", Name: "BRI Virtual Account", Type: "VA"},
+	{Code: "MANDIRIVA", Name: "Mandiri Virtual Account", Type: "VA"},
+	{Code: "QRIS", Name: "QRIS", Type: "QRIS"},
+	{Code: "ALFAMART", Name: "Alfamart", Type: "RETAIL"},
+}
+
 func (t *TripayGateway) GetChannels() ([]payment.PaymentChannel, error) {
+	if t.cfg.TestMode {
+		return sandboxChannels, nil
+	}
+
 	baseURL := t.getBaseURL()
 	endpoint := baseURL + "merchant/payment-channel"
 
@@ -128,15 +157,7 @@ func (t *TripayGateway) GetChannels() ([]payment.PaymentChannel, error) {
 	if !success {
 		// Mock channels for dev
 		if t.cfg.TripayMode == "sandbox" {
-			return []payment.PaymentChannel{
-				{Code: "MYBVA", Name: "Maybank Virtual Account", Type: "VA"},
-				{Code: "PERMATAVA", Name: "Permata Virtual Account", Type: "VA"},
-				{Code: "BNIVA", Name: "BNI Virtual Account", Type: "VA"},
-				{Code: "BRIVA", Name: "BRI Virtual Account", Type: "VA"},
-				{Code: "MANDIRIVA", Name: "Mandiri Virtual Account", Type: "VA"},
-				{Code: "QRIS", Name: "QRIS", Type: "QRIS"},
-				{Code: "ALFAMART", Name: "Alfamart", Type: "RETAIL"},
-			}, nil
+			return sandboxChannels, nil
 		}
 		return nil, fmt.Errorf("failed to fetch channels")
 	}
@@ -164,21 +185,18 @@ func (t *TripayGateway) HandleCallback(r *http.Request) (*payment.CallbackData,
 	r.Body = io.NopCloser(bytes.NewBuffer(body)) // restore body
 
 	// 2. Validate Signature
-	// Note: In Sandbox, sometimes you might want to skip signature check or user different key
-	// But robust implementation should always check.
+	// Every callback must carry the HMAC of the private key. Unsigned mock
+	// callbacks (Postman/curl) are accepted only in TEST_MODE with no private
+	// key configured.
 	signature := r.Header.Get("X-Callback-Signature")
-	expectedSignature := t.sign(string(body))
-
-	// Compare signatures (use constant time compare in production)
-	if signature != expectedSignature {
-		// Log warning: Signature mismatch
-		// return nil, fmt.Errorf("invalid signature: got %s, want %s", signature, expectedSignature)
-
-		// For DEVELOPMENT with mock callbacks via Postman/Curl, maybe allow skip if signature is empty
-		if t.cfg.TripayMode == "production" || signature != "" {
-			// In real production, UNCOMMENT THIS to enforce security
-			// if signature != expectedSignature { return nil, fmt.Errorf("invalid signature") }
+	if t.cfg.TripayPrivateKey == "" {
+		if !t.cfg.TestMode {
+			return nil, fmt.Errorf("tripay private key is not configured")
 		}
+	} else if signature == "" {
+		return nil, fmt.Errorf("missing callback signature")
+	} else if !hmac.Equal([]byte(signature), []byte(t.sign(string(body)))) {
+		return nil, fmt.Errorf("invalid callback signature")
 	}
 
 	// 3. Parse JSON
@@ -0,0 +1,92 @@
+package tripay
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go-acs/internal/config"
+	"go-acs/internal/payment"
+)
+
+const paidBody = `{"reference":"T1","merchant_ref":"INV-1","total_amount":100000,"status":"PAID"}`
+
+func callback(t *TripayGateway, signature string) error {
+	r := httptest.NewRequest("POST", "/api/callbacks/tripay", strings.NewReader(paidBody))
+	if signature != "" {
+		r.Header.Set("X-Callback-Signature", signature)
+	}
+	_, err := t.HandleCallback(r)
+	return err
+}
+
+func TestCallbackSignature(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		cfg      config.Config
+		sign     bool
+		accepted bool
+	}{
+		{"signed", config.Config{TripayPrivateKey: "key", TripayMode: "sandbox"}, true, true},
+		{"unsigned in sandbox", config.Config{TripayPrivateKey: "key", TripayMode: "sandbox"}, false, false},
+		{"unsigned in test mode with a key", config.Config{TripayPrivateKey: "key", TestMode: true}, false, false},
+		{"no key", config.Config{TripayMode: "sandbox"}, false, false},
+		{"mock in test mode", config.Config{TestMode: true}, false, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := tc.cfg
+			gw := New(&cfg)
+			signature := ""
+			if tc.sign {
+				signature = gw.sign(paidBody)
+			}
+			if err := callback(gw, signature); (err == nil) != tc.accepted {
+				t.Fatalf("accepted = %v (err %v), want %v", err == nil, err, tc.accepted)
+			}
+		})
+	}
+}
+
+func TestCallbackWrongSignature(t *testing.T) {
+	gw := New(&config.Config{TripayPrivateKey: "key"})
+	forged := New(&config.Config{TripayPrivateKey: "other"})
+	if err := callback(gw, forged.sign(paidBody)); err == nil {
+		t.Fatal("callback signed with another key accepted")
+	}
+}
+
+// countingTransport fails every request and counts how many were attempted
+type countingTransport struct{ requests int }
+
+func (c *countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	c.requests++
+	return nil, errors.New("network disabled in tests")
+}
+
+func TestTestModeMakesNoRequests(t *testing.T) {
+	transport := &countingTransport{}
+	saved := http.DefaultTransport
+	http.DefaultTransport = transport
+	t.Cleanup(func() { http.DefaultTransport = saved })
+
+	gw := New(&config.Config{TripayAPIKey: "key", TripayPrivateKey: "key", TripayMode: "production", TestMode: true})
+	tx, err := gw.CreateTransaction(payment.TransactionRequest{InvoiceID: "INV-1", Amount: 100000})
+	if err != nil || tx.Status != "pending" || tx.Amount != 100000 {
+		t.Fatalf("CreateTransaction = %+v, %v, want a pending sandbox transaction", tx, err)
+	}
+	if channels, err := gw.GetChannels(); err != nil || len(channels) == 0 {
+		t.Fatalf("GetChannels = %v, %v, want the sandbox channels", channels, err)
+	}
+	if transport.requests != 0 {
+		t.Errorf("%d requests sent to Tripay in test mode", transport.requests)
+	}
+
+	// Without test mode the same calls do reach the network
+	gw = New(&config.Config{TripayAPIKey: "key", TripayPrivateKey: "key", TripayMode: "production"})
+	gw.GetChannels()
+	if transport.requests == 0 {
+		t.Error("no request attempted with test mode off")
+	}
+}
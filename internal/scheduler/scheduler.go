@@ -27,11 +27,36 @@ func (s *Scheduler) Start() {
 		}
 	}()
 
-	// Bandwidth Monitoring (Every 5 minutes)
+	// Bandwidth and optical signal monitoring (Every 5 minutes)
 	monitorTicker := time.NewTicker(5 * time.Minute)
 	go func() {
 		for range monitorTicker.C {
 			s.runBandwidthMonitor()
+			s.handler.RecordOpticalSamples()
+			s.handler.RequeueFailedTasks()
+			s.handler.ProcessRollouts()
+			s.handler.ResumeDueHolds()
+		}
+	}()
+
+	// Retries of failed payment callbacks (CALLBACK_RETRY_MINUTES)
+	if minutes := s.handler.Config.CallbackRetryMinutes; minutes > 0 {
+		callbackTicker := time.NewTicker(time.Duration(minutes) * time.Minute)
+		go func() {
+			for range callbackTicker.C {
+				s.handler.RetryFailedCallbacks()
+			}
+		}()
+	}
+
+	// Scheduled reboots (cron specs have minute resolution), retries of
+	// queued customer notifications and later batches of bulk firmware upgrades
+	minuteTicker := time.NewTicker(time.Minute)
+	go func() {
+		for now := range minuteTicker.C {
+			s.handler.RunRebootSchedules(now.In(s.handler.Config.Loc()))
+			s.handler.FlushNotificationOutbox()
+			s.handler.ProcessFirmwareUpgrades(now)
 		}
 	}()
 
@@ -45,18 +70,24 @@ func (s *Scheduler) Start() {
 }
 
 func (s *Scheduler) runTasks() {
-	now := time.Now()
+	now := s.handler.Config.Now()
 
 	// 1. Auto Invoice Generation (Run on 1st day of month)
 	if now.Day() == 1 {
 		fmt.Println("[SCHEDULER] Running monthly invoice generation...")
-		count, err := s.handler.GenerateInvoicesInternal()
+		report, err := s.handler.GenerateInvoicesInternal()
 		if err != nil {
 			fmt.Printf("[SCHEDULER] Error generating invoices: %v\n", err)
 		} else {
-			fmt.Printf("[SCHEDULER] Generated %d invoices\n", count)
+			fmt.Printf("[SCHEDULER] Generated %d invoices, skipped %d customers\n", report.Generated, len(report.Skipped))
 		}
 	}
+
+	// 2. Due-date reminders and overdue invoices
+	s.handler.ProcessInvoiceReminders()
+
+	// 3. Close tickets left in resolved state
+	s.handler.AutoCloseResolvedTickets()
 }
 
 func (s *Scheduler) runBandwidthMonitor() {
@@ -0,0 +1,87 @@
+package tr069
+
+import (
+	"strings"
+	"testing"
+)
+
+// informWithPPPoE is testInform reporting the given PPPoE username
+func informWithPPPoE(username string) string {
+	return strings.Replace(testInform, "<ParameterList></ParameterList>",
+		`<ParameterList><ParameterValueStruct><Name>InternetGatewayDevice.WANDevice.1.WANConnectionDevice.1.WANPPPConnection.1.Username</Name><Value>`+username+`</Value></ParameterValueStruct></ParameterList>`, 1)
+}
+
+// createPortalCustomer inserts an active customer with a portal username
+func createPortalCustomer(t *testing.T, s *Server, code, username string) int64 {
+	t.Helper()
+	res, err := s.DB.Exec(`INSERT INTO customers (customer_code, name, username, status) VALUES (?, ?, ?, 'active')`,
+		code, "Customer "+code, username)
+	if err != nil {
+		t.Fatalf("insert customer: %v", err)
+	}
+	id, _ := res.LastInsertId()
+	return id
+}
+
+func TestInformAutoAssignsDeviceByPPPoEUsername(t *testing.T) {
+	s := newTestServer(t)
+	s.Config.AutoAssignPPPoE = true
+	customerID := createPortalCustomer(t, s, "C-0001", "budi@isp")
+
+	if rec := post(s, informWithPPPoE("budi@isp")); rec.Code != 200 {
+		t.Fatalf("Inform status = %d", rec.Code)
+	}
+	device, err := s.DB.GetDeviceBySerial("SN100")
+	if err != nil {
+		t.Fatalf("GetDeviceBySerial: %v", err)
+	}
+	if device.CustomerID == nil || *device.CustomerID != customerID {
+		t.Fatalf("customer = %v, want %d", device.CustomerID, customerID)
+	}
+	if device.Template != "budi@isp" {
+		t.Errorf("template = %q, want the PPPoE username", device.Template)
+	}
+	var logged int
+	s.DB.QueryRow(`SELECT COUNT(*) FROM logs WHERE device_id = ? AND message LIKE 'Auto-assigned to customer C-0001%'`, device.ID).Scan(&logged)
+	if logged != 1 {
+		t.Errorf("%d auto-assign log entries, want 1", logged)
+	}
+
+	// A later inform with another customer's username does not move the device
+	createPortalCustomer(t, s, "C-0002", "siti@isp")
+	post(s, informWithPPPoE("siti@isp"))
+	device, _ = s.DB.GetDeviceBySerial("SN100")
+	if device.CustomerID == nil || *device.CustomerID != customerID {
+		t.Fatalf("assigned device moved to customer %v", device.CustomerID)
+	}
+}
+
+func TestInformWithoutMatchingCustomerLeavesDeviceUnassigned(t *testing.T) {
+	s := newTestServer(t)
+	s.Config.AutoAssignPPPoE = true
+	createPortalCustomer(t, s, "C-0001", "budi@isp")
+
+	post(s, informWithPPPoE("unknown@isp"))
+	device, err := s.DB.GetDeviceBySerial("SN100")
+	if err != nil {
+		t.Fatalf("GetDeviceBySerial: %v", err)
+	}
+	if device.CustomerID != nil {
+		t.Fatalf("device assigned to customer %d without a matching username", *device.CustomerID)
+	}
+}
+
+func TestDisabledAutoAssignLeavesDeviceUnassigned(t *testing.T) {
+	s := newTestServer(t)
+	s.Config.AutoAssignPPPoE = false
+	createPortalCustomer(t, s, "C-0001", "budi@isp")
+
+	post(s, informWithPPPoE("budi@isp"))
+	device, err := s.DB.GetDeviceBySerial("SN100")
+	if err != nil {
+		t.Fatalf("GetDeviceBySerial: %v", err)
+	}
+	if device.CustomerID != nil {
+		t.Fatalf("device assigned to customer %d with auto-assign disabled", *device.CustomerID)
+	}
+}
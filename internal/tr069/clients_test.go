@@ -0,0 +1,66 @@
+package tr069
+
+import (
+	"testing"
+	"time"
+
+	"go-acs/internal/models"
+)
+
+func TestRecordClientHistoryUsesReportedHostsOnly(t *testing.T) {
+	s := newTestServer(t)
+	device, err := s.DB.CreateDevice(&models.Device{SerialNumber: "SN001", Manufacturer: "ZTE", ModelName: "ONT"})
+	if err != nil {
+		t.Fatalf("CreateDevice: %v", err)
+	}
+	// A client that left earlier is still in the stored parameters
+	prefix := "InternetGatewayDevice.LANDevice.1.Hosts.Host."
+	s.DB.SetDeviceParameter(device.ID, prefix+"1.MACAddress", "AA:AA:AA:AA:AA:AA", "xsd:string", false)
+	s.DB.SetDeviceParameter(device.ID, prefix+"1.Active", "1", "xsd:boolean", false)
+
+	s.recordClientHistory(device, []ParsedParameterValue{
+		{Name: prefix + "2.MACAddress", Value: "BB:BB:BB:BB:BB:BB"},
+		{Name: prefix + "2.Active", Value: "1"},
+	})
+
+	entries, err := s.DB.GetClientHistory(device.ID, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GetClientHistory: %v", err)
+	}
+	if len(entries) != 1 || entries[0].MAC != "BB:BB:BB:BB:BB:BB" {
+		t.Fatalf("history = %+v, want only the reported client", entries)
+	}
+}
+
+func TestClientLimitAlertUsesWLAN1(t *testing.T) {
+	s := newTestServer(t)
+	s.Config.WiFiClientAlertPercent = 50
+	device, err := s.DB.CreateDevice(&models.Device{SerialNumber: "SN001", Manufacturer: "ZTE", ModelName: "ONT"})
+	if err != nil {
+		t.Fatalf("CreateDevice: %v", err)
+	}
+	wlan := "InternetGatewayDevice.LANDevice.1.WLANConfiguration."
+	s.DB.SetDeviceParameter(device.ID, wlan+"1.MaxAssociatedDevices", "4", "xsd:unsignedInt", true)
+	s.DB.SetDeviceParameter(device.ID, wlan+"5.MaxAssociatedDevices", "32", "xsd:unsignedInt", true)
+	s.DB.SetDeviceParameter(device.ID, wlan+"5.Enable", "0", "xsd:boolean", true)
+
+	hosts := func(n int) []ParsedParameterValue {
+		var reported []ParsedParameterValue
+		for i := 1; i <= n; i++ {
+			prefix := "InternetGatewayDevice.LANDevice.1.Hosts.Host." + string(rune('0'+i)) + "."
+			reported = append(reported,
+				ParsedParameterValue{Name: prefix + "MACAddress", Value: "AA:BB:CC:DD:EE:0" + string(rune('0'+i))},
+				ParsedParameterValue{Name: prefix + "Active", Value: "1"})
+		}
+		return reported
+	}
+	for _, tc := range []struct {
+		clients int
+		want    string
+	}{{1, ""}, {2, models.ClientLimitNear}, {4, models.ClientLimitOver}, {1, ""}} {
+		s.recordClientHistory(device, hosts(tc.clients))
+		if got := s.DB.GetClientAlertLevel(device.ID); got != tc.want {
+			t.Errorf("%d client(s): level %q, want %q", tc.clients, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package tr069
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"go-acs/internal/models"
+)
+
+func TestConnectionRequestURL(t *testing.T) {
+	tests := []struct {
+		raw, scheme string
+		port        int
+		want        string
+		ok          bool
+	}{
+		{"http://10.0.0.2:7547/cr", "", 0, "http://10.0.0.2:7547/cr", true},
+		{" HTTPS://10.0.0.2:30005/abc ", "", 0, "https://10.0.0.2:30005/abc", true},
+		{"10.0.0.2:7547/cr", "", 0, "http://10.0.0.2:7547/cr", true},
+		{"http://10.0.0.2:7547/cr", "https", 0, "https://10.0.0.2:7547/cr", true},
+		{"http://10.0.0.2:7547/cr", "", 58000, "http://10.0.0.2:58000/cr", true},
+		{"http://[2001:db8::2]:7547/cr", "", 8443, "http://[2001:db8::2]:8443/cr", true},
+		{"", "", 0, "", false},
+		{"ftp://10.0.0.2/cr", "", 0, "", false},
+		{"http:///cr", "", 0, "", false},
+	}
+	for _, tt := range tests {
+		s := newTestServer(t)
+		s.Config.ConnReqScheme, s.Config.ConnReqPort = tt.scheme, tt.port
+		got, err := s.connectionRequestURL(tt.raw)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("connectionRequestURL(%q, scheme %q, port %d) = %q, %v; want %q ok %v",
+				tt.raw, tt.scheme, tt.port, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+// connReqEndpoint counts connection requests and answers with status
+func connReqEndpoint(status int) (http.Handler, *atomic.Int32) {
+	var hits atomic.Int32
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.WriteHeader(status)
+	}), &hits
+}
+
+func TestConnectionRequestOverHTTP(t *testing.T) {
+	handler, hits := connReqEndpoint(http.StatusNoContent)
+	cpe := httptest.NewServer(handler)
+	defer cpe.Close()
+	s := newTestServer(t)
+
+	if err := s.SendConnectionRequest(&models.Device{SerialNumber: "SN100", ConnectionRequest: cpe.URL + "/cr"}); err != nil {
+		t.Fatalf("SendConnectionRequest: %v", err)
+	}
+	// Reported without a scheme
+	if err := s.SendConnectionRequest(&models.Device{SerialNumber: "SN100", ConnectionRequest: strings.TrimPrefix(cpe.URL, "http://") + "/cr"}); err != nil {
+		t.Fatalf("SendConnectionRequest without scheme: %v", err)
+	}
+	if hits.Load() != 2 {
+		t.Fatalf("CPE received %d connection requests, want 2", hits.Load())
+	}
+
+	failing, _ := connReqEndpoint(http.StatusUnauthorized)
+	down := httptest.NewServer(failing)
+	defer down.Close()
+	if err := s.SendConnectionRequest(&models.Device{SerialNumber: "SN100", ConnectionRequest: down.URL}); err == nil {
+		t.Fatal("401 from the CPE reported as success")
+	}
+}
+
+func TestConnectionRequestOverHTTPS(t *testing.T) {
+	handler, hits := connReqEndpoint(http.StatusOK)
+	cpe := httptest.NewTLSServer(handler)
+	defer cpe.Close()
+	s := newTestServer(t)
+	device := &models.Device{SerialNumber: "SN100", ConnectionRequest: cpe.URL + "/cr"}
+
+	// CPE certificates are self-signed, so verification is off by default
+	s.Config.ConnReqTLSVerify = false
+	if err := s.SendConnectionRequest(device); err != nil {
+		t.Fatalf("SendConnectionRequest: %v", err)
+	}
+	s.Config.ConnReqTLSVerify = true
+	if err := s.SendConnectionRequest(device); err == nil {
+		t.Fatal("untrusted certificate accepted with verification on")
+	}
+	if hits.Load() != 1 {
+		t.Fatalf("CPE received %d connection requests, want 1", hits.Load())
+	}
+}
+
+func TestConnectionRequestSchemeAndPortOverride(t *testing.T) {
+	handler, hits := connReqEndpoint(http.StatusOK)
+	cpe := httptest.NewTLSServer(handler)
+	defer cpe.Close()
+	u, _ := url.Parse(cpe.URL)
+	_, port, _ := net.SplitHostPort(u.Host)
+
+	s := newTestServer(t)
+	s.Config.ConnReqTLSVerify = false
+	s.Config.ConnReqScheme = "https"
+	s.Config.ConnReqPort, _ = strconv.Atoi(port)
+
+	// The device reports plain http on the default port
+	if err := s.SendConnectionRequest(&models.Device{SerialNumber: "SN100", ConnectionRequest: "http://" + u.Hostname() + ":7547/cr"}); err != nil {
+		t.Fatalf("SendConnectionRequest: %v", err)
+	}
+	if hits.Load() != 1 {
+		t.Fatalf("CPE received %d connection requests, want 1", hits.Load())
+	}
+}
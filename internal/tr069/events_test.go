@@ -0,0 +1,49 @@
+package tr069
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestInformEventCodesAreStored(t *testing.T) {
+	s := newTestServer(t)
+
+	if rec := post(s, testInform); rec.Code != 200 {
+		t.Fatalf("Inform status = %d", rec.Code)
+	}
+	boot := strings.Replace(testInform, "<EventStruct><EventCode>2 PERIODIC</EventCode><CommandKey></CommandKey></EventStruct>",
+		"<EventStruct><EventCode> 1 BOOT </EventCode><CommandKey></CommandKey></EventStruct>"+
+			"<EventStruct><EventCode>M Reboot</EventCode><CommandKey>reboot-42</CommandKey></EventStruct>", 1)
+	if rec := post(s, boot); rec.Code != 200 {
+		t.Fatalf("Inform status = %d", rec.Code)
+	}
+
+	device, err := s.DB.GetDeviceBySerial("SN100")
+	if err != nil {
+		t.Fatalf("GetDeviceBySerial: %v", err)
+	}
+	events, err := s.DB.GetInformEvents(device.ID, 10)
+	if err != nil {
+		t.Fatalf("GetInformEvents: %v", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("%d inform events, want 2", len(events))
+	}
+	// Newest first
+	if want := []string{"1 BOOT", "M Reboot"}; !reflect.DeepEqual(events[0].EventCodes, want) {
+		t.Errorf("event codes = %q, want %q", events[0].EventCodes, want)
+	}
+	if want := []string{"", "reboot-42"}; !reflect.DeepEqual(events[0].CommandKeys, want) {
+		t.Errorf("command keys = %q, want %q", events[0].CommandKeys, want)
+	}
+	if want := []string{"2 PERIODIC"}; !reflect.DeepEqual(events[1].EventCodes, want) {
+		t.Errorf("first inform event codes = %q, want %q", events[1].EventCodes, want)
+	}
+
+	var logged int
+	s.DB.QueryRow(`SELECT COUNT(*) FROM logs WHERE device_id = ? AND message = 'Inform received: 1 BOOT, M Reboot'`, device.ID).Scan(&logged)
+	if logged != 1 {
+		t.Errorf("%d inform log entries with the event codes, want 1", logged)
+	}
+}
@@ -0,0 +1,76 @@
+package tr069
+
+import (
+	"testing"
+	"time"
+
+	"go-acs/internal/models"
+)
+
+type opticalAlert struct {
+	device *models.Device
+	level  string
+}
+
+func newOpticalServer(t *testing.T, enabled bool) (*Server, *models.Device, chan opticalAlert) {
+	t.Helper()
+	s := newTestServer(t)
+	s.Config.OpticalAlertEnabled = enabled
+	alerts := make(chan opticalAlert, 4)
+	s.OnOpticalAlertChange = func(device *models.Device, _ float64, _, level string) {
+		alerts <- opticalAlert{device, level}
+	}
+	device, err := s.DB.CreateDevice(&models.Device{SerialNumber: "SN001", Manufacturer: "ZTE"})
+	if err != nil {
+		t.Fatalf("CreateDevice: %v", err)
+	}
+	return s, device, alerts
+}
+
+func expectNoAlert(t *testing.T, alerts chan opticalAlert) {
+	t.Helper()
+	select {
+	case a := <-alerts:
+		t.Errorf("unexpected alert %q", a.level)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestFirstOpticalReadingDoesNotAlert(t *testing.T) {
+	s, device, alerts := newOpticalServer(t, true)
+	device.RXPower = -30
+	s.checkOpticalSignal(device, 0)
+	expectNoAlert(t, alerts)
+	if level := s.DB.GetOpticalAlertLevel(device.ID); level != models.SignalCritical {
+		t.Errorf("stored level = %q, want %q as the baseline", level, models.SignalCritical)
+	}
+}
+
+func TestOpticalAlertOffByDefault(t *testing.T) {
+	s, device, alerts := newOpticalServer(t, false)
+	if s.Config.OpticalAlertEnabled {
+		t.Fatal("OPTICAL_ALERT_ENABLED defaults to on")
+	}
+	device.RXPower = -30
+	s.checkOpticalSignal(device, -20)
+	expectNoAlert(t, alerts)
+}
+
+func TestOpticalAlertGetsDeviceCopy(t *testing.T) {
+	s, device, alerts := newOpticalServer(t, true)
+	device.RXPower = -30
+	s.checkOpticalSignal(device, -20)
+	device.RXPower = -10 // the inform handler keeps updating its device
+
+	select {
+	case a := <-alerts:
+		if a.level != models.SignalCritical || a.device.RXPower != -30 {
+			t.Errorf("alert = %q at %.1f dBm, want %q at -30", a.level, a.device.RXPower, models.SignalCritical)
+		}
+		if a.device == device {
+			t.Error("callback shares the caller's device")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no alert")
+	}
+}
@@ -70,7 +70,7 @@ func (p *DeviceParameterParser) parseDeviceInfo(paramName, paramValue string) {
 		}
 	case "Device.ManagementServer.ConnectionRequestURL",
 		"InternetGatewayDevice.ManagementServer.ConnectionRequestURL":
-		p.device.ConnectionRequest = paramValue
+		p.device.ConnectionRequest = strings.TrimSpace(paramValue)
 	}
 }
 
@@ -0,0 +1,84 @@
+package tr069
+
+import (
+	"encoding/json"
+	"testing"
+
+	"go-acs/internal/models"
+)
+
+// bootstrapPollPaths runs bootstrapDevice for a new device and returns the
+// paths of the GetParameterValues task it queued
+func bootstrapPollPaths(t *testing.T, s *Server, serial, manufacturer, model string) []string {
+	t.Helper()
+	device, err := s.DB.CreateDevice(&models.Device{SerialNumber: serial, Manufacturer: manufacturer, ModelName: model})
+	if err != nil {
+		t.Fatalf("CreateDevice: %v", err)
+	}
+	s.bootstrapDevice(device)
+	tasks, err := s.DB.GetPendingTasks(device.ID)
+	if err != nil {
+		t.Fatalf("GetPendingTasks: %v", err)
+	}
+	for _, task := range tasks {
+		if task.Type == models.TaskGetParameterValues {
+			var paths []string
+			if err := json.Unmarshal(task.Parameters, &paths); err != nil {
+				t.Fatalf("task parameters: %v", err)
+			}
+			return paths
+		}
+	}
+	t.Fatalf("no GetParameterValues task queued for %s", serial)
+	return nil
+}
+
+func TestBootstrapPollsProfilePathsPerModel(t *testing.T) {
+	s := newTestServer(t)
+	create := func(p *models.PollProfile) {
+		t.Helper()
+		p.Enabled = true
+		if _, err := s.DB.CreatePollProfile(p); err != nil {
+			t.Fatalf("CreatePollProfile: %v", err)
+		}
+	}
+	create(&models.PollProfile{Name: "zte", Manufacturer: "ZTE",
+		Paths: []string{"InternetGatewayDevice.DeviceInfo."}})
+	create(&models.PollProfile{Name: "f670l", Manufacturer: "ZTE", ModelName: "F670L",
+		Paths: []string{"InternetGatewayDevice.DeviceInfo.UpTime", "InternetGatewayDevice.WANDevice.1."}})
+
+	for _, tc := range []struct {
+		serial, model string
+		want          []string
+	}{
+		{"SN-F670L", "F670L", []string{"InternetGatewayDevice.DeviceInfo.UpTime", "InternetGatewayDevice.WANDevice.1."}},
+		{"SN-F609", "F609", []string{"InternetGatewayDevice.DeviceInfo."}},
+	} {
+		got := bootstrapPollPaths(t, s, tc.serial, "ZTE", tc.model)
+		if len(got) != len(tc.want) {
+			t.Fatalf("%s polled %v, want %v", tc.model, got, tc.want)
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Errorf("%s polled %v, want %v", tc.model, got, tc.want)
+				break
+			}
+		}
+	}
+
+	// Other vendors keep the generic parameter set
+	if got := bootstrapPollPaths(t, s, "SN-HW", "Huawei", "HG8245H"); len(got) <= 2 {
+		t.Errorf("Huawei polled %v, want the generic parameter set", got)
+	}
+}
+
+func TestBootstrapIgnoresDisabledPollProfile(t *testing.T) {
+	s := newTestServer(t)
+	if _, err := s.DB.CreatePollProfile(&models.PollProfile{Name: "off", Manufacturer: "ZTE", ModelName: "F670L",
+		Paths: []string{"InternetGatewayDevice.DeviceInfo.UpTime"}}); err != nil {
+		t.Fatalf("CreatePollProfile: %v", err)
+	}
+	if got := bootstrapPollPaths(t, s, "SN-F670L", "ZTE", "F670L"); len(got) <= 1 {
+		t.Errorf("polled %v, want the generic parameter set", got)
+	}
+}
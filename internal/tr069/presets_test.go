@@ -0,0 +1,75 @@
+package tr069
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"go-acs/internal/config"
+	"go-acs/internal/database"
+	"go-acs/internal/models"
+)
+
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	db, err := database.InitDB(filepath.Join(t.TempDir(), "acs.db"), database.Options{BusyTimeoutMs: 5000})
+	if err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return NewServer(0, db, nil, config.Load())
+}
+
+func pendingTasks(t *testing.T, s *Server, deviceID int64) []*models.DeviceTask {
+	t.Helper()
+	tasks, err := s.DB.GetPendingTasks(deviceID)
+	if err != nil {
+		t.Fatalf("GetPendingTasks: %v", err)
+	}
+	return tasks
+}
+
+func TestApplyPresetsSkipsEquivalentValues(t *testing.T) {
+	s := newTestServer(t)
+	device, err := s.DB.CreateDevice(&models.Device{SerialNumber: "SN001", Manufacturer: "ZTE", ModelName: "ONT"})
+	if err != nil {
+		t.Fatalf("CreateDevice: %v", err)
+	}
+	s.DB.SetDeviceParameter(device.ID, "A.Enable", "1", "xsd:boolean", true)
+	s.DB.SetDeviceParameter(device.ID, "A.Rate", "1000000", "xsd:unsignedInt", true)
+	if _, err := s.DB.CreatePreset(&models.Preset{Name: "p", Enabled: true,
+		Provisions: json.RawMessage(`{"A.Enable": true, "A.Rate": 1000000}`)}); err != nil {
+		t.Fatalf("CreatePreset: %v", err)
+	}
+
+	s.applyPresets(device, []string{"2 PERIODIC"})
+	if tasks := pendingTasks(t, s, device.ID); len(tasks) != 0 {
+		t.Fatalf("queued %d task(s) for values already in place", len(tasks))
+	}
+}
+
+func TestApplyPresetsComparesSecretsWithWrittenValue(t *testing.T) {
+	s := newTestServer(t)
+	device, err := s.DB.CreateDevice(&models.Device{SerialNumber: "SN001", Manufacturer: "ZTE", ModelName: "ONT"})
+	if err != nil {
+		t.Fatalf("CreateDevice: %v", err)
+	}
+	path := "InternetGatewayDevice.LANDevice.1.WLANConfiguration.1.KeyPassphrase"
+	s.DB.SetDeviceParameter(device.ID, path, "", "xsd:string", true)
+	if _, err := s.DB.CreatePreset(&models.Preset{Name: "wifi", Enabled: true,
+		Provisions: json.RawMessage(`{"` + path + `": "secret123"}`)}); err != nil {
+		t.Fatalf("CreatePreset: %v", err)
+	}
+
+	s.applyPresets(device, nil)
+	tasks := pendingTasks(t, s, device.ID)
+	if len(tasks) != 1 {
+		t.Fatalf("first apply queued %d task(s), want 1", len(tasks))
+	}
+	s.DB.UpdateTaskStatus(tasks[0].ID, models.TaskCompleted, nil, "")
+
+	s.applyPresets(device, nil)
+	if tasks := pendingTasks(t, s, device.ID); len(tasks) != 0 {
+		t.Fatalf("re-applied a secret that was already written")
+	}
+}
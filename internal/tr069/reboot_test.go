@@ -0,0 +1,58 @@
+package tr069
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDetectReboot(t *testing.T) {
+	tests := []struct {
+		previous, current int64
+		want              bool
+	}{
+		{86400, 120, true},    // counter went backwards
+		{86400, 86700, false}, // normal progression
+		{0, 120, false},       // first inform, nothing to compare
+		{86400, 0, false},     // uptime not reported
+		{300, 300, false},     // repeated inform
+	}
+	for _, tt := range tests {
+		if got := detectReboot(tt.previous, tt.current); got != tt.want {
+			t.Errorf("detectReboot(%d, %d) = %v, want %v", tt.previous, tt.current, got, tt.want)
+		}
+	}
+}
+
+// informWithUptime is testInform reporting the given uptime in seconds
+func informWithUptime(uptime string) string {
+	return strings.Replace(testInform, "<ParameterList></ParameterList>",
+		`<ParameterList><ParameterValueStruct><Name>InternetGatewayDevice.DeviceInfo.UpTime</Name><Value>`+uptime+`</Value></ParameterValueStruct></ParameterList>`, 1)
+}
+
+func TestDecreasingUptimeIsRecordedAsReboot(t *testing.T) {
+	s := newTestServer(t)
+
+	for _, uptime := range []string{"3600", "7200", "60", "400", "30"} {
+		if rec := post(s, informWithUptime(uptime)); rec.Code != 200 {
+			t.Fatalf("Inform status = %d", rec.Code)
+		}
+	}
+
+	device, err := s.DB.GetDeviceBySerial("SN100")
+	if err != nil {
+		t.Fatalf("GetDeviceBySerial: %v", err)
+	}
+	counts, err := s.DB.GetDeviceRebootCounts(device.ID, 1)
+	if err != nil {
+		t.Fatalf("GetDeviceRebootCounts: %v", err)
+	}
+	if len(counts) != 1 || counts[0].Count != 2 {
+		t.Fatalf("reboot counts = %+v, want 2 today", counts)
+	}
+
+	var logged int
+	s.DB.QueryRow(`SELECT COUNT(*) FROM logs WHERE device_id = ? AND category = 'reboot'`, device.ID).Scan(&logged)
+	if logged != 2 {
+		t.Fatalf("%d reboot log entries, want 2", logged)
+	}
+}
@@ -0,0 +1,67 @@
+package tr069
+
+import (
+	"strings"
+	"testing"
+
+	"go-acs/internal/models"
+)
+
+// informWithParameter is testInform reporting one parameter value
+var informWithParameter = strings.Replace(testInform, "<ParameterList></ParameterList>",
+	`<ParameterList><ParameterValueStruct><Name>InternetGatewayDevice.DeviceInfo.SoftwareVersion</Name><Value>V1.0</Value></ParameterValueStruct></ParameterList>`, 1)
+
+func TestAutoRegistrationManagesNewDevice(t *testing.T) {
+	s := newTestServer(t)
+	s.Config.DeviceRegistration = "auto"
+
+	rec := post(s, informWithParameter)
+	if rec.Code != 200 {
+		t.Fatalf("Inform status = %d", rec.Code)
+	}
+	device, err := s.DB.GetDeviceBySerial("SN100")
+	if err != nil {
+		t.Fatalf("GetDeviceBySerial: %v", err)
+	}
+	if device.Status != models.StatusOnline {
+		t.Errorf("status = %s, want online", device.Status)
+	}
+	if len(rec.Result().Cookies()) == 0 {
+		t.Error("no session opened for an auto-registered device")
+	}
+	if params, _ := s.DB.GetDeviceParameters(device.ID, "InternetGatewayDevice.DeviceInfo.SoftwareVersion"); len(params) != 1 || params[0].Value != "V1.0" {
+		t.Error("inform parameter not stored")
+	}
+}
+
+func TestApprovalPolicyHoldsNewDevice(t *testing.T) {
+	s := newTestServer(t)
+	s.Config.DeviceRegistration = "approval"
+
+	for i := 0; i < 2; i++ {
+		rec := post(s, informWithParameter)
+		if rec.Code != 200 {
+			t.Fatalf("Inform status = %d", rec.Code)
+		}
+		if len(rec.Result().Cookies()) != 0 {
+			t.Fatal("a pending device was given a session")
+		}
+	}
+
+	device, err := s.DB.GetDeviceBySerial("SN100")
+	if err != nil {
+		t.Fatalf("GetDeviceBySerial: %v", err)
+	}
+	if device.Status != models.StatusPending {
+		t.Fatalf("status = %s, want pending", device.Status)
+	}
+	if device.LastInform == nil {
+		t.Error("last inform of a pending device not recorded")
+	}
+	if params, _ := s.DB.GetDeviceParameters(device.ID, ""); len(params) != 0 {
+		t.Errorf("stored %d parameters for a pending device", len(params))
+	}
+	if _, err := s.DB.CreateTask(&models.DeviceTask{DeviceID: device.ID, Type: models.TaskReboot}); err == nil {
+		t.Error("task queued for a pending device")
+	}
+}
@@ -2,22 +2,32 @@ package tr069
 
 import (
 	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
 	"encoding/hex"
 	"encoding/json"
 	"encoding/xml"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"go-acs/internal/config"
 	"go-acs/internal/database"
+	"go-acs/internal/middleware"
 	"go-acs/internal/models"
 	"go-acs/internal/websocket"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
 // Server represents the TR-069 ACS server
@@ -25,9 +35,32 @@ type Server struct {
 	Port     int
 	DB       *database.DB
 	WSHub    *websocket.Hub
+	Config   *config.Config
 	sessions sync.Map // Map of session ID to session data
+
+	// OnOpticalAlertChange is called when a device's optical alert level
+	// changes: it dropped into a worse band (warning or critical, see
+	// Config.RXThresholds) or recovered. Set by the HTTP layer to log and
+	// send notifications.
+	OnOpticalAlertChange func(device *models.Device, previousRX float64, previousLevel, level string)
+
+	// OnClientLimitChange is called when a device's WiFi client alert level
+	// changes: its connected clients approached or reached the configured
+	// MaxAssociatedDevices (see Config.WiFiClientAlertPercent) or dropped back.
+	OnClientLimitChange func(device *models.Device, clients, limit int, previousLevel, level string)
+
+	// OnWatchedParameterChange is called when a device reports a new value for
+	// a watched parameter; set by the HTTP layer to log and send notifications
+	OnWatchedParameterChange func(device *models.Device, watch *models.ParameterWatch, change *models.ParameterChange)
 }
 
+// sessionCookie names the cookie carrying the CWMP session ID; sessions
+// older than sessionTimeout are discarded
+const (
+	sessionCookie  = "session"
+	sessionTimeout = 30 * time.Minute
+)
+
 // Session represents a TR-069 session
 type Session struct {
 	ID           string
@@ -40,11 +73,12 @@ type Session struct {
 }
 
 // NewServer creates a new TR-069 server
-func NewServer(port int, db *database.DB, wsHub *websocket.Hub) *Server {
+func NewServer(port int, db *database.DB, wsHub *websocket.Hub, cfg *config.Config) *Server {
 	return &Server{
-		Port:  port,
-		DB:    db,
-		WSHub: wsHub,
+		Port:   port,
+		DB:     db,
+		WSHub:  wsHub,
+		Config: cfg,
 	}
 }
 
@@ -128,8 +162,24 @@ func (s *Server) handleRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Verify CPE credentials before accepting an Inform; everything after it
+	// must carry the session cookie issued in the InformResponse
+	method := soapMethod(envelope)
+	if method == "Inform" {
+		if !s.authorizeInform(envelope, r) {
+			log.Printf("→ Inform rejected from %s: invalid credentials", r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", `Basic realm="go-acs"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	} else if s.sessionFor(r) == nil {
+		log.Printf("→ %s rejected from %s: no active session", method, r.RemoteAddr)
+		http.Error(w, "No active session", http.StatusForbidden)
+		return
+	}
+
 	// Handle the request based on the method
-	response := s.handleSOAPRequest(envelope, r)
+	response := s.handleSOAPRequest(w, envelope, r)
 
 	// Send response
 	if response != nil {
@@ -151,33 +201,137 @@ func (s *Server) handleRequest(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (s *Server) handleEmptyRequest(w http.ResponseWriter, r *http.Request) {
-	// CPE is asking for pending commands
-	clientIP := strings.Split(r.RemoteAddr, ":")[0]
-
-	// Find the device for this session
-	var deviceID int64
-	if sessionData, ok := s.sessions.Load(clientIP); ok {
-		session := sessionData.(*Session)
-		deviceID = session.DeviceID
-	} else {
-		// Try to find device by IP directly if session lost
-		devices, _, _ := s.DB.GetDevices("online", "", 500, 0)
-		for _, d := range devices {
-			if d.IPAddress == clientIP {
-				deviceID = d.ID
-				break
-			}
+// authorizeInform checks the HTTP Basic credentials of an Inform. Per-device
+// credentials take precedence over the global TR069_USERNAME/TR069_PASSWORD;
+// when neither is configured every Inform is accepted.
+func (s *Server) authorizeInform(envelope *SOAPEnvelope, r *http.Request) bool {
+	user, pass, _ := r.BasicAuth()
+
+	if inform, err := parseInform(envelope.Body.InnerXML); err == nil {
+		sn := decodeSerialNumber(inform.DeviceId.SerialNumber)
+		devUser, devHash, err := s.DB.GetDeviceInformCredentials(sn)
+		if err == nil && devUser != "" {
+			return user == devUser && bcrypt.CompareHashAndPassword([]byte(devHash), []byte(pass)) == nil
+		}
+	}
+
+	if s.Config == nil || s.Config.TR069Username == "" {
+		return true
+	}
+	userOK := subtle.ConstantTimeCompare([]byte(user), []byte(s.Config.TR069Username)) == 1
+	passOK := subtle.ConstantTimeCompare([]byte(pass), []byte(s.Config.TR069Password)) == 1
+	return userOK && passOK
+}
+
+// soapMethod returns the name of the first element in the SOAP body, i.e. the
+// CWMP method or response carried by the envelope
+func soapMethod(envelope *SOAPEnvelope) string {
+	decoder := xml.NewDecoder(bytes.NewReader(envelope.Body.InnerXML))
+	for {
+		tok, err := decoder.Token()
+		if err != nil {
+			return ""
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			return start.Name.Local
+		}
+	}
+}
+
+// startSession opens a CWMP session for a device after an accepted Inform
+// and hands its ID to the CPE as a cookie
+func (s *Server) startSession(w http.ResponseWriter, device *models.Device) {
+	s.pruneSessions()
+
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		log.Printf("Failed to create session for %s: %v", device.SerialNumber, err)
+		return
+	}
+	now := time.Now()
+	session := &Session{
+		ID:           hex.EncodeToString(buf),
+		DeviceID:     device.ID,
+		SerialNumber: device.SerialNumber,
+		StartTime:    now,
+		LastActivity: now,
+	}
+	s.sessions.Store(session.ID, session)
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: session.ID, Path: "/", HttpOnly: true})
+}
+
+// sessionFor returns the session named by the request's cookie, or nil when
+// the cookie is missing, unknown or expired
+func (s *Server) sessionFor(r *http.Request) *Session {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil
+	}
+	data, ok := s.sessions.Load(cookie.Value)
+	if !ok {
+		return nil
+	}
+	session := data.(*Session)
+	if time.Since(session.StartTime) > sessionTimeout {
+		s.sessions.Delete(cookie.Value)
+		return nil
+	}
+	return session
+}
+
+// pruneSessions drops sessions that outlived sessionTimeout
+func (s *Server) pruneSessions() {
+	s.sessions.Range(func(key, value any) bool {
+		if time.Since(value.(*Session).StartTime) > sessionTimeout {
+			s.sessions.Delete(key)
 		}
+		return true
+	})
+}
+
+// sessionTaskID returns the task a response refers to (from the "task-<id>"
+// envelope ID), provided it belongs to the device of the request's session
+func (s *Server) sessionTaskID(envelope *SOAPEnvelope, r *http.Request) (int64, bool) {
+	if envelope.Header == nil || !strings.HasPrefix(envelope.Header.ID, "task-") {
+		return 0, false
 	}
+	taskID, err := strconv.ParseInt(strings.TrimPrefix(envelope.Header.ID, "task-"), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	session := s.sessionFor(r)
+	if session == nil {
+		return 0, false
+	}
+	task, err := s.DB.GetTask(taskID)
+	if err != nil || task.DeviceID != session.DeviceID {
+		log.Printf("Ignoring response for task %d: not a task of device %s", taskID, session.SerialNumber)
+		return 0, false
+	}
+	return taskID, true
+}
 
-	if deviceID == 0 {
+// clientIP returns the CPE's address, looking through trusted reverse
+// proxies (Config.TrustedProxies) so sessions aren't keyed on the proxy
+func (s *Server) clientIP(r *http.Request) string {
+	trusted := ""
+	if s.Config != nil {
+		trusted = s.Config.TrustedProxies
+	}
+	return middleware.ClientIP(r, trusted)
+}
+
+func (s *Server) handleEmptyRequest(w http.ResponseWriter, r *http.Request) {
+	// CPE is asking for pending commands; only a session opened by an
+	// authenticated Inform gets any
+	session := s.sessionFor(r)
+	if session == nil {
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
 
 	// Fetch pending tasks
-	tasks, err := s.DB.GetPendingTasks(deviceID)
+	tasks, err := s.DB.GetPendingTasks(session.DeviceID)
 	if err != nil || len(tasks) == 0 {
 		w.WriteHeader(http.StatusNoContent)
 		return
@@ -220,6 +374,10 @@ func (s *Server) sendTask(w http.ResponseWriter, task *models.DeviceTask) {
 			download.FileType = "1 Firmware Upgrade Image"
 		}
 		response = CreateDownload(id, download.FileType, download.URL, download.FileSize, download.Username, download.Password)
+	case models.TaskAddObject:
+		var req models.AddObjectRequest
+		json.Unmarshal(task.Parameters, &req)
+		response = CreateAddObject(id, req.ObjectName, id)
 	case models.TaskRefresh:
 		// Build comprehensive parameter list using vendor-aware resolver
 		device, _ := s.DB.GetDevice(task.DeviceID)
@@ -265,30 +423,33 @@ func (s *Server) sendTask(w http.ResponseWriter, task *models.DeviceTask) {
 	w.Write(response)
 }
 
-func (s *Server) handleSOAPRequest(envelope *SOAPEnvelope, r *http.Request) *SOAPEnvelope {
+func (s *Server) handleSOAPRequest(w http.ResponseWriter, envelope *SOAPEnvelope, r *http.Request) *SOAPEnvelope {
 	// Determine the CWMP method being called
 	body := envelope.Body.InnerXML
 
-	switch {
-	case strings.Contains(string(body), "Inform"):
-		return s.handleInform(envelope, r)
-	case strings.Contains(string(body), "GetRPCMethodsResponse"):
+	switch soapMethod(envelope) {
+	case "Inform":
+		return s.handleInform(w, envelope, r)
+	case "GetRPCMethodsResponse":
 		return s.handleGetRPCMethodsResponse(envelope)
-	case strings.Contains(string(body), "TransferComplete"):
+	case "TransferComplete":
 		return s.handleTransferComplete(envelope)
-	case strings.Contains(string(body), "GetParameterValuesResponse"):
+	case "GetParameterValuesResponse":
 		s.handleGetParameterValuesResponse(envelope, r)
 		return nil // We'll send next task in handleRequest/empty post
-	case strings.Contains(string(body), "SetParameterValuesResponse"):
+	case "SetParameterValuesResponse":
 		s.handleSetParameterValuesResponse(envelope, r)
 		return nil
-	case strings.Contains(string(body), "RebootResponse"):
+	case "RebootResponse":
 		s.handleRebootResponse(envelope, r)
 		return nil
-	case strings.Contains(string(body), "FactoryResetResponse"):
+	case "FactoryResetResponse":
 		s.handleFactoryResetResponse(envelope, r)
 		return nil
-	case strings.Contains(string(body), "Fault"):
+	case "AddObjectResponse":
+		s.handleAddObjectResponse(envelope, r)
+		return nil
+	case "Fault":
 		s.handleFault(envelope, r)
 		return nil
 	default:
@@ -297,26 +458,150 @@ func (s *Server) handleSOAPRequest(envelope *SOAPEnvelope, r *http.Request) *SOA
 	}
 }
 
-func (s *Server) handleFault(envelope *SOAPEnvelope, _ *http.Request) {
+func (s *Server) handleFault(envelope *SOAPEnvelope, r *http.Request) {
 	log.Printf("Fault received from device: %s", string(envelope.Body.InnerXML))
 	// Try to identify task from Envelope ID
-	if envelope.Header != nil && strings.HasPrefix(envelope.Header.ID, "task-") {
-		taskIDStr := strings.TrimPrefix(envelope.Header.ID, "task-")
-		if taskID, err := strconv.ParseInt(taskIDStr, 10, 64); err == nil {
-			now := time.Now()
-			task := &models.DeviceTask{
-				ID:          taskID,
-				Status:      models.TaskFailed,
-				CompletedAt: &now,
-				Error:       "CWMP Fault: " + string(envelope.Body.InnerXML),
-			}
-			s.DB.UpdateTask(task)
+	if taskID, ok := s.sessionTaskID(envelope, r); ok {
+		// Only the status changes, so the task keeps its parameters and can be retried
+		s.DB.UpdateTaskStatus(taskID, models.TaskFailed, nil, "CWMP Fault: "+string(envelope.Body.InnerXML))
+		if verified, err := s.DB.GetTaskVerifiedBy(taskID); err == nil {
+			s.DB.FinishTaskVerification(verified.ID, models.VerificationUnverified, nil)
+		}
+	}
+}
+
+// autoAssignCustomer links an unassigned device to the customer whose
+// username matches the PPPoE username reported on inform, when enabled
+func (s *Server) autoAssignCustomer(device *models.Device) {
+	if s.Config == nil || !s.Config.AutoAssignPPPoE || device.CustomerID != nil || device.PPPoEUsername == "" {
+		return
+	}
+
+	customer, err := s.DB.GetCustomerByUsername(device.PPPoEUsername)
+	if err != nil {
+		return
+	}
+	if err := s.DB.LinkDeviceToCustomer(device.ID, customer.ID); err != nil {
+		log.Printf("Failed to auto-assign device %s to customer %s: %v", device.SerialNumber, customer.CustomerCode, err)
+		return
+	}
+
+	device.CustomerID = &customer.ID
+	device.Template = device.PPPoEUsername
+	if label, err := s.DB.ApplyDeviceLabel(device.ID, s.Config.DeviceLabelTemplate); err != nil {
+		log.Printf("Failed to label device %s: %v", device.SerialNumber, err)
+	} else if label != "" {
+		device.Label = label
+	}
+	log.Printf("Device %s auto-assigned to customer %s by PPPoE username", device.SerialNumber, customer.CustomerCode)
+	s.DB.CreateLog(&device.ID, "info", "device",
+		fmt.Sprintf("Auto-assigned to customer %s (PPPoE %s)", customer.CustomerCode, device.PPPoEUsername), "")
+}
+
+// detectReboot reports whether an uptime reading indicates the device has
+// restarted since the previous inform, i.e. the uptime counter went backwards
+func detectReboot(previousUptime, currentUptime int64) bool {
+	return previousUptime > 0 && currentUptime > 0 && currentUptime < previousUptime
+}
+
+// checkOpticalSignal updates the device's optical alert level from its RX
+// reading and, when Config.OpticalAlertEnabled, fires OnOpticalAlertChange
+// if the level changed
+func (s *Server) checkOpticalSignal(device *models.Device, previousRX float64) {
+	if s.Config == nil {
+		return
+	}
+	previous := s.DB.GetOpticalAlertLevel(device.ID)
+	level := models.OpticalAlertLevel(device.RXPower, previous, s.Config.RXThresholds(), s.Config.RXAlertHysteresisDB)
+	if level == previous {
+		return
+	}
+	if err := s.DB.SetOpticalAlertLevel(device.ID, level); err != nil {
+		log.Printf("Failed to store optical alert level for %s: %v", device.SerialNumber, err)
+		return
+	}
+
+	log.Printf("Optical alert level on %s: %q -> %q (RX %.2f dBm, was %.2f dBm)", device.SerialNumber, previous, level, device.RXPower, previousRX)
+	// The first reading only sets the baseline, so devices that were already
+	// low before they were tracked don't all alert at once
+	if previousRX == 0 || !s.Config.OpticalAlertEnabled || s.OnOpticalAlertChange == nil {
+		return
+	}
+	// The callback runs after the caller goes on updating device
+	snapshot := *device
+	go s.OnOpticalAlertChange(&snapshot, previousRX, previous, level)
+}
+
+// recordClientHistory updates the device's client history when the response
+// carried its LAN host table. Only the reported hosts count: stored Host.N
+// rows of clients that have since left are never deleted.
+func (s *Server) recordClientHistory(device *models.Device, reported []ParsedParameterValue) {
+	hosts := make([]*models.DeviceParameter, 0, len(reported))
+	for _, p := range reported {
+		if strings.Contains(p.Name, "Hosts.Host.") {
+			hosts = append(hosts, &models.DeviceParameter{Path: p.Name, Value: p.Value, Type: p.Type})
+		}
+	}
+	if len(hosts) == 0 {
+		return
+	}
+	params, err := s.DB.GetDeviceParameters(device.ID, "")
+	if err != nil {
+		return
+	}
+	clients := models.ParseConnectedClients(hosts)
+	if err := s.DB.RecordClientHistory(device.ID, clients, time.Now()); err != nil {
+		log.Printf("Failed to record client history for %s: %v", device.SerialNumber, err)
+	}
+	s.checkClientLimit(device, models.CountWiFiClients(clients), models.WiFiClientLimit(params))
+}
+
+// checkClientLimit updates the device's WiFi client alert level and fires
+// OnClientLimitChange when it changed
+func (s *Server) checkClientLimit(device *models.Device, clients, limit int) {
+	if s.Config == nil {
+		return
+	}
+	previous := s.DB.GetClientAlertLevel(device.ID)
+	level := models.ClientLimitLevel(clients, limit, s.Config.WiFiClientAlertPercent)
+	if level == previous {
+		return
+	}
+	if err := s.DB.SetClientAlertLevel(device.ID, level); err != nil {
+		log.Printf("Failed to store client alert level for %s: %v", device.SerialNumber, err)
+		return
+	}
+
+	log.Printf("WiFi client alert level on %s: %q -> %q (%d of max %d clients)", device.SerialNumber, previous, level, clients, limit)
+	if s.OnClientLimitChange != nil {
+		go s.OnClientLimitChange(device, clients, limit, previous, level)
+	}
+}
+
+// notifyWatchedChanges fires OnWatchedParameterChange for the changes of
+// parameters the device has a watch on
+func (s *Server) notifyWatchedChanges(device *models.Device, changes []*models.ParameterChange) {
+	if s.OnWatchedParameterChange == nil || len(changes) == 0 {
+		return
+	}
+	watches, err := s.DB.GetDeviceParameterWatches(device.ID)
+	if err != nil || len(watches) == 0 {
+		return
+	}
+	byPath := make(map[string]*models.ParameterWatch, len(watches))
+	for _, w := range watches {
+		byPath[w.Path] = w
+	}
+	for _, c := range changes {
+		if w, ok := byPath[c.Path]; ok {
+			log.Printf("Watched parameter %s on %s changed: %q -> %q", c.Path, device.SerialNumber, c.OldValue, c.NewValue)
+			go s.OnWatchedParameterChange(device, w, c)
 		}
 	}
 }
 
 // handleInform handles the Inform RPC from CPE
-func (s *Server) handleInform(envelope *SOAPEnvelope, r *http.Request) *SOAPEnvelope {
+func (s *Server) handleInform(w http.ResponseWriter, envelope *SOAPEnvelope, r *http.Request) *SOAPEnvelope {
 	// Parse the Inform message
 	inform, err := parseInform(envelope.Body.InnerXML)
 	if err != nil {
@@ -337,9 +622,13 @@ func (s *Server) handleInform(envelope *SOAPEnvelope, r *http.Request) *SOAPEnve
 	if err != nil {
 		if err.Error() == "sql: no rows in result set" {
 			// New device - initialize the object
+			status := models.StatusOnline
+			if s.Config != nil && s.Config.DeviceRegistration == "approval" {
+				status = models.StatusPending
+			}
 			device = &models.Device{
 				SerialNumber: sn,
-				Status:       models.StatusOnline,
+				Status:       status,
 				Manufacturer: inform.DeviceId.Manufacturer,
 				OUI:          inform.DeviceId.OUI,
 				ProductClass: inform.DeviceId.ProductClass,
@@ -349,9 +638,13 @@ func (s *Server) handleInform(envelope *SOAPEnvelope, r *http.Request) *SOAPEnve
 			if err != nil {
 				log.Printf("Error creating device %s: %v", inform.DeviceId.SerialNumber, err)
 			} else {
-				log.Printf("New device registered: %s", device.SerialNumber)
+				log.Printf("New device registered: %s (status: %s)", device.SerialNumber, device.Status)
 				s.DB.CreateLog(&device.ID, "info", "device",
 					fmt.Sprintf("New device registered: %s", device.SerialNumber), "")
+				if device.Status == models.StatusPending {
+					s.DB.CreateLog(&device.ID, "warning", "device",
+						fmt.Sprintf("Device %s is awaiting approval", device.SerialNumber), "")
+				}
 			}
 		} else {
 			// Database error (missing columns, etc)
@@ -360,14 +653,29 @@ func (s *Server) handleInform(envelope *SOAPEnvelope, r *http.Request) *SOAPEnve
 		}
 	}
 
+	// Devices awaiting approval are recorded but not managed
+	if device != nil && device.Status == models.StatusPending {
+		s.recordPendingInform(device, inform, r)
+		return createInformResponse(envelope.Header)
+	}
+
 	if device != nil {
 		// Update existing device
 		now := time.Now()
+		// Record the transition in the status history (and push it to the
+		// live dashboard) before UpdateDevice overwrites the status
+		if device.Status != models.StatusOnline {
+			if err := s.DB.UpdateDeviceStatus(device.ID, models.StatusOnline); err != nil {
+				log.Printf("Error updating status of device %s: %v", device.SerialNumber, err)
+			}
+		}
 		device.Status = models.StatusOnline
 		device.LastInform = &now
 		device.LastContact = &now
-		device.IPAddress = strings.Split(r.RemoteAddr, ":")[0]
+		device.IPAddress = s.clientIP(r)
 		device.ClientCount = 0 // Reset for summation
+		previousUptime := device.Uptime
+		previousRX := device.RXPower
 
 		// Update device info from Inform using new parameter parser
 		parser := NewDeviceParameterParser(device, device.Manufacturer, device.ModelName)
@@ -399,24 +707,32 @@ func (s *Server) handleInform(envelope *SOAPEnvelope, r *http.Request) *SOAPEnve
 			}
 		}
 
+		s.autoAssignCustomer(device)
+
+		if detectReboot(previousUptime, device.Uptime) {
+			log.Printf("Reboot detected for %s (uptime %ds -> %ds)", device.SerialNumber, previousUptime, device.Uptime)
+			s.DB.RecordDeviceReboot(device.ID)
+			s.DB.CreateLog(&device.ID, "warning", "reboot",
+				fmt.Sprintf("Device rebooted (uptime reset from %ds to %ds)", previousUptime, device.Uptime), "")
+		}
+
 		s.DB.UpdateDevice(device)
 		log.Printf("Device updated: %s (Status: online, RX: %.2f dBm, TX: %.2f dBm)", device.SerialNumber, device.RXPower, device.TXPower)
+		s.checkOpticalSignal(device, previousRX)
 
-		// Store session for this IP address so we can identify device in subsequent responses
-		clientIP := strings.Split(r.RemoteAddr, ":")[0]
-		s.sessions.Store(clientIP, &Session{
-			DeviceID:     device.ID,
-			SerialNumber: device.SerialNumber,
-			StartTime:    time.Now(),
-			LastActivity: time.Now(),
-		})
+		// Open a session so we can identify the device in subsequent responses
+		s.startSession(w, device)
 	}
 
 	// Store parameters from Inform
 	if device != nil {
+		var changes []*models.ParameterChange
 		for _, param := range inform.ParameterList.ParameterValueStruct {
-			s.DB.SetDeviceParameter(device.ID, param.Name, param.Value, "string", true)
+			if change, _ := s.DB.SetDeviceParameter(device.ID, param.Name, param.Value, "string", true); change != nil {
+				changes = append(changes, change)
+			}
 		}
+		s.notifyWatchedChanges(device, changes)
 	}
 
 	// Notify via WebSocket
@@ -425,7 +741,7 @@ func (s *Server) handleInform(envelope *SOAPEnvelope, r *http.Request) *SOAPEnve
 			Type:     "device_update",
 			DeviceID: device.ID,
 			Data: map[string]interface{}{
-				"status":      "online",
+				"status":      device.Status,
 				"lastContact": time.Now(),
 				"event":       "inform",
 			},
@@ -434,14 +750,19 @@ func (s *Server) handleInform(envelope *SOAPEnvelope, r *http.Request) *SOAPEnve
 
 	// Log the Inform event
 	if device != nil {
-		eventCodes := ""
+		var eventCodes, commandKeys []string
 		for _, event := range inform.Event.EventStruct {
-			eventCodes += event.EventCode + " "
+			eventCodes = append(eventCodes, strings.TrimSpace(event.EventCode))
+			commandKeys = append(commandKeys, event.CommandKey)
+		}
+		if err := s.DB.RecordInformEvents(device.ID, eventCodes, commandKeys); err != nil {
+			log.Printf("Failed to record inform events for %s: %v", device.SerialNumber, err)
 		}
 		s.DB.CreateLog(&device.ID, "info", "inform",
-			fmt.Sprintf("Inform received: %s", strings.TrimSpace(eventCodes)), "")
+			fmt.Sprintf("Inform received: %s", strings.Join(eventCodes, ", ")), "")
 
 		// Run provisioning/bootstrap logic (Logic from Provision script)
+		s.applyPresets(device, eventCodes)
 		s.bootstrapDevice(device)
 	}
 
@@ -449,6 +770,31 @@ func (s *Server) handleInform(envelope *SOAPEnvelope, r *http.Request) *SOAPEnve
 	return createInformResponse(envelope.Header)
 }
 
+// recordPendingInform handles an Inform from a device awaiting approval. Only
+// the contact time, address and the inform events are kept: its parameters are
+// neither stored nor broadcast, no session is opened and nothing is provisioned
+// until an operator approves it.
+func (s *Server) recordPendingInform(device *models.Device, inform *Inform, r *http.Request) {
+	now := time.Now()
+	device.LastInform = &now
+	device.LastContact = &now
+	device.IPAddress = s.clientIP(r)
+	if err := s.DB.UpdateDevice(device); err != nil {
+		log.Printf("Error updating pending device %s: %v", device.SerialNumber, err)
+	}
+
+	var eventCodes, commandKeys []string
+	for _, event := range inform.Event.EventStruct {
+		eventCodes = append(eventCodes, strings.TrimSpace(event.EventCode))
+		commandKeys = append(commandKeys, event.CommandKey)
+	}
+	if err := s.DB.RecordInformEvents(device.ID, eventCodes, commandKeys); err != nil {
+		log.Printf("Failed to record inform events for %s: %v", device.SerialNumber, err)
+	}
+	s.DB.CreateLog(&device.ID, "info", "inform",
+		fmt.Sprintf("Inform received from pending device: %s", strings.Join(eventCodes, ", ")), "")
+}
+
 func (s *Server) handleGetRPCMethodsResponse(_ *SOAPEnvelope) *SOAPEnvelope {
 	log.Println("GetRPCMethodsResponse received")
 	return nil
@@ -471,39 +817,10 @@ func (s *Server) handleGetParameterValuesResponse(envelope *SOAPEnvelope, r *htt
 
 	log.Printf("Parsed %d parameters from GetParameterValuesResponse", len(parsed.ParameterList))
 
-	// Try to find device by IP address from request
-	clientIP := strings.Split(r.RemoteAddr, ":")[0]
-
-	// First, look for the device by IP in database
+	// The device is the one that opened the session
 	var device *models.Device
-	devices, _, _ := s.DB.GetDevices("online", "", 500, 0)
-	for _, d := range devices {
-		if d.IPAddress == clientIP {
-			device = d
-			break
-		}
-	}
-
-	// Also check in parameters for SerialNumber
-	if device == nil {
-		var serialNumber string
-		for _, p := range parsed.ParameterList {
-			if strings.HasSuffix(p.Name, ".SerialNumber") || strings.HasSuffix(p.Name, ".X_HW_SerialNumber") {
-				serialNumber = p.Value
-				break
-			}
-		}
-		if serialNumber != "" {
-			device, _ = s.DB.GetDeviceBySerial(serialNumber)
-		}
-	}
-
-	// Also try to find from session map
-	if device == nil {
-		if sessionData, ok := s.sessions.Load(clientIP); ok {
-			session := sessionData.(*Session)
-			device, _ = s.DB.GetDevice(session.DeviceID)
-		}
+	if session := s.sessionFor(r); session != nil {
+		device, _ = s.DB.GetDevice(session.DeviceID)
 	}
 
 	if device != nil {
@@ -517,18 +834,24 @@ func (s *Server) handleGetParameterValuesResponse(envelope *SOAPEnvelope, r *htt
 
 		// Store each parameter
 		storedCount := 0
+		var changes []*models.ParameterChange
 		for _, p := range parsed.ParameterList {
-			err := s.DB.SetDeviceParameter(device.ID, p.Name, p.Value, p.Type, true)
+			change, err := s.DB.SetDeviceParameter(device.ID, p.Name, p.Value, p.Type, true)
 			if err != nil {
 				log.Printf("Error storing parameter %s: %v", p.Name, err)
 			} else {
 				storedCount++
 			}
+			if change != nil {
+				changes = append(changes, change)
+			}
 		}
+		s.notifyWatchedChanges(device, changes)
 
 		// Update device with parsed data
 		parsedDevice := parser.GetDeviceData()
 		if parsedDevice != nil && device != nil {
+			previousRX := device.RXPower
 			// Update device fields that were parsed
 			if parsedDevice.RXPower != 0 {
 				device.RXPower = parsedDevice.RXPower
@@ -555,79 +878,245 @@ func (s *Server) handleGetParameterValuesResponse(envelope *SOAPEnvelope, r *htt
 			} else {
 				log.Printf("Updated device %s with parsed optical parameters: RX=%.2f dBm, TX=%.2f dBm, Temp=%.2f°C",
 					device.SerialNumber, device.RXPower, device.TXPower, device.OpticalTemperature)
+				s.checkOpticalSignal(device, previousRX)
 			}
 		}
 
-		log.Printf("Stored %d parameters for device %s (IP: %s)", storedCount, device.SerialNumber, clientIP)
+		log.Printf("Stored %d parameters for device %s", storedCount, device.SerialNumber)
+		s.recordClientHistory(device, parsed.ParameterList)
 
 		// Mark task as completed
-		if envelope.Header != nil && strings.HasPrefix(envelope.Header.ID, "task-") {
-			taskIDStr := strings.TrimPrefix(envelope.Header.ID, "task-")
-			if taskID, err := strconv.ParseInt(taskIDStr, 10, 64); err == nil {
-				now := time.Now()
-				resJSON, _ := json.Marshal(map[string]interface{}{"count": storedCount})
-				task := &models.DeviceTask{
-					ID:          taskID,
-					Status:      models.TaskCompleted,
-					CompletedAt: &now,
-					Result:      resJSON,
-				}
-				s.DB.UpdateTask(task)
+		if taskID, ok := s.sessionTaskID(envelope, r); ok {
+			now := time.Now()
+			resJSON, _ := json.Marshal(map[string]interface{}{"count": storedCount})
+			task := &models.DeviceTask{
+				ID:          taskID,
+				Status:      models.TaskCompleted,
+				CompletedAt: &now,
+				Result:      resJSON,
 			}
+			s.DB.UpdateTask(task)
+			s.verifyReadBack(device, taskID, parsed.ParameterList)
 		}
 	} else if len(parsed.ParameterList) > 0 {
-		log.Printf("No device identified for IP %s, skipping parameter storage for %d params", clientIP, len(parsed.ParameterList))
+		log.Printf("No device identified for session, skipping parameter storage for %d params", len(parsed.ParameterList))
 	}
 
 	return nil
 }
 
-func (s *Server) handleSetParameterValuesResponse(envelope *SOAPEnvelope, _ *http.Request) {
+func (s *Server) handleSetParameterValuesResponse(envelope *SOAPEnvelope, r *http.Request) {
 	log.Println("SetParameterValuesResponse received")
-	if envelope.Header != nil && strings.HasPrefix(envelope.Header.ID, "task-") {
-		taskIDStr := strings.TrimPrefix(envelope.Header.ID, "task-")
-		if taskID, err := strconv.ParseInt(taskIDStr, 10, 64); err == nil {
-			now := time.Now()
-			task := &models.DeviceTask{
-				ID:          taskID,
-				Status:      models.TaskCompleted,
-				CompletedAt: &now,
-			}
-			s.DB.UpdateTask(task)
-		}
+	if taskID, ok := s.sessionTaskID(envelope, r); ok {
+		// Keep the written parameters: the read-back and revert need them
+		s.DB.UpdateTaskStatus(taskID, models.TaskCompleted, nil, "")
+		s.queueReadBack(taskID)
+	}
+}
+
+// queueReadBack queues a GetParameterValues of the paths a completed
+// SetParameterValues task wrote, so verifyReadBack can check that the device
+// actually applied them. It runs ahead of other pending tasks.
+func (s *Server) queueReadBack(taskID int64) {
+	task, err := s.DB.GetTask(taskID)
+	if err != nil || task.Type != models.TaskSetParameterValues {
+		return
+	}
+	writes := models.VerifiableWrites(task.Parameters)
+	if len(writes) == 0 {
+		return
+	}
+	paths := make([]string, 0, len(writes))
+	for path := range writes {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	payload, _ := json.Marshal(paths)
+
+	readBack, err := s.DB.CreateTask(&models.DeviceTask{
+		DeviceID:   task.DeviceID,
+		Type:       models.TaskGetParameterValues,
+		Parameters: payload,
+		Priority:   models.TaskPriorityHigh,
+	})
+	if err != nil {
+		log.Printf("Failed to queue read-back of task %d: %v", taskID, err)
+		return
+	}
+	if err := s.DB.StartTaskVerification(taskID, readBack.ID); err != nil {
+		log.Printf("Failed to start verification of task %d: %v", taskID, err)
+	}
+}
+
+// verifyReadBack compares a read-back with the values written by the task it
+// verifies and records whether the device applied them. Mismatches are logged
+// and pushed to the dashboard.
+func (s *Server) verifyReadBack(device *models.Device, readBackID int64, reported []ParsedParameterValue) {
+	task, err := s.DB.GetTaskVerifiedBy(readBackID)
+	if err != nil {
+		return
+	}
+	values := make(map[string]string, len(reported))
+	for _, p := range reported {
+		values[p.Name] = p.Value
+	}
+	writes := models.VerifiableWrites(task.Parameters)
+	mismatches := models.CompareParameterValues(writes, values)
+
+	verification := models.VerificationApplied
+	if len(mismatches) > 0 {
+		verification = models.VerificationNotApplied
+	}
+	if err := s.DB.FinishTaskVerification(task.ID, verification, mismatches); err != nil {
+		log.Printf("Failed to store verification of task %d: %v", task.ID, err)
+		return
+	}
+	if len(mismatches) == 0 {
+		return
+	}
+
+	log.Printf("Task %d on %s accepted but not applied: %d of %d values differ", task.ID, device.SerialNumber, len(mismatches), len(writes))
+	details, _ := json.Marshal(mismatches)
+	s.DB.CreateLog(&device.ID, "warning", "task",
+		fmt.Sprintf("Task %d accepted but not applied: %d of %d values differ on read-back", task.ID, len(mismatches), len(writes)),
+		string(details))
+	if s.WSHub != nil {
+		s.WSHub.Broadcast(websocket.Message{
+			Type:     "task_verification",
+			DeviceID: device.ID,
+			Data: map[string]interface{}{
+				"taskId":       task.ID,
+				"verification": verification,
+				"mismatches":   mismatches,
+			},
+		})
 	}
 }
 
-func (s *Server) handleRebootResponse(envelope *SOAPEnvelope, _ *http.Request) {
+func (s *Server) handleRebootResponse(envelope *SOAPEnvelope, r *http.Request) {
 	log.Println("RebootResponse received")
-	if envelope.Header != nil && strings.HasPrefix(envelope.Header.ID, "task-") {
-		taskIDStr := strings.TrimPrefix(envelope.Header.ID, "task-")
-		if taskID, err := strconv.ParseInt(taskIDStr, 10, 64); err == nil {
-			now := time.Now()
-			task := &models.DeviceTask{
-				ID:          taskID,
-				Status:      models.TaskCompleted,
-				CompletedAt: &now,
-			}
-			s.DB.UpdateTask(task)
+	if taskID, ok := s.sessionTaskID(envelope, r); ok {
+		now := time.Now()
+		task := &models.DeviceTask{
+			ID:          taskID,
+			Status:      models.TaskCompleted,
+			CompletedAt: &now,
 		}
+		s.DB.UpdateTask(task)
 	}
 }
 
-func (s *Server) handleFactoryResetResponse(envelope *SOAPEnvelope, _ *http.Request) {
+func (s *Server) handleFactoryResetResponse(envelope *SOAPEnvelope, r *http.Request) {
 	log.Println("FactoryResetResponse received")
-	if envelope.Header != nil && strings.HasPrefix(envelope.Header.ID, "task-") {
-		taskIDStr := strings.TrimPrefix(envelope.Header.ID, "task-")
-		if taskID, err := strconv.ParseInt(taskIDStr, 10, 64); err == nil {
-			now := time.Now()
-			task := &models.DeviceTask{
-				ID:          taskID,
-				Status:      models.TaskCompleted,
-				CompletedAt: &now,
-			}
-			s.DB.UpdateTask(task)
+	if taskID, ok := s.sessionTaskID(envelope, r); ok {
+		now := time.Now()
+		task := &models.DeviceTask{
+			ID:          taskID,
+			Status:      models.TaskCompleted,
+			CompletedAt: &now,
+		}
+		s.DB.UpdateTask(task)
+	}
+}
+
+// handleAddObjectResponse completes an addObject task and queues what it
+// prepared for the new instance: the next object to add beneath it, or the
+// values to write. Follow-ups run ahead of other pending tasks.
+func (s *Server) handleAddObjectResponse(envelope *SOAPEnvelope, r *http.Request) {
+	log.Println("AddObjectResponse received")
+	taskID, ok := s.sessionTaskID(envelope, r)
+	if !ok {
+		return
+	}
+	var resp struct {
+		InstanceNumber int `xml:"InstanceNumber"`
+	}
+	if err := xml.Unmarshal(envelope.Body.InnerXML, &resp); err != nil || resp.InstanceNumber <= 0 {
+		s.DB.UpdateTaskStatus(taskID, models.TaskFailed, nil, "AddObjectResponse without an instance number")
+		return
+	}
+	task, err := s.DB.GetTask(taskID)
+	if err != nil {
+		return
+	}
+	var req models.AddObjectRequest
+	json.Unmarshal(task.Parameters, &req)
+	base := fmt.Sprintf("%s%d.", req.ObjectName, resp.InstanceNumber)
+
+	now := time.Now()
+	result, _ := json.Marshal(map[string]interface{}{"instance": base})
+	s.DB.UpdateTask(&models.DeviceTask{ID: taskID, Status: models.TaskCompleted, CompletedAt: &now, Result: result})
+	s.DB.CreateLog(&task.DeviceID, "info", "task", fmt.Sprintf("Created %s", base), "")
+
+	next := &models.DeviceTask{DeviceID: task.DeviceID, Priority: models.TaskPriorityHigh}
+	switch {
+	case req.Then != nil:
+		child := *req.Then
+		child.ObjectName = base + child.ObjectName
+		next.Type = models.TaskAddObject
+		next.Parameters, _ = json.Marshal(child)
+	case len(req.Values) > 0:
+		values := make(map[string]string, len(req.Values))
+		for path, value := range req.Values {
+			values[base+path] = value
+		}
+		next.Type = models.TaskSetParameterValues
+		next.Parameters, _ = json.Marshal(values)
+	default:
+		return
+	}
+	if _, err := s.DB.CreateTask(next); err != nil {
+		log.Printf("Failed to queue follow-up of task %d: %v", taskID, err)
+	}
+}
+
+// connectionRequestURL normalizes the connection-request URL reported by the
+// CPE and applies the configured scheme/port overrides
+func (s *Server) connectionRequestURL(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", fmt.Errorf("empty connection request URL")
+	}
+	// Some CPEs report "host:port/path" without a scheme
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid connection request URL %q: %v", raw, err)
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Hostname() == "" {
+		return "", fmt.Errorf("connection request URL %q has no host", raw)
+	}
+
+	if s.Config != nil {
+		if scheme := strings.ToLower(s.Config.ConnReqScheme); scheme != "" {
+			u.Scheme = scheme
+		}
+		if s.Config.ConnReqPort > 0 {
+			u.Host = net.JoinHostPort(u.Hostname(), strconv.Itoa(s.Config.ConnReqPort))
 		}
 	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("unsupported connection request scheme %q", u.Scheme)
+	}
+	return u.String(), nil
+}
+
+// connectionRequestClient returns the HTTP client used for connection requests.
+// CPEs almost always present self-signed certificates, so verification is opt-in.
+func (s *Server) connectionRequestClient() *http.Client {
+	verify := s.Config != nil && s.Config.ConnReqTLSVerify
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			Proxy:             http.ProxyFromEnvironment,
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: !verify},
+			DisableKeepAlives: true,
+		},
+	}
 }
 
 // SendConnectionRequest sends a connection request to a CPE
@@ -636,24 +1125,28 @@ func (s *Server) SendConnectionRequest(device *models.Device) error {
 		return fmt.Errorf("no connection request URL for device %s", device.SerialNumber)
 	}
 
+	target, err := s.connectionRequestURL(device.ConnectionRequest)
+	if err != nil {
+		return fmt.Errorf("device %s: %v", device.SerialNumber, err)
+	}
+
 	// Make the connection request
-	client := &http.Client{Timeout: 10 * time.Second}
-	req, err := http.NewRequest("GET", device.ConnectionRequest, nil)
+	req, err := http.NewRequest("GET", target, nil)
 	if err != nil {
 		return err
 	}
 
-	resp, err := client.Do(req)
+	resp, err := s.connectionRequestClient().Do(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
 		return fmt.Errorf("connection request failed with status: %d", resp.StatusCode)
 	}
 
-	log.Printf("Connection request sent to device %s", device.SerialNumber)
+	log.Printf("Connection request sent to device %s (%s)", device.SerialNumber, target)
 	return nil
 }
 
@@ -867,6 +1360,75 @@ func decodeSerialNumber(sn string) string {
 	return string(decoded) + sn[8:]
 }
 
+// applyPresets queues the parameter values of the presets matching the device
+// as one SetParameterValues task. Values already stored in device_parameters
+// are skipped, so a preset only re-applies when the device has drifted.
+func (s *Server) applyPresets(device *models.Device, eventCodes []string) {
+	presets, err := s.DB.GetMatchingPresets(device)
+	if err != nil {
+		log.Printf("Failed to load presets for %s: %v", device.SerialNumber, err)
+		return
+	}
+	triggered := make([]*models.Preset, 0, len(presets))
+	names := make([]string, 0, len(presets))
+	for _, p := range presets {
+		if p.TriggeredBy(eventCodes) {
+			triggered = append(triggered, p)
+			names = append(names, p.Name)
+		}
+	}
+	if len(triggered) == 0 {
+		return
+	}
+
+	desired := models.MergePresetParameters(triggered)
+	if len(desired) == 0 {
+		return
+	}
+	current, err := s.DB.GetDeviceParameters(device.ID, "")
+	if err != nil {
+		log.Printf("Failed to load parameters for %s: %v", device.SerialNumber, err)
+		return
+	}
+	for _, p := range current {
+		if v, ok := desired[p.Path]; ok && !models.IsSecretParameter(p.Path) && models.SameParameterValue(v, p.Value) {
+			delete(desired, p.Path)
+		}
+	}
+	// Devices report secrets back empty, so compare them with what was last
+	// written instead
+	written, err := s.DB.GetWrittenParameterValues(device.ID)
+	if err != nil {
+		log.Printf("Failed to load written values for %s: %v", device.SerialNumber, err)
+		return
+	}
+	for path, v := range desired {
+		if w, ok := written[path]; ok && models.IsSecretParameter(path) && w == v {
+			delete(desired, path)
+		}
+	}
+	if len(desired) == 0 {
+		return
+	}
+
+	payload, _ := json.Marshal(desired)
+	if s.DB.HasPendingTask(device.ID, models.TaskSetParameterValues, payload) {
+		return
+	}
+	if _, err := s.DB.CreateTask(&models.DeviceTask{
+		DeviceID:   device.ID,
+		Type:       models.TaskSetParameterValues,
+		Status:     models.TaskPending,
+		Parameters: payload,
+	}); err != nil {
+		log.Printf("Failed to queue presets for %s: %v", device.SerialNumber, err)
+		return
+	}
+	log.Printf("Presets: Queued %d parameter(s) for %s from %s", len(desired), device.SerialNumber, strings.Join(names, ", "))
+	s.DB.CreateLog(&device.ID, "info", "provision",
+		fmt.Sprintf("Presets applied: %s", strings.Join(names, ", ")), string(payload))
+}
+
 // bootstrapDevice implements the logic from the user's Provisioning script
 func (s *Server) bootstrapDevice(device *models.Device) {
 	// 1. Determine if we need to set Remote Access (ACL)
@@ -928,6 +1490,22 @@ func (s *Server) bootstrapDevice(device *models.Device) {
 	// 2. Schedule Parameter Refresh (GetParameterValues)
 	// Use comprehensive parameter system for better ONU data collection
 
+	// A poll profile for this model replaces the generic parameter set below
+	if profiles, err := s.DB.GetPollProfiles(); err == nil {
+		if profile := models.SelectPollProfile(profiles, device.Manufacturer, device.ModelName); profile != nil && len(profile.Paths) > 0 {
+			payloadRefresh, _ := json.Marshal(profile.Paths)
+			s.DB.CreateTask(&models.DeviceTask{
+				DeviceID:   device.ID,
+				Type:       models.TaskGetParameterValues,
+				Status:     models.TaskPending,
+				Parameters: payloadRefresh,
+			})
+			log.Printf("Auto-provisioning: Queued parameter refresh for %s (%s %s) using poll profile %q with %d parameters",
+				device.SerialNumber, device.Manufacturer, device.ModelName, profile.Name, len(profile.Paths))
+			return
+		}
+	}
+
 	// Get standard ONU parameters
 	standardParams := GetStandardONUParameters()
 	commonParams := GetONUCommonParameters()
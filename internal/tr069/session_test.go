@@ -0,0 +1,210 @@
+package tr069
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"go-acs/internal/models"
+)
+
+const testInform = `<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:cwmp="urn:dslforum-org:cwmp-1-0">
+<soap:Header><cwmp:ID soap:mustUnderstand="1">1</cwmp:ID></soap:Header>
+<soap:Body><cwmp:Inform>
+<DeviceId><Manufacturer>ZTE</Manufacturer><OUI>000000</OUI><ProductClass>ONT</ProductClass><SerialNumber>SN100</SerialNumber></DeviceId>
+<Event><EventStruct><EventCode>2 PERIODIC</EventCode><CommandKey></CommandKey></EventStruct></Event>
+<ParameterList></ParameterList>
+</cwmp:Inform></soap:Body></soap:Envelope>`
+
+func gpvResponse(id, name, value string) string {
+	return `<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:cwmp="urn:dslforum-org:cwmp-1-0">
+<soap:Header><cwmp:ID soap:mustUnderstand="1">` + id + `</cwmp:ID></soap:Header>
+<soap:Body><cwmp:GetParameterValuesResponse><ParameterList>
+<ParameterValueStruct><Name>` + name + `</Name><Value>` + value + `</Value></ParameterValueStruct>
+</ParameterList></cwmp:GetParameterValuesResponse></soap:Body></soap:Envelope>`
+}
+
+func post(s *Server, body string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.RemoteAddr = "10.0.0.5:7547"
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	rec := httptest.NewRecorder()
+	s.handleRequest(rec, req)
+	return rec
+}
+
+func informSession(t *testing.T, s *Server) (*models.Device, *http.Cookie) {
+	t.Helper()
+	rec := post(s, testInform)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Inform status = %d", rec.Code)
+	}
+	var cookie *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == sessionCookie {
+			cookie = c
+		}
+	}
+	if cookie == nil {
+		t.Fatal("InformResponse carries no session cookie")
+	}
+	device, err := s.DB.GetDeviceBySerial("SN100")
+	if err != nil {
+		t.Fatalf("GetDeviceBySerial: %v", err)
+	}
+	return device, cookie
+}
+
+func TestSOAPMethodUsesBodyElement(t *testing.T) {
+	envelope, err := parseSOAPEnvelope([]byte(gpvResponse("1", "InternetGatewayDevice.ManagementServer.PeriodicInformInterval", "300")))
+	if err != nil {
+		t.Fatalf("parseSOAPEnvelope: %v", err)
+	}
+	if got := soapMethod(envelope); got != "GetParameterValuesResponse" {
+		t.Errorf("soapMethod = %q, want GetParameterValuesResponse", got)
+	}
+	envelope, _ = parseSOAPEnvelope([]byte(testInform))
+	if got := soapMethod(envelope); got != "Inform" {
+		t.Errorf("soapMethod = %q, want Inform", got)
+	}
+}
+
+func TestResponseWithoutSessionIsRejected(t *testing.T) {
+	s := newTestServer(t)
+	s.Config.TR069Username = "acs"
+	s.Config.TR069Password = "secret"
+
+	// Mentions PeriodicInformInterval but is not an Inform, so no credentials
+	// are asked for; without a session it must not be processed
+	rec := post(s, gpvResponse("1", "InternetGatewayDevice.ManagementServer.PeriodicInformInterval", "300"))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+
+	rec = post(s, testInform)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Inform without credentials: status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestSessionCookieIdentifiesDevice(t *testing.T) {
+	s := newTestServer(t)
+	device, cookie := informSession(t, s)
+
+	rec := post(s, gpvResponse("1", "InternetGatewayDevice.DeviceInfo.SoftwareVersion", "V2.0"), cookie)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	params, err := s.DB.GetDeviceParameters(device.ID, "")
+	if err != nil {
+		t.Fatalf("GetDeviceParameters: %v", err)
+	}
+	found := false
+	for _, p := range params {
+		if p.Path == "InternetGatewayDevice.DeviceInfo.SoftwareVersion" && p.Value == "V2.0" {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("parameter from the session's response was not stored")
+	}
+
+	// A forged cookie from the same address gets nothing
+	rec = post(s, gpvResponse("1", "InternetGatewayDevice.DeviceInfo.SoftwareVersion", "V3.0"), &http.Cookie{Name: sessionCookie, Value: "forged"})
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("forged cookie: status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+}
+
+func TestSessionCannotCompleteOtherDevicesTasks(t *testing.T) {
+	s := newTestServer(t)
+	_, cookie := informSession(t, s)
+
+	other, err := s.DB.CreateDevice(&models.Device{SerialNumber: "SN200", Manufacturer: "ZTE"})
+	if err != nil {
+		t.Fatalf("CreateDevice: %v", err)
+	}
+	task, err := s.DB.CreateTask(&models.DeviceTask{DeviceID: other.ID, Type: models.TaskReboot})
+	if err != nil {
+		t.Fatalf("CreateTask: %v", err)
+	}
+
+	body := `<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:cwmp="urn:dslforum-org:cwmp-1-0">
+<soap:Header><cwmp:ID soap:mustUnderstand="1">task-` + strconv.FormatInt(task.ID, 10) + `</cwmp:ID></soap:Header>
+<soap:Body><cwmp:RebootResponse/></soap:Body></soap:Envelope>`
+	post(s, body, cookie)
+
+	got, err := s.DB.GetTask(task.ID)
+	if err != nil {
+		t.Fatalf("GetTask: %v", err)
+	}
+	if got.Status != models.TaskPending {
+		t.Errorf("other device's task status = %s, want pending", got.Status)
+	}
+}
+
+func addObjectResponse(taskID int64, instance int) string {
+	return `<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:cwmp="urn:dslforum-org:cwmp-1-0">
+<soap:Header><cwmp:ID soap:mustUnderstand="1">task-` + strconv.FormatInt(taskID, 10) + `</cwmp:ID></soap:Header>
+<soap:Body><cwmp:AddObjectResponse><InstanceNumber>` + strconv.Itoa(instance) + `</InstanceNumber><Status>0</Status></cwmp:AddObjectResponse></soap:Body></soap:Envelope>`
+}
+
+// pendingOfType returns a device's pending tasks of one type; the Inform
+// itself may queue others (bootstrap, presets)
+func pendingOfType(t *testing.T, s *Server, deviceID int64, typ models.TaskType) []*models.DeviceTask {
+	t.Helper()
+	var tasks []*models.DeviceTask
+	for _, task := range pendingTasks(t, s, deviceID) {
+		if task.Type == typ {
+			tasks = append(tasks, task)
+		}
+	}
+	return tasks
+}
+
+func TestAddObjectResponseQueuesFollowUp(t *testing.T) {
+	s := newTestServer(t)
+	device, cookie := informSession(t, s)
+
+	base := "InternetGatewayDevice.WANDevice.1.WANConnectionDevice."
+	payload, _ := json.Marshal(models.AddObjectRequest{
+		ObjectName: base,
+		Then:       &models.AddObjectRequest{ObjectName: "WANIPConnection.", Values: map[string]string{"ConnectionType": "IP_Bridged"}},
+	})
+	task, err := s.DB.CreateTask(&models.DeviceTask{DeviceID: device.ID, Type: models.TaskAddObject, Parameters: payload})
+	if err != nil {
+		t.Fatalf("CreateTask: %v", err)
+	}
+
+	post(s, addObjectResponse(task.ID, 4), cookie)
+	tasks := pendingOfType(t, s, device.ID, models.TaskAddObject)
+	if len(tasks) != 1 {
+		t.Fatalf("pending addObject tasks = %d, want 1", len(tasks))
+	}
+	var child models.AddObjectRequest
+	json.Unmarshal(tasks[0].Parameters, &child)
+	if child.ObjectName != base+"4.WANIPConnection." {
+		t.Errorf("child object = %q", child.ObjectName)
+	}
+
+	post(s, addObjectResponse(tasks[0].ID, 1), cookie)
+	if left := pendingOfType(t, s, device.ID, models.TaskAddObject); len(left) != 0 {
+		t.Errorf("pending addObject tasks = %d, want 0", len(left))
+	}
+	found := false
+	for _, task := range pendingOfType(t, s, device.ID, models.TaskSetParameterValues) {
+		var values map[string]string
+		json.Unmarshal(task.Parameters, &values)
+		if values[base+"4.WANIPConnection.1.ConnectionType"] == "IP_Bridged" && len(values) == 1 {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("values were not queued for the created instance")
+	}
+}
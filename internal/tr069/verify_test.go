@@ -0,0 +1,132 @@
+package tr069
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"testing"
+
+	"go-acs/internal/models"
+)
+
+const wlan = "InternetGatewayDevice.LANDevice.1.WLANConfiguration.1."
+
+func spvResponse(taskID int64) string {
+	return `<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:cwmp="urn:dslforum-org:cwmp-1-0">
+<soap:Header><cwmp:ID soap:mustUnderstand="1">task-` + strconv.FormatInt(taskID, 10) + `</cwmp:ID></soap:Header>
+<soap:Body><cwmp:SetParameterValuesResponse><Status>0</Status></cwmp:SetParameterValuesResponse></soap:Body></soap:Envelope>`
+}
+
+// gpvResponseOf answers a read-back task with the given values
+func gpvResponseOf(taskID int64, values map[string]string) string {
+	var list string
+	for name, value := range values {
+		list += `<ParameterValueStruct><Name>` + name + `</Name><Value>` + value + `</Value></ParameterValueStruct>`
+	}
+	return `<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:cwmp="urn:dslforum-org:cwmp-1-0">
+<soap:Header><cwmp:ID soap:mustUnderstand="1">task-` + strconv.FormatInt(taskID, 10) + `</cwmp:ID></soap:Header>
+<soap:Body><cwmp:GetParameterValuesResponse><ParameterList>` + list + `</ParameterList></cwmp:GetParameterValuesResponse></soap:Body></soap:Envelope>`
+}
+
+// writeAndReadBack runs a SetParameterValues task through the session and
+// returns the read-back it queued
+func writeAndReadBack(t *testing.T, s *Server, deviceID int64, post func(string), values map[string]interface{}) (*models.DeviceTask, *models.DeviceTask) {
+	t.Helper()
+	params, _ := json.Marshal(values)
+	task, err := s.DB.CreateTask(&models.DeviceTask{DeviceID: deviceID, Type: models.TaskSetParameterValues, Parameters: params})
+	if err != nil {
+		t.Fatalf("CreateTask: %v", err)
+	}
+	post(spvResponse(task.ID))
+
+	if task, err = s.DB.GetTask(task.ID); err != nil || task.Status != models.TaskCompleted || task.Verification != models.VerificationPending {
+		t.Fatalf("written task = %+v (err %v), want completed with a pending verification", task, err)
+	}
+	var readBackID int64
+	s.DB.QueryRow(`SELECT verify_task_id FROM tasks WHERE id = ?`, task.ID).Scan(&readBackID)
+	readBack, err := s.DB.GetTask(readBackID)
+	if err != nil || readBack.Type != models.TaskGetParameterValues || readBack.Status != models.TaskPending {
+		t.Fatalf("read-back = %+v (err %v), want a pending GetParameterValues", readBack, err)
+	}
+	return task, readBack
+}
+
+func TestReadBackConfirmsAppliedValues(t *testing.T) {
+	s := newTestServer(t)
+	device, cookie := informSession(t, s)
+	send := func(body string) { post(s, body, cookie) }
+
+	task, readBack := writeAndReadBack(t, s, device.ID, send, map[string]interface{}{
+		wlan + "SSID":          "Home",
+		wlan + "Enable":        true,
+		wlan + "MaxBitRate":    1000000,
+		wlan + "KeyPassphrase": "s3cret",
+	})
+
+	// Only non-secret paths are read back
+	var paths []string
+	json.Unmarshal(readBack.Parameters, &paths)
+	sort.Strings(paths)
+	if want := []string{wlan + "Enable", wlan + "MaxBitRate", wlan + "SSID"}; len(paths) != 3 || paths[0] != want[0] || paths[1] != want[1] || paths[2] != want[2] {
+		t.Errorf("read-back paths = %v, want %v", paths, want)
+	}
+
+	send(gpvResponseOf(readBack.ID, map[string]string{wlan + "SSID": "Home", wlan + "Enable": "1", wlan + "MaxBitRate": "1000000"}))
+	got, _ := s.DB.GetTask(task.ID)
+	if got.Verification != models.VerificationApplied {
+		t.Errorf("verification = %q, want %q", got.Verification, models.VerificationApplied)
+	}
+}
+
+func TestReadBackFlagsDrift(t *testing.T) {
+	s := newTestServer(t)
+	device, cookie := informSession(t, s)
+	send := func(body string) { post(s, body, cookie) }
+
+	task, readBack := writeAndReadBack(t, s, device.ID, send, map[string]interface{}{
+		wlan + "SSID":    "Home",
+		wlan + "Channel": "6",
+		wlan + "Enable":  "1",
+	})
+	// The device accepted the write but kept its old SSID and doesn't report the channel
+	send(gpvResponseOf(readBack.ID, map[string]string{wlan + "SSID": "Home-old", wlan + "Enable": "true"}))
+
+	got, _ := s.DB.GetTask(task.ID)
+	if got.Verification != models.VerificationNotApplied {
+		t.Fatalf("verification = %q, want %q", got.Verification, models.VerificationNotApplied)
+	}
+	var result struct {
+		Mismatches []models.ParameterMismatch `json:"mismatches"`
+	}
+	json.Unmarshal(got.Result, &result)
+	want := []models.ParameterMismatch{
+		{Path: wlan + "Channel", Expected: "6", Missing: true},
+		{Path: wlan + "SSID", Expected: "Home", Actual: "Home-old"},
+	}
+	if len(result.Mismatches) != 2 || result.Mismatches[0] != want[0] || result.Mismatches[1] != want[1] {
+		t.Errorf("mismatches = %+v, want %+v", result.Mismatches, want)
+	}
+
+	var logged int
+	s.DB.QueryRow(`SELECT COUNT(*) FROM logs WHERE device_id = ? AND level = 'warning' AND message LIKE '%accepted but not applied: 2 of 3 values differ%'`,
+		device.ID).Scan(&logged)
+	if logged != 1 {
+		t.Errorf("%d drift warnings logged, want 1", logged)
+	}
+}
+
+func TestFailedReadBackLeavesTaskUnverified(t *testing.T) {
+	s := newTestServer(t)
+	device, cookie := informSession(t, s)
+	send := func(body string) { post(s, body, cookie) }
+
+	task, readBack := writeAndReadBack(t, s, device.ID, send, map[string]interface{}{wlan + "SSID": "Home"})
+	send(`<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:cwmp="urn:dslforum-org:cwmp-1-0">
+<soap:Header><cwmp:ID soap:mustUnderstand="1">task-` + strconv.FormatInt(readBack.ID, 10) + `</cwmp:ID></soap:Header>
+<soap:Body><soap:Fault><faultcode>Client</faultcode><faultstring>CWMP fault</faultstring><detail><cwmp:Fault><FaultCode>9005</FaultCode><FaultString>Invalid parameter name</FaultString></cwmp:Fault></detail></soap:Fault></soap:Body></soap:Envelope>`)
+
+	got, _ := s.DB.GetTask(task.ID)
+	if got.Verification != models.VerificationUnverified {
+		t.Errorf("verification = %q, want %q", got.Verification, models.VerificationUnverified)
+	}
+}
@@ -0,0 +1,69 @@
+package tr069
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"go-acs/internal/models"
+)
+
+const (
+	wanIPPath    = "InternetGatewayDevice.WANDevice.1.WANConnectionDevice.1.WANPPPConnection.1.ExternalIPAddress"
+	firmwarePath = "InternetGatewayDevice.DeviceInfo.SoftwareVersion"
+)
+
+// informWithWAN is testInform reporting the given WAN IP and firmware version
+func informWithWAN(ip, firmware string) string {
+	return strings.Replace(testInform, "<ParameterList></ParameterList>",
+		`<ParameterList><ParameterValueStruct><Name>`+wanIPPath+`</Name><Value>`+ip+`</Value></ParameterValueStruct>`+
+			`<ParameterValueStruct><Name>`+firmwarePath+`</Name><Value>`+firmware+`</Value></ParameterValueStruct></ParameterList>`, 1)
+}
+
+func TestWatchedParameterChangeNotifies(t *testing.T) {
+	s := newTestServer(t)
+	changes := make(chan *models.ParameterChange, 4)
+	s.OnWatchedParameterChange = func(_ *models.Device, watch *models.ParameterWatch, change *models.ParameterChange) {
+		if watch.Path != change.Path {
+			t.Errorf("watch %s notified for %s", watch.Path, change.Path)
+		}
+		changes <- change
+	}
+	expectNone := func(when string) {
+		t.Helper()
+		select {
+		case c := <-changes:
+			t.Errorf("%s: unexpected notification for %s", when, c.Path)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	device, _ := informSession(t, s)
+	if _, err := s.DB.CreateParameterWatch(&models.ParameterWatch{DeviceID: device.ID, Path: wanIPPath, Label: "WAN IP"}); err != nil {
+		t.Fatalf("CreateParameterWatch: %v", err)
+	}
+
+	// The first report of a value is not a change
+	post(s, informWithWAN("100.64.0.10", "V1.0"))
+	expectNone("first report")
+
+	// Only the watched WAN IP notifies; the firmware change is just history
+	post(s, informWithWAN("100.64.0.99", "V2.0"))
+	select {
+	case c := <-changes:
+		if c.Path != wanIPPath || c.OldValue != "100.64.0.10" || c.NewValue != "100.64.0.99" {
+			t.Errorf("change = %+v, want the WAN IP from 100.64.0.10 to 100.64.0.99", c)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("watched WAN IP change was not notified")
+	}
+	expectNone("unwatched firmware change")
+
+	history, err := s.DB.GetParameterHistory(device.ID, firmwarePath, 10)
+	if err != nil || len(history) != 1 {
+		t.Errorf("firmware history = %d entries (err %v), want 1", len(history), err)
+	}
+
+	post(s, informWithWAN("100.64.0.99", "V2.0"))
+	expectNone("unchanged values")
+}
@@ -4,12 +4,27 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+const (
+	// writeWait is the time allowed to write a message to the client
+	writeWait = 10 * time.Second
+	// defaultPongWait is how long a client may stay silent (no pong or
+	// message) before it is considered dead and evicted
+	defaultPongWait = 60 * time.Second
+	// maxMessageSize is the largest message accepted from a client
+	maxMessageSize = 512 * 1024
+	// broadcastBuffer absorbs bursts (e.g. many devices coming back online
+	// after an outage) so Broadcast never blocks the inform handler
+	broadcastBuffer = 256
+)
+
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
@@ -20,9 +35,29 @@ var upgrader = websocket.Upgrader{
 
 // Message represents a WebSocket message
 type Message struct {
-	Type     string      `json:"type"`
-	DeviceID int64       `json:"deviceId,omitempty"`
-	Data     interface{} `json:"data,omitempty"`
+	Type        string      `json:"type"`
+	DeviceID    int64       `json:"deviceId,omitempty"`
+	DeviceAlias string      `json:"deviceAlias,omitempty"` // Filled by Hub.ResolveAlias when empty
+	Data        interface{} `json:"data,omitempty"`
+}
+
+// clientMessage is a message sent by the browser. Subscriptions use
+// {"action": "subscribe", "deviceId": 123} for one device's events or
+// {"action": "subscribe", "topic": "device_status"} for one event type
+// (the dashboard's aggregate counts); "unsubscribe" without a deviceId or
+// topic clears all subscriptions.
+type clientMessage struct {
+	Type     string `json:"type"`
+	Action   string `json:"action"`
+	DeviceID int64  `json:"deviceId"`
+	Topic    string `json:"topic"`
+}
+
+// envelope is a marshaled Message with the fields subscriptions match on
+type envelope struct {
+	deviceID int64
+	msgType  string
+	data     []byte
 }
 
 // Client represents a WebSocket client
@@ -30,29 +65,120 @@ type Client struct {
 	hub  *Hub
 	conn *websocket.Conn
 	send chan []byte
+
+	// lastSeen is the UnixNano time of the last pong or message
+	lastSeen int64
+
+	// Subscriptions; a client without any receives every message
+	subMu   sync.RWMutex
+	devices map[int64]bool
+	topics  map[string]bool
+}
+
+// wants reports whether a message passes the client's subscriptions
+func (c *Client) wants(e envelope) bool {
+	c.subMu.RLock()
+	defer c.subMu.RUnlock()
+	if len(c.devices) == 0 && len(c.topics) == 0 {
+		return true
+	}
+	return (e.deviceID != 0 && c.devices[e.deviceID]) || c.topics[e.msgType]
+}
+
+// subscribe adds (or with add false removes) a device or topic subscription
+func (c *Client) subscribe(msg clientMessage, add bool) {
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+	if c.devices == nil {
+		c.devices = make(map[int64]bool)
+		c.topics = make(map[string]bool)
+	}
+	if !add && msg.DeviceID == 0 && msg.Topic == "" {
+		c.devices = make(map[int64]bool)
+		c.topics = make(map[string]bool)
+		return
+	}
+	if msg.DeviceID != 0 {
+		if add {
+			c.devices[msg.DeviceID] = true
+		} else {
+			delete(c.devices, msg.DeviceID)
+		}
+	}
+	if msg.Topic != "" {
+		if add {
+			c.topics[msg.Topic] = true
+		} else {
+			delete(c.topics, msg.Topic)
+		}
+	}
+}
+
+// subscriptions lists the client's current device and topic subscriptions
+func (c *Client) subscriptions() map[string]interface{} {
+	c.subMu.RLock()
+	defer c.subMu.RUnlock()
+	devices := make([]int64, 0, len(c.devices))
+	for id := range c.devices {
+		devices = append(devices, id)
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i] < devices[j] })
+	topics := make([]string, 0, len(c.topics))
+	for t := range c.topics {
+		topics = append(topics, t)
+	}
+	sort.Strings(topics)
+	return map[string]interface{}{"deviceIds": devices, "topics": topics}
+}
+
+// touch records that the client is alive
+func (c *Client) touch() {
+	atomic.StoreInt64(&c.lastSeen, time.Now().UnixNano())
+}
+
+// stale reports whether the client has been silent longer than pongWait
+func (c *Client) stale(now time.Time, pongWait time.Duration) bool {
+	return now.Sub(time.Unix(0, atomic.LoadInt64(&c.lastSeen))) > pongWait
 }
 
 // Hub maintains the set of active clients and broadcasts messages
 type Hub struct {
 	clients    map[*Client]bool
-	broadcast  chan []byte
+	broadcast  chan envelope
 	register   chan *Client
 	unregister chan *Client
 	mu         sync.RWMutex
+
+	// pongWait is how long a client may stay silent before it is evicted
+	pongWait time.Duration
+
+	// ResolveAlias, when set, returns a friendly name for a device ID so the
+	// live feed shows more than numeric IDs
+	ResolveAlias func(deviceID int64) string
 }
 
 // NewHub creates a new Hub
 func NewHub() *Hub {
 	return &Hub{
 		clients:    make(map[*Client]bool),
-		broadcast:  make(chan []byte),
+		broadcast:  make(chan envelope, broadcastBuffer),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
+		pongWait:   defaultPongWait,
 	}
 }
 
+// pingPeriod must be shorter than pongWait so a live client always answers
+// in time
+func (h *Hub) pingPeriod() time.Duration {
+	return (h.pongWait * 9) / 10
+}
+
 // Run starts the hub's main loop
 func (h *Hub) Run() {
+	sweep := time.NewTicker(h.pingPeriod())
+	defer sweep.Stop()
+
 	for {
 		select {
 		case client := <-h.register:
@@ -63,30 +189,69 @@ func (h *Hub) Run() {
 
 		case client := <-h.unregister:
 			h.mu.Lock()
-			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.send)
+			if h.remove(client) {
+				log.Printf("WebSocket client disconnected. Total clients: %d", len(h.clients))
 			}
 			h.mu.Unlock()
-			log.Printf("WebSocket client disconnected. Total clients: %d", len(h.clients))
 
 		case message := <-h.broadcast:
-			h.mu.RLock()
+			h.mu.Lock()
 			for client := range h.clients {
+				if !client.wants(message) {
+					continue
+				}
 				select {
-				case client.send <- message:
+				case client.send <- message.data:
 				default:
-					close(client.send)
-					delete(h.clients, client)
+					// Too slow to keep up; drop it rather than block everyone
+					h.remove(client)
 				}
 			}
-			h.mu.RUnlock()
+			h.mu.Unlock()
+
+		case now := <-sweep.C:
+			h.evictStale(now)
 		}
 	}
 }
 
+// remove drops a client and closes its send channel, which makes its
+// writePump close the connection. The caller must hold h.mu.
+func (h *Hub) remove(client *Client) bool {
+	if _, ok := h.clients[client]; !ok {
+		return false
+	}
+	delete(h.clients, client)
+	close(client.send)
+	return true
+}
+
+// evictStale removes clients that stopped answering pings, so half-open
+// connections (lost Wi-Fi, sleeping laptops) don't inflate ClientCount. The
+// read deadline normally catches these first; this is the backstop for
+// connections whose reader is stuck.
+func (h *Hub) evictStale(now time.Time) {
+	h.mu.Lock()
+	evicted := 0
+	for client := range h.clients {
+		if client.stale(now, h.pongWait) {
+			h.remove(client)
+			client.conn.Close()
+			evicted++
+		}
+	}
+	h.mu.Unlock()
+	if evicted > 0 {
+		log.Printf("Evicted %d unresponsive WebSocket clients. Total clients: %d", evicted, h.ClientCount())
+	}
+}
+
 // Broadcast sends a message to all connected clients
 func (h *Hub) Broadcast(msg Message) {
+	if msg.DeviceID != 0 && msg.DeviceAlias == "" && h.ResolveAlias != nil {
+		msg.DeviceAlias = h.ResolveAlias(msg.DeviceID)
+	}
+
 	data, err := json.Marshal(msg)
 	if err != nil {
 		log.Printf("Error marshaling WebSocket message: %v", err)
@@ -94,7 +259,7 @@ func (h *Hub) Broadcast(msg Message) {
 	}
 
 	select {
-	case h.broadcast <- data:
+	case h.broadcast <- envelope{deviceID: msg.DeviceID, msgType: msg.Type, data: data}:
 	default:
 		log.Println("WebSocket broadcast channel full, dropping message")
 	}
@@ -120,6 +285,7 @@ func HandleWebSocket(hub *Hub, w http.ResponseWriter, r *http.Request) {
 		conn: conn,
 		send: make(chan []byte, 256),
 	}
+	client.touch()
 
 	client.hub.register <- client
 
@@ -135,10 +301,12 @@ func (c *Client) readPump() {
 		c.conn.Close()
 	}()
 
-	c.conn.SetReadLimit(512 * 1024) // 512KB max message size
-	c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+	pongWait := c.hub.pongWait
+	c.conn.SetReadLimit(maxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
 	c.conn.SetPongHandler(func(string) error {
-		c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+		c.touch()
+		c.conn.SetReadDeadline(time.Now().Add(pongWait))
 		return nil
 	})
 
@@ -150,9 +318,11 @@ func (c *Client) readPump() {
 			}
 			break
 		}
+		c.touch()
+		c.conn.SetReadDeadline(time.Now().Add(pongWait))
 
 		// Handle incoming messages from client
-		var msg Message
+		var msg clientMessage
 		if err := json.Unmarshal(message, &msg); err == nil {
 			c.handleMessage(msg)
 		}
@@ -161,7 +331,7 @@ func (c *Client) readPump() {
 
 // writePump pumps messages from the hub to the WebSocket connection
 func (c *Client) writePump() {
-	ticker := time.NewTicker(30 * time.Second)
+	ticker := time.NewTicker(c.hub.pingPeriod())
 	defer func() {
 		ticker.Stop()
 		c.conn.Close()
@@ -170,31 +340,20 @@ func (c *Client) writePump() {
 	for {
 		select {
 		case message, ok := <-c.send:
-			c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if !ok {
 				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
 				return
 			}
 
-			w, err := c.conn.NextWriter(websocket.TextMessage)
-			if err != nil {
-				return
-			}
-			w.Write(message)
-
-			// Add queued messages to the current WebSocket message
-			n := len(c.send)
-			for i := 0; i < n; i++ {
-				w.Write([]byte{'\n'})
-				w.Write(<-c.send)
-			}
-
-			if err := w.Close(); err != nil {
+			// One JSON document per frame; the pages JSON.parse each frame,
+			// so queued messages can't be joined into one
+			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
 				return
 			}
 
 		case <-ticker.C:
-			c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
 				return
 			}
@@ -203,19 +362,36 @@ func (c *Client) writePump() {
 }
 
 // handleMessage handles incoming WebSocket messages
-func (c *Client) handleMessage(msg Message) {
-	switch msg.Type {
+func (c *Client) handleMessage(msg clientMessage) {
+	action := msg.Action
+	if action == "" {
+		action = msg.Type
+	}
+	switch action {
 	case "ping":
 		// Respond with pong
-		response := Message{Type: "pong"}
-		data, _ := json.Marshal(response)
-		c.send <- data
+		c.reply(Message{Type: "pong"})
 
-	case "subscribe":
-		// Handle subscription requests
-		log.Printf("Client subscribed to: %v", msg.Data)
+	case "subscribe", "unsubscribe":
+		c.subscribe(msg, action == "subscribe")
+		c.reply(Message{Type: "subscriptions", Data: c.subscriptions()})
 
 	default:
-		log.Printf("Unknown message type: %s", msg.Type)
+		log.Printf("Unknown message type: %s", action)
+	}
+}
+
+// reply queues a message for this client only. It is dropped if the client's
+// buffer is full or the hub already removed it (which closes send).
+func (c *Client) reply(msg Message) {
+	data, _ := json.Marshal(msg)
+	c.hub.mu.RLock()
+	defer c.hub.mu.RUnlock()
+	if !c.hub.clients[c] {
+		return
+	}
+	select {
+	case c.send <- data:
+	default:
 	}
 }
@@ -0,0 +1,130 @@
+package websocket
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// dialHub starts a running hub behind a test server and connects a client
+func dialHub(t *testing.T, hub *Hub) *websocket.Conn {
+	t.Helper()
+	go hub.Run()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		HandleWebSocket(hub, w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	deadline := time.Now().Add(time.Second)
+	for hub.ClientCount() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("client never registered")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return conn
+}
+
+// readMessage reads the next frame from the hub
+func readMessage(t *testing.T, conn *websocket.Conn) Message {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	var msg Message
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatalf("ReadJSON: %v", err)
+	}
+	return msg
+}
+
+func TestBroadcastIncludesDeviceAlias(t *testing.T) {
+	hub := NewHub()
+	var resolved []int64
+	hub.ResolveAlias = func(deviceID int64) string {
+		resolved = append(resolved, deviceID)
+		return "Budi Santoso (ZTEG1234)"
+	}
+	conn := dialHub(t, hub)
+
+	hub.Broadcast(Message{Type: "device_status", DeviceID: 7, Data: "online"})
+	msg := readMessage(t, conn)
+	if msg.DeviceID != 7 || msg.DeviceAlias != "Budi Santoso (ZTEG1234)" {
+		t.Errorf("message = %+v, want the resolved alias for device 7", msg)
+	}
+
+	// An alias set by the caller is kept, and device-less messages are not resolved
+	hub.Broadcast(Message{Type: "device_status", DeviceID: 8, DeviceAlias: "given"})
+	if msg := readMessage(t, conn); msg.DeviceAlias != "given" {
+		t.Errorf("alias = %q, want the caller's alias", msg.DeviceAlias)
+	}
+	hub.Broadcast(Message{Type: "stats"})
+	if msg := readMessage(t, conn); msg.DeviceAlias != "" {
+		t.Errorf("alias = %q for a message without a device", msg.DeviceAlias)
+	}
+	if len(resolved) != 1 || resolved[0] != 7 {
+		t.Errorf("resolved %v, want only device 7", resolved)
+	}
+}
+
+func TestBroadcastWithoutResolverOmitsAlias(t *testing.T) {
+	hub := NewHub()
+	conn := dialHub(t, hub)
+
+	hub.Broadcast(Message{Type: "device_status", DeviceID: 7})
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	var raw map[string]interface{}
+	json.Unmarshal(data, &raw)
+	if _, ok := raw["deviceAlias"]; ok {
+		t.Errorf("payload %s has a deviceAlias without a resolver", data)
+	}
+}
+
+func TestEvictStaleRemovesSilentClients(t *testing.T) {
+	hub := NewHub()
+	conn := dialHub(t, hub)
+
+	// A client heard from within pongWait stays
+	hub.evictStale(time.Now())
+	if n := hub.ClientCount(); n != 1 {
+		t.Fatalf("%d clients after a sweep, want the live one kept", n)
+	}
+
+	// One that has not answered for longer is evicted and disconnected
+	hub.evictStale(time.Now().Add(hub.pongWait + time.Second))
+	if n := hub.ClientCount(); n != 0 {
+		t.Fatalf("%d clients after the sweep, want the silent one evicted", n)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err := conn.ReadMessage(); err == nil {
+		t.Error("evicted client's connection is still open")
+	}
+}
+
+func TestClientThatFailsToPongIsEvicted(t *testing.T) {
+	hub := NewHub()
+	hub.pongWait = 200 * time.Millisecond
+	// The client never reads, so it never answers the hub's pings
+	dialHub(t, hub)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for hub.ClientCount() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("silent client still registered after %s, want it evicted", 2*time.Second)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}